@@ -6,29 +6,109 @@ package main
 // identity). Required for Google Play compliance (apps with account
 // creation must offer in-app deletion) and simply the right thing.
 //
-// Scope: hard-deletes the user's row, social graph edges, engagement
-// history, profile/model state, device tokens, and their CONTENT
-// (challenges cascade to responses/likes/votes/comments via
-// DeleteChallengeByID, which also feeds the search-index removal).
-// Chat messages the user SENT remain (they're the other participant's
-// history too) but are already attributed by numeric id only.
+// ════════════════════════════════════════════════════════════════════════════════
+// THE GRACE PERIOD
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Deletion used to be immediate and final. That is the wrong answer for the
+// two people who most often press the button: someone who did it by mistake
+// (or in a temper), and someone whose account was taken over by whoever did
+// it for them. Neither could get anything back.
+//
+// So deleting is now a state machine, kept in account_deletions
+// (migrations/020_account_deletions.sql):
+//
+//	active ──DELETE──▶ deactivated ──purge_after passes──▶ purged
+//	                        │
+//	                        └──signs in before then──▶ active
+//
+//	deactivated  Every session is signed out. The account and everything it
+//	             posted leave feeds, explore, search and suggestions, its
+//	             profile answers 404, and no pushes are queued for it — the
+//	             same map lookups that hide a suspended account (see
+//	             moderation.go's snapshot). Nothing is deleted yet.
+//	restored     Signing in — password, passkey, Google/Apple — within
+//	             accountDeletionGrace removes the row and the account is
+//	             simply back. completeLogin tells the app so.
+//	purged       After the grace period the purge job below does the hard
+//	             delete, and a sign-in attempt gets 410. account_purge_log
+//	             keeps the fact that it happened, and nothing else.
+//
+// Restore and purge cannot race: restore only touches a row whose
+// purge_after is still in the future, the purge job only claims one whose
+// purge_after has passed, and each is a single statement on that row.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE PURGE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Hard-deletes the user's row, social graph edges, engagement history,
+// profile/model state, device tokens, and their CONTENT (challenges cascade
+// to responses/likes/votes/comments via DeleteChallengeByID, which also
+// feeds the search-index removal). Chat messages go with the users row
+// (chat_messages cascades on both sender and receiver).
 //
 // The videos themselves go too. This file used to say they did not —
 // "decoupled storage cleanup" — but nothing was ever doing the decoupled
 // part, so every deleted account left its videos in the bucket forever.
 // DeleteChallengeByID now queues each one's storage paths and a background
-// worker clears them; see media_delete.go. Nothing extra is needed here,
-// because every video a user owns hangs off one of their challenges.
+// worker clears them; see media_delete.go.
+//
+// DMs cascade away with the users row, sent and received alike, and with
+// them the only record of any photo, clip or voice note in them. So those
+// files are collected first and queued the same way once the delete has
+// committed — see chatMediaPrefixesForUser for which ones.
+//
+// Group chats the user owns are handed on first, as if they had left
+// (leaveGroup): their membership row would otherwise cascade away and leave
+// a group with no owner, which nobody could ever manage again.
+//
+// The job is idempotent: every step deletes what is still there, so a purge
+// that dies half-way is simply run again. A failing one keeps its claim and
+// error and is retried once the claim goes stale; GET /admin/health shows
+// how many are waiting, overdue and failing.
 
 import (
-	"encoding/json"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	// accountDeletionGrace is how long a deleted account can be restored by
+	// signing back in.
+	accountDeletionGrace = 30 * 24 * time.Hour
+	// How often the purge job looks for accounts past their grace period.
+	// Nobody is waiting on the exact hour.
+	accountPurgeInterval = 30 * time.Minute
+	// Purges per tick; each one can be many challenges' worth of deletes.
+	accountPurgeBatch = 20
+	// A claim older than this belonged to a worker that died, or to a purge
+	// that failed; either way the account is tried again.
+	accountPurgeClaimTTL = 2 * time.Hour
+)
+
+var errAccountPurged = errors.New("this account has been deleted")
+
+// accountDeletion is one account_deletions row.
+type accountDeletion struct {
+	UserID      string
+	RequestedAt time.Time
+	PurgeAfter  time.Time
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DEACTIVATE / RESTORE
+// ─────────────────────────────────────────────────────────────────────────────
+
 // DeleteAccountHandler — DELETE /api/v1/users/{id}
+// Deactivates now; the data goes after accountDeletionGrace.
 func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
 	if db == nil {
 		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
@@ -41,66 +121,310 @@ func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	d, err := deactivateAccount(userID)
+	if err != nil {
+		log.Printf("account delete %s failed: %v", userID, err)
+		http.Error(w, "delete failed", http.StatusInternalServerError)
+		return
+	}
+	// Out everywhere, this device included. Whoever comes back through the
+	// sign-in screen is who restores it.
+	if _, err := revokeAllSessions(userID, "", "account_deleted"); err != nil {
+		log.Printf("account delete %s: signing out: %v", userID, err)
+	}
+	moderationMarkDeactivated(userID, true)
+
+	log.Printf("Account deactivated: user %s, purge after %s", userID, d.PurgeAfter.UTC().Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deleted":    true,
+		"restorable": true,
+		"purgeAfter": d.PurgeAfter,
+	})
+}
+
+// deactivateAccount starts the grace period. Deleting an account that is
+// already deactivated keeps its original purge date — asking twice must not
+// push the real deletion further away.
+func deactivateAccount(userID string) (accountDeletion, error) {
+	d := accountDeletion{UserID: userID}
+	err := db.QueryRow(`
+		INSERT INTO account_deletions (user_id, purge_after)
+		VALUES (CAST($1 AS INT), NOW() + ($2)::interval)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING requested_at, purge_after`,
+		userID, fmt.Sprintf("%d seconds", int(accountDeletionGrace.Seconds()))).
+		Scan(&d.RequestedAt, &d.PurgeAfter)
+	return d, err
+}
+
+// pendingAccountDeletion reads a user's deletion straight from the
+// database, for sign-in, which must not trust a snapshot.
+func pendingAccountDeletion(userID string) (accountDeletion, bool, error) {
+	d := accountDeletion{UserID: userID}
+	err := db.QueryRow(`
+		SELECT requested_at, purge_after FROM account_deletions WHERE user_id = CAST($1 AS INT)`,
+		userID).Scan(&d.RequestedAt, &d.PurgeAfter)
+	if err == sql.ErrNoRows {
+		return d, false, nil
+	}
+	return d, err == nil, err
+}
+
+// restoreAccount cancels a pending deletion. Returns errAccountPurged when
+// the grace period is over — the account is, or is about to be, gone.
+func restoreAccount(userID string) error {
+	res, err := db.Exec(`
+		DELETE FROM account_deletions
+		WHERE user_id = CAST($1 AS INT) AND purge_after > NOW()`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAccountPurged
+	}
+	moderationMarkDeactivated(userID, false)
+	log.Printf("Account restored: user %s", userID)
+	return nil
+}
+
+// checkAccountRestore is completeLogin's step: a deactivated account that
+// signs in is restored, one past its grace period is refused. restored
+// reports whether this sign-in brought the account back; ok=false means a
+// response has been written.
+func checkAccountRestore(w http.ResponseWriter, userID string) (restored, ok bool) {
+	if db == nil {
+		return false, true
+	}
+	_, pending, err := pendingAccountDeletion(userID)
+	if err != nil {
+		http.Error(w, "Login temporarily unavailable", http.StatusInternalServerError)
+		return false, false
+	}
+	if !pending {
+		return false, true
+	}
+	if err := restoreAccount(userID); err != nil {
+		if err == errAccountPurged {
+			http.Error(w, err.Error(), http.StatusGone)
+		} else {
+			http.Error(w, "Login temporarily unavailable", http.StatusInternalServerError)
+		}
+		return false, false
+	}
+	return true, true
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// PURGE JOB
+// ─────────────────────────────────────────────────────────────────────────────
+
+// AccountPurgeRun is this replica's last purge tick, for /admin/health.
+type AccountPurgeRun struct {
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+	Purged    int       `json:"purged"`
+	Failed    int       `json:"failed"`
+	Err       string    `json:"err,omitempty"`
+}
+
+var accountPurgeLastRun struct {
+	sync.RWMutex
+	run AccountPurgeRun
+}
+
+// startAccountPurger runs the purge job forever. Safe on several instances:
+// accounts are claimed with SKIP LOCKED.
+func startAccountPurger() {
+	go func() {
+		t := time.NewTicker(accountPurgeInterval)
+		defer t.Stop()
+		for range t.C {
+			runAccountPurge()
+		}
+	}()
+}
+
+// runAccountPurge purges up to accountPurgeBatch accounts past their grace
+// period.
+func runAccountPurge() {
+	if db == nil {
+		return
+	}
+	run := AccountPurgeRun{StartedAt: time.Now()}
+	for i := 0; i < accountPurgeBatch; i++ {
+		d, attempts, err := claimAccountPurge()
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			run.Err = "claiming: " + err.Error()
+			break
+		}
+		n, err := purgeAccount(d, attempts)
+		if err != nil {
+			run.Failed++
+			log.Printf("account purge %s (attempt %d) failed: %v", d.UserID, attempts, err)
+			// The claim stays, so the account is retried once it is stale
+			// rather than on every tick.
+			if _, uerr := db.Exec(`UPDATE account_deletions SET last_error = $2 WHERE user_id = CAST($1 AS INT)`,
+				d.UserID, truncateText(err.Error(), 500)); uerr != nil {
+				log.Printf("account purge %s: recording the failure: %v", d.UserID, uerr)
+			}
+			continue
+		}
+		run.Purged++
+		log.Printf("Account purged: user %s (%d challenges)", d.UserID, n)
+	}
+	run.Duration = time.Since(run.StartedAt).String()
+	accountPurgeLastRun.Lock()
+	accountPurgeLastRun.run = run
+	accountPurgeLastRun.Unlock()
+}
+
+// claimAccountPurge takes the most overdue account nobody is working on.
+func claimAccountPurge() (accountDeletion, int, error) {
+	var d accountDeletion
+	var attempts int
+	err := db.QueryRow(`
+		UPDATE account_deletions SET claimed_at = NOW(), attempts = attempts + 1
+		WHERE user_id = (
+			SELECT user_id FROM account_deletions
+			WHERE purge_after <= NOW()
+			  AND (claimed_at IS NULL OR claimed_at < NOW() - ($1)::interval)
+			ORDER BY purge_after LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING user_id::text, requested_at, purge_after, attempts`,
+		fmt.Sprintf("%d seconds", int(accountPurgeClaimTTL.Seconds()))).
+		Scan(&d.UserID, &d.RequestedAt, &d.PurgeAfter, &attempts)
+	return d, attempts, err
+}
+
+// handOverOwnedGroups takes userID out of every group they own the way the
+// leave button does: the longest-standing admin, else member, becomes owner,
+// and a group with nobody left is deleted.
+func handOverOwnedGroups(userID string) error {
+	rows, err := db.Query(`
+		SELECT group_id FROM chat_group_members
+		WHERE user_id = CAST($1 AS INT) AND role = $2`, userID, groupRoleOwner)
+	if err != nil {
+		return fmt.Errorf("listing owned groups: %w", err)
+	}
+	var groups []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			groups = append(groups, id)
+		}
+	}
+	rows.Close()
+	for _, id := range groups {
+		successor, _, err := leaveGroup(id, userID)
+		if errors.Is(err, errGroupNotFound) {
+			continue // left some other way since the list was read
+		}
+		if err != nil {
+			return fmt.Errorf("group %d: %w", id, err)
+		}
+		if successor != "" {
+			go postGroupSystemMessage(id, successor, usernameOf(successor)+" is now the owner")
+		}
+	}
+	return nil
+}
+
+// purgeAccount is the hard delete. Returns how many challenges went with
+// the account.
+func purgeAccount(d accountDeletion, attempts int) (int, error) {
+	userID := d.UserID
+
 	// 1) Content first: reuse the challenge-deletion path so responses,
 	// likes, votes, comments, and the Meilisearch document all go with
-	// each challenge.
-	rows, err := db.Query(`SELECT id FROM challenges WHERE creator_id::text = $1`, userID)
-	if err == nil {
-		ids := []string{}
-		for rows.Next() {
-			var id string
-			if rows.Scan(&id) == nil {
-				ids = append(ids, id)
-			}
+	// each challenge. A challenge that fails to go fails the purge — it
+	// would otherwise cascade away with the users row below and take the
+	// only pointer to its video with it.
+	rows, err := db.Query(`SELECT id FROM challenges WHERE creator_id = CAST($1 AS INT)`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("listing challenges: %w", err)
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
 		}
-		rows.Close()
-		for _, id := range ids {
-			if err := DeleteChallengeByID(id); err != nil {
-				log.Printf("account delete %s: challenge %s cleanup failed: %v", userID, id, err)
-			}
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := DeleteChallengeByID(id); err != nil {
+			return 0, fmt.Errorf("challenge %s: %w", id, err)
 		}
 	}
 
+	if err := handOverOwnedGroups(userID); err != nil {
+		return 0, err
+	}
+
+	// Chat attachments: read now, while the messages still exist; queued
+	// only once the delete below has committed.
+	chatMedia := chatMediaPrefixesForUser(userID)
+
 	// 2) Everything else in one transaction. Order doesn't matter (no
 	// FK chains between these), but the users row goes last so a crash
 	// mid-way leaves a recoverable half-cleaned account rather than an
 	// orphaned login.
 	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "delete failed", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
 	stmts := []string{
-		`DELETE FROM challenge_responses WHERE responder_id::text = $1`,
-		`DELETE FROM follows WHERE follower_id::text = $1 OR following_id::text = $1`,
-		`DELETE FROM user_blocks WHERE blocker_id::text = $1 OR blocked_id::text = $1`,
+		`DELETE FROM challenge_responses WHERE responder_id = CAST($1 AS INT)`,
+		`DELETE FROM follows WHERE follower_id = CAST($1 AS INT) OR following_id = CAST($1 AS INT)`,
+		`DELETE FROM user_blocks WHERE blocker_id = CAST($1 AS INT) OR blocked_id = CAST($1 AS INT)`,
+		// group_messages.sender_id is ON DELETE SET NULL, so the users row
+		// going would leave the text behind with no sender; it goes here.
+		`DELETE FROM group_messages WHERE sender_id = CAST($1 AS INT)`,
+		`DELETE FROM muted_creators WHERE user_id = CAST($1 AS INT) OR creator_id = CAST($1 AS INT)`,
+		`DELETE FROM content_preferences WHERE user_id = CAST($1 AS INT)`,
 		`DELETE FROM device_tokens WHERE user_id = $1`,
 		`DELETE FROM notification_prefs WHERE user_id = $1`,
 		`DELETE FROM notification_outbox WHERE user_id = $1`,
-		`DELETE FROM saved_challenges WHERE user_id::text = $1`,
-		`DELETE FROM challenge_likes WHERE user_id::text = $1`,
-		`DELETE FROM challenge_votes WHERE voter_id::text = $1`,
-		`DELETE FROM watch_events WHERE user_id::text = $1`,
+		`DELETE FROM saved_challenges WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM challenge_likes WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM challenge_votes WHERE voter_id = CAST($1 AS INT)`,
+		`DELETE FROM watch_events WHERE user_id = CAST($1 AS INT)`,
 		`DELETE FROM feed_events WHERE user_id = $1`,
 		`DELETE FROM session_outcomes WHERE user_id = $1`,
 		`DELETE FROM experiment_exposures WHERE user_id = $1`,
-		`DELETE FROM user_similarities WHERE user_id::text = $1 OR similar_user_id::text = $1`,
+		`DELETE FROM ranking_impressions WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM model_version_bandits WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM model_shadow_diffs WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM user_similarities WHERE user_id = $1 OR similar_user_id = $1`,
 		`DELETE FROM user_profiles WHERE user_id = $1`,
-		`DELETE FROM user_totp WHERE user_id::text = $1`,
-		`DELETE FROM users WHERE id::text = $1`,
+		`DELETE FROM user_totp WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM user_passkeys WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM user_identities WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM data_exports WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM account_deletions WHERE user_id = CAST($1 AS INT)`,
+		`DELETE FROM users WHERE id = CAST($1 AS INT)`,
 	}
 	for _, s := range stmts {
 		if _, err := tx.Exec(s, userID); err != nil {
 			_ = tx.Rollback()
-			log.Printf("account delete %s failed at %q: %v", userID, s, err)
-			http.Error(w, "delete failed", http.StatusInternalServerError)
-			return
+			return 0, fmt.Errorf("at %q: %w", s, err)
 		}
 	}
+	if _, err := tx.Exec(`
+		INSERT INTO account_purge_log (user_id, requested_at, challenges, attempts)
+		VALUES (CAST($1 AS INT), $2, $3, $4)`, userID, d.RequestedAt, len(ids), attempts); err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("logging the purge: %w", err)
+	}
 	if err := tx.Commit(); err != nil {
-		http.Error(w, "delete failed", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
+	// Any data export archives go with the account, finished or not.
+	enqueueMediaDeletions(append(chatMedia, dataExportPrefix(userID)))
+	moderationMarkDeactivated(userID, false)
 
 	// 3) Best-effort Redis state: embeddings, seen-set, signals. TTLs
 	// reap the rest; these are just the long-lived keys.
@@ -111,12 +435,55 @@ func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
 			"recent_bounces:" + userID, "recent_searches:" + userID,
 			"creator_affinity:" + userID, "tie:" + userID,
 			"lasteng:" + userID, "ltrneg:" + userID,
+			"bandit:" + userID, modelRecentRequestsKey + userID,
 		} {
 			_ = rdb.Del(rctx, k).Err()
 		}
 	}
+	return len(ids), nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// HEALTH
+// ─────────────────────────────────────────────────────────────────────────────
 
-	log.Printf("Account deleted: user %s", userID)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"deleted": true})
+// AccountPurgeHealth is the purge job's section of /admin/health. The counts
+// come from the database, so they are the same whichever replica answers;
+// LastRun is this replica's own.
+type AccountPurgeHealth struct {
+	// Deactivated and still inside the grace period.
+	Pending int `json:"pending"`
+	// Past the grace period and not purged yet. More than a tick's worth
+	// for long means the job is not running or not keeping up.
+	Due int `json:"due"`
+	// Due accounts whose last attempt failed; LastError is the newest.
+	Failing      int             `json:"failing"`
+	LastError    string          `json:"lastError,omitempty"`
+	PurgedLast7d int             `json:"purgedLast7d"`
+	LastPurgedAt *time.Time      `json:"lastPurgedAt"`
+	LastRun      AccountPurgeRun `json:"lastRun"`
+}
+
+func accountPurgeHealth() AccountPurgeHealth {
+	accountPurgeLastRun.RLock()
+	h := AccountPurgeHealth{LastRun: accountPurgeLastRun.run}
+	accountPurgeLastRun.RUnlock()
+	if db == nil {
+		return h
+	}
+	_ = db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE purge_after > NOW()),
+		       COUNT(*) FILTER (WHERE purge_after <= NOW()),
+		       COUNT(*) FILTER (WHERE purge_after <= NOW() AND last_error <> ''),
+		       COALESCE((SELECT last_error FROM account_deletions
+		                 WHERE last_error <> '' ORDER BY claimed_at DESC NULLS LAST LIMIT 1), '')
+		FROM account_deletions`).Scan(&h.Pending, &h.Due, &h.Failing, &h.LastError)
+	var last sql.NullTime
+	_ = db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE purged_at > NOW() - INTERVAL '7 days'), MAX(purged_at)
+		FROM account_purge_log`).Scan(&h.PurgedLast7d, &last)
+	if last.Valid {
+		h.LastPurgedAt = &last.Time
+	}
+	return h
 }
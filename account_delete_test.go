@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeactivatedAccountIsHidden(t *testing.T) {
+	withModerationState(t, newModerationState())
+
+	moderationMarkDeactivated("7", true)
+	if !accountHidden("7") || !moderationHides("challenge", "100", "7") {
+		t.Fatal("deactivated account's content still served")
+	}
+	if accountHidden("8") {
+		t.Error("unrelated account hidden")
+	}
+	moderationMarkDeactivated("7", false)
+	if accountHidden("7") || moderationHides("challenge", "100", "7") {
+		t.Error("restored account still hidden")
+	}
+}
+
+func TestLoadModerationStateReadsDeactivated(t *testing.T) {
+	withModerationState(t, newModerationState())
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery("FROM moderation_hidden").WillReturnRows(sqlmock.NewRows([]string{"target_type", "target_id"}))
+	mock.ExpectQuery("FROM account_restrictions").WillReturnRows(sqlmock.NewRows(
+		[]string{"user_id", "kind", "reason", "action_id", "until", "indefinite"}))
+	mock.ExpectQuery("FROM account_deletions").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(42))
+
+	loadModerationState()
+	if !accountDeactivated("42") {
+		t.Error("account 42 not in the snapshot")
+	}
+}
+
+// Past the grace period a sign-in gets 410, not the account back.
+func TestCheckAccountRestoreAfterGrace(t *testing.T) {
+	withModerationState(t, newModerationState())
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM account_deletions WHERE user_id")).WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"requested_at", "purge_after"}).
+			AddRow(time.Now().Add(-31*24*time.Hour), time.Now().Add(-time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM account_deletions")).WithArgs("7").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rec := httptest.NewRecorder()
+	restored, ok := checkAccountRestore(rec, "7")
+	if restored || ok || rec.Code != http.StatusGone {
+		t.Fatalf("restored=%v ok=%v status=%d", restored, ok, rec.Code)
+	}
+}
+
+func TestCheckAccountRestoreWithinGrace(t *testing.T) {
+	withModerationState(t, newModerationState())
+	moderationMarkDeactivated("7", true)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM account_deletions WHERE user_id")).WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"requested_at", "purge_after"}).
+			AddRow(time.Now().Add(-time.Hour), time.Now().Add(29*24*time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM account_deletions")).WithArgs("7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	restored, ok := checkAccountRestore(httptest.NewRecorder(), "7")
+	if !restored || !ok {
+		t.Fatalf("restored=%v ok=%v", restored, ok)
+	}
+	if accountDeactivated("7") {
+		t.Error("restored account still hidden")
+	}
+}
+
+// A purge that fails keeps its claim and records why, and the run reports it.
+func TestRunAccountPurgeRecordsFailure(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE account_deletions SET claimed_at = NOW()")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "requested_at", "purge_after", "attempts"}).
+			AddRow("7", time.Now().Add(-31*24*time.Hour), time.Now().Add(-time.Hour), 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM challenges WHERE creator_id")).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE account_deletions SET last_error")).
+		WithArgs("7", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE account_deletions SET claimed_at = NOW()")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "requested_at", "purge_after", "attempts"}))
+
+	runAccountPurge()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	run := accountPurgeHealth().LastRun
+	if run.Failed != 1 || run.Purged != 0 || run.Err != "" {
+		t.Errorf("last run = %+v", run)
+	}
+}
+
+// A purged owner's groups go to a successor, or go away if nobody is left.
+func TestHandOverOwnedGroups(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT group_id FROM chat_group_members")).
+		WithArgs("1", groupRoleOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"group_id"}).AddRow(7).AddRow(8))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.role FROM chat_group_members`).WithArgs(7, "1").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(groupRoleOwner))
+	mock.ExpectExec(`DELETE FROM chat_group_members`).WithArgs(7, "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`ORDER BY \(role = \$2\) DESC`).WithArgs(7, groupRoleAdmin).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectExec(`DELETE FROM chat_groups WHERE id = \$1`).WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.role FROM chat_group_members`).WithArgs(8, "1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	if err := handOverOwnedGroups("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnqueueNotificationSkipsDeactivated(t *testing.T) {
+	withModerationState(t, newModerationState())
+	moderationMarkDeactivated("7", true)
+	_, cleanup := withMockDB(t)
+	defer cleanup()
+
+	_, queued, err := enqueueNotification(EnqueueParams{UserID: "7", TriggerKind: TriggerFriendResponse, DedupeKey: "x"})
+	if queued || err != nil {
+		t.Errorf("queued=%v err=%v", queued, err)
+	}
+}
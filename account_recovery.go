@@ -0,0 +1,407 @@
+package main
+
+// account_recovery.go — email addresses, verifying them, and getting back
+// into an account by one.
+//
+// Until this file an account was a username and a password and nothing else.
+// Forgetting the password meant a new account; there was no address on file
+// to send anything to, let alone one known to belong to the user.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// ADDRESSES
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// An address is given at signup or later (POST /account/email) and stays
+// unverified until the link mailed to it is used. Only a verified address can
+// receive a password reset: an unverified one is merely what someone typed,
+// and a typo would hand the account to whoever owns the typo. Two accounts
+// may both have typed the same address; only one can verify it.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// TOKENS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Reset and verification links carry a random token. Only its SHA-256 is
+// stored, so a read of the database is not a way into accounts. Each token
+// is single-use, expires (accountResetTTL, accountVerifyTTL), and issuing a
+// new one of the same kind retires the user's older ones — only the latest
+// email in the inbox works.
+//
+// POST /password/forgot answers the same whatever it is given, so it can't be
+// used to find out which addresses have accounts; the mail is sent off the
+// request path for the same reason. It is rate limited per address and per IP
+// through allowAction, as login is.
+//
+// Completing a reset signs the account out everywhere (sessions.go): whoever
+// knew the old password may also hold a session.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	accountPurposeReset  = "password_reset"
+	accountPurposeVerify = "email_verify"
+
+	// accountResetTTL is short: a reset link is a password.
+	accountResetTTL = time.Hour
+	// accountVerifyTTL allows for the mail being read tomorrow.
+	accountVerifyTTL = 48 * time.Hour
+)
+
+var (
+	errAccountTokenInvalid = errors.New("this link is invalid or has expired")
+	errEmailInvalid        = errors.New("that doesn't look like an email address")
+	errEmailTaken          = errors.New("that address is already verified on another account")
+)
+
+// normalizeEmail returns the canonical form of an address — trimmed and
+// lower-cased — or false if it is not a bare address.
+func normalizeEmail(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || len(s) > 254 || strings.ContainsAny(s, "\r\n") {
+		return "", false
+	}
+	a, err := mail.ParseAddress(s)
+	if err != nil || a.Address != s || a.Name != "" {
+		return "", false
+	}
+	return s, true
+}
+
+// hashAccountToken is what is stored in place of a mailed token.
+func hashAccountToken(token string) string {
+	return hashRefreshSecret(token)
+}
+
+// mailLink builds the link a mail carries. MAIL_LINK_BASE is where the app
+// (or its web fallback) handles it.
+func mailLink(path, token string) string {
+	return getEnv("MAIL_LINK_BASE", "devf://") + path + "?token=" + token
+}
+
+// issueAccountToken mints a token for userID, retiring any unused one of the
+// same purpose.
+func issueAccountToken(userID int, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE account_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO account_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		userID, purpose, hashAccountToken(token), email, time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// consumeAccountToken spends a token inside tx and returns whose it was.
+func consumeAccountToken(tx *sql.Tx, purpose, token string) (userID int, email string, err error) {
+	var expires time.Time
+	var used bool
+	err = tx.QueryRow(`
+		SELECT user_id, email, expires_at, used_at IS NOT NULL FROM account_tokens
+		WHERE token_hash = $1 AND purpose = $2 FOR UPDATE`,
+		hashAccountToken(token), purpose).Scan(&userID, &email, &expires, &used)
+	if err == sql.ErrNoRows {
+		return 0, "", errAccountTokenInvalid
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if used || !time.Now().Before(expires) {
+		return 0, "", errAccountTokenInvalid
+	}
+	if _, err := tx.Exec(`UPDATE account_tokens SET used_at = NOW() WHERE token_hash = $1`,
+		hashAccountToken(token)); err != nil {
+		return 0, "", err
+	}
+	return userID, email, nil
+}
+
+// setUserEmail records a new, unverified address for a user and mails it a
+// verification link. Setting the address already on file resends the link,
+// unless it is already verified. Reports whether it is.
+func setUserEmail(userID int, email string) (bool, error) {
+	var verified bool
+	if err := db.QueryRow(`
+		INSERT INTO user_emails (user_id, email) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			verified_at = CASE WHEN user_emails.email = EXCLUDED.email THEN user_emails.verified_at END,
+			email = EXCLUDED.email, updated_at = NOW()
+		RETURNING verified_at IS NOT NULL`, userID, email).Scan(&verified); err != nil {
+		return false, err
+	}
+	if verified {
+		return true, nil
+	}
+	return false, sendVerificationEmail(userID, email)
+}
+
+// sendVerificationEmail mails a verification link to an address.
+func sendVerificationEmail(userID int, email string) error {
+	token, err := issueAccountToken(userID, accountPurposeVerify, email, accountVerifyTTL)
+	if err != nil {
+		return err
+	}
+	return getMailSender().Send(MailMessage{
+		To:      email,
+		Subject: "Confirm your email address",
+		Text: fmt.Sprintf("Confirm this is your address by opening the link below. It works once and expires in %d hours.\n\n%s\n\nIf you didn't add this address to an account, ignore this email.\n",
+			int(accountVerifyTTL.Hours()), mailLink("email/verify", token)),
+	})
+}
+
+// sendPasswordReset mails a reset link if the address is verified on an
+// account, and does nothing otherwise. Runs off the request path.
+func sendPasswordReset(email string) {
+	var userID int
+	var username string
+	err := db.QueryRow(`
+		SELECT e.user_id, u.username FROM user_emails e JOIN users u ON u.id = e.user_id
+		WHERE e.email = $1 AND e.verified_at IS NOT NULL`, email).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("password reset: lookup: %v", err)
+		return
+	}
+	token, err := issueAccountToken(userID, accountPurposeReset, email, accountResetTTL)
+	if err != nil {
+		log.Printf("password reset: issuing token for user %d: %v", userID, err)
+		return
+	}
+	if err := getMailSender().Send(MailMessage{
+		To:      email,
+		Subject: "Reset your password",
+		Text: fmt.Sprintf("Someone asked to reset the password for %s. To choose a new one, open the link below. It works once and expires in %d minutes.\n\n%s\n\nIf it wasn't you, ignore this email; your password hasn't changed.\n",
+			username, int(accountResetTTL.Minutes()), mailLink("password/reset", token)),
+	}); err != nil {
+		log.Printf("password reset: sending to user %d: %v", userID, err)
+	}
+}
+
+// resetPassword spends a reset token and sets the new password. Returns the
+// user whose password it was.
+func resetPassword(token, password string) (int, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	userID, _, err := consumeAccountToken(tx, accountPurposeReset, token)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password_hash = $1, password = '' WHERE id = $2`, hash, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// verifyEmail spends a verification token and marks its address verified, if
+// it is still the address on the account.
+func verifyEmail(token string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	userID, email, err := consumeAccountToken(tx, accountPurposeVerify, token)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE user_emails SET verified_at = NOW(), updated_at = NOW()
+		WHERE user_id = $1 AND email = $2 AND verified_at IS NULL`, userID, email)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return errEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Changed since the mail was sent, or already verified.
+		var current string
+		var verified bool
+		if err := tx.QueryRow(`SELECT email, verified_at IS NOT NULL FROM user_emails WHERE user_id = $1`,
+			userID).Scan(&current, &verified); err != nil || current != email || !verified {
+			return errAccountTokenInvalid
+		}
+	}
+	return tx.Commit()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// ForgotPasswordHandler — POST /password/forgot body:{ email }
+// Always 202 with the same body, whether or not the address has an account.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email, ok := normalizeEmail(p.Email)
+	if !ok {
+		http.Error(w, errEmailInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+	// Per address so one inbox can't be flooded, per IP so one client
+	// can't walk a list of addresses. Same short-circuit as login.
+	if !allowAction("email:"+email, "password_reset") || !allowAction("ip:"+clientIP(r), "password_reset") {
+		writeRateLimited(w, "password_reset")
+		return
+	}
+	go sendPasswordReset(email)
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "If that address is verified on an account, a reset link is on its way.",
+	})
+}
+
+// ResetPasswordHandler — POST /password/reset body:{ token, password }
+// Sets the new password and signs the account out everywhere.
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(p.Password) < minPasswordLen {
+		http.Error(w, fmt.Sprintf("password must be at least %d characters", minPasswordLen), http.StatusBadRequest)
+		return
+	}
+	if !allowAction("ip:"+clientIP(r), "password_reset") {
+		writeRateLimited(w, "password_reset")
+		return
+	}
+	userID, err := resetPassword(p.Token, p.Password)
+	if errors.Is(err, errAccountTokenInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("password reset failed: %v", err)
+		http.Error(w, "reset failed", http.StatusInternalServerError)
+		return
+	}
+	uid := strconv.Itoa(userID)
+	n, err := revokeAllSessions(uid, "", "password_reset")
+	if err != nil {
+		// The password has changed; a failed sign-out must not undo that or
+		// hide it from the user, but it must be seen.
+		log.Printf("password reset: signing out user %s: %v", uid, err)
+	}
+	log.Printf("password reset for user %s; %d sessions signed out", uid, n)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "password updated"})
+}
+
+// VerifyEmailHandler — POST /email/verify body:{ token }
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !allowAction("ip:"+clientIP(r), "email_verify") {
+		writeRateLimited(w, "email_verify")
+		return
+	}
+	switch err := verifyEmail(p.Token); {
+	case errors.Is(err, errAccountTokenInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		log.Printf("email verify failed: %v", err)
+		http.Error(w, "verification failed", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "email verified"})
+	}
+}
+
+// UpdateEmailHandler — POST /api/v1/account/email body:{ email }
+// Sets the caller's address (unverified) and mails it a link. Sending the
+// address already on file resends the link.
+func UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email, ok := normalizeEmail(p.Email)
+	if !ok {
+		http.Error(w, errEmailInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	if !allowAction(userID, "email_verify") || !allowAction("email:"+email, "email_verify") {
+		writeRateLimited(w, "email_verify")
+		return
+	}
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	verified, err := setUserEmail(uid, email)
+	if err != nil {
+		log.Printf("setting email for user %s: %v", userID, err)
+		http.Error(w, "could not update email", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"email": email, "verified": verified})
+}
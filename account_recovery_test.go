@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// recordingMailSender keeps what it was asked to send.
+type recordingMailSender struct{ sent []MailMessage }
+
+func (*recordingMailSender) Name() string { return "recording" }
+
+func (s *recordingMailSender) Send(msg MailMessage) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func withMailSender(t *testing.T) *recordingMailSender {
+	t.Helper()
+	prev := getMailSender()
+	s := &recordingMailSender{}
+	setMailSender(s)
+	t.Cleanup(func() { setMailSender(prev) })
+	return s
+}
+
+// Unconfigured mail fails closed rather than logging credentials.
+func TestMailIsOffUnlessConfigured(t *testing.T) {
+	prev := getMailSender()
+	t.Cleanup(func() { setMailSender(prev) })
+	for mode, want := range map[string]string{"": "off", "smpt": "off", "smtp": "off", "log": "log"} {
+		t.Setenv("MAIL_SENDER", mode)
+		t.Setenv("SMTP_HOST", "")
+		initMailSender()
+		if got := getMailSender().Name(); got != want {
+			t.Errorf("MAIL_SENDER=%q: sender %s, want %s", mode, got, want)
+		}
+	}
+	if err := (offMailSender{}).Send(MailMessage{To: "a@b.co"}); err == nil {
+		t.Error("the off sender claimed to send")
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		"  Alice@Example.COM ": "alice@example.com",
+		"a.b+c@d.io":           "a.b+c@d.io",
+	} {
+		if got, ok := normalizeEmail(in); !ok || got != want {
+			t.Errorf("normalizeEmail(%q) = %q, %v", in, got, ok)
+		}
+	}
+	for _, bad := range []string{"", "alice", "Alice <a@b.co>", "a@b.co\r\nBcc: x@y.z", "a@b.co, c@d.co"} {
+		if got, ok := normalizeEmail(bad); ok {
+			t.Errorf("normalizeEmail(%q) accepted as %q", bad, got)
+		}
+	}
+}
+
+// A reset goes only to a verified address and retires older links.
+func TestSendPasswordReset(t *testing.T) {
+	mail := withMailSender(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta("e.verified_at IS NOT NULL")).WithArgs("a@b.co").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username"}).AddRow(5, "alice"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE account_tokens SET used_at = NOW()")).
+		WithArgs(5, accountPurposeReset).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO account_tokens")).
+		WithArgs(5, accountPurposeReset, sqlmock.AnyArg(), "a@b.co", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	sendPasswordReset("a@b.co")
+
+	if len(mail.sent) != 1 || mail.sent[0].To != "a@b.co" {
+		t.Fatalf("sent = %+v", mail.sent)
+	}
+	if !strings.Contains(mail.sent[0].Text, "password/reset?token=") || !strings.Contains(mail.sent[0].Text, "alice") {
+		t.Errorf("mail text = %q", mail.sent[0].Text)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// No verified address, no mail — and nothing that tells the caller so.
+func TestSendPasswordResetUnknownAddress(t *testing.T) {
+	mail := withMailSender(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("e.verified_at IS NOT NULL")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username"}))
+
+	sendPasswordReset("nobody@b.co")
+	if len(mail.sent) != 0 {
+		t.Errorf("mailed an address with no account: %+v", mail.sent)
+	}
+}
+
+// A spent or expired token changes nothing.
+func TestResetPasswordRefusesSpentToken(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	for _, row := range [][]driver.Value{
+		{5, "a@b.co", time.Now().Add(time.Hour), true},     // used
+		{5, "a@b.co", time.Now().Add(-time.Minute), false}, // expired
+	} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("FROM account_tokens")).
+			WithArgs(hashAccountToken("tok"), accountPurposeReset).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "expires_at", "used"}).AddRow(row...))
+		mock.ExpectRollback()
+		if _, err := resetPassword("tok", "new-password"); err != errAccountTokenInvalid {
+			t.Errorf("err = %v, want errAccountTokenInvalid", err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResetPasswordSetsHash(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM account_tokens")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "expires_at", "used"}).
+			AddRow(5, "a@b.co", time.Now().Add(time.Hour), false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE account_tokens SET used_at")).
+		WithArgs(hashAccountToken("tok")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password_hash")).
+		WithArgs(sqlmock.AnyArg(), 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	userID, err := resetPassword("tok", "new-password")
+	if err != nil || userID != 5 {
+		t.Fatalf("resetPassword = %d, %v", userID, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSMTPMessageFormat(t *testing.T) {
+	s := &smtpMailSender{from: "no-reply@example.com"}
+	got := string(s.format(MailMessage{To: "a@b.co", Subject: "Hi", Text: "line one\nline two\n"}))
+	for _, want := range []string{"From: no-reply@example.com\r\n", "To: a@b.co\r\n", "Subject: Hi\r\n", "\r\n\r\nline one\r\nline two\r\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message missing %q:\n%s", want, got)
+		}
+	}
+}
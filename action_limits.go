@@ -54,17 +54,28 @@ var actionLimitTable = map[string]actionLimitConfig{
 	"unfollow":         {tokensPerSecond: 0.5, burst: 5},
 	"block":            {tokensPerSecond: 0.33, burst: 5},  // 20/min
 	"unblock":          {tokensPerSecond: 0.33, burst: 5},
+	"mute":             {tokensPerSecond: 0.5, burst: 10},  // 30/min, burst 10 — edits and mutes
 
 	// Content creation — expensive (storage + processing) so the per-
 	// hour cap is quite low. Burst of 2 means even back-to-back
 	// uploads after a long break are fine.
 	"challenge_create": {tokensPerSecond: 5.0 / 3600.0, burst: 2}, // 5/hr
 	"challenge_accept": {tokensPerSecond: 0.0083, burst: 3},       // 30/hr, burst 3
+	// A tournament pulls up to 32 people into a day of battles each round,
+	// so opening one is rarer than posting a challenge.
+	"tournament_create": {tokensPerSecond: 3.0 / 3600.0, burst: 2}, // 3/hr
 
 	// Messaging — chat needs to feel instant for real conversations
 	// but a script could absolutely spam. 1 msg/sec sustained, burst
 	// of 10 for a quick exchange.
 	"chat":             {tokensPerSecond: 1.0, burst: 10},
+	// Starting a group, or minting a fresh invite link, is a setup step
+	// a person does a few times a day; a script doing it is harvesting
+	// members.
+	"group_manage": {tokensPerSecond: 10.0 / 3600.0, burst: 5}, // 10/hr
+	// Typing indicators — the app sends one when typing starts and stops,
+	// and refreshes every few seconds. Anything faster is noise to drop.
+	"typing": {tokensPerSecond: 2.0, burst: 5},
 
 	// Reports — moderation tooling abuse-prone (false reports to
 	// silence rivals), keep tight. 10/hr sustained.
@@ -78,6 +89,10 @@ var actionLimitTable = map[string]actionLimitConfig{
 	// minute from one account is a credential-stuffing attempt.
 	"login":            {tokensPerSecond: 0.1, burst: 5},          // 6/min
 	"signup":           {tokensPerSecond: 0.05, burst: 3},         // 3/min
+	// Account mail — each one lands in someone's inbox, so per address
+	// and per IP these are far tighter than login.
+	"password_reset": {tokensPerSecond: 3.0 / 3600.0, burst: 3}, // 3/hr
+	"email_verify":   {tokensPerSecond: 5.0 / 3600.0, burst: 5}, // 5/hr
 }
 
 // actionLimiterRegistry holds one rateLimiter per action key. Created
@@ -0,0 +1,346 @@
+package main
+
+// admin_accounts.go — managing admin accounts, admin TOTP, and the admin
+// audit log. The gate itself is in admin_auth.go.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE AUDIT LOG
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// adminOnly writes one row per admin request that changes something — reseed,
+// an experiment upsert, a moderation decision, an account change — after the
+// handler has run, with the status it answered. Request bodies are not kept:
+// some carry passwords. What a decision contained is in the feature's own
+// records (moderation_actions, experiments); this log says who did it, when,
+// and whether it worked.
+//
+// The table refuses UPDATE, DELETE and TRUNCATE (a trigger in the migration),
+// so it is append-only for this service's own database role too, not just by
+// convention in this file.
+//
+// Endpoints:
+//   GET  /api/v1/admin/me                      → who am I, what may I do   (view)
+//   POST /api/v1/admin/me/totp/enroll          → new TOTP secret            (view)
+//   POST /api/v1/admin/me/totp/verify          → turn TOTP on               (view)
+//   GET  /api/v1/admin/audit-log               → the log, newest first     (view)
+//   GET  /api/v1/admin/accounts                → every admin               (manage_admins)
+//   POST /api/v1/admin/accounts                → create one                (manage_admins)
+//   POST /api/v1/admin/accounts/{id}           → role/password/disable/TOTP (manage_admins)
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+var (
+	errAdminBadRole      = errors.New(`role must be "viewer", "moderator", "experimenter" or "superuser"`)
+	errAdminWeakPassword = fmt.Errorf("admin passwords must be at least %d characters and not a common one", minAdminPassLen)
+	errAdminSelfLockout  = errors.New("you can't demote or disable your own account; ask another superuser")
+)
+
+// recordAdminAction appends one mutating admin request to the audit log.
+func recordAdminAction(a *adminAccount, perm string, r *http.Request, status int) {
+	action := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			action = tpl
+		}
+	}
+	target, _ := json.Marshal(mux.Vars(r))
+	if _, err := db.Exec(`
+		INSERT INTO admin_audit_log
+			(admin_id, admin_username, role, permission, method, action, path, target, status, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9, $10)`,
+		a.ID, a.Username, a.Role, perm, r.Method, action, r.URL.RequestURI(), string(target),
+		status, clientIP(r)); err != nil {
+		// Never silently: an admin action with no record is what this log
+		// exists to prevent.
+		log.Printf("ADMIN AUDIT WRITE FAILED: %s %s by %s (status %d): %v",
+			r.Method, r.URL.RequestURI(), a.Username, status, err)
+	}
+}
+
+// AdminAuditEntry is one row of the admin audit log.
+type AdminAuditEntry struct {
+	ID         int64             `json:"id"`
+	AdminID    int               `json:"adminId"`
+	Admin      string            `json:"admin"`
+	Role       string            `json:"role"`
+	Permission string            `json:"permission"`
+	Method     string            `json:"method"`
+	Action     string            `json:"action"`
+	Path       string            `json:"path"`
+	Target     map[string]string `json:"target"`
+	Status     int               `json:"status"`
+	IP         string            `json:"ip"`
+	CreatedAt  string            `json:"createdAt"`
+}
+
+// AdminAuditLogHandler — GET /api/v1/admin/audit-log?admin=&before=&limit=
+// before is an entry id, for paging back.
+func AdminAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := parseIntOrDefault(q.Get("limit"), 100, 500)
+	before := int64(parseIntOrDefault(q.Get("before"), 0, 1<<62))
+	rows, err := db.Query(`
+		SELECT id, admin_id, admin_username, role, permission, method, action, path,
+		       COALESCE(target, '{}'), status, ip, created_at
+		FROM admin_audit_log
+		WHERE ($1 = '' OR admin_username = $1) AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC LIMIT $3`, q.Get("admin"), before, limit)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	out := []AdminAuditEntry{}
+	for rows.Next() {
+		var e AdminAuditEntry
+		var target []byte
+		var at time.Time
+		if rows.Scan(&e.ID, &e.AdminID, &e.Admin, &e.Role, &e.Permission, &e.Method, &e.Action,
+			&e.Path, &target, &e.Status, &e.IP, &at) != nil {
+			continue
+		}
+		_ = json.Unmarshal(target, &e.Target)
+		e.CreatedAt = at.UTC().Format(time.RFC3339)
+		out = append(out, e)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": out})
+}
+
+// adminPermsOf lists a role's permissions, for display.
+func adminPermsOf(role string) []string {
+	var out []string
+	for _, p := range []string{adminPermView, adminPermModerate, adminPermExperiment, adminPermOperate, adminPermManage} {
+		if adminRolePerms[role][p] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// AdminMeHandler — GET /api/v1/admin/me
+func AdminMeHandler(w http.ResponseWriter, r *http.Request) {
+	a := currentAdmin(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":          a.ID,
+		"username":    a.Username,
+		"role":        a.Role,
+		"permissions": adminPermsOf(a.Role),
+		"totp":        a.TOTPActive,
+	})
+}
+
+// AdminEnrollTOTPHandler — POST /api/v1/admin/me/totp/enroll
+// Returns a new secret; TOTP stays off until /verify proves the app has it.
+// With TOTP already on, a superuser has to reset it first — otherwise a
+// remembered credential alone could swap the second factor.
+func AdminEnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	a := currentAdmin(r)
+	if a.TOTPActive {
+		http.Error(w, "TOTP is already on; a superuser can reset it", http.StatusConflict)
+		return
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		http.Error(w, "secret generation failed", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.Exec(`UPDATE admin_accounts SET totp_secret = $2 WHERE id = $1 AND NOT totp_active`,
+		a.ID, secret); err != nil {
+		http.Error(w, "enroll failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"secret":         secret,
+		"otpauthUri":     otpauthURI("devf-admin", a.Username, secret),
+		"digits":         totpDigits,
+		"periodSeconds":  totpStepSeconds,
+		"requiresVerify": true,
+	})
+}
+
+// AdminVerifyTOTPHandler — POST /api/v1/admin/me/totp/verify body:{ code }
+// Turns TOTP on. From the next request the password needs the code on the end.
+func AdminVerifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	a := currentAdmin(r)
+	var p struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var secret string
+	if err := db.QueryRow(`SELECT COALESCE(totp_secret, '') FROM admin_accounts WHERE id = $1`, a.ID).
+		Scan(&secret); err != nil || secret == "" {
+		http.Error(w, "enroll first", http.StatusBadRequest)
+		return
+	}
+	if !verifyTOTPCode(secret, strings.TrimSpace(p.Code)) {
+		http.Error(w, "wrong code", http.StatusBadRequest)
+		return
+	}
+	if _, err := db.Exec(`UPDATE admin_accounts SET totp_active = TRUE, auth_version = auth_version + 1
+		WHERE id = $1`, a.ID); err != nil {
+		http.Error(w, "verify failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"totp": true})
+}
+
+// AdminAccountView is one admin as the account list shows them.
+type AdminAccountView struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	TOTP        bool   `json:"totp"`
+	CreatedBy   string `json:"createdBy"`
+	CreatedAt   string `json:"createdAt"`
+	LastLoginAt string `json:"lastLoginAt,omitempty"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// AdminListAccountsHandler — GET /api/v1/admin/accounts
+func AdminListAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT id, username, role, totp_active, created_by, created_at, last_login_at,
+		disabled_at IS NOT NULL FROM admin_accounts ORDER BY id`)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	out := []AdminAccountView{}
+	for rows.Next() {
+		var v AdminAccountView
+		var created time.Time
+		var lastLogin sql.NullTime
+		if rows.Scan(&v.ID, &v.Username, &v.Role, &v.TOTP, &v.CreatedBy, &created, &lastLogin, &v.Disabled) != nil {
+			continue
+		}
+		v.CreatedAt = created.UTC().Format(time.RFC3339)
+		if lastLogin.Valid {
+			v.LastLoginAt = lastLogin.Time.UTC().Format(time.RFC3339)
+		}
+		out = append(out, v)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"accounts": out})
+}
+
+// AdminCreateAccountHandler — POST /api/v1/admin/accounts
+// body:{ username, password, role }
+func AdminCreateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var p struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	p.Username = strings.TrimSpace(p.Username)
+	if p.Username == "" || strings.ContainsAny(p.Username, ": ") {
+		http.Error(w, "username is required and can't contain spaces or ':'", http.StatusBadRequest)
+		return
+	}
+	if adminRolePerms[p.Role] == nil {
+		http.Error(w, errAdminBadRole.Error(), http.StatusBadRequest)
+		return
+	}
+	if weakAdminPassword(p.Password) {
+		http.Error(w, errAdminWeakPassword.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := hashPassword(p.Password)
+	if err != nil {
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	var id int
+	err = db.QueryRow(`INSERT INTO admin_accounts (username, password_hash, role, created_by)
+		VALUES ($1, $2, $3, $4) RETURNING id`, p.Username, hash, p.Role, currentAdmin(r).Username).Scan(&id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		http.Error(w, "that admin username is taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "username": p.Username, "role": p.Role})
+}
+
+// AdminUpdateAccountHandler — POST /api/v1/admin/accounts/{id}
+// body:{ role?, password?, disabled?, resetTotp? }
+// Any change signs the admin out everywhere (auth_version).
+func AdminUpdateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "unknown admin", http.StatusBadRequest)
+		return
+	}
+	var p struct {
+		Role      *string `json:"role"`
+		Password  *string `json:"password"`
+		Disabled  *bool   `json:"disabled"`
+		ResetTOTP bool    `json:"resetTotp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if p.Role != nil && adminRolePerms[*p.Role] == nil {
+		http.Error(w, errAdminBadRole.Error(), http.StatusBadRequest)
+		return
+	}
+	self := currentAdmin(r).ID == id
+	if self && ((p.Role != nil && *p.Role != adminRoleSuperuser) || (p.Disabled != nil && *p.Disabled)) {
+		http.Error(w, errAdminSelfLockout.Error(), http.StatusBadRequest)
+		return
+	}
+	var hash *string
+	if p.Password != nil {
+		if weakAdminPassword(*p.Password) {
+			http.Error(w, errAdminWeakPassword.Error(), http.StatusBadRequest)
+			return
+		}
+		h, err := hashPassword(*p.Password)
+		if err != nil {
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+		hash = &h
+	}
+	res, err := db.Exec(`
+		UPDATE admin_accounts SET
+			role          = COALESCE($2, role),
+			password_hash = COALESCE($3, password_hash),
+			disabled_at   = CASE WHEN $4::boolean IS NULL THEN disabled_at
+			                     WHEN $4 THEN COALESCE(disabled_at, NOW()) END,
+			totp_active   = CASE WHEN $5 THEN FALSE ELSE totp_active END,
+			totp_secret   = CASE WHEN $5 THEN NULL ELSE totp_secret END,
+			auth_version  = auth_version + 1
+		WHERE id = $1`, id, p.Role, hash, p.Disabled, p.ResetTOTP)
+	if err != nil {
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "unknown admin", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "updated": true})
+}
@@ -1,54 +1,324 @@
 package main
 
 import (
-	"crypto/subtle"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ════════════════════════════════════════════════════════════════════════════════
-// ADMIN AUTH — HTTP Basic Auth gate for /admin* surfaces
+// ADMIN AUTH — named admin accounts with roles, behind HTTP Basic Auth
 // ════════════════════════════════════════════════════════════════════════════════
 //
-// Reads two env vars at request time:
-//   ADMIN_USER   — username
-//   ADMIN_PASS   — password
+// This gate used to compare every request against one ADMIN_USER/ADMIN_PASS
+// pair: everyone who could read the funnels could also POST /admin/reseed,
+// and nothing recorded who did what. Now each admin is a row in
+// admin_accounts with their own bcrypt password and a role, and every route
+// says which permission it needs:
 //
-// If either is missing, the gate returns 503 (service unavailable) so we never
-// silently expose admin data because someone forgot to set the env. If they're
-// set, the browser's native Basic-Auth prompt is used — no login page to build.
+//	viewer        dashboards, health, diagnostics, the audit log
+//	moderator     + the moderation queue and its decisions
+//	experimenter  + creating, changing and killing experiments
+//	superuser     + reseed, ratings rebuild, and managing admin accounts
 //
-// Constant-time comparison prevents timing side-channels (not critical for a
-// private admin, but cheap to get right).
+// The browser's Basic-Auth prompt is still the login page. An admin with TOTP
+// turned on (totp.go) types their password with the current 6-digit code on
+// the end — "correct horse battery staple492817" — or a script sends the code
+// in X-Admin-OTP. The browser then re-sends that same header on every
+// request, so once a credential has passed, its hash is remembered for
+// adminCredentialTTL (adminTOTPCredentialTTL with TOTP) rather than asking
+// for a fresh code every 30 seconds. Changing an admin's password, role or
+// TOTP, or disabling them, bumps auth_version and retires every remembered
+// credential at once.
+//
+// ADMIN_USER/ADMIN_PASS now only bootstrap: while admin_accounts is empty,
+// boot creates a superuser from them (seedAdminFromEnv). After that they are
+// not read.
+//
+// Every admin request that changes something (anything but GET/HEAD) is
+// written to admin_audit_log after it runs: who, what, and the status it got.
+// See admin_accounts.go.
+
+// Admin permissions. A route asks for exactly one.
+const (
+	adminPermView       = "view"
+	adminPermModerate   = "moderate"
+	adminPermExperiment = "experiment"
+	adminPermOperate    = "operate" // reseed, rebuilds
+	adminPermManage     = "manage_admins"
+)
+
+// Admin roles.
+const (
+	adminRoleViewer       = "viewer"
+	adminRoleModerator    = "moderator"
+	adminRoleExperimenter = "experimenter"
+	adminRoleSuperuser    = "superuser"
+)
+
+// adminRolePerms is what each role may do.
+var adminRolePerms = map[string]map[string]bool{
+	adminRoleViewer:       {adminPermView: true},
+	adminRoleModerator:    {adminPermView: true, adminPermModerate: true},
+	adminRoleExperimenter: {adminPermView: true, adminPermExperiment: true},
+	adminRoleSuperuser: {
+		adminPermView: true, adminPermModerate: true, adminPermExperiment: true,
+		adminPermOperate: true, adminPermManage: true,
+	},
+}
+
+const (
+	// adminCredentialTTL is how long a password-only credential is trusted
+	// without another bcrypt comparison.
+	adminCredentialTTL = 15 * time.Minute
+	// adminTOTPCredentialTTL is how long a credential that passed TOTP lasts
+	// — in effect, the length of an admin session.
+	adminTOTPCredentialTTL = 12 * time.Hour
+)
+
+const adminContextKey ctxKey = "adminAccount"
+
+// adminAccount is the admin a request is made by.
+type adminAccount struct {
+	ID          int
+	Username    string
+	Role        string
+	TOTPActive  bool
+	AuthVersion int
+}
+
+// can reports whether the admin's role grants perm.
+func (a *adminAccount) can(perm string) bool {
+	return a != nil && adminRolePerms[a.Role][perm]
+}
+
+// currentAdmin returns the admin established by adminOnly, or nil.
+func currentAdmin(r *http.Request) *adminAccount {
+	a, _ := r.Context().Value(adminContextKey).(*adminAccount)
+	return a
+}
+
+var errAdminCredentials = errors.New("unauthorized")
+
+// adminCredentialKey identifies one exact Basic-Auth credential without
+// keeping it.
+func adminCredentialKey(user, pass string) string {
+	sum := sha256.Sum256([]byte(user + "\x00" + pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// adminCredentials remembers credentials that have passed, for replicas
+// without Redis. Keyed by adminCredentialKey.
+var adminCredentials = struct {
+	sync.Mutex
+	m map[string]adminCredential
+}{m: map[string]adminCredential{}}
+
+type adminCredential struct {
+	adminID, authVersion int
+	until                time.Time
+}
+
+func rememberAdminCredential(key string, a *adminAccount, ttl time.Duration) {
+	if rdb != nil {
+		rdb.Set(rctx, "admin:cred:"+key, strconv.Itoa(a.ID)+"|"+strconv.Itoa(a.AuthVersion), ttl)
+		return
+	}
+	adminCredentials.Lock()
+	defer adminCredentials.Unlock()
+	now := time.Now()
+	for k, c := range adminCredentials.m {
+		if now.After(c.until) {
+			delete(adminCredentials.m, k)
+		}
+	}
+	adminCredentials.m[key] = adminCredential{adminID: a.ID, authVersion: a.AuthVersion, until: now.Add(ttl)}
+}
+
+func recalledAdminCredential(key string) (adminID, authVersion int, ok bool) {
+	if rdb != nil {
+		v, err := rdb.Get(rctx, "admin:cred:"+key).Result()
+		if err != nil {
+			return 0, 0, false
+		}
+		id, ver, found := strings.Cut(v, "|")
+		if !found {
+			return 0, 0, false
+		}
+		adminID, err1 := strconv.Atoi(id)
+		authVersion, err2 := strconv.Atoi(ver)
+		return adminID, authVersion, err1 == nil && err2 == nil
+	}
+	adminCredentials.Lock()
+	defer adminCredentials.Unlock()
+	c, found := adminCredentials.m[key]
+	if !found || time.Now().After(c.until) {
+		return 0, 0, false
+	}
+	return c.adminID, c.authVersion, true
+}
+
+// loadAdmin reads an enabled admin by id.
+func loadAdmin(id int) (*adminAccount, error) {
+	a := &adminAccount{ID: id}
+	err := db.QueryRow(`SELECT username, role, totp_active, auth_version FROM admin_accounts
+		WHERE id = $1 AND disabled_at IS NULL`, id).Scan(&a.Username, &a.Role, &a.TOTPActive, &a.AuthVersion)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// splitAdminOTP separates a TOTP code typed on the end of a password.
+func splitAdminOTP(pass string) (password, code string) {
+	if len(pass) <= totpDigits {
+		return pass, ""
+	}
+	tail := pass[len(pass)-totpDigits:]
+	for _, c := range tail {
+		if c < '0' || c > '9' {
+			return pass, ""
+		}
+	}
+	return pass[:len(pass)-totpDigits], tail
+}
 
-// adminOnly wraps a handler and requires valid Basic Auth credentials.
+// authenticateAdmin checks a Basic-Auth credential against admin_accounts.
+func authenticateAdmin(r *http.Request, user, pass string) (*adminAccount, error) {
+	key := adminCredentialKey(user, pass+"\x00"+r.Header.Get("X-Admin-OTP"))
+	if id, ver, ok := recalledAdminCredential(key); ok {
+		if a, err := loadAdmin(id); err == nil && a.AuthVersion == ver {
+			return a, nil
+		}
+	}
+
+	// A miss costs a bcrypt comparison, so it spends from the login
+	// buckets — per admin name and per address — as user sign-in does.
+	if !allowAction("admin:"+strings.ToLower(user), "login") || !allowAction("ip:"+clientIP(r), "login") {
+		return nil, errRateLimitedAdmin
+	}
+
+	var a adminAccount
+	var hash, secret string
+	err := db.QueryRow(`
+		SELECT id, username, role, totp_active, auth_version, password_hash, COALESCE(totp_secret, '')
+		FROM admin_accounts WHERE username = $1 AND disabled_at IS NULL`, user).
+		Scan(&a.ID, &a.Username, &a.Role, &a.TOTPActive, &a.AuthVersion, &hash, &secret)
+	if err == sql.ErrNoRows {
+		return nil, errAdminCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl := adminCredentialTTL
+	if a.TOTPActive {
+		code := r.Header.Get("X-Admin-OTP")
+		if code == "" {
+			pass, code = splitAdminOTP(pass)
+		}
+		if !checkPassword(hash, pass) || !verifyTOTPCode(secret, code) {
+			return nil, errAdminCredentials
+		}
+		ttl = adminTOTPCredentialTTL
+	} else if !checkPassword(hash, pass) {
+		return nil, errAdminCredentials
+	}
+	rememberAdminCredential(key, &a, ttl)
+	_, _ = db.Exec(`UPDATE admin_accounts SET last_login_at = NOW() WHERE id = $1`, a.ID)
+	return &a, nil
+}
+
+var errRateLimitedAdmin = errors.New("too many attempts")
+
+// adminOnly wraps a handler so it only runs for an admin whose role grants
+// perm. Mutating requests are written to the audit log.
 // Usage:
 //
-//	api.HandleFunc("/admin/foo", adminOnly(FooHandler)).Methods("GET")
-func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+//	api.HandleFunc("/admin/foo", adminOnly(adminPermView, FooHandler)).Methods("GET")
+func adminOnly(perm string, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		wantUser := os.Getenv("ADMIN_USER")
-		wantPass := os.Getenv("ADMIN_PASS")
-
-		if wantUser == "" || wantPass == "" {
-			http.Error(w, "admin auth not configured: set ADMIN_USER and ADMIN_PASS", http.StatusServiceUnavailable)
+		if db == nil {
+			http.Error(w, "admin auth unavailable: no database", http.StatusServiceUnavailable)
 			return
 		}
-
-		gotUser, gotPass, ok := r.BasicAuth()
-		userOK := ok && subtle.ConstantTimeCompare([]byte(gotUser), []byte(wantUser)) == 1
-		passOK := ok && subtle.ConstantTimeCompare([]byte(gotPass), []byte(wantPass)) == 1
-
-		if !userOK || !passOK {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user == "" {
 			w.Header().Set("WWW-Authenticate", `Basic realm="devf-admin"`)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		a, err := authenticateAdmin(r, user, pass)
+		switch {
+		case errors.Is(err, errRateLimitedAdmin):
+			writeRateLimited(w, "login")
+			return
+		case errors.Is(err, errAdminCredentials):
+			w.Header().Set("WWW-Authenticate", `Basic realm="devf-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Printf("admin auth: %v", err)
+			http.Error(w, "admin auth unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !a.can(perm) {
+			http.Error(w, "your admin role ("+a.Role+") does not allow this", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), adminContextKey, a))
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			h(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		recordAdminAction(a, perm, r, rec.status)
+	}
+}
 
-		h(w, r)
+// seedAdminFromEnv creates the first superuser from ADMIN_USER/ADMIN_PASS
+// while there are no admin accounts. Called once at boot.
+func seedAdminFromEnv() {
+	if db == nil {
+		return
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM admin_accounts`).Scan(&n); err != nil {
+		log.Printf("admin: counting accounts: %v", err)
+		return
 	}
+	user, pass := os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS")
+	if n > 0 {
+		if pass != "" {
+			log.Println("NOTE: ADMIN_PASS is set but admin accounts already exist, so it is " +
+				"not read. Unset it; manage admins under /admin/accounts.")
+		}
+		return
+	}
+	if user == "" || pass == "" {
+		return
+	}
+	hash, err := hashPassword(pass)
+	if err != nil {
+		log.Printf("admin: hashing bootstrap password: %v", err)
+		return
+	}
+	if _, err := db.Exec(`INSERT INTO admin_accounts (username, password_hash, role, created_by)
+		VALUES ($1, $2, $3, 'bootstrap') ON CONFLICT (username) DO NOTHING`, user, hash, adminRoleSuperuser); err != nil {
+		log.Printf("admin: creating bootstrap superuser: %v", err)
+		return
+	}
+	log.Printf("admin: created superuser %q from ADMIN_USER/ADMIN_PASS. Turn on TOTP and "+
+		"create named accounts for everyone else.", user)
 }
 
 // ════════════════════════════════════════════════════════════════════════════════
@@ -87,28 +357,35 @@ var weakAdminPasswords = map[string]bool{
 }
 
 // minAdminPassLen is the length below which we warn regardless of content. A
-// short password is brute-forceable even when it is not on the list above,
-// and the admin gate has no lockout of its own — it sits behind the global
-// per-IP limiter and nothing else.
+// short password is brute-forceable even when it is not on the list above;
+// the gate's only brake is the login rate limit.
 const minAdminPassLen = 12
 
+// weakAdminPassword reports whether a password should never guard an admin
+// account. New accounts and password changes are refused on it; the env
+// bootstrap password only warns (see checkAdminConfig).
+func weakAdminPassword(pass string) bool {
+	return len(pass) < minAdminPassLen || weakAdminPasswords[strings.ToLower(pass)]
+}
+
 // checkAdminConfig logs at startup when the admin credentials are missing or
 // obviously unrotated, so an operator finds out from the boot log rather than
 // from an incident.
 //
 // Deliberately warn-only, matching checkAuthConfig: refusing to boot over a
 // weak admin password would take the whole API down with it, and the API
-// serving users matters more than the dashboard being locked. The gate itself
-// already fails closed when the vars are unset, so an unconfigured admin
-// surface is unreachable rather than open — the warning is about the case
-// where it IS reachable and the key is one somebody would guess.
+// serving users matters more than the dashboard being locked. The vars only
+// matter until the first admin account exists (seedAdminFromEnv), and that
+// account keeps this password until someone changes it — the warning is
+// about the case where it is one somebody would guess.
 func checkAdminConfig() {
 	user := os.Getenv("ADMIN_USER")
 	pass := os.Getenv("ADMIN_PASS")
 
 	if user == "" || pass == "" {
-		log.Println("NOTE: ADMIN_USER / ADMIN_PASS are not set — every /admin route " +
-			"will answer 503 until they are. This is the safe default, not an error.")
+		log.Println("NOTE: ADMIN_USER / ADMIN_PASS are not set. If no admin account " +
+			"exists yet, nobody can sign in to /admin until they are. This is the safe " +
+			"default, not an error.")
 		return
 	}
 
@@ -120,8 +397,8 @@ func checkAdminConfig() {
 			"real traffic.")
 	case len(pass) < minAdminPassLen:
 		log.Printf("SECURITY WARNING: ADMIN_PASS is only %d characters. The admin gate "+
-			"has no lockout of its own, so short passwords are brute-forceable. Use at "+
-			"least %d characters.", len(pass), minAdminPassLen)
+			"has no lockout beyond the login rate limit, so short passwords are "+
+			"brute-forceable. Use at least %d characters.", len(pass), minAdminPassLen)
 	}
 
 	if strings.EqualFold(user, "admin") && len(pass) < minAdminPassLen {
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAdminRolePermissions(t *testing.T) {
+	cases := []struct {
+		role string
+		perm string
+		want bool
+	}{
+		{adminRoleViewer, adminPermView, true},
+		{adminRoleViewer, adminPermModerate, false},
+		{adminRoleModerator, adminPermModerate, true},
+		{adminRoleModerator, adminPermExperiment, false},
+		{adminRoleExperimenter, adminPermExperiment, true},
+		{adminRoleExperimenter, adminPermOperate, false},
+		{adminRoleSuperuser, adminPermOperate, true},
+		{adminRoleSuperuser, adminPermManage, true},
+		{"", adminPermView, false},
+	}
+	for _, c := range cases {
+		a := &adminAccount{Role: c.role}
+		if got := a.can(c.perm); got != c.want {
+			t.Errorf("%q.can(%q) = %v, want %v", c.role, c.perm, got, c.want)
+		}
+	}
+}
+
+func TestSplitAdminOTP(t *testing.T) {
+	for in, want := range map[string][2]string{
+		"correct horse battery staple492817": {"correct horse battery staple", "492817"},
+		"correct horse battery staple":       {"correct horse battery staple", ""},
+		"123456":                             {"123456", ""},
+		"hunter2hunter2":                     {"hunter2hunter2", ""},
+	} {
+		if pw, code := splitAdminOTP(in); pw != want[0] || code != want[1] {
+			t.Errorf("splitAdminOTP(%q) = %q, %q; want %q, %q", in, pw, code, want[0], want[1])
+		}
+	}
+}
+
+func expectAdminLookup(mock sqlmock.Sqlmock, role, hash, secret string, totp bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM admin_accounts WHERE username = $1")).WithArgs("ops").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "totp_active", "auth_version", "password_hash", "totp_secret"}).
+			AddRow(3, "ops", role, totp, 1, hash, secret))
+}
+
+// With TOTP on, the password alone is refused; with the code on the end it passes.
+func TestAuthenticateAdminTOTP(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	hash, err := hashPassword("a-long-admin-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := generateTOTPSecret()
+	code, _ := generateTOTPCode(secret, time.Now())
+	r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+
+	expectAdminLookup(mock, adminRoleModerator, hash, secret, true)
+	if _, err := authenticateAdmin(r, "ops", "a-long-admin-password"); err != errAdminCredentials {
+		t.Fatalf("password without code: err = %v", err)
+	}
+
+	expectAdminLookup(mock, adminRoleModerator, hash, secret, true)
+	mock.ExpectExec(regexp.QuoteMeta("SET last_login_at")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	a, err := authenticateAdmin(r, "ops", "a-long-admin-password"+code)
+	if err != nil || a.Username != "ops" || a.Role != adminRoleModerator {
+		t.Fatalf("authenticateAdmin = %+v, %v", a, err)
+	}
+
+	// The same credential again is recalled, and only re-checks the account.
+	mock.ExpectQuery(regexp.QuoteMeta("FROM admin_accounts\n\t\tWHERE id = $1")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "role", "totp_active", "auth_version"}).
+			AddRow("ops", adminRoleModerator, true, 1))
+	if _, err := authenticateAdmin(r, "ops", "a-long-admin-password"+code); err != nil {
+		t.Fatalf("recalled credential: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A viewer can't reseed, and the refusal never reaches the handler.
+func TestAdminOnlyRefusesMissingPermission(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	hash, _ := hashPassword("a-long-admin-password")
+	expectAdminLookup(mock, adminRoleViewer, hash, "", false)
+	mock.ExpectExec(regexp.QuoteMeta("SET last_login_at")).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ran := false
+	h := adminOnly(adminPermOperate, func(w http.ResponseWriter, r *http.Request) { ran = true })
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/reseed", nil)
+	req.SetBasicAuth("ops", "a-long-admin-password")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	if rec.Code != http.StatusForbidden || ran {
+		t.Fatalf("status = %d, handler ran = %v", rec.Code, ran)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A mutating request that passes is written to the audit log with its status.
+func TestAdminOnlyAuditsMutations(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	hash, _ := hashPassword("a-long-admin-password")
+	expectAdminLookup(mock, adminRoleExperimenter, hash, "", false)
+	mock.ExpectExec(regexp.QuoteMeta("SET last_login_at")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO admin_audit_log")).
+		WithArgs(3, "ops", adminRoleExperimenter, adminPermExperiment, "POST", "/api/v1/admin/experiments",
+			"/api/v1/admin/experiments", "null", http.StatusBadRequest, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	h := adminOnly(adminPermExperiment, func(w http.ResponseWriter, r *http.Request) {
+		if currentAdmin(r).Username != "ops" {
+			t.Errorf("handler saw admin %+v", currentAdmin(r))
+		}
+		http.Error(w, "bad experiment", http.StatusBadRequest)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/experiments", nil)
+	req.SetBasicAuth("ops", "a-long-admin-password")
+	h(httptest.NewRecorder(), req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWeakAdminPassword(t *testing.T) {
+	for _, p := range []string{"short", "administrator", "Password123", "changeme"} {
+		if !weakAdminPassword(p) {
+			t.Errorf("weakAdminPassword(%q) = false", p)
+		}
+	}
+	if weakAdminPassword("a-long-admin-password") {
+		t.Error("refused a reasonable password")
+	}
+}
@@ -67,6 +67,7 @@ type DiagnosticsReport struct {
 	ImpressionStats    map[string]interface{} `json:"impressionStats"`
 	SessionState       map[string]interface{} `json:"sessionState"`
 	CalibrationParams  map[string]interface{} `json:"calibrationParams"`
+	ModelVersions      map[string]interface{} `json:"modelVersions"`
 	EmbedCacheSpotChk  map[string]interface{} `json:"embedCacheSpotCheck"`
 }
 
@@ -117,6 +118,7 @@ func buildUserDiagnosticsReport(_ context.Context, userID string) *DiagnosticsRe
 		ImpressionStats:   make(map[string]interface{}),
 		SessionState:      make(map[string]interface{}),
 		CalibrationParams: make(map[string]interface{}),
+		ModelVersions:     make(map[string]interface{}),
 		EmbedCacheSpotChk: make(map[string]interface{}),
 	}
 
@@ -133,6 +135,7 @@ func buildUserDiagnosticsReport(_ context.Context, userID string) *DiagnosticsRe
 	probeImpressionStats(report, userID)
 	probeSessionState(report, userID)
 	probeCalibration(report)
+	probeModelVersions(report, userID)
 	probeEmbedCacheSpot(report)
 
 	return report
@@ -463,6 +466,28 @@ func probeCalibration(r *DiagnosticsReport) {
 	r.Summary["calibrationParams"] = "OK"
 }
 
+// probeModelVersions shows the registry as this replica sees it and which
+// version served each of the user's recent For You pages (model_registry.go).
+func probeModelVersions(r *DiagnosticsReport, userID string) {
+	view := currentModelRegistry()
+	r.ModelVersions["registry"] = view.modelRegistryState
+	if rdb == nil {
+		r.Summary["modelVersions"] = "SKIPPED — redis nil"
+		return
+	}
+	recent, err := recentModelVersionsServed(userID)
+	if err != nil {
+		r.Summary["modelVersions"] = "ERROR — " + err.Error()
+		return
+	}
+	r.ModelVersions["recentRequests"] = recent
+	if len(recent) == 0 {
+		r.Summary["modelVersions"] = "EMPTY — no For You pages served to this user in the last week"
+		return
+	}
+	r.Summary["modelVersions"] = fmt.Sprintf("OK — last page %s served by v%d", recent[0].RequestID, recent[0].Serving)
+}
+
 func probeEmbedCacheSpot(r *DiagnosticsReport) {
 	if rdb == nil {
 		r.Summary["embedCacheSpotCheck"] = "SKIPPED — redis nil"
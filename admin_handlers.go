@@ -19,9 +19,10 @@ import (
 //   GET /api/v1/admin/errors     → JSON: error counts by surface + errorType (7d)
 //   GET /admin                   → inline HTML dashboard that fetches above
 //
-// Auth: every route in this file is wrapped in adminOnly() (HTTP Basic,
-// ADMIN_USER/ADMIN_PASS — see admin_auth.go) at registration time in
-// main.go. Handlers here assume the wrapper has already vetted the caller.
+// Auth: every route in this file is wrapped in adminOnly(adminPermView, …)
+// (HTTP Basic against admin_accounts — see admin_auth.go) at registration
+// time in main.go. Handlers here assume the wrapper has already vetted the
+// caller.
 
 // AdminFunnelsHandler returns per-uploadType funnel counts for the last 7 days.
 // Response shape:
@@ -132,8 +133,17 @@ func AdminErrorsHandler(w http.ResponseWriter, r *http.Request) {
 // AdminHealthHandler returns the last analytics batch run: when it started,
 // how long it took, which sub-jobs ran, how many users each covered, any errors.
 // Use this to spot silent nightly failures.
+//
+// It also carries the account purge job's state (accountPurge): how many
+// deleted accounts are waiting out their grace period, how many are past it
+// and not yet purged, and how many purges are failing.
 func AdminHealthHandler(w http.ResponseWriter, r *http.Request) {
-	h := SnapshotAnalyticsHealth()
+	// The analytics snapshot's fields stay at the top level, where the
+	// dashboard reads them; other jobs report alongside.
+	h := struct {
+		AnalyticsHealthSnapshot
+		AccountPurge AccountPurgeHealth `json:"accountPurge"`
+	}{SnapshotAnalyticsHealth(), accountPurgeHealth()}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(h)
 }
@@ -210,7 +220,12 @@ code{background:#222;padding:1px 5px;border-radius:3px;color:#ffb}
 <h2>Errors by surface</h2>
 <div id="errors">loading…</div>
 
+<h2>Admin audit log</h2>
+<div class="tiny">Every admin request that changed something, newest first. Append-only.</div>
+<div id="audit">loading…</div>
+
 <script>
+function esc(s){return String(s==null?'':s).replace(/[&<>"]/g,c=>({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;'}[c]))}
 function rateClass(r){if(r<0.3)return 'low';if(r<0.6)return 'mid';return 'hi'}
 function fmtAgo(iso){
   if(!iso||iso.startsWith('0001-01-01'))return 'never';
@@ -233,6 +248,10 @@ async function render(){
       hh += '<tr><td><code>'+name+'</code></td><td>'+(r.users||0)+'</td><td>'+(r.duration||'—')+'</td><td>'+errCell+'</td></tr>';
     }
     hh += '</table>';
+    const p = h.accountPurge || {};
+    hh += '<div class="tiny">account purge · '+(p.pending||0)+' in grace period · '+(p.due||0)+' due · '+(p.purgedLast7d||0)+' purged in 7d · last purge '+fmtAgo(p.lastPurgedAt);
+    if(p.failing) hh += ' · <span class="pct low">'+p.failing+' FAILING: '+esc(p.lastError)+'</span>';
+    hh += '</div>';
     document.getElementById('health').innerHTML = hh;
   }catch(e){document.getElementById('health').textContent='error: '+e}
 
@@ -258,6 +277,18 @@ async function render(){
     eh += '</table>';
     document.getElementById('errors').innerHTML = eh;
   }catch(e){document.getElementById('errors').textContent='error: '+e}
+
+  try{
+    const a = await fetch('/api/v1/admin/audit-log?limit=50').then(r=>r.json());
+    let ah = '<table><tr><th>when</th><th>admin</th><th>role</th><th>request</th><th>status</th><th>ip</th></tr>';
+    for(const r of (a.entries||[])){
+      const cls = r.status>=400 ? 'pct low' : '';
+      ah += '<tr><td>'+fmtAgo(r.createdAt)+'</td><td>'+esc(r.admin)+'</td><td>'+esc(r.role)+'</td><td><code>'+esc(r.method)+' '+esc(r.path)+'</code></td><td class="'+cls+'">'+r.status+'</td><td>'+esc(r.ip)+'</td></tr>';
+    }
+    if(!(a.entries||[]).length) ah += '<tr><td colspan="6" style="color:#7a7c85">no admin actions yet</td></tr>';
+    ah += '</table>';
+    document.getElementById('audit').innerHTML = ah;
+  }catch(e){document.getElementById('audit').textContent='error: '+e}
 }
 render();
 </script>
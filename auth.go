@@ -27,13 +27,13 @@ import (
 // Handlers then call authUserID(r) instead of reading a client-supplied id, so a
 // caller can never act on behalf of another user by lying in the payload.
 //
-// Stateless by design: HMAC verification is deterministic, so every Render replica
-// validates the same token with zero shared state. (Redis is available if we ever
-// want a revocation list — see redis.go.) Tokens are short-lived to bound the blast
-// radius of a leak in lieu of server-side revocation.
+// HMAC verification is deterministic, so every Render replica validates the same
+// token with zero shared state. What a signature can't say is whether the user
+// has since signed that device out: tokens carry a session id (jti) and authed()
+// also checks that the session still stands — see sessions.go.
 
-// tokenTTL is how long an issued session token stays valid. Kept short-ish because
-// we have no revocation list yet; the client transparently re-logs in on a 401.
+// tokenTTL is how long an issued access token stays valid. The client refreshes
+// well before this (sessions.go); on a 401 it transparently re-logs in.
 const tokenTTL = 7 * 24 * time.Hour
 
 // authClaims is the JWT payload. Subject carries the canonical user id (the same
@@ -49,8 +49,9 @@ type authClaims struct {
 type ctxKey string
 
 const (
-	userIDContextKey   ctxKey = "authUserID"
-	usernameContextKey ctxKey = "authUsername"
+	userIDContextKey    ctxKey = "authUserID"
+	usernameContextKey  ctxKey = "authUsername"
+	sessionIDContextKey ctxKey = "authSessionID"
 )
 
 // authSecret returns the signing key, or an error if JWT_SECRET is unset. We read
@@ -75,8 +76,16 @@ func checkAuthConfig() {
 	}
 }
 
-// issueToken mints a signed session token for the given user.
+// issueToken mints a signed token for the given user with no session behind
+// it. Sign-in goes through startSession instead; this remains for tooling
+// and tests.
 func issueToken(userID, username string) (string, error) {
+	return issueSessionToken(userID, username, "")
+}
+
+// issueSessionToken mints a signed access token for one of the user's
+// sessions. The session id rides in the jti claim.
+func issueSessionToken(userID, username, sessionID string) (string, error) {
 	secret, err := authSecret()
 	if err != nil {
 		return "", err
@@ -86,6 +95,7 @@ func issueToken(userID, username string) (string, error) {
 		Username: username,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID,
+			ID:        sessionID,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(tokenTTL)),
 		},
@@ -137,6 +147,16 @@ func bearerToken(r *http.Request) string {
 // it via authUserID(r). CORS preflights never reach here (corsMiddleware answers
 // OPTIONS before routing), so no OPTIONS bypass is needed.
 func authed(h http.HandlerFunc) http.HandlerFunc {
+	return authedWith(h, false)
+}
+
+// authedAllowSuspended is authed for the few routes a suspended account still
+// needs — seeing why, and appealing. See enforcement.go.
+func authedAllowSuspended(h http.HandlerFunc) http.HandlerFunc {
+	return authedWith(h, true)
+}
+
+func authedWith(h http.HandlerFunc, allowSuspended bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tok := bearerToken(r)
 		if tok == "" {
@@ -148,8 +168,21 @@ func authed(h http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "invalid or expired token", http.StatusUnauthorized)
 			return
 		}
+		if err := verifySession(claims); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		// A suspension ends every session the account already holds, not
+		// just the next sign-in. See enforcement.go.
+		if !allowSuspended {
+			if rs, suspended := moderationRestriction(claims.Subject, restrictSuspended); suspended {
+				writeRestricted(w, rs)
+				return
+			}
+		}
 		ctx := context.WithValue(r.Context(), userIDContextKey, claims.Subject)
 		ctx = context.WithValue(ctx, usernameContextKey, claims.Username)
+		ctx = context.WithValue(ctx, sessionIDContextKey, claims.ID)
 		h(w, r.WithContext(ctx))
 	}
 }
@@ -162,6 +195,25 @@ func authUserID(r *http.Request) string {
 	return v
 }
 
+// optionalAuthUserID is authUserID for public routes, which never pass
+// through authed(): the signed-in user if the request carries a valid
+// session token, "" otherwise. A bad or expired token counts as no token —
+// these routes serve anyone, they only show strangers less.
+func optionalAuthUserID(r *http.Request) string {
+	if uid := authUserID(r); uid != "" {
+		return uid
+	}
+	tok := bearerToken(r)
+	if tok == "" {
+		return ""
+	}
+	claims, err := parseToken(tok)
+	if err != nil || verifySession(claims) != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
 // authUsername returns the trusted username from the token, or "" if absent.
 // Used where a display name is persisted (comments, chat) so a client can't
 // attach someone else's handle to its content.
@@ -182,3 +234,10 @@ func requirePathUser(w http.ResponseWriter, r *http.Request, pathUserID string)
 	}
 	return uid, true
 }
+
+// authSessionID returns the session the request's token belongs to, or "" for
+// a token from before sessions.
+func authSessionID(r *http.Request) string {
+	v, _ := r.Context().Value(sessionIDContextKey).(string)
+	return v
+}
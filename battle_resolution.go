@@ -0,0 +1,494 @@
+package main
+
+// battle_resolution.go — close battles, crown a winner, move people up and down
+// the league ladder.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE PROBLEM THIS FIXES
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// A battle could be voted on forever and never end. CastVote wrote a row,
+// GetVoteSummary counted the rows, and that was the whole lifecycle. Nothing
+// ever wrote users.wins or users.losses, nothing ever changed users.league, and
+// challenges.status stayed 'active' for good — while the client counted down to
+// an expiresAt that nothing on the server honoured. Every record on every
+// profile was 0–0 Bronze, and the league gate in challenge_handler.go was
+// comparing numbers nobody could ever change.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// HOW
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// A background pass finds live battles that are due and settles each one:
+//
+//	due       — expiresAt has passed, or the battle has drawn enough votes to
+//	            call early (battleVoteQuorum) AND somebody is clearly ahead.
+//	winner    — most votes. Ties go to the side that reached its tally first.
+//	            A tie that survives that is a draw: no winner, and nobody's
+//	            record moves.
+//	records   — the winner gets a win, every other participant a loss, and
+//	            everybody's rating moves (ratings.go), which in turn may move
+//	            their league.
+//	telling   — everybody who took part gets an in-app notification with the
+//	            result, and a second one if their league changed.
+//
+// The status change and every record change land in ONE transaction with the
+// challenge row locked. Either the battle is closed and every record reflects
+// it, or none of it happened and the next pass tries again. There is no state
+// where a battle is 'completed' and somebody is missing their win.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT THIS DELIBERATELY DOES NOT DO
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// It never touches an unanswered challenge. A challenge nobody has responded to
+// plays in the feed as an ordinary short, and every feed query reads status
+// 'open' for that. Marking those 'expired' would quietly delete most of the
+// catalogue from every feed at once.
+//
+// A battle nobody voted on is still closed — as 'completed' with no winner —
+// rather than 'expired'. The feeds read 'completed'; they do not read
+// 'expired', and a finished battle is still a perfectly good video to watch.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// challengeLifetime is how long a challenge stays open for votes, and what the
+// client's expiresAt countdown has always measured. The SQL in the feed
+// queries spells the same value as INTERVAL '24 hours'.
+const challengeLifetime = 24 * time.Hour
+
+// battleVoteQuorum is the vote count at which a battle may be called before
+// its time is up.
+//
+// Only with a clear leader: a battle tied at the quorum keeps running to its
+// deadline, because calling it on a timestamp tie-break at minute forty would
+// feel like a coin toss to both people in it.
+const battleVoteQuorum = 50
+
+// battleResolveInterval is how often the resolver looks for due battles. A
+// minute of lateness on a 24-hour clock is not something anyone notices.
+const battleResolveInterval = time.Minute
+
+// battleResolveBatch caps how many battles one pass settles. A backlog (the
+// first pass after this shipped, or after an outage) drains over several
+// passes instead of holding a transaction open per battle for minutes.
+const battleResolveBatch = 100
+
+// creatorVoteSide is the responseId a voter sends to vote for the challenger's
+// own video. It is stored as a NULL response_id — the challenger's video has no
+// response row to point at.
+const creatorVoteSide = "creator"
+
+// ════════════════════════════════════════════════════════════════════════════════
+// PICKING A WINNER
+// ════════════════════════════════════════════════════════════════════════════════
+
+// battleSide is one votable video in a battle and the votes it drew.
+type battleSide struct {
+	// responseID is the response row, or "" for the challenger's own video.
+	responseID string
+	userID     string
+	username   string
+	votes      int
+	// weight is the side's weighted tally (vote_integrity.go) — what decides
+	// the battle. votes is the raw count the public counter shows.
+	weight float64
+	// reachedAt is when this side's last vote arrived — the moment it reached
+	// its final tally. The first tie-break.
+	reachedAt time.Time
+}
+
+// pickBattleWinner returns the index of the winning side, or -1 for no winner.
+//
+// The rules, in order:
+//  1. Most votes wins — weighted votes, see vote_integrity.go.
+//  2. Level on votes: whoever got there first wins. The side that reached the
+//     tally earlier held the lead while the other was catching up.
+//  3. Still level — including the nobody-voted case — is a draw.
+//
+// clearLead reports whether the winner won on votes alone, without needing a
+// tie-break. That is what decides whether a battle can be called early.
+//
+// Pure, because this is the function that decides who wins somebody's battle
+// and it should be readable and testable without a database.
+func pickBattleWinner(sides []battleSide) (winner int, clearLead bool) {
+	if len(sides) == 0 {
+		return -1, false
+	}
+	order := make([]int, len(sides))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := sides[order[a]], sides[order[b]]
+		if sa.weight != sb.weight {
+			return sa.weight > sb.weight
+		}
+		return sa.reachedAt.Before(sb.reachedAt)
+	})
+
+	top := sides[order[0]]
+	if top.weight == 0 {
+		return -1, false
+	}
+	if len(order) == 1 {
+		return order[0], true
+	}
+	next := sides[order[1]]
+	if next.weight < top.weight {
+		return order[0], true
+	}
+	if top.reachedAt.Before(next.reachedAt) {
+		return order[0], false
+	}
+	return -1, false
+}
+
+// battleDue reports whether a battle should be closed now.
+func battleDue(createdAt, now time.Time, totalVotes int, clearLead bool) bool {
+	if !now.Before(createdAt.Add(challengeLifetime)) {
+		return true
+	}
+	return totalVotes >= battleVoteQuorum && clearLead
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE WORKER
+// ════════════════════════════════════════════════════════════════════════════════
+
+// startBattleResolver runs the resolver forever.
+//
+// Safe on several instances at once: each battle is settled inside a
+// transaction that locks its challenge row and re-checks that it is still
+// unresolved, so the second instance to reach a battle finds it already closed
+// and does nothing.
+func startBattleResolver() {
+	go func() {
+		t := time.NewTicker(battleResolveInterval)
+		defer t.Stop()
+		for range t.C {
+			if err := runBattleResolution(context.Background()); err != nil {
+				log.Printf("battle resolver: %v", err)
+			}
+		}
+	}()
+}
+
+// runBattleResolution does one pass over the battles that might be due.
+func runBattleResolution(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	ids, err := battlesMaybeDue(ctx, battleResolveBatch)
+	if err != nil {
+		return err
+	}
+
+	closed := 0
+	for _, id := range ids {
+		res, err := resolveBattle(ctx, id, time.Now())
+		if err != nil {
+			log.Printf("battle resolver: challenge %s: %v", id, err)
+			if metricBattlesResolved != nil {
+				metricBattlesResolved.WithLabelValues("error").Inc()
+			}
+			continue
+		}
+		if res == nil {
+			continue
+		}
+		closed++
+		outcome := "won"
+		if res.winner < 0 {
+			outcome = "no_winner"
+		}
+		if metricBattlesResolved != nil {
+			metricBattlesResolved.WithLabelValues(outcome).Inc()
+		}
+		go notifyBattleResult(*res)
+	}
+	if closed > 0 {
+		log.Printf("battle resolver: closed %d battle(s).", closed)
+	}
+	return nil
+}
+
+// battlesMaybeDue lists live battles that are past their deadline or have
+// reached the vote quorum, oldest first. "Maybe", because a battle at quorum
+// without a clear leader is not due yet — that is decided per battle, with the
+// tallies in hand.
+func battlesMaybeDue(ctx context.Context, limit int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT CAST(c.id AS TEXT)
+		FROM challenges c
+		WHERE c.status = 'active'
+		  AND c.resolved_at IS NULL
+		  AND (c.created_at <= NOW() - ($1)::interval
+		       OR (SELECT COUNT(*) FROM challenge_votes cv WHERE cv.challenge_id = c.id) >= $2)
+		ORDER BY c.created_at ASC
+		LIMIT $3`,
+		fmt.Sprintf("%d seconds", int(challengeLifetime.Seconds())), battleVoteQuorum, limit)
+	if err != nil {
+		return nil, fmt.Errorf("finding battles due for a result: %w", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			out = append(out, id)
+		}
+	}
+	return out, rows.Err()
+}
+
+// battleResult is a settled battle, carried out of the transaction so the
+// notifications can go out after it commits.
+type battleResult struct {
+	challengeID string
+	title       string
+	sides       []battleSide
+	winner      int // index into sides, -1 for no winner
+	// leagues maps user id → [league before, league after], for users whose
+	// league changed.
+	leagues map[string][2]string
+}
+
+// errBattleClosed is returned to a voter whose vote arrived after the battle
+// was settled.
+var errBattleClosed = errors.New("this battle has already been decided")
+
+// resolveBattle settles one battle if it is due. Returns nil, nil when there
+// was nothing to do — already settled by another instance, or at quorum but
+// still too close to call.
+func resolveBattle(ctx context.Context, challengeID string, now time.Time) (*battleResult, error) {
+	cid, err := strconv.Atoi(challengeID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge id %q", challengeID)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }() // no-op once committed
+
+	// Lock the row first. Everything after this reads a battle nobody else
+	// can be settling at the same moment.
+	var (
+		creatorID, creatorName, prefix, subject, category, status string
+		createdAt                                                 time.Time
+		resolvedAt                                                sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT CAST(c.creator_id AS TEXT), u.username, c.prefix, c.subject,
+		       COALESCE(c.category, 'other'), c.status, c.created_at, c.resolved_at
+		FROM challenges c
+		JOIN users u ON u.id = c.creator_id
+		WHERE c.id = $1
+		FOR UPDATE OF c`, cid).
+		Scan(&creatorID, &creatorName, &prefix, &subject, &category, &status, &createdAt, &resolvedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil // deleted since it was listed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("locking the battle: %w", err)
+	}
+	if status != "active" || resolvedAt.Valid {
+		return nil, nil
+	}
+
+	sides, err := loadBattleSides(ctx, tx, cid, creatorID, creatorName)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, s := range sides {
+		total += s.votes
+	}
+	winner, clear := pickBattleWinner(sides)
+	if !battleDue(createdAt, now, total, clear) {
+		return nil, nil
+	}
+
+	var winnerID, winnerResp any
+	if winner >= 0 {
+		winnerID = sides[winner].userID
+		if sides[winner].responseID != "" {
+			winnerResp = sides[winner].responseID
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE challenges
+		   SET status = 'completed',
+		       winner_id = $2,
+		       winner_response_id = $3,
+		       resolved_at = NOW()
+		 WHERE id = $1`, cid, winnerID, winnerResp); err != nil {
+		return nil, fmt.Errorf("closing the battle: %w", err)
+	}
+
+	res := &battleResult{
+		challengeID: challengeID,
+		title:       prefix + " " + subject,
+		sides:       sides,
+		winner:      winner,
+		leagues:     map[string][2]string{},
+	}
+	if winner >= 0 {
+		leagues, err := applyBattleRecords(ctx, tx, sides, winner)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyBattleRatings(ctx, tx, cid, category, sides, winner, now, leagues, res.leagues); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return res, nil
+}
+
+// loadBattleSides reads every votable side of a battle and its tally: the
+// challenger's own video plus each visible response. Hidden responses (taken
+// down by community flags) are not sides — a video the app no longer shows
+// cannot win.
+func loadBattleSides(ctx context.Context, tx *sql.Tx, cid int, creatorID, creatorName string) ([]battleSide, error) {
+	sides := []battleSide{{userID: creatorID, username: creatorName}}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT CAST(cr.id AS TEXT), CAST(cr.responder_id AS TEXT), u.username
+		FROM challenge_responses cr
+		JOIN users u ON u.id = cr.responder_id
+		WHERE cr.challenge_id = $1 AND COALESCE(cr.is_hidden, FALSE) = FALSE
+		ORDER BY cr.created_at ASC`, cid)
+	if err != nil {
+		return nil, fmt.Errorf("reading responses: %w", err)
+	}
+	byResponse := map[string]int{"": 0}
+	for rows.Next() {
+		var s battleSide
+		if rows.Scan(&s.responseID, &s.userID, &s.username) == nil {
+			byResponse[s.responseID] = len(sides)
+			sides = append(sides, s)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading responses: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT COALESCE(CAST(response_id AS TEXT), ''), COUNT(*), SUM(weight), MAX(created_at)
+		FROM challenge_votes
+		WHERE challenge_id = $1
+		GROUP BY response_id`, cid)
+	if err != nil {
+		return nil, fmt.Errorf("reading votes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rid string
+		var n int
+		var w float64
+		var last time.Time
+		if rows.Scan(&rid, &n, &w, &last) != nil {
+			continue
+		}
+		if i, ok := byResponse[rid]; ok {
+			sides[i].votes = n
+			sides[i].weight = w
+			sides[i].reachedAt = last
+		}
+	}
+	return sides, rows.Err()
+}
+
+// applyBattleRecords writes a win to the winner and a loss to everyone else,
+// and returns each participant's league going in. Runs inside the battle's
+// transaction.
+//
+// Someone on two sides of the same battle (a challenger answering their own
+// challenge) is counted once: the win if either side won, otherwise one loss.
+func applyBattleRecords(ctx context.Context, tx *sql.Tx, sides []battleSide, winner int) (map[string]string, error) {
+	winnerID := sides[winner].userID
+	leagues := map[string]string{}
+	for _, s := range sides {
+		if _, done := leagues[s.userID]; done {
+			continue
+		}
+
+		col := "losses"
+		if s.userID == winnerID {
+			col = "wins"
+		}
+		var league string
+		err := tx.QueryRowContext(ctx, `
+			UPDATE users SET `+col+` = `+col+` + 1
+			 WHERE id = CAST($1 AS INT)
+			RETURNING COALESCE(league, 'Bronze')`, s.userID).
+			Scan(&league)
+		if err == sql.ErrNoRows {
+			continue // account deleted mid-battle
+		}
+		if err != nil {
+			return nil, fmt.Errorf("recording %s for user %s: %w", col, s.userID, err)
+		}
+		leagues[s.userID] = league
+	}
+	return leagues, nil
+}
+
+// notifyBattleResult tells everyone in a settled battle how it went, through
+// the same in-app path votes and accepts use: straight down the socket when
+// they are online, stored for their next connect when they are not.
+func notifyBattleResult(res battleResult) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	told := map[string]bool{}
+	for i, s := range res.sides {
+		if told[s.username] || s.username == "" {
+			continue
+		}
+		told[s.username] = true
+
+		var msg string
+		switch {
+		case res.winner < 0:
+			msg = fmt.Sprintf("\"%s\" has ended with no winner.", res.title)
+		case res.sides[res.winner].userID == s.userID:
+			msg = fmt.Sprintf("You won \"%s\" with %d votes!", res.title, res.sides[res.winner].votes)
+		default:
+			msg = fmt.Sprintf("\"%s\" is over — %s won with %d votes. You got %d.",
+				res.title, res.sides[res.winner].username, res.sides[res.winner].votes, res.sides[i].votes)
+		}
+		deliverNotification(s.username, Notification{
+			Type:      "battle_result",
+			Message:   msg,
+			Timestamp: now,
+		})
+
+		if change, ok := res.leagues[s.userID]; ok {
+			verb := "promoted"
+			if ladderRung(change[1]) < ladderRung(change[0]) {
+				verb = "moved down"
+			}
+			deliverNotification(s.username, Notification{
+				Type:      "league_change",
+				Message:   fmt.Sprintf("You've been %s to %s.", verb, change[1]),
+				Timestamp: now,
+			})
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Who wins somebody's battle is the decision here nobody can check by eye
+// afterwards, so it is pinned as pure functions. The transaction is pinned with
+// sqlmock, for the parts that matter most: a battle is settled at most once,
+// and its result, records and ratings land together.
+
+// ── Picking a winner ────────────────────────────────────────────────────────
+
+func TestPickBattleWinner_MostVotesWins(t *testing.T) {
+	now := time.Now()
+	sides := []battleSide{
+		{userID: "1", votes: 4, weight: 4, reachedAt: now},
+		{userID: "2", votes: 9, weight: 9, reachedAt: now.Add(time.Hour)},
+	}
+	w, clear := pickBattleWinner(sides)
+	if w != 1 || !clear {
+		t.Errorf("got winner %d clear=%v, want 1 clear=true", w, clear)
+	}
+}
+
+func TestPickBattleWinner_TieGoesToWhoeverGotThereFirst(t *testing.T) {
+	now := time.Now()
+	sides := []battleSide{
+		{userID: "1", votes: 7, weight: 7, reachedAt: now.Add(time.Minute)},
+		{userID: "2", votes: 7, weight: 7, reachedAt: now},
+	}
+	w, clear := pickBattleWinner(sides)
+	if w != 1 {
+		t.Errorf("got winner %d, want 1 (reached 7 votes first)", w)
+	}
+	if clear {
+		t.Error("a win on the tie-break is not a clear lead and must not end a battle early")
+	}
+}
+
+func TestPickBattleWinner_WeightDecidesNotHeadcount(t *testing.T) {
+	// Twelve votes from fresh accounts against eight established ones. The
+	// public counter says 12–8; the battle goes to the side the weighted
+	// tally favours.
+	now := time.Now()
+	sides := []battleSide{
+		{userID: "1", votes: 12, weight: 3.5, reachedAt: now},
+		{userID: "2", votes: 8, weight: 7.6, reachedAt: now},
+	}
+	if w, _ := pickBattleWinner(sides); w != 1 {
+		t.Errorf("got winner %d, want 1 (more weight, fewer heads)", w)
+	}
+}
+
+func TestPickBattleWinner_NoWinner(t *testing.T) {
+	now := time.Now()
+	cases := map[string][]battleSide{
+		"nobody voted":    {{userID: "1"}, {userID: "2"}},
+		"dead heat":       {{userID: "1", votes: 3, weight: 3, reachedAt: now}, {userID: "2", votes: 3, weight: 3, reachedAt: now}},
+		"no sides at all": nil,
+	}
+	for name, sides := range cases {
+		if w, _ := pickBattleWinner(sides); w != -1 {
+			t.Errorf("%s: got winner %d, want none", name, w)
+		}
+	}
+}
+
+func TestBattleDue(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	if battleDue(created, time.Now(), battleVoteQuorum-1, true) {
+		t.Error("under quorum and inside the window: not due")
+	}
+	if !battleDue(created, time.Now(), battleVoteQuorum, true) {
+		t.Error("at quorum with a clear leader: due")
+	}
+	if battleDue(created, time.Now(), battleVoteQuorum*2, false) {
+		t.Error("at quorum but level: keeps running to the deadline")
+	}
+	if !battleDue(created, created.Add(challengeLifetime), 0, false) {
+		t.Error("at the deadline: due, whatever the votes")
+	}
+}
+
+// ── Settling a battle ───────────────────────────────────────────────────────
+
+func TestResolveBattle_AlreadySettledIsANoOp(t *testing.T) {
+	// Two instances reach the same battle. The second one locks the row after
+	// the first committed, sees it closed, and must not hand out a second set
+	// of wins and losses.
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM challenges c\s+JOIN users u .*FOR UPDATE OF c`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"creator_id", "username", "prefix", "subject", "category", "status", "created_at", "resolved_at"}).
+			AddRow("1", "ana", "Who is better", "Dancer", "dance", "completed", time.Now().Add(-48*time.Hour), time.Now()))
+	mock.ExpectRollback()
+
+	res, err := resolveBattle(context.Background(), "7", time.Now())
+	if err != nil || res != nil {
+		t.Fatalf("got %+v, %v; want nil, nil", res, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResolveBattle_RecordsWinLossAndRatingsInOneTransaction(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	now := time.Now()
+	ratingCols := []string{"user_id", "rating", "deviation", "volatility", "battles", "updated_at"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF c`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"creator_id", "username", "prefix", "subject", "category", "status", "created_at", "resolved_at"}).
+			AddRow("1", "ana", "Who is better", "Dancer", "dance", "active", now.Add(-25*time.Hour), nil))
+	mock.ExpectQuery(`FROM challenge_responses cr`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "responder_id", "username"}).
+			AddRow("30", "2", "ben"))
+	mock.ExpectQuery(`FROM challenge_votes`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"response_id", "count", "sum", "max"}).
+			AddRow("", 3, 3.0, now.Add(-2*time.Hour)).
+			AddRow("30", 5, 4.2, now.Add(-3*time.Hour)))
+	mock.ExpectExec(`UPDATE challenges\s+SET status = 'completed'`).
+		WithArgs(7, "2", "30").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// ana (the challenger) lost, ben won.
+	mock.ExpectQuery(`UPDATE users SET losses = losses \+ 1`).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"league"}).AddRow("Bronze"))
+	mock.ExpectQuery(`UPDATE users SET wins = wins \+ 1`).
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"league"}).AddRow("Bronze"))
+
+	// Ratings: first in the battle's category (both new to it), then overall,
+	// where ben is an established player good enough to leave Bronze.
+	for _, cat := range []string{"dance", overallRatingCategory} {
+		mock.ExpectExec(`INSERT INTO user_ratings`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		rows := sqlmock.NewRows(ratingCols).
+			AddRow("1", 1500.0, 350.0, 0.06, 0, now)
+		if cat == overallRatingCategory {
+			rows.AddRow("2", 1700.0, 60.0, 0.06, 40, now.Add(-time.Hour))
+		} else {
+			rows.AddRow("2", 1500.0, 350.0, 0.06, 0, now)
+		}
+		mock.ExpectQuery(`FROM user_ratings\s+WHERE category = \$1 .*FOR UPDATE`).
+			WillReturnRows(rows)
+		for _, uid := range []string{"1", "2"} {
+			mock.ExpectExec(`UPDATE user_ratings`).
+				WithArgs(uid, cat, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`INSERT INTO rating_history`).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			if cat == overallRatingCategory && uid == "2" {
+				// One step only, however far up the rating now reads.
+				mock.ExpectExec(`UPDATE users SET league = \$2`).
+					WithArgs("2", "Silver").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+		}
+	}
+	mock.ExpectCommit()
+
+	res, err := resolveBattle(context.Background(), "7", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.winner != 1 {
+		t.Fatalf("got %+v, want ben's side to win", res)
+	}
+	if got := res.leagues["2"]; got != [2]string{"Bronze", "Silver"} {
+		t.Errorf("league change = %v, want Bronze → Silver", got)
+	}
+	if _, moved := res.leagues["1"]; moved {
+		t.Error("ana is new and lost: nowhere to move")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCastVote_RefusedOnceTheBattleIsDecided(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(`FROM users u`).WithArgs("3").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM challenges WHERE id = \$1 FOR SHARE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"creator_id", "status", "resolved"}).
+			AddRow(1, "completed", true))
+	mock.ExpectRollback()
+
+	_, err := CastVote(ChallengeVotePayload{ChallengeID: "7", ResponseID: creatorVoteSide, VoterID: "3"})
+	if !errors.Is(err, errBattleClosed) {
+		t.Errorf("got %v, want errBattleClosed", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
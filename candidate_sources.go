@@ -140,6 +140,12 @@ func multiSourceFetchForCohort(userID string, totalLimit int, cohort Cohort) ([]
 			if seen[key] {
 				continue
 			}
+			// Limited distribution: a restricted creator's content still
+			// reaches their followers but no other source may surface it.
+			// See enforcement.go.
+			if src.name != "follow" && moderationLimited(getItemCreatorID(it)) {
+				continue
+			}
 			seen[key] = true
 			bySource[src.name] = append(bySource[src.name], it)
 			itemSource[key] = src.name
@@ -173,6 +179,9 @@ func buildSourcesForCohort(cohort Cohort) []candidateSource {
 		// and its permanent audition eligibility never gets to mean anything.
 		// See audition.go.
 		{name: "audition", weight: auditionSourceWeight, fetch: sourceAudition},
+		// Live tournament matchups, closing soonest first. A matchup is only
+		// worth showing while its vote is open. See tournament.go.
+		{name: "tournament", weight: tournamentSourceWeight, fetch: sourceTournament},
 	}
 }
 
@@ -221,6 +230,7 @@ func buildDefaultSources() []candidateSource {
 		{name: "searchAffinity", weight: defaultSourceWeights["searchAffinity"], fetch: sourceSearchAffinity},
 		// Same reasoning as the cohort build above — see audition.go.
 		{name: "audition", weight: auditionSourceWeight, fetch: sourceAudition},
+		{name: "tournament", weight: tournamentSourceWeight, fetch: sourceTournament},
 	}
 }
 
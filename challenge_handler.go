@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -11,8 +12,9 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// leagueTier maps league names to numeric tiers for matchmaking comparison.
-// Users can only challenge within ±2 tiers for fair play.
+// leagueTier maps league names to numeric tiers. Leagues are bands over the
+// overall skill rating (see leagueLadder in ratings.go); the tier number is
+// what search and the feed compare.
 var leagueTier = map[string]int{
 	"Bronze":   1,
 	"Silver":   2,
@@ -21,31 +23,27 @@ var leagueTier = map[string]int{
 	"Diamond":  5,
 }
 
-const maxLeagueDiff = 2 // Maximum league tier difference allowed for challenges
-
-// checkLeagueEligibility verifies two users are within the allowed league range.
-// Returns nil if eligible, or an error describing why not.
-func checkLeagueEligibility(userID1, userID2 string) error {
+// checkLeagueEligibility verifies two users are close enough in skill to
+// battle in a category. Returns nil if eligible, or an error describing why
+// not.
+//
+// Compares ratings, not league tiers: two players a few points apart either
+// side of a band edge are a fair match, and a fresh account and a veteran
+// sharing a league may not be. See matchmakingRating for what "rating" means
+// for somebody who has not battled yet.
+func checkLeagueEligibility(userID1, userID2, category string) error {
 	user1, found1 := GetUserByID(userID1)
 	user2, found2 := GetUserByID(userID2)
 	if !found1 || !found2 {
 		return fmt.Errorf("user not found")
 	}
 
-	tier1, ok1 := leagueTier[user1.League]
-	tier2, ok2 := leagueTier[user2.League]
-	if !ok1 {
-		tier1 = 1 // Default to Bronze if unknown
-	}
-	if !ok2 {
-		tier2 = 1
-	}
-
-	diff := math.Abs(float64(tier1 - tier2))
-	if diff > maxLeagueDiff {
+	r1 := matchmakingRating(userID1, user1.League, category)
+	r2 := matchmakingRating(userID2, user2.League, category)
+	if math.Abs(r1-r2) > maxRatingGap {
 		return fmt.Errorf(
-			"league mismatch: %s (%s) cannot challenge %s (%s) — max %d tier difference allowed",
-			user1.Username, user1.League, user2.Username, user2.League, maxLeagueDiff,
+			"skill mismatch: %s (%.0f) cannot challenge %s (%.0f) — max %.0f rating points apart",
+			user1.Username, r1, user2.Username, r2, maxRatingGap,
 		)
 	}
 	return nil
@@ -65,6 +63,10 @@ func CreateChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// The creator is the authenticated user, never a client-supplied id.
 	payload.CreatorID = authUserID(r)
+	// A posting ban refuses new challenges. See enforcement.go.
+	if !requireCanPost(w, payload.CreatorID) {
+		return
+	}
 
 	if payload.Prefix == "" || payload.Subject == "" {
 		http.Error(w, "prefix and subject are required", http.StatusBadRequest)
@@ -78,6 +80,32 @@ func CreateChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A direct challenge names its rival, who has to exist, be someone the
+	// challenger could fairly battle, and not be blocked either way. Checked
+	// before the upload gate so a refusal costs nothing. See
+	// direct_challenge.go.
+	var directFor time.Duration
+	if payload.Visibility == "direct" {
+		window, err := directWindow(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, found := GetUserByID(payload.TargetID); !found {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if usersBlocked(payload.CreatorID, payload.TargetID) {
+			http.Error(w, "cannot challenge this user", http.StatusForbidden)
+			return
+		}
+		if err := checkLeagueEligibility(payload.CreatorID, payload.TargetID, payload.Category); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		directFor = window
+	}
+
 	// Size gate. The bytes went straight from the phone to object storage
 	// without passing through here, so this is the first moment the server can
 	// find out what was actually uploaded — see video_probe.go. The app shrinks
@@ -112,8 +140,23 @@ func CreateChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		go SendChallengeNotification(creator.Username, payload.Prefix+" "+payload.Subject, payload.VisibleTo)
 	}
 
-	// Index in Meilisearch
-	go IndexChallenge(challenge)
+	// A call-out is between two people until it is answered (or falls back
+	// to the arena), so it is not indexed for search here.
+	if payload.Visibility == "direct" {
+		respondBy := time.Now().Add(directFor)
+		if err := createDirectChallenge(challenge.ID, payload.TargetID, payload.FallbackToArena, respondBy); err != nil {
+			// Without its call-out the row would be a challenge nobody
+			// can see or answer; take it back down.
+			_ = DeleteChallengeByID(challenge.ID)
+			http.Error(w, "Failed to create challenge: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		go SendDirectChallengeNotification(challenge.CreatorUsername, payload.TargetID, challenge.ID,
+			payload.Prefix+" "+payload.Subject, respondBy)
+	} else {
+		// Index in Meilisearch
+		go IndexChallenge(challenge)
+	}
 	// Bump the autocomplete popularity counter for this subject so
 	// the next typer who matches it gets it ranked higher. Fire-and-
 	// forget: a hiccup in the suggest index never blocks the create
@@ -129,9 +172,18 @@ func CreateChallengeHandler(w http.ResponseWriter, r *http.Request) {
 // GET /api/v1/challenges/arena
 func GetArenaChallengesHandler(w http.ResponseWriter, r *http.Request) {
 	challenges := GetArenaChallenges()
-	if challenges == nil {
-		challenges = []Challenge{}
+	// The arena is open to everyone except on a private account, whose
+	// challenges are for its followers. See follow_requests.go.
+	viewerID := optionalAuthUserID(r)
+	pv := newPrivacyViewer(viewerID, nil)
+	visible := make([]Challenge, 0, len(challenges))
+	for _, ch := range challenges {
+		if pv.canSee(ch.CreatorID) {
+			visible = append(visible, ch)
+		}
 	}
+	// A signed-in viewer's mutes apply here too. See content_preferences.go.
+	challenges = dropMutedChallenges(visible, loadContentPrefs(viewerID))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(challenges)
 }
@@ -166,6 +218,13 @@ func GetChallengeDetailHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Challenge not found", http.StatusNotFound)
 		return
 	}
+	// A private account's challenges are for its followers; to anyone else
+	// they don't exist. The viewer comes from the token alone — the userId
+	// query parameter below is anyone's to set. See follow_requests.go.
+	if !newPrivacyViewer(optionalAuthUserID(r), nil).canSee(challenge.CreatorID) {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
 
 	// Increment views.
 	go IncrementChallengeViews(id)
@@ -193,12 +252,22 @@ func GetChallengeDetailHandler(w http.ResponseWriter, r *http.Request) {
 		viewerID = r.URL.Query().Get("userId")
 	}
 	if viewerID != "" {
-		if err := checkLeagueEligibility(challenge.CreatorID, viewerID); err != nil {
+		if err := checkLeagueEligibility(challenge.CreatorID, viewerID, challenge.Category); err != nil {
 			canAccept = false
 			leagueMsg = err.Error()
 		}
 	}
 
+	// A call-out (pending or settled) says who it was for; while pending only
+	// that person can accept it.
+	var direct *DirectChallengeInfo
+	if d, ok := loadDirectChallenge(challenge.ID); ok {
+		direct = &d
+		if challenge.Visibility == "direct" && d.TargetID != viewerID {
+			canAccept = false
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"challenge":     challenge,
@@ -206,12 +275,13 @@ func GetChallengeDetailHandler(w http.ResponseWriter, r *http.Request) {
 		"votes":         votes,
 		"canAccept":     canAccept,
 		"leagueMessage": leagueMsg,
+		"direct":        direct,
 	})
 }
 
 // AcceptChallengeHandler lets a user respond to a challenge.
 // POST /api/v1/challenges/accept
-// Enforces league-restricted matchmaking: responder must be within ±2 league tiers of creator.
+// Enforces skill-restricted matchmaking: responder must be within maxRatingGap of creator.
 func AcceptChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	var payload AcceptChallengePayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -220,6 +290,9 @@ func AcceptChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// The responder is the authenticated user.
 	payload.ResponderID = authUserID(r)
+	if !requireCanPost(w, payload.ResponderID) {
+		return
+	}
 
 	// 30 accepts per hour per user. Higher than challenge_create
 	// because responding is a lighter act, but still bounded so a
@@ -229,16 +302,24 @@ func AcceptChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// League restriction: verify the responder is within ±2 tiers of the challenge creator
+	// Skill restriction: verify the responder is within maxRatingGap of the challenge creator
 	challenge, found := GetChallengeByID(payload.ChallengeID)
-	if !found {
+	if !found || !newPrivacyViewer(payload.ResponderID, nil).canSee(challenge.CreatorID) {
 		http.Error(w, "Challenge not found", http.StatusNotFound)
 		return
 	}
-	if err := checkLeagueEligibility(challenge.CreatorID, payload.ResponderID); err != nil {
+	if err := checkLeagueEligibility(challenge.CreatorID, payload.ResponderID, challenge.Category); err != nil {
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
+	// A call-out can only be answered by the person it was sent to.
+	direct := challenge.Visibility == "direct"
+	if direct {
+		if d, ok := loadDirectChallenge(challenge.ID); !ok || d.TargetID != payload.ResponderID {
+			http.Error(w, errDirectNotYours.Error(), http.StatusForbidden)
+			return
+		}
+	}
 
 	// Tier-1 structural validation: duration bounds, video dedupe, one-per-challenge,
 	// challenge-still-open, per-user rate limit. Cheap checks that fire on every upload.
@@ -257,8 +338,26 @@ func AcceptChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Claim the call-out last, once nothing else can refuse the answer: the
+	// claim is what settles a race with a decline or the deadline.
+	if direct {
+		if err := claimDirectChallenge(challenge.ID, payload.ResponderID); err != nil {
+			status := http.StatusConflict
+			if errors.Is(err, errDirectNotYours) {
+				status = http.StatusForbidden
+			} else if !errors.Is(err, errDirectNotPending) && !errors.Is(err, errDirectNotFound) {
+				status = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
 	response, err := AcceptChallenge(payload)
 	if err != nil {
+		if direct {
+			releaseDirectChallenge(challenge.ID)
+		}
 		http.Error(w, "Failed to accept challenge: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -268,7 +367,16 @@ func AcceptChallengeHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Notify the challenger that someone accepted.
 	responder, _ := GetUserByID(payload.ResponderID)
-	go SendChallengeAcceptedNotification(responder.Username, challenge.CreatorUsername, challenge.Prefix+" "+challenge.Subject)
+	title := challenge.Prefix + " " + challenge.Subject
+	if direct {
+		go SendDirectChallengeAcceptedNotification(responder.Username, challenge.CreatorID,
+			challenge.CreatorUsername, challenge.ID, title)
+		// Answered, the battle is public like any other.
+		challenge.Visibility = "arena"
+		go IndexChallenge(challenge)
+	} else {
+		go SendChallengeAcceptedNotification(responder.Username, challenge.CreatorUsername, title)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -277,6 +385,11 @@ func AcceptChallengeHandler(w http.ResponseWriter, r *http.Request) {
 
 // VoteChallengeHandler lets a user vote for a challenge response.
 // POST /api/v1/challenges/vote body:{ challengeId, responseId, voterId }
+//
+// responseId "creator" votes for the challenger's own video. Votes are
+// screened (vote_integrity.go): a battler voting in their own battle or a
+// voter who has not watched both sides is a 403, a second change of mind or a
+// vote on a battle already decided is a 409.
 func VoteChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	var payload ChallengeVotePayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -299,8 +412,8 @@ func VoteChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	voted, err := CastVote(payload)
-	if err != nil {
-		http.Error(w, "Failed to cast vote: "+err.Error(), http.StatusInternalServerError)
+	recordVoteOutcome(voteOutcomeLabel(err))
+	if writeVoteError(w, err) {
 		return
 	}
 
@@ -310,8 +423,11 @@ func VoteChallengeHandler(w http.ResponseWriter, r *http.Request) {
 		votes = []VoteSummary{}
 	}
 
-	// Send vote notification to the response owner
-	go SendVoteNotification(payload)
+	// Send vote notification to the response owner — only for a new or
+	// changed vote, not a repeat tap on the same side.
+	if voted {
+		go SendVoteNotification(payload)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -320,6 +436,25 @@ func VoteChallengeHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// writeVoteError answers a refused or failed CastVote and reports whether it
+// did; nil writes nothing. Shared by every endpoint that casts a battle vote,
+// so a refusal reads the same wherever the vote came from.
+func writeVoteError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errVoteByParticipant), errors.Is(err, errVoteNeedsWatch):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errVoteLocked), errors.Is(err, errBattleClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errVoteUnknownSide):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to cast vote: "+err.Error(), http.StatusInternalServerError)
+	}
+	return true
+}
+
 // GetVoteResultsHandler returns vote counts for a challenge.
 // GET /api/v1/challenges/{id}/votes
 func GetVoteResultsHandler(w http.ResponseWriter, r *http.Request) {
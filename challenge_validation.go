@@ -18,7 +18,7 @@ import (
 // Two tiers of validation, both designed to scale to millions of users without
 // per-upload AI inference costs:
 //
-//   Tier 1 (structural, cheap, fires on every upload):
+//   Tier 1 (structural, cheap, fires on every upload, tournament entries too):
 //     - Duration bounds (2s - 180s)
 //     - Same user can't reuse the same video URL across challenges
 //     - One response per user per challenge
@@ -56,21 +56,14 @@ const (
 // validateChallengeResponseSubmission runs all tier-1 checks on a new response.
 // Returns nil on success or a user-facing error on failure.
 func validateChallengeResponseSubmission(payload AcceptChallengePayload, challenge Challenge) error {
-	// --- Duration bounds ---
-	if payload.DurationMs < minResponseDurationMs {
-		return fmt.Errorf("video too short — minimum %d seconds", minResponseDurationMs/1000)
-	}
-	if payload.DurationMs > maxResponseDurationMs {
-		return fmt.Errorf("video too long — maximum %d seconds", maxResponseDurationMs/1000)
-	}
-
-	// --- Video URL must not be empty ---
-	if strings.TrimSpace(payload.VideoURL) == "" {
-		return fmt.Errorf("video URL is required")
+	if err := validateResponseVideo(payload.DurationMs, payload.VideoURL); err != nil {
+		return err
 	}
 
 	// --- Challenge must still accept responses ---
-	if challenge.Status == "closed" || challenge.Status == "expired" {
+	// A settled battle ("completed") is closed too — a response landing after
+	// the result would be a side nobody could ever vote for.
+	if challenge.Status == "closed" || challenge.Status == "expired" || challenge.Status == "completed" {
 		return fmt.Errorf("challenge is no longer accepting responses")
 	}
 
@@ -83,13 +76,8 @@ func validateChallengeResponseSubmission(payload AcceptChallengePayload, challen
 	if err != nil {
 		return fmt.Errorf("invalid challenge ID")
 	}
-	var dupExists bool
-	db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM challenge_responses WHERE responder_id=$1 AND video_url=$2)`,
-		rid, payload.VideoURL,
-	).Scan(&dupExists)
-	if dupExists {
-		return fmt.Errorf("you have already used this video for another challenge — record a new one")
+	if err := validateVideoNotReused(rid, payload.VideoURL); err != nil {
+		return err
 	}
 
 	// --- One response per user per challenge ---
@@ -102,17 +90,71 @@ func validateChallengeResponseSubmission(payload AcceptChallengePayload, challen
 		return fmt.Errorf("you have already responded to this challenge")
 	}
 
+	return validateResponder(payload.ResponderID)
+}
+
+// validateTournamentEntrySubmission runs the same tier-1 checks on a
+// tournament entry, which becomes a battle side once the bracket is drawn.
+// There is no challenge yet, so the per-challenge checks don't apply; the
+// bracket takes one entry per user itself.
+func validateTournamentEntrySubmission(userID string, p JoinTournamentPayload) error {
+	if err := validateResponseVideo(p.DurationMs, p.VideoURL); err != nil {
+		return err
+	}
+	rid, err := strconv.Atoi(userID)
+	if err != nil {
+		return fmt.Errorf("invalid responder ID")
+	}
+	if err := validateVideoNotReused(rid, p.VideoURL); err != nil {
+		return err
+	}
+	return validateResponder(userID)
+}
+
+// validateResponseVideo checks the upload itself: duration bounds and a URL.
+func validateResponseVideo(durationMs int, videoURL string) error {
+	// --- Duration bounds ---
+	if durationMs < minResponseDurationMs {
+		return fmt.Errorf("video too short — minimum %d seconds", minResponseDurationMs/1000)
+	}
+	if durationMs > maxResponseDurationMs {
+		return fmt.Errorf("video too long — maximum %d seconds", maxResponseDurationMs/1000)
+	}
+
+	// --- Video URL must not be empty ---
+	if strings.TrimSpace(videoURL) == "" {
+		return fmt.Errorf("video URL is required")
+	}
+	return nil
+}
+
+// validateVideoNotReused refuses a video the user has already answered with.
+func validateVideoNotReused(responderID int, videoURL string) error {
+	var dupExists bool
+	db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM challenge_responses WHERE responder_id=$1 AND video_url=$2)`,
+		responderID, videoURL,
+	).Scan(&dupExists)
+	if dupExists {
+		return fmt.Errorf("you have already used this video for another challenge — record a new one")
+	}
+	return nil
+}
+
+// validateResponder holds back repeat offenders and anyone over the hourly
+// response limit.
+func validateResponder(responderID string) error {
 	// --- Repeat-offender gate ---
 	// If >40% of this user's past responses have been community-hidden as
 	// off-topic, reject further submissions outright until a human reviews.
 	// Protects the challenge feed from well-tested bad actors without
 	// needing an explicit ban list.
-	if rate := userOffTopicRate(payload.ResponderID); rate > 0.4 {
+	if rate := userOffTopicRate(responderID); rate > 0.4 {
 		return fmt.Errorf("too many of your past responses were flagged off-topic — contact support")
 	}
 
 	// --- Per-user rate limit (Redis sliding-hour counter) ---
-	if err := enforceResponseRateLimit(payload.ResponderID); err != nil {
+	if err := enforceResponseRateLimit(responderID); err != nil {
 		return err
 	}
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -17,6 +18,14 @@ type SendMessagePayload struct {
 	ReceiverID string `json:"receiverId"`
 	Message    string `json:"message"`
 	ReplyToID  string `json:"replyToId,omitempty"`
+
+	// Kind is "text" (the default), "image", "video", "voice" or
+	// "challenge". Media kinds carry Attachment, uploaded through the
+	// "chat" media kind; a challenge share carries ChallengeID. For all
+	// but text, Message is an optional caption. See chat_media.go.
+	Kind        string          `json:"kind,omitempty"`
+	Attachment  *ChatAttachment `json:"attachment,omitempty"`
+	ChallengeID string          `json:"challengeId,omitempty"`
 }
 
 // maxChatMessageLen bounds a single chat message. Generous for real
@@ -45,7 +54,10 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 
 	senderID, _ := strconv.Atoi(payload.SenderID)
 	receiverID, _ := strconv.Atoi(payload.ReceiverID)
-	if senderID == 0 || receiverID == 0 || payload.Message == "" {
+	if payload.Kind == "" {
+		payload.Kind = chatKindText
+	}
+	if senderID == 0 || receiverID == 0 || (payload.Kind == chatKindText && payload.Message == "") {
 		http.Error(w, "senderId, receiverId, and message are required", http.StatusBadRequest)
 		return
 	}
@@ -70,6 +82,12 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	content, status, err := chatContentFor(payload)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	var replyToID *int
 	if payload.ReplyToID != "" {
 		if rid, err := strconv.Atoi(payload.ReplyToID); err == nil && rid > 0 {
@@ -77,7 +95,7 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	msgID, err := SendChatMessage(senderID, receiverID, payload.Message, replyToID)
+	msgID, err := SendChatMessage(senderID, receiverID, payload.Message, replyToID, content)
 	if err != nil {
 		log.Printf("SendChatMessage error: %v", err)
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
@@ -99,6 +117,14 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		Status:          "sent",
 		ReplyToID:       payload.ReplyToID,
 		CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+		Kind:            content.storedKind(),
+		Attachment:      content.attachment,
+	}
+	if content.challengeID != nil {
+		msg.ChallengeID = payload.ChallengeID
+		if c, ok := GetChallengeByID(payload.ChallengeID); ok {
+			msg.Challenge = &c
+		}
 	}
 
 	// Send real-time via WebSocket if receiver is online
@@ -136,8 +162,8 @@ func GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		messages = []ChatMessage{}
 	}
 
-	// Mark messages from otherUser as read
-	go MarkMessagesRead(otherID, userID)
+	// Mark messages from otherUser as read, and tell them.
+	go readChatMessages(otherID, userID, 0)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(messages)
@@ -155,16 +181,21 @@ func GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
 	if conversations == nil {
 		conversations = []Conversation{}
 	}
+	// The list shows the newest of everything waiting, so it is all
+	// delivered now.
+	go deliverAllChatReceipts(userID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(conversations)
 }
 
-// MarkReadHandler handles POST /api/v1/chat/read
+// MarkReadHandler handles POST /api/v1/chat/read body:{ senderId, messageId? }
+// — with messageId, only the messages up to and including it are read.
 func MarkReadHandler(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		SenderID   string `json:"senderId"`
 		ReceiverID string `json:"receiverId"`
+		MessageID  string `json:"messageId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Invalid body", http.StatusBadRequest)
@@ -180,7 +211,8 @@ func MarkReadHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "senderId must be a valid integer", http.StatusBadRequest)
 		return
 	}
-	MarkMessagesRead(sID, rID)
+	upTo, _ := strconv.Atoi(payload.MessageID)
+	readChatMessages(sID, rID, upTo)
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, `{"ok":true}`)
 }
@@ -260,16 +292,38 @@ func ForwardMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get original message text
-	var originalText string
-	err := db.QueryRow(`SELECT message FROM chat_messages WHERE id=$1`, msgID).Scan(&originalText)
+	// Get the original — only one from a conversation the forwarder is in,
+	// and not one already unsent. What it carries is forwarded with it: the
+	// same file, not a copy, which is why deleting an attachment checks for
+	// forwards first (chat_media.go).
+	var originalText, kind string
+	var mediaURL, thumbURL sql.NullString
+	var durationMs, challengeID sql.NullInt64
+	err := db.QueryRow(
+		`SELECT message, COALESCE(kind, 'text'), media_url, media_thumb_url, media_duration_ms, challenge_id
+		   FROM chat_messages
+		  WHERE id=$1 AND (sender_id=$2 OR receiver_id=$2) AND COALESCE(is_deleted, FALSE) = FALSE`,
+		msgID, senderID,
+	).Scan(&originalText, &kind, &mediaURL, &thumbURL, &durationMs, &challengeID)
 	if err != nil {
 		http.Error(w, "Message not found", http.StatusNotFound)
 		return
 	}
+	var original ChatMessage
+	scanChatContent(&original, kind, mediaURL, thumbURL, durationMs, challengeID)
+	content := chatContent{kind: kind, attachment: original.Attachment}
+	if challengeID.Valid {
+		// Passing a challenge on is sharing it again, by someone else.
+		if original.Challenge == nil || !challengeShareable(*original.Challenge, payload.SenderID) {
+			http.Error(w, "this challenge can't be shared", http.StatusForbidden)
+			return
+		}
+		cid := int(challengeID.Int64)
+		content.challengeID = &cid
+	}
 
 	// Send as a new message (no reply reference for forwards)
-	newMsgID, err := SendChatMessage(senderID, receiverID, originalText, nil)
+	newMsgID, err := SendChatMessage(senderID, receiverID, originalText, nil, content)
 	if err != nil {
 		http.Error(w, "Failed to forward", http.StatusInternalServerError)
 		return
@@ -287,6 +341,10 @@ func ForwardMessageHandler(w http.ResponseWriter, r *http.Request) {
 		Message:         originalText,
 		Status:          "sent",
 		CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+		Kind:            original.Kind,
+		Attachment:      original.Attachment,
+		ChallengeID:     original.ChallengeID,
+		Challenge:       original.Challenge,
 	}
 
 	go deliverChatMessage(receiver.Username, msg)
@@ -341,7 +399,8 @@ func GetSavedChallengesHandler(w http.ResponseWriter, r *http.Request) {
 // deliverChatMessage sends a chat message to a user via WebSocket —
 // locally, or through the cross-replica relay when the recipient is
 // connected to a different replica. Offline recipients simply don't get
-// a push (the message is already durable in chat_messages).
+// a push (the message is already durable in chat_messages), but it is in
+// their event log, so a reconnect with ?since= replays it (ws_events.go).
 func deliverChatMessage(recipientUsername string, msg ChatMessage) {
 	// Wrap the message in a notification-like envelope with type "chat"
 	envelope := map[string]interface{}{
@@ -353,6 +412,14 @@ func deliverChatMessage(recipientUsername string, msg ChatMessage) {
 		"receiverUsername": msg.ReceiverUsername,
 		"messageId":        msg.ID,
 		"timestamp":        msg.CreatedAt,
+		"kind":             msg.Kind,
+	}
+	if msg.Attachment != nil {
+		envelope["attachment"] = msg.Attachment
+	}
+	if msg.Challenge != nil {
+		envelope["challengeId"] = msg.ChallengeID
+		envelope["challenge"] = msg.Challenge
 	}
 
 	data, err := json.Marshal(envelope)
@@ -360,7 +427,7 @@ func deliverChatMessage(recipientUsername string, msg ChatMessage) {
 		return
 	}
 
-	if !wsDeliver(recipientUsername, data) && IsUserOnline(recipientUsername) {
+	if !wsDeliverEvent(recipientUsername, data) && IsUserOnline(recipientUsername) {
 		log.Printf("Failed to deliver chat message to %s", recipientUsername)
 	}
 }
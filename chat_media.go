@@ -0,0 +1,302 @@
+package main
+
+// chat_media.go — photos, short clips, voice notes and shared challenges in
+// DMs.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// HOW AN ATTACHMENT GETS INTO A MESSAGE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Exactly as a challenge video gets into a challenge. The app asks
+// /media/presign (or /media/multipart, for a long clip on a bad connection)
+// for the "chat" kind with the variant naming what it is — "image", "video"
+// plus "poster", or "voice" — PUTs the file straight to the bucket, and sends
+// the message with the public URL it was given. The backend never sees the
+// bytes.
+//
+// So all the send endpoint has to check is that the URL is what the presign
+// step would have produced for this sender: one of our own uploads, in the
+// sender's folder, named for the kind of message it claims to be. Anything
+// else — a link to another site, somebody else's upload, a voice note named
+// .jpg — is refused, so a message can never make the app load from a place
+// we did not hand out.
+//
+// A shared challenge is a card: the message holds the challenge id and the
+// reader gets a Challenge preview. Only a challenge the receiver could see
+// anyway can be shared — an arena challenge, or the sender's own.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHEN THE FILES GO
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Through the pending_media_deletions queue, like every other upload (see
+// media_delete.go), at two moments: when a message is unsent, and when an
+// account is deleted (its DMs go with it, sent and received). A forward is a
+// second message pointing at the same file, so a file is only queued once
+// nothing that survives still shows it — except the deleted account's own
+// uploads, which go regardless: deleting your account deletes what you
+// uploaded, including copies others forwarded on.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message kinds.
+const (
+	chatKindText      = "text"
+	chatKindImage     = "image"
+	chatKindVideo     = "video"
+	chatKindVoice     = "voice"
+	chatKindChallenge = "challenge"
+)
+
+const (
+	// chatVideoMaxDuration is the longest clip a message may carry. Chat is
+	// for reactions and snippets; anything longer is a challenge.
+	chatVideoMaxDuration = 60 * time.Second
+	// chatVoiceMaxDuration is the longest voice note.
+	chatVoiceMaxDuration = 5 * time.Minute
+)
+
+// chatMediaFiles is the file each media kind must be, as buildObjectKey names
+// the "chat" variants.
+var chatMediaFiles = map[string]string{
+	chatKindImage: "image." + chatVariantToExt["image"],
+	chatKindVideo: "video." + chatVariantToExt["video"],
+	chatKindVoice: "voice." + chatVariantToExt["voice"],
+}
+
+// chatPosterFile is a clip's poster frame.
+var chatPosterFile = "poster." + chatVariantToExt["poster"]
+
+var errChatMediaNotConfigured = errors.New("media storage not configured")
+
+// chatContent is what a message carries beyond its text. The zero value is a
+// plain text message.
+type chatContent struct {
+	kind        string
+	attachment  *ChatAttachment
+	challengeID *int
+}
+
+// storedKind is the kind column's value.
+func (c chatContent) storedKind() string {
+	if c.kind == "" {
+		return chatKindText
+	}
+	return c.kind
+}
+
+// chatUploadFile returns the file name of rawURL if it is one of senderID's
+// own chat uploads ("" otherwise): under our public base, in the sender's
+// folder, with nothing after the file name.
+func chatUploadFile(c *R2Config, rawURL, senderID string) string {
+	prefix := mediaPrefixFromPublicURL(c, rawURL)
+	if prefix == "" || !strings.HasPrefix(prefix, "u/"+senderID+"/") {
+		return ""
+	}
+	if strings.ContainsAny(rawURL, "?#") {
+		return ""
+	}
+	file := strings.TrimPrefix(rawURL, c.PublicURL(prefix))
+	if file == "" || strings.Contains(file, "/") {
+		return ""
+	}
+	return file
+}
+
+// validateChatAttachment checks a media message's attachment against its
+// kind. Returns a user-facing error.
+func validateChatAttachment(c *R2Config, kind string, att *ChatAttachment, senderID string) error {
+	want, ok := chatMediaFiles[kind]
+	if !ok {
+		return fmt.Errorf("kind must be text, image, video, voice or challenge")
+	}
+	if att == nil || att.URL == "" {
+		return fmt.Errorf("attachment.url is required for a %s message", kind)
+	}
+	if c == nil {
+		return errChatMediaNotConfigured
+	}
+	if chatUploadFile(c, att.URL, senderID) != want {
+		return fmt.Errorf("attachment.url is not a %s you uploaded", kind)
+	}
+	if att.ThumbnailURL != "" && (kind != chatKindVideo || chatUploadFile(c, att.ThumbnailURL, senderID) != chatPosterFile) {
+		return fmt.Errorf("attachment.thumbnailUrl must be the clip's poster frame")
+	}
+	if att.DurationMs < 0 {
+		return fmt.Errorf("attachment.durationMs can't be negative")
+	}
+	d := time.Duration(att.DurationMs) * time.Millisecond
+	switch {
+	case kind == chatKindImage && att.DurationMs != 0:
+		return fmt.Errorf("a photo has no duration")
+	case kind == chatKindVideo && d > chatVideoMaxDuration:
+		return fmt.Errorf("clips can be at most %d seconds", int(chatVideoMaxDuration.Seconds()))
+	case kind == chatKindVoice && d > chatVoiceMaxDuration:
+		return fmt.Errorf("voice notes can be at most %d minutes", int(chatVoiceMaxDuration.Minutes()))
+	}
+	return nil
+}
+
+// chatContentFor validates what a send carries beyond its text and returns
+// it, or a user-facing error with the status to answer it with.
+func chatContentFor(p SendMessagePayload) (chatContent, int, error) {
+	switch p.Kind {
+	case "", chatKindText:
+		if p.Attachment != nil || p.ChallengeID != "" {
+			return chatContent{}, http.StatusBadRequest, fmt.Errorf("a text message carries no attachment; set kind")
+		}
+		return chatContent{}, 0, nil
+
+	case chatKindChallenge:
+		c, ok := GetChallengeByID(p.ChallengeID)
+		if !ok {
+			return chatContent{}, http.StatusNotFound, fmt.Errorf("challenge not found")
+		}
+		if !challengeShareable(c, p.SenderID) {
+			return chatContent{}, http.StatusForbidden, fmt.Errorf("this challenge can't be shared")
+		}
+		cid, _ := strconv.Atoi(c.ID)
+		return chatContent{kind: chatKindChallenge, challengeID: &cid}, 0, nil
+	}
+
+	cfg, _ := loadR2Config()
+	if err := validateChatAttachment(cfg, p.Kind, p.Attachment, p.SenderID); err != nil {
+		if errors.Is(err, errChatMediaNotConfigured) {
+			return chatContent{}, http.StatusServiceUnavailable, err
+		}
+		return chatContent{}, http.StatusBadRequest, err
+	}
+	return chatContent{kind: p.Kind, attachment: p.Attachment}, 0, nil
+}
+
+// challengeShareable reports whether userID may put a challenge in front of
+// people it was not necessarily shown to: an arena challenge, or their own.
+// A friends-only or direct challenge passed on would reach people it was
+// never meant for.
+func challengeShareable(c Challenge, userID string) bool {
+	return c.Visibility == "arena" || c.CreatorID == userID
+}
+
+// chatPreviewText is what the conversation list shows for a message.
+func chatPreviewText(kind, message string) string {
+	if message != "" || kind == "" || kind == chatKindText {
+		return message
+	}
+	switch kind {
+	case chatKindImage:
+		return "Photo"
+	case chatKindVideo:
+		return "Video"
+	case chatKindVoice:
+		return "Voice message"
+	case chatKindChallenge:
+		return "Shared a challenge"
+	}
+	return message
+}
+
+// releaseChatMedia queues an unsent message's files for deletion, unless a
+// forward of it still shows them.
+func releaseChatMedia(mediaURL, thumbURL string) {
+	if db == nil || mediaURL == "" {
+		return
+	}
+	cfg, err := loadR2Config()
+	if err != nil {
+		return
+	}
+	var used bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM chat_messages WHERE media_url = $1)`,
+		mediaURL).Scan(&used); err != nil {
+		log.Printf("chat media cleanup: checking %s: %v", mediaURL, err)
+		return
+	}
+	if used {
+		return
+	}
+	var prefixes []string
+	for _, u := range []string{mediaURL, thumbURL} {
+		if p := mediaPrefixFromPublicURL(cfg, u); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	enqueueMediaDeletions(prefixes)
+}
+
+// chatMediaPrefixesForUser collects the storage folders of every attachment
+// in a user's DMs that should go when the account does: their own uploads,
+// and the other side's that nothing outside this user's conversations shows.
+//
+// Must be called BEFORE the account is deleted — the messages cascade away
+// with the users row.
+func chatMediaPrefixesForUser(userID string) []string {
+	if db == nil {
+		return nil
+	}
+	cfg, err := loadR2Config()
+	if err != nil {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT DISTINCT m.media_url, COALESCE(m.media_thumb_url, ''),
+		       EXISTS (SELECT 1 FROM chat_messages o
+		                WHERE o.media_url = m.media_url
+		                  AND o.sender_id <> CAST($1 AS INT) AND o.receiver_id <> CAST($1 AS INT))
+		FROM chat_messages m
+		WHERE (m.sender_id = CAST($1 AS INT) OR m.receiver_id = CAST($1 AS INT))
+		  AND m.media_url IS NOT NULL`, userID)
+	if err != nil {
+		log.Printf("chat media cleanup for %s: %v", userID, err)
+		return nil
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	own := "u/" + userID + "/"
+	for rows.Next() {
+		var media, thumb string
+		var usedElsewhere bool
+		if rows.Scan(&media, &thumb, &usedElsewhere) != nil {
+			continue
+		}
+		for _, u := range []string{media, thumb} {
+			p := mediaPrefixFromPublicURL(cfg, u)
+			if p != "" && (strings.HasPrefix(p, own) || !usedElsewhere) {
+				seen[p] = true
+			}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for p := range seen {
+		out = append(out, p)
+	}
+	return out
+}
+
+// scanChatContent fills a message's kind, attachment and challenge from the
+// columns GetChatMessages reads.
+func scanChatContent(cm *ChatMessage, kind string, mediaURL, thumbURL sql.NullString, durationMs sql.NullInt64, challengeID sql.NullInt64) {
+	cm.Kind = kind
+	if mediaURL.Valid && mediaURL.String != "" {
+		cm.Attachment = &ChatAttachment{
+			URL:          mediaURL.String,
+			ThumbnailURL: thumbURL.String,
+			DurationMs:   int(durationMs.Int64),
+		}
+	}
+	if challengeID.Valid {
+		cm.ChallengeID = strconv.FormatInt(challengeID.Int64, 10)
+		if c, ok := GetChallengeByID(cm.ChallengeID); ok {
+			cm.Challenge = &c
+		}
+	}
+}
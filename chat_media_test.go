@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// What a message may point at is checked here, not by the app: these pin that
+// nothing but the sender's own upload of the right kind gets through.
+
+func TestValidateChatAttachment(t *testing.T) {
+	c := testR2()
+	base := "https://pub-abc123.r2.dev/u/29/4ab2fd/"
+
+	ok := []struct {
+		name string
+		kind string
+		att  ChatAttachment
+	}{
+		{"photo", chatKindImage, ChatAttachment{URL: base + "image.jpg"}},
+		{"clip with poster", chatKindVideo, ChatAttachment{URL: base + "video.mp4", ThumbnailURL: base + "poster.jpg", DurationMs: 12000}},
+		{"voice note", chatKindVoice, ChatAttachment{URL: base + "voice.m4a", DurationMs: 90000}},
+	}
+	for _, tc := range ok {
+		att := tc.att
+		if err := validateChatAttachment(c, tc.kind, &att, "29"); err != nil {
+			t.Errorf("%s: refused: %v", tc.name, err)
+		}
+	}
+
+	bad := []struct {
+		name string
+		kind string
+		att  *ChatAttachment
+	}{
+		{"no attachment", chatKindImage, nil},
+		{"unknown kind", "gif", &ChatAttachment{URL: base + "image.jpg"}},
+		{"someone else's upload", chatKindImage, &ChatAttachment{URL: "https://pub-abc123.r2.dev/u/30/4ab2fd/image.jpg"}},
+		{"another site", chatKindImage, &ChatAttachment{URL: "https://evil.example/u/29/4ab2fd/image.jpg"}},
+		{"wrong file for the kind", chatKindVoice, &ChatAttachment{URL: base + "image.jpg"}},
+		{"a challenge video", chatKindVideo, &ChatAttachment{URL: base + "720p.mp4"}},
+		{"a query string", chatKindImage, &ChatAttachment{URL: base + "image.jpg?x=1"}},
+		{"a nested path", chatKindImage, &ChatAttachment{URL: base + "x/image.jpg"}},
+		{"poster on a photo", chatKindImage, &ChatAttachment{URL: base + "image.jpg", ThumbnailURL: base + "poster.jpg"}},
+		{"photo with a duration", chatKindImage, &ChatAttachment{URL: base + "image.jpg", DurationMs: 10}},
+		{"clip too long", chatKindVideo, &ChatAttachment{URL: base + "video.mp4", DurationMs: 61000}},
+		{"voice note too long", chatKindVoice, &ChatAttachment{URL: base + "voice.m4a", DurationMs: 301000}},
+	}
+	for _, tc := range bad {
+		if err := validateChatAttachment(c, tc.kind, tc.att, "29"); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+
+	if err := validateChatAttachment(nil, chatKindImage, &ChatAttachment{URL: base + "image.jpg"}, "29"); err != errChatMediaNotConfigured {
+		t.Errorf("no storage configured: got %v", err)
+	}
+}
+
+// A user id that is a prefix of another's must not reach into their folder.
+func TestChatUploadFileIsScopedToSender(t *testing.T) {
+	c := testR2()
+	if got := chatUploadFile(c, "https://pub-abc123.r2.dev/u/290/4ab2fd/image.jpg", "29"); got != "" {
+		t.Errorf("user 29 claimed user 290's upload: %q", got)
+	}
+	if got := chatUploadFile(c, "https://pub-abc123.r2.dev/u/29/4ab2fd/image.jpg", "29"); got != "image.jpg" {
+		t.Errorf("own upload = %q", got)
+	}
+}
+
+func TestChatPreviewText(t *testing.T) {
+	cases := map[[2]string]string{
+		{chatKindText, "hey"}:   "hey",
+		{"", "hey"}:             "hey",
+		{chatKindImage, ""}:     "Photo",
+		{chatKindImage, "look"}: "look",
+		{chatKindVoice, ""}:     "Voice message",
+		{chatKindChallenge, ""}: "Shared a challenge",
+		{chatKindVideo, ""}:     "Video",
+	}
+	for in, want := range cases {
+		if got := chatPreviewText(in[0], in[1]); got != want {
+			t.Errorf("chatPreviewText(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestChatContentForRejectsMixedText(t *testing.T) {
+	_, status, err := chatContentFor(SendMessagePayload{
+		SenderID: "29", Message: "hi",
+		Attachment: &ChatAttachment{URL: "https://pub-abc123.r2.dev/u/29/4ab2fd/image.jpg"},
+	})
+	if err == nil || status != 400 || !strings.Contains(err.Error(), "kind") {
+		t.Errorf("text with an attachment: status %d, err %v", status, err)
+	}
+}
+
+func TestChallengeShareable(t *testing.T) {
+	if !challengeShareable(Challenge{Visibility: "arena", CreatorID: "1"}, "2") {
+		t.Error("an arena challenge could not be shared")
+	}
+	if challengeShareable(Challenge{Visibility: "friends", CreatorID: "1"}, "2") {
+		t.Error("someone else's friends-only challenge was shareable")
+	}
+	if !challengeShareable(Challenge{Visibility: "friends", CreatorID: "1"}, "1") {
+		t.Error("the creator could not share their own challenge")
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+// chat_receipts.go — what the other side of a DM sees happening live:
+// delivery and read receipts per message, typing, and reactions.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// RECEIPTS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	sent       stored. Every message starts here.
+//	delivered  a device of the receiver has it: the app reports it over the
+//	           socket ({"type":"delivered","messageIds":[...]}) or with
+//	           POST /chat/delivered, and opening the conversation list counts
+//	           for everything waiting in it. Everything is only ever marked
+//	           when asked for by name ({"all":true}, or the list): a report
+//	           whose ids are all unusable marks nothing, rather than every
+//	           message the receiver has.
+//	read       the receiver has seen it: opening the conversation, or
+//	           POST /chat/read with a messageId to mark only up to there.
+//
+// Each move is pushed to the sender as a "chat_receipt" frame through
+// wsDeliverEvent — logged and replayed like the message itself, so a sender
+// who was offline still ends up with the right ticks.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// TYPING AND REACTIONS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Typing is a frame up the socket ({"type":"typing","to":"<userId>",
+// "state":"start"|"stop"}) passed straight to the other person's devices with
+// wsDeliver. It is never stored and never logged: replaying "typing…" from
+// ten minutes ago would be wrong, and the app stops showing it on its own
+// after a few seconds without a refresh.
+//
+// Reactions are one per person per message, from chatReactions. Reacting again
+// replaces it, an empty emoji takes it away, and either side of the
+// conversation hears about it as a "chat_reaction" frame.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// chatReactions is the set a reaction must come from. Bounded on purpose: a
+// closed set renders the same on every device and can't carry text.
+var chatReactions = []string{"👍", "❤️", "😂", "😮", "😢", "🔥"}
+
+// chatDeliveredMax bounds one delivery report.
+const chatDeliveredMax = 200
+
+var (
+	errChatMessageNotFound = errors.New("message not found")
+	errChatBadReaction     = errors.New("that reaction isn't available")
+)
+
+// chatReactionAllowed reports whether emoji is in chatReactions.
+func chatReactionAllowed(emoji string) bool {
+	for _, e := range chatReactions {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// RECEIPTS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// markChatDelivered records that receiverID has the given messages and
+// returns the newly delivered ids by sender. No ids marks nothing.
+func markChatDelivered(receiverID int, ids []int) (map[int][]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > chatDeliveredMax {
+		ids = ids[:chatDeliveredMax]
+	}
+	return scanChatDelivered(db.Query(`
+		UPDATE chat_messages
+		   SET delivered_at = NOW(), status = 'delivered'
+		 WHERE receiver_id = $1 AND delivered_at IS NULL AND is_read = FALSE
+		   AND id = ANY($2::int[])
+		RETURNING sender_id, id`, receiverID, pq.Array(ids)))
+}
+
+// markAllChatDelivered is markChatDelivered for every message waiting for
+// receiverID.
+func markAllChatDelivered(receiverID int) (map[int][]int, error) {
+	return scanChatDelivered(db.Query(`
+		UPDATE chat_messages
+		   SET delivered_at = NOW(), status = 'delivered'
+		 WHERE receiver_id = $1 AND delivered_at IS NULL AND is_read = FALSE
+		RETURNING sender_id, id`, receiverID))
+}
+
+// scanChatDelivered groups the rows a delivered UPDATE returns by sender.
+func scanChatDelivered(rows *sql.Rows, err error) (map[int][]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bySender := map[int][]int{}
+	for rows.Next() {
+		var sender, id int
+		if rows.Scan(&sender, &id) == nil {
+			bySender[sender] = append(bySender[sender], id)
+		}
+	}
+	return bySender, rows.Err()
+}
+
+// deliverChatReceipts marks messages delivered and tells their senders.
+func deliverChatReceipts(receiverID int, ids []int) {
+	bySender, err := markChatDelivered(receiverID, ids)
+	sendChatDelivered(receiverID, bySender, err)
+}
+
+// deliverAllChatReceipts is deliverChatReceipts for everything waiting.
+func deliverAllChatReceipts(receiverID int) {
+	bySender, err := markAllChatDelivered(receiverID)
+	sendChatDelivered(receiverID, bySender, err)
+}
+
+// sendChatDelivered tells each sender which of their messages arrived.
+func sendChatDelivered(receiverID int, bySender map[int][]int, err error) {
+	if err != nil {
+		log.Printf("chat delivered for %d: %v", receiverID, err)
+		return
+	}
+	for sender, got := range bySender {
+		pushChatReceipt(sender, receiverID, "delivered", got)
+	}
+}
+
+// readChatMessages marks messages from senderID read by receiverID (up to
+// upToID, or all when 0) and tells the sender.
+func readChatMessages(senderID, receiverID, upToID int) {
+	if ids := MarkMessagesRead(senderID, receiverID, upToID); len(ids) > 0 {
+		pushChatReceipt(senderID, receiverID, "read", ids)
+	}
+}
+
+// pushChatReceipt tells a sender that their messages moved to status.
+func pushChatReceipt(senderID, byUserID int, status string, ids []int) {
+	sender, ok := GetUserByID(strconv.Itoa(senderID))
+	if !ok {
+		return
+	}
+	msgIDs := make([]string, len(ids))
+	for i, id := range ids {
+		msgIDs[i] = strconv.Itoa(id)
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"type":       "chat_receipt",
+		"status":     status,
+		"messageIds": msgIDs,
+		"userId":     strconv.Itoa(byUserID),
+	})
+	if err != nil {
+		return
+	}
+	wsDeliverEvent(sender.Username, data)
+}
+
+// parseMessageIDs turns the ids a client sends into ints, dropping anything
+// that is not one.
+func parseMessageIDs(in []string) []int {
+	out := make([]int, 0, len(in))
+	for _, s := range in {
+		if id, err := strconv.Atoi(s); err == nil && id > 0 {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// TYPING
+// ════════════════════════════════════════════════════════════════════════════════
+
+// chatTyping passes a typing frame from one user to another's devices. Not
+// stored, not logged; dropped if either has blocked the other or the sender
+// is typing faster than anyone types.
+func chatTyping(fromID, fromUsername, toID, state string) {
+	if state != "start" && state != "stop" {
+		return
+	}
+	if toID == "" || toID == fromID || !allowAction(fromID, "typing") {
+		return
+	}
+	if usersBlocked(fromID, toID) {
+		return
+	}
+	to, ok := GetUserByID(toID)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(map[string]string{
+		"type":         "typing",
+		"fromId":       fromID,
+		"fromUsername": fromUsername,
+		"state":        state,
+	})
+	if err != nil {
+		return
+	}
+	wsDeliver(to.Username, data)
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// REACTIONS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// setChatReaction sets (or with emoji "" removes) userID's reaction to a
+// message in one of their conversations. Returns the other side's id.
+func setChatReaction(messageID, userID int, emoji string) (int, error) {
+	if emoji != "" && !chatReactionAllowed(emoji) {
+		return 0, errChatBadReaction
+	}
+	var senderID, receiverID int
+	err := db.QueryRow(`
+		SELECT sender_id, receiver_id FROM chat_messages
+		WHERE id = $1 AND (sender_id = $2 OR receiver_id = $2)
+		  AND COALESCE(is_deleted, FALSE) = FALSE`, messageID, userID).Scan(&senderID, &receiverID)
+	if err != nil {
+		return 0, errChatMessageNotFound
+	}
+	if emoji == "" {
+		_, err = db.Exec(`DELETE FROM chat_message_reactions WHERE message_id = $1 AND user_id = $2`,
+			messageID, userID)
+	} else {
+		_, err = db.Exec(`
+			INSERT INTO chat_message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3)
+			ON CONFLICT (message_id, user_id) DO UPDATE SET emoji = EXCLUDED.emoji, created_at = NOW()`,
+			messageID, userID, emoji)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if senderID == userID {
+		return receiverID, nil
+	}
+	return senderID, nil
+}
+
+// attachChatReactions fills in the reactions on a page of messages, in one
+// query.
+func attachChatReactions(msgs []ChatMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	ids := make([]int, 0, len(msgs))
+	at := make(map[string]int, len(msgs))
+	for i, m := range msgs {
+		if id, err := strconv.Atoi(m.ID); err == nil {
+			ids = append(ids, id)
+			at[m.ID] = i
+		}
+	}
+	rows, err := db.Query(`
+		SELECT message_id, user_id, emoji FROM chat_message_reactions
+		WHERE message_id = ANY($1::int[])
+		ORDER BY created_at`, pq.Array(ids))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mid, uid int
+		var emoji string
+		if rows.Scan(&mid, &uid, &emoji) != nil {
+			continue
+		}
+		if i, ok := at[strconv.Itoa(mid)]; ok {
+			msgs[i].Reactions = append(msgs[i].Reactions, ChatReaction{UserID: strconv.Itoa(uid), Emoji: emoji})
+		}
+	}
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// ReactMessageHandler sets or removes the caller's reaction to a message.
+// POST /api/v1/chat/react body:{ messageId, emoji } — emoji "" removes it.
+func ReactMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var p struct {
+		MessageID string `json:"messageId"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	if !allowAction(userID, "chat") {
+		writeRateLimited(w, "chat")
+		return
+	}
+	msgID, _ := strconv.Atoi(p.MessageID)
+	uid, _ := strconv.Atoi(userID)
+	if msgID == 0 || uid == 0 {
+		http.Error(w, "messageId must be a valid integer", http.StatusBadRequest)
+		return
+	}
+
+	otherID, err := setChatReaction(msgID, uid, p.Emoji)
+	switch {
+	case errors.Is(err, errChatBadReaction):
+		http.Error(w, fmt.Sprintf("%s (one of %v)", err.Error(), chatReactions), http.StatusBadRequest)
+		return
+	case errors.Is(err, errChatMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to react: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	frame := map[string]string{
+		"type":      "chat_reaction",
+		"messageId": p.MessageID,
+		"userId":    userID,
+		"emoji":     p.Emoji,
+	}
+	go func() {
+		if other, ok := GetUserByID(strconv.Itoa(otherID)); ok {
+			if data, err := json.Marshal(frame); err == nil {
+				wsDeliverEvent(other.Username, data)
+			}
+		}
+	}()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(frame)
+}
+
+// MarkDeliveredHandler records that the caller's device has messages.
+// POST /api/v1/chat/delivered body:{ messageIds } or { all: true } for
+// everything waiting for the caller.
+func MarkDeliveredHandler(w http.ResponseWriter, r *http.Request) {
+	var p struct {
+		MessageIDs []string `json:"messageIds"`
+		All        bool     `json:"all"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&p)
+	uid, _ := strconv.Atoi(authUserID(r))
+	if uid == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if p.All {
+		deliverAllChatReceipts(uid)
+	} else if ids := parseMessageIDs(p.MessageIDs); len(ids) > 0 {
+		deliverChatReceipts(uid, ids)
+	} else {
+		http.Error(w, "messageIds required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"ok":true}`)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestChatReactionsAreBounded(t *testing.T) {
+	for _, e := range chatReactions {
+		if !chatReactionAllowed(e) {
+			t.Errorf("%q refused", e)
+		}
+	}
+	for _, e := range []string{"", "🍆", "lol", "👍👍"} {
+		if chatReactionAllowed(e) {
+			t.Errorf("%q accepted", e)
+		}
+	}
+	// A reaction outside the set never reaches the database.
+	if _, err := setChatReaction(1, 2, "🍆"); err != errChatBadReaction {
+		t.Errorf("setChatReaction with a stray emoji: %v", err)
+	}
+}
+
+// Reactions on a page come from one query and land on the right messages.
+func TestAttachChatReactions(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM chat_message_reactions")).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "emoji"}).
+			AddRow(11, 2, "🔥").
+			AddRow(12, 1, "👍").
+			AddRow(11, 1, "😂"))
+
+	msgs := []ChatMessage{{ID: "11"}, {ID: "12"}, {ID: "13"}}
+	attachChatReactions(msgs)
+
+	if len(msgs[0].Reactions) != 2 || msgs[0].Reactions[0].Emoji != "🔥" || msgs[0].Reactions[1].UserID != "1" {
+		t.Errorf("message 11 reactions = %+v", msgs[0].Reactions)
+	}
+	if len(msgs[1].Reactions) != 1 || msgs[1].Reactions[0].Emoji != "👍" {
+		t.Errorf("message 12 reactions = %+v", msgs[1].Reactions)
+	}
+	if len(msgs[2].Reactions) != 0 {
+		t.Errorf("message 13 reactions = %+v", msgs[2].Reactions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A delivery report answers each sender with only their own messages.
+func TestMarkChatDeliveredGroupsBySender(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SET delivered_at = NOW()")).
+		WithArgs(5, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"sender_id", "id"}).
+			AddRow(1, 40).AddRow(2, 41).AddRow(1, 42))
+
+	got, err := markChatDelivered(5, parseMessageIDs([]string{"40", "41", "x", "42", "-3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got[1]) != 2 || got[1][0] != 40 || got[1][1] != 42 || len(got[2]) != 1 {
+		t.Errorf("by sender = %v", got)
+	}
+}
+
+// A report with no usable ids marks nothing — not everything waiting.
+func TestMarkDeliveredNeedsUsableIDs(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	if got, err := markChatDelivered(5, parseMessageIDs([]string{"x", "-3", ""})); err != nil || len(got) != 0 {
+		t.Errorf("unusable ids: %v, %v", got, err)
+	}
+	rec := httptest.NewRecorder()
+	MarkDeliveredHandler(rec, withAuth(httptest.NewRequest("POST", "/api/v1/chat/delivered",
+		strings.NewReader(`{"messageIds":["x"]}`)), "5", "sam"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
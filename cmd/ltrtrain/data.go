@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ltrFeatureKeys mirrors ltrFeatureKeys in learning_to_rank.go. The server
+// refuses an artifact trained on any other list, so keep them in step.
+var ltrFeatureKeys = []string{
+	"social", "freshness", "energyFit", "relevance", "quality", "novelty",
+	"tieStrength", "creatorAffinityBoost", "dwellIntentBoost",
+	"searchBoost", "fatiguePenalty", "creatorFatigue", "sequencePenalty",
+	"dopaminePenalty", "unseenBonus", "coldContentBonus", "trendingBonus",
+	"hourBonus", "emotionBonus", "egoContextBonus", "wellbeingBonus",
+	"collabBonus", "momentumBonus", "variableReward", "reentryBonus",
+	"streakBonus", "impressionBouncePenalty", "scrollBackBonus",
+	"completeBonus", "loopBonus", "unmuteBonus", "profileVisitBonus",
+	"egoBoost",
+}
+
+// outcomeWindow mirrors ltrBreakdownTTL: online, an event more than this
+// long after the item was served finds no stashed breakdown and trains
+// nothing, so offline it is not an outcome either.
+const outcomeWindow = 30 * time.Minute
+
+// example is one served item with what the online heads would have learned
+// from it.
+type example struct {
+	Cohort   string
+	ServedAt time.Time
+	X        []float64 // ltrFeatureKeys order; absent features are 0
+	Weight   float64   // position (inverse-propensity) weight, clamped as online
+
+	HasLabel bool
+	Label    float64   // LTR label, from the first labelled outcome
+	Ratios   []float64 // watch-ratio targets, in event order
+}
+
+// labelForEvent mirrors ltrLabelForEvent in learning_to_rank.go.
+func labelForEvent(eventType string, completionRate float64) (float64, bool) {
+	switch eventType {
+	case "complete", "like", "share", "rewatch", "loop", "scroll_back", "save", "unmute":
+		return 1.0, true
+	case "skip", "not_interested", "report", "block":
+		return 0.0, true
+	case "view":
+		if completionRate >= 0.8 {
+			return 1.0, true
+		}
+		if completionRate > 0 && completionRate < 0.2 {
+			return 0.0, true
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// watchRatioForEvent mirrors the ratio updateSessionFromEvent hands the
+// watch-ratio head: a completion is a full watch, a skip none, and a view
+// what was watched of it.
+func watchRatioForEvent(eventType string, completionRate float64) (float64, bool) {
+	switch eventType {
+	case "complete":
+		return 1, true
+	case "skip":
+		return 0, true
+	case "view":
+		if completionRate > 0 {
+			return math.Min(1, completionRate), true
+		}
+	}
+	return 0, false
+}
+
+// positionWeight mirrors the online heads: 1/positionPropensity, clamped to
+// [0.25, 4] as ltrObserveWeighted and wrObserve clamp it.
+func positionWeight(pos int) float64 {
+	if pos < 1 {
+		return 1
+	}
+	w := 1 / math.Pow(float64(pos), -0.7)
+	return math.Max(0.25, math.Min(4, w))
+}
+
+type outcomeEvent struct {
+	Type           string
+	CompletionRate float64
+}
+
+// buildExample replays an item's outcome events the way the online path
+// consumes them. The first event with a label trains LTR and ends the item:
+// online it deletes the stashed breakdown. Every event up to and including
+// it that carries a watch ratio trains the watch-ratio head.
+func buildExample(cohort string, servedAt time.Time, position int, breakdown map[string]float64, events []outcomeEvent) example {
+	ex := example{Cohort: cohort, ServedAt: servedAt, Weight: positionWeight(position)}
+	ex.X = make([]float64, len(ltrFeatureKeys))
+	for i, k := range ltrFeatureKeys {
+		ex.X[i] = breakdown[k]
+	}
+	for _, e := range events {
+		label, labelled := labelForEvent(e.Type, e.CompletionRate)
+		if r, ok := watchRatioForEvent(e.Type, e.CompletionRate); ok {
+			ex.Ratios = append(ex.Ratios, r)
+		}
+		if labelled {
+			ex.HasLabel, ex.Label = true, label
+			break
+		}
+	}
+	return ex
+}
+
+// loadExamples reads the logged impressions served in [from, to) and their
+// outcome events, oldest first. Impressions nobody acted on train nothing
+// online and are left out here too.
+func loadExamples(db *sql.DB, from, to time.Time) ([]example, error) {
+	rows, err := db.Query(`
+		SELECT i.id, i.cohort, i.position, i.breakdown, i.served_at, e.event_type, COALESCE(e.completion_rate, 0)
+		FROM ranking_impressions i
+		JOIN feed_events e
+		  ON e.user_id = i.user_id::text AND e.content_type = i.content_type AND e.content_id = i.content_id
+		 AND e.created_at >= i.served_at AND e.created_at < i.served_at + ($3)::interval
+		WHERE i.served_at >= $1 AND i.served_at < $2
+		ORDER BY i.served_at, i.id, e.created_at, e.id`,
+		from, to, fmt.Sprintf("%d seconds", int(outcomeWindow.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		out      []example
+		curID    int64 = -1
+		cohort   string
+		position int
+		bd       map[string]float64
+		servedAt time.Time
+		events   []outcomeEvent
+	)
+	flush := func() {
+		if curID < 0 {
+			return
+		}
+		ex := buildExample(cohort, servedAt, position, bd, events)
+		if ex.HasLabel || len(ex.Ratios) > 0 {
+			out = append(out, ex)
+		}
+	}
+	for rows.Next() {
+		var (
+			id       int64
+			c        string
+			pos      int
+			raw      []byte
+			served   time.Time
+			evType   string
+			complete float64
+		)
+		if err := rows.Scan(&id, &c, &pos, &raw, &served, &evType, &complete); err != nil {
+			return nil, err
+		}
+		if id != curID {
+			flush()
+			curID, cohort, position, servedAt, events = id, c, pos, served, nil
+			bd = nil
+			if err := json.Unmarshal(raw, &bd); err != nil {
+				return nil, fmt.Errorf("impression %d: %w", id, err)
+			}
+		}
+		events = append(events, outcomeEvent{Type: evType, CompletionRate: complete})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return out, nil
+}
+
+// timeSplit puts the latest holdout share of the examples aside for
+// evaluation. Splitting on time rather than at random is what makes the
+// holdout honest: the model is judged on a later stretch it never saw, as
+// it will be once served.
+func timeSplit(examples []example, holdout float64) (train, test []example) {
+	sorted := append([]example(nil), examples...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ServedAt.Before(sorted[j].ServedAt) })
+	cut := len(sorted) - int(math.Round(float64(len(sorted))*holdout))
+	return sorted[:cut], sorted[cut:]
+}
+
+// byCohort groups examples by the cohort they were served to.
+func byCohort(examples []example) map[string][]example {
+	out := map[string][]example{}
+	for _, ex := range examples {
+		out[ex.Cohort] = append(out[ex.Cohort], ex)
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// GRADIENT-BOOSTED LTR HEAD
+//
+// The linear head can only say "more of this feature is better". It cannot
+// say "fresh is good unless the user is already fatigued", and most of what
+// the breakdown knows is conditional like that. Boosted trees learn the
+// interactions: each round fits a small tree to what the rounds before it
+// got wrong, on the same logistic loss and the same position weights.
+//
+// Splits are searched over at most gbdtBins quantile cut points per feature,
+// which is what keeps a round linear in the number of examples. The JSON
+// shapes mirror gbdtNode/gbdtTree/gbdtModel in offline_models.go, and the
+// server walks a tree the same way: left when feature ≤ threshold, an absent
+// feature reading as 0.
+// ─────────────────────────────────────────────────────────────────────────────
+
+const (
+	gbdtBins         = 32
+	gbdtLambda       = 1.0 // L2 on leaf values
+	gbdtMinChildHess = 1.0 // smallest hessian weight a split may leave on either side
+	gbdtMinSplitGain = 1e-6
+)
+
+type gbdtNode struct {
+	Feature   string  `json:"f,omitempty"`
+	Threshold float64 `json:"t,omitempty"`
+	Left      int     `json:"l,omitempty"`
+	Right     int     `json:"r,omitempty"`
+	Value     float64 `json:"v,omitempty"`
+	Leaf      bool    `json:"leaf,omitempty"`
+}
+
+type gbdtTree struct {
+	Nodes []gbdtNode `json:"nodes"`
+}
+
+type gbdtModel struct {
+	Bias    float64    `json:"bias"`
+	Samples int        `json:"samples"`
+	Trees   []gbdtTree `json:"trees"`
+}
+
+func (t gbdtTree) eval(x []float64) float64 {
+	i := 0
+	for {
+		n := t.Nodes[i]
+		if n.Leaf {
+			return n.Value
+		}
+		if x[featureIndex[n.Feature]] <= n.Threshold {
+			i = n.Left
+		} else {
+			i = n.Right
+		}
+	}
+}
+
+func (g gbdtModel) logit(x []float64) float64 {
+	z := g.Bias
+	for _, t := range g.Trees {
+		z += t.eval(x)
+	}
+	return z
+}
+
+var featureIndex = func() map[string]int {
+	m := make(map[string]int, len(ltrFeatureKeys))
+	for i, k := range ltrFeatureKeys {
+		m[k] = i
+	}
+	return m
+}()
+
+// quantileCuts picks up to gbdtBins−1 distinct cut points from a feature's
+// values.
+func quantileCuts(values []float64) []float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var cuts []float64
+	for k := 1; k < gbdtBins; k++ {
+		v := sorted[k*(len(sorted)-1)/gbdtBins]
+		if len(cuts) == 0 || v > cuts[len(cuts)-1] {
+			cuts = append(cuts, v)
+		}
+	}
+	// A cut at the maximum splits nothing off.
+	if n := len(cuts); n > 0 && cuts[n-1] >= sorted[len(sorted)-1] {
+		cuts = cuts[:n-1]
+	}
+	return cuts
+}
+
+type gbdtBuilder struct {
+	cuts [][]float64 // per feature
+	bins [][]uint8   // per feature, per example: index of the first cut ≥ x
+	g, h []float64
+	cfg  trainConfig
+	tree gbdtTree
+}
+
+func fitGBDT(xs [][]float64, ys, ws []float64, cfg trainConfig) gbdtModel {
+	n, nf := len(xs), len(ltrFeatureKeys)
+	model := gbdtModel{Samples: n}
+	if n == 0 {
+		return model
+	}
+	var wsum, mean float64
+	for i, y := range ys {
+		wsum += ws[i]
+		mean += ws[i] * y
+	}
+	mean = math.Min(1-1e-6, math.Max(1e-6, mean/wsum))
+	model.Bias = math.Log(mean / (1 - mean))
+
+	b := &gbdtBuilder{cfg: cfg, cuts: make([][]float64, nf), bins: make([][]uint8, nf),
+		g: make([]float64, n), h: make([]float64, n)}
+	col := make([]float64, n)
+	for f := 0; f < nf; f++ {
+		for i, x := range xs {
+			col[i] = x[f]
+		}
+		b.cuts[f] = quantileCuts(col)
+		b.bins[f] = make([]uint8, n)
+		for i, v := range col {
+			b.bins[f][i] = uint8(sort.SearchFloat64s(b.cuts[f], v))
+		}
+	}
+
+	F := make([]float64, n)
+	for i := range F {
+		F[i] = model.Bias
+	}
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	for round := 0; round < cfg.Trees; round++ {
+		for i := range xs {
+			p := sigmoid(F[i])
+			b.g[i] = ws[i] * (p - ys[i])
+			b.h[i] = math.Max(1e-9, ws[i]*p*(1-p))
+		}
+		b.tree = gbdtTree{}
+		b.grow(all, cfg.Depth)
+		for i, x := range xs {
+			F[i] += b.tree.eval(x)
+		}
+		model.Trees = append(model.Trees, b.tree)
+	}
+	return model
+}
+
+// grow adds the subtree for the examples in idx and returns its node index.
+func (b *gbdtBuilder) grow(idx []int, depth int) int {
+	var G, H float64
+	for _, i := range idx {
+		G += b.g[i]
+		H += b.h[i]
+	}
+	self := len(b.tree.Nodes)
+	b.tree.Nodes = append(b.tree.Nodes, gbdtNode{Leaf: true, Value: -G / (H + gbdtLambda) * b.cfg.Shrinkage})
+	if depth <= 0 || len(idx) < 2 {
+		return self
+	}
+
+	parent := G * G / (H + gbdtLambda)
+	bestGain, bestF, bestBin := gbdtMinSplitGain, -1, 0
+	for f, cuts := range b.cuts {
+		if len(cuts) == 0 {
+			continue
+		}
+		hg := make([]float64, len(cuts)+1)
+		hh := make([]float64, len(cuts)+1)
+		for _, i := range idx {
+			bin := b.bins[f][i]
+			hg[bin] += b.g[i]
+			hh[bin] += b.h[i]
+		}
+		var gl, hl float64
+		for bin := 0; bin < len(cuts); bin++ {
+			gl += hg[bin]
+			hl += hh[bin]
+			gr, hr := G-gl, H-hl
+			if hl < gbdtMinChildHess || hr < gbdtMinChildHess {
+				continue
+			}
+			gain := gl*gl/(hl+gbdtLambda) + gr*gr/(hr+gbdtLambda) - parent
+			if gain > bestGain {
+				bestGain, bestF, bestBin = gain, f, bin
+			}
+		}
+	}
+	if bestF < 0 {
+		return self
+	}
+
+	var left, right []int
+	for _, i := range idx {
+		if int(b.bins[bestF][i]) <= bestBin {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+	l := b.grow(left, depth-1)
+	r := b.grow(right, depth-1)
+	b.tree.Nodes[self] = gbdtNode{
+		Feature:   ltrFeatureKeys[bestF],
+		Threshold: b.cuts[bestF][bestBin],
+		Left:      l,
+		Right:     r,
+	}
+	return self
+}
@@ -0,0 +1,517 @@
+// Command ltrtrain trains the ranker's learned heads offline, checks them on
+// data they never saw, and publishes them as versioned artifacts the server
+// can serve next to, or instead of, the weights it learns online.
+//
+// Why this exists
+// ---------------
+// The LTR head, the watch-ratio head and the Platt calibration all learn one
+// event at a time (learning_to_rank.go, watch_ratio.go, calibration.go), with
+// their weights held only in Redis. That keeps them current, but nothing ever
+// measures whether they are any good, a bad hour of traffic moves them as
+// much as a good one, and there is nowhere to try a richer model.
+//
+// This command fits the same per-cohort models in batch from the ranking log
+// (ranking_log.go): every logged impression carries the score breakdown it
+// was ranked with and its position, and its outcome is whatever the user did
+// with it in feed_events within the next 30 minutes — the same window in
+// which the online path would have found the stashed breakdown. Labels,
+// watch ratios and position weights follow the online path exactly (see
+// data.go), so the offline and online heads learn the same thing from the
+// same event; offline they just see all of it at once. The ranking log is a
+// sample of pages (RANKING_LOG_SAMPLE); raise it to train on more.
+//
+// What it trains, per cohort:
+//
+//   - the LTR head: logistic regression over ltrFeatureKeys, or with -gbdt a
+//     gradient-boosted tree ensemble on the same features and loss;
+//   - the watch-ratio head: the same regression wrObserve runs online;
+//   - one Platt calibration over every cohort's LTR logits, as online.
+//
+// and it evaluates each on a time-split holdout: the latest -holdout share of
+// the window, which the models never trained on. The report gives log loss
+// and AUC for engagement (against always predicting the base rate), log loss
+// and calibration error after Platt scaling, and watch-ratio RMSE (against
+// always predicting the mean). With -gbdt the linear head is fitted and
+// scored too, so the two can be compared on the same holdout.
+//
+// Publishing
+// ----------
+// -publish stores the heads and the report in model_artifacts as a new
+// version, switched off. Nothing is served until it is activated:
+//
+//	-activate N -mode blend -blend 0.3   serve alongside the online weights
+//	-activate N -mode replace            serve in place of them
+//	-deactivate                          back to online weights only
+//
+// Activating one version switches off whichever was live. Every server picks
+// the change up within a minute (offline_models.go). The online heads keep
+// learning throughout, so deactivating is always safe.
+//
+// Usage:
+//
+//	export DATABASE_URL='postgres://...'
+//	go run ./cmd/ltrtrain                                  # train and report
+//	go run ./cmd/ltrtrain -since 2026-09-01 -gbdt -publish # train, report, publish
+//	go run ./cmd/ltrtrain -list
+//	go run ./cmd/ltrtrain -activate 12 -mode blend -blend 0.3
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	var (
+		since       = flag.String("since", "", "first day of impressions to train on, YYYY-MM-DD (default: 28 days ago)")
+		until       = flag.String("until", "", "day after the last day to train on, YYYY-MM-DD (default: now)")
+		holdout     = flag.Float64("holdout", 0.2, "latest share of the window held out for evaluation")
+		useGBDT     = flag.Bool("gbdt", false, "serve a gradient-boosted LTR head instead of the linear one")
+		iterations  = flag.Int("iters", 300, "gradient steps for the linear heads")
+		lr          = flag.Float64("lr", 0.5, "step size for the linear heads")
+		l2          = flag.Float64("l2", 1e-3, "L2 weight decay for the linear heads")
+		trees       = flag.Int("trees", 100, "boosting rounds (-gbdt)")
+		depth       = flag.Int("depth", 3, "tree depth (-gbdt)")
+		shrinkage   = flag.Float64("shrinkage", 0.1, "boosting learning rate (-gbdt)")
+		minExamples = flag.Int("min-examples", 200, "train no model for a cohort with fewer examples")
+		name        = flag.String("name", "", "label stored with a published artifact")
+		out         = flag.String("out", "", "also write the artifact payload to this file")
+		publish     = flag.Bool("publish", false, "store the trained heads as a new artifact (switched off)")
+		asJSON      = flag.Bool("json", false, "print the report as JSON")
+
+		list       = flag.Bool("list", false, "list published artifacts and exit")
+		activate   = flag.Int64("activate", 0, "serve this artifact version and exit")
+		mode       = flag.String("mode", "blend", "with -activate: blend or replace")
+		blend      = flag.Float64("blend", 0.5, "with -activate -mode blend: share of the offline logit")
+		deactivate = flag.Bool("deactivate", false, "stop serving any artifact and exit")
+	)
+	flag.Parse()
+	log.SetFlags(0)
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("ltrtrain: DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("ltrtrain: %v", err)
+	}
+	defer db.Close()
+
+	switch {
+	case *list:
+		if err := listArtifacts(db); err != nil {
+			log.Fatalf("ltrtrain: %v", err)
+		}
+		return
+	case *deactivate:
+		if _, err := db.Exec(`UPDATE model_artifacts SET mode = 'off' WHERE mode <> 'off'`); err != nil {
+			log.Fatalf("ltrtrain: %v", err)
+		}
+		fmt.Println("no artifact is live; servers return to online weights within a minute")
+		return
+	case *activate > 0:
+		if err := activateArtifact(db, *activate, *mode, *blend); err != nil {
+			log.Fatalf("ltrtrain: %v", err)
+		}
+		fmt.Printf("artifact %d is live (%s); servers pick it up within a minute\n", *activate, *mode)
+		return
+	}
+
+	if *holdout <= 0 || *holdout >= 1 {
+		log.Fatal("ltrtrain: -holdout must be between 0 and 1")
+	}
+	from, to, err := window(*since, *until, time.Now())
+	if err != nil {
+		log.Fatalf("ltrtrain: %v", err)
+	}
+	examples, err := loadExamples(db, from, to)
+	if err != nil {
+		log.Fatalf("ltrtrain: reading the ranking log: %v", err)
+	}
+	if len(examples) == 0 {
+		log.Fatalf("ltrtrain: no logged impressions with outcomes between %s and %s",
+			from.Format(time.DateOnly), to.Format(time.DateOnly))
+	}
+
+	cfg := trainConfig{Iterations: *iterations, LearningRate: *lr, L2: *l2,
+		Trees: *trees, Depth: *depth, Shrinkage: *shrinkage, MinExamples: *minExamples}
+	res := train(examples, *holdout, *useGBDT, cfg)
+	res.Report.From, res.Report.Until = from, to
+	if len(res.Payload.LTR)+len(res.Payload.LTRTrees)+len(res.Payload.WatchRatio) == 0 {
+		log.Fatalf("ltrtrain: no cohort had %d training examples; nothing trained", *minExamples)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res.Report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printReport(res.Report)
+	}
+
+	payload, err := json.Marshal(res.Payload)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out != "" {
+		if err := os.WriteFile(*out, payload, 0o644); err != nil {
+			log.Fatalf("ltrtrain: %v", err)
+		}
+	}
+	if *publish {
+		id, err := publishArtifact(db, *name, res, payload)
+		if err != nil {
+			log.Fatalf("ltrtrain: publishing: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "published artifact %d (off); serve it with -activate %d\n", id, id)
+	}
+}
+
+// window turns the -since/-until flags into a time range.
+func window(since, until string, now time.Time) (time.Time, time.Time, error) {
+	from, to := now.AddDate(0, 0, -28), now
+	var err error
+	if since != "" {
+		if from, err = time.Parse(time.DateOnly, since); err != nil {
+			return from, to, fmt.Errorf("-since: %w", err)
+		}
+	}
+	if until != "" {
+		if to, err = time.Parse(time.DateOnly, until); err != nil {
+			return from, to, fmt.Errorf("-until: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("-since must be before -until")
+	}
+	return from, to, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// TRAINING RUN
+// ─────────────────────────────────────────────────────────────────────────────
+
+// The payload shapes match what the server decodes (offlineArtifactPayload
+// in offline_models.go), which for ltr and watchRatio are the same JSON the
+// online heads keep in Redis.
+type ltrModelJSON struct {
+	Weights map[string]float64 `json:"weights"`
+	Bias    float64            `json:"bias"`
+	Updates int                `json:"updates"`
+}
+
+type wrModelJSON struct {
+	Weights   map[string]float64 `json:"weights"`
+	Bias      float64            `json:"bias"`
+	Samples   int                `json:"samples"`
+	MeanRatio float64            `json:"meanRatio"`
+}
+
+type calibrationJSON struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+type artifactPayload struct {
+	Features    []string                `json:"features"`
+	LTR         map[string]ltrModelJSON `json:"ltr,omitempty"`
+	LTRTrees    map[string]gbdtModel    `json:"ltrTrees,omitempty"`
+	WatchRatio  map[string]wrModelJSON  `json:"watchRatio,omitempty"`
+	Calibration *calibrationJSON        `json:"calibration,omitempty"`
+}
+
+type cohortReport struct {
+	Cohort     string             `json:"cohort"`
+	TrainLTR   int                `json:"trainLtr"`
+	TrainWR    int                `json:"trainWatchRatio"`
+	LTR        *classifierMetrics `json:"ltr,omitempty"`
+	LinearLTR  *classifierMetrics `json:"linearLtr,omitempty"` // with -gbdt, the linear head for comparison
+	WatchRatio *regressionMetrics `json:"watchRatio,omitempty"`
+}
+
+type trainReport struct {
+	From        time.Time          `json:"from"`
+	Until       time.Time          `json:"until"`
+	HoldoutFrom time.Time          `json:"holdoutFrom"`
+	Examples    int                `json:"examples"`
+	Train       int                `json:"train"`
+	Test        int                `json:"test"`
+	LTRKind     string             `json:"ltrKind"`
+	Calibration *calibrationJSON   `json:"calibration,omitempty"`
+	Overall     *classifierMetrics `json:"overall,omitempty"`
+	Cohorts     []cohortReport     `json:"cohorts"`
+}
+
+type trainResult struct {
+	Payload artifactPayload
+	Report  trainReport
+}
+
+// ltrSet and wrSet flatten a cohort's examples into what each head trains on.
+func ltrSet(exs []example) (xs [][]float64, ys, ws []float64) {
+	for _, ex := range exs {
+		if ex.HasLabel {
+			xs, ys, ws = append(xs, ex.X), append(ys, ex.Label), append(ws, ex.Weight)
+		}
+	}
+	return
+}
+
+func wrSet(exs []example) (xs [][]float64, ys, ws []float64) {
+	for _, ex := range exs {
+		for _, r := range ex.Ratios {
+			xs, ys, ws = append(xs, ex.X), append(ys, r), append(ws, ex.Weight)
+		}
+	}
+	return
+}
+
+func mean(ys []float64) float64 {
+	if len(ys) == 0 {
+		return 0
+	}
+	var s float64
+	for _, y := range ys {
+		s += y
+	}
+	return s / float64(len(ys))
+}
+
+// train fits every head and scores it on the holdout.
+func train(examples []example, holdout float64, useGBDT bool, cfg trainConfig) trainResult {
+	trainSet, testSet := timeSplit(examples, holdout)
+	res := trainResult{
+		Payload: artifactPayload{Features: ltrFeatureKeys, LTR: map[string]ltrModelJSON{},
+			LTRTrees: map[string]gbdtModel{}, WatchRatio: map[string]wrModelJSON{}},
+		Report: trainReport{Examples: len(examples), Train: len(trainSet), Test: len(testSet), LTRKind: "linear"},
+	}
+	if useGBDT {
+		res.Report.LTRKind = "gbdt"
+	}
+	if len(testSet) > 0 {
+		res.Report.HoldoutFrom = testSet[0].ServedAt
+	}
+	trainBy, testBy := byCohort(trainSet), byCohort(testSet)
+	cohorts := make([]string, 0, len(trainBy))
+	for c := range trainBy {
+		cohorts = append(cohorts, c)
+	}
+	sort.Strings(cohorts)
+
+	// The served LTR logit per cohort, for calibration and evaluation.
+	served := map[string]func([]float64) float64{}
+	linear := map[string]linearModel{}
+	var calZ, calY []float64
+	for _, c := range cohorts {
+		xs, ys, ws := ltrSet(trainBy[c])
+		if len(xs) < cfg.MinExamples {
+			continue
+		}
+		lm := fitSigmoid(xs, ys, ws, cfg)
+		linear[c] = lm
+		served[c] = lm.logit
+		if useGBDT {
+			gm := fitGBDT(xs, ys, ws, cfg)
+			res.Payload.LTRTrees[c] = gm
+			served[c] = gm.logit
+		} else {
+			res.Payload.LTR[c] = ltrModelJSON{Weights: lm.weightMap(), Bias: lm.Bias, Updates: len(xs)}
+		}
+		for i, x := range xs {
+			calZ, calY = append(calZ, served[c](x)), append(calY, ys[i])
+		}
+	}
+	if len(calZ) > 0 {
+		a, b := fitPlatt(calZ, calY)
+		res.Payload.Calibration = &calibrationJSON{A: a, B: b}
+		res.Report.Calibration = res.Payload.Calibration
+	}
+
+	var allZ, allY []float64
+	var allBase float64
+	for _, c := range cohorts {
+		cr := cohortReport{Cohort: c}
+		txs, tys, _ := ltrSet(trainBy[c])
+		cr.TrainLTR = len(txs)
+		if f, ok := served[c]; ok {
+			base := mean(tys)
+			hxs, hys, _ := ltrSet(testBy[c])
+			zs := make([]float64, len(hxs))
+			lzs := make([]float64, len(hxs))
+			for i, x := range hxs {
+				zs[i] = f(x)
+				lzs[i] = linear[c].logit(x)
+			}
+			cal := res.Payload.Calibration
+			m := evalClassifier(zs, hys, base, cal.A, cal.B)
+			cr.LTR = &m
+			if useGBDT {
+				lm := evalClassifier(lzs, hys, base, cal.A, cal.B)
+				cr.LinearLTR = &lm
+			}
+			allZ, allY = append(allZ, zs...), append(allY, hys...)
+			allBase += base * float64(len(hys))
+		}
+
+		wxs, wys, wws := wrSet(trainBy[c])
+		cr.TrainWR = len(wxs)
+		if len(wxs) >= cfg.MinExamples {
+			wm := fitSigmoid(wxs, wys, wws, cfg)
+			mr := mean(wys)
+			res.Payload.WatchRatio[c] = wrModelJSON{Weights: wm.weightMap(), Bias: wm.Bias, Samples: len(wxs), MeanRatio: mr}
+			hxs, hys, _ := wrSet(testBy[c])
+			preds := make([]float64, len(hxs))
+			for i, x := range hxs {
+				preds[i] = sigmoid(wm.logit(x))
+			}
+			m := evalRegression(preds, hys, mr)
+			cr.WatchRatio = &m
+		}
+		res.Report.Cohorts = append(res.Report.Cohorts, cr)
+	}
+	if len(allZ) > 0 {
+		cal := res.Payload.Calibration
+		m := evalClassifier(allZ, allY, allBase/float64(len(allY)), cal.A, cal.B)
+		res.Report.Overall = &m
+	}
+	if len(res.Payload.LTR) == 0 {
+		res.Payload.LTR = nil
+	}
+	if len(res.Payload.LTRTrees) == 0 {
+		res.Payload.LTRTrees = nil
+	}
+	if len(res.Payload.WatchRatio) == 0 {
+		res.Payload.WatchRatio = nil
+	}
+	return res
+}
+
+func printReport(r trainReport) {
+	fmt.Printf("%d impressions with outcomes, %s to %s; trained on %d, held out %d from %s; LTR head: %s\n",
+		r.Examples, r.From.Format(time.DateOnly), r.Until.Format(time.DateOnly), r.Train, r.Test,
+		r.HoldoutFrom.Format(time.RFC3339), r.LTRKind)
+	if r.Calibration != nil {
+		fmt.Printf("calibration: A=%.4f B=%.4f\n", r.Calibration.A, r.Calibration.B)
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "cohort\ttrain\tholdout\tlog loss (base)\tAUC\tcalibrated loss\tECE\tlinear AUC\twatch RMSE (base)")
+	row := func(name string, train int, m *classifierMetrics, lin *classifierMetrics, wr *regressionMetrics) {
+		cols := []string{name, fmt.Sprint(train), "-", "-", "-", "-", "-", "-", "-"}
+		if m != nil {
+			cols[2] = fmt.Sprint(m.N)
+			cols[3] = fmt.Sprintf("%.4f (%.4f)", m.LogLoss, m.BaselineLogLoss)
+			cols[4] = fmt.Sprintf("%.3f", m.AUC)
+			cols[5] = fmt.Sprintf("%.4f", m.CalibratedLoss)
+			cols[6] = fmt.Sprintf("%.3f", m.ECE)
+		}
+		if lin != nil {
+			cols[7] = fmt.Sprintf("%.3f", lin.AUC)
+		}
+		if wr != nil {
+			cols[8] = fmt.Sprintf("%.4f (%.4f)", wr.RMSE, wr.BaselineRMSE)
+		}
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	}
+	for _, c := range r.Cohorts {
+		row(c.Cohort, c.TrainLTR, c.LTR, c.LinearLTR, c.WatchRatio)
+	}
+	if r.Overall != nil {
+		row("all", r.Train, r.Overall, nil, nil)
+	}
+	tw.Flush()
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// ARTIFACTS
+// ─────────────────────────────────────────────────────────────────────────────
+
+func publishArtifact(db *sql.DB, name string, res trainResult, payload []byte) (int64, error) {
+	metrics, err := json.Marshal(res.Report)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO model_artifacts (name, ltr_kind, payload, metrics, trained_from, trained_until, examples)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		name, res.Report.LTRKind, string(payload), string(metrics),
+		res.Report.From, res.Report.Until, res.Report.Train).Scan(&id)
+	return id, err
+}
+
+// activateArtifact makes id the live artifact, switching off the previous
+// one in the same transaction so there is never a moment with two.
+func activateArtifact(db *sql.DB, id int64, mode string, blend float64) error {
+	if mode != "blend" && mode != "replace" {
+		return fmt.Errorf("-mode must be blend or replace, not %q", mode)
+	}
+	if blend <= 0 || blend > 1 {
+		return errors.New("-blend must be in (0,1]")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE model_artifacts SET mode = 'off' WHERE mode <> 'off' AND id <> $1`, id); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE model_artifacts SET mode = $2, blend = $3, activated_at = NOW() WHERE id = $1`,
+		id, mode, blend)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no artifact %d", id)
+	}
+	return tx.Commit()
+}
+
+func listArtifacts(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id, name, ltr_kind, mode, blend, examples, trained_from, trained_until, created_at,
+		       COALESCE((metrics->'overall'->>'auc')::float, 0)
+		FROM model_artifacts ORDER BY id DESC LIMIT 50`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "version\tname\tltr\tmode\texamples\ttrained on\tholdout AUC\tpublished")
+	for rows.Next() {
+		var (
+			id                     int64
+			name, kind, mode       string
+			blend, auc             float64
+			examples               int
+			from, until, published time.Time
+		)
+		if err := rows.Scan(&id, &name, &kind, &mode, &blend, &examples, &from, &until, &published, &auc); err != nil {
+			return err
+		}
+		if mode == "blend" {
+			mode = fmt.Sprintf("blend %.2f", blend)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s–%s\t%.3f\t%s\n", id, name, kind, mode, examples,
+			from.Format(time.DateOnly), until.Format(time.DateOnly), auc, published.Format(time.DateTime))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
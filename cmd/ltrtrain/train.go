@@ -0,0 +1,237 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// trainConfig is the knobs the command line exposes.
+type trainConfig struct {
+	Iterations   int     // full-batch gradient steps for the linear heads
+	LearningRate float64 // step size for the linear heads
+	L2           float64 // weight decay for the linear heads
+	Trees        int     // boosting rounds for the gbdt head
+	Depth        int     // tree depth for the gbdt head
+	Shrinkage    float64 // boosting learning rate
+	MinExamples  int     // a cohort with fewer training examples gets no model
+}
+
+func sigmoid(z float64) float64 { return 1 / (1 + math.Exp(-z)) }
+
+// linearModel is the shape of ltrModel and wrModel in the server, less the
+// bookkeeping.
+type linearModel struct {
+	W    []float64
+	Bias float64
+}
+
+func (m linearModel) logit(x []float64) float64 {
+	z := m.Bias
+	for i, v := range x {
+		z += m.W[i] * v
+	}
+	return z
+}
+
+func (m linearModel) weightMap() map[string]float64 {
+	out := make(map[string]float64, len(ltrFeatureKeys))
+	for i, k := range ltrFeatureKeys {
+		out[k] = m.W[i]
+	}
+	return out
+}
+
+// fitSigmoid fits σ(w·x+b) to targets in [0,1] by full-batch gradient
+// descent on the gradient the online heads step along, (σ(z)−y)·x, with
+// each example weighted by its position weight and L2 on w. For 0/1 labels
+// that is logistic regression (the LTR head); for watch ratios it is the
+// watch-ratio head's regression. Online each sample is one noisy step; here
+// every step sees all of them.
+func fitSigmoid(xs [][]float64, ys, ws []float64, cfg trainConfig) linearModel {
+	nf := len(ltrFeatureKeys)
+	m := linearModel{W: make([]float64, nf)}
+	if len(xs) == 0 {
+		return m
+	}
+	var wsum float64
+	for _, w := range ws {
+		wsum += w
+	}
+	// Start the bias at the weighted base rate so the early steps are spent
+	// on the features, not on finding the mean.
+	var mean float64
+	for i, y := range ys {
+		mean += ws[i] * y
+	}
+	mean = math.Min(1-1e-6, math.Max(1e-6, mean/wsum))
+	m.Bias = math.Log(mean / (1 - mean))
+
+	grad := make([]float64, nf)
+	for it := 0; it < cfg.Iterations; it++ {
+		for j := range grad {
+			grad[j] = 0
+		}
+		var gb float64
+		for i, x := range xs {
+			e := ws[i] * (sigmoid(m.logit(x)) - ys[i])
+			for j, v := range x {
+				grad[j] += e * v
+			}
+			gb += e
+		}
+		for j := range m.W {
+			m.W[j] -= cfg.LearningRate * (grad[j]/wsum + cfg.L2*m.W[j])
+		}
+		m.Bias -= cfg.LearningRate * gb / wsum
+	}
+	return m
+}
+
+// fitPlatt fits p = σ(A·z + B) to (logit, label) pairs, as plattFit does
+// online over its rolling buffer.
+func fitPlatt(zs, ys []float64) (a, b float64) {
+	a = 1
+	if len(zs) == 0 {
+		return a, 0
+	}
+	const epochs, lr = 500, 0.05
+	inv := 1 / float64(len(zs))
+	for e := 0; e < epochs; e++ {
+		var ga, gb float64
+		for i, z := range zs {
+			err := sigmoid(a*z+b) - ys[i]
+			ga += err * z
+			gb += err
+		}
+		a -= lr * ga * inv
+		b -= lr * gb * inv
+	}
+	return a, b
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// EVALUATION
+// ─────────────────────────────────────────────────────────────────────────────
+
+// classifierMetrics scores engagement predictions on the holdout. Baseline
+// is the log loss of always predicting the training base rate: a model that
+// cannot beat it has learned nothing worth serving.
+type classifierMetrics struct {
+	N               int     `json:"n"`
+	PositiveRate    float64 `json:"positiveRate"`
+	LogLoss         float64 `json:"logLoss"`
+	BaselineLogLoss float64 `json:"baselineLogLoss"`
+	AUC             float64 `json:"auc"`
+	CalibratedLoss  float64 `json:"calibratedLogLoss"`
+	ECE             float64 `json:"ece"` // expected calibration error, 10 bins, after calibration
+}
+
+// regressionMetrics scores watch-ratio predictions on the holdout against
+// always predicting the training mean.
+type regressionMetrics struct {
+	N            int     `json:"n"`
+	RMSE         float64 `json:"rmse"`
+	BaselineRMSE float64 `json:"baselineRmse"`
+}
+
+func logLoss(ps, ys []float64) float64 {
+	if len(ps) == 0 {
+		return 0
+	}
+	var s float64
+	for i, p := range ps {
+		p = math.Min(1-1e-12, math.Max(1e-12, p))
+		s -= ys[i]*math.Log(p) + (1-ys[i])*math.Log(1-p)
+	}
+	return s / float64(len(ps))
+}
+
+// auc is the chance a random positive is scored above a random negative,
+// ties counting half.
+func auc(scores, ys []float64) float64 {
+	idx := make([]int, len(scores))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return scores[idx[a]] < scores[idx[b]] })
+	var pos, neg, rankSum float64
+	for i := 0; i < len(idx); {
+		j := i
+		for j < len(idx) && scores[idx[j]] == scores[idx[i]] {
+			j++
+		}
+		avgRank := float64(i+j+1) / 2 // 1-based ranks i+1..j
+		for k := i; k < j; k++ {
+			if ys[idx[k]] >= 0.5 {
+				pos++
+				rankSum += avgRank
+			} else {
+				neg++
+			}
+		}
+		i = j
+	}
+	if pos == 0 || neg == 0 {
+		return 0.5
+	}
+	return (rankSum - pos*(pos+1)/2) / (pos * neg)
+}
+
+func expectedCalibrationError(ps, ys []float64) float64 {
+	const bins = 10
+	var cnt, sumP, sumY [bins]float64
+	for i, p := range ps {
+		b := int(p * bins)
+		if b >= bins {
+			b = bins - 1
+		}
+		cnt[b]++
+		sumP[b] += p
+		sumY[b] += ys[i]
+	}
+	var ece float64
+	for b := 0; b < bins; b++ {
+		if cnt[b] > 0 {
+			ece += cnt[b] / float64(len(ps)) * math.Abs(sumP[b]/cnt[b]-sumY[b]/cnt[b])
+		}
+	}
+	return ece
+}
+
+func evalClassifier(zs, ys []float64, baseRate, a, b float64) classifierMetrics {
+	m := classifierMetrics{N: len(zs)}
+	if len(zs) == 0 {
+		return m
+	}
+	ps := make([]float64, len(zs))
+	cal := make([]float64, len(zs))
+	base := make([]float64, len(zs))
+	for i, z := range zs {
+		ps[i] = sigmoid(z)
+		cal[i] = sigmoid(a*z + b)
+		base[i] = baseRate
+		m.PositiveRate += ys[i]
+	}
+	m.PositiveRate /= float64(len(ys))
+	m.LogLoss = logLoss(ps, ys)
+	m.BaselineLogLoss = logLoss(base, ys)
+	m.AUC = auc(zs, ys)
+	m.CalibratedLoss = logLoss(cal, ys)
+	m.ECE = expectedCalibrationError(cal, ys)
+	return m
+}
+
+func evalRegression(preds, ys []float64, mean float64) regressionMetrics {
+	m := regressionMetrics{N: len(preds)}
+	if len(preds) == 0 {
+		return m
+	}
+	var se, seBase float64
+	for i, p := range preds {
+		se += (p - ys[i]) * (p - ys[i])
+		seBase += (mean - ys[i]) * (mean - ys[i])
+	}
+	m.RMSE = math.Sqrt(se / float64(len(preds)))
+	m.BaselineRMSE = math.Sqrt(seBase / float64(len(preds)))
+	return m
+}
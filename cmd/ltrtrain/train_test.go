@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// synthetic draws n examples whose label depends on the features through f.
+func synthetic(n int, seed int64, f func(x []float64) float64) (xs [][]float64, ys, ws []float64) {
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		x := make([]float64, len(ltrFeatureKeys))
+		for j := range x {
+			x[j] = rng.Float64()
+		}
+		y := 0.0
+		if rng.Float64() < f(x) {
+			y = 1
+		}
+		xs, ys, ws = append(xs, x), append(ys, y), append(ws, 1)
+	}
+	return
+}
+
+var testConfig = trainConfig{Iterations: 300, LearningRate: 0.5, L2: 1e-4, Trees: 60, Depth: 3, Shrinkage: 0.2}
+
+func TestFitSigmoidLearnsFeatureDirection(t *testing.T) {
+	// Engagement rises with relevance (index 3) and falls with fatiguePenalty
+	// (index 10); nothing else matters.
+	xs, ys, ws := synthetic(4000, 1, func(x []float64) float64 { return sigmoid(4*x[3] - 4*x[10]) })
+	m := fitSigmoid(xs, ys, ws, testConfig)
+	w := m.weightMap()
+	if w["relevance"] <= 1 || w["fatiguePenalty"] >= -1 {
+		t.Errorf("relevance %.2f, fatiguePenalty %.2f: want clearly positive and negative", w["relevance"], w["fatiguePenalty"])
+	}
+	if math.Abs(w["novelty"]) > 0.5 {
+		t.Errorf("novelty %.2f: want near zero for a feature the label ignores", w["novelty"])
+	}
+}
+
+func TestGBDTLearnsInteractionLinearCannot(t *testing.T) {
+	// Fresh content is good unless the user is fatigued: an XOR-like
+	// interaction a linear head has no weight for.
+	f := func(x []float64) float64 {
+		if (x[1] > 0.5) != (x[10] > 0.5) {
+			return 0.9
+		}
+		return 0.1
+	}
+	xs, ys, ws := synthetic(3000, 2, f)
+	hxs, hys, _ := synthetic(1000, 3, f)
+
+	lm := fitSigmoid(xs, ys, ws, testConfig)
+	gm := fitGBDT(xs, ys, ws, testConfig)
+	lz := make([]float64, len(hxs))
+	gz := make([]float64, len(hxs))
+	for i, x := range hxs {
+		lz[i], gz[i] = lm.logit(x), gm.logit(x)
+	}
+	la, ga := auc(lz, hys), auc(gz, hys)
+	if ga < 0.75 || ga < la+0.15 {
+		t.Errorf("holdout AUC: gbdt %.3f, linear %.3f; want the trees well ahead", ga, la)
+	}
+}
+
+func TestBuildExampleStopsAtFirstLabel(t *testing.T) {
+	ex := buildExample("engaged", time.Now(), 3, map[string]float64{"relevance": 0.7}, []outcomeEvent{
+		{Type: "view", CompletionRate: 0.5}, // ratio, no label
+		{Type: "skip"},                      // ratio and label: ends the item
+		{Type: "like"},                      // online the breakdown is gone by now
+	})
+	if !ex.HasLabel || ex.Label != 0 {
+		t.Errorf("label = %v (%v), want the skip's 0", ex.Label, ex.HasLabel)
+	}
+	if len(ex.Ratios) != 2 || ex.Ratios[0] != 0.5 || ex.Ratios[1] != 0 {
+		t.Errorf("ratios = %v, want [0.5 0]", ex.Ratios)
+	}
+	if ex.X[3] != 0.7 {
+		t.Errorf("relevance = %v, want 0.7 at its ltrFeatureKeys index", ex.X[3])
+	}
+	if math.Abs(ex.Weight-math.Pow(3, 0.7)) > 1e-9 {
+		t.Errorf("weight = %v, want 1/propensity(3)", ex.Weight)
+	}
+}
+
+func TestTimeSplitHoldsOutTheLatest(t *testing.T) {
+	base := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	var exs []example
+	for _, h := range []int{5, 1, 9, 3, 7, 0, 8, 2, 6, 4} {
+		exs = append(exs, example{ServedAt: base.Add(time.Duration(h) * time.Hour)})
+	}
+	train, test := timeSplit(exs, 0.3)
+	if len(train) != 7 || len(test) != 3 {
+		t.Fatalf("split %d/%d, want 7/3", len(train), len(test))
+	}
+	if !train[len(train)-1].ServedAt.Before(test[0].ServedAt) {
+		t.Error("a training example is later than the holdout")
+	}
+}
+
+func TestAUCCountsTiesAsHalf(t *testing.T) {
+	if got := auc([]float64{0.1, 0.4, 0.35, 0.8}, []float64{0, 0, 1, 1}); got != 0.75 {
+		t.Errorf("auc = %v, want 0.75", got)
+	}
+	if got := auc([]float64{1, 1, 1, 1}, []float64{0, 1, 0, 1}); got != 0.5 {
+		t.Errorf("auc with all ties = %v, want 0.5", got)
+	}
+}
+
+func TestPayloadMatchesServerShape(t *testing.T) {
+	gm := gbdtModel{Bias: -1, Samples: 500, Trees: []gbdtTree{{Nodes: []gbdtNode{
+		{Feature: "relevance", Threshold: 0.5, Left: 1, Right: 2}, {Leaf: true, Value: -0.2}, {Leaf: true, Value: 0.3},
+	}}}}
+	p := artifactPayload{
+		Features:    ltrFeatureKeys,
+		LTRTrees:    map[string]gbdtModel{"engaged": gm},
+		WatchRatio:  map[string]wrModelJSON{"engaged": {Weights: map[string]float64{"relevance": 1}, Samples: 300, MeanRatio: 0.4}},
+		Calibration: &calibrationJSON{A: 1.1, B: -0.2},
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"features", "ltrTrees", "watchRatio", "calibration"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("payload has no %q", k)
+		}
+	}
+	if _, ok := got["ltr"]; ok {
+		t.Error("an empty linear head should be left out")
+	}
+	x := make([]float64, len(ltrFeatureKeys))
+	x[featureIndex["relevance"]] = 0.9
+	if z := gm.logit(x); math.Abs(z-(-0.7)) > 1e-9 {
+		t.Errorf("logit = %v, want -0.7 (right leaf)", z)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// LOGGED DATA
+// ─────────────────────────────────────────────────────────────────────────────
+
+// impression is one row of ranking_impressions.
+type impression struct {
+	Position   int
+	Propensity float64
+	CreatorID  string
+	Score      float64
+	Breakdown  map[string]float64
+	Embedding  []float64
+	Completed  bool
+	Liked      bool
+	Skipped    bool
+}
+
+// slate is one logged page, items in served order. Positions can have gaps
+// where the page carried an injected item, which is not logged.
+type slate struct {
+	RequestID string
+	Cohort    string
+	Items     []impression
+}
+
+const (
+	metricCompletion = iota
+	metricLike
+	metricSkip
+	numMetrics
+)
+
+var metricNames = [numMetrics]string{"completion", "like", "skip"}
+
+// reward is the outcome of one impression for a metric, as 0 or 1.
+func (im impression) reward(metric int) float64 {
+	var b bool
+	switch metric {
+	case metricCompletion:
+		b = im.Completed
+	case metricLike:
+		b = im.Liked
+	case metricSkip:
+		b = im.Skipped
+	}
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// POLICY
+// ─────────────────────────────────────────────────────────────────────────────
+
+// policy is a candidate ranking change, expressed against production:
+// multipliers on score-breakdown terms, optionally per cohort, and optional
+// MMR settings. An empty policy is production.
+//
+//	{
+//	  "name": "more social, less novelty",
+//	  "terms": {"socialTerm": 1.3, "noveltyTerm": 0.8},
+//	  "cohorts": {"new": {"coldContentBonus": 1.5}},
+//	  "mmr": {"lambdaHead": 0.6, "lambdaTail": 0.85, "creatorPenalty": 0.25}
+//	}
+//
+// A cohort's multiplier stacks on the global one for the same term.
+type policy struct {
+	Name    string                        `json:"name"`
+	Terms   map[string]float64            `json:"terms"`
+	Cohorts map[string]map[string]float64 `json:"cohorts"`
+	MMR     *mmrParams                    `json:"mmr"`
+}
+
+type mmrParams struct {
+	LambdaHead     float64 `json:"lambdaHead"`
+	LambdaTail     float64 `json:"lambdaTail"`
+	CreatorPenalty float64 `json:"creatorPenalty"`
+}
+
+// productionMMR mirrors mmrLambdaHead, mmrLambdaTail and mmrCreatorPenalty
+// in mmr.go. Keep them in step.
+var productionMMR = mmrParams{LambdaHead: 0.55, LambdaTail: 0.85, CreatorPenalty: 0.18}
+
+// additiveTerms are the breakdown keys scoreForUser sums into the score
+// before the negative-signal multiplier, so scaling one by m moves the score
+// by negativeMult·(m−1)·term. The raw signals (social, freshness, …) are not
+// here: they enter the score through their *Term, which is what to scale.
+var additiveTerms = map[string]bool{
+	"socialTerm": true, "freshnessTerm": true, "energyFitTerm": true, "relevanceTerm": true,
+	"qualityTerm": true, "noveltyTerm": true, "tieTerm": true, "affinityTerm": true,
+	"dwellTerm": true, "searchBoost": true,
+	"egoBoost": true, "fatiguePenalty": true, "creatorFatigue": true, "sequencePenalty": true,
+	"dopaminePenalty": true, "unseenBonus": true, "coldContentBonus": true, "trendingBonus": true,
+	"breakoutBonus": true, "hourBonus": true, "emotionBonus": true, "egoContextBonus": true,
+	"wellbeingBonus": true, "collabBonus": true, "momentumBonus": true, "variableReward": true,
+	"reentryBonus": true, "streakBonus": true, "impressionBouncePenalty": true,
+	"creatorBouncePenalty": true, "scrollBackBonus": true, "completeBonus": true, "loopBonus": true,
+	"unmuteBonus": true, "profileVisitBonus": true, "battleBoost": true,
+	"ltrDelta": true, "calibBonus": true, "watchRatioBonus": true, "uncertaintyBonus": true,
+	"creatorResidualAdj": true, "trajectoryBonus": true, "moodTransitionBonus": true,
+	"personaBonus": true,
+}
+
+// embedBonusTerm is added after the multiplier and already carries it.
+const embedBonusTerm = "embedBonus"
+
+func loadPolicy(path string) (policy, error) {
+	var p policy
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Name == "" {
+		p.Name = path
+	}
+	return p, nil
+}
+
+func (p policy) validate() error {
+	check := func(terms map[string]float64) error {
+		for t, m := range terms {
+			if !additiveTerms[t] && t != embedBonusTerm {
+				return fmt.Errorf("term %q is not a replayable score term", t)
+			}
+			if math.IsNaN(m) || math.IsInf(m, 0) {
+				return fmt.Errorf("term %q: multiplier must be finite", t)
+			}
+		}
+		return nil
+	}
+	if err := check(p.Terms); err != nil {
+		return err
+	}
+	for c, terms := range p.Cohorts {
+		if err := check(terms); err != nil {
+			return fmt.Errorf("cohort %s: %w", c, err)
+		}
+	}
+	if m := p.MMR; m != nil {
+		if m.LambdaHead <= 0 || m.LambdaHead > 1 || m.LambdaTail <= 0 || m.LambdaTail > 1 {
+			return fmt.Errorf("mmr lambdas must be in (0,1]")
+		}
+		if m.CreatorPenalty < 0 {
+			return fmt.Errorf("mmr creatorPenalty must not be negative")
+		}
+	}
+	return nil
+}
+
+func (p policy) multiplier(cohort, term string) float64 {
+	m := 1.0
+	if v, ok := p.Terms[term]; ok {
+		m = v
+	}
+	if v, ok := p.Cohorts[cohort][term]; ok {
+		m *= v
+	}
+	return m
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// REPLAY
+// ─────────────────────────────────────────────────────────────────────────────
+
+// rescore is the score an impression would have had under p, before MMR.
+// The logged score already has the production MMR penalty in it; that is
+// added back so replay can run MMR itself.
+func rescore(im impression, cohort string, p policy) float64 {
+	bd := im.Breakdown
+	s := im.Score + bd["mmrPenalty"]
+	negMult, ok := bd["negativeMult"]
+	if !ok {
+		negMult = 1
+	}
+	seen := map[string]bool{}
+	var terms []string
+	for _, set := range []map[string]float64{p.Terms, p.Cohorts[cohort]} {
+		for t := range set {
+			if !seen[t] {
+				seen[t] = true
+				terms = append(terms, t)
+			}
+		}
+	}
+	sort.Strings(terms) // a fixed summation order, so reruns agree
+	for _, t := range terms {
+		m := p.multiplier(cohort, t)
+		if t == embedBonusTerm {
+			s += (m - 1) * bd[t]
+		} else {
+			s += negMult * (m - 1) * bd[t]
+		}
+	}
+	return s
+}
+
+// replay returns the position each of the slate's items would have been
+// served at under p. The ranked items are reordered among the positions
+// they occupied, so an injected item's slot stays where it was.
+func replay(s slate, p policy) []int {
+	n := len(s.Items)
+	scores := make([]float64, n)
+	for i, im := range s.Items {
+		scores[i] = rescore(im, s.Cohort, p)
+	}
+	params := productionMMR
+	if p.MMR != nil {
+		params = *p.MMR
+	}
+	order := mmrOrder(s.Items, scores, params)
+
+	slots := make([]int, n)
+	for i, im := range s.Items {
+		slots[i] = im.Position
+	}
+	sort.Ints(slots)
+	newPos := make([]int, n)
+	for rank, idx := range order {
+		newPos[idx] = slots[rank]
+	}
+	return newPos
+}
+
+// mmrOrder mirrors applyMMRWithCreatorPenalty in mmr.go: seed with the top
+// score, then greedily take the item with the best λ·score minus its
+// similarity to, and creator overlap with, what is already taken. λ ramps
+// from head to tail across the slate.
+func mmrOrder(items []impression, scores []float64, params mmrParams) []int {
+	n := len(items)
+	order := make([]int, 0, n)
+	if n == 0 {
+		return order
+	}
+	taken := make([]bool, n)
+	creatorCount := map[string]int{}
+	take := func(i int) {
+		order = append(order, i)
+		taken[i] = true
+		if c := items[i].CreatorID; c != "" {
+			creatorCount[c]++
+		}
+	}
+	best := 0
+	for i := 1; i < n; i++ {
+		if scores[i] > scores[best] {
+			best = i
+		}
+	}
+	take(best)
+	for len(order) < n {
+		lambda := params.LambdaHead
+		if n > 1 {
+			t := float64(len(order)) / float64(n-1)
+			lambda = params.LambdaHead + (params.LambdaTail-params.LambdaHead)*t
+		}
+		bestI, bestMMR := -1, -1e18
+		for i := 0; i < n; i++ {
+			if taken[i] {
+				continue
+			}
+			maxSim := -1.0
+			for _, j := range order {
+				if s := cosine(items[i].Embedding, items[j].Embedding); s > maxSim {
+					maxSim = s
+				}
+			}
+			pen := (1 - lambda) * maxSim
+			if c := items[i].CreatorID; c != "" {
+				pen += params.CreatorPenalty * float64(creatorCount[c])
+			}
+			if v := lambda*scores[i] - pen; v > bestMMR {
+				bestI, bestMMR = i, v
+			}
+		}
+		take(bestI)
+	}
+	return order
+}
+
+// cosine is the dot product of two unit vectors, as cosineSim in
+// embeddings.go; mismatched lengths (a missing embedding) count as unrelated.
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var s float64
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return math.Max(-1, math.Min(1, s))
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// ESTIMATION
+// ─────────────────────────────────────────────────────────────────────────────
+
+// examination is the chance an item at pos is looked at, relative to the
+// top slot: positionPropensity in learning_to_rank.go.
+func examination(pos int) float64 {
+	if pos < 1 {
+		pos = 1
+	}
+	return math.Pow(float64(pos), -0.7)
+}
+
+const (
+	policyProduction = iota
+	policyCandidate
+	numPolicies
+)
+
+// slateStats is everything the estimators need from one slate, kept as sums
+// so a bootstrap resample only has to add slates up.
+type slateStats struct {
+	n  float64
+	r  [numMetrics]float64              // Σ r, as logged
+	w  [numPolicies]float64             // Σ w
+	w2 [numPolicies]float64             // Σ w²
+	wr [numPolicies][numMetrics]float64 // Σ w·r
+}
+
+func (a *slateStats) add(b slateStats) {
+	a.n += b.n
+	for m := 0; m < numMetrics; m++ {
+		a.r[m] += b.r[m]
+	}
+	for p := 0; p < numPolicies; p++ {
+		a.w[p] += b.w[p]
+		a.w2[p] += b.w2[p]
+		for m := 0; m < numMetrics; m++ {
+			a.wr[p][m] += b.wr[p][m]
+		}
+	}
+}
+
+func (a slateStats) ips(p, m int) float64 {
+	if a.n == 0 {
+		return 0
+	}
+	return a.wr[p][m] / a.n
+}
+
+func (a slateStats) snips(p, m int) float64 {
+	if a.w[p] == 0 {
+		return 0
+	}
+	return a.wr[p][m] / a.w[p]
+}
+
+// weigh computes a slate's sums for production and the candidate. Each
+// impression's weight is how much more (or less) likely it is to be examined
+// at its replayed position than where it was served, capped at clip. The
+// production policy is replayed too, rather than taken as logged: the replay
+// cannot reproduce everything that placed an item (see main.go), and
+// comparing two replays leaves that out of the difference.
+func weigh(s slate, candidate policy, clip float64) slateStats {
+	var st slateStats
+	pols := [numPolicies][]int{replay(s, policy{}), replay(s, candidate)}
+	for i, im := range s.Items {
+		st.n++
+		logged := im.Propensity
+		if logged <= 0 {
+			logged = examination(im.Position)
+		}
+		for m := 0; m < numMetrics; m++ {
+			st.r[m] += im.reward(m)
+		}
+		for p := 0; p < numPolicies; p++ {
+			w := math.Min(clip, examination(pols[p][i])/logged)
+			st.w[p] += w
+			st.w2[p] += w * w
+			for m := 0; m < numMetrics; m++ {
+				st.wr[p][m] += w * im.reward(m)
+			}
+		}
+	}
+	return st
+}
+
+// interval is a point estimate with a 95% bootstrap interval.
+type interval struct {
+	Value float64 `json:"value"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+type policyEstimate struct {
+	IPS   interval `json:"ips"`
+	SNIPS interval `json:"snips"`
+}
+
+type metricReport struct {
+	Metric     string         `json:"metric"`
+	Logged     float64        `json:"logged"`
+	Production policyEstimate `json:"production"`
+	Candidate  policyEstimate `json:"candidate"`
+	Delta      interval       `json:"delta"` // candidate − production, SNIPS
+	Verdict    string         `json:"verdict"`
+}
+
+type report struct {
+	Policy       string         `json:"policy"`
+	Slates       int            `json:"slates"`
+	Impressions  int            `json:"impressions"`
+	EffectiveN   float64        `json:"effectiveSampleSize"`
+	Metrics      []metricReport `json:"metrics"`
+	BootstrapRun int            `json:"bootstrapResamples"`
+}
+
+// evaluate estimates every metric for production and the candidate, with
+// intervals from a paired bootstrap over slates: both policies are scored on
+// the same resample, so the interval on the difference reflects how the two
+// differ, not how noisy each is on its own. Slates, not impressions, are
+// resampled because outcomes on one page are not independent.
+func evaluate(slates []slate, candidate policy, clip float64, resamples int, seed int64) report {
+	stats := make([]slateStats, len(slates))
+	var total slateStats
+	for i, s := range slates {
+		stats[i] = weigh(s, candidate, clip)
+		total.add(stats[i])
+	}
+	rep := report{Policy: candidate.Name, Slates: len(slates), Impressions: int(total.n), BootstrapRun: resamples}
+	if total.w2[policyCandidate] > 0 {
+		rep.EffectiveN = total.w[policyCandidate] * total.w[policyCandidate] / total.w2[policyCandidate]
+	}
+
+	type draw struct {
+		ips, snips [numPolicies][numMetrics]float64
+	}
+	draws := make([]draw, 0, resamples)
+	if len(stats) > 0 {
+		rng := rand.New(rand.NewSource(seed))
+		for b := 0; b < resamples; b++ {
+			var agg slateStats
+			for range stats {
+				agg.add(stats[rng.Intn(len(stats))])
+			}
+			var d draw
+			for p := 0; p < numPolicies; p++ {
+				for m := 0; m < numMetrics; m++ {
+					d.ips[p][m] = agg.ips(p, m)
+					d.snips[p][m] = agg.snips(p, m)
+				}
+			}
+			draws = append(draws, d)
+		}
+	}
+	ci := func(value float64, pick func(draw) float64) interval {
+		iv := interval{Value: value, Low: value, High: value}
+		if len(draws) == 0 {
+			return iv
+		}
+		xs := make([]float64, len(draws))
+		for i, d := range draws {
+			xs[i] = pick(d)
+		}
+		iv.Low, iv.High = percentile(xs, 0.025), percentile(xs, 0.975)
+		return iv
+	}
+
+	for m := 0; m < numMetrics; m++ {
+		mr := metricReport{Metric: metricNames[m]}
+		if total.n > 0 {
+			mr.Logged = total.r[m] / total.n
+		}
+		est := func(p int) policyEstimate {
+			return policyEstimate{
+				IPS:   ci(total.ips(p, m), func(d draw) float64 { return d.ips[p][m] }),
+				SNIPS: ci(total.snips(p, m), func(d draw) float64 { return d.snips[p][m] }),
+			}
+		}
+		mr.Production = est(policyProduction)
+		mr.Candidate = est(policyCandidate)
+		mr.Delta = ci(total.snips(policyCandidate, m)-total.snips(policyProduction, m), func(d draw) float64 {
+			return d.snips[policyCandidate][m] - d.snips[policyProduction][m]
+		})
+		mr.Verdict = verdict(mr.Delta, m == metricSkip)
+		rep.Metrics = append(rep.Metrics, mr)
+	}
+	return rep
+}
+
+// verdict reads a delta interval: only one that excludes zero says anything.
+func verdict(delta interval, lowerIsBetter bool) string {
+	switch {
+	case delta.Low > 0 && !lowerIsBetter, delta.High < 0 && lowerIsBetter:
+		return "better"
+	case delta.High < 0 && !lowerIsBetter, delta.Low > 0 && lowerIsBetter:
+		return "worse"
+	}
+	return "inconclusive"
+}
+
+func percentile(xs []float64, q float64) float64 {
+	sort.Float64s(xs)
+	idx := q * float64(len(xs)-1)
+	lo := int(math.Floor(idx))
+	hi := int(math.Ceil(idx))
+	return xs[lo] + (xs[hi]-xs[lo])*(idx-float64(lo))
+}
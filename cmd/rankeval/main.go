@@ -0,0 +1,224 @@
+// Command rankeval estimates, offline, how a ranking change would have done
+// on traffic already served — before anyone is shown it.
+//
+// Why this exists
+// ---------------
+// Until now a change to the For You ranker (a weight, a cohort row, the MMR
+// settings) could only be judged by shipping it to an experiment arm and
+// waiting. That is slow, and it spends real users on changes that a look at
+// last week's data would have ruled out.
+//
+// The server logs a sample of For You pages exactly as served, with every
+// item's score breakdown and position, and what the user then did with each
+// item (ranking_log.go, table ranking_impressions). This tool re-ranks those
+// logged pages under a candidate policy and estimates what completion, like
+// and skip rates would have been, by inverse propensity scoring: an outcome
+// is weighted by how much more likely the user was to look at the item where
+// the candidate would have put it than where it actually was, using the same
+// position-examination curve the online learner corrects for
+// (positionPropensity in learning_to_rank.go).
+//
+// It reports, per metric:
+//
+//   - the logged rate;
+//   - IPS and self-normalised IPS (SNIPS) estimates for production and for
+//     the candidate, each with a 95% bootstrap interval;
+//   - the candidate − production SNIPS difference with its interval, and a
+//     verdict: better or worse only when that interval excludes zero.
+//
+// SNIPS is the number to read. IPS is unbiased but noisy when the weights
+// are large; SNIPS trades a little bias for much less variance. The effective
+// sample size says how many impressions the weighting left doing the work —
+// when it is a small fraction of the impressions, the candidate moves items
+// too far from where they were served for this log to judge it.
+//
+// What a replay can and cannot see
+// --------------------------------
+// A policy is a set of multipliers on score-breakdown terms (see policy in
+// estimate.go), optionally per cohort, and optionally new MMR settings. The
+// replay rescales those terms in each logged item's score, undoes the MMR
+// penalty production applied, re-runs MMR, and reorders the page.
+//
+//   - It can only reorder what was served. An item the candidate would have
+//     pulled up from below the page was never logged, so gains from
+//     surfacing different content are invisible here.
+//   - Score steps that are not linear in the terms — the continuity damping,
+//     the clamp at zero, the behavioural caps — are not re-run; a large
+//     multiplier on a term those touch is estimated less well.
+//   - Page composition (slot patterns, the battle:short ratio, injected
+//     items) is not re-run. Injected items are not logged; their slots stay
+//     where they were and the ranked items move around them.
+//
+// Production is replayed by the same mechanics with every multiplier at 1,
+// and the candidate is compared with that replay rather than with the page as
+// served, so what the replay cannot reproduce falls out of the difference.
+//
+// Usage:
+//
+//	export DATABASE_URL='postgres://...'
+//	go run ./cmd/rankeval -policy candidate.json
+//	go run ./cmd/rankeval -policy candidate.json -since 2026-10-01 -until 2026-10-08
+//	go run ./cmd/rankeval -policy candidate.json -only scoring_weights_v1=control -json
+//
+// -only restricts the log to pages served under one experiment variant,
+// which is how to evaluate a change against the arm it would replace.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func main() {
+	var (
+		policyPath = flag.String("policy", "", "candidate policy JSON file (required)")
+		since      = flag.String("since", "", "first day of pages to use, YYYY-MM-DD (default: 7 days ago)")
+		until      = flag.String("until", "", "day after the last day of pages to use, YYYY-MM-DD (default: now)")
+		only       = flag.String("only", "", "only pages served under experiment=variant")
+		resamples  = flag.Int("bootstrap", 500, "bootstrap resamples for the intervals")
+		clip       = flag.Float64("clip", 10, "cap on any impression's weight")
+		seed       = flag.Int64("seed", 1, "bootstrap seed, for reproducible intervals")
+		asJSON     = flag.Bool("json", false, "print the report as JSON")
+	)
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *policyPath == "" {
+		log.Fatal("rankeval: -policy is required")
+	}
+	if *clip <= 0 {
+		log.Fatal("rankeval: -clip must be positive")
+	}
+	pol, err := loadPolicy(*policyPath)
+	if err != nil {
+		log.Fatalf("rankeval: %v", err)
+	}
+	from, to, err := window(*since, *until, time.Now())
+	if err != nil {
+		log.Fatalf("rankeval: %v", err)
+	}
+	var expID, variant string
+	if *only != "" {
+		var ok bool
+		expID, variant, ok = strings.Cut(*only, "=")
+		if !ok || expID == "" || variant == "" {
+			log.Fatal("rankeval: -only takes experiment=variant")
+		}
+	}
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("rankeval: DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("rankeval: %v", err)
+	}
+	defer db.Close()
+
+	slates, err := loadSlates(db, from, to, expID, variant)
+	if err != nil {
+		log.Fatalf("rankeval: reading the ranking log: %v", err)
+	}
+	if len(slates) == 0 {
+		log.Fatalf("rankeval: no logged pages between %s and %s", from.Format(time.DateOnly), to.Format(time.DateOnly))
+	}
+
+	rep := evaluate(slates, pol, *clip, *resamples, *seed)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printReport(rep)
+}
+
+// window turns the -since/-until flags into a time range.
+func window(since, until string, now time.Time) (time.Time, time.Time, error) {
+	from, to := now.AddDate(0, 0, -7), now
+	var err error
+	if since != "" {
+		if from, err = time.Parse(time.DateOnly, since); err != nil {
+			return from, to, fmt.Errorf("-since: %w", err)
+		}
+	}
+	if until != "" {
+		if to, err = time.Parse(time.DateOnly, until); err != nil {
+			return from, to, fmt.Errorf("-until: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("-since must be before -until")
+	}
+	return from, to, nil
+}
+
+// loadSlates reads the logged pages served in [from, to), grouped by page.
+func loadSlates(db *sql.DB, from, to time.Time, expID, variant string) ([]slate, error) {
+	q := `SELECT request_id, cohort, position, propensity, creator_id, score, breakdown, embedding,
+		       completed, liked, skipped
+		FROM ranking_impressions
+		WHERE served_at >= $1 AND served_at < $2`
+	args := []any{from, to}
+	if expID != "" {
+		q += ` AND variants->>$3 = $4`
+		args = append(args, expID, variant)
+	}
+	q += ` ORDER BY request_id, position`
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slates []slate
+	for rows.Next() {
+		var (
+			requestID, cohort string
+			im                impression
+			breakdown         []byte
+			embedding         pq.Float64Array
+		)
+		if err := rows.Scan(&requestID, &cohort, &im.Position, &im.Propensity, &im.CreatorID, &im.Score,
+			&breakdown, &embedding, &im.Completed, &im.Liked, &im.Skipped); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(breakdown, &im.Breakdown); err != nil {
+			return nil, fmt.Errorf("page %s position %d: %w", requestID, im.Position, err)
+		}
+		im.Embedding = embedding
+		if n := len(slates); n == 0 || slates[n-1].RequestID != requestID {
+			slates = append(slates, slate{RequestID: requestID, Cohort: cohort})
+		}
+		slates[len(slates)-1].Items = append(slates[len(slates)-1].Items, im)
+	}
+	return slates, rows.Err()
+}
+
+func printReport(rep report) {
+	fmt.Printf("policy %q: %d pages, %d impressions, effective sample size %.0f, %d bootstrap resamples\n\n",
+		rep.Policy, rep.Slates, rep.Impressions, rep.EffectiveN, rep.BootstrapRun)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "metric\tlogged\tproduction SNIPS\tcandidate SNIPS\tcandidate IPS\tdelta\tverdict")
+	iv := func(v interval) string {
+		return fmt.Sprintf("%.4f [%.4f, %.4f]", v.Value, v.Low, v.High)
+	}
+	for _, m := range rep.Metrics {
+		fmt.Fprintf(tw, "%s\t%.4f\t%s\t%s\t%s\t%+.4f [%+.4f, %+.4f]\t%s\n",
+			m.Metric, m.Logged, iv(m.Production.SNIPS), iv(m.Candidate.SNIPS), iv(m.Candidate.IPS),
+			m.Delta.Value, m.Delta.Low, m.Delta.High, m.Verdict)
+	}
+	tw.Flush()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -132,7 +133,6 @@ func observeSourceReward(cohort Cohort, source string, reward float64) {
 // to flush. Just: "what worked here gets more budget."
 func effectiveSourceWeights(cohort Cohort) map[string]float64 {
 	cohortBlendEnsureLoaded()
-	out := make(map[string]float64, len(defaultSourceWeights))
 	// COPY the per-cohort reward map under the lock — the old code released the
 	// RLock and then read `rewards[src]` in the loops below, racing with
 	// observeSourceReward's concurrent writes to the same map (a data race that can
@@ -145,7 +145,13 @@ func effectiveSourceWeights(cohort Cohort) map[string]float64 {
 		}
 	}
 	cohortBlend.mu.RUnlock()
+	return sourceWeightsFromRewards(rewards)
+}
 
+// sourceWeightsFromRewards turns one cohort's per-source reward EMAs into
+// its source mix.
+func sourceWeightsFromRewards(rewards map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(defaultSourceWeights))
 	// NOTE: do NOT subtract a cross-source mean before exp() — the renormalization
 	// below (raw/totalRaw) is shift-invariant, so exp(r-mean)/Σ == exp(r)/Σ: any
 	// constant offset cancels and centering is a mathematical no-op. (An earlier
@@ -153,10 +159,22 @@ func effectiveSourceWeights(cohort Cohort) map[string]float64 {
 	// but the absolute neutral is irrelevant post-normalization — only the RELATIVE
 	// exp(r) across sources matters, and renormalization already gives an
 	// average-performing source ~its default share.)
-	// rewards is the snapshot above (never nil; empty for an untrained cohort →
+	// rewards is the caller's snapshot (possibly empty for an untrained cohort →
 	// mul 1, uniform defaults).
+	//
+	// Every sum below runs over the sources in a fixed order. Map order
+	// changes from call to call, and a float sum taken in another order can
+	// come out different in the last bit — enough that the same rewards gave
+	// two not-quite-equal mixes, and the shadow diff (model_registry.go)
+	// reported movement between a version and itself.
+	srcs := make([]string, 0, len(defaultSourceWeights))
+	for src := range defaultSourceWeights {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
 	totalRaw := 0.0
-	for src, defWeight := range defaultSourceWeights {
+	for _, src := range srcs {
+		defWeight := defaultSourceWeights[src]
 		// expSafe (bandit.go) is overflow-guarded; the realized EMA range is
 		// [-0.4, 1.0] so exp stays in ~[0.67, 2.72] — no extra clamp needed.
 		mul := expSafe(rewards[src])
@@ -188,7 +206,7 @@ func effectiveSourceWeights(cohort Cohort) map[string]float64 {
 	for {
 		avail := 1.0 - float64(len(pinned))*cohortBlendMinWeight
 		unpinnedBaseSum := 0.0
-		for src := range out {
+		for _, src := range srcs {
 			if !pinned[src] {
 				unpinnedBaseSum += base[src]
 			}
@@ -212,7 +230,7 @@ func effectiveSourceWeights(cohort Cohort) map[string]float64 {
 	}
 	avail := 1.0 - float64(len(pinned))*cohortBlendMinWeight
 	unpinnedBaseSum := 0.0
-	for src := range out {
+	for _, src := range srcs {
 		if !pinned[src] {
 			unpinnedBaseSum += base[src]
 		}
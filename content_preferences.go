@@ -0,0 +1,714 @@
+package main
+
+// content_preferences.go — what a user has asked not to be shown.
+//
+// The feed has always inferred avoidance: skips and not-interested taps
+// drift CategoryAffinity down until a category lands in AvoidedCategories,
+// and blocks, unfollows and bounces penalise creators (signals_negative.go).
+// None of that lets someone simply say "no more of this". This file is where
+// they say it, and what every surface checks before showing them anything.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT CAN BE MUTED
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	words      words and phrases, matched whole-word and case-insensitively
+//	           against a challenge's prefix, subject and tags (emotion and
+//	           custom) and a post's caption and tags. "dog" hides "Best dog
+//	           trick" and "#dog"; it does not hide "hotdog".
+//	categories ContentCategories, matched exactly.
+//	creators   soft, unlike a block: the creator isn't told, can still
+//	           follow and message, and their profile can still be opened.
+//	           Only their content goes — from every feed, Following
+//	           included, from search and from pushes — and they are never
+//	           suggested.
+//	sensitive  how much reported-as-sensitive content to let through; see
+//	           below.
+//
+// Words are normalised on the way in — lower case, anything that isn't a
+// letter or digit turned into a single space — and content text the same
+// way when it is matched, so "Dog-Trick!!" and "dog trick" are one phrase.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// SENSITIVE CONTENT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Nothing declares a video sensitive, so the level works off what viewers
+// say: pending reports for sexual content or violence. Once a reviewer
+// decides, the reports close — hidden for everyone, or dismissed and shown
+// again — so this only ever covers the time a report waits in the queue.
+//
+//	more      nothing extra is hidden.
+//	standard  (the default) content with sensitiveStandardReporters or more
+//	          distinct people reporting it is held back.
+//	less      any such report at all holds it back.
+//
+// The counts are a snapshot, reloaded every sensitiveSyncInterval the same
+// way the moderation and privacy snapshots are.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHERE IT IS ENFORCED
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// The For You, explore and arena lists, search and the you-will-love push
+// filter in memory with dropMutedItems / dropMutedChallenges /
+// contentMutedFor, next to the privacy filter. Matching text against words
+// needs the text, which feed candidates don't carry in full (custom tags
+// never, emotion tags rarely), so it is batch-loaded per request and cached
+// per item. The Following feed paginates in SQL, where filtering afterwards
+// would make pages skip items, so it takes the same rules as a WHERE clause
+// (challengeSQLFilter). Suggested accounts leave out muted creators and
+// don't pick a muted category as a reason to suggest anyone. A muted
+// responder doesn't trigger "answered your challenge".
+//
+// A user's preferences are cached for contentPrefsCacheTTL. The replica that
+// saves a change refreshes its copy on the spot; the others catch up within
+// the TTL.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	contentPrefsCacheTTL  = time.Minute
+	contentMuteInfoTTL    = 10 * time.Minute
+	sensitiveSyncInterval = time.Minute
+
+	// maxMutedWords and maxMutedWordLen bound one user's word list.
+	maxMutedWords   = 200
+	maxMutedWordLen = 80
+
+	sensitiveMore     = "more"
+	sensitiveStandard = "standard"
+	sensitiveLess     = "less"
+	// sensitiveStandardReporters is how many distinct people must report
+	// something as sensitive before 'standard' holds it back.
+	sensitiveStandardReporters = 3
+)
+
+// sensitiveReportReasons are the report reasons that make content
+// sensitive rather than abusive.
+var sensitiveReportReasons = []string{"sexual_content", "violence"}
+
+// ContentPreferences is the API shape of a user's preferences.
+type ContentPreferences struct {
+	MutedWords       []string       `json:"mutedWords"`
+	MutedCategories  []string       `json:"mutedCategories"`
+	MutedCreators    []MutedCreator `json:"mutedCreators"`
+	SensitiveContent string         `json:"sensitiveContent"`
+}
+
+// MutedCreator is one entry of a user's muted-creator list.
+type MutedCreator struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	MutedAt  string `json:"mutedAt"`
+}
+
+// contentPrefs is the compiled form every surface checks.
+type contentPrefs struct {
+	words      []string // normalised phrases
+	categories map[string]bool
+	creators   map[string]bool
+	// sensitiveAt is the distinct-reporter count that hides an item; 0 is off.
+	sensitiveAt int
+}
+
+func compileContentPrefs(p ContentPreferences) *contentPrefs {
+	c := &contentPrefs{
+		categories: make(map[string]bool, len(p.MutedCategories)),
+		creators:   make(map[string]bool, len(p.MutedCreators)),
+	}
+	for _, w := range p.MutedWords {
+		if n := normalizeMuteText(w); n != "" {
+			c.words = append(c.words, n)
+		}
+	}
+	for _, cat := range p.MutedCategories {
+		c.categories[strings.ToLower(cat)] = true
+	}
+	for _, m := range p.MutedCreators {
+		c.creators[m.ID] = true
+	}
+	switch p.SensitiveContent {
+	case sensitiveMore:
+		c.sensitiveAt = 0
+	case sensitiveLess:
+		c.sensitiveAt = 1
+	default:
+		c.sensitiveAt = sensitiveStandardReporters
+	}
+	return c
+}
+
+// normalizeMuteText lower-cases s and reduces it to its words, one space
+// apart.
+func normalizeMuteText(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func (p *contentPrefs) mutesCreator(id string) bool   { return id != "" && p.creators[id] }
+func (p *contentPrefs) mutesCategory(cat string) bool { return p.categories[strings.ToLower(cat)] }
+
+// needsText reports whether matching needs an item's category and text.
+func (p *contentPrefs) needsText() bool { return len(p.words) > 0 || len(p.categories) > 0 }
+
+// active reports whether anything at all could be hidden.
+func (p *contentPrefs) active() bool {
+	return p.needsText() || len(p.creators) > 0 || (p.sensitiveAt > 0 && len(currentSensitive()) > 0)
+}
+
+// contentMuteInfo is what matching needs to know about one item beyond its
+// creator. text is normalised and padded with a space at each end.
+type contentMuteInfo struct {
+	category string
+	text     string
+}
+
+func newContentMuteInfo(category string, parts ...string) contentMuteInfo {
+	return contentMuteInfo{category: category, text: " " + normalizeMuteText(strings.Join(parts, " ")) + " "}
+}
+
+// hides reports whether one item is muted for this user.
+func (p *contentPrefs) hides(contentType, contentID, creatorID string, info contentMuteInfo) bool {
+	if p.mutesCreator(creatorID) {
+		return true
+	}
+	if p.sensitiveAt > 0 && currentSensitive()[contentType+":"+contentID] >= p.sensitiveAt {
+		return true
+	}
+	if info.category != "" && p.mutesCategory(info.category) {
+		return true
+	}
+	for _, w := range p.words {
+		if strings.Contains(info.text, " "+w+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// LOADING
+// ════════════════════════════════════════════════════════════════════════════════
+
+var contentPrefsCache = NewSignalCache[*contentPrefs](contentPrefsCacheTTL)
+
+// loadContentPrefs returns the user's compiled preferences. An anonymous
+// viewer, or a read that fails, gets the defaults.
+func loadContentPrefs(userID string) *contentPrefs {
+	if userID == "" {
+		return compileContentPrefs(ContentPreferences{})
+	}
+	if p, ok := contentPrefsCache.Get(userID); ok {
+		return p
+	}
+	prefs, err := readContentPreferences(userID)
+	if err != nil {
+		log.Printf("content prefs: loading %s: %v", userID, err)
+		return compileContentPrefs(ContentPreferences{})
+	}
+	p := compileContentPrefs(prefs)
+	contentPrefsCache.Set(userID, p)
+	return p
+}
+
+// readContentPreferences reads a user's preferences from the database.
+func readContentPreferences(userID string) (ContentPreferences, error) {
+	prefs := ContentPreferences{
+		MutedWords:       []string{},
+		MutedCategories:  []string{},
+		MutedCreators:    []MutedCreator{},
+		SensitiveContent: sensitiveStandard,
+	}
+	if db == nil {
+		return prefs, nil
+	}
+	err := db.QueryRow(`
+		SELECT muted_words, muted_categories, sensitive_content
+		FROM content_preferences WHERE user_id = CAST($1 AS INT)`, userID).
+		Scan(pq.Array(&prefs.MutedWords), pq.Array(&prefs.MutedCategories), &prefs.SensitiveContent)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return prefs, err
+	}
+	rows, err := db.Query(`
+		SELECT m.creator_id::text, u.username, m.created_at
+		FROM muted_creators m JOIN users u ON u.id = m.creator_id
+		WHERE m.user_id = CAST($1 AS INT)
+		ORDER BY m.created_at DESC`, userID)
+	if err != nil {
+		return prefs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m MutedCreator
+		var at time.Time
+		if err := rows.Scan(&m.ID, &m.Username, &at); err != nil {
+			return prefs, err
+		}
+		m.MutedAt = at.UTC().Format(time.RFC3339)
+		prefs.MutedCreators = append(prefs.MutedCreators, m)
+	}
+	return prefs, rows.Err()
+}
+
+// refreshContentPrefs re-reads a user's preferences after a change so this
+// replica enforces it from the next request.
+func refreshContentPrefs(userID string) (ContentPreferences, error) {
+	prefs, err := readContentPreferences(userID)
+	if err != nil {
+		return prefs, err
+	}
+	contentPrefsCache.Set(userID, compileContentPrefs(prefs))
+	return prefs, nil
+}
+
+var contentMuteInfoCache = NewSignalCache[contentMuteInfo](contentMuteInfoTTL)
+
+// contentRef names one item to load mute info for.
+type contentRef struct{ contentType, id string }
+
+// loadContentMuteInfo returns mute info for each ref, keyed "type:id", from
+// the cache or one query per content type. Anything that can't be loaded
+// is simply absent, and is then matched on its creator and reports alone.
+func loadContentMuteInfo(refs []contentRef) map[string]contentMuteInfo {
+	out := make(map[string]contentMuteInfo, len(refs))
+	missing := map[string][]int64{}
+	for _, ref := range refs {
+		key := ref.contentType + ":" + ref.id
+		if info, ok := contentMuteInfoCache.Get(key); ok {
+			out[key] = info
+			continue
+		}
+		if id, err := strconv.ParseInt(ref.id, 10, 64); err == nil {
+			missing[ref.contentType] = append(missing[ref.contentType], id)
+		}
+	}
+	if db == nil || len(missing) == 0 {
+		return out
+	}
+	load := func(contentType, query string, ids []int64) {
+		rows, err := db.Query(query, pq.Array(ids))
+		if err != nil {
+			log.Printf("content prefs: loading %s text: %v", contentType, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, category, a, b, tags, custom string
+			if rows.Scan(&id, &category, &a, &b, &tags, &custom) != nil {
+				continue
+			}
+			key := contentType + ":" + id
+			info := newContentMuteInfo(category, a, b, tags, custom)
+			contentMuteInfoCache.Set(key, info)
+			out[key] = info
+		}
+	}
+	if ids := missing["challenge"]; len(ids) > 0 {
+		load("challenge", `
+			SELECT id::text, COALESCE(category, ''), COALESCE(prefix, ''), COALESCE(subject, ''),
+			       COALESCE(emotion_tags::text, ''), COALESCE(custom_tags::text, '')
+			FROM challenges WHERE id = ANY($1)`, ids)
+	}
+	if ids := missing["post"]; len(ids) > 0 {
+		load("post", `
+			SELECT id::text, COALESCE(category, ''), COALESCE(caption, ''), '',
+			       COALESCE(emotion_tags::text, ''), COALESCE(custom_tags::text, '')
+			FROM posts WHERE id = ANY($1)`, ids)
+	}
+	return out
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// FILTERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// dropMutedItems removes feed items the user has muted. Cards that aren't
+// content (suggested accounts) are kept.
+func dropMutedItems(items []HomeFeedItem, p *contentPrefs) []HomeFeedItem {
+	if !p.active() {
+		return items
+	}
+	var info map[string]contentMuteInfo
+	if p.needsText() {
+		refs := make([]contentRef, 0, len(items))
+		for _, it := range items {
+			if it.Challenge != nil || it.Post != nil {
+				refs = append(refs, contentRef{it.Type, getItemID(it)})
+			}
+		}
+		info = loadContentMuteInfo(refs)
+	}
+	out := items[:0]
+	for _, it := range items {
+		if it.Challenge != nil || it.Post != nil {
+			id := getItemID(it)
+			if p.hides(it.Type, id, getItemCreatorID(it), info[it.Type+":"+id]) {
+				continue
+			}
+		}
+		out = append(out, it)
+	}
+	return out
+}
+
+// dropMutedChallenges is dropMutedItems for a plain challenge list.
+func dropMutedChallenges(chs []Challenge, p *contentPrefs) []Challenge {
+	if !p.active() {
+		return chs
+	}
+	var info map[string]contentMuteInfo
+	if p.needsText() {
+		refs := make([]contentRef, len(chs))
+		for i, ch := range chs {
+			refs[i] = contentRef{"challenge", ch.ID}
+		}
+		info = loadContentMuteInfo(refs)
+	}
+	out := make([]Challenge, 0, len(chs))
+	for _, ch := range chs {
+		if !p.hides("challenge", ch.ID, ch.CreatorID, info["challenge:"+ch.ID]) {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// contentMutedFor reports whether one item is muted for one user — the
+// per-recipient check a push makes.
+func contentMutedFor(userID, contentType, contentID, creatorID string) bool {
+	p := loadContentPrefs(userID)
+	if !p.active() {
+		return false
+	}
+	var info contentMuteInfo
+	if p.needsText() {
+		info = loadContentMuteInfo([]contentRef{{contentType, contentID}})[contentType+":"+contentID]
+	}
+	return p.hides(contentType, contentID, creatorID, info)
+}
+
+// challengeSQLFilter is the same rules as a WHERE fragment over the
+// challenges table aliased alias, for a query that paginates in SQL. Its
+// placeholders start at $next. Empty when nothing could be hidden.
+//
+// Words become Postgres regular expressions: a normalised phrase is only
+// letters, digits and single spaces, so it needs no escaping, and each
+// space matches any run of non-alphanumerics, as normalisation would.
+func (p *contentPrefs) challengeSQLFilter(alias string, next int) (string, []interface{}) {
+	if !p.active() {
+		return "", nil
+	}
+	creators := make([]int64, 0, len(p.creators))
+	for id := range p.creators {
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			creators = append(creators, n)
+		}
+	}
+	categories := make([]string, 0, len(p.categories))
+	for cat := range p.categories {
+		categories = append(categories, cat)
+	}
+	sort.Strings(categories)
+	var sensitive []int64
+	if p.sensitiveAt > 0 {
+		for key, n := range currentSensitive() {
+			if id, ok := strings.CutPrefix(key, "challenge:"); ok && n >= p.sensitiveAt {
+				if v, err := strconv.ParseInt(id, 10, 64); err == nil {
+					sensitive = append(sensitive, v)
+				}
+			}
+		}
+	}
+	patterns := make([]string, len(p.words))
+	for i, w := range p.words {
+		patterns[i] = `(^|[^[:alnum:]])` + strings.ReplaceAll(w, " ", `[^[:alnum:]]+`) + `([^[:alnum:]]|$)`
+	}
+	a := alias
+	clause := fmt.Sprintf(`
+		AND NOT (%[1]s.creator_id = ANY($%[2]d))
+		AND NOT (LOWER(COALESCE(%[1]s.category, '')) = ANY($%[3]d))
+		AND NOT (%[1]s.id = ANY($%[4]d))
+		AND NOT (LOWER(COALESCE(%[1]s.prefix, '') || ' ' || COALESCE(%[1]s.subject, '') || ' ' ||
+		             COALESCE(%[1]s.emotion_tags::text, '') || ' ' || COALESCE(%[1]s.custom_tags::text, '')) ~ ANY($%[5]d))`,
+		a, next, next+1, next+2, next+3)
+	return clause, []interface{}{pq.Array(creators), pq.Array(categories), pq.Array(sensitive), pq.Array(patterns)}
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SENSITIVE SNAPSHOT
+// ════════════════════════════════════════════════════════════════════════════════
+
+// sensitiveStore maps "type:id" to the number of distinct people with a
+// pending sensitive report on it (map[string]int, never mutated once
+// stored).
+var sensitiveStore atomic.Value
+
+func currentSensitive() map[string]int {
+	s, _ := sensitiveStore.Load().(map[string]int)
+	return s
+}
+
+// loadSensitiveContent replaces the snapshot. On error the previous one
+// stays.
+func loadSensitiveContent() {
+	if db == nil {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT target_type, target_id, COUNT(DISTINCT reporter_id)
+		FROM reports
+		WHERE status = 'pending' AND reason = ANY($1) AND target_type IN ('challenge', 'post')
+		GROUP BY target_type, target_id`, pq.Array(sensitiveReportReasons))
+	if err != nil {
+		log.Printf("content prefs: loading sensitive reports (keeping previous snapshot): %v", err)
+		return
+	}
+	defer rows.Close()
+	next := map[string]int{}
+	for rows.Next() {
+		var tt string
+		var id, n int
+		if rows.Scan(&tt, &id, &n) == nil {
+			next[tt+":"+strconv.Itoa(id)] = n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("content prefs: loading sensitive reports (keeping previous snapshot): %v", err)
+		return
+	}
+	sensitiveStore.Store(next)
+}
+
+// startSensitiveContentSync loads the snapshot, then keeps it fresh. Called
+// from main() after InitDatabase.
+func startSensitiveContentSync() {
+	loadSensitiveContent()
+	go func() {
+		t := time.NewTicker(sensitiveSyncInterval)
+		defer t.Stop()
+		for range t.C {
+			loadSensitiveContent()
+		}
+	}()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// GetContentPreferencesHandler — GET /api/v1/users/{id}/content-preferences
+func GetContentPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requirePathUser(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	prefs, err := readContentPreferences(userID)
+	if err != nil {
+		http.Error(w, "could not load preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// UpdateContentPreferencesHandler — PUT /api/v1/users/{id}/content-preferences
+// Body: any of { mutedWords, mutedCategories, sensitiveContent }
+//
+// Each field present replaces what was there; absent fields are left
+// alone. Muted creators have their own routes.
+func UpdateContentPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requirePathUser(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	var payload struct {
+		MutedWords       *[]string `json:"mutedWords"`
+		MutedCategories  *[]string `json:"mutedCategories"`
+		SensitiveContent *string   `json:"sensitiveContent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if !allowAction(userID, "mute") {
+		writeRateLimited(w, "mute")
+		return
+	}
+	prefs, err := readContentPreferences(userID)
+	if err != nil {
+		http.Error(w, "could not load preferences", http.StatusInternalServerError)
+		return
+	}
+	if payload.MutedWords != nil {
+		words, err := cleanMutedWords(*payload.MutedWords)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.MutedWords = words
+	}
+	if payload.MutedCategories != nil {
+		cats, err := cleanMutedCategories(*payload.MutedCategories)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.MutedCategories = cats
+	}
+	if payload.SensitiveContent != nil {
+		switch level := strings.ToLower(strings.TrimSpace(*payload.SensitiveContent)); level {
+		case sensitiveMore, sensitiveStandard, sensitiveLess:
+			prefs.SensitiveContent = level
+		default:
+			http.Error(w, "sensitiveContent must be more, standard or less", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := db.Exec(`
+		INSERT INTO content_preferences (user_id, muted_words, muted_categories, sensitive_content, updated_at)
+		VALUES (CAST($1 AS INT), $2, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			muted_words = EXCLUDED.muted_words,
+			muted_categories = EXCLUDED.muted_categories,
+			sensitive_content = EXCLUDED.sensitive_content,
+			updated_at = NOW()`,
+		userID, pq.Array(prefs.MutedWords), pq.Array(prefs.MutedCategories), prefs.SensitiveContent); err != nil {
+		log.Printf("content prefs: saving %s: %v", userID, err)
+		http.Error(w, "could not save preferences", http.StatusInternalServerError)
+		return
+	}
+	if prefs, err = refreshContentPrefs(userID); err != nil {
+		http.Error(w, "could not load preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// cleanMutedWords normalises, de-duplicates and bounds a word list.
+func cleanMutedWords(in []string) ([]string, error) {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, w := range in {
+		n := normalizeMuteText(w)
+		if n == "" || seen[n] {
+			continue
+		}
+		if len(n) > maxMutedWordLen {
+			return nil, fmt.Errorf("muted words can be at most %d characters", maxMutedWordLen)
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	if len(out) > maxMutedWords {
+		return nil, fmt.Errorf("at most %d muted words", maxMutedWords)
+	}
+	return out, nil
+}
+
+// cleanMutedCategories keeps known categories, lower-cased, once each.
+func cleanMutedCategories(in []string) ([]string, error) {
+	known := make(map[string]bool, len(ContentCategories))
+	for _, c := range ContentCategories {
+		known[c] = true
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, c := range in {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if !known[c] {
+			return nil, fmt.Errorf("unknown category %q", c)
+		}
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// MuteCreatorHandler — POST /api/v1/users/{id}/muted-creators
+// Body: { creatorId }
+func MuteCreatorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requirePathUser(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	var payload struct {
+		CreatorID string `json:"creatorId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	creatorID, err := strconv.Atoi(strings.TrimSpace(payload.CreatorID))
+	if err != nil {
+		http.Error(w, "creatorId required", http.StatusBadRequest)
+		return
+	}
+	if strconv.Itoa(creatorID) == userID {
+		http.Error(w, "cannot mute yourself", http.StatusBadRequest)
+		return
+	}
+	if !allowAction(userID, "mute") {
+		writeRateLimited(w, "mute")
+		return
+	}
+	_, err = db.Exec(`
+		INSERT INTO muted_creators (user_id, creator_id) VALUES (CAST($1 AS INT), $2)
+		ON CONFLICT DO NOTHING`, userID, creatorID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("content prefs: muting %d for %s: %v", creatorID, userID, err)
+		http.Error(w, "could not mute", http.StatusInternalServerError)
+		return
+	}
+	writeMutedCreators(w, userID)
+}
+
+// UnmuteCreatorHandler — DELETE /api/v1/users/{id}/muted-creators/{creatorId}
+func UnmuteCreatorHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, ok := requirePathUser(w, r, vars["id"])
+	if !ok {
+		return
+	}
+	if _, err := db.Exec(`
+		DELETE FROM muted_creators WHERE user_id = CAST($1 AS INT) AND creator_id::text = $2`,
+		userID, vars["creatorId"]); err != nil {
+		log.Printf("content prefs: unmuting %s for %s: %v", vars["creatorId"], userID, err)
+		http.Error(w, "could not unmute", http.StatusInternalServerError)
+		return
+	}
+	writeMutedCreators(w, userID)
+}
+
+// writeMutedCreators answers a mute or unmute with the list as it now is.
+func writeMutedCreators(w http.ResponseWriter, userID string) {
+	prefs, err := refreshContentPrefs(userID)
+	if err != nil {
+		http.Error(w, "could not load preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"mutedCreators": prefs.MutedCreators})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func TestMutedWordsMatchWholeWords(t *testing.T) {
+	if got := normalizeMuteText("  Dog-Trick!! #Spoilers "); got != "dog trick spoilers" {
+		t.Fatalf("normalizeMuteText = %q", got)
+	}
+	p := compileContentPrefs(ContentPreferences{MutedWords: []string{"dog trick", "Spoiler"}, SensitiveContent: sensitiveMore})
+	cases := []struct {
+		text string
+		want bool
+	}{
+		{"Best DOG-trick ever", true},
+		{"my dog knows a trick", false}, // the phrase, not both words
+		{`["spoiler","happy"]`, true},   // tags as stored
+		{"spoilers ahead", false},
+		{"hotdog trick", false},
+	}
+	for _, c := range cases {
+		if got := p.hides("challenge", "1", "9", newContentMuteInfo("", c.text)); got != c.want {
+			t.Errorf("hides(%q) = %v, want %v", c.text, got, c.want)
+		}
+	}
+	if !compileContentPrefs(ContentPreferences{MutedCategories: []string{"comedy"}}).
+		hides("post", "1", "9", newContentMuteInfo("Comedy")) {
+		t.Error("muted category not hidden")
+	}
+}
+
+func TestDropMutedItemsCreatorsAndSensitive(t *testing.T) {
+	prev := currentSensitive()
+	defer sensitiveStore.Store(prev)
+	sensitiveStore.Store(map[string]int{"challenge:2": 1, "challenge:3": 4})
+
+	feed := func() []HomeFeedItem {
+		var items []HomeFeedItem
+		for _, c := range []Challenge{{ID: "1", CreatorID: "40"}, {ID: "2", CreatorID: "41"}, {ID: "3", CreatorID: "42"}, {ID: "4", CreatorID: "43"}} {
+			c := c
+			items = append(items, HomeFeedItem{Type: "challenge", Challenge: &c})
+		}
+		return append(items, HomeFeedItem{Type: "suggested_accounts"})
+	}
+	ids := func(items []HomeFeedItem) string {
+		var out []string
+		for _, it := range items {
+			out = append(out, it.Type+":"+getItemID(it))
+		}
+		return strings.Join(out, ",")
+	}
+
+	muted := []MutedCreator{{ID: "40"}}
+	for _, c := range []struct{ level, want string }{
+		{sensitiveMore, "challenge:2,challenge:3,challenge:4,suggested_accounts:"},
+		{sensitiveStandard, "challenge:2,challenge:4,suggested_accounts:"},
+		{sensitiveLess, "challenge:4,suggested_accounts:"},
+	} {
+		p := compileContentPrefs(ContentPreferences{MutedCreators: muted, SensitiveContent: c.level})
+		if got := ids(dropMutedItems(feed(), p)); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.level, got, c.want)
+		}
+	}
+}
+
+func TestChallengeSQLFilter(t *testing.T) {
+	prev := currentSensitive()
+	defer sensitiveStore.Store(prev)
+	sensitiveStore.Store(map[string]int{})
+
+	if clause, args := compileContentPrefs(ContentPreferences{}).challengeSQLFilter("c", 3); clause != "" || args != nil {
+		t.Fatalf("nothing muted: clause %q, args %v", clause, args)
+	}
+	p := compileContentPrefs(ContentPreferences{MutedWords: []string{"dog trick"}, MutedCreators: []MutedCreator{{ID: "40"}}})
+	clause, args := p.challengeSQLFilter("c", 3)
+	if !strings.Contains(clause, "c.creator_id = ANY($3)") || !strings.Contains(clause, "~ ANY($6)") || len(args) != 4 {
+		t.Fatalf("clause %q with %d args", clause, len(args))
+	}
+	re := regexp.MustCompile(strings.ReplaceAll(`(^|[^[:alnum:]])dog[^[:alnum:]]+trick([^[:alnum:]]|$)`, "[:alnum:]", `\p{L}\p{N}`))
+	if p.words[0] != "dog trick" || !re.MatchString("best dog-trick") || re.MatchString("hotdog trick") {
+		t.Errorf("pattern for %q doesn't match as the in-memory filter does", p.words[0])
+	}
+}
+
+func TestUpdateContentPreferencesHandler(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	// Other tests' user 7 has muted nothing.
+	defer contentPrefsCache.Set("7", compileContentPrefs(ContentPreferences{}))
+
+	expectRead := func(words string) {
+		q := mock.ExpectQuery("FROM content_preferences").WithArgs("7")
+		if words == "" {
+			q.WillReturnError(sql.ErrNoRows)
+		} else {
+			q.WillReturnRows(sqlmock.NewRows([]string{"muted_words", "muted_categories", "sensitive_content"}).
+				AddRow(words, "{comedy}", "less"))
+		}
+		mock.ExpectQuery("FROM muted_creators").WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"creator_id", "username", "created_at"}))
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/users/7/content-preferences", strings.NewReader(body))
+		req = mux.SetURLVars(withAuth(req, "7", "sam"), map[string]string{"id": "7"})
+		rec := httptest.NewRecorder()
+		UpdateContentPreferencesHandler(rec, req)
+		return rec
+	}
+
+	expectRead("")
+	if rec := put(`{"mutedCategories":["knitting"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown category: status = %d", rec.Code)
+	}
+
+	expectRead("")
+	mock.ExpectExec("INSERT INTO content_preferences").
+		WithArgs("7", sqlmock.AnyArg(), sqlmock.AnyArg(), sensitiveLess).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRead("{spoiler}")
+	rec := put(`{"mutedWords":["Spoiler!","spoiler"],"mutedCategories":["Comedy"],"sensitiveContent":"less"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var got ContentPreferences
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.MutedWords) != 1 || got.MutedWords[0] != "spoiler" || got.SensitiveContent != sensitiveLess {
+		t.Errorf("body = %+v", got)
+	}
+	if p, ok := contentPrefsCache.Get("7"); !ok || p.sensitiveAt != 1 || !p.mutesCategory("comedy") {
+		t.Error("saving didn't refresh this replica's copy")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,647 @@
+package main
+
+// data_export.go — "download your data".
+//
+// POST /api/v1/users/{id}/export asks for an archive of everything we hold
+// about the caller; GET /api/v1/users/{id}/export reports on it and, once it
+// is built, hands out a download link. Data protection law (GDPR article 15
+// and 20, and the app-store rules that lean on it) gives every user the right
+// to a copy in a machine-readable form. Before this the only way to honour a
+// request was somebody running SQL by hand.
+//
+// WHY IT IS ASYNCHRONOUS
+//
+// An active account's watch history alone is tens of thousands of rows, and
+// its DMs can be more. Building that inside a request would hold a DB
+// connection and an HTTP worker for as long as it takes and fail the moment
+// a proxy times out. So the request writes a job row (data_exports, see
+// migrations/019_data_exports.sql) and returns 202; a worker builds the
+// archive, uploads it to R2 and notifies the user.
+//
+// The job is a table rather than a goroutine for the same reason the media
+// deletion queue is (media_delete.go): this service restarts often, and a
+// request lost to a restart is a user who waits forever. A worker that dies
+// mid-build leaves its row 'running'; after dataExportStaleAfter another
+// worker picks it up again.
+//
+// WHAT IS IN IT
+//
+// A zip with one JSON file per area — profile, content, comments, votes,
+// messages, social graph, activity, account security, settings, and the
+// recommendation profile the feed has learned (UserProfile, feed_engine.go).
+// Videos are referenced by URL, not copied in: an archive that contained
+// every clip a creator ever posted would be gigabytes, and the files are
+// already theirs to download. README.txt in the archive says so.
+//
+// Some things are deliberately left out because they are someone else's
+// data or a secret rather than the user's: who has blocked *them*, password
+// and TOTP material, refresh-token hashes, passkey public keys.
+//
+// THE DOWNLOAD
+//
+// The bucket may be public, so the archive's key has a random component
+// nobody can guess, and the link we hand out is a presigned GET that dies in
+// dataExportLinkTTL. Asking again mints a fresh link; the file itself is kept
+// for dataExportRetention and then queued for deletion like any other media.
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How often the worker looks for work. Nobody expects an export in
+	// seconds; "we'll notify you" covers a minute of latency easily.
+	dataExportInterval = 30 * time.Second
+	// Jobs built per tick, so a burst of requests cannot pin the process.
+	dataExportBatch = 3
+	// A 'running' job older than this belonged to a worker that died.
+	dataExportStaleAfter = 15 * time.Minute
+	// Builds attempted before a job is marked failed.
+	dataExportMaxAttempts = 3
+	// How long a finished archive is kept in the bucket.
+	dataExportRetention = 7 * 24 * time.Hour
+	// A ready archive younger than this is handed back instead of building a
+	// new one. Exports are expensive and the data rarely moves much in a day.
+	dataExportCooldown = 24 * time.Hour
+	// Lifetime of one download link. Short, because it is a bearer link to
+	// everything the user has ever done here; the app asks for a new one
+	// whenever the user taps download.
+	dataExportLinkTTL = 15 * time.Minute
+)
+
+var errDataExportUnavailable = errors.New("data export is not available right now")
+
+// dataExport is one data_exports row.
+type dataExport struct {
+	ID          int
+	UserID      string
+	Status      string
+	ObjectKey   string
+	SizeBytes   int64
+	Attempts    int
+	RequestedAt time.Time
+	ReadyAt     sql.NullTime
+	ExpiresAt   sql.NullTime
+}
+
+const dataExportColumns = `id, user_id::text, status, object_key, size_bytes, attempts,
+	requested_at, ready_at, expires_at`
+
+func scanDataExport(s interface{ Scan(...any) error }) (dataExport, error) {
+	var e dataExport
+	err := s.Scan(&e.ID, &e.UserID, &e.Status, &e.ObjectKey, &e.SizeBytes, &e.Attempts,
+		&e.RequestedAt, &e.ReadyAt, &e.ExpiresAt)
+	return e, err
+}
+
+// dataExportPrefix is the folder every archive of one user lives under.
+// Account deletion queues the whole folder.
+func dataExportPrefix(userID string) string {
+	return "exports/" + userID + "/"
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// REQUESTING
+// ─────────────────────────────────────────────────────────────────────────────
+
+// requestDataExport returns the export the caller should be looking at: the
+// one already in flight, a ready one from within dataExportCooldown, or a
+// newly queued job. created reports which.
+func requestDataExport(userID string) (e dataExport, created bool, err error) {
+	if e, err = reusableDataExport(userID); err != sql.ErrNoRows {
+		return e, false, err
+	}
+	suffix, err := randomHex(16)
+	if err != nil {
+		return dataExport{}, false, err
+	}
+	key := dataExportPrefix(userID) + suffix + "/data.zip"
+	e, err = scanDataExport(db.QueryRow(`
+		INSERT INTO data_exports (user_id, object_key) VALUES ($1, $2)
+		ON CONFLICT (user_id) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING `+dataExportColumns, userID, key))
+	if err == sql.ErrNoRows {
+		// Lost a race with another request for the same user; theirs is the
+		// one in flight now.
+		e, err = reusableDataExport(userID)
+		return e, false, err
+	}
+	return e, err == nil, err
+}
+
+func reusableDataExport(userID string) (dataExport, error) {
+	return scanDataExport(db.QueryRow(`
+		SELECT `+dataExportColumns+` FROM data_exports
+		WHERE user_id = CAST($1 AS INT)
+		  AND (status IN ('pending', 'running')
+		       OR (status = 'ready' AND requested_at > NOW() - ($2)::interval
+		           AND expires_at > NOW()))
+		ORDER BY id DESC LIMIT 1`, userID, fmt.Sprintf("%d seconds", int(dataExportCooldown.Seconds()))))
+}
+
+// latestDataExport is the user's most recent export in any state.
+func latestDataExport(userID string) (dataExport, error) {
+	return scanDataExport(db.QueryRow(`
+		SELECT `+dataExportColumns+` FROM data_exports
+		WHERE user_id = CAST($1 AS INT) ORDER BY id DESC LIMIT 1`, userID))
+}
+
+// dataExportView is what the API shows for an export. A ready one carries a
+// freshly signed link.
+func dataExportView(e dataExport, cfg *R2Config) map[string]any {
+	v := map[string]any{
+		"id":          e.ID,
+		"status":      e.Status,
+		"requestedAt": e.RequestedAt,
+	}
+	if e.Status != "ready" {
+		return v
+	}
+	v["sizeBytes"] = e.SizeBytes
+	v["readyAt"] = e.ReadyAt.Time
+	v["expiresAt"] = e.ExpiresAt.Time
+	if e.ExpiresAt.Valid && time.Now().After(e.ExpiresAt.Time) {
+		// Ready in the table but past its retention: the expiry sweep has
+		// not reached it yet. The file may already be going, so no link.
+		v["status"] = "expired"
+		return v
+	}
+	name := fmt.Sprintf("devf-data-%s.zip", e.RequestedAt.UTC().Format("2006-01-02"))
+	if link, err := cfg.PresignGetURL(e.ObjectKey, name, dataExportLinkTTL); err == nil {
+		v["downloadUrl"] = link
+		v["downloadUrlExpiresAt"] = time.Now().Add(dataExportLinkTTL)
+	}
+	return v
+}
+
+// RequestDataExportHandler — POST /api/v1/users/{id}/export
+// 202 with a queued (or already running) job, or 200 with a recent archive
+// that is still downloadable.
+func RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	userID, ok := requirePathUser(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	cfg, err := loadR2Config()
+	if err != nil {
+		// Without storage the job would sit in the queue forever. Better to
+		// say so now than to promise a notification that never comes.
+		http.Error(w, errDataExportUnavailable.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	e, created, err := requestDataExport(userID)
+	if err != nil {
+		log.Printf("data export: request for user %s failed: %v", userID, err)
+		http.Error(w, "could not request export", http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusAccepted
+	if e.Status == "ready" {
+		status = http.StatusOK
+	}
+	if created {
+		log.Printf("data export: queued export %d for user %s", e.ID, userID)
+	}
+	writeJSON(w, status, dataExportView(e, cfg))
+}
+
+// GetDataExportHandler — GET /api/v1/users/{id}/export
+// The caller's latest export. Polling this is how the app learns it is
+// ready when the notification was missed, and how it gets a new link once
+// the last one has lapsed.
+func GetDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	userID, ok := requirePathUser(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	cfg, err := loadR2Config()
+	if err != nil {
+		http.Error(w, errDataExportUnavailable.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	e, err := latestDataExport(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "no export requested", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, dataExportView(e, cfg))
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// WORKER
+// ─────────────────────────────────────────────────────────────────────────────
+
+// startDataExporter builds queued exports and expires old ones, forever.
+// Safe on several instances: jobs are claimed with SKIP LOCKED.
+func startDataExporter() {
+	go func() {
+		t := time.NewTicker(dataExportInterval)
+		defer t.Stop()
+		for range t.C {
+			if err := runDataExports(context.Background()); err != nil {
+				log.Printf("data export: %v", err)
+			}
+		}
+	}()
+}
+
+func runDataExports(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	cfg, err := loadR2Config()
+	if err != nil {
+		// Storage not configured; the request handler refuses new jobs, and
+		// any old ones wait for credentials rather than failing.
+		return nil
+	}
+	sweepDataExports()
+	for i := 0; i < dataExportBatch; i++ {
+		e, err := claimDataExport()
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("claiming a job: %w", err)
+		}
+		processDataExport(ctx, cfg, e)
+	}
+	return nil
+}
+
+// claimDataExport takes the oldest pending job, or a running one whose
+// worker has gone quiet.
+func claimDataExport() (dataExport, error) {
+	return scanDataExport(db.QueryRow(`
+		UPDATE data_exports SET status = 'running', started_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE (status = 'pending'
+			       OR (status = 'running' AND started_at < NOW() - ($1)::interval))
+			  AND attempts < $2
+			ORDER BY id LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+dataExportColumns,
+		fmt.Sprintf("%d seconds", int(dataExportStaleAfter.Seconds())), dataExportMaxAttempts))
+}
+
+func processDataExport(ctx context.Context, cfg *R2Config, e dataExport) {
+	archive, err := buildDataExport(e.UserID)
+	if err == nil {
+		err = cfg.PutObject(ctx, e.ObjectKey, "application/zip", archive)
+	}
+	if err != nil {
+		next := "pending"
+		if e.Attempts >= dataExportMaxAttempts {
+			next = "failed"
+		}
+		log.Printf("data export %d (user %s) attempt %d failed: %v", e.ID, e.UserID, e.Attempts, err)
+		if _, uerr := db.Exec(
+			`UPDATE data_exports SET status = $2, last_error = $3 WHERE id = $1`,
+			e.ID, next, truncateText(err.Error(), 500)); uerr != nil {
+			log.Printf("data export %d: recording the failure: %v", e.ID, uerr)
+		}
+		return
+	}
+
+	if _, err := db.Exec(`
+		UPDATE data_exports
+		SET status = 'ready', size_bytes = $2, last_error = '',
+		    ready_at = NOW(), expires_at = NOW() + ($3)::interval
+		WHERE id = $1`, e.ID, len(archive), fmt.Sprintf("%d seconds", int(dataExportRetention.Seconds()))); err != nil {
+		// The file is up but the row does not say so. The stale-job rule
+		// will build it again; the orphaned file goes with the user's
+		// export folder eventually.
+		log.Printf("data export %d: marking ready: %v", e.ID, err)
+		return
+	}
+	log.Printf("data export %d (user %s) ready: %d bytes", e.ID, e.UserID, len(archive))
+
+	// Goes through the normal outbox, so quiet hours delay it like any
+	// other push. GET /users/{id}/export answers either way.
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      e.UserID,
+		TriggerKind: TriggerDataExport,
+		DedupeKey:   fmt.Sprintf("data_export:%d", e.ID),
+		Title:       "Your data is ready",
+		Body:        fmt.Sprintf("Your download is available for %d days.", int(dataExportRetention.Hours()/24)),
+		Deeplink:    "devf://account/export",
+	}); err != nil {
+		log.Printf("data export %d: notify: %v", e.ID, err)
+	}
+}
+
+// sweepDataExports retires archives past their retention and gives up on
+// jobs whose worker died once too often. The latter matters beyond tidiness:
+// a job stuck in 'running' holds the user's one in-flight slot, and they
+// could never ask again.
+func sweepDataExports() {
+	rows, err := db.Query(`
+		UPDATE data_exports SET status = 'expired'
+		WHERE status = 'ready' AND expires_at < NOW()
+		RETURNING object_key`)
+	if err == nil {
+		var prefixes []string
+		for rows.Next() {
+			var key string
+			if rows.Scan(&key) == nil {
+				prefixes = append(prefixes, path.Dir(key)+"/")
+			}
+		}
+		rows.Close()
+		enqueueMediaDeletions(prefixes)
+	}
+	if _, err := db.Exec(`
+		UPDATE data_exports SET status = 'failed', last_error = 'worker did not finish'
+		WHERE status = 'running' AND attempts >= $1
+		  AND started_at < NOW() - ($2)::interval`,
+		dataExportMaxAttempts, fmt.Sprintf("%d seconds", int(dataExportStaleAfter.Seconds()))); err != nil {
+		log.Printf("data export: sweeping stuck jobs: %v", err)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// THE ARCHIVE
+// ─────────────────────────────────────────────────────────────────────────────
+
+// dataExportPart is one query's worth of the archive: its rows land under
+// key in file. one marks a query that returns a single record, written as
+// an object (or null) instead of a list. Every query takes the user id as
+// text in $1 and compares it as CAST($1 AS INT), so the integer columns'
+// indexes serve the lookup.
+type dataExportPart struct {
+	file, key string
+	one       bool
+	query     string
+}
+
+// dataExportParts lists every table we export from. A new table holding
+// per-user data belongs here as well as in account_delete.go.
+var dataExportParts = []dataExportPart{
+	{file: "profile.json", key: "user", one: true, query: `
+		SELECT id, username, full_name, bio, visibility, settings, wins, losses, league,
+		       created_at, last_seen
+		FROM users WHERE id = CAST($1 AS INT)`},
+	{file: "profile.json", key: "email", one: true, query: `
+		SELECT email, verified_at, created_at FROM user_emails WHERE user_id = CAST($1 AS INT)`},
+
+	{file: "content.json", key: "challenges", query: `
+		SELECT id, prefix, subject, category, visibility, status, video_url, thumbnail_url,
+		       hls_manifest_url, video_variants, views, created_at
+		FROM challenges WHERE creator_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "content.json", key: "responses", query: `
+		SELECT id, challenge_id, caption, video_url, thumbnail_url, hls_manifest_url,
+		       video_variants, views, created_at
+		FROM challenge_responses WHERE responder_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "content.json", key: "posts", query: `
+		SELECT id, type, caption, content_url, thumbnail_url, views, created_at
+		FROM posts WHERE author_id = CAST($1 AS INT) ORDER BY id`},
+
+	{file: "comments.json", key: "challengeComments", query: `
+		SELECT id, challenge_id, text, created_at
+		FROM challenge_comments WHERE author_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "comments.json", key: "postComments", query: `
+		SELECT id, post_id, text, created_at
+		FROM comments WHERE author_id = CAST($1 AS INT) ORDER BY id`},
+
+	{file: "votes.json", key: "battleVotes", query: `
+		SELECT challenge_id, response_id, created_at
+		FROM challenge_votes WHERE voter_id = CAST($1 AS INT) ORDER BY created_at`},
+	{file: "votes.json", key: "challengeLikes", query: `
+		SELECT challenge_id, created_at FROM challenge_likes WHERE user_id = CAST($1 AS INT) ORDER BY created_at`},
+	{file: "votes.json", key: "challengeDislikes", query: `
+		SELECT challenge_id, created_at FROM challenge_dislikes WHERE user_id = CAST($1 AS INT) ORDER BY created_at`},
+	{file: "votes.json", key: "responseLikes", query: `
+		SELECT response_id, created_at FROM challenge_response_likes WHERE user_id = CAST($1 AS INT) ORDER BY created_at`},
+	{file: "votes.json", key: "postLikes", query: `
+		SELECT post_id, created_at FROM post_likes WHERE user_id = CAST($1 AS INT) ORDER BY created_at`},
+
+	// Both sides of every DM conversation: what was said to the user is
+	// part of their correspondence too. Group chats are their own messages
+	// only — the rest of a group's history belongs to the group.
+	{file: "messages.json", key: "direct", query: `
+		SELECT id, sender_id, receiver_id, kind, message, media_url, media_thumb_url,
+		       media_duration_ms, challenge_id, reply_to_id, is_edited, is_deleted,
+		       created_at, read_at
+		FROM chat_messages WHERE sender_id = CAST($1 AS INT) OR receiver_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "messages.json", key: "groups", query: `
+		SELECT m.group_id, g.name, m.role, m.joined_at
+		FROM chat_group_members m JOIN chat_groups g ON g.id = m.group_id
+		WHERE m.user_id = CAST($1 AS INT) ORDER BY m.joined_at`},
+	{file: "messages.json", key: "groupMessages", query: `
+		SELECT id, group_id, kind, message, challenge_id, reply_to_id, created_at
+		FROM group_messages WHERE sender_id = CAST($1 AS INT) ORDER BY id`},
+
+	{file: "social.json", key: "following", query: `
+		SELECT f.following_id AS user_id, u.username, f.created_at
+		FROM follows f JOIN users u ON u.id = f.following_id
+		WHERE f.follower_id = CAST($1 AS INT) ORDER BY f.created_at`},
+	{file: "social.json", key: "followers", query: `
+		SELECT f.follower_id AS user_id, u.username, f.created_at
+		FROM follows f JOIN users u ON u.id = f.follower_id
+		WHERE f.following_id = CAST($1 AS INT) ORDER BY f.created_at`},
+	{file: "social.json", key: "followRequestsSent", query: `
+		SELECT r.target_id AS user_id, u.username, r.created_at
+		FROM follow_requests r JOIN users u ON u.id = r.target_id
+		WHERE r.requester_id = CAST($1 AS INT) ORDER BY r.created_at`},
+	{file: "social.json", key: "followRequestsReceived", query: `
+		SELECT r.requester_id AS user_id, u.username, r.created_at
+		FROM follow_requests r JOIN users u ON u.id = r.requester_id
+		WHERE r.target_id = CAST($1 AS INT) ORDER BY r.created_at`},
+	{file: "social.json", key: "blocked", query: `
+		SELECT b.blocked_id AS user_id, u.username, b.created_at
+		FROM user_blocks b JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = CAST($1 AS INT) ORDER BY b.created_at`},
+
+	// What the user asked not to be shown (content_preferences.go).
+	{file: "settings.json", key: "contentPreferences", one: true, query: `
+		SELECT to_json(muted_words) AS muted_words, to_json(muted_categories) AS muted_categories,
+		       sensitive_content, updated_at
+		FROM content_preferences WHERE user_id = CAST($1 AS INT)`},
+	{file: "settings.json", key: "mutedCreators", query: `
+		SELECT m.creator_id AS user_id, u.username, m.created_at
+		FROM muted_creators m JOIN users u ON u.id = m.creator_id
+		WHERE m.user_id = CAST($1 AS INT) ORDER BY m.created_at`},
+
+	{file: "activity.json", key: "saved", query: `
+		SELECT challenge_id, created_at FROM saved_challenges WHERE user_id = CAST($1 AS INT) ORDER BY created_at`},
+	{file: "activity.json", key: "feedEvents", query: `
+		SELECT content_id, content_type, event_type, watch_duration_ms, total_duration_ms,
+		       completion_rate, session_id, metadata, created_at
+		FROM feed_events WHERE user_id = $1 ORDER BY id`},
+	{file: "activity.json", key: "watchHistory", query: `
+		SELECT content_id, content_type, watch_time, completed, created_at
+		FROM watch_events WHERE user_id = CAST($1 AS INT) ORDER BY id`},
+
+	// The sampled feed pages kept for offline ranking evaluation
+	// (ranking_log.go): what was shown, where, and what you did with it.
+	{file: "recommendations.json", key: "rankedImpressions", query: `
+		SELECT request_id, position, content_type, content_id, score, breakdown,
+		       completed, liked, skipped, served_at
+		FROM ranking_impressions WHERE user_id = CAST($1 AS INT) ORDER BY id`},
+
+	{file: "account.json", key: "sessions", query: `
+		SELECT user_agent, ip, created_at, last_active_at, expires_at, revoked_at, revoked_reason
+		FROM user_sessions WHERE user_id = CAST($1 AS INT) ORDER BY created_at`},
+	{file: "account.json", key: "passkeys", query: `
+		SELECT name, backup_eligible, created_at, last_used_at
+		FROM user_passkeys WHERE user_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "account.json", key: "linkedSignIns", query: `
+		SELECT provider, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "account.json", key: "strikes", query: `
+		SELECT reason, created_at, expires_at, lifted_at
+		FROM account_strikes WHERE user_id = CAST($1 AS INT) ORDER BY id`},
+	{file: "account.json", key: "appeals", query: `
+		SELECT action_id, message, status, decision_note, decided_at, created_at
+		FROM moderation_appeals WHERE user_id = CAST($1 AS INT) ORDER BY id`},
+}
+
+const dataExportReadme = `This archive contains the personal data devf holds about your account.
+
+Each .json file covers one area:
+
+  profile.json          your profile and email address
+  content.json          challenges, responses and posts you created
+  comments.json         comments you wrote
+  votes.json            battle votes, likes and dislikes
+  messages.json         direct messages (sent and received), your groups,
+                        and messages you sent in them
+  social.json           who you follow, who follows you, follow requests
+                        pending either way, who you blocked
+  activity.json         saved challenges and your viewing history
+  account.json          sign-in sessions, passkeys, linked Google/Apple
+                        sign-ins, strikes and appeals
+  settings.json         notification settings, and the words, categories
+                        and creators you muted
+  recommendations.json  what the feed has learned about your tastes, and
+                        a sample of feed pages as they were ranked for you
+
+Videos and images are listed by URL rather than included, to keep the
+archive a reasonable size. Times are UTC.
+`
+
+// buildDataExport assembles the zip for one user in memory.
+func buildDataExport(userID string) ([]byte, error) {
+	files := map[string]map[string]any{}
+	var order []string
+	add := func(file, key string, v any) {
+		if files[file] == nil {
+			files[file] = map[string]any{}
+			order = append(order, file)
+		}
+		files[file][key] = v
+	}
+
+	for _, p := range dataExportParts {
+		rows, err := exportRows(p.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", p.file, p.key, err)
+		}
+		if !p.one {
+			add(p.file, p.key, rows)
+		} else if len(rows) > 0 {
+			add(p.file, p.key, rows[0])
+		} else {
+			add(p.file, p.key, nil)
+		}
+	}
+	add("settings.json", "notifications", loadNotificationPrefs(userID))
+	profile, err := loadUserProfile(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("recommendation profile: %w", err)
+	}
+	add("recommendations.json", "learnedProfile", profile)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, body []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(body)
+		return err
+	}
+	if err := write("README.txt", []byte(dataExportReadme)); err != nil {
+		return nil, err
+	}
+	for _, name := range order {
+		body, err := json.MarshalIndent(files[name], "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := write(name, body); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportRows runs query and returns every row as a column→value map. JSON
+// columns are embedded as JSON rather than as a string of it.
+func exportRows(query string, args ...any) ([]map[string]any, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	out := []map[string]any{}
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		rec := make(map[string]any, len(cols))
+		for i, c := range cols {
+			v := vals[i]
+			if b, ok := v.([]byte); ok {
+				t := strings.ToUpper(c.DatabaseTypeName())
+				if (t == "JSON" || t == "JSONB") && json.Valid(b) {
+					v = json.RawMessage(b)
+				} else {
+					v = string(b)
+				}
+			}
+			rec[c.Name()] = v
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBuildDataExportArchive(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	for _, p := range dataExportParts {
+		rows := sqlmock.NewRows([]string{"id"})
+		switch p.key {
+		case "user":
+			rows = sqlmock.NewRows([]string{"id", "username"}).AddRow(5, "alice")
+		case "direct":
+			rows = sqlmock.NewRows([]string{"sender_id", "receiver_id", "message"}).
+				AddRow(9, 5, []byte("gg"))
+		case "feedEvents":
+			rows = sqlmock.NewRowsWithColumnDefinition(
+				sqlmock.NewColumn("event_type").OfType("VARCHAR", ""),
+				sqlmock.NewColumn("metadata").OfType("JSONB", nil),
+			).AddRow("view", []byte(`{"src":"feed"}`))
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(p.query)).WithArgs("5").WillReturnRows(rows)
+	}
+	mock.ExpectQuery("FROM notification_prefs").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM user_profiles").WillReturnError(sql.ErrNoRows)
+
+	archive, err := buildDataExport("5")
+	if err != nil {
+		t.Fatalf("buildDataExport: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]map[string]json.RawMessage{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == "README.txt" {
+			continue
+		}
+		var compact bytes.Buffer
+		var m map[string]json.RawMessage
+		if err := json.Compact(&compact, body); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if err := json.Unmarshal(compact.Bytes(), &m); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		files[f.Name] = m
+	}
+	if zr.File[0].Name != "README.txt" {
+		t.Errorf("first entry = %s, want README.txt", zr.File[0].Name)
+	}
+	for _, want := range []string{"profile.json", "content.json", "messages.json", "activity.json",
+		"account.json", "settings.json", "recommendations.json"} {
+		if files[want] == nil {
+			t.Errorf("archive has no %s", want)
+		}
+	}
+	if got := string(files["profile.json"]["user"]); !strings.Contains(got, `"username":"alice"`) {
+		t.Errorf("profile user = %s", got)
+	}
+	if got := string(files["profile.json"]["email"]); got != "null" {
+		t.Errorf("missing email = %s, want null", got)
+	}
+	if got := string(files["messages.json"]["direct"]); !strings.Contains(got, `"message":"gg"`) {
+		t.Errorf("direct messages = %s", got)
+	}
+	// JSON columns are embedded, not quoted.
+	if got := string(files["activity.json"]["feedEvents"]); !strings.Contains(got, `"metadata":{"src":"feed"}`) {
+		t.Errorf("feed events = %s", got)
+	}
+	if got := string(files["content.json"]["posts"]); got != "[]" {
+		t.Errorf("no posts = %s, want []", got)
+	}
+}
+
+var dataExportTestColumns = []string{"id", "user_id", "status", "object_key", "size_bytes", "attempts",
+	"requested_at", "ready_at", "expires_at"}
+
+func TestRequestDataExportReusesInFlight(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("status IN ('pending', 'running')")).WithArgs("5", "86400 seconds").
+		WillReturnRows(sqlmock.NewRows(dataExportTestColumns).
+			AddRow(3, "5", "running", "exports/5/x/data.zip", 0, 1, time.Now(), nil, nil))
+
+	e, created, err := requestDataExport("5")
+	if err != nil || created || e.ID != 3 {
+		t.Fatalf("requestDataExport = %+v, %v, %v", e, created, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Two requests at once: the partial unique index lets one insert, and the
+// other gets the winner's job back.
+func TestRequestDataExportLostRace(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM data_exports")).WillReturnRows(sqlmock.NewRows(dataExportTestColumns))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO data_exports")).
+		WithArgs("5", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(dataExportTestColumns))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM data_exports")).
+		WillReturnRows(sqlmock.NewRows(dataExportTestColumns).
+			AddRow(4, "5", "pending", "exports/5/y/data.zip", 0, 0, time.Now(), nil, nil))
+
+	e, created, err := requestDataExport("5")
+	if err != nil || created || e.ID != 4 {
+		t.Fatalf("requestDataExport = %+v, %v, %v", e, created, err)
+	}
+}
+
+func TestDataExportViewSignsOnlyLiveArchives(t *testing.T) {
+	cfg := &R2Config{AccountID: "acct", Bucket: "media", AccessKeyID: "AK", SecretAccessKey: "SK"}
+	ready := dataExport{
+		ID: 1, Status: "ready", ObjectKey: "exports/5/abc/data.zip",
+		RequestedAt: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		ReadyAt:     sql.NullTime{Time: time.Now(), Valid: true},
+		ExpiresAt:   sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	}
+	v := dataExportView(ready, cfg)
+	link, _ := v["downloadUrl"].(string)
+	if !strings.Contains(link, "/media/exports/5/abc/data.zip?") ||
+		!strings.Contains(link, "X-Amz-Signature=") ||
+		!strings.Contains(link, "devf-data-2026-03-01.zip") {
+		t.Errorf("downloadUrl = %q", link)
+	}
+
+	ready.ExpiresAt.Time = time.Now().Add(-time.Minute)
+	v = dataExportView(ready, cfg)
+	if v["status"] != "expired" || v["downloadUrl"] != nil {
+		t.Errorf("lapsed archive view = %v", v)
+	}
+	if v := dataExportView(dataExport{Status: "pending"}, cfg); v["downloadUrl"] != nil {
+		t.Errorf("pending view = %v", v)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -1670,7 +1671,7 @@ func CreateChallenge(payload CreateChallengePayload) (Challenge, error) {
 		EmotionTags:     payload.EmotionTags,
 		EnergyLevel:     energyLevel,
 		CreatedAt:       createdAt.UTC().Format(time.RFC3339),
-		ExpiresAt:       createdAt.Add(24 * time.Hour).UTC().Format(time.RFC3339),
+		ExpiresAt:       createdAt.Add(challengeLifetime).UTC().Format(time.RFC3339),
 	}, nil
 }
 
@@ -1735,7 +1736,7 @@ func queryChallenges(query string, args ...interface{}) []Challenge {
 				EmotionTags:     emotions,
 				EnergyLevel:     energyStr,
 				CreatedAt:       createdAt.UTC().Format(time.RFC3339),
-				ExpiresAt:       createdAt.Add(24 * time.Hour).UTC().Format(time.RFC3339),
+				ExpiresAt:       createdAt.Add(challengeLifetime).UTC().Format(time.RFC3339),
 			})
 		}
 	}
@@ -1753,6 +1754,8 @@ func GetArenaChallenges() []Challenge {
 // GetFriendsChallenges returns challenges visible to a specific user (friends-only).
 // This includes: challenges by people the user follows with visibility=friends,
 // where the user is either in the visible_to list OR the list is empty (all friends).
+// follows holds only approved followers of a private account (follow_requests.go),
+// so a pending request sees nothing here until it is approved.
 func GetFriendsChallenges(userID string) []Challenge {
 	uid, err := strconv.Atoi(userID)
 	if err != nil {
@@ -2098,35 +2101,142 @@ func RecordWatchEvent(payload WatchEventPayload) error {
 // ---------------------------------------------------------------------------
 
 // CastVote records a user's vote on a challenge response. One vote per user per challenge.
+//
+// ResponseID creatorVoteSide is a vote for the challenger's own video and is
+// stored as a NULL response_id. Every vote is screened first — see
+// vote_integrity.go for the rules and the errors a refused vote returns — and
+// stored with the weight it will count for.
+//
+// The challenge row is share-locked for the length of the vote, so a vote and
+// the resolver settling the same battle queue up rather than cross: either
+// the vote lands first and is counted, or the battle closes first and the
+// vote is refused with errBattleClosed.
+//
+// Returns false, nil when the vote was already on that side — nothing to do.
 func CastVote(payload ChallengeVotePayload) (bool, error) {
 	cid, err := strconv.Atoi(payload.ChallengeID)
 	if err != nil {
 		return false, fmt.Errorf("invalid challenge ID")
 	}
-	rid, err := strconv.Atoi(payload.ResponseID)
-	if err != nil {
-		return false, fmt.Errorf("invalid response ID")
+	var rid any // nil → the challenger's side
+	side := challengeSideKey
+	if payload.ResponseID != creatorVoteSide {
+		n, err := strconv.Atoi(payload.ResponseID)
+		if err != nil {
+			return false, fmt.Errorf("invalid response ID")
+		}
+		rid = n
+		side = strconv.Itoa(n)
 	}
 	vid, err := strconv.Atoi(payload.VoterID)
 	if err != nil {
 		return false, fmt.Errorf("invalid voter ID")
 	}
 
-	// Upsert: if user already voted, update their vote
-	_, err = db.Exec(
-		`INSERT INTO challenge_votes (challenge_id, response_id, voter_id)
-		 VALUES ($1,$2,$3)
-		 ON CONFLICT (challenge_id, voter_id)
-		 DO UPDATE SET response_id = $2`,
-		cid, rid, vid,
-	)
+	// Read outside the transaction: it may compute engagement quality, which
+	// is several queries and has no business holding the battle's lock.
+	weight := voterWeight(payload.VoterID)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }() // no-op once committed
+
+	var creatorID int
+	var status string
+	var resolved bool
+	err = tx.QueryRow(
+		`SELECT creator_id, status, resolved_at IS NOT NULL
+		 FROM challenges WHERE id = $1 FOR SHARE`, cid,
+	).Scan(&creatorID, &status, &resolved)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("challenge not found")
+	}
+	if err != nil {
+		return false, err
+	}
+	if resolved || status == "completed" {
+		return false, errBattleClosed
+	}
+
+	// The side has to belong to this battle, and the voter must not.
+	var participant bool
+	if err := tx.QueryRow(
+		`SELECT $2 = $3 OR EXISTS (SELECT 1 FROM challenge_responses
+		                           WHERE challenge_id = $1 AND responder_id = $2)`,
+		cid, vid, creatorID,
+	).Scan(&participant); err != nil {
+		return false, err
+	}
+	if participant {
+		return false, errVoteByParticipant
+	}
+	if rid != nil {
+		var ok bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM challenge_responses
+			                WHERE id = $1 AND challenge_id = $2 AND COALESCE(is_hidden, FALSE) = FALSE)`,
+			rid, cid,
+		).Scan(&ok); err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, errVoteUnknownSide
+		}
+	}
+
+	dwell, err := voterDwell(tx, vid, cid)
+	if err != nil {
+		return false, err
+	}
+	if !watchedEnough(dwell, side) {
+		return false, errVoteNeedsWatch
+	}
+
+	// An existing vote: same side is a no-op, a switch uses up the change.
+	var prevResp sql.NullInt64
+	var changes int
+	err = tx.QueryRow(
+		`SELECT response_id, changes FROM challenge_votes
+		 WHERE challenge_id = $1 AND voter_id = $2 FOR UPDATE`, cid, vid,
+	).Scan(&prevResp, &changes)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(
+			`INSERT INTO challenge_votes (challenge_id, response_id, voter_id, weight)
+			 VALUES ($1, $2, $3, $4)`,
+			cid, rid, vid, weight,
+		)
+	case err != nil:
+		return false, err
+	default:
+		samePick := (rid == nil && !prevResp.Valid) || (rid != nil && prevResp.Valid && int(prevResp.Int64) == rid.(int))
+		if samePick {
+			return false, nil
+		}
+		if changes >= voteMaxChanges {
+			return false, errVoteLocked
+		}
+		_, err = tx.Exec(
+			`UPDATE challenge_votes
+			    SET response_id = $3, weight = $4, changes = changes + 1, created_at = NOW()
+			  WHERE challenge_id = $1 AND voter_id = $2`,
+			cid, vid, rid, weight,
+		)
+	}
 	if err != nil {
 		return false, err
 	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
-// GetVoteSummary returns vote counts per response for a challenge.
+// GetVoteSummary returns vote counts per side for a challenge, raw and
+// weighted (see vote_integrity.go). The challenger's own side is reported with
+// ResponseID creatorVoteSide.
 func GetVoteSummary(challengeID string) []VoteSummary {
 	cid, err := strconv.Atoi(challengeID)
 	if err != nil {
@@ -2134,13 +2244,15 @@ func GetVoteSummary(challengeID string) []VoteSummary {
 	}
 
 	rows, err := db.Query(
-		`SELECT cv.response_id, u.username, COUNT(*) AS votes
+		`SELECT COALESCE(CAST(cv.response_id AS TEXT), $2), u.username, COUNT(*) AS votes,
+		        COALESCE(SUM(cv.weight), 0) AS weighted
 		 FROM challenge_votes cv
-		 JOIN challenge_responses cr ON cv.response_id = cr.id
-		 JOIN users u ON cr.responder_id = u.id
+		 JOIN challenges c ON c.id = cv.challenge_id
+		 LEFT JOIN challenge_responses cr ON cv.response_id = cr.id
+		 JOIN users u ON u.id = COALESCE(cr.responder_id, c.creator_id)
 		 WHERE cv.challenge_id = $1
 		 GROUP BY cv.response_id, u.username
-		 ORDER BY votes DESC`, cid,
+		 ORDER BY votes DESC`, cid, creatorVoteSide,
 	)
 	if err != nil {
 		return nil
@@ -2149,13 +2261,15 @@ func GetVoteSummary(challengeID string) []VoteSummary {
 
 	var result []VoteSummary
 	for rows.Next() {
-		var respID, votes int
-		var username string
-		if rows.Scan(&respID, &username, &votes) == nil {
+		var respID, username string
+		var votes int
+		var weighted float64
+		if rows.Scan(&respID, &username, &votes, &weighted) == nil {
 			result = append(result, VoteSummary{
-				ResponseID: strconv.Itoa(respID),
-				Username:   username,
-				Votes:      votes,
+				ResponseID:    respID,
+				Username:      username,
+				Votes:         votes,
+				WeightedVotes: math.Round(weighted*100) / 100,
 			})
 		}
 	}
@@ -2498,13 +2612,27 @@ func seedChallenges() {
 // Chat
 // --------------------------------------------------------------------------
 
-// SendChatMessage inserts a message and returns its ID.
-func SendChatMessage(senderID, receiverID int, message string, replyToID *int) (int, error) {
+// SendChatMessage inserts a message and returns its ID. content is what it
+// carries beyond its text — see chat_media.go; the zero value is plain text.
+func SendChatMessage(senderID, receiverID int, message string, replyToID *int, content chatContent) (int, error) {
+	var mediaURL, thumbURL any
+	var durationMs any
+	if a := content.attachment; a != nil {
+		mediaURL = a.URL
+		if a.ThumbnailURL != "" {
+			thumbURL = a.ThumbnailURL
+		}
+		if a.DurationMs > 0 {
+			durationMs = a.DurationMs
+		}
+	}
 	var id int
 	err := db.QueryRow(
-		`INSERT INTO chat_messages (sender_id, receiver_id, message, reply_to_id)
-		 VALUES ($1, $2, $3, $4) RETURNING id`,
+		`INSERT INTO chat_messages (sender_id, receiver_id, message, reply_to_id,
+		                            kind, media_url, media_thumb_url, media_duration_ms, challenge_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
 		senderID, receiverID, message, replyToID,
+		content.storedKind(), mediaURL, thumbURL, durationMs, content.challengeID,
 	).Scan(&id)
 	return id, err
 }
@@ -2514,12 +2642,16 @@ func GetChatMessages(userA, userB, limit, offset int) []ChatMessage {
 	rows, err := db.Query(
 		`SELECT m.id, m.sender_id, s.username, m.receiver_id, r.username,
 				m.message, m.is_read,
-				COALESCE(m.status, 'sent') AS status,
+				CASE WHEN m.is_read THEN 'read'
+				     WHEN m.delivered_at IS NOT NULL THEN 'delivered'
+				     ELSE 'sent' END AS status,
 				COALESCE(m.is_edited, FALSE) AS is_edited,
 				COALESCE(m.is_deleted, FALSE) AS is_deleted,
 				m.reply_to_id,
 				(SELECT m2.message FROM chat_messages m2 WHERE m2.id = m.reply_to_id) AS reply_to_text,
-				m.created_at
+				m.created_at,
+				COALESCE(m.kind, 'text'), m.media_url, m.media_thumb_url, m.media_duration_ms, m.challenge_id,
+				m.delivered_at, m.read_at
 		 FROM chat_messages m
 		 JOIN users s ON m.sender_id = s.id
 		 JOIN users r ON m.receiver_id = r.id
@@ -2542,8 +2674,14 @@ func GetChatMessages(userA, userB, limit, offset int) []ChatMessage {
 		var replyToID *int
 		var replyToText *string
 		var createdAt time.Time
+		var kind string
+		var mediaURL, thumbURL sql.NullString
+		var durationMs, challengeID sql.NullInt64
+		var deliveredAt, readAt sql.NullTime
 		if rows.Scan(&id, &sID, &sName, &rID, &rName, &msg, &isRead,
-			&status, &isEdited, &isDeleted, &replyToID, &replyToText, &createdAt) == nil {
+			&status, &isEdited, &isDeleted, &replyToID, &replyToText, &createdAt,
+			&kind, &mediaURL, &thumbURL, &durationMs, &challengeID,
+			&deliveredAt, &readAt) == nil {
 			cm := ChatMessage{
 				ID:               strconv.Itoa(id),
 				SenderID:         strconv.Itoa(sID),
@@ -2563,19 +2701,45 @@ func GetChatMessages(userA, userB, limit, offset int) []ChatMessage {
 			if replyToText != nil {
 				cm.ReplyToText = *replyToText
 			}
+			scanChatContent(&cm, kind, mediaURL, thumbURL, durationMs, challengeID)
+			if deliveredAt.Valid {
+				cm.DeliveredAt = deliveredAt.Time.UTC().Format(time.RFC3339)
+			}
+			if readAt.Valid {
+				cm.ReadAt = readAt.Time.UTC().Format(time.RFC3339)
+			}
 			result = append(result, cm)
 		}
 	}
+	attachChatReactions(result)
 	return result
 }
 
-// MarkMessagesRead marks all messages from sender to receiver as read.
-func MarkMessagesRead(senderID, receiverID int) {
-	db.Exec(
-		`UPDATE chat_messages SET is_read = TRUE
-		 WHERE sender_id = $1 AND receiver_id = $2 AND is_read = FALSE`,
-		senderID, receiverID,
+// MarkMessagesRead marks messages from sender to receiver as read — all of
+// them, or with upToID > 0 only those up to and including that one — and
+// returns the ids it changed. Read implies delivered.
+func MarkMessagesRead(senderID, receiverID, upToID int) []int {
+	rows, err := db.Query(
+		`UPDATE chat_messages
+		    SET is_read = TRUE, status = 'read', read_at = NOW(),
+		        delivered_at = COALESCE(delivered_at, NOW())
+		 WHERE sender_id = $1 AND receiver_id = $2 AND is_read = FALSE
+		   AND ($3 = 0 OR id <= $3)
+		 RETURNING id`,
+		senderID, receiverID, upToID,
 	)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 // GetConversations returns the list of users the given user has chatted with.
@@ -2615,14 +2779,14 @@ func GetConversations(userID int) []Conversation {
 			continue
 		}
 
-		var lastMsg string
+		var lastMsg, lastKind string
 		var lastTime time.Time
 		err = db.QueryRow(
-			`SELECT message, created_at FROM chat_messages
+			`SELECT message, COALESCE(kind, 'text'), created_at FROM chat_messages
 			 WHERE (sender_id=$1 AND receiver_id=$2) OR (sender_id=$2 AND receiver_id=$1)
 			 ORDER BY created_at DESC LIMIT 1`,
 			userID, pid,
-		).Scan(&lastMsg, &lastTime)
+		).Scan(&lastMsg, &lastKind, &lastTime)
 		if err != nil {
 			continue
 		}
@@ -2638,7 +2802,7 @@ func GetConversations(userID int) []Conversation {
 			UserID:      strconv.Itoa(pid),
 			Username:    username,
 			League:      league,
-			LastMessage: lastMsg,
+			LastMessage: chatPreviewText(lastKind, lastMsg),
 			LastTime:    lastTime.UTC().Format(time.RFC3339),
 			UnreadCount: unread,
 		})
@@ -2821,19 +2985,28 @@ func EditChatMessage(msgID int, senderID int, newText string) error {
 	return nil
 }
 
-// DeleteChatMessage soft-deletes a message (unsend for everyone).
+// DeleteChatMessage soft-deletes a message (unsend for everyone). An
+// attachment goes with it: the columns are cleared, and the files are queued
+// for deletion unless a forward still shows them (chat_media.go).
 func DeleteChatMessage(msgID int, senderID int) error {
-	result, err := db.Exec(
-		`UPDATE chat_messages SET is_deleted=TRUE, message='This message was deleted'
-		 WHERE id=$1 AND sender_id=$2`,
+	var mediaURL, thumbURL sql.NullString
+	err := db.QueryRow(
+		`UPDATE chat_messages m
+		    SET is_deleted=TRUE, message='This message was deleted',
+		        media_url=NULL, media_thumb_url=NULL, media_duration_ms=NULL, challenge_id=NULL
+		   FROM chat_messages old
+		  WHERE m.id=$1 AND m.sender_id=$2 AND old.id = m.id
+		 RETURNING old.media_url, old.media_thumb_url`,
 		msgID, senderID,
-	)
+	).Scan(&mediaURL, &thumbURL)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("message not found or not yours")
+	}
 	if err != nil {
 		return err
 	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("message not found or not yours")
+	if mediaURL.Valid {
+		go releaseChatMedia(mediaURL.String, thumbURL.String)
 	}
 	return nil
 }
@@ -0,0 +1,367 @@
+package main
+
+// direct_challenge.go — calling out one specific rival.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE LIFECYCLE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	pending   the challenger posts a challenge with visibility "direct" and a
+//	          target. The target gets a push and an in-app notification and has
+//	          until respond_by (12 hours unless the challenger picks 1–24).
+//	accepted  the target answers with their video — through the ordinary
+//	          accept endpoint, because accepting a challenge has always meant
+//	          answering it. From there it is an ordinary battle.
+//	declined  the target says no (POST /challenges/{id}/decline).
+//	expired   respond_by passes with no answer. The sweep below notices.
+//
+// On declined or expired the challenger's choice, made when they posted,
+// applies: fallbackToArena opens the challenge to everyone in the arena;
+// otherwise the challenge expires. Either way the challenger hears about it.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY THE CHALLENGE IS AN ORDINARY ROW
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// A direct challenge is a challenges row whose visibility is "direct". Every
+// feed lane and list query asks for 'arena' (or 'friends') by name, so a
+// call-out is invisible to everyone else without touching any of them, and
+// falling back to the arena is one UPDATE of that column. The only new state
+// is the pending call-out itself, in direct_challenges.
+//
+// Falling back also restarts created_at. An open challenge is listed in the
+// arena for 24 hours from created_at, and the battle clock runs from it; a
+// call-out that sat unanswered for 12 hours should arrive in the arena with a
+// full day ahead of it, not half of one.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How long the target has to answer, by default and at the extremes. The
+	// longest is one challenge lifetime: past that a call-out is stale.
+	directDefaultWindow = 12 * time.Hour
+	directMinWindow     = time.Hour
+	directMaxWindow     = challengeLifetime
+	// directExpireInterval is how often the sweep looks for lapsed call-outs.
+	directExpireInterval = time.Minute
+	// directExpireBatch bounds one sweep.
+	directExpireBatch = 200
+)
+
+var (
+	errDirectNotFound   = errors.New("no direct challenge here")
+	errDirectNotYours   = errors.New("this challenge was sent to someone else")
+	errDirectNotPending = errors.New("this challenge has already been answered or has expired")
+)
+
+// DirectChallengeInfo is the call-out attached to a direct challenge.
+type DirectChallengeInfo struct {
+	ChallengeID     string `json:"challengeId"`
+	TargetID        string `json:"targetId"`
+	TargetUsername  string `json:"targetUsername"`
+	Status          string `json:"status"` // "pending", "accepted", "declined", "expired"
+	FallbackToArena bool   `json:"fallbackToArena"`
+	RespondBy       string `json:"respondBy"`
+}
+
+// directWindow validates a direct challenge's target and answer window.
+// Returns the window to use, or a user-facing error.
+func directWindow(p CreateChallengePayload) (time.Duration, error) {
+	if strings.TrimSpace(p.TargetID) == "" {
+		return 0, fmt.Errorf("targetId is required for a direct challenge")
+	}
+	if p.TargetID == p.CreatorID {
+		return 0, fmt.Errorf("you can't challenge yourself")
+	}
+	if p.RespondWithinHours == 0 {
+		return directDefaultWindow, nil
+	}
+	window := time.Duration(p.RespondWithinHours) * time.Hour
+	if window < directMinWindow || window > directMaxWindow {
+		return 0, fmt.Errorf("respondWithinHours must be between %d and %d",
+			int(directMinWindow.Hours()), int(directMaxWindow.Hours()))
+	}
+	return window, nil
+}
+
+// usersBlocked reports whether either user has blocked the other. Fails open
+// on a query error, like the chat check it mirrors.
+func usersBlocked(a, b string) bool {
+	var blocked bool
+	if db != nil {
+		_ = db.QueryRow(`SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			 WHERE (blocker_id = CAST($1 AS INT) AND blocked_id = CAST($2 AS INT))
+			    OR (blocker_id = CAST($2 AS INT) AND blocked_id = CAST($1 AS INT)))`,
+			a, b).Scan(&blocked)
+	}
+	return blocked
+}
+
+// createDirectChallenge records the call-out for a challenge just created.
+func createDirectChallenge(challengeID, targetID string, fallback bool, respondBy time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO direct_challenges (challenge_id, target_id, fallback_to_arena, respond_by)
+		VALUES (CAST($1 AS INT), CAST($2 AS INT), $3, $4)`,
+		challengeID, targetID, fallback, respondBy)
+	return err
+}
+
+// loadDirectChallenge reads the call-out on a challenge, if it has one.
+func loadDirectChallenge(challengeID string) (DirectChallengeInfo, bool) {
+	var d DirectChallengeInfo
+	var targetID int
+	var respondBy time.Time
+	err := db.QueryRow(`
+		SELECT d.target_id, u.username, d.status, d.fallback_to_arena, d.respond_by
+		FROM direct_challenges d
+		JOIN users u ON u.id = d.target_id
+		WHERE d.challenge_id = CAST($1 AS INT)`, challengeID).
+		Scan(&targetID, &d.TargetUsername, &d.Status, &d.FallbackToArena, &respondBy)
+	if err != nil {
+		return d, false
+	}
+	d.ChallengeID = challengeID
+	d.TargetID = strconv.Itoa(targetID)
+	d.RespondBy = respondBy.UTC().Format(time.RFC3339)
+	return d, true
+}
+
+// claimDirectChallenge marks a call-out accepted on behalf of the responder,
+// before their answer is stored, and opens the challenge to the arena: once
+// answered it is a battle like any other, and a battle needs voters. Only the
+// target can claim, only while it is pending and in time. The conditional
+// UPDATE is the whole check, so a decline, a lapse and an accept racing each
+// other settle on exactly one.
+func claimDirectChallenge(challengeID, responderID string) error {
+	res, err := db.Exec(`
+		WITH claimed AS (
+			UPDATE direct_challenges
+			   SET status = 'accepted', decided_at = NOW()
+			 WHERE challenge_id = CAST($1 AS INT)
+			   AND target_id = CAST($2 AS INT)
+			   AND status = 'pending'
+			   AND respond_by > NOW()
+			RETURNING challenge_id)
+		UPDATE challenges SET visibility = 'arena'
+		 WHERE id IN (SELECT challenge_id FROM claimed)`, challengeID, responderID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil
+	}
+	d, ok := loadDirectChallenge(challengeID)
+	switch {
+	case !ok:
+		return errDirectNotFound
+	case d.TargetID != responderID:
+		return errDirectNotYours
+	default:
+		return errDirectNotPending
+	}
+}
+
+// releaseDirectChallenge undoes a claim whose answer then failed to store, so
+// the target can try again.
+func releaseDirectChallenge(challengeID string) {
+	if _, err := db.Exec(`
+		WITH released AS (
+			UPDATE direct_challenges SET status = 'pending', decided_at = NULL
+			 WHERE challenge_id = CAST($1 AS INT) AND status = 'accepted'
+			   AND NOT EXISTS (SELECT 1 FROM challenge_responses r WHERE r.challenge_id = CAST($1 AS INT))
+			RETURNING challenge_id)
+		UPDATE challenges SET visibility = 'direct'
+		 WHERE id IN (SELECT challenge_id FROM released)`,
+		challengeID); err != nil {
+		log.Printf("direct challenge %s: releasing claim: %v", challengeID, err)
+	}
+}
+
+// directLapse is what happened when a call-out was declined or expired, for
+// the notifications that follow.
+type directLapse struct {
+	challengeID, creatorID, creatorUsername string
+	targetUsername, title                   string
+	outcome                                 string // "declined" or "expired"
+	toArena                                 bool
+}
+
+// lapseDirectChallenge settles a call-out that will not be answered:
+// declined by the target, or expired. Applies the challenger's fallback.
+// Returns nil, errDirectNotPending if it was already settled.
+func lapseDirectChallenge(ctx context.Context, challengeID, outcome string) (*directLapse, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	l := &directLapse{challengeID: challengeID, outcome: outcome}
+	var creatorID int
+	var prefix, subject string
+	err = tx.QueryRowContext(ctx, `
+		SELECT d.fallback_to_arena, c.creator_id, cu.username, tu.username, c.prefix, c.subject
+		FROM direct_challenges d
+		JOIN challenges c ON c.id = d.challenge_id
+		JOIN users cu ON cu.id = c.creator_id
+		JOIN users tu ON tu.id = d.target_id
+		WHERE d.challenge_id = CAST($1 AS INT) AND d.status = 'pending'
+		FOR UPDATE OF d`, challengeID).
+		Scan(&l.toArena, &creatorID, &l.creatorUsername, &l.targetUsername, &prefix, &subject)
+	if err == sql.ErrNoRows {
+		return nil, errDirectNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.creatorID = strconv.Itoa(creatorID)
+	l.title = prefix + " " + subject
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE direct_challenges SET status = $2, decided_at = NOW()
+		 WHERE challenge_id = CAST($1 AS INT)`, challengeID, outcome); err != nil {
+		return nil, err
+	}
+	if l.toArena {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE challenges SET visibility = 'arena', created_at = NOW()
+			 WHERE id = CAST($1 AS INT) AND status = 'open'`, challengeID)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE challenges SET status = 'expired'
+			 WHERE id = CAST($1 AS INT) AND status = 'open'`, challengeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, tx.Commit()
+}
+
+// afterDirectLapse does the follow-up outside the transaction: tell the
+// challenger, and list the challenge in search if it is now public.
+func afterDirectLapse(l *directLapse) {
+	SendDirectChallengeLapsedNotification(l.creatorID, l.creatorUsername, l.targetUsername,
+		l.challengeID, l.title, l.outcome, l.toArena)
+	if l.toArena {
+		if ch, ok := GetChallengeByID(l.challengeID); ok {
+			IndexChallenge(ch)
+		}
+	}
+}
+
+// startDirectChallengeExpirer expires call-outs nobody answered, forever.
+// Safe on several instances: lapseDirectChallenge re-checks under a row lock.
+func startDirectChallengeExpirer() {
+	go func() {
+		t := time.NewTicker(directExpireInterval)
+		defer t.Stop()
+		for range t.C {
+			if err := runDirectChallengeExpiry(context.Background()); err != nil {
+				log.Printf("direct challenge expiry: %v", err)
+			}
+		}
+	}()
+}
+
+// runDirectChallengeExpiry expires one batch of lapsed call-outs.
+func runDirectChallengeExpiry(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT CAST(challenge_id AS TEXT) FROM direct_challenges
+		WHERE status = 'pending' AND respond_by <= NOW()
+		ORDER BY respond_by
+		LIMIT $1`, directExpireBatch)
+	if err != nil {
+		return fmt.Errorf("finding lapsed call-outs: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		l, err := lapseDirectChallenge(ctx, id, "expired")
+		if errors.Is(err, errDirectNotPending) {
+			continue // answered or declined in the meantime
+		}
+		if err != nil {
+			log.Printf("direct challenge %s: expiring: %v", id, err)
+			continue
+		}
+		go afterDirectLapse(l)
+	}
+	return nil
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HTTP
+// ════════════════════════════════════════════════════════════════════════════════
+
+// DeclineDirectChallengeHandler lets the target turn a call-out down.
+// POST /api/v1/challenges/{id}/decline
+func DeclineDirectChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userID := authUserID(r)
+
+	d, ok := loadDirectChallenge(id)
+	if !ok {
+		http.Error(w, errDirectNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if d.TargetID != userID {
+		http.Error(w, errDirectNotYours.Error(), http.StatusForbidden)
+		return
+	}
+	l, err := lapseDirectChallenge(r.Context(), id, "declined")
+	if errors.Is(err, errDirectNotPending) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to decline challenge: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go afterDirectLapse(l)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "declined",
+		"toArena": l.toArena,
+	})
+}
+
+// GetDirectChallengesHandler lists the call-outs waiting on the caller.
+// GET /api/v1/challenges/direct
+func GetDirectChallengesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authUserID(r)
+	challenges := queryChallenges(challengeBaseQuery+`
+		JOIN direct_challenges d ON d.challenge_id = c.id
+		WHERE d.target_id = CAST($1 AS INT)
+		  AND d.status = 'pending' AND d.respond_by > NOW()
+		ORDER BY d.respond_by ASC`, userID)
+	if challenges == nil {
+		challenges = []Challenge{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenges)
+}
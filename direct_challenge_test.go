@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDirectWindow(t *testing.T) {
+	cases := []struct {
+		name    string
+		p       CreateChallengePayload
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default window", CreateChallengePayload{CreatorID: "1", TargetID: "2"}, directDefaultWindow, false},
+		{"chosen window", CreateChallengePayload{CreatorID: "1", TargetID: "2", RespondWithinHours: 3}, 3 * time.Hour, false},
+		{"longest allowed", CreateChallengePayload{CreatorID: "1", TargetID: "2", RespondWithinHours: 24}, 24 * time.Hour, false},
+		{"too long", CreateChallengePayload{CreatorID: "1", TargetID: "2", RespondWithinHours: 25}, 0, true},
+		{"negative", CreateChallengePayload{CreatorID: "1", TargetID: "2", RespondWithinHours: -1}, 0, true},
+		{"no target", CreateChallengePayload{CreatorID: "1"}, 0, true},
+		{"yourself", CreateChallengePayload{CreatorID: "1", TargetID: "1"}, 0, true},
+	}
+	for _, c := range cases {
+		got, err := directWindow(c.p)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", c.name, err, c.wantErr)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: window = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// A claim that updates nothing has to say why: somebody else's call-out is a
+// 403, one already settled is a 409.
+func TestClaimDirectChallengeExplainsRefusal(t *testing.T) {
+	mock, done := withMockDB(t)
+	defer done()
+
+	respondBy := time.Now().Add(time.Hour)
+	for _, c := range []struct {
+		responder string
+		status    string
+		want      error
+	}{
+		{"9", "pending", errDirectNotYours},
+		{"2", "declined", errDirectNotPending},
+	} {
+		mock.ExpectExec(`WITH claimed AS`).
+			WithArgs("5", c.responder).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`FROM direct_challenges d`).
+			WithArgs("5").
+			WillReturnRows(sqlmock.NewRows([]string{"target_id", "username", "status", "fallback_to_arena", "respond_by"}).
+				AddRow(2, "rival", c.status, true, respondBy))
+
+		if err := claimDirectChallenge("5", c.responder); !errors.Is(err, c.want) {
+			t.Errorf("responder %s on a %s call-out: got %v, want %v", c.responder, c.status, err, c.want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLapseDirectChallengeFallsBackToArena(t *testing.T) {
+	mock, done := withMockDB(t)
+	defer done()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM direct_challenges d[\s\S]*FOR UPDATE OF d`).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"fallback", "creator_id", "creator", "target", "prefix", "subject"}).
+			AddRow(true, 1, "alice", "bob", "Who is better", "Dancer"))
+	mock.ExpectExec(`UPDATE direct_challenges SET status = \$2`).
+		WithArgs("5", "declined").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE challenges SET visibility = 'arena', created_at = NOW\(\)`).
+		WithArgs("5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	l, err := lapseDirectChallenge(t.Context(), "5", "declined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.toArena || l.creatorID != "1" || l.title != "Who is better Dancer" {
+		t.Errorf("unexpected lapse %+v", l)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLapseDirectChallengeAlreadySettled(t *testing.T) {
+	mock, done := withMockDB(t)
+	defer done()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM direct_challenges d`).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"fallback"}))
+	mock.ExpectRollback()
+
+	if _, err := lapseDirectChallenge(t.Context(), "5", "expired"); !errors.Is(err, errDirectNotPending) {
+		t.Errorf("got %v, want errDirectNotPending", err)
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+// enforcement.go — what an account is allowed to do, when moderation says
+// less than everything.
+//
+// Until moderation.go an account had no state beyond existing: someone
+// reported over and over kept posting, chatting and voting, braked only by
+// actionLimitTable. A reviewer's decision can now put one of three
+// restrictions on an account, each with an end (or none):
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// RESTRICTIONS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	suspended    authed() refuses every token the account holds and sign-in
+//	             refuses new ones. Their content and account leave feeds and
+//	             search. The only routes left open are the ones below that
+//	             let them see why and appeal.
+//	posting_ban  no new challenges, no new responses. Watching, chatting and
+//	             voting are untouched — the problem was what they posted.
+//	limited      "limited distribution": nothing is refused and nothing is
+//	             hidden from the account itself. Their content still reaches
+//	             their followers, but no other candidate source picks it up,
+//	             and scoreForUser scales it right down for anyone who does not
+//	             follow them. The account is not told; that is the point.
+//
+// Strikes are warnings that expire (moderationStrikeLifetime). Enough live at
+// once (moderationStrikeLimit) and the account is suspended.
+//
+// Everything refused answers with the same machine-readable body — which
+// restriction, a reason code from restrictReasons, when it ends, the decision
+// it came from, and where to appeal — so the app can explain rather than
+// show a bare 403.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// APPEALS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// One appeal per decision, from the account it was about, reachable even
+// while suspended. A reviewer grants or denies it; granting undoes what that
+// decision did — the restriction it imposed, the strike it gave, the content
+// it hid — and both outcomes go in the audit log and are sent to the user.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Restriction kinds.
+const (
+	restrictSuspended  = "suspended"
+	restrictPostingBan = "posting_ban"
+	restrictLimited    = "limited"
+)
+
+// restrictionForAction is the restriction each decision imposes.
+var restrictionForAction = map[string]string{
+	moderationSuspend:    restrictSuspended,
+	moderationPostingBan: restrictPostingBan,
+	moderationLimit:      restrictLimited,
+}
+
+// Reason codes a restricted account is shown. The app owns the wording.
+const (
+	restrictReasonDefault = "policy_violation"
+	restrictReasonStrikes = "repeated_strikes"
+)
+
+var restrictReasons = map[string]bool{
+	restrictReasonDefault: true,
+	restrictReasonStrikes: true,
+	"spam":                true,
+	"harassment":          true,
+	"hate":                true,
+	"threats":             true,
+	"violence":            true,
+	"sexual_content":      true,
+	"impersonation":       true,
+	"scam":                true,
+}
+
+// limitedDistributionMult is what scoreForUser scales a limited account's
+// content by for a viewer who does not follow them. Not zero: the item may
+// still fill a page that has nothing better, it just never wins one.
+const limitedDistributionMult = 0.1
+
+// appealPath is where a restricted account is pointed.
+const appealPath = "/api/v1/account/appeals"
+
+var (
+	errAppealNotYours  = errors.New("that decision was not about your account")
+	errAppealDuplicate = errors.New("you have already appealed this decision")
+	errAppealNotFound  = errors.New("appeal not found")
+	errAppealDecided   = errors.New("this appeal has already been decided")
+)
+
+// accountRestriction is one restriction on an account.
+type accountRestriction struct {
+	Kind     string
+	Reason   string
+	Until    time.Time // zero: until further notice
+	ActionID string
+}
+
+// activeAt reports whether the restriction is in force at t.
+func (r accountRestriction) activeAt(t time.Time) bool {
+	return r.Until.IsZero() || t.Before(r.Until)
+}
+
+// outlasts reports whether r ends no earlier than o.
+func (r accountRestriction) outlasts(o accountRestriction) bool {
+	if r.Until.IsZero() {
+		return true
+	}
+	return !o.Until.IsZero() && !r.Until.Before(o.Until)
+}
+
+// untilArg is the until column's value.
+func (r accountRestriction) untilArg() interface{} {
+	if r.Until.IsZero() {
+		return "infinity"
+	}
+	return r.Until
+}
+
+// untilText is the end as the API shows it.
+func (r accountRestriction) untilText() string {
+	if r.Until.IsZero() {
+		return "indefinite"
+	}
+	return r.Until.UTC().Format(time.RFC3339)
+}
+
+// restrictionEnd is when a restriction of length d starting at now ends.
+// Zero d is indefinite.
+func restrictionEnd(now time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+// upsertRestriction puts a restriction on an account. A shorter one never
+// cuts a longer one of the same kind short.
+func upsertRestriction(tx *sql.Tx, userID int, r accountRestriction) error {
+	_, err := tx.Exec(`
+		INSERT INTO account_restrictions (user_id, kind, until, reason, action_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, kind) DO UPDATE SET
+			until = EXCLUDED.until, reason = EXCLUDED.reason, action_id = EXCLUDED.action_id,
+			created_at = NOW()
+		WHERE account_restrictions.until <= EXCLUDED.until`,
+		userID, r.Kind, r.untilArg(), r.Reason, r.ActionID)
+	return err
+}
+
+// loadRestrictions reads account_restrictions rows matching where, by user.
+func loadRestrictions(where string, args ...interface{}) (map[string][]accountRestriction, error) {
+	rows, err := db.Query(`
+		SELECT user_id, kind, reason, action_id,
+		       COALESCE(NULLIF(until, 'infinity'), 'epoch'), until = 'infinity'
+		FROM account_restrictions `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]accountRestriction{}
+	for rows.Next() {
+		var userID, actionID int
+		var r accountRestriction
+		var indefinite bool
+		if rows.Scan(&userID, &r.Kind, &r.Reason, &actionID, &r.Until, &indefinite) != nil {
+			continue
+		}
+		if indefinite {
+			r.Until = time.Time{}
+		}
+		r.ActionID = strconv.Itoa(actionID)
+		uid := strconv.Itoa(userID)
+		out[uid] = append(out[uid], r)
+	}
+	return out, rows.Err()
+}
+
+// activeRestriction reads a restriction straight from the database, for the
+// places that must not trust a snapshot up to moderationSyncInterval old —
+// sign-in.
+func activeRestriction(userID, kind string) (accountRestriction, bool) {
+	if db == nil {
+		return accountRestriction{}, false
+	}
+	rs, err := loadRestrictions(`WHERE user_id = CAST($1 AS INT) AND kind = $2 AND until > NOW()`, userID, kind)
+	if err != nil || len(rs[userID]) == 0 {
+		return accountRestriction{}, false
+	}
+	return rs[userID][0], true
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// ENFORCEMENT
+// ════════════════════════════════════════════════════════════════════════════════
+
+// RestrictionNotice is the body of every response refused by a restriction.
+type RestrictionNotice struct {
+	Error       string `json:"error"`       // "account_suspended" | "posting_banned"
+	Restriction string `json:"restriction"` // a restriction kind
+	Reason      string `json:"reason"`      // one of restrictReasons
+	Until       string `json:"until"`       // RFC 3339, or "indefinite"
+	ActionID    string `json:"actionId"`
+	Appeal      string `json:"appeal"`
+}
+
+// writeRestricted refuses a request because of r.
+func writeRestricted(w http.ResponseWriter, r accountRestriction) {
+	code := "account_suspended"
+	if r.Kind == restrictPostingBan {
+		code = "posting_banned"
+	}
+	writeJSON(w, http.StatusForbidden, RestrictionNotice{
+		Error:       code,
+		Restriction: r.Kind,
+		Reason:      r.Reason,
+		Until:       r.untilText(),
+		ActionID:    r.ActionID,
+		Appeal:      appealPath,
+	})
+}
+
+// requireCanPost refuses the request if the user is banned from posting.
+// Returns false when it has written the refusal.
+func requireCanPost(w http.ResponseWriter, userID string) bool {
+	if rs, banned := moderationRestriction(userID, restrictPostingBan); banned {
+		writeRestricted(w, rs)
+		return false
+	}
+	return true
+}
+
+// moderationLimited reports whether a creator is on limited distribution.
+func moderationLimited(creatorID string) bool {
+	_, ok := moderationRestriction(creatorID, restrictLimited)
+	return ok
+}
+
+// distributionMult is the factor scoreForUser applies for a creator's
+// distribution: 1 unless they are limited and the viewer doesn't follow them.
+func distributionMult(creatorID string, following map[string]bool) float64 {
+	if creatorID == "" || following[creatorID] || !moderationLimited(creatorID) {
+		return 1.0
+	}
+	return limitedDistributionMult
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// STANDING AND APPEALS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// AccountStrike is one live strike.
+type AccountStrike struct {
+	ActionID  string `json:"actionId"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// ModerationAppeal is one appeal.
+type ModerationAppeal struct {
+	ID        string `json:"id"`
+	UserID    string `json:"userId"`
+	ActionID  string `json:"actionId"`
+	Message   string `json:"message"`
+	Status    string `json:"status"` // "pending", "granted", "denied"
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	DecidedAt string `json:"decidedAt,omitempty"`
+}
+
+// listAppeals reads appeals matching where, newest first.
+func listAppeals(where string, args ...interface{}) ([]ModerationAppeal, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, action_id, message, status, COALESCE(decision_note, ''), created_at, decided_at
+		FROM moderation_appeals `+where+`
+		ORDER BY created_at DESC LIMIT 200`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ModerationAppeal{}
+	for rows.Next() {
+		var a ModerationAppeal
+		var id, userID, actionID int
+		var created time.Time
+		var decided sql.NullTime
+		if rows.Scan(&id, &userID, &actionID, &a.Message, &a.Status, &a.Note, &created, &decided) != nil {
+			continue
+		}
+		a.ID, a.UserID, a.ActionID = strconv.Itoa(id), strconv.Itoa(userID), strconv.Itoa(actionID)
+		a.CreatedAt = created.UTC().Format(time.RFC3339)
+		if decided.Valid {
+			a.DecidedAt = decided.Time.UTC().Format(time.RFC3339)
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// AccountStandingHandler — GET /api/v1/account/standing
+// The caller's restrictions (except limited, which is never disclosed), live
+// strikes and appeals. Open to suspended accounts.
+func AccountStandingHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	userID := authUserID(r)
+	restrictions := []RestrictionNotice{}
+	rs, err := loadRestrictions(`WHERE user_id = CAST($1 AS INT) AND until > NOW() AND kind <> $2`,
+		userID, restrictLimited)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	for _, rr := range rs[userID] {
+		restrictions = append(restrictions, RestrictionNotice{
+			Restriction: rr.Kind, Reason: rr.Reason, Until: rr.untilText(),
+			ActionID: rr.ActionID, Appeal: appealPath,
+		})
+	}
+
+	strikes := []AccountStrike{}
+	rows, err := db.Query(`
+		SELECT action_id, reason, created_at, expires_at FROM account_strikes
+		WHERE user_id = CAST($1 AS INT) AND expires_at > NOW() AND lifted_at IS NULL
+		ORDER BY created_at`, userID)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s AccountStrike
+		var actionID int
+		var created, expires time.Time
+		if rows.Scan(&actionID, &s.Reason, &created, &expires) == nil {
+			s.ActionID = strconv.Itoa(actionID)
+			s.CreatedAt = created.UTC().Format(time.RFC3339)
+			s.ExpiresAt = expires.UTC().Format(time.RFC3339)
+			strikes = append(strikes, s)
+		}
+	}
+
+	appeals, err := listAppeals(`WHERE user_id = CAST($1 AS INT)`, userID)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"restrictions": restrictions,
+		"strikes":      strikes,
+		"strikeLimit":  moderationStrikeLimit,
+		"appeals":      appeals,
+	})
+}
+
+// CreateAppealHandler — POST /api/v1/account/appeals body:{ actionId, message }
+// Open to suspended accounts.
+func CreateAppealHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		ActionID string `json:"actionId"`
+		Message  string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	actionID, err := strconv.Atoi(p.ActionID)
+	if err != nil || p.Message == "" {
+		http.Error(w, "actionId and message are required", http.StatusBadRequest)
+		return
+	}
+	if !allowAction(userID, "report") {
+		writeRateLimited(w, "report")
+		return
+	}
+
+	var id int
+	var created time.Time
+	err = db.QueryRow(`
+		INSERT INTO moderation_appeals (user_id, action_id, message)
+		SELECT subject_user_id, id, $3 FROM moderation_actions
+		WHERE id = $1 AND subject_user_id = CAST($2 AS INT) AND action <> 'dismiss'
+		ON CONFLICT (action_id) DO NOTHING
+		RETURNING id, created_at`, actionID, userID, truncateText(p.Message, 2000)).Scan(&id, &created)
+	if err == sql.ErrNoRows {
+		// Either not theirs, or already appealed; say which.
+		var exists bool
+		_ = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM moderation_appeals
+			WHERE action_id = $1 AND user_id = CAST($2 AS INT))`, actionID, userID).Scan(&exists)
+		if exists {
+			http.Error(w, errAppealDuplicate.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, errAppealNotYours.Error(), http.StatusNotFound)
+		}
+		return
+	}
+	if err != nil {
+		http.Error(w, "appeal failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ModerationAppeal{
+		ID: strconv.Itoa(id), UserID: userID, ActionID: p.ActionID, Message: p.Message,
+		Status: "pending", CreatedAt: created.UTC().Format(time.RFC3339),
+	})
+}
+
+// AdminListAppealsHandler — GET /api/v1/admin/moderation/appeals?status=pending
+func AdminListAppealsHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	appeals, err := listAppeals(`WHERE status = $1`, status)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"appeals": appeals})
+}
+
+// decideAppeal grants or denies an appeal. Granting undoes the decision it
+// was about. Both outcomes are logged as moderation actions.
+func decideAppeal(appealID int, grant bool, reviewer, note string) (ModerationAppeal, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return ModerationAppeal{}, err
+	}
+	defer tx.Rollback()
+
+	var a ModerationAppeal
+	var userID, actionID, targetID int
+	var targetType string
+	err = tx.QueryRow(`
+		SELECT ap.user_id, ap.action_id, ap.status, ap.message, ma.target_type, ma.target_id
+		FROM moderation_appeals ap JOIN moderation_actions ma ON ma.id = ap.action_id
+		WHERE ap.id = $1 FOR UPDATE OF ap`, appealID).Scan(&userID, &actionID, &a.Status, &a.Message, &targetType, &targetID)
+	if err == sql.ErrNoRows {
+		return ModerationAppeal{}, errAppealNotFound
+	}
+	if err != nil {
+		return ModerationAppeal{}, err
+	}
+	if a.Status != "pending" {
+		return ModerationAppeal{}, errAppealDecided
+	}
+
+	outcome, action := "denied", "appeal_denied"
+	if grant {
+		outcome, action = "granted", "appeal_granted"
+		for _, q := range []string{
+			`DELETE FROM account_restrictions WHERE action_id = $1`,
+			`UPDATE account_strikes SET lifted_at = NOW() WHERE action_id = $1 AND lifted_at IS NULL`,
+			`DELETE FROM moderation_hidden WHERE action_id = $1`,
+		} {
+			if _, err := tx.Exec(q, actionID); err != nil {
+				return ModerationAppeal{}, err
+			}
+		}
+		if targetType == "response" {
+			if _, err := tx.Exec(`UPDATE challenge_responses SET is_hidden = FALSE WHERE id = $1
+				AND NOT EXISTS (SELECT 1 FROM moderation_hidden WHERE target_type = 'response' AND target_id = $1)`,
+				targetID); err != nil {
+				return ModerationAppeal{}, err
+			}
+		}
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO moderation_actions (target_type, target_id, subject_user_id, action, reviewer, note)
+		VALUES ($1, $2, $3, $4, $5, $6)`, targetType, targetID, userID, action, reviewer, note); err != nil {
+		return ModerationAppeal{}, err
+	}
+	var decided time.Time
+	if err := tx.QueryRow(`UPDATE moderation_appeals
+		SET status = $2, decision_note = $3, reviewer = $4, decided_at = NOW()
+		WHERE id = $1 RETURNING decided_at`, appealID, outcome, note, reviewer).Scan(&decided); err != nil {
+		return ModerationAppeal{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ModerationAppeal{}, err
+	}
+	a.ID, a.UserID, a.ActionID = strconv.Itoa(appealID), strconv.Itoa(userID), strconv.Itoa(actionID)
+	a.Status, a.Note = outcome, note
+	a.DecidedAt = decided.UTC().Format(time.RFC3339)
+	return a, nil
+}
+
+// AdminDecideAppealHandler — POST /api/v1/admin/moderation/appeals/{id}/decide
+// body:{ decision: "grant"|"deny", note }
+func AdminDecideAppealHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "unknown appeal", http.StatusBadRequest)
+		return
+	}
+	var p struct {
+		Decision string `json:"decision"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || (p.Decision != "grant" && p.Decision != "deny") {
+		http.Error(w, `decision must be "grant" or "deny"`, http.StatusBadRequest)
+		return
+	}
+	a, err := decideAppeal(id, p.Decision == "grant", moderationReviewer(r), truncateText(p.Note, 1000))
+	switch {
+	case errors.Is(err, errAppealNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errAppealDecided):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "decision failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("moderation: appeal %s %s (action %s)", a.ID, a.Status, a.ActionID)
+	if a.Status == "granted" {
+		// Lifting is rarer than imposing; rebuilding the snapshot is simpler
+		// than undoing one entry and just as quick.
+		go loadModerationState()
+	}
+	go notifyAppealDecided(a)
+	writeJSON(w, http.StatusOK, a)
+}
+
+// notifyAppealDecided tells the user how their appeal went.
+func notifyAppealDecided(a ModerationAppeal) {
+	msg := "We reviewed your appeal and upheld our decision."
+	if a.Status == "granted" {
+		msg = "We reviewed your appeal and reversed our decision."
+	}
+	if u, ok := GetUserByID(a.UserID); ok {
+		deliverNotification(u.Username, Notification{
+			Type:      "appeal_" + a.Status,
+			Message:   msg,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      a.UserID,
+		TriggerKind: TriggerReportResolved,
+		DedupeKey:   fmt.Sprintf("appeal:%s", a.ID),
+		Title:       "Your appeal was reviewed",
+		Body:        msg,
+		Deeplink:    "devf://account/standing",
+	}); err != nil {
+		log.Printf("appeal %s: queueing push: %v", a.ID, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAccountRestrictionSpan(t *testing.T) {
+	now := time.Now()
+	indefinite := accountRestriction{Kind: restrictSuspended}
+	week := accountRestriction{Kind: restrictSuspended, Until: now.Add(7 * 24 * time.Hour)}
+	day := accountRestriction{Kind: restrictSuspended, Until: now.Add(24 * time.Hour)}
+
+	if !indefinite.activeAt(now.Add(10*365*24*time.Hour)) || !week.activeAt(now) || week.activeAt(now.Add(8*24*time.Hour)) {
+		t.Error("activeAt")
+	}
+	if !indefinite.outlasts(week) || !week.outlasts(day) || day.outlasts(week) || week.outlasts(indefinite) {
+		t.Error("outlasts")
+	}
+	if indefinite.untilArg() != "infinity" || indefinite.untilText() != "indefinite" {
+		t.Errorf("indefinite = %v / %s", indefinite.untilArg(), indefinite.untilText())
+	}
+	if !restrictionEnd(now, 0).IsZero() || !restrictionEnd(now, time.Hour).Equal(now.Add(time.Hour)) {
+		t.Error("restrictionEnd")
+	}
+}
+
+// A posting ban refuses new challenges, tournaments and responses with a
+// body the app can act on; browsing is untouched.
+func TestPostingBanRefusesPosting(t *testing.T) {
+	s := newModerationState()
+	s.restrict("u_muted", accountRestriction{Kind: restrictPostingBan, Reason: "spam", ActionID: "31"})
+	withModerationState(t, s)
+
+	tok, _ := issueToken("u_muted", "muted")
+	for name, h := range map[string]http.HandlerFunc{
+		"create":          CreateChallengeHandler,
+		"accept":          AcceptChallengeHandler,
+		"tournament":      CreateTournamentHandler,
+		"tournament join": JoinTournamentHandler,
+	} {
+		req := httptest.NewRequest("POST", "/x", strings.NewReader(`{"challengeId":"1","prefix":"a","subject":"b"}`))
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		authed(h)(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: status %d, want 403", name, w.Code)
+		}
+		var n RestrictionNotice
+		if err := json.Unmarshal(w.Body.Bytes(), &n); err != nil {
+			t.Fatalf("%s: body %q: %v", name, w.Body.String(), err)
+		}
+		if n.Error != "posting_banned" || n.Reason != "spam" || n.Until != "indefinite" || n.ActionID != "31" || n.Appeal != appealPath {
+			t.Errorf("%s: notice = %+v", name, n)
+		}
+	}
+
+	// Banned from posting, not from the app.
+	ok := authed(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	req := httptest.NewRequest("GET", "/x", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	w := httptest.NewRecorder()
+	ok(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("posting ban blocked a read: %d", w.Code)
+	}
+}
+
+// The standing and appeal routes stay open to a suspended account.
+func TestAuthedAllowSuspended(t *testing.T) {
+	s := newModerationState()
+	s.restrict("u_out", accountRestriction{Kind: restrictSuspended, Reason: restrictReasonStrikes})
+	withModerationState(t, s)
+
+	tok, _ := issueToken("u_out", "out")
+	h := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	for name, tc := range map[string]struct {
+		wrap func(http.HandlerFunc) http.HandlerFunc
+		want int
+	}{
+		"authed":               {authed, http.StatusForbidden},
+		"authedAllowSuspended": {authedAllowSuspended, http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/x", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		tc.wrap(h)(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", name, w.Code, tc.want)
+		}
+		if tc.want == http.StatusForbidden && !strings.Contains(w.Body.String(), `"account_suspended"`) {
+			t.Errorf("%s: body %s", name, w.Body.String())
+		}
+	}
+}
+
+// Limited distribution damps a creator for strangers, not for followers.
+func TestLimitedDistribution(t *testing.T) {
+	s := newModerationState()
+	s.restrict("lim", accountRestriction{Kind: restrictLimited})
+	withModerationState(t, s)
+
+	if distributionMult("lim", map[string]bool{}) != limitedDistributionMult {
+		t.Error("limited creator not damped for a stranger")
+	}
+	if distributionMult("lim", map[string]bool{"lim": true}) != 1 || distributionMult("ok", nil) != 1 {
+		t.Error("damped where it shouldn't be")
+	}
+}
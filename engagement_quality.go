@@ -78,14 +78,17 @@ func computeEngagementQuality(userID string) float64 {
 	// last 30 days so a brand-new account's reputation can grow.
 	var ageDays float64
 	var totalEvents, completes, skips, sessions, flagsAgainst int
+	// created_at is NULL for accounts that predate its being recorded (see
+	// migrations/006_vote_integrity.sql); those are old, so they read as fully
+	// aged rather than as brand new.
 	err := db.QueryRow(`
 		SELECT
-			COALESCE(EXTRACT(EPOCH FROM (NOW() - u.created_at))/86400, 0) AS age_days,
+			COALESCE(EXTRACT(EPOCH FROM (NOW() - u.created_at))/86400, 90) AS age_days,
 			(SELECT COUNT(*) FROM feed_events WHERE user_id = u.id::text AND created_at > NOW() - INTERVAL '30 days') AS total_events,
 			(SELECT COUNT(*) FROM feed_events WHERE user_id = u.id::text AND event_type = 'complete' AND created_at > NOW() - INTERVAL '30 days') AS completes,
 			(SELECT COUNT(*) FROM feed_events WHERE user_id = u.id::text AND event_type IN ('skip','not_interested') AND created_at > NOW() - INTERVAL '30 days') AS skips,
 			(SELECT COUNT(DISTINCT session_id) FROM feed_events WHERE user_id = u.id::text AND created_at > NOW() - INTERVAL '30 days') AS sessions,
-			(SELECT COUNT(*) FROM reports WHERE target_id = u.id::text AND target_type = 'user' AND created_at > NOW() - INTERVAL '30 days') AS flags_against
+			(SELECT COUNT(*) FROM reports WHERE target_id = u.id AND target_type = 'user' AND created_at > NOW() - INTERVAL '30 days') AS flags_against
 		FROM users u
 		WHERE u.id::text = $1
 	`, userID).Scan(&ageDays, &totalEvents, &completes, &skips, &sessions, &flagsAgainst)
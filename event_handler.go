@@ -27,17 +27,27 @@ func HandleFollowEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ProcessFollowEvent(payload); err != nil {
+	outcome, err := ProcessFollowEvent(payload)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// After successfully processing the follow event, send a notification.
-	go SendFollowNotification(payload)
+	// A private account gets asked instead — once, not on every retry.
+	message := "Follow event processed successfully"
+	if outcome.Status == followStatusRequested {
+		message = "Follow request sent"
+		if outcome.Created {
+			go SendFollowRequestNotification(outcome.FollowerID, outcome.TargetID)
+		}
+	} else {
+		go SendFollowNotification(payload)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Follow event processed successfully"})
+	json.NewEncoder(w).Encode(map[string]string{"message": message, "status": outcome.Status})
 }
 
 // HandleUnfollowEvent handles the logic for when a user unfollows another user.
@@ -28,26 +28,69 @@ func resolveUserID(idStr, username string) (int, error) {
 	return 0, fmt.Errorf("user not found: id=%s username=%s", idStr, username)
 }
 
-// ProcessFollowEvent inserts a row into the follows table
-// ON CONFLICT DO NOTHING makes duplicate follows a safe no-op.
-func ProcessFollowEvent(payload FollowEventPayload) error {
+// ProcessFollowEvent follows an account, or — when it is private and the
+// follower is not already in — asks to (see follow_requests.go). ON CONFLICT
+// DO NOTHING makes a duplicate follow or request a safe no-op; Created says
+// whether this call was the one that made the row.
+func ProcessFollowEvent(payload FollowEventPayload) (followOutcome, error) {
 	followerID, err := resolveUserID(payload.FollowerID, payload.FollowerUsername)
 	if err != nil {
-		return fmt.Errorf("follower '%s' not found", payload.FollowerUsername)
+		return followOutcome{}, fmt.Errorf("follower '%s' not found", payload.FollowerUsername)
 	}
 	followingID, err := resolveUserID(payload.FollowingID, payload.FollowingUsername)
 	if err != nil {
-		return fmt.Errorf("user to follow '%s' not found", payload.FollowingUsername)
+		return followOutcome{}, fmt.Errorf("user to follow '%s' not found", payload.FollowingUsername)
+	}
+	out := followOutcome{
+		Status:     followStatusFollowing,
+		FollowerID: strconv.Itoa(followerID),
+		TargetID:   strconv.Itoa(followingID),
 	}
 
-	_, err = db.Exec(
+	var visibility string
+	if err := db.QueryRow(
+		`SELECT COALESCE(visibility, 'public') FROM users WHERE id = $1`, followingID,
+	).Scan(&visibility); err != nil {
+		return followOutcome{}, err
+	}
+	if visibility == visibilityPrivate && followerID != followingID {
+		var following bool
+		if err := db.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM follows WHERE follower_id = $1 AND following_id = $2)`,
+			followerID, followingID,
+		).Scan(&following); err != nil {
+			return followOutcome{}, err
+		}
+		if following {
+			return out, nil
+		}
+		res, err := db.Exec(
+			`INSERT INTO follow_requests (requester_id, target_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			followerID, followingID,
+		)
+		if err != nil {
+			return followOutcome{}, err
+		}
+		out.Status = followStatusRequested
+		n, _ := res.RowsAffected()
+		out.Created = n > 0
+		return out, nil
+	}
+
+	res, err := db.Exec(
 		`INSERT INTO follows (follower_id, following_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
 		followerID, followingID,
 	)
-	return err
+	if err != nil {
+		return followOutcome{}, err
+	}
+	n, _ := res.RowsAffected()
+	out.Created = n > 0
+	return out, nil
 }
 
-// ProcessUnfollowEvent removes a row from the follows table.
+// ProcessUnfollowEvent removes a row from the follows table, and withdraws a
+// follow request still waiting on a private account.
 func ProcessUnfollowEvent(payload UnfollowEventPayload) error {
 	unfollowerID, err := resolveUserID(payload.UnfollowerID, payload.UnfollowerUsername)
 	if err != nil {
@@ -58,9 +101,15 @@ func ProcessUnfollowEvent(payload UnfollowEventPayload) error {
 		return fmt.Errorf("user to unfollow '%s' not found", payload.UnfollowedUsername)
 	}
 
-	_, err = db.Exec(
+	if _, err := db.Exec(
 		`DELETE FROM follows WHERE follower_id = $1 AND following_id = $2`,
 		unfollowerID, unfollowedID,
+	); err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`DELETE FROM follow_requests WHERE requester_id = $1 AND target_id = $2`,
+		unfollowerID, unfollowedID,
 	)
 	return err
 }
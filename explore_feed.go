@@ -109,9 +109,11 @@ func ExploreFeedHandler(w http.ResponseWriter, r *http.Request) {
 	recent := fetchCandidates(userID, candidateLimit*3/10)
 
 	// Merge + dedup. Trending leads — freshness is what users want here.
+	// Which source each item came from is kept for "why am I seeing this?".
 	seen := make(map[string]bool, candidateLimit)
+	sourceOf := make(map[string]string, candidateLimit)
 	candidates := make([]HomeFeedItem, 0, candidateLimit)
-	for _, src := range [][]HomeFeedItem{trending, recent} {
+	for i, src := range [][]HomeFeedItem{trending, recent} {
 		for _, it := range src {
 			id := getItemID(it)
 			if id == "" {
@@ -122,6 +124,7 @@ func ExploreFeedHandler(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			seen[key] = true
+			sourceOf[key] = []string{"trendingRealtime", "recency"}[i]
 			candidates = append(candidates, it)
 		}
 	}
@@ -130,6 +133,13 @@ func ExploreFeedHandler(w http.ResponseWriter, r *http.Request) {
 		// fetchCandidates which has its own ladder.
 		candidates = fetchCandidates(userID, candidateLimit)
 	}
+	pooled := make(map[string]bool, len(candidates))
+	for _, it := range candidates {
+		pooled[it.Type+":"+getItemID(it)] = true
+	}
+	candidates = dropModeratedItems(candidates)
+	candidates = dropPrivateItems(candidates, newPrivacyViewer(userID, nil))
+	candidates = dropMutedItems(candidates, loadContentPrefs(userID))
 
 	// Build interacted set + warm signal caches (still needed for negative
 	// signals like blocks/reports — explore must respect those even when
@@ -266,6 +276,17 @@ func ExploreFeedHandler(w http.ResponseWriter, r *http.Request) {
 		if refresh && page == 1 {
 			go savePrevRefreshTops(userID, items)
 		}
+		// The record GET /feed/explain reads. Explore's score is not about
+		// the user, so what explains an item is its source — or, for the
+		// wildcard from outside the pool, its slot. See feed_explain.go.
+		for _, it := range items {
+			key := it.Type + ":" + getItemID(it)
+			slot := ""
+			if !pooled[key] {
+				slot = slotSurprise
+			}
+			go stashServedExplanation(userID, it, sourceOf[key], slot)
+		}
 	}
 
 	// Shared enrichment choke point — same as For You / Following.
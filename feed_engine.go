@@ -1048,6 +1048,9 @@ func updateSessionFromEvent(event FeedEvent) {
 	// full watch refills, a partial view costs a little, a skip costs more, and
 	// taps (like/share) are pure refills on top.
 
+	// Outcome for the ranking log, if this item was on a sampled page.
+	go recordRankingOutcome(event)
+
 	// Tier 3.11: feed terminal outcome events into the online LTR model so it
 	// learns which breakdown features correlate with completions for this
 	// cohort. ltrObserveEvent is a no-op if no breakdown was stashed.
@@ -3389,22 +3392,36 @@ func scoreForUser(cs *ContentScore, profile *UserProfile, session *SessionState,
 	}
 
 	// ── BASE SCORE (cohort-weighted, experiment-scaled) ──
-	baseScore := social*wsel("wSocial", wSocial)*socialWeightMult*cw.Social +
-		freshness*wsel("wFreshness", wFreshness)*cw.Freshness +
-		energyFit*wsel("wEnergyFit", wEnergyFit)*cw.EnergyFit +
-		relevance*wsel("wRelevance", wRelevance)*cw.Relevance +
-		quality*wsel("wQuality", wQuality)*cw.Quality +
-		novelty*wsel("wNovelty", wNovelty)*cw.Novelty +
-		// tie-strength is a social-graph signal, so SocialDrive scales it too (the
-		// socialWeightMult comment promises BOTH social and tie-strength; it was
-		// previously applied to social only).
-		tieBoost*cw.Tie*socialWeightMult +
-		creatorAffinityBoost*cw.Affinity +
-		// dwellBoost is a precomputed-intent signal like tie/affinity, so it's
-		// cohort-weighted (reusing cw.Affinity) instead of leaking in un-gated —
-		// e.g. cold_start, where cw.Affinity=0, no longer gets the full boost.
-		dwellBoost*cw.Affinity +
-		searchTerm
+	// Each weighted term is also kept in the breakdown as what it actually
+	// added (the *Term keys), not just as the raw feature, so the ranking
+	// log can replay a reweighting of any one of them offline. See
+	// ranking_log.go.
+	socialTerm := social * wsel("wSocial", wSocial) * socialWeightMult * cw.Social
+	freshnessTerm := freshness * wsel("wFreshness", wFreshness) * cw.Freshness
+	energyFitTerm := energyFit * wsel("wEnergyFit", wEnergyFit) * cw.EnergyFit
+	relevanceTerm := relevance * wsel("wRelevance", wRelevance) * cw.Relevance
+	qualityTerm := quality * wsel("wQuality", wQuality) * cw.Quality
+	noveltyTerm := novelty * wsel("wNovelty", wNovelty) * cw.Novelty
+	// tie-strength is a social-graph signal, so SocialDrive scales it too (the
+	// socialWeightMult comment promises BOTH social and tie-strength; it was
+	// previously applied to social only).
+	tieTerm := tieBoost * cw.Tie * socialWeightMult
+	affinityTerm := creatorAffinityBoost * cw.Affinity
+	// dwellBoost is a precomputed-intent signal like tie/affinity, so it's
+	// cohort-weighted (reusing cw.Affinity) instead of leaking in un-gated —
+	// e.g. cold_start, where cw.Affinity=0, no longer gets the full boost.
+	dwellTerm := dwellBoost * cw.Affinity
+	baseScore := socialTerm + freshnessTerm + energyFitTerm + relevanceTerm + qualityTerm + noveltyTerm +
+		tieTerm + affinityTerm + dwellTerm + searchTerm
+	breakdown["socialTerm"] = socialTerm
+	breakdown["freshnessTerm"] = freshnessTerm
+	breakdown["energyFitTerm"] = energyFitTerm
+	breakdown["relevanceTerm"] = relevanceTerm
+	breakdown["qualityTerm"] = qualityTerm
+	breakdown["noveltyTerm"] = noveltyTerm
+	breakdown["tieTerm"] = tieTerm
+	breakdown["affinityTerm"] = affinityTerm
+	breakdown["dwellTerm"] = dwellTerm
 
 	// ── EGO BOOST (conditional) ──
 	// Validating content for ego-sensitive users. Gated ONLY on EgoSensitivity,
@@ -3994,8 +4011,10 @@ func scoreForUser(cs *ContentScore, profile *UserProfile, session *SessionState,
 	// Scaled by the same earned authority as the LTR delta, because it is the
 	// same model read a second way — a calibrated reading of a guess that has
 	// not earned its say is still a guess that has not earned its say.
+	// calibrateServedLogit reads the online Platt fit unless an offline
+	// artifact has replaced the online heads (offline_models.go).
 	if z, samples, ok := ltrRawLogitWithSamples(cohort, breakdown); ok {
-		p := plattCalibrate(z)
+		p := calibrateServedLogit(z)
 		// Centre around 0.5 so p≈0.5 contributes nothing, p≈1 adds ~+0.15 at a
 		// gain of 1, more once the model has earned it.
 		calibBonus := (p - 0.5) * 0.30 * learnedGain(cohort, samples, ltrWarmupSamples)
@@ -4071,20 +4090,7 @@ func scoreForUser(cs *ContentScore, profile *UserProfile, session *SessionState,
 	if session != nil && session.DetectedMood != "" {
 		emotions := getContentEmotions(cs.ContentID, cs.ContentType)
 		if len(emotions) > 0 {
-			moodBonus := moodTransitionBonus(session.DetectedMood, emotions)
-			// MoodVolatility consumption: a moody user (high engagement variance
-			// across sessions) benefits more from mood-aware sequencing, so amplify
-			// the mood-transition signal for them and damp it for steady users.
-			// Bounded 0.85x..1.15x. (This dim was computed+persisted but unused.)
-			moodBonus *= 0.85 + 0.30*profile.MoodVolatility
-			// Re-clamp to the declared ceiling — the 1.15x amplification can push a
-			// maxed ±moodTransitionMaxBonus past the bound it was clamped to inside
-			// moodTransitionBonus.
-			if moodBonus > moodTransitionMaxBonus {
-				moodBonus = moodTransitionMaxBonus
-			} else if moodBonus < -moodTransitionMaxBonus {
-				moodBonus = -moodTransitionMaxBonus
-			}
+			moodBonus := scaleMoodBonusByVolatility(moodTransitionBonus(session.DetectedMood, emotions), profile.MoodVolatility)
 			if moodBonus != 0 {
 				breakdown["moodTransitionBonus"] = moodBonus
 				finalScore += moodBonus
@@ -4149,7 +4155,10 @@ func scoreForUser(cs *ContentScore, profile *UserProfile, session *SessionState,
 	// Creator block/unfollow penalty multiplies the whole score (blocked = 0).
 	// Recent-bounce on this exact content zeros it out so we never re-serve
 	// a just-bounced item.
-	negMult := negativeCreatorPenalty(ns, cs.CreatorID) * bouncePenalty(ns, cs.ContentType, cs.ContentID)
+	// A creator on limited distribution rides the same multiplier for anyone
+	// who doesn't follow them (enforcement.go).
+	negMult := negativeCreatorPenalty(ns, cs.CreatorID) * bouncePenalty(ns, cs.ContentType, cs.ContentID) *
+		distributionMult(cs.CreatorID, followingSet)
 	breakdown["negativeMult"] = negMult
 	// Clamp to non-negative BEFORE the multiplicative attenuator — and this is the
 	// LAST flooring op, so it also absorbs a continuity subtraction that dipped
@@ -5148,7 +5157,19 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 		// viewers are cold-start) new content had no route into any For
 		// You page — discovery only happened via Following, a
 		// chicken-and-egg lock. Guarantee the newest uploads a slot.
+		ladder := make(map[string]bool, len(items))
+		for _, it := range items {
+			ladder[it.Type+":"+getItemID(it)] = true
+		}
 		items = injectFreshUploads(userID, items, page)
+		// Nothing a moderator took down, and nothing from a suspended
+		// account — fresh uploads included.
+		items = dropModeratedItems(items)
+		// A brand-new user follows next to nobody, so this is most of the
+		// private content there is. See follow_requests.go.
+		items = dropPrivateItems(items, newPrivacyViewer(userID, nil))
+		// Nor anything they muted. See content_preferences.go.
+		items = dropMutedItems(items, loadContentPrefs(userID))
 
 		// Same payload enrichment every other feed path runs. Without
 		// it, battles reached cold users with no topResponse* fields
@@ -5167,6 +5188,17 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 		// sees the manifest URLs it just filled in.
 		items = applyDeviceFit(items, deviceMax)
 
+		// "Why am I seeing this?" has no score to go on here, only whether an
+		// item came off the popularity ladder or was a fresh upload given a
+		// slot. See feed_explain.go.
+		for _, it := range items {
+			source, slot := "coldStart", ""
+			if !ladder[it.Type+":"+getItemID(it)] {
+				source, slot = "freshUploads", slotFreshBlood
+			}
+			go stashServedExplanation(userID, it, source, slot)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"items":     items,
@@ -5218,6 +5250,12 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 		candidates = fetchCandidates(userID, candidateLimit)
 		candidateSourceMap = nil
 	}
+	// Nothing a moderator took down, and nothing from a suspended account.
+	candidates = dropModeratedItems(candidates)
+	// Nothing from a private account the user doesn't follow.
+	candidates = dropPrivateItems(candidates, newPrivacyViewer(userID, followingSet))
+	// Nothing the user muted. See content_preferences.go.
+	candidates = dropMutedItems(candidates, loadContentPrefs(userID))
 
 	// Batch-load the feed_events aggregates for the WHOLE pool in two
 	// GROUP BY queries — replaces ~2 queries × N candidates inside the
@@ -5380,7 +5418,21 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Step 6.6: Diversity re-rank (MMR) on the top-K so near-duplicates
 	// don't stack next to each other in the feed.
+	//
+	// MMR bakes its penalty into Score; the breakdown keeps what it took off
+	// so an offline replay (cmd/rankeval) can undo it and re-run MMR with
+	// other settings.
+	for i := range scored {
+		if scored[i].ScoreBreakdown != nil {
+			scored[i].ScoreBreakdown["mmrPenalty"] = scored[i].Score
+		}
+	}
 	scored = applyMMRDefault(scored)
+	for i := range scored {
+		if bd := scored[i].ScoreBreakdown; bd != nil {
+			bd["mmrPenalty"] -= scored[i].Score
+		}
+	}
 
 	// (Anti-loop diagnosis moved to Step 5.9, before scoring — see above.)
 
@@ -5461,6 +5513,9 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 	// Step 7.5: Remember the tail of what we just served so the NEXT page's
 	// ranker can apply sequence-awareness penalties against it, AND stash the
 	// score breakdown of each served item so LTR can learn from the outcome.
+	// The page's request id ties the ranking log, the shadow diffs and the
+	// served-version list together, and goes back to the client with the page.
+	requestID, _ := randomHex(8)
 	if len(composed) > 0 {
 		// Seen-filter: record impressions so subsequent pages don't repeat.
 		items := make([]HomeFeedItem, 0, len(composed))
@@ -5485,17 +5540,31 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 			cid := getItemID(it.Item)
 			if it.ScoreBreakdown != nil {
 				cs := getContentScore(cid, it.Item.Type)
-				creatorID := ""
+				creatorID, category := "", ""
 				if cs != nil {
-					creatorID = cs.CreatorID
+					creatorID, category = cs.CreatorID, cs.Category
 				}
 				source := ""
 				if candidateSourceMap != nil {
 					source = candidateSourceMap[it.Item.Type+":"+cid]
 				}
 				go ltrStashBreakdownAll(userID, it.Item.Type, cid, cohort, it.ScoreBreakdown, idx+1, creatorID, source)
+				// And the longer-lived record "why am I seeing this?" reads
+				// (feed_explain.go) — the LTR stash is gone once the item is
+				// watched, which is when people ask.
+				go stashFeedExplanation(userID, it.Item.Type, cid,
+					newFeedExplainRecord(it.ScoreBreakdown, creatorID, category, source, it.SlotType))
 			}
 		}
+		// Sampled pages also go to the ranking log, whole, for offline
+		// evaluation of ranking changes (ranking_log.go, cmd/rankeval).
+		models := currentModelRegistry()
+		logRankedPage(requestID, userID, sessionID, cohort, models.Serving, composed, candidateSourceMap)
+		// A sample of pages is rescored by the shadow model version, if one
+		// is set, and every page is remembered against the version that
+		// served it. See model_registry.go.
+		shadowed := maybeShadowScorePage(requestID, userID, cohort, session, profile, composed, models)
+		go noteModelVersionServed(userID, requestID, models.modelRegistryState, shadowed)
 		// Cross-page session diversity: tally every served category against
 		// this session's hash so the next page can see the distribution and
 		// penalize repeats.
@@ -5692,6 +5761,9 @@ func SmartFeedHandler(w http.ResponseWriter, r *http.Request) {
 		"hasMore":      hasMore,
 		"sessionHooks": sessionHooks,
 	}
+	if requestID != "" {
+		response["requestId"] = requestID
+	}
 	if debug {
 		response["profile"] = profile
 		response["session"] = session
@@ -5776,6 +5848,14 @@ func FollowingFeedV2Handler(w http.ResponseWriter, r *http.Request) {
 
 	var items []HomeFeedItem
 
+	// What the user muted comes out in the query, not after it: this feed
+	// paginates over the rows the query returns, so dropping some afterwards
+	// would shift every later page. See content_preferences.go.
+	muteClause, muteArgs := loadContentPrefs(userID).challengeSQLFilter("c", 3)
+	// Likewise what a moderator took down, and everything from a suspended
+	// or deactivated account.
+	modClause, modArgs := moderationChallengeSQLFilter("c", 3+len(muteArgs))
+
 	// Challenges from followed creators
 	cRows, err := db.Query(`
 		SELECT c.id, c.creator_id, u.username, u.league, c.video_url,
@@ -5789,9 +5869,9 @@ func FollowingFeedV2Handler(w http.ResponseWriter, r *http.Request) {
 			ON cl.challenge_id = c.id
 		WHERE c.visibility = 'arena'
 		AND c.creator_id IN (SELECT following_id FROM follows WHERE follower_id = CAST($1 AS INT))
-		AND c.created_at > NOW() - INTERVAL '14 days'
+		AND c.created_at > NOW() - INTERVAL '14 days'`+muteClause+modClause+`
 		ORDER BY c.created_at DESC
-		LIMIT $2`, userID, fetch)
+		LIMIT $2`, append(append([]interface{}{userID, fetch}, muteArgs...), modArgs...)...)
 	if err == nil {
 		defer cRows.Close()
 		for cRows.Next() {
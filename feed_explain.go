@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ════════════════════════════════════════════════════════════════════════════════
+// "WHY AM I SEEING THIS?" — feed item explanations
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// WHY:
+// scoreForUser already knows why every item ranked where it did — the score
+// breakdown carries each term — and the candidate sources and slot composer
+// know where it came from and what job it is doing on the page. None of that
+// ever reached the user. GET /feed/explain?contentId= turns it into two or
+// three plain sentences ("Because you follow @maya", "Popular in comedy
+// right now", "Similar to videos you finished"), and each sentence carries
+// the action that would change it: show less from this creator, or show
+// less of this category.
+//
+// WHAT IS KEPT:
+// The LTR stash (ltrStashBreakdownAll) is not enough on its own: it lives 30
+// minutes and is deleted the moment the item gets a terminal event, which is
+// exactly when someone who just watched a video asks why. So Step 7.5 of the
+// feed handler writes a second, smaller record per served item — only the
+// breakdown terms that can become a sentence, plus creator, category, source
+// and slot — under feed:why:{user}:{type}:{id} for feedExplainTTL. Nothing
+// is recomputed at explain time; an item whose record has expired gets a
+// 404 rather than a made-up reason.
+//
+// Explore and the cold-start feed don't rank with scoreForUser, so there is
+// no breakdown worth keeping; they write a record from where each item came
+// from and the slot it filled (stashServedExplanation). Without one, every
+// item they served answered 404.
+//
+// TURNING TERMS INTO REASONS:
+// Every reason is weighted by what its terms actually added to the score
+// (the cohort-weighted term where one exists, the raw bonus otherwise), and
+// the top feedExplainMaxReasons above feedExplainMinContribution are shown.
+// A source or slot on its own (the item came from the trending lane, or was
+// placed as discovery) counts as feedExplainSourceWeight, so it surfaces
+// when nothing in the score stood out and loses to anything that did.
+//
+// ACTING ON A REASON:
+// POST /feed/explain/feedback {contentId, contentType, action} with
+//   show_less_creator  — the creator gets the unfollow soft penalty
+//                        (MarkShowLessCreator) and the item is recorded as a
+//                        not_interested event, which runs the usual session,
+//                        embedding and profile-mining path
+//                        (applyNegativeFeedbackToProfile) for it.
+//   show_less_category — CategoryAffinity for the category is nudged down
+//                        through applyNegativeFeedbackToProfile directly,
+//                        with nothing else about the item touched, and the
+//                        not_interested row is still written so the
+//                        analytics rebuild agrees with the nudge.
+// ════════════════════════════════════════════════════════════════════════════════
+
+const (
+	feedExplainTTL             = 6 * time.Hour
+	feedExplainMaxReasons      = 3
+	feedExplainMinContribution = 0.02
+	feedExplainSourceWeight    = 0.05
+
+	feedActionShowLessCreator  = "show_less_creator"
+	feedActionShowLessCategory = "show_less_category"
+)
+
+// feedExplainTerms are the breakdown keys explainFeedItem reads. Everything
+// else in the breakdown (penalties, multipliers, learned corrections) is
+// either not a reason or not one a person could act on, and isn't stored.
+var feedExplainTerms = []string{
+	"social", "socialTerm", "relevance", "relevanceTerm",
+	"creatorAffinityBoost", "profileVisitBonus", "completeBonus",
+	"loopBonus", "collabBonus", "embedBonus", "trendingBonus",
+	"searchBoost", "emotionBonus", "coldContentBonus",
+}
+
+// feedExplainRecord is what Step 7.5 keeps for each served item.
+type feedExplainRecord struct {
+	Terms     map[string]float64 `json:"t,omitempty"`
+	CreatorID string             `json:"cr,omitempty"`
+	Category  string             `json:"cat,omitempty"`
+	Source    string             `json:"s,omitempty"`
+	Slot      string             `json:"sl,omitempty"`
+}
+
+// FeedReason is one sentence of an explanation.
+type FeedReason struct {
+	Code    string   `json:"code"`
+	Text    string   `json:"text"`
+	Actions []string `json:"actions,omitempty"`
+	weight  float64
+}
+
+// FeedExplainAction is one "show less" control offered with an explanation.
+type FeedExplainAction struct {
+	Action string `json:"action"`
+	Label  string `json:"label"`
+}
+
+func feedExplainKey(userID, contentType, contentID string) string {
+	return fmt.Sprintf("feed:why:%s:%s:%s", userID, contentType, contentID)
+}
+
+// newFeedExplainRecord keeps the explainable, positive terms of a breakdown.
+func newFeedExplainRecord(breakdown map[string]float64, creatorID, category, source, slot string) feedExplainRecord {
+	rec := feedExplainRecord{CreatorID: creatorID, Category: category, Source: source, Slot: slot}
+	for _, k := range feedExplainTerms {
+		if v := breakdown[k]; v > 0 {
+			if rec.Terms == nil {
+				rec.Terms = make(map[string]float64, len(feedExplainTerms))
+			}
+			rec.Terms[k] = v
+		}
+	}
+	return rec
+}
+
+// stashFeedExplanation writes the explain record for one served item.
+// Best-effort, like the LTR stash next to it.
+func stashFeedExplanation(userID, contentType, contentID string, rec feedExplainRecord) {
+	if rdb == nil || userID == "" || contentID == "" {
+		return
+	}
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	_ = rdb.Set(rctx, feedExplainKey(userID, contentType, contentID), js, feedExplainTTL).Err()
+}
+
+// stashServedExplanation is stashFeedExplanation for a feed ranked without
+// scoreForUser: creator and category come off the item itself.
+func stashServedExplanation(userID string, it HomeFeedItem, source, slot string) {
+	category := ""
+	switch {
+	case it.Challenge != nil:
+		category = it.Challenge.Category
+	case it.Post != nil:
+		category = it.Post.Category
+	}
+	stashFeedExplanation(userID, it.Type, getItemID(it),
+		newFeedExplainRecord(nil, getItemCreatorID(it), category, source, slot))
+}
+
+func loadFeedExplanation(userID, contentType, contentID string) (feedExplainRecord, bool) {
+	var rec feedExplainRecord
+	if rdb == nil {
+		return rec, false
+	}
+	s, err := rdb.Get(rctx, feedExplainKey(userID, contentType, contentID)).Result()
+	if err != nil || s == "" {
+		return rec, false
+	}
+	if json.Unmarshal([]byte(s), &rec) != nil {
+		return rec, false
+	}
+	return rec, true
+}
+
+// explainFeedItem turns a record into ranked reasons. creatorName is the
+// creator's username, or "" when it couldn't be looked up.
+func explainFeedItem(rec feedExplainRecord, creatorName string) []FeedReason {
+	t := rec.Terms
+	creator := "this creator"
+	if creatorName != "" {
+		creator = "@" + creatorName
+	}
+	creatorActions := []string(nil)
+	if rec.CreatorID != "" {
+		creatorActions = []string{feedActionShowLessCreator}
+	}
+	categoryActions := []string(nil)
+	if rec.Category != "" {
+		categoryActions = []string{feedActionShowLessCategory}
+	}
+	inCategory := func(withCat, without string) string {
+		if rec.Category == "" {
+			return without
+		}
+		return fmt.Sprintf(withCat, rec.Category)
+	}
+	fromSource := func(sources ...string) float64 {
+		for _, s := range sources {
+			if rec.Source == s {
+				return feedExplainSourceWeight
+			}
+		}
+		return 0
+	}
+	// The social term is 0.7 for a follow and 0.3 for a friend-of-friend,
+	// plus 0.2 for a preferred creator; the cohort-weighted term is what it
+	// added to the score.
+	socialWeight := t["socialTerm"]
+	if socialWeight == 0 {
+		socialWeight = t["social"] * wSocial
+	}
+	relevanceWeight := t["relevanceTerm"]
+	if relevanceWeight == 0 {
+		relevanceWeight = t["relevance"] * wRelevance
+	}
+
+	var out []FeedReason
+	add := func(code, text string, actions []string, weight float64) {
+		if weight >= feedExplainMinContribution {
+			out = append(out, FeedReason{Code: code, Text: text, Actions: actions, weight: weight})
+		}
+	}
+	switch social := t["social"]; {
+	case social >= 0.7:
+		add("follow", "Because you follow "+creator, creatorActions, math.Max(socialWeight, fromSource("follow")))
+	case social >= 0.3:
+		add("followed_by_following", "Followed by people you follow", creatorActions, socialWeight)
+	default:
+		// Below 0.3 the social term is only the preferred-creator bump,
+		// which says the same thing as the affinity terms.
+		add("creator_affinity", "Because you often watch "+creator, creatorActions,
+			socialWeight+t["creatorAffinityBoost"]+t["profileVisitBonus"])
+	}
+	add("finished_creator", "You've finished videos from "+creator+" before", creatorActions, t["completeBonus"])
+	add("similar", "Similar to videos you finished", nil, t["embedBonus"]+fromSource("embedding", "coocurrence"))
+	add("similar_users", "People with similar taste liked this", nil, t["collabBonus"]+fromSource("collab"))
+	add("trending", inCategory("Popular in %s right now", "Popular right now"), categoryActions,
+		t["trendingBonus"]+fromSource("trending", "trendingRealtime")+slotWeight(rec.Slot, slotTrending))
+	add("category", inCategory("You watch a lot of %s", "Matches what you usually watch"), categoryActions,
+		relevanceWeight+t["loopBonus"])
+	add("search", "Matches something you searched for", categoryActions, t["searchBoost"]+fromSource("searchAffinity"))
+	add("mood", "Fits what you've been watching this session", nil, t["emotionBonus"])
+	add("new", "A new video looking for its first viewers", nil,
+		t["coldContentBonus"]+fromSource("audition")+slotWeight(rec.Slot, slotFreshBlood))
+	add("tournament", "A live tournament matchup", nil, fromSource("tournament"))
+	add("discovery", "Something different to try", categoryActions, slotWeight(rec.Slot, slotDiscovery, slotSurprise))
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].weight > out[j].weight })
+	reasons := out
+	if len(reasons) > feedExplainMaxReasons {
+		reasons = reasons[:feedExplainMaxReasons]
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, FeedReason{Code: "popular", Text: "Popular with people on the app", Actions: categoryActions})
+	}
+	return reasons
+}
+
+func slotWeight(slot string, slots ...string) float64 {
+	for _, s := range slots {
+		if slot == s {
+			return feedExplainSourceWeight
+		}
+	}
+	return 0
+}
+
+// feedExplainActions lists the show-less controls an explanation offers.
+func feedExplainActions(rec feedExplainRecord, creatorName string) []FeedExplainAction {
+	var actions []FeedExplainAction
+	if rec.CreatorID != "" {
+		label := "Show less from this creator"
+		if creatorName != "" {
+			label = "Show less from @" + creatorName
+		}
+		actions = append(actions, FeedExplainAction{Action: feedActionShowLessCreator, Label: label})
+	}
+	if rec.Category != "" {
+		actions = append(actions, FeedExplainAction{Action: feedActionShowLessCategory, Label: "Show less " + rec.Category})
+	}
+	return actions
+}
+
+func usernameByID(userID string) string {
+	if db == nil || userID == "" {
+		return ""
+	}
+	var name string
+	if err := db.QueryRow(`SELECT username FROM users WHERE id = CAST($1 AS INT)`, userID).Scan(&name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// FeedExplainHandler answers "why am I seeing this?" for an item the caller
+// was served recently.
+// GET /api/v1/feed/explain?contentId=&contentType=
+//
+// contentType may be omitted; challenges are tried before posts.
+func FeedExplainHandler(w http.ResponseWriter, r *http.Request) {
+	userID := authUserID(r)
+	contentID := strings.TrimSpace(r.URL.Query().Get("contentId"))
+	if contentID == "" {
+		http.Error(w, "contentId is required", http.StatusBadRequest)
+		return
+	}
+	types := []string{"challenge", "post"}
+	if ct := strings.TrimSpace(r.URL.Query().Get("contentType")); ct != "" {
+		types = []string{ct}
+	}
+	var rec feedExplainRecord
+	contentType, found := "", false
+	for _, ct := range types {
+		if rec, found = loadFeedExplanation(userID, ct, contentID); found {
+			contentType = ct
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "No explanation for this item — it wasn't served to you recently", http.StatusNotFound)
+		return
+	}
+	creatorName := usernameByID(rec.CreatorID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"contentId":   contentID,
+		"contentType": contentType,
+		"reasons":     explainFeedItem(rec, creatorName),
+		"actions":     feedExplainActions(rec, creatorName),
+	})
+}
+
+// showLessCategory nudges one category down in the profile and nothing
+// else: no emotions are passed, and the energy the nudge would pull away
+// from is pinned to the user's own preference so it stays where it is.
+func showLessCategory(profile *UserProfile, category string) {
+	if profile == nil || category == "" {
+		return
+	}
+	cs := &ContentScore{Category: category, EnergyLevel: profile.EnergyPreference}
+	applyNegativeFeedbackToProfile(profile, "not_interested", cs, nil)
+}
+
+// FeedExplainFeedbackHandler applies a show-less action from an
+// explanation.
+// POST /api/v1/feed/explain/feedback body:{ contentId, contentType, action }
+func FeedExplainFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ContentID   string `json:"contentId"`
+		ContentType string `json:"contentType"`
+		Action      string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	if payload.ContentType == "" {
+		payload.ContentType = "challenge"
+	}
+	if payload.ContentID == "" || userID == "" {
+		http.Error(w, "contentId is required", http.StatusBadRequest)
+		return
+	}
+	if payload.Action != feedActionShowLessCreator && payload.Action != feedActionShowLessCategory {
+		http.Error(w, "action must be show_less_creator or show_less_category", http.StatusBadRequest)
+		return
+	}
+	// Same budget as a dislike: it's the same kind of tap on the same feed.
+	if !allowAction(userID, "dislike") {
+		writeRateLimited(w, "dislike")
+		return
+	}
+	cs := getContentScore(payload.ContentID, payload.ContentType)
+	if cs == nil {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
+
+	event := FeedEvent{
+		UserID:      userID,
+		ContentID:   payload.ContentID,
+		ContentType: payload.ContentType,
+		EventType:   "not_interested",
+		SessionID:   fmt.Sprintf("%s_%d", userID, time.Now().Unix()/1800),
+		Metadata:    map[string]interface{}{"via": "explain", "action": payload.Action},
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	switch payload.Action {
+	case feedActionShowLessCreator:
+		if cs.CreatorID == "" || cs.CreatorID == userID {
+			http.Error(w, "This item has no creator to show less of", http.StatusBadRequest)
+			return
+		}
+		MarkShowLessCreator(userID, cs.CreatorID)
+		go updateSessionFromEvent(event)
+		go applyEmbeddingFromEvent(event)
+	case feedActionShowLessCategory:
+		if cs.Category == "" {
+			http.Error(w, "This item has no category to show less of", http.StatusBadRequest)
+			return
+		}
+		// Not through updateSessionFromEvent: that mines the whole item —
+		// its emotions and energy too — and the user only said the category.
+		go func(category string) {
+			unlock := profileKeyLocks.lock(userID)
+			defer unlock()
+			profile, err := loadUserProfile(userID)
+			if err == nil && profile != nil {
+				showLessCategory(profile, category)
+				bumpNegativeProfileMineEpoch()
+				saveUserProfile(profile)
+			}
+		}(strings.ToLower(cs.Category))
+	}
+	go func() {
+		if err := recordFeedEvent(event); err != nil {
+			log.Printf("feed explain: failed to record feed event: %v", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":          true,
+		"action":      payload.Action,
+		"contentId":   payload.ContentID,
+		"contentType": payload.ContentType,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func reasonCodes(rs []FeedReason) []string {
+	codes := make([]string, len(rs))
+	for i, r := range rs {
+		codes[i] = r.Code
+	}
+	return codes
+}
+
+func TestExplainFeedItemRanksByContribution(t *testing.T) {
+	rec := newFeedExplainRecord(map[string]float64{
+		"social":        0.7,
+		"socialTerm":    0.21,
+		"trendingBonus": 0.09,
+		"embedBonus":    0.04,
+		"relevance":     0.05, // 0.05 * wRelevance is under the floor
+		"negativeMult":  1,    // never a reason, never stored
+		"freshness":     0.8,
+	}, "42", "comedy", "trending", slotSocial)
+	if _, ok := rec.Terms["negativeMult"]; ok {
+		t.Fatalf("record kept a term that can't be explained: %v", rec.Terms)
+	}
+
+	got := explainFeedItem(rec, "maya")
+	want := []string{"follow", "trending", "similar"}
+	if len(got) != len(want) {
+		t.Fatalf("reasons = %v, want %v", reasonCodes(got), want)
+	}
+	for i := range want {
+		if got[i].Code != want[i] {
+			t.Fatalf("reasons = %v, want %v", reasonCodes(got), want)
+		}
+	}
+	if got[0].Text != "Because you follow @maya" || got[0].Actions[0] != feedActionShowLessCreator {
+		t.Errorf("follow reason = %+v", got[0])
+	}
+	// Trending bonus plus the trending lane.
+	if got[1].Text != "Popular in comedy right now" || got[1].Actions[0] != feedActionShowLessCategory {
+		t.Errorf("trending reason = %+v", got[1])
+	}
+}
+
+func TestExplainFeedItemFallsBack(t *testing.T) {
+	// Nothing in the score stood out: the slot is the reason.
+	got := explainFeedItem(newFeedExplainRecord(nil, "", "", "recency", slotDiscovery), "")
+	if len(got) != 1 || got[0].Code != "discovery" {
+		t.Fatalf("reasons = %v, want [discovery]", reasonCodes(got))
+	}
+	// Not even that.
+	got = explainFeedItem(newFeedExplainRecord(nil, "", "", "recency", slotHook), "")
+	if len(got) != 1 || got[0].Code != "popular" {
+		t.Fatalf("reasons = %v, want [popular]", reasonCodes(got))
+	}
+}
+
+func TestFeedExplainHandler(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	stashFeedExplanation("7", "challenge", "99", newFeedExplainRecord(
+		map[string]float64{"completeBonus": 0.15}, "42", "dance", "follow", slotHook))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT username FROM users WHERE id = CAST($1 AS INT)`)).
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("maya"))
+
+	req := withAuth(httptest.NewRequest("GET", "/api/v1/feed/explain?contentId=99", nil), "7", "sam")
+	rec := httptest.NewRecorder()
+	FeedExplainHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		ContentType string              `json:"contentType"`
+		Reasons     []FeedReason        `json:"reasons"`
+		Actions     []FeedExplainAction `json:"actions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ContentType != "challenge" || len(body.Reasons) == 0 || body.Reasons[0].Text != "You've finished videos from @maya before" {
+		t.Errorf("body = %+v", body)
+	}
+	if len(body.Actions) != 2 || body.Actions[0].Label != "Show less from @maya" || body.Actions[1].Label != "Show less dance" {
+		t.Errorf("actions = %+v", body.Actions)
+	}
+
+	// Someone else's served item isn't explained to this user.
+	rec = httptest.NewRecorder()
+	FeedExplainHandler(rec, withAuth(httptest.NewRequest("GET", "/api/v1/feed/explain?contentId=99", nil), "8", "kim"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("other user: status = %d, want 404", rec.Code)
+	}
+}
+
+// Explore and cold-start pages have no breakdown; the item, its source and
+// its slot are enough for a record.
+func TestStashServedExplanation(t *testing.T) {
+	resetRedis(t)
+	it := HomeFeedItem{Type: "challenge", Challenge: &Challenge{ID: "5", CreatorID: "42", Category: "dance"}}
+	stashServedExplanation("7", it, "freshUploads", slotFreshBlood)
+	stashServedExplanation("7", HomeFeedItem{Type: "suggested_accounts"}, "coldStart", "")
+
+	rec, ok := loadFeedExplanation("7", "challenge", "5")
+	if !ok || rec.CreatorID != "42" || rec.Category != "dance" || rec.Terms != nil {
+		t.Fatalf("record = %+v, found %v", rec, ok)
+	}
+	if got := explainFeedItem(rec, ""); len(got) != 1 || got[0].Code != "new" {
+		t.Errorf("reasons = %v, want [new]", reasonCodes(got))
+	}
+	if keys, _ := rdb.Keys(rctx, "feed:why:*").Result(); len(keys) != 1 {
+		t.Errorf("stashed %v, want only the challenge", keys)
+	}
+}
+
+func TestShowLessCategoryOnlyMovesTheCategory(t *testing.T) {
+	p := &UserProfile{
+		CategoryAffinity:  map[string]float64{"comedy": 0.6, "dance": 0.4},
+		EmotionPreference: map[string]float64{"happy": 0.5},
+		EnergyPreference:  0.7,
+	}
+	showLessCategory(p, "comedy")
+	if p.CategoryAffinity["comedy"] >= 0.6 {
+		t.Errorf("comedy affinity = %v, want below 0.6", p.CategoryAffinity["comedy"])
+	}
+	if p.CategoryAffinity["dance"] != 0.4 || p.EmotionPreference["happy"] != 0.5 || p.EnergyPreference != 0.7 {
+		t.Errorf("show less comedy touched more than comedy: %+v", p)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+// follow_requests.go — private accounts.
+//
+// users.visibility has been "public" or "friends" for a long time, and
+// nothing read it: following anyone was instant and everything anyone posted
+// went everywhere. This file is what makes "private" mean something.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// FOLLOWING A PRIVATE ACCOUNT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Following a public account is what it always was: a row in follows. On a
+// private account the same POST /follow writes a follow_requests row instead
+// and answers "requested". The owner sees it in their inbox
+// (GET /follow-requests) and approves it — the row moves into follows — or
+// rejects it, which deletes it without telling the requester. Unfollowing
+// withdraws a request still pending. Making the account public approves
+// everything pending, since there is nothing left to ask permission for.
+//
+// follows only ever holds approved followers, so everything that already
+// reads follows — the following feed, friends-only challenges, the follower
+// count — is correct for private accounts without knowing they exist.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT A STRANGER SEES
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Someone who does not follow a private account (and is not its owner) can
+// still find it by name and ask to follow it. What they get is the limited
+// profile — name, league, follower count — and none of the detail: no bio,
+// no record, no ratings, no follow lists. Its content is not in their feeds
+// or their search results, and it is never suggested to them.
+//
+// Single-profile reads check the follows table. Lists — feed candidates,
+// search hits — check the privacy snapshot: the set of private account ids,
+// held in memory and reloaded every privacySyncInterval the same way the
+// moderation snapshot is (moderation.go), so filtering a page costs a map
+// lookup per item. A replica that changes an account's visibility updates its
+// own snapshot on the spot; the others catch up within one interval.
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// privacySyncInterval is how often the private-account snapshot is
+	// reloaded, and so how long another replica can lag a visibility change.
+	privacySyncInterval = 15 * time.Second
+	// followRequestPageMax bounds one page of the inbox.
+	followRequestPageMax = 100
+)
+
+// Account visibility values.
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+	// visibilityFriends is the old spelling of private, still accepted from
+	// clients built before it was renamed. Stored as private.
+	visibilityFriends = "friends"
+)
+
+// What a follow did (ProcessFollowEvent).
+const (
+	followStatusFollowing = "following"
+	followStatusRequested = "requested"
+)
+
+var errNoFollowRequest = errors.New("no pending follow request from this user")
+
+// followOutcome is the result of a follow.
+type followOutcome struct {
+	Status     string // followStatusFollowing or followStatusRequested
+	Created    bool   // this call wrote the row; false when it was already there
+	FollowerID string
+	TargetID   string
+}
+
+// FollowRequest is one pending request in an owner's inbox.
+type FollowRequest struct {
+	UserID      string `json:"userId"`
+	Username    string `json:"username"`
+	FullName    string `json:"fullName,omitempty"`
+	League      string `json:"league"`
+	RequestedAt string `json:"requestedAt"`
+}
+
+// normalizeVisibility maps a requested visibility onto what is stored, or ""
+// for a value that is not one.
+func normalizeVisibility(v string) string {
+	switch v {
+	case visibilityPublic:
+		return visibilityPublic
+	case visibilityPrivate, visibilityFriends:
+		return visibilityPrivate
+	}
+	return ""
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// REQUESTS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// approveFollowRequest moves a pending request into follows.
+func approveFollowRequest(targetID, requesterID string) error {
+	tid, err1 := strconv.Atoi(targetID)
+	rid, err2 := strconv.Atoi(requesterID)
+	if err1 != nil || err2 != nil {
+		return errNoFollowRequest
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM follow_requests WHERE requester_id = $1 AND target_id = $2`, rid, tid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNoFollowRequest
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO follows (follower_id, following_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		rid, tid,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rejectFollowRequest deletes a pending request.
+func rejectFollowRequest(targetID, requesterID string) error {
+	tid, err1 := strconv.Atoi(targetID)
+	rid, err2 := strconv.Atoi(requesterID)
+	if err1 != nil || err2 != nil {
+		return errNoFollowRequest
+	}
+	res, err := db.Exec(`DELETE FROM follow_requests WHERE requester_id = $1 AND target_id = $2`, rid, tid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNoFollowRequest
+	}
+	return nil
+}
+
+// approveAllFollowRequests lets everyone waiting on an account in, for when
+// it goes public. Returns how many were approved.
+func approveAllFollowRequests(targetID string) (int64, error) {
+	tid, err := strconv.Atoi(targetID)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO follows (follower_id, following_id)
+		SELECT requester_id, target_id FROM follow_requests WHERE target_id = $1
+		ON CONFLICT DO NOTHING`, tid); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM follow_requests WHERE target_id = $1`, tid)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
+}
+
+// pendingFollowRequests is a page of an owner's inbox, newest first, and the
+// total waiting. Requests from hidden accounts (suspended, or deleted and in
+// their grace period) are left out of the page; they come back with the
+// account.
+func pendingFollowRequests(targetID string, limit, offset int) ([]FollowRequest, int, error) {
+	tid, err := strconv.Atoi(targetID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM follow_requests WHERE target_id = $1`, tid).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`
+		SELECT fr.requester_id, u.username, COALESCE(u.full_name, ''), COALESCE(u.league, 'Bronze'), fr.created_at
+		FROM follow_requests fr JOIN users u ON u.id = fr.requester_id
+		WHERE fr.target_id = $1
+		ORDER BY fr.created_at DESC
+		LIMIT $2 OFFSET $3`, tid, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	out := []FollowRequest{}
+	for rows.Next() {
+		var id int
+		var at time.Time
+		var fr FollowRequest
+		if err := rows.Scan(&id, &fr.Username, &fr.FullName, &fr.League, &at); err != nil {
+			return nil, 0, err
+		}
+		fr.UserID = strconv.Itoa(id)
+		if accountHidden(fr.UserID) {
+			continue
+		}
+		fr.RequestedAt = at.UTC().Format(time.RFC3339)
+		out = append(out, fr)
+	}
+	return out, total, rows.Err()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// WHO MAY SEE WHAT
+// ════════════════════════════════════════════════════════════════════════════════
+
+// canViewAccount reports whether viewerID (empty for a signed-out request)
+// may see the detail and content of owner: it is public, it is theirs, or
+// they are an approved follower. A lookup error answers no.
+func canViewAccount(viewerID string, owner User) bool {
+	if owner.Visibility != visibilityPrivate || viewerID == owner.ID {
+		return true
+	}
+	if viewerID == "" {
+		return false
+	}
+	var following bool
+	if err := db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM follows WHERE follower_id = CAST($1 AS INT) AND following_id = CAST($2 AS INT))`,
+		viewerID, owner.ID,
+	).Scan(&following); err != nil {
+		log.Printf("privacy: checking %s follows %s: %v", viewerID, owner.ID, err)
+		return false
+	}
+	return following
+}
+
+// followRequested reports whether viewerID has a request pending with
+// ownerID, so a limited profile can show "Requested" rather than "Follow".
+func followRequested(viewerID, ownerID string) bool {
+	if viewerID == "" {
+		return false
+	}
+	var pending bool
+	db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM follow_requests WHERE requester_id = CAST($1 AS INT) AND target_id = CAST($2 AS INT))`,
+		viewerID, ownerID,
+	).Scan(&pending)
+	return pending
+}
+
+// limitedProfile is what a private account shows someone who may not see it.
+func limitedProfile(u User) User {
+	return User{
+		ID:         u.ID,
+		Username:   u.Username,
+		FullName:   u.FullName,
+		Followers:  u.Followers,
+		League:     u.League,
+		Visibility: u.Visibility,
+	}
+}
+
+// privacyViewer answers canSee for one viewer across a whole response: a
+// snapshot lookup per owner, and the viewer's follows read at most once, and
+// only if a private account actually turns up.
+type privacyViewer struct {
+	viewerID  string
+	following map[string]bool
+}
+
+// newPrivacyViewer takes the viewer's follows when the caller already has
+// them (the feed does); nil means look them up if needed.
+func newPrivacyViewer(viewerID string, following map[string]bool) *privacyViewer {
+	return &privacyViewer{viewerID: viewerID, following: following}
+}
+
+// canSee reports whether the viewer may see content owned by ownerID.
+func (v *privacyViewer) canSee(ownerID string) bool {
+	if ownerID == "" || ownerID == v.viewerID || !accountPrivate(ownerID) {
+		return true
+	}
+	if v.viewerID == "" {
+		return false
+	}
+	if v.following == nil {
+		v.following = followedAccounts(v.viewerID)
+	}
+	return v.following[ownerID]
+}
+
+// followedAccounts is the set of accounts userID follows. Empty on error,
+// which hides private content rather than showing it.
+func followedAccounts(userID string) map[string]bool {
+	out := map[string]bool{}
+	rows, err := db.Query(
+		`SELECT CAST(following_id AS TEXT) FROM follows WHERE follower_id = CAST($1 AS INT)`, userID)
+	if err != nil {
+		log.Printf("privacy: loading follows of %s: %v", userID, err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// dropPrivateItems removes feed candidates from private accounts the viewer
+// does not follow.
+func dropPrivateItems(items []HomeFeedItem, v *privacyViewer) []HomeFeedItem {
+	if len(currentPrivacy()) == 0 {
+		return items
+	}
+	out := items[:0]
+	for _, it := range items {
+		if (it.Challenge != nil || it.Post != nil) && !v.canSee(getItemCreatorID(it)) {
+			continue
+		}
+		out = append(out, it)
+	}
+	return out
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SNAPSHOT
+// ════════════════════════════════════════════════════════════════════════════════
+
+// privacyStore holds the current set of private account ids
+// (map[string]bool, never mutated once stored).
+var privacyStore atomic.Value
+
+func currentPrivacy() map[string]bool {
+	s, _ := privacyStore.Load().(map[string]bool)
+	return s
+}
+
+// accountPrivate reports whether an account is private, per the snapshot.
+func accountPrivate(userID string) bool {
+	return currentPrivacy()[userID]
+}
+
+// privacyMarkPrivate records a visibility change on this replica.
+func privacyMarkPrivate(userID string, private bool) {
+	old := currentPrivacy()
+	next := make(map[string]bool, len(old)+1)
+	for id := range old {
+		next[id] = true
+	}
+	if private {
+		next[userID] = true
+	} else {
+		delete(next, userID)
+	}
+	privacyStore.Store(next)
+}
+
+// loadPrivacyState replaces the snapshot from the database. On error the
+// previous snapshot stays: a blip must not make private accounts public.
+func loadPrivacyState() {
+	if db == nil {
+		return
+	}
+	rows, err := db.Query(`SELECT id FROM users WHERE visibility = 'private'`)
+	if err != nil {
+		log.Printf("privacy: loading private accounts (keeping previous snapshot): %v", err)
+		return
+	}
+	defer rows.Close()
+	next := map[string]bool{}
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			next[strconv.Itoa(id)] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("privacy: loading private accounts (keeping previous snapshot): %v", err)
+		return
+	}
+	privacyStore.Store(next)
+}
+
+// startPrivacySync loads the snapshot, then keeps it fresh. Called from
+// main() after InitDatabase.
+func startPrivacySync() {
+	loadPrivacyState()
+	go func() {
+		t := time.NewTicker(privacySyncInterval)
+		defer t.Stop()
+		for range t.C {
+			loadPrivacyState()
+		}
+	}()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// ListFollowRequestsHandler — GET /api/v1/follow-requests?page=&limit=
+//
+// The signed-in user's pending requests, newest first.
+func ListFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	uid := authUserID(r)
+	limit := parseIntOrDefault(r.URL.Query().Get("limit"), 30, followRequestPageMax)
+	page := parseIntOrDefault(r.URL.Query().Get("page"), 1, 1_000_000)
+	requests, total, err := pendingFollowRequests(uid, limit, (page-1)*limit)
+	if err != nil {
+		log.Printf("follow requests for %s: %v", uid, err)
+		http.Error(w, "could not load follow requests", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"requests": requests,
+		"total":    total,
+		"page":     page,
+	})
+}
+
+// ApproveFollowRequestHandler — POST /api/v1/follow-requests/{requesterId}/approve
+func ApproveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	uid := authUserID(r)
+	requesterID := mux.Vars(r)["requesterId"]
+	if err := approveFollowRequest(uid, requesterID); err != nil {
+		writeFollowRequestError(w, err)
+		return
+	}
+	go SendFollowAcceptedNotification(uid, requesterID)
+	writeJSON(w, http.StatusOK, map[string]any{"approved": true, "userId": requesterID})
+}
+
+// RejectFollowRequestHandler — POST /api/v1/follow-requests/{requesterId}/reject
+//
+// The requester is not told; to them the request just stays unanswered
+// until they look again.
+func RejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	uid := authUserID(r)
+	requesterID := mux.Vars(r)["requesterId"]
+	if err := rejectFollowRequest(uid, requesterID); err != nil {
+		writeFollowRequestError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rejected": true, "userId": requesterID})
+}
+
+func writeFollowRequestError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoFollowRequest) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("follow request: %v", err)
+	http.Error(w, "could not update the follow request", http.StatusInternalServerError)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withPrivacyState swaps in a private-account snapshot for the duration of a
+// test.
+func withPrivacyState(t *testing.T, private ...string) {
+	t.Helper()
+	prev := currentPrivacy()
+	next := map[string]bool{}
+	for _, id := range private {
+		next[id] = true
+	}
+	privacyStore.Store(next)
+	t.Cleanup(func() {
+		if prev == nil {
+			prev = map[string]bool{}
+		}
+		privacyStore.Store(prev)
+	})
+}
+
+func TestNormalizeVisibility(t *testing.T) {
+	for in, want := range map[string]string{
+		"public": "public", "private": "private", "friends": "private", "": "", "everyone": "",
+	} {
+		if got := normalizeVisibility(in); got != want {
+			t.Errorf("normalizeVisibility(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Following a private account leaves a request, not a follow.
+func TestProcessFollowEventPrivateAccountRequests(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users")).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(visibility, 'public') FROM users")).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"visibility"}).AddRow("private"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM follows")).WithArgs(5, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO follow_requests")).WithArgs(5, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	out, err := ProcessFollowEvent(FollowEventPayload{FollowerID: "5", FollowingID: "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Status != followStatusRequested || !out.Created || out.TargetID != "7" {
+		t.Errorf("outcome = %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApproveFollowRequestWithoutRequest(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM follow_requests")).WithArgs(5, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := approveFollowRequest("7", "5"); !errors.Is(err, errNoFollowRequest) {
+		t.Fatalf("err = %v, want errNoFollowRequest", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCanViewAccount(t *testing.T) {
+	private := User{ID: "7", Visibility: visibilityPrivate}
+	if !canViewAccount("", User{ID: "7", Visibility: visibilityPublic}) {
+		t.Error("public account hidden from a signed-out viewer")
+	}
+	if canViewAccount("", private) {
+		t.Error("private account shown to a signed-out viewer")
+	}
+	if !canViewAccount("7", private) {
+		t.Error("private account hidden from its owner")
+	}
+
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM follows")).WithArgs("5", "7").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	if !canViewAccount("5", private) {
+		t.Error("private account hidden from an approved follower")
+	}
+}
+
+func TestDropPrivateItems(t *testing.T) {
+	withPrivacyState(t, "7")
+	items := func() []HomeFeedItem {
+		return []HomeFeedItem{
+			{Type: "challenge", Challenge: &Challenge{ID: "1", CreatorID: "7"}},
+			{Type: "challenge", Challenge: &Challenge{ID: "2", CreatorID: "8"}},
+		}
+	}
+
+	if got := dropPrivateItems(items(), newPrivacyViewer("5", map[string]bool{"7": true})); len(got) != 2 {
+		t.Errorf("follower got %d items, want 2", len(got))
+	}
+	if got := dropPrivateItems(items(), newPrivacyViewer("7", map[string]bool{})); len(got) != 2 {
+		t.Errorf("owner got %d items, want 2", len(got))
+	}
+	got := dropPrivateItems(items(), newPrivacyViewer("6", map[string]bool{}))
+	if len(got) != 1 || got[0].Challenge.ID != "2" {
+		t.Errorf("stranger got %v, want only the public item", got)
+	}
+}
+
+// A viewer with no follows to hand has them read once, and only when a
+// private account turns up.
+func TestPrivacyViewerLoadsFollowsLazily(t *testing.T) {
+	withPrivacyState(t, "7")
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	pv := newPrivacyViewer("5", nil)
+	if !pv.canSee("8") {
+		t.Fatal("public account hidden")
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT CAST(following_id AS TEXT) FROM follows")).WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"following_id"}).AddRow("7"))
+	if !pv.canSee("7") || !pv.canSee("7") {
+		t.Error("follower can't see the private account")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadPrivacyStateKeepsSnapshotOnError(t *testing.T) {
+	withPrivacyState(t, "7")
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery("FROM users WHERE visibility").WillReturnError(errors.New("connection reset"))
+
+	loadPrivacyState()
+	if !accountPrivate("7") {
+		t.Error("a failed reload made a private account public")
+	}
+}
@@ -0,0 +1,1256 @@
+package main
+
+// group_chat.go — conversations with more than two people.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SHAPE OF IT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Chat was strictly 1:1 — chat_messages is a sender and a receiver — and DMs
+// stay exactly that. A group is its own conversation: a chat_groups row, a
+// member list with roles, and its own group_messages. See
+// migrations/009_group_chats.sql.
+//
+//	owner     one per group. Everything an admin can do, plus changing roles
+//	          and handing the group over. An owner who leaves hands it to the
+//	          longest-standing admin, or failing that the longest-standing
+//	          member; the last one out deletes the group.
+//	admin     adds and removes members (not other admins), mints the invite
+//	          link.
+//	member    talks, drops challenges, votes, leaves.
+//
+// Invites are a link with a random code. Minting a new one replaces the old,
+// which is how a leaked link is revoked. Mute is per member and only changes
+// how their devices treat a message — it still arrives, marked "muted", so an
+// open conversation stays current. Read receipts are a cursor per member (the
+// last message id they have read): "read by" is a count of cursors, not a row
+// per message per reader.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// GROUP BATTLES
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// A message can carry a challenge. The group votes on it through CastVote —
+// the same screened, weighted battle vote as everywhere else, so a squad
+// voting together in its room is held to the rules a squad voting together in
+// the feed is (vote_integrity.go would see the ring either way). What the room
+// adds is the group's own tally next to the battle's: how the people in this
+// conversation voted.
+//
+// Every message, receipt and vote is fanned out to each member's devices
+// through wsDeliverEvent, so it is in their event log and a reconnect replays
+// it (ws_events.go).
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// groupMaxMembers caps a group. Squads are a handful of people; this is
+	// room for a big one without turning a group into a broadcast channel.
+	groupMaxMembers = 50
+	// groupNameMax is the longest group name, matching the column.
+	groupNameMax = 80
+	// groupMessagePage is the default page of the message list.
+	groupMessagePage = 50
+)
+
+// Group roles, most powerful first.
+const (
+	groupRoleOwner  = "owner"
+	groupRoleAdmin  = "admin"
+	groupRoleMember = "member"
+)
+
+var (
+	errGroupNotFound      = errors.New("group not found")
+	errGroupFull          = errors.New("this group is full")
+	errGroupAlreadyMember = errors.New("already a member of this group")
+	errGroupBadInvite     = errors.New("this invite link is no longer valid")
+	errGroupNotAllowed    = errors.New("you don't have permission to do that in this group")
+)
+
+// ════════════════════════════════════════════════════════════════════════════════
+// WIRE TYPES
+// ════════════════════════════════════════════════════════════════════════════════
+
+// ChatGroup is one group in the caller's conversation list.
+type ChatGroup struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	MyRole      string `json:"myRole"`
+	Muted       bool   `json:"muted"`
+	MutedUntil  string `json:"mutedUntil,omitempty"` // empty when muted until unmuted
+	MemberCount int    `json:"memberCount"`
+	LastMessage string `json:"lastMessage"`
+	LastTime    string `json:"lastTime,omitempty"`
+	UnreadCount int    `json:"unreadCount"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// ChatGroupMember is one person in a group.
+type ChatGroupMember struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	League   string `json:"league"`
+	Role     string `json:"role"`
+	// LastReadMessageID is the member's read receipt: every message up to
+	// and including this one has been read. "0" before they read anything.
+	LastReadMessageID string `json:"lastReadMessageId"`
+	JoinedAt          string `json:"joinedAt"`
+}
+
+// ChatGroupDetail is a group with its members.
+type ChatGroupDetail struct {
+	ChatGroup
+	Members []ChatGroupMember `json:"members"`
+	// InviteLink is shown to the owner and admins only, and only while one
+	// is live.
+	InviteLink string `json:"inviteLink,omitempty"`
+}
+
+// GroupMessage is one message in a group.
+type GroupMessage struct {
+	ID             string `json:"id"`
+	GroupID        string `json:"groupId"`
+	SenderID       string `json:"senderId,omitempty"`
+	SenderUsername string `json:"senderUsername,omitempty"`
+	Kind           string `json:"kind"` // "text", "challenge", "system"
+	Message        string `json:"message"`
+	ChallengeID    string `json:"challengeId,omitempty"`
+	ReplyToID      string `json:"replyToId,omitempty"`
+	// ReadCount is how many other members have read this far.
+	ReadCount int    `json:"readCount"`
+	CreatedAt string `json:"createdAt"`
+
+	// For a dropped challenge: the battle, its overall tally, and how this
+	// group voted.
+	Challenge  *Challenge    `json:"challenge,omitempty"`
+	Votes      []VoteSummary `json:"votes,omitempty"`
+	GroupVotes []VoteSummary `json:"groupVotes,omitempty"`
+}
+
+// CreateGroupPayload is the body of POST /chat/groups.
+type CreateGroupPayload struct {
+	Name      string   `json:"name"`
+	MemberIDs []string `json:"memberIds"`
+}
+
+// SendGroupMessagePayload is the body of POST /chat/groups/{id}/messages.
+type SendGroupMessagePayload struct {
+	Message     string `json:"message"`
+	ChallengeID string `json:"challengeId,omitempty"`
+	ReplyToID   string `json:"replyToId,omitempty"`
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// ROLES
+// ════════════════════════════════════════════════════════════════════════════════
+
+// groupRoleRank orders roles; an unknown role ranks below member.
+func groupRoleRank(role string) int {
+	switch role {
+	case groupRoleOwner:
+		return 3
+	case groupRoleAdmin:
+		return 2
+	case groupRoleMember:
+		return 1
+	}
+	return 0
+}
+
+// canManageMember reports whether someone with role actor may add or remove
+// someone with role target: admins and the owner may, over anyone ranked
+// below them.
+func canManageMember(actor, target string) bool {
+	return groupRoleRank(actor) >= groupRoleRank(groupRoleAdmin) &&
+		groupRoleRank(actor) > groupRoleRank(target)
+}
+
+// canSetRole reports whether actor may give target the role newRole. Only the
+// owner changes roles, never their own, and giving someone "owner" hands the
+// group over.
+func canSetRole(actor, target, newRole string) bool {
+	if actor != groupRoleOwner || target == groupRoleOwner {
+		return false
+	}
+	return newRole == groupRoleOwner || newRole == groupRoleAdmin || newRole == groupRoleMember
+}
+
+// newGroupInviteCode returns a fresh invite code: 12 random bytes, so a code
+// cannot be guessed or walked.
+func newGroupInviteCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// groupInviteLink is the link the app opens to join with code.
+func groupInviteLink(code string) string {
+	return "devf://group/join/" + code
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// STORE
+// ════════════════════════════════════════════════════════════════════════════════
+
+// groupRole is userID's role in a group, or errGroupNotFound if they are not
+// in it (or there is no such group — to a non-member the two look the same).
+func groupRole(groupID int, userID string) (string, error) {
+	var role string
+	err := db.QueryRow(`
+		SELECT role FROM chat_group_members
+		WHERE group_id = $1 AND user_id = CAST($2 AS INT)`, groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", errGroupNotFound
+	}
+	return role, err
+}
+
+// createGroup creates a group owned by creatorID with the given members.
+func createGroup(creatorID, name string, memberIDs []string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var id int
+	if err := tx.QueryRow(`
+		INSERT INTO chat_groups (name, created_by) VALUES ($1, CAST($2 AS INT))
+		RETURNING id`, name, creatorID).Scan(&id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO chat_group_members (group_id, user_id, role)
+		VALUES ($1, CAST($2 AS INT), $3)`, id, creatorID, groupRoleOwner); err != nil {
+		return 0, err
+	}
+	for _, m := range memberIDs {
+		if _, err := tx.Exec(`
+			INSERT INTO chat_group_members (group_id, user_id, role)
+			VALUES ($1, CAST($2 AS INT), $3)
+			ON CONFLICT (group_id, user_id) DO NOTHING`, id, m, groupRoleMember); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// addGroupMember puts a user in a group. The group row is locked so the last
+// place cannot be taken twice.
+func addGroupMember(groupID int, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var one int
+	err = tx.QueryRow(`SELECT 1 FROM chat_groups WHERE id = $1 FOR UPDATE`, groupID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return errGroupNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM chat_group_members WHERE group_id = $1`, groupID).Scan(&count); err != nil {
+		return err
+	}
+	if count >= groupMaxMembers {
+		return errGroupFull
+	}
+	res, err := tx.Exec(`
+		INSERT INTO chat_group_members (group_id, user_id, role)
+		VALUES ($1, CAST($2 AS INT), $3)
+		ON CONFLICT (group_id, user_id) DO NOTHING`, groupID, userID, groupRoleMember)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errGroupAlreadyMember
+	}
+	return tx.Commit()
+}
+
+// groupByInviteCode is the group a live invite code belongs to.
+func groupByInviteCode(code string) (int, error) {
+	var id int
+	err := db.QueryRow(`SELECT id FROM chat_groups WHERE invite_code = $1`, code).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errGroupBadInvite
+	}
+	return id, err
+}
+
+// rotateGroupInvite mints a new invite code for a group, replacing the old.
+func rotateGroupInvite(groupID int) (string, error) {
+	code, err := newGroupInviteCode()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`UPDATE chat_groups SET invite_code = $2 WHERE id = $1`, groupID, code)
+	return code, err
+}
+
+// removeGroupMember takes a non-owner out of a group.
+func removeGroupMember(groupID int, userID string) error {
+	_, err := db.Exec(`
+		DELETE FROM chat_group_members
+		WHERE group_id = $1 AND user_id = CAST($2 AS INT) AND role <> $3`,
+		groupID, userID, groupRoleOwner)
+	return err
+}
+
+// leaveGroup takes userID out of a group. An owner leaving hands the group to
+// the longest-standing admin, else the longest-standing member; successor is
+// their id, or "" if ownership did not move. The last member out deletes the
+// group (deleted is true).
+func leaveGroup(groupID int, userID string) (successor string, deleted bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var role string
+	err = tx.QueryRow(`
+		SELECT m.role FROM chat_group_members m
+		JOIN chat_groups g ON g.id = m.group_id
+		WHERE m.group_id = $1 AND m.user_id = CAST($2 AS INT)
+		FOR UPDATE OF g`, groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", false, errGroupNotFound
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM chat_group_members WHERE group_id = $1 AND user_id = CAST($2 AS INT)`,
+		groupID, userID); err != nil {
+		return "", false, err
+	}
+
+	if role == groupRoleOwner {
+		var next int
+		err = tx.QueryRow(`
+			SELECT user_id FROM chat_group_members
+			WHERE group_id = $1
+			ORDER BY (role = $2) DESC, joined_at, user_id
+			LIMIT 1`, groupID, groupRoleAdmin).Scan(&next)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := tx.Exec(`DELETE FROM chat_groups WHERE id = $1`, groupID); err != nil {
+				return "", false, err
+			}
+			return "", true, tx.Commit()
+		case err != nil:
+			return "", false, err
+		}
+		if _, err := tx.Exec(`
+			UPDATE chat_group_members SET role = $3
+			WHERE group_id = $1 AND user_id = $2`, groupID, next, groupRoleOwner); err != nil {
+			return "", false, err
+		}
+		successor = strconv.Itoa(next)
+	}
+	return successor, false, tx.Commit()
+}
+
+// setGroupRole gives targetID a new role. Making someone owner hands the
+// group over: the old owner, ownerID, becomes an admin in the same step.
+func setGroupRole(groupID int, ownerID, targetID, role string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if role == groupRoleOwner {
+		if _, err := tx.Exec(`
+			UPDATE chat_group_members SET role = $3
+			WHERE group_id = $1 AND user_id = CAST($2 AS INT)`,
+			groupID, ownerID, groupRoleAdmin); err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec(`
+		UPDATE chat_group_members SET role = $3
+		WHERE group_id = $1 AND user_id = CAST($2 AS INT)`, groupID, targetID, role)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errGroupNotFound
+	}
+	return tx.Commit()
+}
+
+// setGroupMute mutes a group for one member until the given time; forever
+// mutes until unmuted, and a zero time with forever false unmutes.
+func setGroupMute(groupID int, userID string, until time.Time, forever bool) error {
+	var arg any
+	switch {
+	case forever:
+		arg = "infinity"
+	case !until.IsZero():
+		arg = until
+	}
+	_, err := db.Exec(`
+		UPDATE chat_group_members SET muted_until = CAST($3 AS TIMESTAMPTZ)
+		WHERE group_id = $1 AND user_id = CAST($2 AS INT)`, groupID, userID, arg)
+	return err
+}
+
+// markGroupRead moves a member's read cursor forward to messageID. Returns
+// false when it did not move — already read, or not a message in this group.
+func markGroupRead(groupID int, userID string, messageID int) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE chat_group_members SET last_read_message_id = $3
+		WHERE group_id = $1 AND user_id = CAST($2 AS INT)
+		  AND last_read_message_id < $3
+		  AND EXISTS (SELECT 1 FROM group_messages WHERE id = $3 AND group_id = $1)`,
+		groupID, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// insertGroupMessage stores a message and returns it as sent.
+func insertGroupMessage(groupID int, senderID, kind, message string, challengeID, replyToID *int) (GroupMessage, error) {
+	var id int
+	var createdAt time.Time
+	err := db.QueryRow(`
+		INSERT INTO group_messages (group_id, sender_id, kind, message, challenge_id, reply_to_id)
+		VALUES ($1, CAST($2 AS INT), $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		groupID, senderID, kind, message, challengeID, replyToID).Scan(&id, &createdAt)
+	if err != nil {
+		return GroupMessage{}, err
+	}
+	m := GroupMessage{
+		ID:        strconv.Itoa(id),
+		GroupID:   strconv.Itoa(groupID),
+		SenderID:  senderID,
+		Kind:      kind,
+		Message:   message,
+		CreatedAt: createdAt.UTC().Format(time.RFC3339),
+	}
+	if challengeID != nil {
+		m.ChallengeID = strconv.Itoa(*challengeID)
+	}
+	if replyToID != nil {
+		m.ReplyToID = strconv.Itoa(*replyToID)
+	}
+	return m, nil
+}
+
+// listGroupMessages returns a page of a group's messages, newest first,
+// older than before (0 for the newest page).
+func listGroupMessages(groupID, before, limit int) ([]GroupMessage, error) {
+	rows, err := db.Query(`
+		SELECT m.id, COALESCE(CAST(m.sender_id AS TEXT), ''), COALESCE(u.username, ''),
+		       m.kind, m.message,
+		       COALESCE(CAST(m.challenge_id AS TEXT), ''), COALESCE(CAST(m.reply_to_id AS TEXT), ''),
+		       (SELECT COUNT(*) FROM chat_group_members r
+		         WHERE r.group_id = m.group_id AND r.last_read_message_id >= m.id
+		           AND r.user_id IS DISTINCT FROM m.sender_id),
+		       m.created_at
+		FROM group_messages m
+		LEFT JOIN users u ON u.id = m.sender_id
+		WHERE m.group_id = $1 AND ($2 = 0 OR m.id < $2)
+		ORDER BY m.id DESC
+		LIMIT $3`, groupID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []GroupMessage
+	for rows.Next() {
+		var id int
+		var createdAt time.Time
+		m := GroupMessage{GroupID: strconv.Itoa(groupID)}
+		if err := rows.Scan(&id, &m.SenderID, &m.SenderUsername, &m.Kind, &m.Message,
+			&m.ChallengeID, &m.ReplyToID, &m.ReadCount, &createdAt); err != nil {
+			return nil, err
+		}
+		m.ID = strconv.Itoa(id)
+		m.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// attachGroupBattle fills in a challenge message's battle and tallies. A
+// challenge deleted since it was dropped leaves the message as it was.
+func attachGroupBattle(groupID int, m *GroupMessage) {
+	if m.ChallengeID == "" {
+		return
+	}
+	if c, ok := GetChallengeByID(m.ChallengeID); ok {
+		m.Challenge = &c
+	}
+	m.Votes = GetVoteSummary(m.ChallengeID)
+	m.GroupVotes = groupVoteSummary(groupID, m.ChallengeID)
+}
+
+// groupVoteSummary is GetVoteSummary counting only the group's members.
+func groupVoteSummary(groupID int, challengeID string) []VoteSummary {
+	rows, err := db.Query(`
+		SELECT COALESCE(CAST(cv.response_id AS TEXT), $3), u.username, COUNT(*) AS votes,
+		       COALESCE(SUM(cv.weight), 0) AS weighted
+		FROM challenge_votes cv
+		JOIN challenges c ON c.id = cv.challenge_id
+		JOIN chat_group_members gm ON gm.group_id = $2 AND gm.user_id = cv.voter_id
+		LEFT JOIN challenge_responses cr ON cv.response_id = cr.id
+		JOIN users u ON u.id = COALESCE(cr.responder_id, c.creator_id)
+		WHERE cv.challenge_id = CAST($1 AS INT)
+		GROUP BY cv.response_id, u.username
+		ORDER BY votes DESC`, challengeID, groupID, creatorVoteSide)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var out []VoteSummary
+	for rows.Next() {
+		var v VoteSummary
+		var weighted float64
+		if rows.Scan(&v.ResponseID, &v.Username, &v.Votes, &weighted) == nil {
+			v.WeightedVotes = math.Round(weighted*100) / 100
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// listMyGroups is the caller's groups, most recently active first.
+func listMyGroups(userID string) ([]ChatGroup, error) {
+	rows, err := db.Query(`
+		SELECT g.id, g.name, me.role, me.muted_until,
+		       (SELECT COUNT(*) FROM chat_group_members c WHERE c.group_id = g.id),
+		       COALESCE(last.message, ''), last.created_at,
+		       (SELECT COUNT(*) FROM group_messages u
+		         WHERE u.group_id = g.id AND u.id > me.last_read_message_id
+		           AND u.sender_id IS DISTINCT FROM me.user_id),
+		       g.created_at
+		FROM chat_group_members me
+		JOIN chat_groups g ON g.id = me.group_id
+		LEFT JOIN LATERAL (
+			SELECT message, created_at FROM group_messages
+			WHERE group_id = g.id ORDER BY id DESC LIMIT 1
+		) last ON TRUE
+		WHERE me.user_id = CAST($1 AS INT)
+		ORDER BY COALESCE(last.created_at, g.created_at) DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ChatGroup
+	for rows.Next() {
+		var id int
+		var mutedUntil, lastTime sql.NullTime
+		var createdAt time.Time
+		var g ChatGroup
+		if err := rows.Scan(&id, &g.Name, &g.MyRole, &mutedUntil, &g.MemberCount,
+			&g.LastMessage, &lastTime, &g.UnreadCount, &createdAt); err != nil {
+			return nil, err
+		}
+		g.ID = strconv.Itoa(id)
+		g.Muted, g.MutedUntil = groupMuteState(mutedUntil, time.Now())
+		if lastTime.Valid {
+			g.LastTime = lastTime.Time.UTC().Format(time.RFC3339)
+		}
+		g.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// groupMuteState reads a muted_until column: whether it mutes now, and until
+// when ("" for no end). A mute until "infinity" scans as a time far past any
+// real one.
+func groupMuteState(until sql.NullTime, now time.Time) (bool, string) {
+	if !until.Valid || !until.Time.After(now) {
+		return false, ""
+	}
+	if until.Time.Year() > 9000 {
+		return true, ""
+	}
+	return true, until.Time.UTC().Format(time.RFC3339)
+}
+
+// getGroupDetail is a group as viewerID sees it.
+func getGroupDetail(groupID int, viewerID string) (ChatGroupDetail, error) {
+	var d ChatGroupDetail
+	groups, err := listMyGroups(viewerID)
+	if err != nil {
+		return d, err
+	}
+	found := false
+	for _, g := range groups {
+		if g.ID == strconv.Itoa(groupID) {
+			d.ChatGroup, found = g, true
+			break
+		}
+	}
+	if !found {
+		return d, errGroupNotFound
+	}
+
+	rows, err := db.Query(`
+		SELECT m.user_id, u.username, u.league, m.role, m.last_read_message_id, m.joined_at
+		FROM chat_group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, m.joined_at`, groupID)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid, lastRead int
+		var joinedAt time.Time
+		var m ChatGroupMember
+		if err := rows.Scan(&uid, &m.Username, &m.League, &m.Role, &lastRead, &joinedAt); err != nil {
+			return d, err
+		}
+		m.UserID = strconv.Itoa(uid)
+		m.LastReadMessageID = strconv.Itoa(lastRead)
+		m.JoinedAt = joinedAt.UTC().Format(time.RFC3339)
+		d.Members = append(d.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return d, err
+	}
+
+	if canManageMember(d.MyRole, groupRoleMember) {
+		var code sql.NullString
+		if err := db.QueryRow(`SELECT invite_code FROM chat_groups WHERE id = $1`, groupID).Scan(&code); err == nil && code.Valid {
+			d.InviteLink = groupInviteLink(code.String)
+		}
+	}
+	return d, nil
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// FAN-OUT
+// ════════════════════════════════════════════════════════════════════════════════
+
+// groupFanOut delivers a frame to every member's devices except skipUserID's
+// (the one who caused it, who has it in their response — as with a DM). Each
+// copy says whether that member has the group muted, so their devices can
+// update quietly.
+func groupFanOut(groupID int, frame map[string]interface{}, skipUserID string) {
+	rows, err := db.Query(`
+		SELECT CAST(m.user_id AS TEXT), u.username, COALESCE(m.muted_until > NOW(), FALSE)
+		FROM chat_group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1`, groupID)
+	if err != nil {
+		log.Printf("group fan-out %d: %v", groupID, err)
+		return
+	}
+	type recipient struct {
+		username string
+		muted    bool
+	}
+	var to []recipient
+	for rows.Next() {
+		var uid string
+		var r recipient
+		if rows.Scan(&uid, &r.username, &r.muted) == nil && uid != skipUserID {
+			to = append(to, r)
+		}
+	}
+	rows.Close()
+
+	frame["groupId"] = strconv.Itoa(groupID)
+	for _, r := range to {
+		frame["muted"] = r.muted
+		data, err := json.Marshal(frame)
+		if err != nil {
+			return
+		}
+		wsDeliverEvent(r.username, data)
+	}
+}
+
+// postGroupSystemMessage records a join, leave or role change in the
+// conversation and fans it out.
+func postGroupSystemMessage(groupID int, actorID, text string) {
+	m, err := insertGroupMessage(groupID, actorID, "system", text, nil, nil)
+	if err != nil {
+		log.Printf("group %d system message: %v", groupID, err)
+		return
+	}
+	groupFanOut(groupID, map[string]interface{}{"type": "group_message", "message": m}, "")
+}
+
+// usernameOf is a user's username, or "someone" if they can't be found.
+func usernameOf(userID string) string {
+	if u, ok := GetUserByID(userID); ok {
+		return u.Username
+	}
+	return "someone"
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// groupMember resolves the {id} in the path and the caller's role in that
+// group, writing the error response when either fails.
+func groupMember(w http.ResponseWriter, r *http.Request) (groupID int, userID, role string, ok bool) {
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return 0, "", "", false
+	}
+	userID = authUserID(r)
+	role, err = groupRole(groupID, userID)
+	if errors.Is(err, errGroupNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return 0, "", "", false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load group: "+err.Error(), http.StatusInternalServerError)
+		return 0, "", "", false
+	}
+	return groupID, userID, role, true
+}
+
+// writeGroupDetail answers with the group as the caller now sees it.
+func writeGroupDetail(w http.ResponseWriter, groupID int, userID string, status int) {
+	d, err := getGroupDetail(groupID, userID)
+	if err != nil {
+		http.Error(w, "Failed to load group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(d)
+}
+
+// CreateGroupHandler starts a group with the caller as owner.
+// POST /api/v1/chat/groups body:{ name, memberIds? }
+func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var p CreateGroupPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	if !allowAction(userID, "group_manage") {
+		writeRateLimited(w, "group_manage")
+		return
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > groupNameMax {
+		http.Error(w, fmt.Sprintf("name is required and at most %d characters", groupNameMax), http.StatusBadRequest)
+		return
+	}
+
+	// Everyone named has to exist and not be blocked either way by the
+	// creator; duplicates and the creator themselves are dropped.
+	seen := map[string]bool{userID: true}
+	var members []string
+	for _, m := range p.MemberIDs {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		if _, ok := GetUserByID(m); !ok {
+			http.Error(w, "user "+m+" not found", http.StatusBadRequest)
+			return
+		}
+		if usersBlocked(userID, m) {
+			http.Error(w, "cannot add user "+m+" to a group", http.StatusForbidden)
+			return
+		}
+		members = append(members, m)
+	}
+	if len(members)+1 > groupMaxMembers {
+		http.Error(w, errGroupFull.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := createGroup(userID, p.Name, members)
+	if err != nil {
+		http.Error(w, "Failed to create group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go postGroupSystemMessage(id, userID, usernameOf(userID)+" created the group")
+	writeGroupDetail(w, id, userID, http.StatusCreated)
+}
+
+// ListGroupsHandler lists the caller's groups.
+// GET /api/v1/chat/groups
+func ListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := listMyGroups(authUserID(r))
+	if err != nil {
+		http.Error(w, "Failed to list groups: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if groups == nil {
+		groups = []ChatGroup{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// GetGroupHandler returns a group with its members and their read receipts.
+// GET /api/v1/chat/groups/{id}
+func GetGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	writeGroupDetail(w, groupID, userID, http.StatusOK)
+}
+
+// AddGroupMemberHandler adds someone to a group. Owner and admins only.
+// POST /api/v1/chat/groups/{id}/members body:{ userId }
+func AddGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, role, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	var p struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.UserID == "" {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+	if !canManageMember(role, groupRoleMember) {
+		http.Error(w, errGroupNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	if _, ok := GetUserByID(p.UserID); !ok {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if usersBlocked(userID, p.UserID) {
+		http.Error(w, "cannot add this user", http.StatusForbidden)
+		return
+	}
+
+	switch err := addGroupMember(groupID, p.UserID); {
+	case errors.Is(err, errGroupFull), errors.Is(err, errGroupAlreadyMember):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to add member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go postGroupSystemMessage(groupID, userID,
+		usernameOf(userID)+" added "+usernameOf(p.UserID))
+	writeGroupDetail(w, groupID, userID, http.StatusOK)
+}
+
+// RemoveGroupMemberHandler removes someone from a group. Owner and admins
+// only, and only over members ranked below them.
+// DELETE /api/v1/chat/groups/{id}/members/{userId}
+func RemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, role, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	target := mux.Vars(r)["userId"]
+	targetRole, err := groupRole(groupID, target)
+	if errors.Is(err, errGroupNotFound) {
+		http.Error(w, "not a member of this group", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !canManageMember(role, targetRole) {
+		http.Error(w, errGroupNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	if err := removeGroupMember(groupID, target); err != nil {
+		http.Error(w, "Failed to remove member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go postGroupSystemMessage(groupID, userID,
+		usernameOf(userID)+" removed "+usernameOf(target))
+	writeGroupDetail(w, groupID, userID, http.StatusOK)
+}
+
+// SetGroupRoleHandler changes a member's role. Owner only; role "owner"
+// hands the group over and makes the caller an admin.
+// POST /api/v1/chat/groups/{id}/role body:{ userId, role }
+func SetGroupRoleHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, role, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	var p struct {
+		UserID string `json:"userId"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.UserID == "" {
+		http.Error(w, "userId and role are required", http.StatusBadRequest)
+		return
+	}
+	targetRole, err := groupRole(groupID, p.UserID)
+	if errors.Is(err, errGroupNotFound) {
+		http.Error(w, "not a member of this group", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if groupRoleRank(p.Role) == 0 {
+		http.Error(w, "role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+	if !canSetRole(role, targetRole, p.Role) {
+		http.Error(w, errGroupNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	if err := setGroupRole(groupID, userID, p.UserID, p.Role); err != nil {
+		http.Error(w, "Failed to change role: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	text := usernameOf(userID) + " made " + usernameOf(p.UserID) + " " + p.Role
+	if p.Role == groupRoleOwner {
+		text = usernameOf(userID) + " handed the group to " + usernameOf(p.UserID)
+	}
+	go postGroupSystemMessage(groupID, userID, text)
+	writeGroupDetail(w, groupID, userID, http.StatusOK)
+}
+
+// CreateGroupInviteHandler mints a new invite link, replacing any old one.
+// Owner and admins only.
+// POST /api/v1/chat/groups/{id}/invite
+func CreateGroupInviteHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, role, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	if !canManageMember(role, groupRoleMember) {
+		http.Error(w, errGroupNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	if !allowAction(userID, "group_manage") {
+		writeRateLimited(w, "group_manage")
+		return
+	}
+	code, err := rotateGroupInvite(groupID)
+	if err != nil {
+		http.Error(w, "Failed to create invite: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "inviteLink": groupInviteLink(code)})
+}
+
+// JoinGroupHandler joins a group through its invite link.
+// POST /api/v1/chat/groups/join body:{ code }
+func JoinGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var p struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || strings.TrimSpace(p.Code) == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	groupID, err := groupByInviteCode(strings.TrimSpace(p.Code))
+	if errors.Is(err, errGroupBadInvite) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to join group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch err := addGroupMember(groupID, userID); {
+	case errors.Is(err, errGroupAlreadyMember):
+		// Opening the link twice is not an error: show them the group.
+	case errors.Is(err, errGroupNotFound):
+		http.Error(w, errGroupBadInvite.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errGroupFull):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to join group: "+err.Error(), http.StatusInternalServerError)
+		return
+	default:
+		go postGroupSystemMessage(groupID, userID, usernameOf(userID)+" joined with an invite link")
+	}
+	writeGroupDetail(w, groupID, userID, http.StatusOK)
+}
+
+// MuteGroupHandler mutes or unmutes a group for the caller. hours 0 unmutes;
+// a negative value mutes until unmuted.
+// POST /api/v1/chat/groups/{id}/mute body:{ hours }
+func MuteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	var p struct {
+		Hours int `json:"hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var until time.Time
+	if p.Hours > 0 {
+		until = time.Now().Add(time.Duration(p.Hours) * time.Hour)
+	}
+	if err := setGroupMute(groupID, userID, until, p.Hours < 0); err != nil {
+		http.Error(w, "Failed to update mute: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeGroupDetail(w, groupID, userID, http.StatusOK)
+}
+
+// LeaveGroupHandler takes the caller out of a group.
+// POST /api/v1/chat/groups/{id}/leave
+func LeaveGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	successor, deleted, err := leaveGroup(groupID, userID)
+	if err != nil {
+		http.Error(w, "Failed to leave group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		text := usernameOf(userID) + " left"
+		if successor != "" {
+			text += "; " + usernameOf(successor) + " is now the owner"
+		}
+		go postGroupSystemMessage(groupID, userID, text)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"ok":true}`)
+}
+
+// SendGroupMessageHandler posts to a group: text, or a challenge dropped in
+// for the group to vote on (with an optional caption).
+// POST /api/v1/chat/groups/{id}/messages body:{ message, challengeId?, replyToId? }
+func SendGroupMessageHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	var p SendGroupMessagePayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !allowAction(userID, "chat") {
+		writeRateLimited(w, "chat")
+		return
+	}
+	if p.Message == "" && p.ChallengeID == "" {
+		http.Error(w, "message or challengeId is required", http.StatusBadRequest)
+		return
+	}
+	if len(p.Message) > maxChatMessageLen {
+		http.Error(w, "message too long", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	kind := "text"
+	var challengeID, replyToID *int
+	if p.ChallengeID != "" {
+		c, ok := GetChallengeByID(p.ChallengeID)
+		if !ok {
+			http.Error(w, "challenge not found", http.StatusNotFound)
+			return
+		}
+		if !challengeShareable(c, userID) {
+			http.Error(w, "this challenge can't be shared here", http.StatusForbidden)
+			return
+		}
+		cid, _ := strconv.Atoi(c.ID)
+		challengeID = &cid
+		kind = "challenge"
+	}
+	if p.ReplyToID != "" {
+		if rid, err := strconv.Atoi(p.ReplyToID); err == nil && rid > 0 {
+			replyToID = &rid
+		}
+	}
+
+	m, err := insertGroupMessage(groupID, userID, kind, p.Message, challengeID, replyToID)
+	if err != nil {
+		log.Printf("insertGroupMessage error: %v", err)
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+	m.SenderUsername = usernameOf(userID)
+	attachGroupBattle(groupID, &m)
+	// The sender has read their own message.
+	if id, _ := strconv.Atoi(m.ID); id > 0 {
+		_, _ = markGroupRead(groupID, userID, id)
+	}
+
+	go groupFanOut(groupID, map[string]interface{}{"type": "group_message", "message": m}, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// GetGroupMessagesHandler returns a page of a group's messages, newest first.
+// GET /api/v1/chat/groups/{id}/messages?limit=&before=<messageId>
+func GetGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, _, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	limit := groupMessagePage
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	before, _ := strconv.Atoi(r.URL.Query().Get("before"))
+
+	messages, err := listGroupMessages(groupID, before, limit)
+	if err != nil {
+		http.Error(w, "Failed to load messages: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []GroupMessage{}
+	}
+	for i := range messages {
+		attachGroupBattle(groupID, &messages[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// MarkGroupReadHandler records the caller's read receipt and tells the rest
+// of the group.
+// POST /api/v1/chat/groups/{id}/read body:{ messageId }
+func MarkGroupReadHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	var p struct {
+		MessageID string `json:"messageId"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&p)
+	msgID, _ := strconv.Atoi(p.MessageID)
+	if msgID == 0 {
+		http.Error(w, "messageId must be a valid integer", http.StatusBadRequest)
+		return
+	}
+	moved, err := markGroupRead(groupID, userID, msgID)
+	if err != nil {
+		http.Error(w, "Failed to mark read: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if moved {
+		go groupFanOut(groupID, map[string]interface{}{
+			"type":      "group_read",
+			"userId":    userID,
+			"messageId": p.MessageID,
+		}, userID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"ok":true}`)
+}
+
+// VoteGroupChallengeHandler votes on a challenge dropped into a group. It is
+// the ordinary battle vote — screened and weighted by CastVote — and the
+// group hears the new tally.
+// POST /api/v1/chat/groups/{id}/messages/{messageId}/vote body:{ responseId }
+func VoteGroupChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, _, ok := groupMember(w, r)
+	if !ok {
+		return
+	}
+	var p struct {
+		ResponseID string `json:"responseId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.ResponseID == "" {
+		http.Error(w, "responseId is required", http.StatusBadRequest)
+		return
+	}
+	var challengeID sql.NullString
+	err := db.QueryRow(`
+		SELECT CAST(challenge_id AS TEXT) FROM group_messages
+		WHERE id = $1 AND group_id = $2`, mux.Vars(r)["messageId"], groupID).Scan(&challengeID)
+	if err == sql.ErrNoRows || (err == nil && !challengeID.Valid) {
+		http.Error(w, "no challenge in this message", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowAction(userID, "vote") {
+		writeRateLimited(w, "vote")
+		return
+	}
+
+	payload := ChallengeVotePayload{ChallengeID: challengeID.String, ResponseID: p.ResponseID, VoterID: userID}
+	voted, err := CastVote(payload)
+	recordVoteOutcome(voteOutcomeLabel(err))
+	if writeVoteError(w, err) {
+		return
+	}
+	if voted {
+		go SendVoteNotification(payload)
+	}
+
+	votes := GetVoteSummary(challengeID.String)
+	if votes == nil {
+		votes = []VoteSummary{}
+	}
+	groupVotes := groupVoteSummary(groupID, challengeID.String)
+	if groupVotes == nil {
+		groupVotes = []VoteSummary{}
+	}
+	if voted {
+		go groupFanOut(groupID, map[string]interface{}{
+			"type":        "group_vote",
+			"messageId":   mux.Vars(r)["messageId"],
+			"challengeId": challengeID.String,
+			"votes":       votes,
+			"groupVotes":  groupVotes,
+		}, userID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"voted":      voted,
+		"votes":      votes,
+		"groupVotes": groupVotes,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Who may do what in a group is the part that has to be exactly right; the
+// store around it is plain SQL, pinned here only where it decides something
+// (who inherits a group).
+
+func TestCanManageMember(t *testing.T) {
+	cases := []struct {
+		actor, target string
+		want          bool
+	}{
+		{groupRoleOwner, groupRoleAdmin, true},
+		{groupRoleOwner, groupRoleMember, true},
+		{groupRoleAdmin, groupRoleMember, true},
+		{groupRoleAdmin, groupRoleAdmin, false}, // admins can't remove each other
+		{groupRoleAdmin, groupRoleOwner, false},
+		{groupRoleMember, groupRoleMember, false},
+		{groupRoleOwner, groupRoleOwner, false},
+		{"", groupRoleMember, false},
+	}
+	for _, c := range cases {
+		if got := canManageMember(c.actor, c.target); got != c.want {
+			t.Errorf("canManageMember(%q, %q) = %v, want %v", c.actor, c.target, got, c.want)
+		}
+	}
+}
+
+func TestCanSetRole(t *testing.T) {
+	if !canSetRole(groupRoleOwner, groupRoleMember, groupRoleAdmin) {
+		t.Error("owner could not promote a member")
+	}
+	if !canSetRole(groupRoleOwner, groupRoleAdmin, groupRoleOwner) {
+		t.Error("owner could not hand the group over")
+	}
+	if canSetRole(groupRoleAdmin, groupRoleMember, groupRoleAdmin) {
+		t.Error("an admin changed a role")
+	}
+	if canSetRole(groupRoleOwner, groupRoleOwner, groupRoleMember) {
+		t.Error("the owner demoted themselves, leaving the group ownerless")
+	}
+	if canSetRole(groupRoleOwner, groupRoleMember, "superuser") {
+		t.Error("an unknown role was accepted")
+	}
+}
+
+func TestGroupInviteCodesAreUnguessable(t *testing.T) {
+	a, err := newGroupInviteCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newGroupInviteCode()
+	if len(a) != 24 || a == b {
+		t.Errorf("codes %q and %q: want 24 hex chars, distinct", a, b)
+	}
+	if got := groupInviteLink(a); got != "devf://group/join/"+a {
+		t.Errorf("invite link = %q", got)
+	}
+}
+
+func TestGroupMuteState(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	if muted, _ := groupMuteState(sql.NullTime{}, now); muted {
+		t.Error("NULL muted_until reads as muted")
+	}
+	if muted, _ := groupMuteState(sql.NullTime{Time: now.Add(-time.Minute), Valid: true}, now); muted {
+		t.Error("a lapsed mute still mutes")
+	}
+	muted, until := groupMuteState(sql.NullTime{Time: now.Add(time.Hour), Valid: true}, now)
+	if !muted || until != "2026-05-01T13:00:00Z" {
+		t.Errorf("an hour's mute = %v until %q", muted, until)
+	}
+	// 'infinity' comes back from the driver as a time far in the future.
+	forever := time.Date(294276, 12, 31, 23, 59, 59, 0, time.UTC)
+	if muted, until := groupMuteState(sql.NullTime{Time: forever, Valid: true}, now); !muted || until != "" {
+		t.Errorf("mute until unmuted = %v until %q", muted, until)
+	}
+}
+
+func TestOwnerLeavingHandsGroupToAdmin(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.role FROM chat_group_members`).
+		WithArgs(7, "1").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(groupRoleOwner))
+	mock.ExpectExec(`DELETE FROM chat_group_members`).
+		WithArgs(7, "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`ORDER BY \(role = \$2\) DESC, joined_at`).
+		WithArgs(7, groupRoleAdmin).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec(`UPDATE chat_group_members SET role`).
+		WithArgs(7, 3, groupRoleOwner).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	successor, deleted, err := leaveGroup(7, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if successor != "3" || deleted {
+		t.Errorf("successor=%q deleted=%v, want 3 and not deleted", successor, deleted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLastMemberLeavingDeletesGroup(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.role FROM chat_group_members`).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(groupRoleOwner))
+	mock.ExpectExec(`DELETE FROM chat_group_members`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`ORDER BY \(role = \$2\) DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectExec(`DELETE FROM chat_groups WHERE id = \$1`).
+		WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, deleted, err := leaveGroup(7, "1"); err != nil || !deleted {
+		t.Errorf("deleted=%v err=%v, want the group deleted", deleted, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemberLeavingKeepsOwner(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT m.role FROM chat_group_members`).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(groupRoleMember))
+	mock.ExpectExec(`DELETE FROM chat_group_members`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if successor, deleted, err := leaveGroup(7, "5"); err != nil || successor != "" || deleted {
+		t.Errorf("successor=%q deleted=%v err=%v", successor, deleted, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -135,7 +135,16 @@ const ltrDeltaCeiling = ltrMaxDelta * learnedAuthorityMaxGain
 // logit, because callers need both together and a second lock per candidate
 // per page would be pure waste. What the count is FOR is deciding how much of
 // its budget this head has earned — see learned_authority.go.
+//
+// When an offline-trained artifact is live it is folded in here, so every
+// reader of the logit sees the same model — see offline_models.go.
 func ltrRawLogitWithSamples(cohort Cohort, breakdown map[string]float64) (z float64, samples int, ok bool) {
+	z, samples, ok = ltrOnlineLogit(cohort, breakdown)
+	return offlineLTROverride(cohort, breakdown, z, samples, ok)
+}
+
+// ltrOnlineLogit is the online model's own reading.
+func ltrOnlineLogit(cohort Cohort, breakdown map[string]float64) (z float64, samples int, ok bool) {
 	ltrEnsureLoaded()
 	ltr.mu.RLock()
 	m, exists := ltr.byCoh[cohort]
@@ -144,14 +153,21 @@ func ltrRawLogitWithSamples(cohort Cohort, breakdown map[string]float64) (z floa
 		return 0, 0, false // Not enough data yet — don't add noise
 	}
 	samples = m.Updates
-	z = m.Bias
+	z = m.logit(breakdown)
+	ltr.mu.RUnlock()
+	return z, samples, true
+}
+
+// logit is the model's raw score for a breakdown. The caller holds whatever
+// lock guards m; a snapshotted model (model_registry.go) needs none.
+func (m *ltrModel) logit(breakdown map[string]float64) float64 {
+	z := m.Bias
 	for _, k := range ltrFeatureKeys {
 		if v, ok := breakdown[k]; ok {
 			z += m.Weights[k] * v
 		}
 	}
-	ltr.mu.RUnlock()
-	return z, samples, true
+	return z
 }
 
 // ltrScoreDelta returns a bounded correction that scoreForUser adds on top.
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// MAIL SENDER — pluggable like PushSender, so account mail (password reset,
+// email verification) is written once and production picks the transport.
+//
+// Three implementations ship today:
+//   - smtpMailSender : any SMTP relay (SES, Postmark, Mailgun all speak it)
+//   - logMailSender  : prints to log, links included; local development only
+//   - offMailSender  : sends nothing and says so; the default
+//
+// The active sender is chosen at boot time via env vars:
+//   MAIL_SENDER=smtp  → SMTP (requires SMTP_HOST; SMTP_PORT defaults to 587,
+//                       SMTP_USER / SMTP_PASS for auth, MAIL_FROM for From)
+//   MAIL_SENDER=log   → log
+//
+// Anything else — unset, unknown, or smtp without SMTP_HOST — gets
+// offMailSender, with one warning at startup. The links in account mail are
+// credentials, so a deploy that forgot to configure mail must not fall back
+// to writing them into its logs: resets and verifications fail instead
+// until it is fixed. Logging them is something a developer asks for.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// MailMessage is one plain-text email.
+type MailMessage struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// MailSender is the interface every mail backend implements.
+type MailSender interface {
+	Send(msg MailMessage) error
+	Name() string
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// logMailSender — for local development, and only when asked for. The whole
+// message goes to the log, links included, so a local reset can be
+// completed by copying the link. Never select it in production: the links
+// are credentials.
+// ─────────────────────────────────────────────────────────────────────────────
+
+type logMailSender struct{}
+
+func (logMailSender) Name() string { return "log" }
+
+func (logMailSender) Send(msg MailMessage) error {
+	log.Printf("[mail:log] to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// offMailSender — mail isn't configured. Every send fails, so the caller
+// reports it as it would a relay that is down, and nothing is written down
+// that could be used in the message's place.
+// ─────────────────────────────────────────────────────────────────────────────
+
+var errMailNotConfigured = errors.New("mail is not configured (set MAIL_SENDER)")
+
+type offMailSender struct{}
+
+func (offMailSender) Name() string { return "off" }
+
+func (offMailSender) Send(MailMessage) error { return errMailNotConfigured }
+
+// ─────────────────────────────────────────────────────────────────────────────
+// smtpMailSender — net/smtp against a relay. SendMail upgrades to TLS with
+// STARTTLS whenever the server offers it, and PlainAuth refuses to send
+// credentials over an unencrypted connection to anything but localhost.
+// ─────────────────────────────────────────────────────────────────────────────
+
+type smtpMailSender struct {
+	host, port string
+	user, pass string
+	from       string
+}
+
+func (s *smtpMailSender) Name() string { return "smtp" }
+
+func (s *smtpMailSender) Send(msg MailMessage) error {
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.pass, s.host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.host, s.port), auth, s.from, []string{msg.To}, s.format(msg))
+}
+
+// format renders the message as RFC 5322 text. Header values come from us,
+// not the user, apart from To — which has already passed normalizeEmail and
+// so cannot carry a line break.
+func (s *smtpMailSender) format(msg MailMessage) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Selection
+// ─────────────────────────────────────────────────────────────────────────────
+
+var (
+	currentMailSender   MailSender = offMailSender{}
+	currentMailSenderMu sync.RWMutex
+)
+
+func setMailSender(s MailSender) {
+	currentMailSenderMu.Lock()
+	currentMailSender = s
+	currentMailSenderMu.Unlock()
+}
+
+func getMailSender() MailSender {
+	currentMailSenderMu.RLock()
+	defer currentMailSenderMu.RUnlock()
+	return currentMailSender
+}
+
+// initMailSender is called from main(). Reads env to pick sender; safe to
+// call without env vars set (mail is then off).
+func initMailSender() {
+	switch mode := strings.ToLower(getEnv("MAIL_SENDER", "")); mode {
+	case "smtp":
+		host := getEnv("SMTP_HOST", "")
+		if host == "" {
+			log.Printf("mail: WARNING: MAIL_SENDER=smtp but SMTP_HOST is unset — account mail is OFF; password resets and email verification will fail")
+			setMailSender(offMailSender{})
+			break
+		}
+		setMailSender(&smtpMailSender{
+			host: host,
+			port: getEnv("SMTP_PORT", "587"),
+			user: getEnv("SMTP_USER", ""),
+			pass: getEnv("SMTP_PASS", ""),
+			from: getEnv("MAIL_FROM", "no-reply@localhost"),
+		})
+	case "log":
+		log.Printf("mail: WARNING: MAIL_SENDER=log writes reset and verification links to the log — local development only")
+		setMailSender(logMailSender{})
+	default:
+		log.Printf("mail: WARNING: MAIL_SENDER is %q — account mail is OFF; password resets and email verification will fail", mode)
+		setMailSender(offMailSender{})
+	}
+	log.Printf("mail: sender=%s", getMailSender().Name())
+}
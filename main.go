@@ -17,10 +17,13 @@ import (
 )
 
 // LoginHandler validates credentials and returns the user's data and all other users.
+// The credentials are either a username and password or, with "passkey" set,
+// a passkey assertion from POST /passkeys/login/begin (see passkeys.go).
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var creds struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username string            `json:"username"`
+		Password string            `json:"password"`
+		Passkey  *passkeyAssertion `json:"passkey"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
@@ -28,6 +31,29 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if creds.Passkey != nil {
+		// No username to key on, and nothing to guess: an assertion is a
+		// signature over a challenge we issued. The IP bucket only keeps
+		// this path from being a free CPU burner.
+		if !allowAction("ip:"+clientIP(r), "login") {
+			writeRateLimited(w, "login")
+			return
+		}
+		userID, err := verifyPasskeyLogin(*creds.Passkey)
+		if err != nil {
+			log.Printf("Failed passkey login from %s: %v", clientIP(r), err)
+			http.Error(w, "Passkey sign-in failed", http.StatusUnauthorized)
+			return
+		}
+		user, exists := GetUserByID(userID)
+		if !exists {
+			http.Error(w, "Passkey sign-in failed", http.StatusUnauthorized)
+			return
+		}
+		completeLogin(w, r, user)
+		return
+	}
+
 	// Brute-force gate. actionLimitTable has carried a "login" row (6/min)
 	// since it was written, but nothing ever called it — so the only thing
 	// between an attacker and a password list was the global per-IP limiter
@@ -70,31 +96,54 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Could not find user data after successful login", http.StatusInternalServerError)
 		return
 	}
+	completeLogin(w, r, user)
+}
 
-	// Mint a signed session token. From here on the client authenticates every
-	// protected request with this token (Authorization: Bearer <token>) and the
-	// server derives identity from it — never from a client-supplied userId.
-	token, err := issueToken(user.ID, user.Username)
+// completeLogin opens a session for an authenticated user and writes the
+// login response. Shared by the password and passkey paths of LoginHandler.
+func completeLogin(w http.ResponseWriter, r *http.Request, user User) {
+	// A suspended account can't sign in until the suspension ends.
+	if rs, suspended := activeRestriction(user.ID, restrictSuspended); suspended {
+		writeRestricted(w, rs)
+		return
+	}
+	// Signing in to an account deleted within the grace period undoes the
+	// deletion. See account_delete.go.
+	restored, ok := checkAccountRestore(w, user.ID)
+	if !ok {
+		return
+	}
+
+	// Open a session and mint its access token. From here on the client
+	// authenticates every protected request with this token (Authorization:
+	// Bearer <token>) and the server derives identity from it — never from a
+	// client-supplied userId. See sessions.go.
+	session, err := startSession(r, user.ID, user.Username)
 	if err != nil {
-		// Almost always means JWT_SECRET is unset — fail closed rather than hand
-		// back a session the protected routes will reject anyway.
-		log.Printf("token issuance failed for %s: %v", creds.Username, err)
+		// JWT_SECRET unset or the session row not written — fail closed rather
+		// than hand back a session the protected routes will reject anyway.
+		log.Printf("token issuance failed for %s: %v", user.Username, err)
 		http.Error(w, "Login temporarily unavailable", http.StatusInternalServerError)
 		return
 	}
 
 	// Create the response payload — no longer sending all users for security.
 	response := map[string]interface{}{
-		"user":     user,
-		"token":    token,
-		"allUsers": []User{},
+		"user":         user,
+		"token":        session.Token,
+		"refreshToken": session.RefreshToken,
+		"sessionId":    session.SessionID,
+		"allUsers":     []User{},
+	}
+	if restored {
+		response["restored"] = true
 	}
 
 	// Send the successful response.
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
-	log.Printf("User %s logged in successfully.", creds.Username)
+	log.Printf("User %s logged in successfully.", user.Username)
 }
 
 // ReseedHandler drops all data and reseeds the database.
@@ -130,13 +179,45 @@ func GetUserHandler(w http.ResponseWriter, r *http.Request) {
 	username := vars["username"]
 
 	user, exists := GetUserByUsername(username)
-	if !exists {
+	// A deleted account looks gone while it waits out its grace period.
+	if !exists || accountDeactivated(user.ID) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
+	// A private account shows a stranger its limited profile and whether
+	// they have already asked to follow it. See follow_requests.go.
+	if viewerID := optionalAuthUserID(r); !canViewAccount(viewerID, user) {
+		followStatus := "none"
+		if followRequested(viewerID, user.ID) {
+			followStatus = followStatusRequested
+		}
+		writeJSON(w, http.StatusOK, struct {
+			User
+			Private      bool   `json:"private"`
+			FollowStatus string `json:"followStatus"`
+		}{limitedProfile(user), true, followStatus})
+		return
+	}
+
+	// The user's fields stay at the top level, as they always were; the skill
+	// ratings (ratings.go) ride alongside them.
+	ratings := GetUserRatings(user.ID)
+	if ratings == nil {
+		ratings = []SkillRating{}
+	}
+	history := GetRatingHistory(user.ID, ratingHistoryLimit)
+	if history == nil {
+		history = []RatingHistoryEntry{}
+	}
+	resp := struct {
+		User
+		Ratings       []SkillRating        `json:"ratings"`
+		RatingHistory []RatingHistoryEntry `json:"ratingHistory"`
+	}{user, ratings, history}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		http.Error(w, "Failed to encode user data", http.StatusInternalServerError)
 	}
 }
@@ -457,6 +538,9 @@ func main() {
 	// credentials are missing or are an obvious placeholder, rather than
 	// letting an unrotated dev password quietly guard /admin/reseed.
 	checkAdminConfig()
+	// While there are no admin accounts yet, create the first superuser from
+	// those same env vars. See admin_auth.go.
+	seedAdminFromEnv()
 	// Build the challenge-subject autocomplete index. Runs the
 	// curated-vocab + existing-challenge merge in the background so
 	// it doesn't block startup; the handler degrades to the in-binary
@@ -468,11 +552,16 @@ func main() {
 	startAnalyticsScheduler()
 	startLTRFlusher()
 	startPlattRefitter()
+	// Serve the live offline-trained ranking artifact, if one is marked live.
+	// See offline_models.go and cmd/ltrtrain.
+	startOfflineModelSync()
 	startTrendingPruner()
 	startBootstrapPoolWorker()
 	startWatchRatioFlusher()
 	startEmbeddingBackfillWorker()
 	initPushSender()
+	initMailSender()
+	initOIDCProviders()
 	startNotificationDispatcher()
 	startNotificationTriggers()
 	// Reset HLS transcode jobs orphaned at 'PENDING' by crashed workers.
@@ -481,11 +570,30 @@ func main() {
 	// challenge or an account drops the rows and queues the storage paths;
 	// this drains that queue. Without it every delete leaks its video.
 	startMediaDeleter()
+	// Builds requested data export archives, uploads them to R2 and
+	// notifies their owners; retires archives past their retention.
+	startDataExporter()
+	// Hard-deletes accounts whose deletion grace period has run out. See
+	// account_delete.go.
+	startAccountPurger()
 	// Try each new video on a small crowd first, and only spend a big crowd on
 	// the ones that earn it. Without this every video costs the same 300 views
 	// before anyone is allowed to judge it, which is what caps how many uploads
 	// a day can get a verdict at all.
 	startAuditionReviewer()
+	// Close battles when their 24 hours are up (or early, once the vote is
+	// decisive), crown the winner and move wins/losses/league. Without it a
+	// battle is voted on forever and every record stays 0–0 Bronze.
+	startBattleResolver()
+	// Flag groups of accounts that vote as a bloc, and count their votes for
+	// less. See vote_integrity.go.
+	startVoteRingScanner()
+	// Draw tournament brackets when entries close and move each tournament
+	// on to its next round once that round's battles are settled.
+	startTournamentScheduler()
+	// Expire direct challenges nobody answered in time, and apply the
+	// challenger's fallback. See direct_challenge.go.
+	startDirectChallengeExpirer()
 	// One-shot repair: rewrite manifest URLs stored with the fabricated
 	// pub-<ACCOUNT_ID>.r2.dev/<bucket> base (written by workers whose
 	// optional R2_PUBLIC_BASE_URL env was unset) to the real public base.
@@ -498,6 +606,24 @@ func main() {
 	// that lives in process memory (write-through keeps Redis current).
 	loadMoodTransitions()
 	loadSessionTrajectories()
+	// Follow the model registry: load a promoted or rolled-back version when
+	// another replica switches it, keep the shadow version loaded, and take
+	// the scheduled snapshots. See model_registry.go.
+	startModelRegistry()
+	// Hidden content and suspended accounts, as the feed, search and auth
+	// see them; reloaded so every replica follows a reviewer's decision.
+	// See moderation.go.
+	startModerationSync()
+	// Which accounts are private, for filtering feeds and search per viewer
+	// without a query per item. See follow_requests.go.
+	startPrivacySync()
+	// Content with pending sensitive-content reports, counted per item for
+	// each user's sensitive-content level. See content_preferences.go.
+	startSensitiveContentSync()
+	// Drops ranking log pages past their 30 days. See ranking_log.go.
+	startRankingLogPruner()
+	// Deletes signed-out and expired sessions a month after they end.
+	startSessionPruner()
 	// Cross-replica WebSocket delivery (no-op unless MULTI_REPLICA=1).
 	startWSRelay()
 	// Evict idle rate-limiter buckets so the in-memory limiter maps don't grow
@@ -516,6 +642,11 @@ func main() {
 	api.HandleFunc("/users/{username}", GetUserHandler).Methods("GET", "OPTIONS")
 	api.HandleFunc("/follow", authed(HandleFollowEvent)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/unfollow", authed(HandleUnfollowEvent)).Methods("POST", "OPTIONS")
+	// Private accounts: the owner's inbox of pending follow requests. See
+	// follow_requests.go.
+	api.HandleFunc("/follow-requests", authed(ListFollowRequestsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/follow-requests/{requesterId}/approve", authed(ApproveFollowRequestHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/follow-requests/{requesterId}/reject", authed(RejectFollowRequestHandler)).Methods("POST", "OPTIONS")
 	// Legacy post-centric routes retired (/feed, /home, /posts/{userId},
 	// /like, /comments) — the home reels feed now serves challenges only
 	// (battles + unaccepted-as-shorts) via /feed/smart, and per-challenge
@@ -550,15 +681,29 @@ func main() {
 	api.HandleFunc("/challenges/vote", authed(VoteChallengeHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/challenges/comments", authed(AddChallengeCommentHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/challenges/responses/{id}/flag", authed(FlagResponseHandler)).Methods("POST", "OPTIONS")
+	// Direct challenges: call-outs waiting on me, and turning one down.
+	// Accepting is answering, through /challenges/accept.
+	api.HandleFunc("/challenges/direct", authed(GetDirectChallengesHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/challenges/{id}/decline", authed(DeclineDirectChallengeHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/challenges/{id}/votes", GetVoteResultsHandler).Methods("GET", "OPTIONS")
 	api.HandleFunc("/challenges/{id}/comments", GetChallengeCommentsHandler).Methods("GET", "OPTIONS")
 	api.HandleFunc("/challenges/{id}", GetChallengeDetailHandler).Methods("GET", "OPTIONS")
+	// Tournaments — single-elimination brackets played as battles.
+	api.HandleFunc("/tournaments", authed(CreateTournamentHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/tournaments", authed(ListTournamentsHandler)).Methods("GET")
+	api.HandleFunc("/tournaments/{id}", authed(GetTournamentHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/tournaments/{id}/join", authed(JoinTournamentHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/feed/recommended", authed(RecommendedFeedHandler)).Methods("GET", "OPTIONS")
 	api.HandleFunc("/feed/following", authed(FollowingFeedHandler)).Methods("GET", "OPTIONS")
 	// Psychology-based recommendation engine (v2)
 	api.HandleFunc("/feed/smart", authed(SmartFeedHandler)).Methods("GET", "OPTIONS")
 	api.HandleFunc("/feed/following/v2", authed(FollowingFeedV2Handler)).Methods("GET", "OPTIONS")
 	api.HandleFunc("/feed/explore", authed(ExploreFeedHandler)).Methods("GET", "OPTIONS")
+	// "Why am I seeing this?" for a recently served item, and the
+	// show-less-of-this-creator/category actions it offers. See
+	// feed_explain.go.
+	api.HandleFunc("/feed/explain", authed(FeedExplainHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/feed/explain/feedback", authed(FeedExplainFeedbackHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/categories", CategoriesHandler).Methods("GET", "OPTIONS")
 	api.HandleFunc("/events", authed(TrackEventHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/events/batch", authed(TrackBatchEventsHandler)).Methods("POST", "OPTIONS")
@@ -576,6 +721,12 @@ func main() {
 	// Self-service account deletion (owner-only; Google Play requires
 	// in-app deletion for apps with account creation).
 	api.HandleFunc("/users/{id}", authed(DeleteAccountHandler)).Methods("DELETE")
+	// Personal data export ("download your data"). POST queues a job and
+	// returns 202; GET reports on it and hands out a short-lived download
+	// link once built. Open to suspended accounts — the right to a copy of
+	// your data does not depend on standing. See data_export.go.
+	api.HandleFunc("/users/{id}/export", authedAllowSuspended(RequestDataExportHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}/export", authedAllowSuspended(GetDataExportHandler)).Methods("GET", "OPTIONS")
 	// Activity surfaces — paginated list of challenges the user has
 	// liked / watched. Cursor-based on the action timestamp so the
 	// page stays stable as the user keeps engaging.
@@ -591,6 +742,13 @@ func main() {
 	api.HandleFunc("/blocks", authed(BlockUserHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/unblock", authed(UnblockUserHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/users/{id}/blocks", authed(ListBlockedUsersHandler)).Methods("GET", "OPTIONS")
+	// Muted words, categories and creators and the sensitive-content
+	// level. Muting is soft, unlike a block: no follow edge changes and
+	// the creator is not told. See content_preferences.go.
+	api.HandleFunc("/users/{id}/content-preferences", authed(GetContentPreferencesHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/{id}/content-preferences", authed(UpdateContentPreferencesHandler)).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/users/{id}/muted-creators", authed(MuteCreatorHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}/muted-creators/{creatorId}", authed(UnmuteCreatorHandler)).Methods("DELETE", "OPTIONS")
 	// TOTP-based 2FA. Enroll mints a fresh secret + recovery codes
 	// (returned ONCE in plaintext), verify activates the row,
 	// disable requires proving knowledge of a current code.
@@ -604,21 +762,54 @@ func main() {
 	api.HandleFunc("/suggestions/accepted", authed(SuggestionAcceptedHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/watch", authed(HandleWatchEvent)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/report", authed(HandleReportEvent)).Methods("POST", "OPTIONS")
-	api.HandleFunc("/admin/reseed", adminOnly(ReseedHandler)).Methods("POST", "OPTIONS")
-	api.HandleFunc("/admin/funnels", adminOnly(AdminFunnelsHandler)).Methods("GET", "OPTIONS")
-	api.HandleFunc("/admin/errors", adminOnly(AdminErrorsHandler)).Methods("GET", "OPTIONS")
-	api.HandleFunc("/admin/health", adminOnly(AdminHealthHandler)).Methods("GET", "OPTIONS")
-	api.HandleFunc("/admin/golden_hour", adminOnly(AdminGoldenHourHandler)).Methods("GET", "OPTIONS")
-	api.HandleFunc("/admin/online", adminOnly(AdminOnlineUsersHandler)).Methods("GET", "OPTIONS")
-	api.HandleFunc("/admin/diagnostics", adminOnly(AdminDiagnosticsHandler)).Methods("GET", "OPTIONS")
+	// Account standing and appeals. Open to suspended accounts — they are
+	// where a refused request points. See enforcement.go.
+	api.HandleFunc("/account/standing", authedAllowSuspended(AccountStandingHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/account/appeals", authedAllowSuspended(CreateAppealHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/reseed", adminOnly(adminPermOperate, ReseedHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/funnels", adminOnly(adminPermView, AdminFunnelsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/errors", adminOnly(adminPermView, AdminErrorsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/health", adminOnly(adminPermView, AdminHealthHandler)).Methods("GET", "OPTIONS")
+	// Throw away every skill rating and replay every settled battle from its
+	// votes. Deterministic; see ratings.go.
+	api.HandleFunc("/admin/ratings/rebuild", adminOnly(adminPermOperate, AdminRebuildRatingsHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/golden_hour", adminOnly(adminPermView, AdminGoldenHourHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/online", adminOnly(adminPermView, AdminOnlineUsersHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/diagnostics", adminOnly(adminPermView, AdminDiagnosticsHandler)).Methods("GET", "OPTIONS")
 	// Global "is the ranking working?" KPI snapshot (completion/skip/engagement/
 	// session length, new-content discovery, catalog coverage) with good/watch/bad
 	// verdicts. See admin_feed_health.go.
-	api.HandleFunc("/admin/feed-health", adminOnly(AdminFeedHealthHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/feed-health", adminOnly(adminPermView, AdminFeedHealthHandler)).Methods("GET", "OPTIONS")
 	// Experiment CRUD: upsert an experiment (or kill one with
 	// "active": false) without a redeploy — refresher propagates the
 	// change to every replica within 60s.
-	api.HandleFunc("/admin/experiments", adminOnly(AdminUpsertExperimentHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/experiments", adminOnly(adminPermExperiment, AdminUpsertExperimentHandler)).Methods("POST", "OPTIONS")
+	// Model registry: versions of the online-learned ranking state, shadow
+	// scoring of a candidate version, promotion and rollback. See
+	// model_registry.go.
+	api.HandleFunc("/admin/models", adminOnly(adminPermView, AdminModelRegistryHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/models/snapshot", adminOnly(adminPermExperiment, AdminModelSnapshotHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/models/shadow", adminOnly(adminPermView, AdminModelShadowReportHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/models/shadow", adminOnly(adminPermExperiment, AdminModelShadowHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/models/rollback", adminOnly(adminPermExperiment, AdminModelRollbackHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/models/{id:[0-9]+}/promote", adminOnly(adminPermExperiment, AdminModelPromoteHandler)).Methods("POST", "OPTIONS")
+	// Moderation: the report queue, reviewer decisions, and the audit log
+	// of every decision. See moderation.go.
+	api.HandleFunc("/admin/moderation/queue", adminOnly(adminPermModerate, AdminModerationQueueHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/moderation/audit", adminOnly(adminPermModerate, AdminModerationAuditHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/moderation/targets/{type}/{id}", adminOnly(adminPermModerate, AdminModerationTargetHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/moderation/targets/{type}/{id}/decide", adminOnly(adminPermModerate, AdminModerationDecideHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/moderation/appeals", adminOnly(adminPermModerate, AdminListAppealsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/moderation/appeals/{id}/decide", adminOnly(adminPermModerate, AdminDecideAppealHandler)).Methods("POST", "OPTIONS")
+	// Admin accounts, admin TOTP, and the append-only log of every mutating
+	// admin request. See admin_accounts.go.
+	api.HandleFunc("/admin/me", adminOnly(adminPermView, AdminMeHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/me/totp/enroll", adminOnly(adminPermView, AdminEnrollTOTPHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/me/totp/verify", adminOnly(adminPermView, AdminVerifyTOTPHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/audit-log", adminOnly(adminPermView, AdminAuditLogHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/accounts", adminOnly(adminPermManage, AdminListAccountsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/accounts", adminOnly(adminPermManage, AdminCreateAccountHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/accounts/{id}", adminOnly(adminPermManage, AdminUpdateAccountHandler)).Methods("POST", "OPTIONS")
 
 	// Search-page empty state: the caller's recent queries (authed —
 	// personal data) and the platform's trending queries (public).
@@ -639,7 +830,7 @@ func main() {
 	api.HandleFunc("/creator/insights", authed(HandleCreatorInsightsOverview)).Methods("GET", "OPTIONS")
 	api.HandleFunc("/creator/insights/content", authed(HandleCreatorInsightsPerContent)).Methods("GET", "OPTIONS")
 
-	r.HandleFunc("/admin", adminOnly(AdminDashboardHandler)).Methods("GET")
+	r.HandleFunc("/admin", adminOnly(adminPermView, AdminDashboardHandler)).Methods("GET")
 	api.HandleFunc("/chat/send", authed(SendMessageHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/chat/conversations/{userId}", authed(GetConversationsHandler)).Methods("GET", "OPTIONS")
 	api.HandleFunc("/chat/messages/{userId}/{otherUserId}", authed(GetMessagesHandler)).Methods("GET", "OPTIONS")
@@ -647,7 +838,28 @@ func main() {
 	api.HandleFunc("/chat/edit", authed(EditMessageHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/chat/delete", authed(DeleteMessageHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/chat/forward", authed(ForwardMessageHandler)).Methods("POST", "OPTIONS")
+	// Per-message delivery receipts and reactions; typing and socket-side
+	// delivery reports arrive as WebSocket frames. See chat_receipts.go.
+	api.HandleFunc("/chat/delivered", authed(MarkDeliveredHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/react", authed(ReactMessageHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/chat/online/{username}", OnlineStatusHandler).Methods("GET", "OPTIONS")
+
+	// Group chats — membership, roles, invites, mute, read receipts, and
+	// challenges dropped in for the group to vote on. See group_chat.go.
+	api.HandleFunc("/chat/groups", authed(CreateGroupHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups", authed(ListGroupsHandler)).Methods("GET")
+	api.HandleFunc("/chat/groups/join", authed(JoinGroupHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}", authed(GetGroupHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/members", authed(AddGroupMemberHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/members/{userId}", authed(RemoveGroupMemberHandler)).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/role", authed(SetGroupRoleHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/invite", authed(CreateGroupInviteHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/mute", authed(MuteGroupHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/leave", authed(LeaveGroupHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/messages", authed(SendGroupMessageHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/messages", authed(GetGroupMessagesHandler)).Methods("GET")
+	api.HandleFunc("/chat/groups/{id}/read", authed(MarkGroupReadHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/chat/groups/{id}/messages/{messageId}/vote", authed(VoteGroupChallengeHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/save", authed(SaveChallengeHandler)).Methods("POST", "OPTIONS")
 	api.HandleFunc("/saved/{userId}", authed(GetSavedChallengesHandler)).Methods("GET", "OPTIONS")
 
@@ -656,8 +868,34 @@ func main() {
 	// anonymous-rate-limited via the "signup" action bucket).
 	r.HandleFunc("/signup", SignupHandler).Methods("POST", "OPTIONS")
 	r.HandleFunc("/signup/available", UsernameAvailableHandler).Methods("GET", "OPTIONS")
-	// Token refresh (authed) — active users never hit the 7-day expiry.
-	api.HandleFunc("/auth/refresh", authed(RefreshTokenHandler)).Methods("POST", "OPTIONS")
+	// Password reset and email verification (public; rate limited per
+	// address and IP). Setting the address is authed. See account_recovery.go.
+	r.HandleFunc("/password/forgot", ForgotPasswordHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/password/reset", ResetPasswordHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/email/verify", VerifyEmailHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/account/email", authed(UpdateEmailHandler)).Methods("POST", "OPTIONS")
+	// Token refresh — active users never hit the 7-day expiry. Not authed:
+	// the refresh token is the credential. Sessions, logout and logout
+	// everywhere. See sessions.go.
+	api.HandleFunc("/auth/refresh", RefreshTokenHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/logout", authed(LogoutHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/sessions", authed(ListSessionsHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/sessions/logout-all", authed(LogoutEverywhereHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/sessions/{id}", authed(RevokeSessionHandler)).Methods("DELETE", "OPTIONS")
+	// Passkeys (WebAuthn). Registering and managing them is authed; the
+	// sign-in challenge is public and is answered on /login. See passkeys.go.
+	api.HandleFunc("/passkeys/register/begin", authed(BeginPasskeyRegistrationHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/passkeys/register/finish", authed(FinishPasskeyRegistrationHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/passkeys", authed(ListPasskeysHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/passkeys/{id}", authed(DeletePasskeyHandler)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/passkeys/login/begin", BeginPasskeyLoginHandler).Methods("POST", "OPTIONS")
+	// Sign in with Google / Apple (public), and linking those providers to an
+	// account (authed). See oidc.go.
+	r.HandleFunc("/auth/oidc/nonce", OIDCNonceHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/auth/oidc/login", OIDCLoginHandler).Methods("POST", "OPTIONS")
+	api.HandleFunc("/account/identities", authed(ListIdentitiesHandler)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/account/identities", authed(LinkIdentityHandler)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/account/identities/{provider}", authed(UnlinkIdentityHandler)).Methods("DELETE", "OPTIONS")
 	// Onboarding interest picker → seeds CategoryAffinity for cold start.
 	api.HandleFunc("/profile/interests", authed(SeedInterestsHandler)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/ws/{username}", WebsocketHandler).Methods("GET")
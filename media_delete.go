@@ -39,6 +39,7 @@ package main
 // variant this code does not know the name of still goes.
 
 import (
+	"bytes"
 	"context"
 	"encoding/xml"
 	"errors"
@@ -273,6 +274,32 @@ func (c *R2Config) DeleteObject(ctx context.Context, objectKey string) error {
 	return err
 }
 
+// PutObject uploads one object the backend produced itself. Everything the
+// app uploads goes straight from the phone to a presigned URL; this is for
+// the few files the server writes, such as data export archives.
+func (c *R2Config) PutObject(ctx context.Context, objectKey, contentType string, body []byte) error {
+	signed, err := c.presignURL("PUT", objectKey, nil, 10*time.Minute)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", signed, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	res, err := mediaHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4<<10))
+		return fmt.Errorf("storage answered %d: %s",
+			res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
 // doSigned performs a request against a URL that presignURL already signed.
 func (c *R2Config) doSigned(ctx context.Context, method, signedURL string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, method, signedURL, nil)
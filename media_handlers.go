@@ -25,10 +25,11 @@ import (
 // ---------- Wire format ----------
 
 type presignItemRequest struct {
-	// Kind is "video" or "thumbnail".
+	// Kind is "video", "thumbnail" or "chat" (a chat attachment).
 	Kind string `json:"kind"`
-	// Variant is "480p", "720p", "1080p", "original" (videos) or
-	// "default" (thumbnails). buildObjectKey enforces the closed set.
+	// Variant is "480p", "720p", "1080p", "original" (videos),
+	// "default" (thumbnails), or "image", "video", "poster", "voice"
+	// (chat). buildObjectKey enforces the closed set.
 	Variant string `json:"variant"`
 	// ContentType the client will set on the PUT (informational — we
 	// don't sign it). Stored back in the response so the client doesn't
@@ -112,6 +112,20 @@ func (c *R2Config) PresignPutURL(objectKey string, expiry time.Duration) (string
 	return c.presignURL("PUT", objectKey, nil, expiry)
 }
 
+// PresignGetURL is the read-side twin: a link that lets the holder download
+// one object for `expiry`, whether or not the bucket is public. When
+// downloadName is set the response asks the browser to save the file under
+// that name instead of displaying it; the override is part of the signed
+// query, so the holder cannot change it.
+func (c *R2Config) PresignGetURL(objectKey, downloadName string, expiry time.Duration) (string, error) {
+	var q url.Values
+	if downloadName != "" {
+		q = url.Values{}
+		q.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", downloadName))
+	}
+	return c.presignURL("GET", objectKey, q, expiry)
+}
+
 // Multipart presigns — same signer, different (method, query) pairs.
 // The client executes the actual S3 calls; the backend never touches
 // bytes, exactly like the single-PUT path. Part size/count policy lives
@@ -322,6 +336,9 @@ func newUploadID() string {
 var mediaKindAllowed = map[string]struct{}{
 	"video":     {},
 	"thumbnail": {},
+	// Attachments in chat messages. The variant says what the attachment
+	// is — see chatVariantToExt.
+	"chat": {},
 }
 
 // variantToExt maps the requested variant name to the file extension we
@@ -335,6 +352,17 @@ var variantToExt = map[string]string{
 	"default":  "jpg", // thumbnail
 }
 
+// chatVariantToExt is variantToExt for the "chat" kind, where the variant
+// names the attachment rather than a quality tier: a photo, a short clip and
+// its poster frame, or a voice note. chat_media.go checks a message's media
+// against these file names, so a photo can't be sent as a voice note.
+var chatVariantToExt = map[string]string{
+	"image":  "jpg",
+	"video":  "mp4",
+	"poster": "jpg",
+	"voice":  "m4a",
+}
+
 // buildObjectKey constructs the S3 path for one variant of one upload.
 // Layout: u/<userID>/<uploadID>/<variant>.<ext>
 //
@@ -349,6 +377,9 @@ func buildObjectKey(userID, uploadID, kind, variant string) (string, error) {
 		return "", fmt.Errorf("invalid media kind %q", kind)
 	}
 	ext, ok := variantToExt[variant]
+	if kind == "chat" {
+		ext, ok = chatVariantToExt[variant]
+	}
 	if !ok {
 		return "", fmt.Errorf("invalid variant %q", variant)
 	}
@@ -244,6 +244,12 @@ func TestBuildObjectKey_Layout(t *testing.T) {
 		// to silently produce a .jpg with mp4 bytes inside.)
 		{"video with thumbnail variant still mp4", "42", "abc", "video", "default", "u/42/abc/default.mp4", false},
 
+		// Chat attachments: the variant names the attachment.
+		{"chat photo", "42", "abc", "chat", "image", "u/42/abc/image.jpg", false},
+		{"chat clip", "42", "abc", "chat", "video", "u/42/abc/video.mp4", false},
+		{"chat voice note", "42", "abc", "chat", "voice", "u/42/abc/voice.m4a", false},
+		{"chat with a quality variant", "42", "abc", "chat", "720p", "", true},
+
 		{"missing userID", "", "abc", "video", "720p", "", true},
 		{"missing uploadID", "42", "", "video", "720p", "", true},
 		{"unknown kind", "42", "abc", "audio", "720p", "", true},
@@ -284,6 +284,24 @@ var (
 			Help: "Requests served by the non-personalized explore feed.",
 		},
 	)
+
+	// ── Battle resolution ────────────────────────────────────────────────────
+	metricBattlesResolved = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "devf_battles_resolved_total",
+			Help: "Battles closed by the resolver, by outcome.",
+		},
+		[]string{"outcome"}, // "won" | "no_winner" | "error"
+	)
+
+	// ── Vote integrity ───────────────────────────────────────────────────────
+	metricVoteIntegrity = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "devf_vote_integrity_total",
+			Help: "Vote screening decisions, and accounts flagged by the ring scan.",
+		},
+		[]string{"outcome"}, // accepted|participant|not_watched|locked|closed|error|ring_flagged
+	)
 )
 
 // registerMetrics is called from main() — safe to call multiple times, each
@@ -321,6 +339,8 @@ func registerMetrics() {
 		metricCreatorResidualUpdate,
 		metricCohortBlendObserve,
 		metricExploreFeed,
+		metricBattlesResolved,
+		metricVoteIntegrity,
 	)
 }
 
@@ -0,0 +1,41 @@
+-- Let a battle end.
+--
+-- Until now nothing ever closed a battle. Votes were counted for display and
+-- then ignored: users.wins, users.losses and users.league were never written,
+-- and challenges.status never moved past 'active' even though the app has
+-- always shown a countdown to expiresAt. battle_resolution.go closes them now,
+-- and these are the columns it needs to record what happened.
+--
+--   winner_id           the user who won. NULL on a battle that closed with no
+--                       winner — nobody voted, or the tie-breaks could not
+--                       separate the top two.
+--   winner_response_id  which video won. NULL when the winner is the
+--                       challenger's own video, which has no response row.
+--   resolved_at         when the battle was closed. The resolver only ever
+--                       looks at rows where this is NULL, so a battle is
+--                       settled exactly once however many instances run it.
+--
+-- No backfill. Every battle that exists today is left 'active' with a NULL
+-- resolved_at, and the resolver closes the overdue ones on its first pass —
+-- the same way it closes any other battle, under the same tie-break rules.
+
+ALTER TABLE challenges
+    ADD COLUMN IF NOT EXISTS winner_id          INT REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS winner_response_id INT REFERENCES challenge_responses(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS resolved_at        TIMESTAMPTZ;
+
+-- A vote for the challenger's own video.
+--
+-- challenge_votes could only ever point at a response, so a battle had one
+-- votable side: the challenger could not receive a vote and so could never
+-- win. A NULL response_id now means "voted for the challenger". The existing
+-- UNIQUE (challenge_id, voter_id) still holds, so a viewer still has exactly
+-- one vote per battle, whichever side it is on.
+ALTER TABLE challenge_votes ALTER COLUMN response_id DROP NOT NULL;
+
+-- The resolver asks "which live battles are overdue" on a timer. Only live
+-- battles are in this index, so it stays the size of what is currently being
+-- voted on rather than the size of the archive.
+CREATE INDEX IF NOT EXISTS idx_challenges_unresolved_battles
+    ON challenges (created_at)
+    WHERE status = 'active' AND resolved_at IS NULL;
@@ -0,0 +1,48 @@
+-- Skill ratings.
+--
+-- users.league used to be the only measure of how good somebody is, and it was
+-- a label: five names, no notion of how far into a league anyone was, and no
+-- notion of how sure we are. ratings.go keeps a Glicko-2 rating for every
+-- battler, per category and overall, and users.league becomes a banding over
+-- the overall one — still stored, because search, suggestions and the feed all
+-- read it, but now derived rather than set.
+--
+--   rating      the skill estimate, on the familiar 1500-centred scale.
+--   deviation   how unsure that estimate is (Glicko's RD). 350 for somebody
+--               who has never battled; it shrinks with every battle and grows
+--               back slowly while they are away.
+--   volatility  how erratic their results are (Glicko-2's sigma).
+--   battles     how many rated battles fed the estimate.
+--
+-- category is a challenges.category value, or 'overall' for the rating across
+-- every category — the one leagues and matchmaking fall back to.
+CREATE TABLE IF NOT EXISTS user_ratings (
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    category    VARCHAR(30) NOT NULL,
+    rating      DOUBLE PRECISION NOT NULL DEFAULT 1500,
+    deviation   DOUBLE PRECISION NOT NULL DEFAULT 350,
+    volatility  DOUBLE PRECISION NOT NULL DEFAULT 0.06,
+    battles     INT NOT NULL DEFAULT 0,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, category)
+);
+
+-- One row per rating change, so a profile can draw the line and so a bad
+-- result can be explained ("why did I drop 40 points?") without replaying
+-- anything.
+--
+-- Derived data, like user_ratings itself: the admin rebuild deletes both and
+-- writes them again from the votes.
+CREATE TABLE IF NOT EXISTS rating_history (
+    id             BIGSERIAL PRIMARY KEY,
+    user_id        INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    category       VARCHAR(30) NOT NULL,
+    challenge_id   INT REFERENCES challenges(id) ON DELETE SET NULL,
+    rating_before  DOUBLE PRECISION NOT NULL,
+    rating_after   DOUBLE PRECISION NOT NULL,
+    deviation      DOUBLE PRECISION NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rating_history_user
+    ON rating_history (user_id, created_at DESC);
@@ -0,0 +1,39 @@
+-- Vote integrity.
+--
+-- Now that votes decide battles, records and ratings, a vote is worth faking.
+-- vote_integrity.go screens each vote as it is cast and watches for groups of
+-- accounts voting as a bloc; these are the columns it needs.
+
+-- When an account was made. users never recorded it, which also quietly broke
+-- the account-age part of engagement_quality.go (it read a column that did not
+-- exist). Existing accounts are left NULL — "older than we can tell" — rather
+-- than stamped with today, which would make every account in the table look
+-- brand new at once. Accounts made from now on get the real time.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+ALTER TABLE users ALTER COLUMN created_at SET DEFAULT NOW();
+
+-- How much a vote counts, decided when it is cast (account age, engagement,
+-- membership of a flagged ring) and stored, so a battle is settled — and later
+-- replayed by the ratings rebuild — on exactly the weights it was voted with.
+-- 1 is a full vote; existing votes keep 1.
+--
+-- changes counts how many times the voter has switched sides. Switching was
+-- unlimited, which let one account follow the lead back and forth all day.
+ALTER TABLE challenge_votes
+    ADD COLUMN IF NOT EXISTS weight  DOUBLE PRECISION NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS changes INT NOT NULL DEFAULT 0;
+
+-- Accounts caught voting as a bloc. ring_id groups the members found together
+-- ("ring-<lowest member id>"); ring_size is how many there were. A row here
+-- is a judgement that a human may want to reverse, so nothing deletes these
+-- automatically — an operator deletes the row.
+CREATE TABLE IF NOT EXISTS vote_ring_members (
+    user_id     INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    ring_id     VARCHAR(40) NOT NULL,
+    ring_size   INT NOT NULL,
+    flagged_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The ring scan pairs up voters over a recent window.
+CREATE INDEX IF NOT EXISTS idx_challenge_votes_recent
+    ON challenge_votes (created_at, challenge_id);
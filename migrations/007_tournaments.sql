@@ -0,0 +1,72 @@
+-- Tournaments: single-elimination brackets of head-to-head battles.
+--
+-- A creator opens a tournament on a subject; people join with one entry video
+-- each; when entries close the entrants are seeded into a bracket and every
+-- matchup is played as an ordinary battle — a challenges row with the two
+-- entry videos as its two sides. That is deliberate: a matchup gets voting,
+-- vote screening, the 24-hour clock, the early close, records and ratings from
+-- the code that already does all of that for every other battle, and
+-- tournament.go only has to decide who plays whom next. See tournament.go.
+
+-- status: 'open' (taking entries) → 'running' → 'completed', or 'cancelled'
+-- when entries close with fewer than two entrants. round is the round being
+-- played, 0 before the bracket is drawn.
+CREATE TABLE IF NOT EXISTS tournaments (
+    id              SERIAL PRIMARY KEY,
+    creator_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    prefix          VARCHAR(100) NOT NULL,
+    subject         VARCHAR(100) NOT NULL,
+    category        VARCHAR(30) NOT NULL DEFAULT 'other',
+    max_entrants    INT NOT NULL,
+    status          VARCHAR(20) NOT NULL DEFAULT 'open',
+    round           INT NOT NULL DEFAULT 0,
+    entry_deadline  TIMESTAMPTZ NOT NULL,
+    winner_id       INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at      TIMESTAMPTZ,
+    completed_at    TIMESTAMPTZ
+);
+
+-- The scheduler's two questions: which open tournaments are due to start,
+-- and which running ones might have a round to advance.
+CREATE INDEX IF NOT EXISTS idx_tournaments_live
+    ON tournaments (status, entry_deadline)
+    WHERE status IN ('open', 'running');
+
+-- One entry per person. seed is assigned when the bracket is drawn (1 = top);
+-- eliminated_round is the round they went out in, NULL while still in it.
+CREATE TABLE IF NOT EXISTS tournament_entrants (
+    tournament_id     INT NOT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+    user_id           INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    video_url         TEXT NOT NULL,
+    thumbnail_url     TEXT NOT NULL DEFAULT '',
+    seed              INT,
+    eliminated_round  INT,
+    joined_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tournament_id, user_id)
+);
+
+-- One row per matchup. slot is the position within the round, so the winners
+-- of slots 2k and 2k+1 meet in slot k of the next round. entrant_b is NULL for
+-- a bye; a bye is born decided. challenge_id is the battle the matchup is
+-- played as.
+CREATE TABLE IF NOT EXISTS tournament_matches (
+    id             SERIAL PRIMARY KEY,
+    tournament_id  INT NOT NULL REFERENCES tournaments(id) ON DELETE CASCADE,
+    round          INT NOT NULL,
+    slot           INT NOT NULL,
+    entrant_a      INT REFERENCES users(id) ON DELETE SET NULL,
+    entrant_b      INT REFERENCES users(id) ON DELETE SET NULL,
+    challenge_id   INT REFERENCES challenges(id) ON DELETE SET NULL,
+    winner_id      INT REFERENCES users(id) ON DELETE SET NULL,
+    status         VARCHAR(20) NOT NULL DEFAULT 'live',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_at     TIMESTAMPTZ,
+    UNIQUE (tournament_id, round, slot)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tournament_matches_challenge
+    ON tournament_matches (challenge_id);
+CREATE INDEX IF NOT EXISTS idx_tournament_matches_live
+    ON tournament_matches (created_at)
+    WHERE status = 'live';
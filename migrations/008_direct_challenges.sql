@@ -0,0 +1,36 @@
+-- Direct challenges: calling out one specific rival.
+--
+-- A challenge could be posted to the arena or to friends, but never AT
+-- somebody. A direct challenge names its target; the target is told (push and
+-- in-app) and has until respond_by to accept — by answering with their video,
+-- exactly as any challenge is answered — or decline. If they decline or let it
+-- lapse, the challenger's choice applies: the challenge falls back to the open
+-- arena, or it expires. See direct_challenge.go.
+--
+-- The challenge itself is an ordinary challenges row with visibility
+-- 'direct', which every feed and list query already leaves out because they
+-- ask for 'arena' or 'friends' by name. Falling back is a change of
+-- visibility to 'arena'.
+
+-- status: 'pending' → 'accepted' | 'declined' | 'expired'.
+CREATE TABLE IF NOT EXISTS direct_challenges (
+    challenge_id       INT PRIMARY KEY REFERENCES challenges(id) ON DELETE CASCADE,
+    target_id          INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status             VARCHAR(20) NOT NULL DEFAULT 'pending',
+    fallback_to_arena  BOOLEAN NOT NULL DEFAULT FALSE,
+    respond_by         TIMESTAMPTZ NOT NULL,
+    decided_at         TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The expiry sweep, and "what's waiting for me".
+CREATE INDEX IF NOT EXISTS idx_direct_challenges_pending
+    ON direct_challenges (respond_by)
+    WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_direct_challenges_target
+    ON direct_challenges (target_id, status);
+
+-- Being called out, and hearing back about a call-out you made, are their own
+-- push kinds so either can be turned off without muting the rest.
+ALTER TABLE notification_prefs
+    ADD COLUMN IF NOT EXISTS direct_challenge BOOLEAN DEFAULT TRUE;
@@ -0,0 +1,56 @@
+-- Group chats: conversations with more than two people.
+--
+-- chat_messages is one sender and one receiver, and stays that way for DMs. A
+-- group is its own conversation with a member list, and its messages live in
+-- group_messages. Each member has a role (owner / admin / member), can mute the
+-- group for themselves, and has a read cursor — the id of the last message they
+-- have read, so "read by" is a count of cursors at or past a message rather
+-- than a row per message per reader. See group_chat.go.
+--
+-- A message can carry a challenge: dropping one into a group puts the battle
+-- in front of every member, and they vote on it through the ordinary battle
+-- vote, so the group's votes are screened and counted like anyone else's.
+
+-- invite_code is NULL when the group has no live invite link; minting a new
+-- one replaces the old, which stops working.
+CREATE TABLE IF NOT EXISTS chat_groups (
+    id           SERIAL PRIMARY KEY,
+    name         VARCHAR(80) NOT NULL,
+    created_by   INT REFERENCES users(id) ON DELETE SET NULL,
+    invite_code  VARCHAR(32) UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- role: 'owner' (exactly one per group), 'admin', 'member'.
+-- muted_until: NULL when not muted; 'infinity' for muted until unmuted.
+-- last_read_message_id: the member's read cursor, 0 before they read anything.
+CREATE TABLE IF NOT EXISTS chat_group_members (
+    group_id              INT NOT NULL REFERENCES chat_groups(id) ON DELETE CASCADE,
+    user_id               INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role                  VARCHAR(10) NOT NULL DEFAULT 'member',
+    muted_until           TIMESTAMPTZ,
+    last_read_message_id  INT NOT NULL DEFAULT 0,
+    joined_at             TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+-- "My groups".
+CREATE INDEX IF NOT EXISTS idx_chat_group_members_user
+    ON chat_group_members (user_id);
+
+-- kind: 'text', 'challenge' (challenge_id set), or 'system' (joins, leaves,
+-- role changes — written by the server, sender is whoever caused it).
+CREATE TABLE IF NOT EXISTS group_messages (
+    id            SERIAL PRIMARY KEY,
+    group_id      INT NOT NULL REFERENCES chat_groups(id) ON DELETE CASCADE,
+    sender_id     INT REFERENCES users(id) ON DELETE SET NULL,
+    kind          VARCHAR(10) NOT NULL DEFAULT 'text',
+    message       TEXT NOT NULL DEFAULT '',
+    challenge_id  INT REFERENCES challenges(id) ON DELETE SET NULL,
+    reply_to_id   INT REFERENCES group_messages(id) ON DELETE SET NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The message list, newest first, and unread counts past a cursor.
+CREATE INDEX IF NOT EXISTS idx_group_messages_group
+    ON group_messages (group_id, id DESC);
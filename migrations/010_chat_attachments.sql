@@ -0,0 +1,27 @@
+-- Chat attachments: photos, short videos, voice notes and shared challenges.
+--
+-- A DM was text and nothing else. Now every message has a kind:
+--
+--   text       message is the text
+--   image      media_url is a photo
+--   video      media_url is a short clip, media_thumb_url its poster frame
+--   voice      media_url is a voice note
+--   challenge  challenge_id is a challenge shared as a card
+--
+-- For all but text, message is an optional caption. The media itself is
+-- uploaded straight to the bucket with the same presigned-URL flow as a
+-- challenge video, under the "chat" media kind; these columns only hold where
+-- it ended up. See chat_media.go.
+
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS kind VARCHAR(10) NOT NULL DEFAULT 'text';
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS media_url TEXT;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS media_thumb_url TEXT;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS media_duration_ms INT;
+-- A shared challenge that is later deleted leaves the message, minus its card.
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS challenge_id INT REFERENCES challenges(id) ON DELETE SET NULL;
+
+-- Before an upload is queued for deletion (unsend, account deletion) we check
+-- that no other message still shows it — a forward points at the same file.
+CREATE INDEX IF NOT EXISTS idx_chat_messages_media
+    ON chat_messages (media_url)
+    WHERE media_url IS NOT NULL;
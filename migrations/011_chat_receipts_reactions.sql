@@ -0,0 +1,20 @@
+-- Chat receipts and reactions.
+--
+-- A DM was "read" or not, flipped for a whole conversation at once. Now each
+-- message has its own delivered and read times: delivered when a device of the
+-- receiver reports having it, read when the receiver has seen it. The status a
+-- message reports is derived from these (and from is_read, for messages older
+-- than this file), so nothing is backfilled. See chat_receipts.go.
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+
+-- One reaction per person per message, from a fixed set; reacting again
+-- replaces it. Typing indicators are not here on purpose — they only ever
+-- exist in flight over the WebSocket.
+CREATE TABLE IF NOT EXISTS chat_message_reactions (
+    message_id  INT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji       VARCHAR(16) NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
@@ -0,0 +1,61 @@
+-- Moderation: a reviewer queue over reports, and what a decision does.
+--
+-- Reports have been written with status 'pending' since the table existed and
+-- nothing ever read them back. A reviewer now works through them grouped by
+-- what was reported, and each decision — dismiss, hide the content, strike the
+-- owner, suspend the owner — resolves every pending report on that target at
+-- once. See moderation.go.
+--
+-- Everything a decision changes lives in small tables of its own rather than
+-- in new columns on challenges or users: the feed, explore and search filter
+-- on a snapshot of these, reloaded every few seconds, and reading a few
+-- hundred rows is cheap where scanning the challenges table for a flag would
+-- not be.
+
+-- How a report was closed. 'pending' → 'dismissed' | 'actioned'.
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMPTZ;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS resolution VARCHAR(20);
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS action_id INT;
+
+-- The queue groups pending reports by target. Pending reports are a small
+-- slice of a small table, so a plain partial index is fine here.
+CREATE INDEX IF NOT EXISTS idx_reports_pending_target
+    ON reports (target_type, target_id)
+    WHERE status = 'pending';
+
+-- The audit log: one row per decision, never updated or deleted. No foreign
+-- keys on purpose — the record of what was decided about an account has to
+-- outlive the account.
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id               SERIAL PRIMARY KEY,
+    target_type      VARCHAR(20) NOT NULL,
+    target_id        INT NOT NULL,
+    subject_user_id  INT,            -- whose content or account it was
+    action           VARCHAR(20) NOT NULL,  -- dismiss | hide | strike | suspend
+    reviewer         TEXT NOT NULL,
+    note             TEXT NOT NULL DEFAULT '',
+    report_ids       INT[] NOT NULL DEFAULT '{}',
+    suspended_until  TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_target
+    ON moderation_actions (target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_subject
+    ON moderation_actions (subject_user_id, created_at DESC);
+
+-- Content a reviewer has taken down. Kept out of every feed and search.
+CREATE TABLE IF NOT EXISTS moderation_hidden (
+    target_type  VARCHAR(20) NOT NULL,
+    target_id    INT NOT NULL,
+    action_id    INT NOT NULL REFERENCES moderation_actions(id),
+    hidden_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (target_type, target_id)
+);
+
+-- Suspended accounts. 'infinity' is until further notice.
+CREATE TABLE IF NOT EXISTS user_suspensions (
+    user_id          INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    suspended_until  TIMESTAMPTZ NOT NULL,
+    action_id        INT NOT NULL REFERENCES moderation_actions(id),
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
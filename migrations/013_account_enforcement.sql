@@ -0,0 +1,72 @@
+-- Account enforcement: strikes that expire, restrictions beyond suspension,
+-- and appeals. See enforcement.go.
+--
+-- 012 could only suspend, and counted strikes by scanning moderation_actions
+-- for the last 90 days. Now a decision can also ban an account from posting
+-- or put it on limited distribution, every strike has its own expiry (and can
+-- be lifted on appeal), and the account can appeal any decision about it.
+--
+-- user_suspensions is superseded by account_restrictions. Its live rows are
+-- copied across below; the table itself stays until every replica runs this
+-- release, and a later migration drops it.
+
+-- One row per restriction kind per account. 'infinity' is until further
+-- notice. A new decision of the same kind replaces the row only if it ends
+-- later (upsertRestriction).
+CREATE TABLE IF NOT EXISTS account_restrictions (
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind        VARCHAR(20) NOT NULL,   -- suspended | posting_ban | limited
+    until       TIMESTAMPTZ NOT NULL,
+    reason      VARCHAR(40) NOT NULL DEFAULT 'policy_violation',
+    action_id   INT NOT NULL REFERENCES moderation_actions(id),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, kind)
+);
+CREATE INDEX IF NOT EXISTS idx_account_restrictions_action ON account_restrictions (action_id);
+
+INSERT INTO account_restrictions (user_id, kind, until, reason, action_id, created_at)
+SELECT user_id, 'suspended', suspended_until, 'policy_violation', action_id, created_at
+FROM user_suspensions
+WHERE suspended_until > NOW()
+ON CONFLICT (user_id, kind) DO NOTHING;
+
+-- Strikes, each with its own expiry. lifted_at is set when an appeal
+-- overturns the decision that gave it.
+CREATE TABLE IF NOT EXISTS account_strikes (
+    id          SERIAL PRIMARY KEY,
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action_id   INT NOT NULL REFERENCES moderation_actions(id),
+    reason      VARCHAR(40) NOT NULL DEFAULT 'policy_violation',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    lifted_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_account_strikes_user ON account_strikes (user_id, expires_at);
+
+-- Strikes given under 012 expire 90 days after they were given, which is
+-- the window 012 counted them over.
+INSERT INTO account_strikes (user_id, action_id, created_at, expires_at)
+SELECT ma.subject_user_id, ma.id, ma.created_at, ma.created_at + INTERVAL '90 days'
+FROM moderation_actions ma
+JOIN users u ON u.id = ma.subject_user_id
+WHERE ma.action = 'strike'
+  AND NOT EXISTS (SELECT 1 FROM account_strikes s WHERE s.action_id = ma.id);
+
+-- The machine-readable reason shown to the account. NULL on 012 rows.
+ALTER TABLE moderation_actions ADD COLUMN IF NOT EXISTS reason VARCHAR(40);
+
+-- One appeal per decision, from the account it was about.
+CREATE TABLE IF NOT EXISTS moderation_appeals (
+    id             SERIAL PRIMARY KEY,
+    user_id        INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action_id      INT NOT NULL UNIQUE REFERENCES moderation_actions(id),
+    message        TEXT NOT NULL,
+    status         VARCHAR(20) NOT NULL DEFAULT 'pending',  -- pending | granted | denied
+    reviewer       TEXT,
+    decision_note  TEXT,
+    decided_at     TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_appeals_pending
+    ON moderation_appeals (created_at)
+    WHERE status = 'pending';
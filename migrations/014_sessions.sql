@@ -0,0 +1,32 @@
+-- Server-tracked sessions, so a token can be taken back. See sessions.go.
+--
+-- Until now a session token was a signed JWT and nothing else: logging out,
+-- losing a phone or changing a password left every issued token working until
+-- it expired. Each sign-in now opens a row here; the access token carries the
+-- row's id, and authed() refuses it once the row is revoked.
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id                   TEXT PRIMARY KEY,       -- random, also the token's jti
+    user_id              INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refresh_hash         TEXT NOT NULL,          -- sha256 of the live refresh secret
+    used_refresh_hashes  TEXT[] NOT NULL DEFAULT '{}',  -- spent ones, newest first, for reuse detection
+    user_agent           TEXT NOT NULL DEFAULT '',
+    ip                   TEXT NOT NULL DEFAULT '',
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_active_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),  -- last refresh
+    expires_at           TIMESTAMPTZ NOT NULL,   -- slides forward on every refresh
+    revoked_at           TIMESTAMPTZ,
+    revoked_reason       VARCHAR(30)             -- logout | revoked | logout_all | refresh_reuse
+);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user
+    ON user_sessions (user_id, last_active_at DESC)
+    WHERE revoked_at IS NULL;
+
+-- "Log out everywhere" for tokens issued before sessions existed, which have
+-- no row above to revoke: such a token issued before not_before is refused.
+-- Those tokens are all expired a week after this ships; the table stays as
+-- the record of when each user last signed out everywhere.
+CREATE TABLE IF NOT EXISTS session_epochs (
+    user_id     INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    not_before  TIMESTAMPTZ NOT NULL
+);
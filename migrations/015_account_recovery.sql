@@ -0,0 +1,34 @@
+-- Email addresses, and the single-use tokens mailed to them for password
+-- reset and verification. See account_recovery.go.
+--
+-- Addresses get a table of their own rather than a column on users: users
+-- is large, and the uniqueness rule below needs an index, which on users
+-- would have to be built CONCURRENTLY outside this file's transaction.
+
+CREATE TABLE IF NOT EXISTS user_emails (
+    user_id      INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email        TEXT NOT NULL,          -- normalized: trimmed, lower-case
+    verified_at  TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- Any number of accounts may have typed an address; one may verify it.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_verified
+    ON user_emails (email)
+    WHERE verified_at IS NOT NULL;
+
+-- Only the SHA-256 of a token is kept. Used and expired rows stay as a
+-- record of what was sent; they are a few per user.
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id          SERIAL PRIMARY KEY,
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose     VARCHAR(20) NOT NULL,    -- password_reset | email_verify
+    token_hash  TEXT NOT NULL UNIQUE,
+    email       TEXT NOT NULL,           -- the address it was sent to
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    used_at     TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user
+    ON account_tokens (user_id, purpose)
+    WHERE used_at IS NULL;
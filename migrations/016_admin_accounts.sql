@@ -0,0 +1,60 @@
+-- Named admin accounts with roles, and the append-only log of every admin
+-- request that changed something. See admin_auth.go and admin_accounts.go.
+--
+-- Neither table references users: admins are not app users, and
+-- ReseedDatabase truncates users with CASCADE — which must not take the
+-- admin who pressed the button, or the record that they did, with it.
+
+CREATE TABLE IF NOT EXISTS admin_accounts (
+    id             SERIAL PRIMARY KEY,
+    username       TEXT NOT NULL UNIQUE,
+    password_hash  TEXT NOT NULL,            -- bcrypt, as users.password_hash
+    role           VARCHAR(20) NOT NULL,     -- viewer | moderator | experimenter | superuser
+    totp_secret    TEXT,                     -- set on enroll; used once totp_active
+    totp_active    BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Bumped on any password/role/TOTP/disable change; remembered
+    -- credentials carry the version they were checked at.
+    auth_version   INT NOT NULL DEFAULT 1,
+    created_by     TEXT NOT NULL,            -- admin username, or 'bootstrap'
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at  TIMESTAMPTZ,
+    disabled_at    TIMESTAMPTZ               -- disabled admins are kept, not deleted
+);
+
+-- Usernames and roles are copied in rather than joined, so an entry still
+-- says who it was after that admin is renamed or disabled.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id              BIGSERIAL PRIMARY KEY,
+    admin_id        INT NOT NULL,
+    admin_username  TEXT NOT NULL,
+    role            VARCHAR(20) NOT NULL,
+    permission      VARCHAR(20) NOT NULL,    -- what the route required
+    method          VARCHAR(10) NOT NULL,
+    action          TEXT NOT NULL,           -- route template, e.g. /api/v1/admin/accounts/{id}
+    path            TEXT NOT NULL,           -- the request URI as sent
+    target          JSONB,                   -- the route's path variables
+    status          INT NOT NULL,
+    ip              TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_admin
+    ON admin_audit_log (admin_username, id DESC);
+
+-- Append-only, enforced here rather than trusted to the code: the service's
+-- own role cannot rewrite or clear the log either. Clearing it on purpose
+-- means dropping these triggers in a migration, which is itself reviewed.
+CREATE OR REPLACE FUNCTION admin_audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'admin_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS admin_audit_log_no_change ON admin_audit_log;
+CREATE TRIGGER admin_audit_log_no_change
+    BEFORE UPDATE OR DELETE ON admin_audit_log
+    FOR EACH ROW EXECUTE FUNCTION admin_audit_log_append_only();
+
+DROP TRIGGER IF EXISTS admin_audit_log_no_truncate ON admin_audit_log;
+CREATE TRIGGER admin_audit_log_no_truncate
+    BEFORE TRUNCATE ON admin_audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION admin_audit_log_append_only();
@@ -0,0 +1,23 @@
+-- WebAuthn passkeys: one row per registered credential. See passkeys.go
+-- and webauthn.go.
+--
+-- Only public keys are stored, so a read of this table gives an attacker
+-- nothing to sign in with. credential_id is the authenticator's id for the
+-- passkey, base64url; sign-in looks the row up by it.
+
+CREATE TABLE IF NOT EXISTS user_passkeys (
+    id               SERIAL PRIMARY KEY,
+    user_id          INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id    TEXT NOT NULL UNIQUE,
+    public_key       BYTEA NOT NULL,          -- COSE_Key, as the authenticator sent it
+    alg              INT NOT NULL,            -- COSE algorithm: -7 ES256, -8 EdDSA, -257 RS256
+    -- Last signature counter seen. Synced passkeys always report 0; a
+    -- hardware key's must only go up.
+    sign_count       BIGINT NOT NULL DEFAULT 0,
+    aaguid           TEXT NOT NULL DEFAULT '', -- authenticator model, base64url; informational
+    backup_eligible  BOOLEAN NOT NULL DEFAULT FALSE,
+    name             TEXT NOT NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at     TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_user_passkeys_user ON user_passkeys (user_id);
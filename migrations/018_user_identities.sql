@@ -0,0 +1,20 @@
+-- External sign-in identities (Google, Apple) linked to accounts. See
+-- oidc.go.
+--
+-- An identity is the provider's subject id, never the email: addresses
+-- change and get recycled, the subject does not. email is what the provider
+-- said at link time, for display only.
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id             SERIAL PRIMARY KEY,
+    user_id        INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider       VARCHAR(20) NOT NULL,   -- google | apple
+    subject        TEXT NOT NULL,          -- the ID token's sub
+    email          TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at  TIMESTAMPTZ,
+    -- One account per identity, and one identity per provider per account.
+    -- oidc.go tells the two apart by constraint name.
+    CONSTRAINT user_identities_provider_subject_key UNIQUE (provider, subject),
+    CONSTRAINT user_identities_user_provider_key UNIQUE (user_id, provider)
+);
@@ -0,0 +1,30 @@
+-- Personal data exports: one row per archive a user asked for. See
+-- data_export.go.
+--
+-- The archive itself lives in R2 under object_key; this row is the job, its
+-- outcome, and how long the file is kept. A row that reaches 'expired' has
+-- had its file queued for deletion and stays as the record that an export
+-- was made.
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id            SERIAL PRIMARY KEY,
+    user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status        VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending | running | ready | failed | expired
+    object_key    TEXT NOT NULL,
+    size_bytes    BIGINT NOT NULL DEFAULT 0,
+    attempts      INT NOT NULL DEFAULT 0,
+    last_error    TEXT NOT NULL DEFAULT '',
+    requested_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at    TIMESTAMPTZ,             -- last claim by a worker
+    ready_at      TIMESTAMPTZ,
+    expires_at    TIMESTAMPTZ              -- set when ready; the file goes after this
+);
+CREATE INDEX IF NOT EXISTS idx_data_exports_user ON data_exports (user_id, requested_at DESC);
+
+-- At most one export in flight per user. Two taps on the button, or two
+-- devices, get the same job back instead of building the archive twice.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_one_active
+    ON data_exports (user_id) WHERE status IN ('pending', 'running');
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_queue
+    ON data_exports (id) WHERE status IN ('pending', 'running');
@@ -0,0 +1,35 @@
+-- Account deletion with a grace period. See account_delete.go.
+--
+-- Deleting an account now only deactivates it: a row here hides the account
+-- and everything it posted, and the data stays for the grace period so that
+-- signing back in can undo it. Once purge_after passes, the purge job does
+-- the hard delete the handler used to do inline, and the row goes with the
+-- users row.
+--
+-- account_purge_log is the record that a purge happened, for admin health
+-- and for answering "did my account really get deleted". It keeps only the
+-- id — the point of purging is that nothing else about the person is left —
+-- and so does not reference users, which it outlives by design.
+
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id       INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    requested_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    purge_after   TIMESTAMPTZ NOT NULL,
+    -- The purge job's claim. A worker that dies mid-purge leaves this set;
+    -- another retries once it is stale. A failed purge keeps it too, which
+    -- is what spaces out the retries.
+    claimed_at    TIMESTAMPTZ,
+    attempts      INT NOT NULL DEFAULT 0,
+    last_error    TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_account_deletions_due ON account_deletions (purge_after);
+
+CREATE TABLE IF NOT EXISTS account_purge_log (
+    id            SERIAL PRIMARY KEY,
+    user_id       INT NOT NULL,
+    requested_at  TIMESTAMPTZ NOT NULL,
+    purged_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    challenges    INT NOT NULL DEFAULT 0,     -- challenges removed with it
+    attempts      INT NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS idx_account_purge_log_purged ON account_purge_log (purged_at DESC);
@@ -0,0 +1,25 @@
+-- Private accounts and follow requests. See follow_requests.go.
+--
+-- A private account's followers are the people it approved: following one
+-- writes a row here instead of into follows, and only the owner approving it
+-- moves it across. Rejecting, or the requester changing their mind, deletes
+-- it. Both ends cascade, so a purged account takes its requests with it.
+--
+-- users.visibility has accepted 'friends' since before anything enforced it.
+-- It meant what 'private' now means, so those rows are renamed rather than
+-- left as a second spelling for every reader to remember.
+
+CREATE TABLE IF NOT EXISTS follow_requests (
+    requester_id  INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (requester_id, target_id)
+);
+-- The owner's inbox, newest first.
+CREATE INDEX IF NOT EXISTS idx_follow_requests_target ON follow_requests (target_id, created_at DESC);
+
+UPDATE users SET visibility = 'private' WHERE visibility = 'friends';
+
+-- The privacy snapshot reloads the set of private accounts every few seconds
+-- on every replica; this keeps that a scan of the few, not of everyone.
+CREATE INDEX IF NOT EXISTS idx_users_private ON users (id) WHERE visibility = 'private';
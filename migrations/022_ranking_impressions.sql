@@ -0,0 +1,34 @@
+-- The ranking log: a sample of For You pages exactly as served, and what the
+-- user did with each item. See ranking_log.go; cmd/rankeval reads it.
+--
+-- One row per served item. request_id groups the rows of one page, which is
+-- the unit the offline estimator resamples. The outcome flags start false and
+-- are set by later events; an impression nobody acted on stays all-false,
+-- which is itself the outcome.
+--
+-- Rows are pruned after 30 days, and go with the account before that.
+
+CREATE TABLE IF NOT EXISTS ranking_impressions (
+    id            BIGSERIAL PRIMARY KEY,
+    request_id    TEXT NOT NULL,
+    user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id    TEXT NOT NULL DEFAULT '',
+    cohort        VARCHAR(20) NOT NULL DEFAULT '',
+    variants      JSONB NOT NULL DEFAULT '{}',     -- experiment id → variant served
+    position      INT NOT NULL,                   -- 1-based, on the page as served
+    propensity    DOUBLE PRECISION NOT NULL,      -- positionPropensity(position)
+    content_type  VARCHAR(20) NOT NULL,
+    content_id    TEXT NOT NULL,
+    creator_id    TEXT NOT NULL DEFAULT '',
+    source        VARCHAR(40) NOT NULL DEFAULT '', -- candidate source that retrieved it
+    score         DOUBLE PRECISION NOT NULL,
+    breakdown     JSONB NOT NULL,
+    embedding     REAL[],
+    served_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed     BOOLEAN NOT NULL DEFAULT FALSE,
+    liked         BOOLEAN NOT NULL DEFAULT FALSE,
+    skipped       BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_ranking_impressions_request ON ranking_impressions (request_id);
+CREATE INDEX IF NOT EXISTS idx_ranking_impressions_served ON ranking_impressions (served_at);
+CREATE INDEX IF NOT EXISTS idx_ranking_impressions_user ON ranking_impressions (user_id);
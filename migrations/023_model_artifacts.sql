@@ -0,0 +1,26 @@
+-- Ranking models trained offline by cmd/ltrtrain. See offline_models.go.
+--
+-- One row per training run; id is the version. payload holds the per-cohort
+-- LTR (linear or boosted), watch-ratio and calibration heads in the JSON
+-- shapes the server already persists to Redis, plus the feature list they
+-- were trained on. metrics is the holdout evaluation the trainer printed.
+--
+-- Every artifact is published 'off'. Setting one to 'blend' or 'replace'
+-- makes the servers read it, within a minute, next to or instead of the
+-- online weights; the partial unique index keeps it to one live artifact.
+
+CREATE TABLE IF NOT EXISTS model_artifacts (
+    id             BIGSERIAL PRIMARY KEY,
+    name           TEXT NOT NULL DEFAULT '',
+    ltr_kind       VARCHAR(10) NOT NULL CHECK (ltr_kind IN ('linear', 'gbdt')),
+    payload        JSONB NOT NULL,
+    metrics        JSONB NOT NULL DEFAULT '{}',
+    trained_from   TIMESTAMPTZ NOT NULL,
+    trained_until  TIMESTAMPTZ NOT NULL,
+    examples       INT NOT NULL,
+    mode           VARCHAR(10) NOT NULL DEFAULT 'off' CHECK (mode IN ('off', 'blend', 'replace')),
+    blend          DOUBLE PRECISION NOT NULL DEFAULT 0.5 CHECK (blend > 0 AND blend <= 1),
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    activated_at   TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_model_artifacts_live ON model_artifacts ((mode <> 'off')) WHERE mode <> 'off';
@@ -0,0 +1,79 @@
+-- The model registry: versioned snapshots of everything the ranker learns
+-- online, which one is serving, and which one is being scored in shadow.
+-- See model_registry.go.
+--
+-- model_versions is one snapshot per row: the LTR and watch-ratio heads, the
+-- Platt fit, the per-cohort source blend, the mood-transition graph and the
+-- session-trajectory tables, as one JSON document. Per-user bandit arms are
+-- too many for one document and belong to their user, so they sit in
+-- model_version_bandits and go with the account.
+--
+-- model_registry is a single row: the version serving, the one in shadow, and
+-- a generation each replica compares with the last one it applied to know
+-- when to reload. model_promotions is the history a rollback walks back
+-- along. model_shadow_diffs holds one row per shadow-scored page.
+
+CREATE TABLE IF NOT EXISTS model_versions (
+    id                  BIGSERIAL PRIMARY KEY,
+    label               TEXT NOT NULL DEFAULT '',
+    reason              VARCHAR(20) NOT NULL
+                        CHECK (reason IN ('manual', 'scheduled', 'pre_promote', 'pre_rollback')),
+    parent_id           BIGINT REFERENCES model_versions(id) ON DELETE SET NULL, -- serving when taken
+    created_by          TEXT NOT NULL DEFAULT '',
+    snapshot            JSONB NOT NULL,
+    stats               JSONB NOT NULL DEFAULT '{}',   -- per-head sample counts, for the listing
+    bandit_users        INT NOT NULL DEFAULT 0,
+    bandits_truncated   BOOLEAN NOT NULL DEFAULT FALSE,
+    offline_artifact_id BIGINT REFERENCES model_artifacts(id) ON DELETE SET NULL, -- live when taken
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_model_versions_reason ON model_versions (reason, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS model_version_bandits (
+    version_id  BIGINT NOT NULL REFERENCES model_versions(id) ON DELETE CASCADE,
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    arms        JSONB NOT NULL,                       -- the bandit:{user} hash, field → value
+    PRIMARY KEY (version_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS model_registry (
+    id              BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    serving_version BIGINT REFERENCES model_versions(id),
+    shadow_version  BIGINT REFERENCES model_versions(id) ON DELETE SET NULL,
+    shadow_sample   DOUBLE PRECISION NOT NULL DEFAULT 0.1 CHECK (shadow_sample > 0 AND shadow_sample <= 1),
+    generation      BIGINT NOT NULL DEFAULT 0,
+    updated_by      TEXT NOT NULL DEFAULT '',
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+INSERT INTO model_registry (id) VALUES (TRUE) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS model_promotions (
+    id           BIGSERIAL PRIMARY KEY,
+    action       VARCHAR(10) NOT NULL CHECK (action IN ('promote', 'rollback')),
+    version_id   BIGINT NOT NULL REFERENCES model_versions(id),
+    previous_id  BIGINT NOT NULL REFERENCES model_versions(id), -- what was serving just before
+    rolled_back  BOOLEAN NOT NULL DEFAULT FALSE,
+    by_admin     TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS model_shadow_diffs (
+    id               BIGSERIAL PRIMARY KEY,
+    request_id       TEXT NOT NULL,
+    user_id          INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    cohort           VARCHAR(20) NOT NULL DEFAULT '',
+    serving_version  BIGINT,
+    shadow_version   BIGINT NOT NULL,
+    items            INT NOT NULL,
+    mean_abs_delta   DOUBLE PRECISION NOT NULL,       -- mean |shadow score − served score|
+    max_abs_delta    DOUBLE PRECISION NOT NULL,
+    top_overlap      DOUBLE PRECISION NOT NULL,       -- share of the top 10 both orders agree on
+    kendall_tau      DOUBLE PRECISION NOT NULL,
+    source_mix_l1    DOUBLE PRECISION NOT NULL DEFAULT 0,
+    components       JSONB NOT NULL DEFAULT '{}',     -- learned term → mean |shadow − served|
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_model_shadow_diffs_version ON model_shadow_diffs (shadow_version, created_at);
+
+-- Which registry version served each logged page.
+ALTER TABLE ranking_impressions ADD COLUMN IF NOT EXISTS model_version BIGINT;
@@ -0,0 +1,32 @@
+-- What a user has asked not to be shown. See content_preferences.go.
+--
+-- One row per user who has changed anything: muted words and phrases (stored
+-- normalised — lower case, one space between words — which is also how they
+-- are matched), muted categories, and how much reported-as-sensitive content
+-- to let through. A user with no row has the defaults: nothing muted,
+-- 'standard'.
+--
+-- Muted creators get a table of their own rather than an array column, so
+-- that a creator's account being purged takes them off every mute list the
+-- same way it leaves every follow list.
+
+CREATE TABLE IF NOT EXISTS content_preferences (
+    user_id            INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    muted_words        TEXT[] NOT NULL DEFAULT '{}',
+    muted_categories   TEXT[] NOT NULL DEFAULT '{}',
+    sensitive_content  VARCHAR(10) NOT NULL DEFAULT 'standard',
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS muted_creators (
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    creator_id  INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, creator_id)
+);
+
+-- The sensitive-content snapshot counts distinct reporters of every piece of
+-- content with a pending sexual-content or violence report, on every replica
+-- every minute; this keeps that to the reports it is about.
+CREATE INDEX IF NOT EXISTS idx_reports_pending_sensitive ON reports (target_type, target_id)
+    WHERE status = 'pending' AND reason IN ('sexual_content', 'violence');
@@ -0,0 +1,1497 @@
+package main
+
+// model_registry.go — numbered versions of everything the ranker learns
+// online, a way to score a candidate version in shadow, and a way back.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Seven things learn from live traffic and keep what they have learned in
+// Redis: the LTR and watch-ratio heads (learning_to_rank.go, watch_ratio.go),
+// the Platt calibration (calibration.go), the per-user bandit arms
+// (bandit.go), the mood-transition graph (mood_transition.go), the
+// session-trajectory tables (session_trajectory.go) and the per-cohort source
+// blend (cohort_source_blending.go). None of it had a version. When a bad
+// afternoon, a broken event producer or a label bug dragged one of them
+// somewhere wrong, the only way back was a reset* helper — back to nothing,
+// not back to last Tuesday — and nobody could say which state had served the
+// page somebody complained about.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT A VERSION IS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// A row in model_versions: all seven, copied from this replica's stores (and
+// the bandit hashes from Redis) at one moment, with who took it, why, the
+// version that was serving at the time and the offline artifact
+// (offline_models.go) that was live. Versions are taken on demand from the
+// admin API, every modelSnapshotInterval by whichever replica gets there
+// first, and automatically before anything replaces the serving state — so
+// there is always somewhere to go back to.
+//
+// Bandit arms are per user. A version keeps up to modelBanditSnapshotCap
+// users' arms in model_version_bandits, where they go with the account when
+// it is deleted; restoring a version rewrites those users' arms and leaves
+// everyone else's alone.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// PROMOTE AND ROLL BACK
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Promoting a version writes its snapshot over the live Redis keys in one
+// MULTI, so no reader sees half of it, and loads it into this replica's
+// stores. Every other replica sees the registry's generation move — at once
+// through modelRegistryChannel, within modelRegistrySyncInterval if the
+// message is lost — and loads the same snapshot from Postgres. What was
+// serving is snapshotted first (pre_promote) and recorded on the promotion;
+// rolling back restores that, and rolling back again walks on to the
+// promotion before it.
+//
+// The online heads keep learning from whatever was restored, so "serving
+// version N" means "descended from N". Observations in flight while a version
+// is applied — a few counter increments, at most one flush interval of LTR
+// weights from a replica yet to reload — land on top of it.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// SHADOW
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// One version at a time can be scored in shadow. On shadow_sample of For You
+// pages, after the page has been served, each ranked item's learned terms —
+// the LTR delta, the calibrated bonus, the watch-ratio bonus, the trajectory
+// and mood-transition bonuses — are recomputed from the candidate and swapped
+// into its score, the rest of the score held as served. The candidate's order
+// is never shown to anyone; what is kept (model_shadow_diffs) is how far it
+// moved: the mean and largest score change, each term's change, rank
+// agreement between the two scorings (Kendall's tau), how much of the top
+// modelShadowTopK survives, and how far the candidate's source mix is from
+// the one that fetched the page. The bandit and the source blend decide what
+// is fetched rather than how it is scored, so they cannot be replayed on a
+// page already fetched: the source mix is compared, the bandit only restored.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHICH VERSION SERVED
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Every For You page gets a request id, returned with the page. The last
+// modelRecentRequestsCap per user are kept with the serving version (and the
+// shadow version, when the page was shadow-scored) for /admin/diagnostics,
+// and logged pages carry it in ranking_impressions.model_version.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	modelRegistrySyncInterval   = 15 * time.Second
+	modelSnapshotInterval       = 6 * time.Hour
+	modelSnapshotCheckInterval  = 30 * time.Minute
+	modelSnapshotRetention      = 14 * 24 * time.Hour // scheduled versions nothing points at
+	modelShadowDiffRetention    = 30 * 24 * time.Hour
+	modelBanditSnapshotCap      = 100000
+	modelRegistryChannel        = "models:changed"
+	modelRecentRequestsKey      = "modelreq:" // + userID
+	modelRecentRequestsCap      = 20
+	modelRecentRequestsTTL      = 7 * 24 * time.Hour
+	modelShadowTopK             = 10
+	modelVersionBanditBatchSize = 500
+)
+
+// modelRegistryCohorts are the cohorts every per-cohort store is kept for.
+var modelRegistryCohorts = []Cohort{CohortColdStart, CohortNew, CohortEngaged, CohortPower, CohortAtRisk}
+
+// modelShadowTerms are the breakdown keys a shadow version rescores.
+var modelShadowTerms = []string{"ltrDelta", "calibBonus", "watchRatioBonus", "trajectoryBonus", "moodTransitionBonus"}
+
+var errNothingToRollBack = errors.New("no promotion to roll back")
+
+// ── Snapshots ────────────────────────────────────────────────────────────────
+
+type plattParams struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+// moodEdge is one learned mood transition: its EMA reward over Count
+// observations.
+type moodEdge struct {
+	Reward float64 `json:"r"`
+	Count  int     `json:"n"`
+}
+
+// modelSnapshot is everything one version holds. Calibration is nil when the
+// Platt fit had not converged, which restores as "unfitted".
+type modelSnapshot struct {
+	LTR         map[Cohort]*ltrModel                 `json:"ltr"`
+	WatchRatio  map[Cohort]*wrModel                  `json:"watchRatio"`
+	Calibration *plattParams                         `json:"calibration,omitempty"`
+	SourceBlend map[Cohort]map[string]float64        `json:"sourceBlend"`
+	Mood        map[string]map[string]moodEdge       `json:"mood"`
+	Trajectory  map[Cohort]map[string]map[string]int `json:"trajectory"`
+
+	// Bandit arms live in model_version_bandits, one row per user: the
+	// bandit:{user} hash as it was.
+	Bandits          map[string]map[string]string `json:"-"`
+	BanditsTruncated bool                         `json:"-"`
+}
+
+// cohortsWith is modelRegistryCohorts plus any other cohort m has, so a
+// version written by a build with a cohort this one lacks is not cut short.
+func cohortsWith[V any](m map[Cohort]V) []Cohort {
+	out := append([]Cohort(nil), modelRegistryCohorts...)
+	var extra []Cohort
+	for c := range m {
+		known := false
+		for _, k := range modelRegistryCohorts {
+			if c == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			extra = append(extra, c)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i] < extra[j] })
+	return append(out, extra...)
+}
+
+// captureModelSnapshot deep-copies this replica's learned state. Each store
+// is copied under its own lock, so the copy is consistent per store rather
+// than across them — close enough for state that moves an observation at a
+// time.
+func captureModelSnapshot(withBandits bool) (*modelSnapshot, error) {
+	ltrEnsureLoaded()
+	wrEnsureLoaded()
+	cohortBlendEnsureLoaded()
+	s := &modelSnapshot{
+		LTR:         make(map[Cohort]*ltrModel),
+		WatchRatio:  make(map[Cohort]*wrModel),
+		SourceBlend: make(map[Cohort]map[string]float64),
+		Mood:        make(map[string]map[string]moodEdge),
+		Trajectory:  make(map[Cohort]map[string]map[string]int),
+	}
+
+	ltr.mu.RLock()
+	for c, m := range ltr.byCoh {
+		if m != nil {
+			s.LTR[c] = &ltrModel{Weights: maps.Clone(m.Weights), Bias: m.Bias, Updates: m.Updates}
+		}
+	}
+	ltr.mu.RUnlock()
+
+	watchRatio.mu.RLock()
+	for c, m := range watchRatio.byCoh {
+		if m != nil {
+			cp := *m
+			cp.Weights = maps.Clone(m.Weights)
+			s.WatchRatio[c] = &cp
+		}
+	}
+	watchRatio.mu.RUnlock()
+
+	platt.mu.RLock()
+	if platt.fitted {
+		s.Calibration = &plattParams{A: platt.A, B: platt.B}
+	}
+	platt.mu.RUnlock()
+
+	cohortBlend.mu.RLock()
+	for c, m := range cohortBlend.rewards {
+		s.SourceBlend[c] = maps.Clone(m)
+	}
+	cohortBlend.mu.RUnlock()
+
+	moodTransitions.mu.RLock()
+	for from, outs := range moodTransitions.rewards {
+		for to, r := range outs {
+			if s.Mood[from] == nil {
+				s.Mood[from] = make(map[string]moodEdge)
+			}
+			s.Mood[from][to] = moodEdge{Reward: r, Count: moodTransitions.counts[from][to]}
+		}
+	}
+	moodTransitions.mu.RUnlock()
+
+	sessionTrajectories.mu.RLock()
+	states := maps.Clone(sessionTrajectories.byCoh)
+	sessionTrajectories.mu.RUnlock()
+	for c, st := range states {
+		st.mu.RLock()
+		t := make(map[string]map[string]int, len(st.transitions))
+		for from, outs := range st.transitions {
+			t[from] = maps.Clone(outs)
+		}
+		st.mu.RUnlock()
+		s.Trajectory[c] = t
+	}
+
+	if withBandits {
+		var err error
+		if s.Bandits, s.BanditsTruncated, err = captureBandits(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// captureBandits reads up to modelBanditSnapshotCap users' bandit hashes.
+// Keys for anything but a numeric user id (anonymous sessions) are skipped:
+// they cannot be tied to an account, and they expire on their own.
+func captureBandits() (map[string]map[string]string, bool, error) {
+	out := make(map[string]map[string]string)
+	if rdb == nil {
+		return out, false, nil
+	}
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(rctx, cursor, "bandit:*", 500).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, k := range keys {
+			userID := strings.TrimPrefix(k, "bandit:")
+			if _, err := strconv.Atoi(userID); err != nil {
+				continue
+			}
+			if len(out) >= modelBanditSnapshotCap {
+				return out, true, nil
+			}
+			arms, err := rdb.HGetAll(rctx, k).Result()
+			if err != nil {
+				return nil, false, err
+			}
+			if len(arms) > 0 {
+				out[userID] = arms
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return out, false, nil
+		}
+	}
+}
+
+// applyModelSnapshot replaces this replica's stores with s. It copies, so s
+// can go on being read (it may be the shadow version too).
+func applyModelSnapshot(s *modelSnapshot) {
+	// Every cohort is marked dirty so the next flush writes the restored
+	// weights back over anything a replica that had not reloaded yet flushed
+	// in the meantime.
+	ltr.mu.Lock()
+	ltr.byCoh = make(map[Cohort]*ltrModel)
+	ltr.dirty = make(map[Cohort]bool)
+	for _, c := range cohortsWith(s.LTR) {
+		m := &ltrModel{Weights: make(map[string]float64)}
+		if src := s.LTR[c]; src != nil {
+			m.Bias, m.Updates = src.Bias, src.Updates
+			maps.Copy(m.Weights, src.Weights)
+		}
+		ltr.byCoh[c] = m
+		ltr.dirty[c] = true
+	}
+	ltr.loaded = true
+	ltr.mu.Unlock()
+
+	watchRatio.mu.Lock()
+	watchRatio.byCoh = make(map[Cohort]*wrModel)
+	watchRatio.dirty = make(map[Cohort]bool)
+	for _, c := range cohortsWith(s.WatchRatio) {
+		m := &wrModel{Weights: make(map[string]float64)}
+		if src := s.WatchRatio[c]; src != nil {
+			m.Bias, m.Samples, m.MeanRatio = src.Bias, src.Samples, src.MeanRatio
+			maps.Copy(m.Weights, src.Weights)
+		}
+		watchRatio.byCoh[c] = m
+		watchRatio.dirty[c] = true
+	}
+	watchRatio.loaded = true
+	watchRatio.mu.Unlock()
+
+	// The buffered samples are logits from the heads just replaced; refitting
+	// on them would drag the restored calibration back toward those heads.
+	platt.mu.Lock()
+	platt.A, platt.B, platt.fitted = calibInitialA, calibInitialB, false
+	if s.Calibration != nil {
+		platt.A, platt.B, platt.fitted = s.Calibration.A, s.Calibration.B, true
+	}
+	platt.samples = nil
+	platt.mu.Unlock()
+
+	cohortBlend.mu.Lock()
+	cohortBlend.rewards = make(map[Cohort]map[string]float64)
+	for _, c := range cohortsWith(s.SourceBlend) {
+		m := make(map[string]float64)
+		maps.Copy(m, s.SourceBlend[c])
+		cohortBlend.rewards[c] = m
+	}
+	cohortBlend.loaded = true
+	cohortBlend.mu.Unlock()
+
+	moodTransitions.mu.Lock()
+	moodTransitions.rewards = make(map[string]map[string]float64)
+	moodTransitions.counts = make(map[string]map[string]int)
+	for from, outs := range s.Mood {
+		for to, e := range outs {
+			if moodTransitions.rewards[from] == nil {
+				moodTransitions.rewards[from] = make(map[string]float64)
+				moodTransitions.counts[from] = make(map[string]int)
+			}
+			moodTransitions.rewards[from][to] = e.Reward
+			moodTransitions.counts[from][to] = e.Count
+		}
+	}
+	moodTransitions.mu.Unlock()
+
+	byCoh := make(map[Cohort]*trajectoryState)
+	for c, t := range s.Trajectory {
+		st := newTrajectoryState()
+		for from, outs := range t {
+			st.transitions[from] = maps.Clone(outs)
+			for _, n := range outs {
+				st.fromTotals[from] += n
+			}
+		}
+		byCoh[c] = st
+	}
+	sessionTrajectories.mu.Lock()
+	sessionTrajectories.byCoh = byCoh
+	sessionTrajectories.mu.Unlock()
+}
+
+// writeModelSnapshotToRedis makes s the durable state every store loads at
+// boot, in one MULTI/EXEC. Keys a store would write are deleted where s has
+// nothing for them, so what was learned after the version was taken goes.
+func writeModelSnapshotToRedis(s *modelSnapshot) error {
+	if rdb == nil {
+		return nil
+	}
+	pipe := rdb.TxPipeline()
+	for _, c := range cohortsWith(s.LTR) {
+		if m := s.LTR[c]; m != nil {
+			js, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			pipe.Set(rctx, ltrRedisKey+string(c), js, 0)
+		} else {
+			pipe.Del(rctx, ltrRedisKey+string(c))
+		}
+	}
+	for _, c := range cohortsWith(s.WatchRatio) {
+		if m := s.WatchRatio[c]; m != nil {
+			js, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			pipe.Set(rctx, wrRedisKey+string(c), js, 0)
+		} else {
+			pipe.Del(rctx, wrRedisKey+string(c))
+		}
+	}
+	if s.Calibration != nil {
+		js, err := json.Marshal(s.Calibration)
+		if err != nil {
+			return err
+		}
+		pipe.Set(rctx, calibRedisKey, js, 30*24*time.Hour)
+	} else {
+		pipe.Del(rctx, calibRedisKey)
+	}
+	for _, c := range cohortsWith(s.SourceBlend) {
+		for src := range defaultSourceWeights {
+			key := cohortBlendRedisKey + string(c) + ":" + src
+			if r, ok := s.SourceBlend[c][src]; ok {
+				pipe.Set(rctx, key, strconv.FormatFloat(r, 'f', 4, 64), cohortBlendTTL)
+			} else {
+				pipe.Del(rctx, key)
+			}
+		}
+	}
+	// The mood graph is stored as reward sums and counts; writing the EMA
+	// back as reward·count makes loadMoodTransitions read the EMA again.
+	pipe.Del(rctx, moodTransRewardSumKey, moodTransCountKey)
+	for from, outs := range s.Mood {
+		for to, e := range outs {
+			if e.Count <= 0 {
+				continue
+			}
+			field := from + "|" + to
+			pipe.HSet(rctx, moodTransRewardSumKey, field, e.Reward*float64(e.Count))
+			pipe.HSet(rctx, moodTransCountKey, field, e.Count)
+		}
+	}
+	for _, c := range cohortsWith(s.Trajectory) {
+		key := trajRedisKeyPrefix + string(c)
+		pipe.Del(rctx, key)
+		for from, outs := range s.Trajectory[c] {
+			for to, n := range outs {
+				if n > 0 {
+					pipe.HSet(rctx, key, from+"|"+to, n)
+				}
+			}
+		}
+	}
+	for userID, arms := range s.Bandits {
+		key := "bandit:" + userID
+		pipe.Del(rctx, key)
+		if len(arms) > 0 {
+			pipe.HSet(rctx, key, arms)
+			pipe.Expire(rctx, key, 90*24*time.Hour)
+		}
+	}
+	_, err := pipe.Exec(rctx)
+	return err
+}
+
+// stats is the summary kept next to a version for the listing.
+func (s *modelSnapshot) stats() map[string]any {
+	ltrUpdates := make(map[Cohort]int)
+	for c, m := range s.LTR {
+		if m != nil {
+			ltrUpdates[c] = m.Updates
+		}
+	}
+	wrSamples := make(map[Cohort]int)
+	for c, m := range s.WatchRatio {
+		if m != nil {
+			wrSamples[c] = m.Samples
+		}
+	}
+	moodEdges := 0
+	for _, outs := range s.Mood {
+		moodEdges += len(outs)
+	}
+	transitions := 0
+	for _, t := range s.Trajectory {
+		for _, outs := range t {
+			for _, n := range outs {
+				transitions += n
+			}
+		}
+	}
+	return map[string]any{
+		"ltrUpdates":            ltrUpdates,
+		"watchRatioSamples":     wrSamples,
+		"calibrated":            s.Calibration != nil,
+		"moodEdges":             moodEdges,
+		"trajectoryTransitions": transitions,
+	}
+}
+
+// ── Persistence ──────────────────────────────────────────────────────────────
+
+// sqlRunner is what both *sql.DB and *sql.Tx offer, so a version can be
+// written inside a promotion's transaction or on its own.
+type sqlRunner interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// insertModelVersion stores s as a new version and returns its id. parent is
+// the version serving when s was taken, 0 for none.
+func insertModelVersion(q sqlRunner, s *modelSnapshot, label, reason, by string, parent int64) (int64, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return 0, err
+	}
+	stats, err := json.Marshal(s.stats())
+	if err != nil {
+		return 0, err
+	}
+	var parentArg, artifactArg any
+	if parent != 0 {
+		parentArg = parent
+	}
+	if m := currentOfflineModel(); m != nil {
+		artifactArg = m.ID
+	}
+	var id int64
+	if err := q.QueryRow(`INSERT INTO model_versions
+		(label, reason, parent_id, created_by, snapshot, stats, bandit_users, bandits_truncated, offline_artifact_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		label, reason, parentArg, by, string(raw), string(stats), len(s.Bandits), s.BanditsTruncated, artifactArg,
+	).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, insertModelVersionBandits(q, id, s.Bandits)
+}
+
+// insertModelVersionBandits writes a version's bandit arms in batches. The
+// join on users drops arms left behind by an account deleted since.
+func insertModelVersionBandits(q sqlRunner, id int64, bandits map[string]map[string]string) error {
+	users := make([]string, 0, len(bandits))
+	for u := range bandits {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	for start := 0; start < len(users); start += modelVersionBanditBatchSize {
+		batch := users[start:min(start+modelVersionBanditBatchSize, len(users))]
+		var sb strings.Builder
+		sb.WriteString(`INSERT INTO model_version_bandits (version_id, user_id, arms)
+		SELECT $1, v.user_id, v.arms FROM (VALUES `)
+		args := make([]any, 0, 2*len(batch)+1)
+		args = append(args, id)
+		for i, u := range batch {
+			arms, err := json.Marshal(bandits[u])
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "($%d::int, $%d::jsonb)", len(args)+1, len(args)+2)
+			args = append(args, u, string(arms))
+		}
+		sb.WriteString(`) AS v(user_id, arms) JOIN users u ON u.id = v.user_id`)
+		if _, err := q.Exec(sb.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadModelVersion reads a version back. Bandits are only read when asked
+// for: a replica following a promotion needs the in-process stores, and the
+// promoting replica has already written the arms to Redis.
+func loadModelVersion(id int64, withBandits bool) (*modelSnapshot, error) {
+	var raw []byte
+	var truncated bool
+	if err := db.QueryRow(`SELECT snapshot, bandits_truncated FROM model_versions WHERE id = $1`, id).
+		Scan(&raw, &truncated); err != nil {
+		return nil, err
+	}
+	s := &modelSnapshot{}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, fmt.Errorf("version %d: %w", id, err)
+	}
+	if !withBandits {
+		return s, nil
+	}
+	s.BanditsTruncated = truncated
+	s.Bandits = make(map[string]map[string]string)
+	rows, err := db.Query(`SELECT user_id, arms FROM model_version_bandits WHERE version_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var arms []byte
+		if err := rows.Scan(&userID, &arms); err != nil {
+			return nil, err
+		}
+		m := make(map[string]string)
+		if err := json.Unmarshal(arms, &m); err != nil {
+			return nil, fmt.Errorf("version %d, user %d: %w", id, userID, err)
+		}
+		s.Bandits[strconv.Itoa(userID)] = m
+	}
+	return s, rows.Err()
+}
+
+// ── Registry ─────────────────────────────────────────────────────────────────
+
+// modelRegistryState is the model_registry row. Zero versions mean none:
+// before the first promotion nothing is "serving" but what was learned.
+type modelRegistryState struct {
+	Serving      int64   `json:"servingVersion"`
+	Shadow       int64   `json:"shadowVersion"`
+	ShadowSample float64 `json:"shadowSample"`
+	Generation   int64   `json:"generation"`
+}
+
+// modelRegistryView is the registry as this replica last read it, with the
+// shadow version's snapshot loaded.
+type modelRegistryView struct {
+	modelRegistryState
+	shadow *modelSnapshot
+}
+
+var (
+	modelRegistryStore atomic.Value // *modelRegistryView
+
+	// modelRegistryMu serialises applying a version on this replica;
+	// modelRegistryApplied is the generation last applied here.
+	modelRegistryMu      sync.Mutex
+	modelRegistryApplied int64
+)
+
+// currentModelRegistry never returns nil.
+func currentModelRegistry() *modelRegistryView {
+	v, _ := modelRegistryStore.Load().(*modelRegistryView)
+	if v == nil {
+		return &modelRegistryView{}
+	}
+	return v
+}
+
+func readModelRegistryState(q sqlRunner, forUpdate bool) (modelRegistryState, error) {
+	query := `SELECT COALESCE(serving_version, 0), COALESCE(shadow_version, 0), shadow_sample, generation
+		FROM model_registry WHERE id`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var st modelRegistryState
+	err := q.QueryRow(query).Scan(&st.Serving, &st.Shadow, &st.ShadowSample, &st.Generation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, nil
+	}
+	return st, err
+}
+
+// syncModelRegistry follows the registry row. When the generation has moved
+// it loads the serving version into this replica's stores — except at boot,
+// when Redis already holds that version plus everything learned since, and
+// loading it again would throw the learning away on every deploy. A read
+// error keeps what this replica has.
+func syncModelRegistry(boot bool) {
+	if db == nil {
+		return
+	}
+	state, err := readModelRegistryState(db, false)
+	if err != nil {
+		log.Printf("model registry: reading registry: %v", err)
+		return
+	}
+	modelRegistryMu.Lock()
+	defer modelRegistryMu.Unlock()
+	if !boot && state.Generation != modelRegistryApplied && state.Serving != 0 {
+		snap, err := loadModelVersion(state.Serving, false)
+		if err != nil {
+			log.Printf("model registry: loading v%d: %v", state.Serving, err)
+			return
+		}
+		applyModelSnapshot(snap)
+		log.Printf("model registry: now serving v%d (generation %d)", state.Serving, state.Generation)
+	}
+	modelRegistryApplied = state.Generation
+	storeModelRegistryView(state)
+}
+
+// storeModelRegistryView publishes state, reusing the loaded shadow snapshot
+// when the shadow version has not changed. Callers hold modelRegistryMu.
+func storeModelRegistryView(state modelRegistryState) {
+	prev := currentModelRegistry()
+	view := &modelRegistryView{modelRegistryState: state}
+	if state.Shadow != 0 {
+		if prev.Shadow == state.Shadow && prev.shadow != nil {
+			view.shadow = prev.shadow
+		} else if snap, err := loadModelVersion(state.Shadow, false); err != nil {
+			log.Printf("model registry: loading shadow v%d: %v", state.Shadow, err)
+		} else {
+			view.shadow = snap
+		}
+	}
+	modelRegistryStore.Store(view)
+}
+
+// startModelRegistry follows the registry and takes the scheduled versions.
+func startModelRegistry() {
+	syncModelRegistry(true)
+	if multiReplica() && rdb != nil {
+		go func() {
+			sub := rdb.Subscribe(rctx, modelRegistryChannel)
+			defer sub.Close()
+			for range sub.Channel() {
+				syncModelRegistry(false)
+			}
+		}()
+	}
+	go func() {
+		t := time.NewTicker(modelRegistrySyncInterval)
+		defer t.Stop()
+		for range t.C {
+			syncModelRegistry(false)
+		}
+	}()
+	go func() {
+		t := time.NewTicker(modelSnapshotCheckInterval)
+		defer t.Stop()
+		for range t.C {
+			if db == nil {
+				continue
+			}
+			if id, err := takeScheduledModelSnapshot(); err != nil {
+				log.Printf("model registry: scheduled snapshot: %v", err)
+			} else if id != 0 {
+				log.Printf("model registry: scheduled snapshot v%d", id)
+			}
+			pruneModelRegistry()
+		}
+	}()
+}
+
+// takeModelSnapshot stores this replica's learned state as a new version.
+func takeModelSnapshot(label, by string) (int64, error) {
+	s, err := captureModelSnapshot(true)
+	if err != nil {
+		return 0, err
+	}
+	state, err := readModelRegistryState(db, false)
+	if err != nil {
+		return 0, err
+	}
+	return insertModelVersion(db, s, label, "manual", by, state.Serving)
+}
+
+// takeScheduledModelSnapshot takes the periodic version unless another
+// replica already has. The registry row lock makes the check and the insert
+// one step across replicas. Returns 0 when there was nothing to do.
+func takeScheduledModelSnapshot() (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	state, err := readModelRegistryState(tx, true)
+	if err != nil {
+		return 0, err
+	}
+	var recent bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM model_versions
+		WHERE reason = 'scheduled' AND created_at > NOW() - ($1)::interval)`,
+		fmt.Sprintf("%d seconds", int((modelSnapshotInterval-modelSnapshotCheckInterval/2).Seconds())),
+	).Scan(&recent); err != nil || recent {
+		return 0, err
+	}
+	s, err := captureModelSnapshot(true)
+	if err != nil {
+		return 0, err
+	}
+	id, err := insertModelVersion(tx, s, "", "scheduled", "scheduler", state.Serving)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// pruneModelRegistry drops old scheduled versions nothing points at, and old
+// shadow diffs. Versions taken by hand or around a promotion are kept.
+func pruneModelRegistry() {
+	res, err := db.Exec(`DELETE FROM model_versions v
+		WHERE v.reason = 'scheduled' AND v.created_at < NOW() - ($1)::interval
+		  AND NOT EXISTS (SELECT 1 FROM model_registry r WHERE r.serving_version = v.id OR r.shadow_version = v.id)
+		  AND NOT EXISTS (SELECT 1 FROM model_promotions p WHERE p.version_id = v.id OR p.previous_id = v.id)`,
+		fmt.Sprintf("%d seconds", int(modelSnapshotRetention.Seconds())))
+	if err != nil {
+		log.Printf("model registry: pruning versions: %v", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("model registry: pruned %d scheduled versions", n)
+	}
+	if _, err := db.Exec(`DELETE FROM model_shadow_diffs WHERE created_at < NOW() - ($1)::interval`,
+		fmt.Sprintf("%d seconds", int(modelShadowDiffRetention.Seconds()))); err != nil {
+		log.Printf("model registry: pruning shadow diffs: %v", err)
+	}
+}
+
+// promoteModelVersion makes id the serving version.
+func promoteModelVersion(id int64, by string) (modelRegistryState, error) {
+	return switchModelVersion(id, by, 0)
+}
+
+// rollbackModelVersion restores what was serving before the latest promotion
+// not yet rolled back.
+func rollbackModelVersion(by string) (modelRegistryState, error) {
+	var promotion, previous int64
+	err := db.QueryRow(`SELECT id, previous_id FROM model_promotions
+		WHERE action = 'promote' AND NOT rolled_back ORDER BY id DESC LIMIT 1`).Scan(&promotion, &previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return modelRegistryState{}, errNothingToRollBack
+	}
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+	return switchModelVersion(previous, by, promotion)
+}
+
+// switchModelVersion serves target: a promotion, or the rollback of
+// promotion undo when undo is not 0.
+//
+// The order matters. The current state is snapshotted and the registry
+// updated inside one transaction holding the registry row, so two admins
+// cannot interleave. Redis is written before the commit: if the commit then
+// fails, what was serving is written back, and nobody followed a generation
+// that never existed. Only after the commit is the new state applied here
+// and announced to the other replicas.
+func switchModelVersion(target int64, by string, undo int64) (modelRegistryState, error) {
+	action, preReason := "promote", "pre_promote"
+	if undo != 0 {
+		action, preReason = "rollback", "pre_rollback"
+	}
+	snap, err := loadModelVersion(target, true)
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+
+	modelRegistryMu.Lock()
+	defer modelRegistryMu.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+	defer tx.Rollback()
+	state, err := readModelRegistryState(tx, true)
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+	if undo != 0 {
+		res, err := tx.Exec(`UPDATE model_promotions SET rolled_back = TRUE WHERE id = $1 AND NOT rolled_back`, undo)
+		if err != nil {
+			return modelRegistryState{}, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return modelRegistryState{}, errNothingToRollBack // another admin got there first
+		}
+	}
+	current, err := captureModelSnapshot(true)
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+	previous, err := insertModelVersion(tx, current, fmt.Sprintf("before %s to v%d", action, target), preReason, by, state.Serving)
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+	was := state.Serving
+	state.Serving = target
+	state.Generation++
+	if state.Shadow == target {
+		state.Shadow = 0
+	}
+	var shadowArg any
+	if state.Shadow != 0 {
+		shadowArg = state.Shadow
+	}
+	if _, err := tx.Exec(`UPDATE model_registry SET serving_version = $1, shadow_version = $2,
+		generation = $3, updated_by = $4, updated_at = NOW() WHERE id`,
+		target, shadowArg, state.Generation, by); err != nil {
+		return modelRegistryState{}, err
+	}
+	if _, err := tx.Exec(`INSERT INTO model_promotions (action, version_id, previous_id, by_admin)
+		VALUES ($1, $2, $3, $4)`, action, target, previous, by); err != nil {
+		return modelRegistryState{}, err
+	}
+
+	if err := writeModelSnapshotToRedis(snap); err != nil {
+		return modelRegistryState{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		if rerr := writeModelSnapshotToRedis(current); rerr != nil {
+			log.Printf("model registry: restoring Redis after a failed %s to v%d: %v", action, target, rerr)
+		}
+		return modelRegistryState{}, err
+	}
+	applyModelSnapshot(snap)
+	modelRegistryApplied = state.Generation
+	storeModelRegistryView(state)
+	if multiReplica() && rdb != nil {
+		_ = rdb.Publish(rctx, modelRegistryChannel, strconv.FormatInt(state.Generation, 10)).Err()
+	}
+	log.Printf("model registry: %s to v%d by %s (was v%d, kept as v%d)", action, target, by, was, previous)
+	return state, nil
+}
+
+// setModelShadow points shadow scoring at version (0 stops it).
+func setModelShadow(version int64, sample float64, by string) (modelRegistryState, error) {
+	var versionArg any
+	if version != 0 {
+		versionArg = version
+	}
+	if _, err := db.Exec(`UPDATE model_registry SET shadow_version = $1,
+		shadow_sample = COALESCE($2, shadow_sample), updated_by = $3, updated_at = NOW() WHERE id`,
+		versionArg, nullableSample(sample), by); err != nil {
+		return modelRegistryState{}, err
+	}
+	state, err := readModelRegistryState(db, false)
+	if err != nil {
+		return modelRegistryState{}, err
+	}
+	modelRegistryMu.Lock()
+	storeModelRegistryView(state)
+	modelRegistryMu.Unlock()
+	return state, nil
+}
+
+func nullableSample(sample float64) any {
+	if sample == 0 {
+		return nil
+	}
+	return sample
+}
+
+// ── Shadow scoring ───────────────────────────────────────────────────────────
+
+// shadowContext is what a page's learned terms read from the request rather
+// than from the item, taken when the page was served.
+type shadowContext struct {
+	fromKey    string // trajectory bucket of the last positive engagement
+	mood       string
+	volatility float64
+}
+
+// shadowItem is one ranked item of a served page.
+type shadowItem struct {
+	contentType string
+	contentID   string
+	score       float64
+	breakdown   map[string]float64
+	toKey       string   // the item's trajectory bucket
+	emotions    []string // the item's emotions, for the mood transition
+}
+
+// learnedTerms recomputes the item's modelShadowTerms from s, the way
+// scoreForUser computes them from the live stores. A live offline artifact
+// is folded in exactly as it is for the served score, and the learned-gain
+// skill tracker is the live one: the candidate is judged as the ranker would
+// serve it today.
+func (s *modelSnapshot) learnedTerms(cohort Cohort, sc shadowContext, it shadowItem) map[string]float64 {
+	out := make(map[string]float64, len(modelShadowTerms))
+	bd := it.breakdown
+
+	var z float64
+	var samples int
+	ok := false
+	if m := s.LTR[cohort]; m != nil && m.Updates >= ltrWarmupSamples {
+		z, samples, ok = m.logit(bd), m.Updates, true
+	}
+	if z, samples, ok = offlineLTROverride(cohort, bd, z, samples, ok); ok {
+		gain := learnedGain(cohort, samples, ltrWarmupSamples)
+		out["ltrDelta"] = ltrMaxDelta * gain * math.Tanh(z)
+		out["calibBonus"] = (s.calibrate(z) - 0.5) * 0.30 * gain
+	}
+
+	var pred, center float64
+	samples, ok = 0, false
+	if m := s.WatchRatio[cohort]; m != nil && m.Samples >= wrMinSamples {
+		pred, center, samples, ok = m.predict(bd), m.MeanRatio, m.Samples, true
+	}
+	if pred, center, samples, ok = offlineWatchRatioOverride(cohort, bd, pred, center, samples, ok); ok {
+		out["watchRatioBonus"] = wrBonusFromPrediction(pred, center, samples)
+	}
+
+	if sc.fromKey != "" && it.toKey != "" {
+		outs := s.Trajectory[cohort][sc.fromKey]
+		total := 0
+		for _, n := range outs {
+			total += n
+		}
+		out["trajectoryBonus"] = trajectoryBonusFromCounts(outs[it.toKey], total)
+	}
+
+	if from, to := moodTransitionEndpoints(sc.mood, it.emotions); from != "" && to != "" {
+		e, exists := s.Mood[from][to]
+		hasLearned := exists && e.Count >= moodMinTransitions
+		reward := 0.0
+		if hasLearned {
+			reward = e.Reward
+		}
+		out["moodTransitionBonus"] = scaleMoodBonusByVolatility(
+			moodTransitionBonusFrom(from, to, reward, e.Count, hasLearned), sc.volatility)
+	}
+	return out
+}
+
+// calibrate is calibrateServedLogit with the version's Platt fit.
+func (s *modelSnapshot) calibrate(z float64) float64 {
+	if m := currentOfflineModel(); m != nil && m.Mode == offlineModeReplace && m.Calibration != nil {
+		return calibrateServedLogit(z)
+	}
+	a, b := calibInitialA, calibInitialB
+	if s.Calibration != nil {
+		a, b = s.Calibration.A, s.Calibration.B
+	}
+	return 1.0 / (1.0 + math.Exp(-(a*z + b)))
+}
+
+// shadowDiff is how far a shadow version moved one page.
+type shadowDiff struct {
+	Items        int                `json:"items"`
+	MeanAbsDelta float64            `json:"meanAbsDelta"`
+	MaxAbsDelta  float64            `json:"maxAbsDelta"`
+	TopOverlap   float64            `json:"topOverlap"`
+	KendallTau   float64            `json:"kendallTau"`
+	SourceMixL1  float64            `json:"sourceMixL1"`
+	Components   map[string]float64 `json:"components"`
+}
+
+// diffShadowPage rescores items with s and compares the two scorings.
+func diffShadowPage(s *modelSnapshot, cohort Cohort, sc shadowContext, items []shadowItem) shadowDiff {
+	d := shadowDiff{Items: len(items), Components: make(map[string]float64, len(modelShadowTerms))}
+	served := make([]float64, len(items))
+	shadow := make([]float64, len(items))
+	for i, it := range items {
+		terms := s.learnedTerms(cohort, sc, it)
+		delta := 0.0
+		for _, k := range modelShadowTerms {
+			c := terms[k] - it.breakdown[k]
+			delta += c
+			d.Components[k] += math.Abs(c)
+		}
+		served[i], shadow[i] = it.score, it.score+delta
+		d.MeanAbsDelta += math.Abs(delta)
+		d.MaxAbsDelta = math.Max(d.MaxAbsDelta, math.Abs(delta))
+	}
+	if n := float64(len(items)); n > 0 {
+		d.MeanAbsDelta /= n
+		for k := range d.Components {
+			d.Components[k] /= n
+		}
+	}
+	d.KendallTau = kendallTau(served, shadow)
+	d.TopOverlap = topOverlap(served, shadow, modelShadowTopK)
+	live, shadowMix := effectiveSourceWeights(cohort), sourceWeightsFromRewards(s.SourceBlend[cohort])
+	srcs := make([]string, 0, len(shadowMix))
+	for src := range shadowMix {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	for _, src := range srcs {
+		d.SourceMixL1 += math.Abs(shadowMix[src] - live[src])
+	}
+	return d
+}
+
+// kendallTau is rank agreement between two scorings of the same items:
+// 1 for the same order, −1 for the reverse. Pairs tied in either count as
+// neither agreeing nor disagreeing.
+func kendallTau(a, b []float64) float64 {
+	n := len(a)
+	if n < 2 {
+		return 1
+	}
+	s := 0
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			x, y := a[i]-a[j], b[i]-b[j]
+			switch {
+			case x*y > 0:
+				s++
+			case x*y < 0:
+				s--
+			}
+		}
+	}
+	return float64(s) / float64(n*(n-1)/2)
+}
+
+// topOverlap is the share of a's top k that is also in b's top k.
+func topOverlap(a, b []float64, k int) float64 {
+	k = min(k, len(a))
+	if k == 0 {
+		return 1
+	}
+	top := func(v []float64) []int {
+		idx := make([]int, len(v))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(i, j int) bool { return v[idx[i]] > v[idx[j]] })
+		return idx[:k]
+	}
+	inA := make(map[int]bool, k)
+	for _, i := range top(a) {
+		inA[i] = true
+	}
+	both := 0
+	for _, i := range top(b) {
+		if inA[i] {
+			both++
+		}
+	}
+	return float64(both) / float64(k)
+}
+
+// maybeShadowScorePage samples a served page for shadow scoring and, if it
+// is picked, scores and records it in the background. Reports whether it
+// was picked. Only ranked items are compared; injected ones have no score.
+func maybeShadowScorePage(requestID, userID string, cohort Cohort, session *SessionState, profile *UserProfile, composed []ScoredItem, view *modelRegistryView) bool {
+	if db == nil || requestID == "" || view.shadow == nil || len(composed) == 0 || rand.Float64() >= view.ShadowSample {
+		return false
+	}
+	if _, err := strconv.Atoi(userID); err != nil {
+		return false
+	}
+	var sc shadowContext
+	if session != nil {
+		sc.fromKey = lastTrajectoryFromKey(session)
+		sc.mood = session.DetectedMood
+	}
+	if profile != nil {
+		sc.volatility = profile.MoodVolatility
+	}
+	items := make([]shadowItem, 0, len(composed))
+	for _, it := range composed {
+		if it.ScoreBreakdown == nil {
+			continue
+		}
+		items = append(items, shadowItem{
+			contentType: it.Item.Type,
+			contentID:   getItemID(it.Item),
+			score:       it.Score,
+			breakdown:   it.ScoreBreakdown,
+		})
+	}
+	if len(items) == 0 {
+		return false
+	}
+	go func() {
+		for i := range items {
+			if cs := getContentScore(items[i].contentID, items[i].contentType); cs != nil {
+				items[i].toKey = trajectoryStateKey(cs.Category, cs.EnergyLevel)
+			}
+			if sc.mood != "" {
+				items[i].emotions = getContentEmotions(items[i].contentID, items[i].contentType)
+			}
+		}
+		d := diffShadowPage(view.shadow, cohort, sc, items)
+		if err := insertShadowDiff(requestID, userID, cohort, view.modelRegistryState, d); err != nil {
+			log.Printf("model registry: recording shadow diff for %s: %v", userID, err)
+		}
+	}()
+	return true
+}
+
+func insertShadowDiff(requestID, userID string, cohort Cohort, state modelRegistryState, d shadowDiff) error {
+	components, err := json.Marshal(d.Components)
+	if err != nil {
+		return err
+	}
+	var servingArg any
+	if state.Serving != 0 {
+		servingArg = state.Serving
+	}
+	_, err = db.Exec(`INSERT INTO model_shadow_diffs (request_id, user_id, cohort, serving_version, shadow_version,
+		items, mean_abs_delta, max_abs_delta, top_overlap, kendall_tau, source_mix_l1, components)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		requestID, userID, string(cohort), servingArg, state.Shadow,
+		d.Items, d.MeanAbsDelta, d.MaxAbsDelta, d.TopOverlap, d.KendallTau, d.SourceMixL1, string(components))
+	return err
+}
+
+// ── Which version served ─────────────────────────────────────────────────────
+
+// servedModelVersion is one page in a user's recent-request list.
+type servedModelVersion struct {
+	RequestID  string `json:"requestId"`
+	Serving    int64  `json:"servingVersion"`
+	Shadow     int64  `json:"shadowVersion,omitempty"`
+	Generation int64  `json:"generation"`
+	ServedAt   int64  `json:"servedAt"`
+}
+
+// noteModelVersionServed records which version served requestID. shadowed
+// says whether the page was also shadow-scored.
+func noteModelVersionServed(userID, requestID string, state modelRegistryState, shadowed bool) {
+	if rdb == nil || userID == "" || requestID == "" {
+		return
+	}
+	e := servedModelVersion{RequestID: requestID, Serving: state.Serving, Generation: state.Generation, ServedAt: time.Now().Unix()}
+	if shadowed {
+		e.Shadow = state.Shadow
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	key := modelRecentRequestsKey + userID
+	pipe := rdb.Pipeline()
+	pipe.LPush(rctx, key, raw)
+	pipe.LTrim(rctx, key, 0, modelRecentRequestsCap-1)
+	pipe.Expire(rctx, key, modelRecentRequestsTTL)
+	_, _ = pipe.Exec(rctx)
+}
+
+// recentModelVersionsServed is a user's recent pages, newest first.
+func recentModelVersionsServed(userID string) ([]servedModelVersion, error) {
+	raws, err := rdb.LRange(rctx, modelRecentRequestsKey+userID, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]servedModelVersion, 0, len(raws))
+	for _, raw := range raws {
+		var e servedModelVersion
+		if json.Unmarshal([]byte(raw), &e) == nil {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// ── Admin ────────────────────────────────────────────────────────────────────
+
+func modelRegistryAdmin(r *http.Request) string {
+	if a := currentAdmin(r); a != nil {
+		return a.Username
+	}
+	return "admin"
+}
+
+// AdminModelRegistryHandler — GET /api/v1/admin/models
+// The registry, the latest versions and the promotion history.
+func AdminModelRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	state, err := readModelRegistryState(db, false)
+	if err != nil {
+		http.Error(w, "db query failed", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.Query(`SELECT id, label, reason, COALESCE(parent_id, 0), created_by, stats,
+		bandit_users, bandits_truncated, COALESCE(offline_artifact_id, 0), created_at
+		FROM model_versions ORDER BY id DESC LIMIT 50`)
+	if err != nil {
+		http.Error(w, "db query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	versions := []map[string]any{}
+	for rows.Next() {
+		var id, parent, artifact int64
+		var label, reason, by string
+		var stats []byte
+		var banditUsers int
+		var truncated bool
+		var createdAt time.Time
+		if err := rows.Scan(&id, &label, &reason, &parent, &by, &stats, &banditUsers, &truncated, &artifact, &createdAt); err != nil {
+			http.Error(w, "db scan failed", http.StatusInternalServerError)
+			return
+		}
+		versions = append(versions, map[string]any{
+			"id": id, "label": label, "reason": reason, "parentId": parent, "createdBy": by,
+			"stats": json.RawMessage(stats), "banditUsers": banditUsers, "banditsTruncated": truncated,
+			"offlineArtifactId": artifact, "createdAt": createdAt,
+		})
+	}
+	prow, err := db.Query(`SELECT id, action, version_id, previous_id, rolled_back, by_admin, created_at
+		FROM model_promotions ORDER BY id DESC LIMIT 20`)
+	if err != nil {
+		http.Error(w, "db query failed", http.StatusInternalServerError)
+		return
+	}
+	defer prow.Close()
+	promotions := []map[string]any{}
+	for prow.Next() {
+		var id, version, previous int64
+		var action, by string
+		var rolledBack bool
+		var createdAt time.Time
+		if err := prow.Scan(&id, &action, &version, &previous, &rolledBack, &by, &createdAt); err != nil {
+			http.Error(w, "db scan failed", http.StatusInternalServerError)
+			return
+		}
+		promotions = append(promotions, map[string]any{
+			"id": id, "action": action, "versionId": version, "previousId": previous,
+			"rolledBack": rolledBack, "by": by, "createdAt": createdAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"registry":   state,
+		"versions":   versions,
+		"promotions": promotions,
+	})
+}
+
+// AdminModelSnapshotHandler — POST /api/v1/admin/models/snapshot {"label"}
+// Stores the learned state as it is on the replica that takes the request.
+func AdminModelSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var body struct {
+		Label string `json:"label"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	id, err := takeModelSnapshot(strings.TrimSpace(body.Label), modelRegistryAdmin(r))
+	if err != nil {
+		log.Printf("model registry: snapshot: %v", err)
+		http.Error(w, "snapshot failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+}
+
+// AdminModelPromoteHandler — POST /api/v1/admin/models/{id}/promote
+func AdminModelPromoteHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid version id", http.StatusBadRequest)
+		return
+	}
+	state, err := promoteModelVersion(id, modelRegistryAdmin(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such version", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("model registry: promote v%d: %v", id, err)
+		http.Error(w, "promote failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"registry": state})
+}
+
+// AdminModelRollbackHandler — POST /api/v1/admin/models/rollback
+func AdminModelRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	state, err := rollbackModelVersion(modelRegistryAdmin(r))
+	if errors.Is(err, errNothingToRollBack) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("model registry: rollback: %v", err)
+		http.Error(w, "rollback failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"registry": state})
+}
+
+// AdminModelShadowHandler — POST /api/v1/admin/models/shadow {"versionId", "sample"}
+// versionId 0 stops shadow scoring; sample, a share of pages in (0, 1],
+// keeps its current value when left out.
+func AdminModelShadowHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var body struct {
+		VersionID int64   `json:"versionId"`
+		Sample    float64 `json:"sample"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.VersionID < 0 {
+		http.Error(w, "invalid JSON (versionId required)", http.StatusBadRequest)
+		return
+	}
+	if body.Sample < 0 || body.Sample > 1 {
+		http.Error(w, "sample must be in (0, 1]", http.StatusBadRequest)
+		return
+	}
+	if body.VersionID != 0 {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM model_versions WHERE id = $1)`, body.VersionID).Scan(&exists); err != nil {
+			http.Error(w, "db query failed", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "no such version", http.StatusNotFound)
+			return
+		}
+	}
+	state, err := setModelShadow(body.VersionID, body.Sample, modelRegistryAdmin(r))
+	if err != nil {
+		log.Printf("model registry: shadow v%d: %v", body.VersionID, err)
+		http.Error(w, "db update failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"registry": state})
+}
+
+// AdminModelShadowReportHandler — GET /api/v1/admin/models/shadow?hours=24
+// The shadow version's diffs over the window, overall and per cohort.
+func AdminModelShadowReportHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	hours, _ := strconv.Atoi(r.URL.Query().Get("hours"))
+	if hours <= 0 || hours > 24*30 {
+		hours = 24
+	}
+	version, _ := strconv.ParseInt(r.URL.Query().Get("versionId"), 10, 64)
+	if version <= 0 {
+		state, err := readModelRegistryState(db, false)
+		if err != nil {
+			http.Error(w, "db query failed", http.StatusInternalServerError)
+			return
+		}
+		version = state.Shadow
+	}
+	// GROUPING SETS gives the overall row (cohort NULL) and one per cohort in
+	// one pass; the per-term means come from the components documents.
+	rows, err := db.Query(`
+		WITH d AS (
+			SELECT * FROM model_shadow_diffs
+			WHERE shadow_version = $1 AND created_at > NOW() - ($2)::interval
+		), c AS (
+			SELECT d.cohort, kv.key, AVG(kv.value::float8) AS mean
+			FROM d, jsonb_each_text(d.components) kv
+			GROUP BY GROUPING SETS ((d.cohort, kv.key), (kv.key))
+		)
+		SELECT g.cohort, g.pages, g.mean_abs, g.max_abs, g.overlap, g.tau, g.mix,
+			COALESCE((SELECT jsonb_object_agg(c.key, c.mean) FROM c
+				WHERE c.cohort IS NOT DISTINCT FROM g.cohort), '{}')
+		FROM (
+			SELECT cohort, COUNT(*) AS pages,
+				COALESCE(AVG(mean_abs_delta), 0) AS mean_abs, COALESCE(MAX(max_abs_delta), 0) AS max_abs,
+				COALESCE(AVG(top_overlap), 0) AS overlap, COALESCE(AVG(kendall_tau), 0) AS tau,
+				COALESCE(AVG(source_mix_l1), 0) AS mix
+			FROM d GROUP BY GROUPING SETS ((cohort), ())
+		) g
+		ORDER BY g.cohort NULLS FIRST`,
+		version, fmt.Sprintf("%d hours", hours))
+	if err != nil {
+		http.Error(w, "db query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var overall map[string]any
+	cohorts := map[string]any{}
+	for rows.Next() {
+		var cohort sql.NullString
+		var pages int
+		var meanAbs, maxAbs, overlap, tau, mix float64
+		var components []byte
+		if err := rows.Scan(&cohort, &pages, &meanAbs, &maxAbs, &overlap, &tau, &mix, &components); err != nil {
+			http.Error(w, "db scan failed", http.StatusInternalServerError)
+			return
+		}
+		agg := map[string]any{
+			"pages": pages, "meanAbsDelta": meanAbs, "maxAbsDelta": maxAbs,
+			"topOverlap": overlap, "kendallTau": tau, "sourceMixL1": mix,
+			"components": json.RawMessage(components),
+		}
+		if cohort.Valid {
+			cohorts[cohort.String] = agg
+		} else {
+			overall = agg
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"shadowVersion": version,
+		"hours":         hours,
+		"overall":       overall,
+		"cohorts":       cohorts,
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// resetLearnedStores empties every store a model version covers.
+func resetLearnedStores(t *testing.T) {
+	t.Helper()
+	resetRedis(t)
+	resetLTR()
+	resetWR()
+	resetPlatt()
+	resetCohortBlend()
+	resetMoodTransitions()
+	resetSessionTrajectories()
+	t.Cleanup(func() {
+		resetLTR()
+		resetWR()
+		resetPlatt()
+		resetCohortBlend()
+		resetMoodTransitions()
+		resetSessionTrajectories()
+	})
+}
+
+// seedLearnedStores gives every store something to remember.
+func seedLearnedStores() {
+	ltrEnsureLoaded()
+	wrEnsureLoaded()
+	cohortBlendEnsureLoaded()
+	ltr.mu.Lock()
+	ltr.byCoh[CohortEngaged] = &ltrModel{Weights: map[string]float64{"relevance": 1.5}, Bias: -0.2, Updates: 900}
+	ltr.mu.Unlock()
+	watchRatio.mu.Lock()
+	watchRatio.byCoh[CohortPower] = &wrModel{Weights: map[string]float64{"freshness": 0.4}, Samples: 300, MeanRatio: 0.35}
+	watchRatio.mu.Unlock()
+	platt.mu.Lock()
+	platt.A, platt.B, platt.fitted = 1.3, -0.1, true
+	platt.mu.Unlock()
+	cohortBlend.mu.Lock()
+	cohortBlend.rewards[CohortNew]["trending"] = 0.25
+	cohortBlend.mu.Unlock()
+	moodTransitions.mu.Lock()
+	moodTransitions.rewards["bored"] = map[string]float64{"funny": 0.75}
+	moodTransitions.counts["bored"] = map[string]int{"funny": 12}
+	moodTransitions.mu.Unlock()
+	st := newTrajectoryState()
+	st.transitions["comedy:high"] = map[string]int{"music:med": 6, "sports:low": 2}
+	st.fromTotals["comedy:high"] = 8
+	sessionTrajectories.mu.Lock()
+	sessionTrajectories.byCoh[CohortEngaged] = st
+	sessionTrajectories.mu.Unlock()
+}
+
+// assertSeededStores checks the stores hold what seedLearnedStores put there.
+func assertSeededStores(t *testing.T) {
+	t.Helper()
+	ltrEnsureLoaded()
+	wrEnsureLoaded()
+	cohortBlendEnsureLoaded()
+	if m := ltr.byCoh[CohortEngaged]; m == nil || m.Weights["relevance"] != 1.5 || m.Bias != -0.2 || m.Updates != 900 {
+		t.Errorf("ltr engaged = %+v", m)
+	}
+	if m := watchRatio.byCoh[CohortPower]; m == nil || m.Weights["freshness"] != 0.4 || m.Samples != 300 || m.MeanRatio != 0.35 {
+		t.Errorf("watch ratio power = %+v", m)
+	}
+	if !platt.fitted || platt.A != 1.3 || platt.B != -0.1 {
+		t.Errorf("platt = %v/%v fitted=%v", platt.A, platt.B, platt.fitted)
+	}
+	if r := cohortBlend.rewards[CohortNew]["trending"]; r != 0.25 {
+		t.Errorf("source blend new/trending = %v", r)
+	}
+	if r, n := moodTransitions.rewards["bored"]["funny"], moodTransitions.counts["bored"]["funny"]; math.Abs(r-0.75) > 1e-9 || n != 12 {
+		t.Errorf("mood bored→funny = %v over %d", r, n)
+	}
+	st := sessionTrajectories.byCoh[CohortEngaged]
+	if st == nil || st.transitions["comedy:high"]["music:med"] != 6 || st.fromTotals["comedy:high"] != 8 {
+		t.Errorf("trajectory engaged = %+v", st)
+	}
+}
+
+func TestModelSnapshotRestoresWhatWasCaptured(t *testing.T) {
+	resetLearnedStores(t)
+	seedLearnedStores()
+	snap, err := captureModelSnapshot(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drift, then restore.
+	ltr.mu.Lock()
+	ltr.byCoh[CohortEngaged].Weights["relevance"] = -3
+	ltr.mu.Unlock()
+	resetMoodTransitions()
+	resetSessionTrajectories()
+	platt.mu.Lock()
+	platt.samples = []calibSample{{X: 1, Y: 0}}
+	platt.mu.Unlock()
+
+	applyModelSnapshot(snap)
+	assertSeededStores(t)
+	if len(platt.samples) != 0 {
+		t.Error("calibration samples from the replaced heads survived the restore")
+	}
+	if !ltr.dirty[CohortEngaged] {
+		t.Error("restored LTR weights are not marked for the next flush")
+	}
+
+	// The stores must not share maps with the snapshot.
+	snap.LTR[CohortEngaged].Weights["relevance"] = 99
+	snap.Trajectory[CohortEngaged]["comedy:high"]["music:med"] = 99
+	if ltr.byCoh[CohortEngaged].Weights["relevance"] != 1.5 ||
+		sessionTrajectories.byCoh[CohortEngaged].transitions["comedy:high"]["music:med"] != 6 {
+		t.Error("editing the snapshot changed the live stores")
+	}
+}
+
+func TestWriteModelSnapshotToRedisIsWhatBootLoads(t *testing.T) {
+	resetLearnedStores(t)
+	seedLearnedStores()
+	snap, err := captureModelSnapshot(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Bandits = map[string]map[string]string{"7": {"explore_a": "3.000", "explore_b": "1.000"}}
+
+	// Learned after the version was taken: must go.
+	rdb.Set(rctx, ltrRedisKey+string(CohortAtRisk), `{"weights":{"relevance":4},"updates":50}`, 0)
+	rdb.HSet(rctx, moodTransCountKey, "sad|intense", 30)
+
+	if err := writeModelSnapshotToRedis(snap); err != nil {
+		t.Fatal(err)
+	}
+	resetLTR()
+	resetWR()
+	resetPlatt()
+	resetCohortBlend()
+	resetMoodTransitions()
+	resetSessionTrajectories()
+	plattLoad()
+	loadMoodTransitions()
+	loadSessionTrajectories()
+	assertSeededStores(t)
+
+	if m := ltr.byCoh[CohortAtRisk]; m.Updates != 0 {
+		t.Errorf("at-risk LTR kept %d updates learned after the version", m.Updates)
+	}
+	if n := moodTransitions.counts["sad"]["intense"]; n != 0 {
+		t.Errorf("mood sad→intense kept %d observations learned after the version", n)
+	}
+	arms, _ := rdb.HGetAll(rctx, "bandit:7").Result()
+	if arms["explore_a"] != "3.000" || arms["explore_b"] != "1.000" {
+		t.Errorf("bandit arms = %v", arms)
+	}
+	if ttl := rdb.TTL(rctx, "bandit:7").Val(); ttl <= 0 {
+		t.Error("restored bandit arms have no expiry")
+	}
+}
+
+// shadowPage builds a page whose served learned terms are exactly what s
+// gives, ranked by score.
+func shadowPage(s *modelSnapshot, relevances []float64) []shadowItem {
+	items := make([]shadowItem, len(relevances))
+	for i, rel := range relevances {
+		bd := map[string]float64{"relevance": rel}
+		it := shadowItem{breakdown: bd}
+		score := 1.0
+		for k, v := range s.learnedTerms(CohortEngaged, shadowContext{}, it) {
+			bd[k] = v
+			score += v
+		}
+		it.score = score
+		items[i] = it
+	}
+	return items
+}
+
+func TestDiffShadowPage(t *testing.T) {
+	resetLearnedStores(t)
+	live := &modelSnapshot{LTR: map[Cohort]*ltrModel{
+		CohortEngaged: {Weights: map[string]float64{"relevance": 2}, Updates: 100000},
+	}}
+	items := shadowPage(live, []float64{0.9, 0.6, 0.3, 0.1, -0.2, -0.5})
+
+	same := diffShadowPage(live, CohortEngaged, shadowContext{}, items)
+	if same.MeanAbsDelta != 0 || same.MaxAbsDelta != 0 || same.KendallTau != 1 || same.TopOverlap != 1 || same.SourceMixL1 != 0 {
+		t.Errorf("identical version: %+v, want no movement", same)
+	}
+
+	flipped := &modelSnapshot{LTR: map[Cohort]*ltrModel{
+		CohortEngaged: {Weights: map[string]float64{"relevance": -2}, Updates: 100000},
+	}}
+	d := diffShadowPage(flipped, CohortEngaged, shadowContext{}, items)
+	if d.KendallTau != -1 {
+		t.Errorf("tau = %v, want -1 for a head that ranks the page backwards", d.KendallTau)
+	}
+	if d.MeanAbsDelta <= 0 || d.Components["ltrDelta"] <= 0 || d.Components["watchRatioBonus"] != 0 {
+		t.Errorf("diff = %+v, want the movement attributed to the LTR terms", d)
+	}
+	if d.Items != len(items) {
+		t.Errorf("items = %d, want %d", d.Items, len(items))
+	}
+}
+
+func TestKendallTauAndTopOverlap(t *testing.T) {
+	a := []float64{4, 3, 2, 1}
+	if got := kendallTau(a, []float64{40, 30, 20, 10}); got != 1 {
+		t.Errorf("same order: tau = %v", got)
+	}
+	if got := kendallTau(a, []float64{3, 4, 2, 1}); math.Abs(got-2.0/3) > 1e-9 {
+		t.Errorf("one swap of six pairs: tau = %v, want 2/3", got)
+	}
+	if got := topOverlap(a, []float64{1, 2, 3, 4}, 2); got != 0 {
+		t.Errorf("disjoint top 2: overlap = %v", got)
+	}
+	if got := topOverlap(a, []float64{4, 1, 3, 2}, 2); got != 0.5 {
+		t.Errorf("overlap = %v, want 0.5", got)
+	}
+}
+
+func TestRollbackWithNothingPromoted(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery("FROM model_promotions").WillReturnRows(sqlmock.NewRows([]string{"id", "previous_id"}))
+	if _, err := rollbackModelVersion("ops"); !errors.Is(err, errNothingToRollBack) {
+		t.Errorf("err = %v, want errNothingToRollBack", err)
+	}
+}
+
+func TestRollbackLosesRaceToAnotherAdmin(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery("FROM model_promotions").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "previous_id"}).AddRow(4, 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT snapshot, bandits_truncated FROM model_versions")).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot", "bandits_truncated"}).AddRow([]byte(`{}`), false))
+	mock.ExpectQuery("FROM model_version_bandits").WillReturnRows(sqlmock.NewRows([]string{"user_id", "arms"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM model_registry").WillReturnRows(
+		sqlmock.NewRows([]string{"serving", "shadow", "sample", "generation"}).AddRow(3, 0, 0.1, 5))
+	mock.ExpectExec("UPDATE model_promotions SET rolled_back").WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := rollbackModelVersion("ops"); !errors.Is(err, errNothingToRollBack) {
+		t.Errorf("err = %v, want errNothingToRollBack", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModelVersionServedShowsInDiagnostics(t *testing.T) {
+	resetRedis(t)
+	state := modelRegistryState{Serving: 3, Shadow: 5, Generation: 2}
+	noteModelVersionServed("42", "aaaa", state, false)
+	noteModelVersionServed("42", "bbbb", state, true)
+
+	r := &DiagnosticsReport{Summary: map[string]string{}, ModelVersions: map[string]interface{}{}}
+	probeModelVersions(r, "42")
+	recent, ok := r.ModelVersions["recentRequests"].([]servedModelVersion)
+	if !ok || len(recent) != 2 {
+		t.Fatalf("recent requests = %v", r.ModelVersions["recentRequests"])
+	}
+	if recent[0].RequestID != "bbbb" || recent[0].Serving != 3 || recent[0].Shadow != 5 {
+		t.Errorf("newest = %+v, want bbbb served by v3 and shadowed by v5", recent[0])
+	}
+	if recent[1].Shadow != 0 {
+		t.Errorf("unshadowed page lists shadow v%d", recent[1].Shadow)
+	}
+}
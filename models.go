@@ -18,11 +18,10 @@ type User struct {
 	// nothing on others. omitempty keeps the wire format tight for
 	// the (eventually rare) no-bio case.
 	Bio string `json:"bio,omitempty"`
-	// Account visibility — "public" (default) or "friends". When
-	// "friends" the user's profile detail and challenge content
-	// should only be returned to viewers in the user's follower
-	// list. Filtering is enforced at the handler boundary so the
-	// recommender doesn't see private content either.
+	// Account visibility — "public" (default) or "private" (once
+	// spelled "friends"). A private account's profile detail and
+	// challenge content are returned only to its owner and approved
+	// followers; following it sends a request. See follow_requests.go.
 	Visibility string `json:"visibility,omitempty"`
 	// User-level settings — theme, language, etc. Free-form so we
 	// can add toggles without a schema migration per feature.
@@ -87,6 +86,34 @@ type ChatMessage struct {
 	IsEdited         bool   `json:"isEdited"`
 	IsDeleted        bool   `json:"isDeleted"`
 	CreatedAt        string `json:"createdAt"`
+
+	// Kind is "text", "image", "video", "voice" or "challenge"; for all but
+	// text, Message is an optional caption. See chat_media.go.
+	Kind       string          `json:"kind"`
+	Attachment *ChatAttachment `json:"attachment,omitempty"`
+	// ChallengeID and Challenge are a shared challenge's card. Challenge is
+	// missing if the challenge has since been deleted.
+	ChallengeID string     `json:"challengeId,omitempty"`
+	Challenge   *Challenge `json:"challenge,omitempty"`
+
+	// Per-message receipts (Status is "sent", "delivered" or "read") and
+	// reactions. See chat_receipts.go.
+	DeliveredAt string         `json:"deliveredAt,omitempty"`
+	ReadAt      string         `json:"readAt,omitempty"`
+	Reactions   []ChatReaction `json:"reactions,omitempty"`
+}
+
+// ChatReaction is one person's reaction to a chat message.
+type ChatReaction struct {
+	UserID string `json:"userId"`
+	Emoji  string `json:"emoji"`
+}
+
+// ChatAttachment is the photo, clip or voice note a chat message carries.
+type ChatAttachment struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"` // a clip's poster frame
+	DurationMs   int    `json:"durationMs,omitempty"`   // clips and voice notes
 }
 
 // Conversation represents a chat thread between two users (for the list view).
@@ -187,7 +214,7 @@ type Challenge struct {
 	ThumbnailURL   string   `json:"thumbnailUrl,omitempty"`
 	Prefix         string   `json:"prefix"`              // "Who is better", "Which is best", etc.
 	Subject        string   `json:"subject"`             // "Dancer", "Painting", etc.
-	Visibility     string   `json:"visibility"`          // "arena" or "friends"
+	Visibility     string   `json:"visibility"`          // "arena", "friends" or "direct"
 	VisibleTo      []string `json:"visibleTo,omitempty"` // friends IDs (empty = all friends)
 	Status         string   `json:"status"`              // "open", "active", "completed", "expired"
 	Likes          int      `json:"likes"`
@@ -200,6 +227,10 @@ type Challenge struct {
 	CreatedAt     string `json:"createdAt"`
 	ExpiresAt     string `json:"expiresAt"`
 	ResponseCount int    `json:"responseCount"`
+	// Set when this battle is a tournament matchup, so the client can label
+	// it ("Round 2 · Best Dancer") and link to the bracket. See tournament.go.
+	TournamentID    string `json:"tournamentId,omitempty"`
+	TournamentRound int    `json:"tournamentRound,omitempty"`
 	// Repeat says this is something the viewer has already been shown.
 	//
 	// The feed does not remove what you have seen; it ranks it down and only
@@ -330,11 +361,17 @@ type CreateChallengePayload struct {
 	ThumbnailURL  string        `json:"thumbnailUrl"`
 	Prefix        string        `json:"prefix"`
 	Subject       string        `json:"subject"`
-	Visibility    string        `json:"visibility"`  // "arena" or "friends"
-	VisibleTo     []string      `json:"visibleTo"`   // friend IDs (empty = all)
-	Category      string        `json:"category"`    // "comedy","motivation","sports","dance",etc.
-	EmotionTags   []string      `json:"emotionTags"` // ["happy","intense","inspiring"]
-	EnergyLevel   string        `json:"energyLevel"` // "low","medium","high"
+	Visibility    string        `json:"visibility"` // "arena", "friends" or "direct"
+	VisibleTo     []string      `json:"visibleTo"`  // friend IDs (empty = all)
+	// Direct challenges only (see direct_challenge.go): who is being called
+	// out, how long they have to answer (hours; default 12), and whether the
+	// challenge opens to the arena if they decline or let it lapse.
+	TargetID           string   `json:"targetId,omitempty"`
+	RespondWithinHours int      `json:"respondWithinHours,omitempty"`
+	FallbackToArena    bool     `json:"fallbackToArena,omitempty"`
+	Category           string   `json:"category"`    // "comedy","motivation","sports","dance",etc.
+	EmotionTags        []string `json:"emotionTags"` // ["happy","intense","inspiring"]
+	EnergyLevel        string   `json:"energyLevel"` // "low","medium","high"
 }
 
 // ContentCategory defines the available categories for content.
@@ -463,7 +500,10 @@ type ChallengeVote struct {
 type VoteSummary struct {
 	ResponseID string `json:"responseId"`
 	Username   string `json:"username"`
-	Votes      int    `json:"votes"`
+	Votes      int    `json:"votes"` // raw count, one per voter
+	// WeightedVotes is what the side's votes are worth after integrity
+	// weighting (vote_integrity.go) — the tally that decides the battle.
+	WeightedVotes float64 `json:"weightedVotes"`
 }
 
 // WatchEvent tracks how long a user watched a post or challenge response.
@@ -494,7 +534,7 @@ type Report struct {
 	TargetType  string `json:"targetType"` // "post", "challenge", "response", "user"
 	Reason      string `json:"reason"`
 	Description string `json:"description"`
-	Status      string `json:"status"` // "pending", "reviewed", "resolved"
+	Status      string `json:"status"` // "pending", "dismissed", "actioned" — see moderation.go
 	CreatedAt   string `json:"createdAt"`
 }
 
@@ -0,0 +1,926 @@
+package main
+
+// moderation.go — the reviewer side of reports.
+//
+// HandleReportEvent has always written reports with status 'pending', and
+// until this file nothing read them back: the only thing a report did was
+// count against the target's trust multiplier (engagement_quality.go). This
+// is the queue a reviewer works through, what each decision does, and the
+// record of who decided what.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE QUEUE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Reports are grouped by what they are about — ten people reporting one video
+// is one item to review, not ten. Items are ordered by:
+//
+//  1. Severity. Anything reported for a reason isHardBlockReason calls severe
+//     (abuse, harassment, hate, threats, sexual content, violence) comes
+//     before everything that isn't.
+//  2. Velocity: distinct reporters in the last 24 hours. Something drawing
+//     reports right now is still being seen right now. Distinct, so one
+//     person reporting again does not move an item up.
+//  3. Total distinct reporters.
+//  4. Age — oldest first, so nothing waits forever behind a busy day.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// DECISIONS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	dismiss  nothing wrong. The reports close as 'dismissed'.
+//	hide     the content is taken down: out of the For You and explore feeds
+//	         and out of search. A hidden battle response is also is_hidden,
+//	         the flag every battle query already respects.
+//	strike       a warning against the owner that expires on its own.
+//	             moderationStrikeLimit unexpired strikes suspend them.
+//	suspend      the owner cannot sign in or use a session they already
+//	             hold, and their content and account drop out of feeds and
+//	             search, until the suspension ends.
+//	posting_ban  the owner can still watch, chat and vote, but not post.
+//	limit        the owner's content reaches their followers and nobody
+//	             else — see enforcement.go for all three restrictions.
+//
+// Every decision resolves all pending reports on its target at once, is
+// written to moderation_actions (never updated, never deleted), and tells
+// each reporter their report was looked at — without telling them what
+// happened to someone else's account beyond "we took action".
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// HOW THE FEED FINDS OUT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Hidden content and suspended accounts are held in memory as a snapshot,
+// reloaded every moderationSyncInterval and updated on the spot on the
+// replica that made a decision. The feed, explore and search drop anything in
+// it as they assemble a response — a map lookup per item, no query. Other
+// replicas catch up within one sync interval.
+//
+// Accounts their owner deleted ride in the same snapshot: during the grace
+// period they are hidden exactly as a suspended one is. See
+// account_delete.go.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// moderationSyncInterval is how often the hidden/suspended snapshot is
+	// reloaded, and so how long another replica can lag a decision.
+	moderationSyncInterval = 15 * time.Second
+	// Strikes: each expires after moderationStrikeLifetime, and this many
+	// unexpired at once suspend the owner for moderationStrikeSuspension.
+	moderationStrikeLimit      = 3
+	moderationStrikeLifetime   = 90 * 24 * time.Hour
+	moderationStrikeSuspension = 7 * 24 * time.Hour
+	// moderationDefaultRestriction applies when a suspend, posting ban or
+	// limit names no length.
+	moderationDefaultRestriction = 7 * 24 * time.Hour
+	// moderationQueueMax bounds one page of the queue.
+	moderationQueueMax = 200
+)
+
+// Decisions.
+const (
+	moderationDismiss = "dismiss"
+	moderationHide    = "hide"
+	moderationStrike  = "strike"
+	moderationSuspend = "suspend"
+	// A posting ban stops new challenges and responses; a limit keeps the
+	// owner's content to their followers. See enforcement.go.
+	moderationPostingBan = "posting_ban"
+	moderationLimit      = "limit"
+)
+
+// moderationTargetTypes is what a report can be about (ReportPayload).
+var moderationTargetTypes = map[string]bool{
+	"challenge": true, "post": true, "response": true, "user": true,
+}
+
+var (
+	errModerationBadAction   = errors.New("action must be dismiss, hide, strike, suspend, posting_ban or limit")
+	errModerationBadTarget   = errors.New("targetType must be challenge, post, response or user")
+	errModerationNotFound    = errors.New("the reported content or account no longer exists")
+	errModerationNoHide      = errors.New("an account can't be hidden; strike or suspend it")
+	errModerationNothingOpen = errors.New("there are no pending reports to dismiss")
+)
+
+// ModerationQueueItem is one target with pending reports.
+type ModerationQueueItem struct {
+	TargetType      string   `json:"targetType"`
+	TargetID        string   `json:"targetId"`
+	Reports         int      `json:"reports"`
+	Reporters       int      `json:"reporters"`
+	ReportersLast24 int      `json:"reportersLast24h"`
+	Reasons         []string `json:"reasons"`
+	Severe          bool     `json:"severe"`
+	FirstReportedAt string   `json:"firstReportedAt"`
+	LastReportedAt  string   `json:"lastReportedAt"`
+	first           time.Time
+}
+
+// ModerationAction is one row of the audit log.
+type ModerationAction struct {
+	ID              string `json:"id"`
+	TargetType      string `json:"targetType"`
+	TargetID        string `json:"targetId"`
+	SubjectUserID   string `json:"subjectUserId,omitempty"`
+	Action          string `json:"action"`
+	Reason          string `json:"reason,omitempty"`
+	Reviewer        string `json:"reviewer"`
+	Note            string `json:"note"`
+	ReportIDs       []int  `json:"reportIds"`
+	Restriction     string `json:"restriction,omitempty"`     // what the decision imposed, if anything
+	RestrictedUntil string `json:"restrictedUntil,omitempty"` // RFC 3339, or "indefinite"
+	CreatedAt       string `json:"createdAt"`
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE QUEUE
+// ════════════════════════════════════════════════════════════════════════════════
+
+// reportsForTargetSQL matches reports on a target. Reports with no type were
+// accepted as content reports (HandleReportEvent), so they count as challenge
+// reports here.
+const reportsForTargetSQL = `(target_type = $1 OR ($1 = 'challenge' AND target_type = '')) AND target_id = $2`
+
+// sortModerationQueue puts the queue in review order (see the header).
+func sortModerationQueue(items []ModerationQueueItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Severe != b.Severe {
+			return a.Severe
+		}
+		if a.ReportersLast24 != b.ReportersLast24 {
+			return a.ReportersLast24 > b.ReportersLast24
+		}
+		if a.Reporters != b.Reporters {
+			return a.Reporters > b.Reporters
+		}
+		return a.first.Before(b.first)
+	})
+}
+
+// moderationQueue returns targets with pending reports, in review order.
+func moderationQueue(limit int) ([]ModerationQueueItem, error) {
+	rows, err := db.Query(`
+		SELECT CASE WHEN target_type = '' THEN 'challenge' ELSE target_type END AS tt,
+		       target_id, COUNT(*), COUNT(DISTINCT reporter_id),
+		       COUNT(DISTINCT reporter_id) FILTER (WHERE created_at > NOW() - INTERVAL '24 hours'),
+		       array_agg(DISTINCT reason), MIN(created_at), MAX(created_at)
+		FROM reports
+		WHERE status = 'pending'
+		GROUP BY tt, target_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ModerationQueueItem{}
+	for rows.Next() {
+		var it ModerationQueueItem
+		var targetID int
+		var last time.Time
+		if err := rows.Scan(&it.TargetType, &targetID, &it.Reports, &it.Reporters, &it.ReportersLast24,
+			pq.Array(&it.Reasons), &it.first, &last); err != nil {
+			continue
+		}
+		it.TargetID = strconv.Itoa(targetID)
+		for _, reason := range it.Reasons {
+			if isHardBlockReason(reason) {
+				it.Severe = true
+			}
+		}
+		it.FirstReportedAt = it.first.UTC().Format(time.RFC3339)
+		it.LastReportedAt = last.UTC().Format(time.RFC3339)
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortModerationQueue(items)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// DECISIONS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// moderationDecision is what a reviewer decided about one target.
+type moderationDecision struct {
+	targetType string
+	targetID   int
+	action     string
+	reviewer   string
+	note       string
+	// reason is the machine-readable why a restricted user is shown
+	// (enforcement.go); "" is restrictReasonDefault.
+	reason string
+	// restrictFor is how long a suspend, posting ban or limit lasts; 0
+	// means until further notice.
+	restrictFor time.Duration
+}
+
+// validate checks the decision makes sense for its target.
+func (d moderationDecision) validate() error {
+	if !moderationTargetTypes[d.targetType] {
+		return errModerationBadTarget
+	}
+	switch d.action {
+	case moderationDismiss, moderationStrike, moderationSuspend, moderationPostingBan, moderationLimit:
+	case moderationHide:
+		if d.targetType == "user" {
+			return errModerationNoHide
+		}
+	default:
+		return errModerationBadAction
+	}
+	return nil
+}
+
+// moderationSubject returns who a target belongs to: the account itself, or
+// whoever posted the content.
+func moderationSubject(tx *sql.Tx, targetType string, targetID int) (int, error) {
+	var q string
+	switch targetType {
+	case "user":
+		q = `SELECT id FROM users WHERE id = $1`
+	case "challenge":
+		q = `SELECT creator_id FROM challenges WHERE id = $1`
+	case "post":
+		q = `SELECT author_id FROM posts WHERE id = $1`
+	case "response":
+		q = `SELECT responder_id FROM challenge_responses WHERE id = $1`
+	default:
+		return 0, errModerationBadTarget
+	}
+	var uid int
+	if err := tx.QueryRow(q, targetID).Scan(&uid); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errModerationNotFound
+		}
+		return 0, err
+	}
+	return uid, nil
+}
+
+// applyModerationDecision records a decision and carries it out. Returns the
+// audit row and the reporters to tell.
+func applyModerationDecision(d moderationDecision) (ModerationAction, []int, error) {
+	if err := d.validate(); err != nil {
+		return ModerationAction{}, nil, err
+	}
+	if d.reason == "" {
+		d.reason = restrictReasonDefault
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return ModerationAction{}, nil, err
+	}
+	defer tx.Rollback()
+
+	subject, err := moderationSubject(tx, d.targetType, d.targetID)
+	if err != nil && !(errors.Is(err, errModerationNotFound) && d.action == moderationDismiss) {
+		return ModerationAction{}, nil, err
+	}
+
+	// The pending reports this decision closes, locked so two reviewers
+	// deciding the same item can't both close them.
+	rows, err := tx.Query(`SELECT id, reporter_id FROM reports
+		WHERE status = 'pending' AND `+reportsForTargetSQL+` FOR UPDATE`, d.targetType, d.targetID)
+	if err != nil {
+		return ModerationAction{}, nil, err
+	}
+	var reportIDs []int
+	reporters := map[int]bool{}
+	for rows.Next() {
+		var id, reporter int
+		if rows.Scan(&id, &reporter) == nil {
+			reportIDs = append(reportIDs, id)
+			reporters[reporter] = true
+		}
+	}
+	rows.Close()
+	if d.action == moderationDismiss && len(reportIDs) == 0 {
+		return ModerationAction{}, nil, errModerationNothingOpen
+	}
+
+	// What the decision restricts, if anything. A strike that reaches the
+	// limit suspends as well.
+	now := time.Now()
+	var restrict *accountRestriction
+	if kind := restrictionForAction[d.action]; kind != "" {
+		restrict = &accountRestriction{Kind: kind, Reason: d.reason, Until: restrictionEnd(now, d.restrictFor)}
+	}
+	if d.action == moderationStrike {
+		var active int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM account_strikes
+			WHERE user_id = $1 AND expires_at > NOW() AND lifted_at IS NULL`, subject).Scan(&active); err != nil {
+			return ModerationAction{}, nil, err
+		}
+		if active+1 >= moderationStrikeLimit {
+			restrict = &accountRestriction{Kind: restrictSuspended, Reason: restrictReasonStrikes,
+				Until: now.Add(moderationStrikeSuspension)}
+		}
+	}
+	var untilArg interface{}
+	if restrict != nil {
+		untilArg = restrict.untilArg()
+	}
+	var subjectArg interface{}
+	if subject != 0 {
+		subjectArg = subject
+	}
+
+	var actionID int
+	var createdAt time.Time
+	if err := tx.QueryRow(`
+		INSERT INTO moderation_actions
+			(target_type, target_id, subject_user_id, action, reviewer, note, report_ids, suspended_until, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`,
+		d.targetType, d.targetID, subjectArg, d.action, d.reviewer, d.note,
+		pq.Array(reportIDs), untilArg, d.reason).Scan(&actionID, &createdAt); err != nil {
+		return ModerationAction{}, nil, err
+	}
+
+	resolution := "actioned"
+	if d.action == moderationDismiss {
+		resolution = "dismissed"
+	}
+	if len(reportIDs) > 0 {
+		if _, err := tx.Exec(`UPDATE reports
+			SET status = $1, resolution = $2, resolved_at = NOW(), action_id = $3
+			WHERE id = ANY($4)`, resolution, d.action, actionID, pq.Array(reportIDs)); err != nil {
+			return ModerationAction{}, nil, err
+		}
+	}
+
+	if d.action == moderationHide {
+		if _, err := tx.Exec(`INSERT INTO moderation_hidden (target_type, target_id, action_id)
+			VALUES ($1, $2, $3) ON CONFLICT (target_type, target_id) DO NOTHING`,
+			d.targetType, d.targetID, actionID); err != nil {
+			return ModerationAction{}, nil, err
+		}
+		if d.targetType == "response" {
+			if _, err := tx.Exec(`UPDATE challenge_responses SET is_hidden = TRUE WHERE id = $1`, d.targetID); err != nil {
+				return ModerationAction{}, nil, err
+			}
+		}
+	}
+	if d.action == moderationStrike {
+		if _, err := tx.Exec(`INSERT INTO account_strikes (user_id, action_id, reason, expires_at)
+			VALUES ($1, $2, $3, $4)`, subject, actionID, d.reason, now.Add(moderationStrikeLifetime)); err != nil {
+			return ModerationAction{}, nil, err
+		}
+	}
+	if restrict != nil {
+		restrict.ActionID = strconv.Itoa(actionID)
+		if err := upsertRestriction(tx, subject, *restrict); err != nil {
+			return ModerationAction{}, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ModerationAction{}, nil, err
+	}
+
+	a := ModerationAction{
+		ID:         strconv.Itoa(actionID),
+		TargetType: d.targetType,
+		TargetID:   strconv.Itoa(d.targetID),
+		Action:     d.action,
+		Reason:     d.reason,
+		Reviewer:   d.reviewer,
+		Note:       d.note,
+		ReportIDs:  reportIDs,
+		CreatedAt:  createdAt.UTC().Format(time.RFC3339),
+	}
+	if subject != 0 {
+		a.SubjectUserID = strconv.Itoa(subject)
+	}
+	if restrict != nil {
+		a.Restriction = restrict.Kind
+		a.RestrictedUntil = restrict.untilText()
+	}
+	if a.ReportIDs == nil {
+		a.ReportIDs = []int{}
+	}
+
+	// This replica stops serving it now; the others at their next sync.
+	if d.action == moderationHide {
+		moderationMarkHidden(d.targetType, a.TargetID)
+	}
+	if restrict != nil {
+		moderationMarkRestricted(a.SubjectUserID, *restrict)
+	}
+
+	ids := make([]int, 0, len(reporters))
+	for id := range reporters {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return a, ids, nil
+}
+
+// notifyReportersResolved tells each reporter their report was reviewed.
+func notifyReportersResolved(a ModerationAction, reporters []int) {
+	msg := "Thanks for your report. We reviewed it and took action."
+	if a.Action == moderationDismiss {
+		msg = "Thanks for your report. We reviewed it and didn't find a violation of our guidelines."
+	}
+	for _, id := range reporters {
+		uid := strconv.Itoa(id)
+		if u, ok := GetUserByID(uid); ok {
+			deliverNotification(u.Username, Notification{
+				Type:      "report_resolved",
+				Message:   msg,
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+		if _, _, err := enqueueNotification(EnqueueParams{
+			UserID:      uid,
+			TriggerKind: TriggerReportResolved,
+			DedupeKey:   fmt.Sprintf("rr:%s", a.ID),
+			Title:       "Your report was reviewed",
+			Body:        msg,
+			Deeplink:    "devf://notifications",
+		}); err != nil {
+			log.Printf("moderation action %s: queueing push for %s: %v", a.ID, uid, err)
+		}
+	}
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SNAPSHOT
+// ════════════════════════════════════════════════════════════════════════════════
+
+// moderationState is what the feed, search and auth filter on.
+type moderationState struct {
+	hidden map[string]bool // "type:id"
+	// restrictions is user id → kind → the restriction in force.
+	restrictions map[string]map[string]accountRestriction
+	// deactivated is the accounts inside their deletion grace period.
+	deactivated map[string]bool
+}
+
+func newModerationState() *moderationState {
+	return &moderationState{
+		hidden:       map[string]bool{},
+		restrictions: map[string]map[string]accountRestriction{},
+		deactivated:  map[string]bool{},
+	}
+}
+
+// restrict records a restriction in the snapshot.
+func (s *moderationState) restrict(userID string, r accountRestriction) {
+	if s.restrictions[userID] == nil {
+		s.restrictions[userID] = map[string]accountRestriction{}
+	}
+	s.restrictions[userID][r.Kind] = r
+}
+
+// moderationStore holds the current *moderationState.
+var moderationStore atomic.Value
+
+func currentModeration() *moderationState {
+	s, _ := moderationStore.Load().(*moderationState)
+	return s
+}
+
+// moderationUpdate swaps in a copy of the snapshot with change applied.
+func moderationUpdate(change func(*moderationState)) {
+	old := currentModeration()
+	next := newModerationState()
+	if old != nil {
+		for k, v := range old.hidden {
+			next.hidden[k] = v
+		}
+		for u, byKind := range old.restrictions {
+			for _, r := range byKind {
+				next.restrict(u, r)
+			}
+		}
+		for u := range old.deactivated {
+			next.deactivated[u] = true
+		}
+	}
+	change(next)
+	moderationStore.Store(next)
+}
+
+func moderationMarkHidden(targetType, targetID string) {
+	moderationUpdate(func(s *moderationState) { s.hidden[targetType+":"+targetID] = true })
+}
+
+// moderationMarkRestricted records a restriction on this replica, keeping a
+// longer one already in force.
+func moderationMarkRestricted(userID string, r accountRestriction) {
+	moderationUpdate(func(s *moderationState) {
+		if cur, ok := s.restrictions[userID][r.Kind]; ok && cur.outlasts(r) {
+			return
+		}
+		s.restrict(userID, r)
+	})
+}
+
+// moderationMarkDeactivated records on this replica that an account was
+// deleted (on) or restored or purged (off).
+func moderationMarkDeactivated(userID string, on bool) {
+	moderationUpdate(func(s *moderationState) {
+		if on {
+			s.deactivated[userID] = true
+		} else {
+			delete(s.deactivated, userID)
+		}
+	})
+}
+
+// moderationRestriction returns the restriction of a kind in force on a user
+// right now, if any.
+func moderationRestriction(userID, kind string) (accountRestriction, bool) {
+	s := currentModeration()
+	if s == nil || userID == "" {
+		return accountRestriction{}, false
+	}
+	r, ok := s.restrictions[userID][kind]
+	if !ok || !r.activeAt(time.Now()) {
+		return accountRestriction{}, false
+	}
+	return r, true
+}
+
+// moderationSuspended reports whether a user is suspended right now.
+func moderationSuspended(userID string) bool {
+	_, ok := moderationRestriction(userID, restrictSuspended)
+	return ok
+}
+
+// accountDeactivated reports whether a user deleted their account and is
+// inside the grace period.
+func accountDeactivated(userID string) bool {
+	s := currentModeration()
+	return s != nil && s.deactivated[userID]
+}
+
+// accountHidden reports whether an account must not be shown to anyone:
+// suspended, or deleted by its owner.
+func accountHidden(userID string) bool {
+	return moderationSuspended(userID) || accountDeactivated(userID)
+}
+
+// moderationHides reports whether a piece of content must not be served:
+// taken down itself, or posted by a hidden account.
+func moderationHides(contentType, contentID, ownerID string) bool {
+	s := currentModeration()
+	if s == nil {
+		return false
+	}
+	if s.hidden[contentType+":"+contentID] {
+		return true
+	}
+	return accountHidden(ownerID)
+}
+
+// dropModeratedItems removes hidden content from a feed candidate list.
+func dropModeratedItems(items []HomeFeedItem) []HomeFeedItem {
+	if currentModeration() == nil {
+		return items
+	}
+	out := items[:0]
+	for _, it := range items {
+		if (it.Challenge != nil || it.Post != nil) && moderationHides(it.Type, getItemID(it), getItemCreatorID(it)) {
+			continue
+		}
+		out = append(out, it)
+	}
+	return out
+}
+
+// moderationChallengeSQLFilter is dropModeratedItems as a WHERE fragment
+// for a challenges table aliased alias, for a query that paginates in SQL
+// and so can't drop rows afterwards. Its placeholders start at $next. Empty
+// when nothing is hidden.
+func moderationChallengeSQLFilter(alias string, next int) (string, []interface{}) {
+	s := currentModeration()
+	if s == nil {
+		return "", nil
+	}
+	var challenges, owners []int64
+	for key := range s.hidden {
+		if id, ok := strings.CutPrefix(key, "challenge:"); ok {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				challenges = append(challenges, n)
+			}
+		}
+	}
+	for id := range s.deactivated {
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			owners = append(owners, n)
+		}
+	}
+	for id := range s.restrictions {
+		if !moderationSuspended(id) {
+			continue
+		}
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			owners = append(owners, n)
+		}
+	}
+	if len(challenges) == 0 && len(owners) == 0 {
+		return "", nil
+	}
+	sort.Slice(challenges, func(i, j int) bool { return challenges[i] < challenges[j] })
+	sort.Slice(owners, func(i, j int) bool { return owners[i] < owners[j] })
+	clause := fmt.Sprintf(`
+		AND NOT (%[1]s.id = ANY($%[2]d))
+		AND NOT (%[1]s.creator_id = ANY($%[3]d))`, alias, next, next+1)
+	return clause, []interface{}{pq.Array(challenges), pq.Array(owners)}
+}
+
+// loadModerationState replaces the snapshot from the database. On error the
+// previous snapshot stays: a blip must not put hidden content back.
+func loadModerationState() {
+	if db == nil {
+		return
+	}
+	next := newModerationState()
+	rows, err := db.Query(`SELECT target_type, target_id FROM moderation_hidden`)
+	if err != nil {
+		log.Printf("moderation: loading hidden content (keeping previous snapshot): %v", err)
+		return
+	}
+	for rows.Next() {
+		var tt string
+		var id int
+		if rows.Scan(&tt, &id) == nil {
+			next.hidden[tt+":"+strconv.Itoa(id)] = true
+		}
+	}
+	rows.Close()
+
+	restrictions, err := loadRestrictions(`WHERE until > NOW()`)
+	if err != nil {
+		log.Printf("moderation: loading account restrictions (keeping previous snapshot): %v", err)
+		return
+	}
+	for userID, rs := range restrictions {
+		for _, r := range rs {
+			next.restrict(userID, r)
+		}
+	}
+
+	rows, err = db.Query(`SELECT user_id FROM account_deletions`)
+	if err != nil {
+		log.Printf("moderation: loading deactivated accounts (keeping previous snapshot): %v", err)
+		return
+	}
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			next.deactivated[strconv.Itoa(id)] = true
+		}
+	}
+	rows.Close()
+	moderationStore.Store(next)
+}
+
+// startModerationSync loads the snapshot, then keeps it fresh. Called from
+// main() after InitDatabase.
+func startModerationSync() {
+	loadModerationState()
+	go func() {
+		t := time.NewTicker(moderationSyncInterval)
+		defer t.Stop()
+		for range t.C {
+			loadModerationState()
+		}
+	}()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS (admin)
+// ════════════════════════════════════════════════════════════════════════════════
+
+// moderationReviewer names the reviewer for the audit log: the signed-in
+// admin account (see adminOnly).
+func moderationReviewer(r *http.Request) string {
+	if a := currentAdmin(r); a != nil {
+		return a.Username
+	}
+	return "admin"
+}
+
+// AdminModerationQueueHandler — GET /api/v1/admin/moderation/queue?limit=N
+func AdminModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	limit := parseIntOrDefault(r.URL.Query().Get("limit"), 50, moderationQueueMax)
+	items, err := moderationQueue(limit)
+	if err != nil {
+		http.Error(w, "queue unavailable: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// AdminModerationTargetHandler — GET /api/v1/admin/moderation/targets/{type}/{id}
+// Every report on the target, pending or not, and every decision about it.
+func AdminModerationTargetHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	vars := mux.Vars(r)
+	targetID, err := strconv.Atoi(vars["id"])
+	if err != nil || !moderationTargetTypes[vars["type"]] {
+		http.Error(w, "unknown target", http.StatusBadRequest)
+		return
+	}
+	rows, err := db.Query(`
+		SELECT id, reporter_id, reason, COALESCE(description, ''), status, created_at
+		FROM reports WHERE `+reportsForTargetSQL+`
+		ORDER BY created_at DESC LIMIT 500`, vars["type"], targetID)
+	if err != nil {
+		http.Error(w, "lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	reports := []Report{}
+	for rows.Next() {
+		var id, reporter int
+		var rep Report
+		var created time.Time
+		if rows.Scan(&id, &reporter, &rep.Reason, &rep.Description, &rep.Status, &created) != nil {
+			continue
+		}
+		rep.ID, rep.ReporterID = strconv.Itoa(id), strconv.Itoa(reporter)
+		rep.TargetID, rep.TargetType = vars["id"], vars["type"]
+		rep.CreatedAt = created.UTC().Format(time.RFC3339)
+		reports = append(reports, rep)
+	}
+	actions, err := listModerationActions(`WHERE target_type = $1 AND target_id = $2`, 500, vars["type"], targetID)
+	if err != nil {
+		http.Error(w, "lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"reports": reports, "actions": actions})
+}
+
+// AdminModerationDecideHandler — POST /api/v1/admin/moderation/targets/{type}/{id}/decide
+// body:{ action, note, reason, days } — days is how long a suspend, posting
+// ban or limit lasts: 0 (or absent) is moderationDefaultRestriction, -1 until
+// further notice. reason is one of restrictReasons, shown to the user.
+func AdminModerationDecideHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	vars := mux.Vars(r)
+	targetID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "unknown target", http.StatusBadRequest)
+		return
+	}
+	var p struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+		Reason string `json:"reason"`
+		Days   int    `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if p.Reason != "" && !restrictReasons[p.Reason] {
+		http.Error(w, "unknown reason", http.StatusBadRequest)
+		return
+	}
+	d := moderationDecision{
+		targetType:  vars["type"],
+		targetID:    targetID,
+		action:      p.Action,
+		reviewer:    moderationReviewer(r),
+		note:        truncateText(p.Note, 1000),
+		reason:      p.Reason,
+		restrictFor: moderationDefaultRestriction,
+	}
+	switch {
+	case p.Days < 0:
+		d.restrictFor = 0
+	case p.Days > 0:
+		d.restrictFor = time.Duration(p.Days) * 24 * time.Hour
+	}
+
+	a, reporters, err := applyModerationDecision(d)
+	switch {
+	case errors.Is(err, errModerationBadAction), errors.Is(err, errModerationBadTarget), errors.Is(err, errModerationNoHide):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errModerationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errModerationNothingOpen):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "decision failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("moderation: %s %s %s:%s (action %s, %d reports)",
+		a.Reviewer, a.Action, a.TargetType, a.TargetID, a.ID, len(a.ReportIDs))
+
+	// The ranker's cached view of this content and its owner predates the
+	// decision.
+	if a.TargetType == "challenge" || a.TargetType == "post" {
+		go invalidateContentEmbedding(a.TargetID)
+	}
+	if a.SubjectUserID != "" {
+		go invalidateEngagementQuality(a.SubjectUserID)
+	}
+	go notifyReportersResolved(a, reporters)
+	writeJSON(w, http.StatusOK, a)
+}
+
+// AdminModerationAuditHandler — GET /api/v1/admin/moderation/audit?reviewer=&userId=&limit=N
+// The decision log, newest first.
+func AdminModerationAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	limit := parseIntOrDefault(q.Get("limit"), 100, 500)
+	actions, err := listModerationActions(`
+		WHERE ($1 = '' OR reviewer = $1)
+		  AND ($2 = '' OR CAST(subject_user_id AS TEXT) = $2)`, limit, q.Get("reviewer"), q.Get("userId"))
+	if err != nil {
+		http.Error(w, "lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"actions": actions})
+}
+
+// listModerationActions reads up to limit audit rows matching where, newest
+// first.
+func listModerationActions(where string, limit int, args ...interface{}) ([]ModerationAction, error) {
+	rows, err := db.Query(`
+		SELECT id, target_type, target_id, COALESCE(subject_user_id, 0), action, COALESCE(reason, ''),
+		       reviewer, note, report_ids,
+		       CASE WHEN suspended_until = 'infinity' THEN 'indefinite'
+		            ELSE to_char(suspended_until AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') END,
+		       created_at
+		FROM moderation_actions `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT `+strconv.Itoa(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ModerationAction{}
+	for rows.Next() {
+		var a ModerationAction
+		var id, targetID, subject int
+		var reportIDs pq.Int64Array
+		var until sql.NullString
+		var created time.Time
+		if err := rows.Scan(&id, &a.TargetType, &targetID, &subject, &a.Action, &a.Reason,
+			&a.Reviewer, &a.Note, &reportIDs, &until, &created); err != nil {
+			continue
+		}
+		a.ID, a.TargetID = strconv.Itoa(id), strconv.Itoa(targetID)
+		if subject != 0 {
+			a.SubjectUserID = strconv.Itoa(subject)
+		}
+		a.ReportIDs = make([]int, len(reportIDs))
+		for i, rid := range reportIDs {
+			a.ReportIDs[i] = int(rid)
+		}
+		if until.Valid {
+			a.Restriction = restrictionForAction[a.Action]
+			if a.Action == moderationStrike {
+				a.Restriction = restrictSuspended
+			}
+			a.RestrictedUntil = until.String
+		}
+		a.CreatedAt = created.UTC().Format(time.RFC3339)
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withModerationState swaps in a snapshot for the duration of a test.
+func withModerationState(t *testing.T, s *moderationState) {
+	t.Helper()
+	prev := currentModeration()
+	moderationStore.Store(s)
+	t.Cleanup(func() {
+		if prev == nil {
+			prev = newModerationState()
+		}
+		moderationStore.Store(prev)
+	})
+}
+
+func TestModerationQueueOrder(t *testing.T) {
+	now := time.Now()
+	items := []ModerationQueueItem{
+		{TargetID: "old-spam", Reporters: 2, first: now.Add(-48 * time.Hour)},
+		{TargetID: "busy-spam", Reporters: 3, ReportersLast24: 3, first: now.Add(-time.Hour)},
+		{TargetID: "harassment", Severe: true, Reporters: 1, first: now},
+		{TargetID: "older-spam", Reporters: 2, first: now.Add(-72 * time.Hour)},
+		{TargetID: "hate", Severe: true, Reporters: 4, ReportersLast24: 2, first: now},
+	}
+	sortModerationQueue(items)
+	want := []string{"hate", "harassment", "busy-spam", "older-spam", "old-spam"}
+	for i, id := range want {
+		if items[i].TargetID != id {
+			got := make([]string, len(items))
+			for j, it := range items {
+				got[j] = it.TargetID
+			}
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestModerationDecisionValidate(t *testing.T) {
+	ok := []moderationDecision{
+		{targetType: "challenge", action: moderationHide},
+		{targetType: "response", action: moderationHide},
+		{targetType: "user", action: moderationSuspend},
+		{targetType: "post", action: moderationDismiss},
+	}
+	for _, d := range ok {
+		if err := d.validate(); err != nil {
+			t.Errorf("%s on %s refused: %v", d.action, d.targetType, err)
+		}
+	}
+	if err := (moderationDecision{targetType: "user", action: moderationHide}).validate(); err != errModerationNoHide {
+		t.Errorf("hiding an account: %v", err)
+	}
+	if err := (moderationDecision{targetType: "comment", action: moderationHide}).validate(); err != errModerationBadTarget {
+		t.Errorf("unknown target: %v", err)
+	}
+	if err := (moderationDecision{targetType: "user", action: "ban"}).validate(); err != errModerationBadAction {
+		t.Errorf("unknown action: %v", err)
+	}
+}
+
+// Hidden content and a suspended creator's content leave the feed; the rest,
+// and cards that aren't content, stay.
+func TestDropModeratedItems(t *testing.T) {
+	s := newModerationState()
+	s.hidden["challenge:2"] = true
+	s.restrict("bad", accountRestriction{Kind: restrictSuspended})                                          // indefinite
+	s.restrict("expired", accountRestriction{Kind: restrictSuspended, Until: time.Now().Add(-time.Minute)}) // over
+	withModerationState(t, s)
+	items := []HomeFeedItem{
+		{Type: "challenge", Challenge: &Challenge{ID: "1", CreatorID: "ok"}},
+		{Type: "challenge", Challenge: &Challenge{ID: "2", CreatorID: "ok"}},
+		{Type: "challenge", Challenge: &Challenge{ID: "3", CreatorID: "bad"}},
+		{Type: "challenge", Challenge: &Challenge{ID: "4", CreatorID: "expired"}},
+		{Type: "suggestedAccounts", SuggestedAccounts: &SuggestedAccountsCard{}},
+	}
+	got := dropModeratedItems(items)
+	var ids []string
+	for _, it := range got {
+		ids = append(ids, getItemID(it)+"/"+it.Type)
+	}
+	if len(got) != 3 || getItemID(got[0]) != "1" || getItemID(got[1]) != "4" || got[2].Type != "suggestedAccounts" {
+		t.Errorf("kept %v", ids)
+	}
+}
+
+func TestModerationChallengeSQLFilter(t *testing.T) {
+	withModerationState(t, newModerationState())
+	if clause, args := moderationChallengeSQLFilter("c", 7); clause != "" || args != nil {
+		t.Fatalf("nothing hidden: clause %q, args %v", clause, args)
+	}
+
+	s := newModerationState()
+	s.hidden["challenge:2"] = true
+	s.hidden["post:3"] = true
+	s.deactivated["41"] = true
+	s.restrict("42", accountRestriction{Kind: restrictSuspended})
+	s.restrict("43", accountRestriction{Kind: restrictSuspended, Until: time.Now().Add(-time.Minute)})
+	s.restrict("44", accountRestriction{Kind: restrictPostingBan})
+	withModerationState(t, s)
+
+	clause, args := moderationChallengeSQLFilter("c", 7)
+	if !strings.Contains(clause, "c.id = ANY($7)") || !strings.Contains(clause, "c.creator_id = ANY($8)") || len(args) != 2 {
+		t.Fatalf("clause %q with %d args", clause, len(args))
+	}
+	if got := fmt.Sprint(args[0], args[1]); got != "&[2] &[41 42]" {
+		t.Errorf("args = %s, want hidden challenge 2 and hidden owners 41, 42", got)
+	}
+}
+
+func TestAuthedRejectsSuspendedAccount(t *testing.T) {
+	s := newModerationState()
+	s.restrict("u_banned", accountRestriction{Kind: restrictSuspended, Until: time.Now().Add(time.Hour)})
+	withModerationState(t, s)
+	h := authed(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for user, want := range map[string]int{"u_banned": http.StatusForbidden, "u_fine": http.StatusOK} {
+		tok, _ := issueToken(user, user)
+		req := httptest.NewRequest("GET", "/x", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", user, w.Code, want)
+		}
+	}
+}
+
+// Hiding a reported challenge closes its reports, records the decision,
+// takes it out of the feed here and now, and names the reporters to tell.
+func TestApplyModerationHide(t *testing.T) {
+	withModerationState(t, newModerationState())
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT creator_id FROM challenges")).WithArgs(77).
+		WillReturnRows(sqlmock.NewRows([]string{"creator_id"}).AddRow(9))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, reporter_id FROM reports")).WithArgs("challenge", 77).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reporter_id"}).AddRow(1, 4).AddRow(2, 5).AddRow(3, 4))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO moderation_actions")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(12, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE reports")).
+		WithArgs("actioned", moderationHide, 12, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO moderation_hidden")).WithArgs("challenge", 77, 12).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	a, reporters, err := applyModerationDecision(moderationDecision{
+		targetType: "challenge", targetID: 77, action: moderationHide, reviewer: "rev",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SubjectUserID != "9" || len(a.ReportIDs) != 3 || a.Restriction != "" {
+		t.Errorf("action = %+v", a)
+	}
+	if len(reporters) != 2 || reporters[0] != 4 || reporters[1] != 5 {
+		t.Errorf("reporters = %v, want [4 5]", reporters)
+	}
+	if !moderationHides("challenge", "77", "9") {
+		t.Error("hidden challenge still served on this replica")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// The strike that reaches the limit suspends as well.
+func TestApplyModerationStrikeEscalates(t *testing.T) {
+	withModerationState(t, newModerationState())
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users")).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, reporter_id FROM reports")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reporter_id"}).AddRow(8, 4))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM account_strikes")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(moderationStrikeLimit - 1))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO moderation_actions")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(13, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE reports")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO account_strikes")).
+		WithArgs(9, 13, restrictReasonDefault, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO account_restrictions")).
+		WithArgs(9, restrictSuspended, sqlmock.AnyArg(), restrictReasonStrikes, "13").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	a, _, err := applyModerationDecision(moderationDecision{
+		targetType: "user", targetID: 9, action: moderationStrike, reviewer: "rev",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Restriction != restrictSuspended || !moderationSuspended("9") {
+		t.Errorf("third strike did not suspend: %+v", a)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -204,24 +204,8 @@ func loadMoodTransitions() {
 // Seed gives a mild bonus when learned data is sparse; learned overrides
 // once we have enough observations to trust it.
 func moodTransitionBonus(currentMood string, contentEmotions []string) float64 {
-	if currentMood == "" || len(contentEmotions) == 0 {
-		return 0
-	}
-	from := strings.ToLower(currentMood)
-
-	// Determine the dominant mood signaled by the content's emotions —
-	// take the first non-empty emotion as the candidate "to" mood. (More
-	// sophisticated approaches could weight by emotion intensity; first-
-	// emotion is a reasonable proxy that doesn't depend on intensities
-	// being calibrated.)
-	to := ""
-	for _, e := range contentEmotions {
-		if e != "" {
-			to = strings.ToLower(e)
-			break
-		}
-	}
-	if to == "" {
+	from, to := moodTransitionEndpoints(currentMood, contentEmotions)
+	if from == "" || to == "" {
 		return 0
 	}
 
@@ -240,7 +224,33 @@ func moodTransitionBonus(currentMood string, contentEmotions []string) float64 {
 		}
 	}
 	moodTransitions.mu.RUnlock()
+	return moodTransitionBonusFrom(from, to, emaReward, count, hasLearned)
+}
 
+// moodTransitionEndpoints is the (from, to) pair a candidate is judged on.
+// Either is "" when there is nothing to judge.
+func moodTransitionEndpoints(currentMood string, contentEmotions []string) (from, to string) {
+	if currentMood == "" || len(contentEmotions) == 0 {
+		return "", ""
+	}
+	from = strings.ToLower(currentMood)
+
+	// Determine the dominant mood signaled by the content's emotions —
+	// take the first non-empty emotion as the candidate "to" mood. (More
+	// sophisticated approaches could weight by emotion intensity; first-
+	// emotion is a reasonable proxy that doesn't depend on intensities
+	// being calibrated.)
+	for _, e := range contentEmotions {
+		if e != "" {
+			return from, strings.ToLower(e)
+		}
+	}
+	return from, ""
+}
+
+// moodTransitionBonusFrom blends the seed prior for from→to with what has
+// been learned about it: emaReward over count observations, when hasLearned.
+func moodTransitionBonusFrom(from, to string, emaReward float64, count int, hasLearned bool) float64 {
 	// Seed signal: bonus if toMood is in the healthy-next prior list.
 	seedBonus := 0.0
 	if next, ok := moodHealthyNext[from]; ok {
@@ -274,6 +284,24 @@ func moodTransitionBonus(currentMood string, contentEmotions []string) float64 {
 	return seedBonus
 }
 
+// scaleMoodBonusByVolatility personalises a mood-transition bonus.
+// MoodVolatility consumption: a moody user (high engagement variance across
+// sessions) benefits more from mood-aware sequencing, so amplify the
+// mood-transition signal for them and damp it for steady users. Bounded
+// 0.85x..1.15x. (This dim was computed+persisted but unused.)
+func scaleMoodBonusByVolatility(bonus, volatility float64) float64 {
+	bonus *= 0.85 + 0.30*volatility
+	// Re-clamp to the declared ceiling — the 1.15x amplification can push a
+	// maxed ±moodTransitionMaxBonus past the bound it was clamped to inside
+	// moodTransitionBonus.
+	if bonus > moodTransitionMaxBonus {
+		bonus = moodTransitionMaxBonus
+	} else if bonus < -moodTransitionMaxBonus {
+		bonus = -moodTransitionMaxBonus
+	}
+	return bonus
+}
+
 // recordSessionMoodOutcome is called at session end (or on app_background)
 // to record the reward of the most recent mood transition. The "reward"
 // is derived from the session's final state: high engagement = positive,
@@ -94,7 +94,10 @@ func HandleSetNotificationPrefs(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	var p NotificationPrefs
+	// Decode over the current prefs, so a toggle the client doesn't know
+	// about yet (an older app build that predates it) keeps its value
+	// instead of being saved as false.
+	p := loadNotificationPrefs(authUserID(r))
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 		http.Error(w, "invalid body", http.StatusBadRequest)
 		return
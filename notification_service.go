@@ -23,6 +23,55 @@ func SendFollowNotification(payload FollowEventPayload) {
 	deliverNotification(recipientUsername, notification)
 }
 
+// SendFollowRequestNotification tells the owner of a private account that
+// someone asked to follow it: in-app, and as a push pointing at the inbox.
+func SendFollowRequestNotification(requesterID, targetID string) {
+	requester, ok1 := GetUserByID(requesterID)
+	target, ok2 := GetUserByID(targetID)
+	if !ok1 || !ok2 {
+		return
+	}
+	deliverNotification(target.Username, Notification{
+		Type:      "follow_request",
+		Message:   fmt.Sprintf("%s wants to follow you.", requester.Username),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      targetID,
+		TriggerKind: TriggerFollowRequest,
+		DedupeKey:   fmt.Sprintf("fr:%s:%s", requesterID, targetID),
+		Title:       fmt.Sprintf("@%s wants to follow you", requester.Username),
+		Body:        "Approve or reject their request.",
+		Deeplink:    "devf://follow-requests",
+	}); err != nil {
+		log.Printf("follow request %s→%s: queueing push: %v", requesterID, targetID, err)
+	}
+}
+
+// SendFollowAcceptedNotification tells a requester they were let in.
+func SendFollowAcceptedNotification(targetID, requesterID string) {
+	requester, ok1 := GetUserByID(requesterID)
+	target, ok2 := GetUserByID(targetID)
+	if !ok1 || !ok2 {
+		return
+	}
+	deliverNotification(requester.Username, Notification{
+		Type:      "follow_accepted",
+		Message:   fmt.Sprintf("%s accepted your follow request.", target.Username),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      requesterID,
+		TriggerKind: TriggerFollowAccepted,
+		DedupeKey:   fmt.Sprintf("fa:%s:%s", requesterID, targetID),
+		Title:       fmt.Sprintf("@%s accepted your follow request", target.Username),
+		Body:        "You can now see their challenges and battles.",
+		Deeplink:    fmt.Sprintf("devf://user/%s", target.Username),
+	}); err != nil {
+		log.Printf("follow accepted %s→%s: queueing push: %v", requesterID, targetID, err)
+	}
+}
+
 // SendLikeNotification sends a notification when someone likes a post.
 func SendLikeNotification(likerUsername, postAuthorUsername, caption string) {
 	// Truncate caption for display
@@ -102,6 +151,79 @@ func SendChallengeAcceptedNotification(responderUsername, challengerUsername, ch
 	deliverNotification(challengerUsername, notification)
 }
 
+// SendDirectChallengeNotification tells the target of a direct challenge
+// they have been called out: in-app straight away, and as a push (their
+// direct_challenge pref permitting) because a call-out with a deadline is no
+// use to someone who has not opened the app.
+func SendDirectChallengeNotification(creatorUsername, targetID, challengeID, challengeTitle string, respondBy time.Time) {
+	target, found := GetUserByID(targetID)
+	if !found {
+		return
+	}
+	hours := int(time.Until(respondBy).Round(time.Hour).Hours())
+	deliverNotification(target.Username, Notification{
+		Type:      "direct_challenge",
+		Message:   fmt.Sprintf("%s challenged you: \"%s\" — you have %dh to answer", creatorUsername, challengeTitle, hours),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      targetID,
+		TriggerKind: TriggerDirectChallenge,
+		DedupeKey:   fmt.Sprintf("dc:%s", challengeID),
+		Title:       fmt.Sprintf("@%s called you out", creatorUsername),
+		Body:        truncateText(fmt.Sprintf("\"%s\" — accept within %dh", challengeTitle, hours), 120),
+		Deeplink:    fmt.Sprintf("devf://challenge/%s", challengeID),
+	}); err != nil {
+		log.Printf("direct challenge %s: queueing push: %v", challengeID, err)
+	}
+}
+
+// SendDirectChallengeAcceptedNotification tells the challenger their
+// call-out was answered. The in-app half is the ordinary accepted
+// notification; the push is what a direct challenge adds.
+func SendDirectChallengeAcceptedNotification(responderUsername, challengerID, challengerUsername, challengeID, challengeTitle string) {
+	SendChallengeAcceptedNotification(responderUsername, challengerUsername, challengeTitle)
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      challengerID,
+		TriggerKind: TriggerDirectChallenge,
+		DedupeKey:   fmt.Sprintf("dca:%s", challengeID),
+		Title:       fmt.Sprintf("@%s accepted your challenge", responderUsername),
+		Body:        truncateText(fmt.Sprintf("\"%s\" — the battle is on", challengeTitle), 120),
+		Deeplink:    fmt.Sprintf("devf://challenge/%s", challengeID),
+	}); err != nil {
+		log.Printf("direct challenge %s: queueing push: %v", challengeID, err)
+	}
+}
+
+// SendDirectChallengeLapsedNotification tells the challenger their call-out
+// was declined or expired, and what became of the challenge.
+func SendDirectChallengeLapsedNotification(challengerID, challengerUsername, targetUsername, challengeID, challengeTitle, outcome string, toArena bool) {
+	what := "declined"
+	if outcome == "expired" {
+		what = "didn't answer"
+	}
+	next := "it has expired."
+	if toArena {
+		next = "it's now open to everyone in the arena."
+	}
+	msg := fmt.Sprintf("%s %s your challenge \"%s\" — %s", targetUsername, what, challengeTitle, next)
+	deliverNotification(challengerUsername, Notification{
+		Type:      "direct_challenge_" + outcome,
+		Message:   msg,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if _, _, err := enqueueNotification(EnqueueParams{
+		UserID:      challengerID,
+		TriggerKind: TriggerDirectChallenge,
+		DedupeKey:   fmt.Sprintf("dcl:%s", challengeID),
+		Title:       fmt.Sprintf("@%s %s your challenge", targetUsername, what),
+		Body:        truncateText(msg, 120),
+		Deeplink:    fmt.Sprintf("devf://challenge/%s", challengeID),
+	}); err != nil {
+		log.Printf("direct challenge %s: queueing push: %v", challengeID, err)
+	}
+}
+
 // SendVoteNotification notifies the response owner that someone voted for them.
 func SendVoteNotification(payload ChallengeVotePayload) {
 	// Get the response to find the owner
@@ -115,6 +237,18 @@ func SendVoteNotification(payload ChallengeVotePayload) {
 		return
 	}
 
+	// A vote for the challenger's own video goes to the challenger.
+	if payload.ResponseID == creatorVoteSide {
+		if challenge.CreatorID != payload.VoterID {
+			deliverNotification(challenge.CreatorUsername, Notification{
+				Type:      "vote",
+				Message:   fmt.Sprintf("%s voted for you in \"%s %s\"", voter.Username, challenge.Prefix, challenge.Subject),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+		return
+	}
+
 	// Find the response owner from the responses list
 	responses := GetChallengeResponses(payload.ChallengeID)
 	for _, resp := range responses {
@@ -132,19 +266,32 @@ func SendVoteNotification(payload ChallengeVotePayload) {
 
 // deliverNotification is a helper that sends a notification to a user
 // (directly if online — on any replica — stored for later if offline).
+// Every notification also goes into the user's event log, so an app that
+// reconnects with ?since= gets whatever it missed (ws_events.go); the
+// stored list is for app builds that predate the log, and a replay that
+// missed nothing clears it.
 func deliverNotification(recipientUsername string, notification Notification) {
 	notificationJSON, _ := json.Marshal(notification)
-	if wsDeliver(recipientUsername, notificationJSON) {
+	seq, delivered := wsDeliverLoggedEvent(recipientUsername, notificationJSON)
+	if delivered {
 		log.Printf("User %s is ONLINE. Sent notification.", recipientUsername)
 		return
 	}
 	log.Printf("User %s is OFFLINE. Storing notification.", recipientUsername)
 	StoreNotificationInRedis(recipientUsername, notification)
+	if seq == 0 {
+		// Not in the log, so no replay will carry it.
+		wsMarkUnlogged(recipientUsername)
+	}
 }
 
 // SendStoredNotifications is called when a user connects via WebSocket
 // It retrieves any stored notifications from our mock Redis and sends them to the user.
-func SendStoredNotifications(username string) {
+//
+// connID is the connection that just opened. The backlog goes to it alone:
+// the user's other devices, if any, were online while it built up and have
+// already had every one of these live.
+func SendStoredNotifications(username, connID string) {
 	notifications, found := GetStoredNotifications(username)
 
 	if !found || len(notifications) == 0 {
@@ -158,7 +305,7 @@ func SendStoredNotifications(username string) {
 	// right primitive (no relay — the user just connected here).
 	for _, notification := range notifications {
 		notificationJSON, _ := json.Marshal(notification)
-		if !wsSendLocal(username, notificationJSON) {
+		if wsSendLocal(username, notificationJSON, connID) == 0 {
 			log.Printf("Error sending stored notification to %s (disconnected mid-flush)", username)
 		} else {
 			log.Printf("Successfully sent stored notification to %s", username)
@@ -89,6 +89,11 @@ func scanFriendResponseTrigger() {
 		if err := rows.Scan(&challengerID, &challengeID, &subject, &responderID, &responderUsername, &responseID); err != nil {
 			continue
 		}
+		// Someone the challenger muted doesn't get pushed at them. See
+		// content_preferences.go.
+		if loadContentPrefs(challengerID).mutesCreator(responderID) {
+			continue
+		}
 		_, _, _ = enqueueNotification(EnqueueParams{
 			UserID:      challengerID,
 			TriggerKind: TriggerFriendResponse,
@@ -109,21 +114,21 @@ func scanEndingSoonTrigger() {
 		return
 	}
 	// Notify each user who has submitted to a challenge whose voting window
-	// closes within endingSoonWindow. The voting close is approximated as
-	// `created_at + interval '7 days'` (your existing convention); production
-	// schemas with explicit voting_ends_at columns should swap that in.
+	// closes within endingSoonWindow. Voting closes at created_at +
+	// challengeLifetime — the same deadline battle_resolution.go settles on —
+	// so "ending soon" means the resolver really is about to call it.
 	rows, err := db.Query(`
 		SELECT DISTINCT
 			r.responder_id::text  AS user_id,
 			c.id::text            AS challenge_id,
 			c.subject             AS subject,
-			(c.created_at + INTERVAL '7 days') AS ends_at
+			(c.created_at + ($2)::interval) AS ends_at
 		FROM challenge_responses r
 		JOIN challenges c ON r.challenge_id = c.id
-		WHERE c.status IN ('open', 'active')
-		  AND (c.created_at + INTERVAL '7 days') BETWEEN NOW() AND NOW() + INTERVAL '1 hour'
+		WHERE c.status = 'active' AND c.resolved_at IS NULL
+		  AND (c.created_at + ($2)::interval) BETWEEN NOW() AND NOW() + INTERVAL '1 hour'
 		LIMIT $1
-	`, triggerScanLimit)
+	`, triggerScanLimit, fmt.Sprintf("%d seconds", int(challengeLifetime.Seconds())))
 	if err != nil {
 		return
 	}
@@ -224,6 +229,10 @@ func scanYouWillLoveTrigger() {
 			if cnt, _ := rdb.ZScore(rctx, seenKeyPrefix+uid, cand.Type+":"+cand.ID).Result(); cnt > 0 {
 				continue
 			}
+			// Or if it is something they muted. See content_preferences.go.
+			if contentMutedFor(uid, cand.Type, cand.ID, cs.CreatorID) {
+				continue
+			}
 			_, _, _ = enqueueNotification(EnqueueParams{
 				UserID:      uid,
 				TriggerKind: TriggerYouWillLove,
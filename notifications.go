@@ -24,7 +24,7 @@ import (
 //   - hot-swap senders (FCM/APNs/log) without touching trigger code
 // ─────────────────────────────────────────────────────────────────────────────
 
-// TriggerKind enumerates the four notification reasons we currently send.
+// TriggerKind enumerates the notification reasons we currently send.
 // New triggers add to this list and to NotificationPrefs as a per-trigger
 // boolean column.
 type TriggerKind string
@@ -34,6 +34,17 @@ const (
 	TriggerEndingSoon      TriggerKind = "ending_soon"
 	TriggerYouWillLove     TriggerKind = "you_will_love"
 	TriggerInactiveWinback TriggerKind = "inactive_winback"
+	// Someone called you out directly, or answered (or ducked) your
+	// call-out. See direct_challenge.go.
+	TriggerDirectChallenge TriggerKind = "direct_challenge"
+	// A report you made was reviewed. See moderation.go.
+	TriggerReportResolved TriggerKind = "report_resolved"
+	// The personal data export you asked for is ready. See data_export.go.
+	TriggerDataExport TriggerKind = "data_export"
+	// Someone asked to follow your private account, or the private account
+	// you asked to follow let you in. See follow_requests.go.
+	TriggerFollowRequest  TriggerKind = "follow_request"
+	TriggerFollowAccepted TriggerKind = "follow_accepted"
 )
 
 // NotificationPrefs is the user's per-trigger opt-out + rate-limit settings.
@@ -43,6 +54,7 @@ type NotificationPrefs struct {
 	EndingSoon       bool   `json:"endingSoon"`
 	YouWillLove      bool   `json:"youWillLove"`
 	InactiveWinback  bool   `json:"inactiveWinback"`
+	DirectChallenge  bool   `json:"directChallenge"`
 	QuietHoursStart  int    `json:"quietHoursStart"`  // 0-23, local hour
 	QuietHoursEnd    int    `json:"quietHoursEnd"`
 	MaxPerDay        int    `json:"maxPerDay"`
@@ -58,6 +70,7 @@ func defaultNotificationPrefs(userID string) NotificationPrefs {
 		EndingSoon:       true,
 		YouWillLove:      true,
 		InactiveWinback:  true,
+		DirectChallenge:  true,
 		QuietHoursStart:  22,
 		QuietHoursEnd:    8,
 		MaxPerDay:        4,
@@ -75,6 +88,16 @@ func (p NotificationPrefs) allowedByPrefs(kind TriggerKind) bool {
 		return p.YouWillLove
 	case TriggerInactiveWinback:
 		return p.InactiveWinback
+	case TriggerDirectChallenge:
+		return p.DirectChallenge
+	case TriggerReportResolved, TriggerDataExport:
+		// The answer to something the user asked us to do, not a nudge —
+		// there is no opt-out column for it.
+		return true
+	case TriggerFollowRequest, TriggerFollowAccepted:
+		// Someone waiting on a decision only the user can make, and the
+		// answer to a request the user made. No opt-out column either.
+		return true
 	}
 	return false
 }
@@ -107,10 +130,11 @@ func loadNotificationPrefs(userID string) NotificationPrefs {
 	p.UserID = userID
 	err := db.QueryRow(`
 		SELECT friend_response, ending_soon, you_will_love, inactive_winback,
+		       COALESCE(direct_challenge, TRUE),
 		       quiet_hours_start, quiet_hours_end, max_per_day
 		FROM notification_prefs WHERE user_id = $1
 	`, userID).Scan(&p.FriendResponse, &p.EndingSoon, &p.YouWillLove, &p.InactiveWinback,
-		&p.QuietHoursStart, &p.QuietHoursEnd, &p.MaxPerDay)
+		&p.DirectChallenge, &p.QuietHoursStart, &p.QuietHoursEnd, &p.MaxPerDay)
 	if err != nil {
 		return defaultNotificationPrefs(userID)
 	}
@@ -125,19 +149,20 @@ func saveNotificationPrefs(p NotificationPrefs) error {
 	_, err := db.Exec(`
 		INSERT INTO notification_prefs
 			(user_id, friend_response, ending_soon, you_will_love, inactive_winback,
-			 quiet_hours_start, quiet_hours_end, max_per_day, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+			 direct_challenge, quiet_hours_start, quiet_hours_end, max_per_day, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
 		ON CONFLICT (user_id) DO UPDATE SET
 			friend_response  = EXCLUDED.friend_response,
 			ending_soon      = EXCLUDED.ending_soon,
 			you_will_love    = EXCLUDED.you_will_love,
 			inactive_winback = EXCLUDED.inactive_winback,
+			direct_challenge = EXCLUDED.direct_challenge,
 			quiet_hours_start = EXCLUDED.quiet_hours_start,
 			quiet_hours_end  = EXCLUDED.quiet_hours_end,
 			max_per_day      = EXCLUDED.max_per_day,
 			updated_at       = NOW()
 	`, p.UserID, p.FriendResponse, p.EndingSoon, p.YouWillLove, p.InactiveWinback,
-		p.QuietHoursStart, p.QuietHoursEnd, p.MaxPerDay)
+		p.DirectChallenge, p.QuietHoursStart, p.QuietHoursEnd, p.MaxPerDay)
 	return err
 }
 
@@ -262,6 +287,11 @@ func enqueueNotification(p EnqueueParams) (int64, bool, error) {
 	if p.ScheduledAt.IsZero() {
 		p.ScheduledAt = time.Now()
 	}
+	// A deleted account's devices still hold push tokens until the purge;
+	// nothing should reach them in the meantime.
+	if accountDeactivated(p.UserID) {
+		return 0, false, nil
+	}
 
 	prefs := loadNotificationPrefs(p.UserID)
 	if !prefs.allowedByPrefs(p.TriggerKind) {
@@ -20,7 +20,8 @@ func TestNotifPrefs_DefaultsAllowEverything(t *testing.T) {
 	if !p.allowedByPrefs(TriggerFriendResponse) ||
 		!p.allowedByPrefs(TriggerEndingSoon) ||
 		!p.allowedByPrefs(TriggerYouWillLove) ||
-		!p.allowedByPrefs(TriggerInactiveWinback) {
+		!p.allowedByPrefs(TriggerInactiveWinback) ||
+		!p.allowedByPrefs(TriggerDirectChallenge) {
 		t.Errorf("defaults should allow every trigger, got %+v", p)
 	}
 	if p.MaxPerDay != 4 {
@@ -0,0 +1,277 @@
+package main
+
+// offline_models.go — weights trained offline, served next to (or instead of)
+// the ones learned online.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// The LTR, watch-ratio and calibration heads learn one event at a time
+// (learning_to_rank.go, watch_ratio.go, calibration.go). That keeps them
+// current, but it also means each head is exactly as good as the order events
+// happened to arrive in: a bad afternoon drags the weights, a restart between
+// flushes loses five minutes, and there is no way to ask "is this model any
+// good?" of anything but live traffic.
+//
+// cmd/ltrtrain fits the same models in batch, over weeks of logged pages and
+// what people did with them, checks each on a later stretch of time it did
+// not train on, and publishes the result as a numbered artifact in
+// model_artifacts. This file is the server's side of that: it loads the one
+// artifact marked live and lets the heads read from it.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// MODES
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	off      published, not served. Where every artifact starts.
+//	blend    alongside: a head's logit is (1−blend)·online + blend·offline,
+//	         or the offline one alone for a cohort the online head has not
+//	         warmed up. Calibration stays with the online fit, which keeps
+//	         tracking live traffic.
+//	replace  in place of: the offline heads and their calibration are read
+//	         wherever the artifact has a model for the cohort.
+//
+// Either way the online heads keep training, so turning an artifact off
+// hands back to weights that never stopped learning. At most one artifact is
+// live at a time (a unique index enforces it); switching is a row update
+// cmd/ltrtrain makes, picked up by every replica within
+// offlineModelSyncInterval.
+//
+// An artifact names the features it was trained on. One whose list is not
+// ltrFeatureKeys was trained against a different ranker and is ignored rather
+// than read with weights on the wrong features.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	offlineModelSyncInterval = time.Minute
+
+	offlineModeOff     = "off"
+	offlineModeBlend   = "blend"
+	offlineModeReplace = "replace"
+)
+
+// gbdtNode is one node of a regression tree. A leaf carries Value; an inner
+// node sends a breakdown left when its Feature is at most Threshold (an
+// absent feature reads as 0, as it does for the linear heads).
+type gbdtNode struct {
+	Feature   string  `json:"f,omitempty"`
+	Threshold float64 `json:"t,omitempty"`
+	Left      int     `json:"l,omitempty"`
+	Right     int     `json:"r,omitempty"`
+	Value     float64 `json:"v,omitempty"`
+	Leaf      bool    `json:"leaf,omitempty"`
+}
+
+type gbdtTree struct {
+	Nodes []gbdtNode `json:"nodes"`
+}
+
+// gbdtModel is the gradient-boosted LTR head: a logit that is Bias plus the
+// sum of its trees.
+type gbdtModel struct {
+	Bias    float64    `json:"bias"`
+	Samples int        `json:"samples"`
+	Trees   []gbdtTree `json:"trees"`
+}
+
+func (t gbdtTree) eval(breakdown map[string]float64) float64 {
+	i := 0
+	for steps := 0; steps <= len(t.Nodes) && i >= 0 && i < len(t.Nodes); steps++ {
+		n := t.Nodes[i]
+		if n.Leaf {
+			return n.Value
+		}
+		if breakdown[n.Feature] <= n.Threshold {
+			i = n.Left
+		} else {
+			i = n.Right
+		}
+	}
+	return 0 // malformed tree; contributes nothing
+}
+
+func (g *gbdtModel) logit(breakdown map[string]float64) float64 {
+	z := g.Bias
+	for _, t := range g.Trees {
+		z += t.eval(breakdown)
+	}
+	return z
+}
+
+// offlineArtifactPayload is the payload column. The LTR head is either
+// linear (LTR) or boosted (LTRTrees) per cohort; WatchRatio and Calibration
+// are optional.
+type offlineArtifactPayload struct {
+	Features    []string              `json:"features"`
+	LTR         map[Cohort]*ltrModel  `json:"ltr"`
+	LTRTrees    map[Cohort]*gbdtModel `json:"ltrTrees"`
+	WatchRatio  map[Cohort]*wrModel   `json:"watchRatio"`
+	Calibration *struct {
+		A float64 `json:"a"`
+		B float64 `json:"b"`
+	} `json:"calibration"`
+}
+
+// offlineModel is the live artifact as the heads read it.
+type offlineModel struct {
+	ID    int64
+	Mode  string
+	Blend float64
+	offlineArtifactPayload
+}
+
+var offlineModelStore atomic.Value
+
+// currentOfflineModel returns the live artifact, or nil when none is.
+func currentOfflineModel() *offlineModel {
+	m, _ := offlineModelStore.Load().(*offlineModel)
+	return m
+}
+
+// ltrLogit is the artifact's LTR logit for a cohort, and how many examples
+// that cohort's model was fitted on.
+func (m *offlineModel) ltrLogit(cohort Cohort, breakdown map[string]float64) (float64, int, bool) {
+	if g := m.LTRTrees[cohort]; g != nil && g.Samples >= ltrWarmupSamples {
+		return g.logit(breakdown), g.Samples, true
+	}
+	lm := m.LTR[cohort]
+	if lm == nil || lm.Updates < ltrWarmupSamples {
+		return 0, 0, false
+	}
+	return lm.logit(breakdown), lm.Updates, true
+}
+
+// offlineLTROverride folds the live artifact into an online LTR reading.
+func offlineLTROverride(cohort Cohort, breakdown map[string]float64, z float64, samples int, ok bool) (float64, int, bool) {
+	m := currentOfflineModel()
+	if m == nil {
+		return z, samples, ok
+	}
+	zo, so, okOff := m.ltrLogit(cohort, breakdown)
+	if !okOff {
+		return z, samples, ok
+	}
+	if m.Mode == offlineModeReplace || !ok {
+		return zo, so, true
+	}
+	return (1-m.Blend)*z + m.Blend*zo, max(samples, so), true
+}
+
+// offlineWatchRatioOverride does the same for a watch-ratio prediction: the
+// predicted ratio, the cohort mean it is read against, and the sample count.
+func offlineWatchRatioOverride(cohort Cohort, breakdown map[string]float64, pred, center float64, samples int, ok bool) (float64, float64, int, bool) {
+	m := currentOfflineModel()
+	if m == nil {
+		return pred, center, samples, ok
+	}
+	wm := m.WatchRatio[cohort]
+	if wm == nil || wm.Samples < wrMinSamples {
+		return pred, center, samples, ok
+	}
+	po := wm.predict(breakdown)
+	if m.Mode == offlineModeReplace || !ok {
+		return po, wm.MeanRatio, wm.Samples, true
+	}
+	b := m.Blend
+	return (1-b)*pred + b*po, (1-b)*center + b*wm.MeanRatio, max(samples, wm.Samples), true
+}
+
+// calibrateServedLogit maps the LTR logit the ranker is serving to a
+// probability: with the artifact's own calibration when it has replaced the
+// online heads, with the online Platt fit otherwise.
+func calibrateServedLogit(z float64) float64 {
+	if m := currentOfflineModel(); m != nil && m.Mode == offlineModeReplace && m.Calibration != nil {
+		return 1.0 / (1.0 + math.Exp(-(m.Calibration.A*z + m.Calibration.B)))
+	}
+	return plattCalibrate(z)
+}
+
+// decodeOfflineModel checks an artifact row and turns it into the served form.
+func decodeOfflineModel(id int64, mode string, blend float64, payload []byte) (*offlineModel, error) {
+	m := &offlineModel{ID: id, Mode: mode, Blend: blend}
+	if err := json.Unmarshal(payload, &m.offlineArtifactPayload); err != nil {
+		return nil, err
+	}
+	switch mode {
+	case offlineModeBlend:
+		if blend <= 0 || blend > 1 {
+			return nil, fmt.Errorf("blend %v outside (0,1]", blend)
+		}
+	case offlineModeReplace:
+	default:
+		return nil, fmt.Errorf("mode %q is not servable", mode)
+	}
+	got := append([]string(nil), m.Features...)
+	want := append([]string(nil), ltrFeatureKeys...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		return nil, fmt.Errorf("trained on %d features, the ranker has %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return nil, fmt.Errorf("trained on feature %q, the ranker has %q", got[i], want[i])
+		}
+	}
+	return m, nil
+}
+
+// loadOfflineModel reloads the live artifact. A read error keeps whatever is
+// being served; an artifact that fails its checks is not served at all.
+func loadOfflineModel() {
+	if db == nil {
+		return
+	}
+	var (
+		id      int64
+		mode    string
+		blend   float64
+		payload []byte
+	)
+	err := db.QueryRow(`SELECT id, mode, blend, payload FROM model_artifacts
+		WHERE mode <> 'off' ORDER BY activated_at DESC NULLS LAST LIMIT 1`).Scan(&id, &mode, &blend, &payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		if prev := currentOfflineModel(); prev != nil {
+			log.Printf("offline models: artifact %d is no longer live; serving online weights", prev.ID)
+		}
+		offlineModelStore.Store((*offlineModel)(nil))
+		return
+	}
+	if err != nil {
+		log.Printf("offline models: loading the live artifact (keeping previous): %v", err)
+		return
+	}
+	m, err := decodeOfflineModel(id, mode, blend, payload)
+	if err != nil {
+		log.Printf("offline models: not serving artifact %d: %v", id, err)
+		offlineModelStore.Store((*offlineModel)(nil))
+		return
+	}
+	if prev := currentOfflineModel(); prev == nil || prev.ID != m.ID || prev.Mode != m.Mode || prev.Blend != m.Blend {
+		log.Printf("offline models: serving artifact %d (%s, blend %.2f)", m.ID, m.Mode, m.Blend)
+	}
+	offlineModelStore.Store(m)
+}
+
+func startOfflineModelSync() {
+	loadOfflineModel()
+	go func() {
+		t := time.NewTicker(offlineModelSyncInterval)
+		defer t.Stop()
+		for range t.C {
+			loadOfflineModel()
+		}
+	}()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withOfflineModel serves m for the duration of a test.
+func withOfflineModel(t *testing.T, m *offlineModel) {
+	t.Helper()
+	prev := currentOfflineModel()
+	offlineModelStore.Store(m)
+	t.Cleanup(func() { offlineModelStore.Store(prev) })
+}
+
+func artifactJSON(t *testing.T, features []string) []byte {
+	t.Helper()
+	raw, err := json.Marshal(map[string]any{
+		"features": features,
+		"ltr": map[string]any{
+			string(CohortEngaged): map[string]any{"weights": map[string]float64{"relevance": 2}, "bias": -1, "updates": 500},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeOfflineModelRejectsOtherFeatures(t *testing.T) {
+	if _, err := decodeOfflineModel(1, offlineModeReplace, 1, artifactJSON(t, ltrFeatureKeys)); err != nil {
+		t.Fatalf("valid artifact rejected: %v", err)
+	}
+	if _, err := decodeOfflineModel(1, offlineModeReplace, 1, artifactJSON(t, ltrFeatureKeys[1:])); err == nil {
+		t.Error("artifact missing a feature was accepted")
+	}
+	renamed := append([]string{"relevanceV2"}, ltrFeatureKeys[1:]...)
+	if _, err := decodeOfflineModel(1, offlineModeReplace, 1, artifactJSON(t, renamed)); err == nil {
+		t.Error("artifact trained on a renamed feature was accepted")
+	}
+	if _, err := decodeOfflineModel(1, offlineModeBlend, 0, artifactJSON(t, ltrFeatureKeys)); err == nil {
+		t.Error("blend of 0 was accepted")
+	}
+}
+
+func TestOfflineLTROverrideModes(t *testing.T) {
+	m, err := decodeOfflineModel(7, offlineModeBlend, 0.25, artifactJSON(t, ltrFeatureKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withOfflineModel(t, m)
+	bd := map[string]float64{"relevance": 1} // offline logit: -1 + 2·1 = 1
+
+	z, n, ok := offlineLTROverride(CohortEngaged, bd, 3, 100, true)
+	if !ok || math.Abs(z-(0.75*3+0.25*1)) > 1e-9 || n != 500 {
+		t.Errorf("blend: z=%v n=%d ok=%v, want 2.5, 500, true", z, n, ok)
+	}
+	if z, _, ok := offlineLTROverride(CohortEngaged, bd, 0, 0, false); !ok || z != 1 {
+		t.Errorf("blend over a cold online head: z=%v ok=%v, want the offline 1", z, ok)
+	}
+	if z, n, _ := offlineLTROverride(CohortPower, bd, 3, 100, true); z != 3 || n != 100 {
+		t.Errorf("cohort without an offline model: z=%v n=%d, want online untouched", z, n)
+	}
+
+	m.Mode = offlineModeReplace
+	if z, _, _ := offlineLTROverride(CohortEngaged, bd, 3, 100, true); z != 1 {
+		t.Errorf("replace: z=%v, want the offline 1", z)
+	}
+}
+
+func TestOfflineWatchRatioOverrideBlendsCenter(t *testing.T) {
+	withOfflineModel(t, &offlineModel{ID: 1, Mode: offlineModeBlend, Blend: 0.5,
+		offlineArtifactPayload: offlineArtifactPayload{WatchRatio: map[Cohort]*wrModel{
+			CohortEngaged: {Weights: map[string]float64{}, Bias: 0, Samples: 200, MeanRatio: 0.6},
+		}}})
+	pred, center, n, ok := offlineWatchRatioOverride(CohortEngaged, nil, 0.3, 0.4, 50, true)
+	if !ok || math.Abs(pred-0.4) > 1e-9 || math.Abs(center-0.5) > 1e-9 || n != 200 {
+		t.Errorf("got pred=%v center=%v n=%d ok=%v, want 0.4, 0.5, 200, true", pred, center, n, ok)
+	}
+}
+
+func TestGBDTTreeEval(t *testing.T) {
+	g := &gbdtModel{Bias: 0.1, Trees: []gbdtTree{{Nodes: []gbdtNode{
+		{Feature: "freshness", Threshold: 0.5, Left: 1, Right: 2},
+		{Leaf: true, Value: -1},
+		{Feature: "fatiguePenalty", Threshold: 0, Left: 3, Right: 4},
+		{Leaf: true, Value: 2},
+		{Leaf: true, Value: -3},
+	}}}}
+	cases := []struct {
+		bd   map[string]float64
+		want float64
+	}{
+		{map[string]float64{"freshness": 0.2}, -0.9},
+		{map[string]float64{"freshness": 0.9}, 2.1}, // absent fatigue reads as 0
+		{map[string]float64{"freshness": 0.9, "fatiguePenalty": 0.4}, -2.9},
+	}
+	for _, c := range cases {
+		if got := g.logit(c.bd); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("logit(%v) = %v, want %v", c.bd, got, c.want)
+		}
+	}
+	cyclic := gbdtTree{Nodes: []gbdtNode{{Feature: "freshness", Left: 0, Right: 0}}}
+	if got := cyclic.eval(nil); got != 0 {
+		t.Errorf("cyclic tree = %v, want 0", got)
+	}
+}
+
+func TestLoadOfflineModelKeepsSnapshotOnError(t *testing.T) {
+	live := &offlineModel{ID: 3, Mode: offlineModeReplace}
+	withOfflineModel(t, live)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery("FROM model_artifacts").WillReturnError(errors.New("connection reset"))
+	loadOfflineModel()
+	if currentOfflineModel() != live {
+		t.Error("a read error dropped the live artifact")
+	}
+
+	mock.ExpectQuery("FROM model_artifacts").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "mode", "blend", "payload"}))
+	loadOfflineModel()
+	if currentOfflineModel() != nil {
+		t.Error("still serving an artifact after it was switched off")
+	}
+}
@@ -0,0 +1,750 @@
+package main
+
+// oidc.go — "Sign in with Google / Apple": OpenID Connect ID tokens as a way
+// in, and linking those identities to an account.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SHAPE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// The apps run the provider's own sign-in (Google's Credential Manager,
+// AuthenticationServices on iOS) and end up holding an ID token: a JWT the
+// provider signed, naming the person (sub), the app it was issued to (aud),
+// and the nonce the app asked for. The server never sees a provider password
+// or an OAuth code; it checks the token and trusts what it says.
+//
+//	POST /auth/oidc/nonce                        (public) → a one-time nonce
+//	POST /auth/oidc/login {provider, idToken, nonce, username?}
+//	                                             (public) → a session, as /login
+//	GET  /api/v1/account/identities              (authed) → linked providers
+//	POST /api/v1/account/identities {provider, idToken, nonce}
+//	                                             (authed) → link one
+//	DELETE /api/v1/account/identities/{provider} (authed) → unlink it
+//
+// Checking a token (verifyIDToken): the signature against the provider's
+// published keys (JWKS, fetched through its discovery document and cached
+// for oidcJWKSCacheTTL), the issuer, the audience — one of our client ids
+// for that provider — the expiry, and the nonce. The nonce is ours, issued
+// once and spent on use, so a token lifted from another app or replayed
+// from a log is refused. Apple's SDK sends SHA-256(nonce) to Apple; either
+// form is accepted.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHICH ACCOUNT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// An identity is (provider, sub) — never the email, which people change and
+// providers recycle. A known identity signs in to its account. An unknown
+// one creates an account — unless its verified email is already the verified
+// email of an existing account. Then nothing is linked automatically: the
+// person is told to sign in the usual way and link the provider from
+// settings. Merging on email alone would hand an account to whoever controls
+// that address at the provider, which is not always who owns the account.
+//
+// Accounts created this way have no password (IsValidUser refuses an empty
+// one); a password can be added later through the reset flow. Unlinking the
+// last way into an account is refused.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// oidcJWKSCacheTTL is how long a provider's keys are trusted before
+	// refetching. Providers rotate keys over days and publish the new one
+	// well ahead; a token signed with a kid we haven't seen triggers an
+	// early refetch (at most every oidcJWKSRefetchGap).
+	oidcJWKSCacheTTL   = time.Hour
+	oidcJWKSRefetchGap = time.Minute
+	// oidcNonceTTL is how long a nonce may take to come back.
+	oidcNonceTTL = 10 * time.Minute
+)
+
+var (
+	errOIDCProvider     = errors.New("unknown or unconfigured sign-in provider")
+	errOIDCToken        = errors.New("the sign-in token was not accepted")
+	errOIDCNonce        = errors.New("sign-in expired or already used; start again")
+	errOIDCAccountEmail = errors.New("an account already uses this email; sign in to it and link this provider from settings")
+	errOIDCLinkedOther  = errors.New("this sign-in is already linked to another account")
+	errOIDCLinkedSame   = errors.New("a different account from this provider is already linked; unlink it first")
+	errOIDCLastMethod   = errors.New("this is the only way into the account; add a password or passkey first")
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Providers
+// ─────────────────────────────────────────────────────────────────────────────
+
+// oidcProvider is one identity provider we accept ID tokens from.
+type oidcProvider struct {
+	Name    string
+	Issuer  string   // discovery is fetched from Issuer + /.well-known/openid-configuration
+	Issuers []string // every iss value its tokens may carry; defaults to Issuer
+	// ClientIDs are our app's client ids at the provider — one per
+	// platform (iOS, Android, web). A token's aud must be one of them.
+	ClientIDs []string
+}
+
+func (p *oidcProvider) issuerAllowed(iss string) bool {
+	if len(p.Issuers) == 0 {
+		return iss == p.Issuer
+	}
+	for _, i := range p.Issuers {
+		if i == iss {
+			return true
+		}
+	}
+	return false
+}
+
+// Configured from env at boot (initOIDCProviders). A provider with no
+// client ids is off.
+//
+//	OIDC_GOOGLE_CLIENT_IDS  comma-separated   OIDC_GOOGLE_ISSUER  default https://accounts.google.com
+//	OIDC_APPLE_CLIENT_IDS   comma-separated   OIDC_APPLE_ISSUER   default https://appleid.apple.com
+var (
+	oidcProviders   = map[string]*oidcProvider{}
+	oidcProvidersMu sync.RWMutex
+)
+
+func setOIDCProvider(p *oidcProvider) {
+	oidcProvidersMu.Lock()
+	oidcProviders[p.Name] = p
+	oidcProvidersMu.Unlock()
+}
+
+func getOIDCProvider(name string) (*oidcProvider, bool) {
+	oidcProvidersMu.RLock()
+	defer oidcProvidersMu.RUnlock()
+	p, ok := oidcProviders[name]
+	return p, ok
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// initOIDCProviders is called from main().
+func initOIDCProviders() {
+	google := &oidcProvider{
+		Name:      "google",
+		Issuer:    getEnv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com"),
+		ClientIDs: splitList(getEnv("OIDC_GOOGLE_CLIENT_IDS", "")),
+	}
+	if google.Issuer == "https://accounts.google.com" {
+		// Google's tokens carry either form.
+		google.Issuers = []string{google.Issuer, "accounts.google.com"}
+	}
+	apple := &oidcProvider{
+		Name:      "apple",
+		Issuer:    getEnv("OIDC_APPLE_ISSUER", "https://appleid.apple.com"),
+		ClientIDs: splitList(getEnv("OIDC_APPLE_CLIENT_IDS", "")),
+	}
+	var on []string
+	for _, p := range []*oidcProvider{google, apple} {
+		if len(p.ClientIDs) > 0 {
+			setOIDCProvider(p)
+			on = append(on, p.Name)
+		}
+	}
+	log.Printf("oidc: providers=%v", on)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// JWKS
+// ─────────────────────────────────────────────────────────────────────────────
+
+var oidcHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+type oidcKeySet struct {
+	keys      map[string]any // kid → *rsa.PublicKey | *ecdsa.PublicKey
+	fetchedAt time.Time
+}
+
+var oidcKeyCache = struct {
+	sync.Mutex
+	m map[string]*oidcKeySet // issuer → keys
+}{m: map[string]*oidcKeySet{}}
+
+func oidcGetJSON(url string, out any) error {
+	resp, err := oidcHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// fetchOIDCKeys reads the provider's discovery document and its JWKS.
+func fetchOIDCKeys(p *oidcProvider) (*oidcKeySet, error) {
+	var disco struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := oidcGetJSON(strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &disco); err != nil {
+		return nil, err
+	}
+	if disco.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc %s: discovery has no jwks_uri", p.Name)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := oidcGetJSON(disco.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	set := &oidcKeySet{keys: map[string]any{}, fetchedAt: time.Now()}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeB64URL(k.N)
+			e, err2 := decodeB64URL(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			exp := 0
+			for _, c := range e {
+				exp = exp<<8 | int(c)
+			}
+			set.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		case "EC":
+			x, err1 := decodeB64URL(k.X)
+			y, err2 := decodeB64URL(k.Y)
+			if k.Crv != "P-256" || err1 != nil || err2 != nil {
+				continue
+			}
+			pk := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if pk.Curve.IsOnCurve(pk.X, pk.Y) {
+				set.keys[k.Kid] = pk
+			}
+		}
+	}
+	return set, nil
+}
+
+// oidcKey returns the provider's key for kid, fetching the key set when it
+// is missing, stale, or lacks kid (a rotation we haven't seen).
+func oidcKey(p *oidcProvider, kid string) (any, error) {
+	oidcKeyCache.Lock()
+	defer oidcKeyCache.Unlock()
+	set := oidcKeyCache.m[p.Issuer]
+	if set != nil && time.Since(set.fetchedAt) < oidcJWKSCacheTTL {
+		if k, ok := set.keys[kid]; ok {
+			return k, nil
+		}
+		if time.Since(set.fetchedAt) < oidcJWKSRefetchGap {
+			return nil, errOIDCToken
+		}
+	}
+	fresh, err := fetchOIDCKeys(p)
+	if err != nil {
+		if set != nil {
+			// Keep serving the old keys through a provider blip.
+			if k, ok := set.keys[kid]; ok {
+				return k, nil
+			}
+		}
+		return nil, err
+	}
+	oidcKeyCache.m[p.Issuer] = fresh
+	if k, ok := fresh.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errOIDCToken
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Nonces
+// ─────────────────────────────────────────────────────────────────────────────
+
+var oidcNonces = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+func issueOIDCNonce() (string, error) {
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	if rdb != nil {
+		return nonce, rdb.Set(rctx, "oidc:nonce:"+nonce, "1", oidcNonceTTL).Err()
+	}
+	oidcNonces.Lock()
+	defer oidcNonces.Unlock()
+	now := time.Now()
+	for k, until := range oidcNonces.m {
+		if now.After(until) {
+			delete(oidcNonces.m, k)
+		}
+	}
+	oidcNonces.m[nonce] = now.Add(oidcNonceTTL)
+	return nonce, nil
+}
+
+// spendOIDCNonce reports whether nonce was issued and not yet used, and
+// uses it up.
+func spendOIDCNonce(nonce string) bool {
+	if nonce == "" {
+		return false
+	}
+	if rdb != nil {
+		n, err := rdb.Del(rctx, "oidc:nonce:"+nonce).Result()
+		return err == nil && n == 1
+	}
+	oidcNonces.Lock()
+	defer oidcNonces.Unlock()
+	until, ok := oidcNonces.m[nonce]
+	delete(oidcNonces.m, nonce)
+	return ok && time.Now().Before(until)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Verification
+// ─────────────────────────────────────────────────────────────────────────────
+
+// oidcIdentity is what a verified ID token tells us.
+type oidcIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string // normalized; "" when absent or not an address
+	EmailVerified bool
+	Name          string
+}
+
+// oidcClaims are the ID token claims we read. Apple sends email_verified as
+// the string "true"; Google as a boolean.
+type oidcClaims struct {
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Name          string          `json:"name"`
+	jwt.RegisteredClaims
+}
+
+func (c *oidcClaims) emailVerified() bool {
+	v := strings.Trim(string(c.EmailVerified), `"`)
+	return v == "true"
+}
+
+// verifyIDToken checks an ID token from provider against the nonce the
+// client says it used, and spends the nonce.
+func verifyIDToken(providerName, idToken, nonce string) (*oidcIdentity, error) {
+	p, ok := getOIDCProvider(providerName)
+	if !ok {
+		return nil, errOIDCProvider
+	}
+	claims := &oidcClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return oidcKey(p, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithAudience(p.ClientIDs...),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		log.Printf("oidc %s: token refused: %v", p.Name, err)
+		return nil, errOIDCToken
+	}
+	if !p.issuerAllowed(claims.Issuer) || claims.Subject == "" {
+		return nil, errOIDCToken
+	}
+	hashed := sha256.Sum256([]byte(nonce))
+	if claims.Nonce == "" || (claims.Nonce != nonce && claims.Nonce != hex.EncodeToString(hashed[:])) {
+		return nil, errOIDCNonce
+	}
+	if !spendOIDCNonce(nonce) {
+		return nil, errOIDCNonce
+	}
+	id := &oidcIdentity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		EmailVerified: claims.emailVerified(),
+		Name:          strings.TrimSpace(claims.Name),
+	}
+	if email, ok := normalizeEmail(claims.Email); ok {
+		id.Email = email
+	}
+	return id, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Accounts
+// ─────────────────────────────────────────────────────────────────────────────
+
+// identityUser returns the account an identity is linked to, or "".
+func identityUser(id *oidcIdentity) (string, error) {
+	var userID string
+	err := db.QueryRow(`SELECT user_id::text FROM user_identities WHERE provider = $1 AND subject = $2`,
+		id.Provider, id.Subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+// oidcUsername picks a free username for a new account: the requested
+// one if it is valid and free, else one made from the email or name.
+func oidcUsername(requested string, id *oidcIdentity) (string, error) {
+	requested = strings.ToLower(strings.TrimSpace(requested))
+	if requested != "" {
+		if !usernameRe.MatchString(requested) {
+			return "", errors.New("username must be 3-20 chars: a-z, 0-9, _ or .")
+		}
+		if UserExists(requested) {
+			return "", errors.New("username already taken")
+		}
+		return requested, nil
+	}
+	base := id.Name
+	if local, _, ok := strings.Cut(id.Email, "@"); ok {
+		base = local
+	}
+	var b strings.Builder
+	for _, c := range strings.ToLower(base) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '.' {
+			b.WriteRune(c)
+		}
+	}
+	stem := b.String()
+	if len(stem) > 14 {
+		stem = stem[:14]
+	}
+	if len(stem) < 3 {
+		stem = "user"
+	}
+	if !UserExists(stem) {
+		return stem, nil
+	}
+	for range 5 {
+		n, err := rand.Int(rand.Reader, big.NewInt(100000))
+		if err != nil {
+			return "", err
+		}
+		if name := stem + strconv.FormatInt(n.Int64(), 10); !UserExists(name) {
+			return name, nil
+		}
+	}
+	return "", errors.New("could not pick a username; choose one")
+}
+
+// createOIDCUser creates a password-less account for an identity and links
+// it. A verified provider email becomes the account's verified email.
+func createOIDCUser(id *oidcIdentity, username string) (string, error) {
+	fullName := id.Name
+	if fullName == "" {
+		fullName = username
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var userID string
+	if err := tx.QueryRow(`
+		INSERT INTO users (username, password, password_hash, full_name, wins, losses, league)
+		VALUES ($1, '', '', $2, 0, 0, 'Bronze')
+		RETURNING CAST(id AS TEXT)`, username, truncateText(fullName, 100)).Scan(&userID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`, userID, id.Provider, id.Subject, id.Email); err != nil {
+		return "", err
+	}
+	if id.Email != "" && id.EmailVerified {
+		if _, err := tx.Exec(`INSERT INTO user_emails (user_id, email, verified_at)
+			SELECT $1, $2::text, NOW()
+			WHERE NOT EXISTS (SELECT 1 FROM user_emails WHERE email = $2 AND verified_at IS NOT NULL)`,
+			userID, id.Email); err != nil {
+			return "", err
+		}
+	}
+	return userID, tx.Commit()
+}
+
+// emailOwner returns the account whose verified email is email, or "".
+func emailOwner(email string) (string, error) {
+	var userID string
+	err := db.QueryRow(`SELECT user_id::text FROM user_emails WHERE email = $1 AND verified_at IS NOT NULL`,
+		email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+// linkIdentity links an identity to userID.
+func linkIdentity(userID string, id *oidcIdentity) error {
+	_, err := db.Exec(`INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`, userID, id.Provider, id.Subject, id.Email)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		if pqErr.Constraint == "user_identities_provider_subject_key" {
+			if owner, _ := identityUser(id); owner == userID {
+				return nil // already linked here
+			}
+			return errOIDCLinkedOther
+		}
+		return errOIDCLinkedSame
+	}
+	return err
+}
+
+// unlinkIdentity removes a provider from an account, unless nothing else
+// could sign in to it: a password, a passkey, or another provider.
+func unlinkIdentity(userID, provider string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var others int
+	if err := tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM users WHERE id = $1
+		           AND (COALESCE(password_hash, '') <> '' OR password <> ''))
+		     + (SELECT COUNT(*) FROM user_passkeys WHERE user_id = $1)
+		     + (SELECT COUNT(*) FROM user_identities WHERE user_id = $1 AND provider <> $2)
+		FROM users WHERE id = $1 FOR UPDATE`, userID, provider).Scan(&others); err != nil {
+		return err
+	}
+	if others == 0 {
+		return errOIDCLastMethod
+	}
+	res, err := tx.Exec(`DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`, userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errOIDCProvider
+	}
+	return tx.Commit()
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handlers
+// ─────────────────────────────────────────────────────────────────────────────
+
+// OIDCNonceHandler — POST /auth/oidc/nonce
+func OIDCNonceHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAction("ip:"+clientIP(r), "login") {
+		writeRateLimited(w, "login")
+		return
+	}
+	nonce, err := issueOIDCNonce()
+	if err != nil {
+		http.Error(w, "could not start sign-in", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"nonce": nonce, "expiresIn": int(oidcNonceTTL.Seconds())})
+}
+
+type oidcTokenRequest struct {
+	Provider string `json:"provider"`
+	IDToken  string `json:"idToken"`
+	Nonce    string `json:"nonce"`
+	Username string `json:"username"` // new accounts only; optional
+}
+
+func writeOIDCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errOIDCProvider):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errOIDCToken), errors.Is(err, errOIDCNonce):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, errOIDCAccountEmail), errors.Is(err, errOIDCLinkedOther),
+		errors.Is(err, errOIDCLinkedSame), errors.Is(err, errOIDCLastMethod):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("oidc: %v", err)
+		http.Error(w, "sign-in failed", http.StatusInternalServerError)
+	}
+}
+
+// OIDCLoginHandler — POST /auth/oidc/login
+// Signs in to the linked account, or creates one. Responds like /login.
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p oidcTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !allowAction("ip:"+clientIP(r), "login") {
+		writeRateLimited(w, "login")
+		return
+	}
+	id, err := verifyIDToken(p.Provider, p.IDToken, p.Nonce)
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	userID, err := identityUser(id)
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	if userID == "" {
+		if id.Email != "" && id.EmailVerified {
+			owner, err := emailOwner(id.Email)
+			if err != nil {
+				writeOIDCError(w, err)
+				return
+			}
+			if owner != "" {
+				writeOIDCError(w, errOIDCAccountEmail)
+				return
+			}
+		}
+		if !allowAction("", "signup") {
+			writeRateLimited(w, "signup")
+			return
+		}
+		username, err := oidcUsername(p.Username, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if userID, err = createOIDCUser(id, username); err != nil {
+			log.Printf("oidc: creating account %q for %s: %v", username, id.Provider, err)
+			http.Error(w, "username already taken", http.StatusConflict)
+			return
+		}
+		log.Printf("New user signed up with %s: %s (id=%s)", id.Provider, username, userID)
+		if user, ok := GetUserByID(userID); ok {
+			go IndexUser(user)
+		}
+	} else {
+		_, _ = db.Exec(`UPDATE user_identities SET last_login_at = NOW() WHERE provider = $1 AND subject = $2`,
+			id.Provider, id.Subject)
+	}
+	user, ok := GetUserByID(userID)
+	if !ok {
+		http.Error(w, "sign-in failed", http.StatusInternalServerError)
+		return
+	}
+	completeLogin(w, r, user)
+}
+
+// LinkedIdentity is one entry in GET /account/identities.
+type LinkedIdentity struct {
+	Provider    string `json:"provider"`
+	Email       string `json:"email,omitempty"`
+	LinkedAt    string `json:"linkedAt"`
+	LastLoginAt string `json:"lastLoginAt,omitempty"`
+}
+
+// ListIdentitiesHandler — GET /api/v1/account/identities
+func ListIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	rows, err := db.Query(`SELECT provider, email, created_at, last_login_at FROM user_identities
+		WHERE user_id = $1 ORDER BY provider`, authUserID(r))
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	out := []LinkedIdentity{}
+	for rows.Next() {
+		var li LinkedIdentity
+		var created time.Time
+		var last sql.NullTime
+		if rows.Scan(&li.Provider, &li.Email, &created, &last) != nil {
+			continue
+		}
+		li.LinkedAt = created.UTC().Format(time.RFC3339)
+		if last.Valid {
+			li.LastLoginAt = last.Time.UTC().Format(time.RFC3339)
+		}
+		out = append(out, li)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"identities": out})
+}
+
+// LinkIdentityHandler — POST /api/v1/account/identities
+func LinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p oidcTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	id, err := verifyIDToken(p.Provider, p.IDToken, p.Nonce)
+	if err == nil {
+		err = linkIdentity(authUserID(r), id)
+	}
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"provider": id.Provider, "linked": true})
+}
+
+// UnlinkIdentityHandler — DELETE /api/v1/account/identities/{provider}
+func UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	err := unlinkIdentity(authUserID(r), mux.Vars(r)["provider"])
+	if errors.Is(err, errOIDCProvider) {
+		http.Error(w, "that provider is not linked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
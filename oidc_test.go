@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/lib/pq"
+)
+
+// stubIdP is a local OpenID provider: a discovery document, a JWKS, and a
+// signing key, registered as provider "stub".
+type stubIdP struct {
+	srv        *httptest.Server
+	key        *rsa.PrivateKey
+	kid        string
+	jwksServed atomic.Int32
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	idp := &stubIdP{kid: "k1"}
+	idp.rotate(t)
+	idp.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			writeJSON(w, http.StatusOK, map[string]string{"issuer": idp.srv.URL, "jwks_uri": idp.srv.URL + "/jwks"})
+		case "/jwks":
+			idp.jwksServed.Add(1)
+			writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+				"kty": "RSA", "use": "sig", "alg": "RS256", "kid": idp.kid,
+				"n": b64url.EncodeToString(idp.key.N.Bytes()),
+				"e": b64url.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(idp.srv.Close)
+	setOIDCProvider(&oidcProvider{Name: "stub", Issuer: idp.srv.URL, ClientIDs: []string{"ios-app", "android-app"}})
+	t.Cleanup(func() {
+		oidcProvidersMu.Lock()
+		delete(oidcProviders, "stub")
+		oidcProvidersMu.Unlock()
+	})
+	return idp
+}
+
+// rotate replaces the signing key, as a provider's key rotation does.
+func (idp *stubIdP) rotate(t *testing.T) {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.key = k
+	idp.kid += "'"
+}
+
+func (idp *stubIdP) token(t *testing.T, mutate func(jwt.MapClaims)) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"iss":            idp.srv.URL,
+		"aud":            "android-app",
+		"sub":            "subject-123",
+		"email":          "Alice@Example.com",
+		"email_verified": true,
+		"name":           "Alice",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	if mutate != nil {
+		mutate(claims)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = idp.kid
+	s, err := tok.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	resetRedis(t)
+	idp := newStubIdP(t)
+
+	nonce, _ := issueOIDCNonce()
+	id, err := verifyIDToken("stub", idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce }), nonce)
+	if err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+	if id.Subject != "subject-123" || id.Email != "alice@example.com" || !id.EmailVerified || id.Provider != "stub" {
+		t.Errorf("identity = %+v", id)
+	}
+	// The nonce is spent.
+	if _, err := verifyIDToken("stub", idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce }), nonce); err != errOIDCNonce {
+		t.Errorf("replayed nonce: err = %v", err)
+	}
+}
+
+func TestVerifyIDTokenRejections(t *testing.T) {
+	resetRedis(t)
+	idp := newStubIdP(t)
+	cases := map[string]func(jwt.MapClaims){
+		"other app":      func(c jwt.MapClaims) { c["aud"] = "someone-elses-app" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"other issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+		"nonce mismatch": func(c jwt.MapClaims) { c["nonce"] = "not-the-nonce" },
+	}
+	for name, mutate := range cases {
+		nonce, _ := issueOIDCNonce()
+		tok := idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce; mutate(c) })
+		if _, err := verifyIDToken("stub", tok, nonce); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	// Signed by somebody else's key under our kid.
+	nonce, _ := issueOIDCNonce()
+	real := idp.key
+	idp.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	forged := idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce })
+	idp.key = real
+	if _, err := verifyIDToken("stub", forged, nonce); err != errOIDCToken {
+		t.Errorf("forged: err = %v", err)
+	}
+	if _, err := verifyIDToken("nobody", "x.y.z", nonce); err != errOIDCProvider {
+		t.Errorf("unknown provider: err = %v", err)
+	}
+}
+
+// Apple puts SHA-256(nonce) in the token; the client still sends the raw one.
+func TestVerifyIDTokenHashedNonce(t *testing.T) {
+	resetRedis(t)
+	idp := newStubIdP(t)
+	nonce, _ := issueOIDCNonce()
+	sum := sha256.Sum256([]byte(nonce))
+	tok := idp.token(t, func(c jwt.MapClaims) {
+		c["nonce"] = hex.EncodeToString(sum[:])
+		c["email_verified"] = "true"
+	})
+	id, err := verifyIDToken("stub", tok, nonce)
+	if err != nil || !id.EmailVerified {
+		t.Fatalf("verifyIDToken = %+v, %v", id, err)
+	}
+}
+
+// A token signed with a key we haven't seen refetches the JWKS once.
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	resetRedis(t)
+	idp := newStubIdP(t)
+	nonce, _ := issueOIDCNonce()
+	if _, err := verifyIDToken("stub", idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce }), nonce); err != nil {
+		t.Fatal(err)
+	}
+	idp.rotate(t)
+	// Pretend the cached set is older than the refetch gap.
+	oidcKeyCache.Lock()
+	oidcKeyCache.m[idp.srv.URL].fetchedAt = time.Now().Add(-2 * oidcJWKSRefetchGap)
+	oidcKeyCache.Unlock()
+
+	nonce, _ = issueOIDCNonce()
+	if _, err := verifyIDToken("stub", idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce }), nonce); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+	if n := idp.jwksServed.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
+
+// An unknown identity whose verified email belongs to an account is not
+// merged into it.
+func TestOIDCLoginRefusesEmailTakeover(t *testing.T) {
+	resetRedis(t)
+	idp := newStubIdP(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_identities WHERE provider = $1 AND subject = $2")).
+		WithArgs("stub", "subject-123").WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_emails WHERE email = $1 AND verified_at IS NOT NULL")).
+		WithArgs("alice@example.com").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("7"))
+
+	nonce, _ := issueOIDCNonce()
+	body := `{"provider":"stub","nonce":"` + nonce + `","idToken":"` +
+		idp.token(t, func(c jwt.MapClaims) { c["nonce"] = nonce }) + `"}`
+	rec := httptest.NewRecorder()
+	OIDCLoginHandler(rec, httptest.NewRequest(http.MethodPost, "/auth/oidc/login", strings.NewReader(body)))
+
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "link this provider") {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLinkIdentityConflicts(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	id := &oidcIdentity{Provider: "google", Subject: "s"}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_identities")).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "user_identities_provider_subject_key"})
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_identities WHERE provider = $1")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("9"))
+	if err := linkIdentity("5", id); err != errOIDCLinkedOther {
+		t.Errorf("linked elsewhere: err = %v", err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_identities")).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "user_identities_user_provider_key"})
+	if err := linkIdentity("5", id); err != errOIDCLinkedSame {
+		t.Errorf("second google account: err = %v", err)
+	}
+}
+
+func TestUnlinkIdentityKeepsAWayIn(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id = $1 FOR UPDATE")).WithArgs("5", "google").
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectRollback()
+	if err := unlinkIdentity("5", "google"); err != errOIDCLastMethod {
+		t.Errorf("err = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCUsernameFromEmail(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	name, err := oidcUsername("", &oidcIdentity{Email: "a.l-ice+x@example.com"})
+	if err != nil || name != "a.licex" {
+		t.Errorf("oidcUsername = %q, %v", name, err)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+// passkeys.go — WebAuthn passkeys: registering them, signing in with one,
+// and the list a user manages them from. Verification is in webauthn.go.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SHAPE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Both ceremonies are two calls. /begin issues a random challenge, keeps it
+// server-side for passkeyCeremonyTTL under a ceremony id, and returns the
+// options the client hands to navigator.credentials.create()/get() (or the
+// platform passkey API on mobile). The client then sends the authenticator's
+// response back with that ceremony id. A challenge is taken out of the store
+// the moment it is looked up, so each can be answered once.
+//
+//	POST /api/v1/passkeys/register/begin     (authed)  → creation options
+//	POST /api/v1/passkeys/register/finish    (authed)  → store the passkey
+//	POST /passkeys/login/begin               (public)  → request options
+//	POST /login  { "passkey": {...} }        (public)  → a session, as with a password
+//	GET  /api/v1/passkeys                    (authed)  → the caller's passkeys
+//	DELETE /api/v1/passkeys/{id}             (authed)  → remove one
+//
+// Passkeys are registered as discoverable credentials (residentKey
+// "required"), so sign-in needs no username: the authenticator offers the
+// user their passkeys for this site and returns the chosen one's user
+// handle — our user id — with the assertion. Nothing in /begin depends on
+// who is signing in, which also means it cannot be used to ask whether an
+// account exists or has passkeys.
+//
+// Both ceremonies require user verification (the device's PIN, fingerprint
+// or face check), so a passkey sign-in is two factors on its own —
+// something you have and something you are or know.
+//
+// The signature counter is stored and checked on every sign-in
+// (verifyAssertion); the UPDATE that saves the new value only succeeds if
+// nobody else advanced it first, so two replays racing each other cannot
+// both win.
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// passkeyCeremonyTTL is how long a challenge stays answerable — the
+	// client's timeout plus a little for the round trip.
+	passkeyCeremonyTTL     = 5 * time.Minute
+	passkeyCeremonyTimeout = 4 * time.Minute
+	// maxPasskeysPerUser caps registrations. Nobody needs more; it bounds
+	// the excludeCredentials list and the table.
+	maxPasskeysPerUser = 10
+
+	passkeyPurposeRegister = "register"
+	passkeyPurposeLogin    = "login"
+)
+
+var (
+	errPasskeyCeremony = errors.New("passkey request expired or already used; start again")
+	errPasskeyUnknown  = errors.New("passkey not recognised")
+	errPasskeyLimit    = errors.New("too many passkeys; remove one first")
+	errPasskeyExists   = errors.New("this passkey is already registered")
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Ceremony store
+// ─────────────────────────────────────────────────────────────────────────────
+
+// passkeyCeremony is one outstanding challenge.
+type passkeyCeremony struct {
+	Purpose   string `json:"purpose"`
+	UserID    string `json:"userId,omitempty"` // registration only
+	Challenge []byte `json:"challenge"`
+}
+
+// passkeyCeremonies holds ceremonies when there is no Redis (dev, tests
+// without it). With more than one replica Redis is required anyway: /begin
+// and /finish may land on different instances.
+var passkeyCeremonies = struct {
+	sync.Mutex
+	m map[string]passkeyCeremonyEntry
+}{m: map[string]passkeyCeremonyEntry{}}
+
+type passkeyCeremonyEntry struct {
+	c     passkeyCeremony
+	until time.Time
+}
+
+func passkeyCeremonyKey(id string) string { return "webauthn:" + id }
+
+// beginPasskeyCeremony stores a fresh challenge and returns its id.
+func beginPasskeyCeremony(purpose, userID string) (string, passkeyCeremony, error) {
+	c := passkeyCeremony{Purpose: purpose, UserID: userID, Challenge: make([]byte, 32)}
+	if _, err := rand.Read(c.Challenge); err != nil {
+		return "", c, err
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return "", c, err
+	}
+	if rdb != nil {
+		raw, _ := json.Marshal(c)
+		return id, c, rdb.Set(rctx, passkeyCeremonyKey(id), raw, passkeyCeremonyTTL).Err()
+	}
+	passkeyCeremonies.Lock()
+	defer passkeyCeremonies.Unlock()
+	now := time.Now()
+	for k, e := range passkeyCeremonies.m {
+		if now.After(e.until) {
+			delete(passkeyCeremonies.m, k)
+		}
+	}
+	passkeyCeremonies.m[id] = passkeyCeremonyEntry{c: c, until: now.Add(passkeyCeremonyTTL)}
+	return id, c, nil
+}
+
+// takePasskeyCeremony returns and forgets a ceremony. It must be for
+// purpose and, for registration, for the same user who began it.
+func takePasskeyCeremony(id, purpose, userID string) (passkeyCeremony, error) {
+	var c passkeyCeremony
+	if id == "" {
+		return c, errPasskeyCeremony
+	}
+	if rdb != nil {
+		raw, err := rdb.GetDel(rctx, passkeyCeremonyKey(id)).Bytes()
+		if err != nil || json.Unmarshal(raw, &c) != nil {
+			return c, errPasskeyCeremony
+		}
+	} else {
+		passkeyCeremonies.Lock()
+		e, ok := passkeyCeremonies.m[id]
+		delete(passkeyCeremonies.m, id)
+		passkeyCeremonies.Unlock()
+		if !ok || time.Now().After(e.until) {
+			return c, errPasskeyCeremony
+		}
+		c = e.c
+	}
+	if c.Purpose != purpose || c.UserID != userID {
+		return c, errPasskeyCeremony
+	}
+	return c, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Storage
+// ─────────────────────────────────────────────────────────────────────────────
+
+// Passkey is one entry in GET /passkeys.
+type Passkey struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Synced     bool   `json:"synced"` // backup-eligible: lives in a password manager, not one device
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+func userPasskeyIDs(userID string) ([][]byte, error) {
+	rows, err := db.Query(`SELECT credential_id FROM user_passkeys WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out [][]byte
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) != nil {
+			continue
+		}
+		if b, err := decodeB64URL(id); err == nil {
+			out = append(out, b)
+		}
+	}
+	return out, rows.Err()
+}
+
+func listPasskeys(userID string) ([]Passkey, error) {
+	rows, err := db.Query(`SELECT id, name, backup_eligible, created_at, last_used_at
+		FROM user_passkeys WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Passkey{}
+	for rows.Next() {
+		var p Passkey
+		var created time.Time
+		var used sql.NullTime
+		if rows.Scan(&p.ID, &p.Name, &p.Synced, &created, &used) != nil {
+			continue
+		}
+		p.CreatedAt = created.UTC().Format(time.RFC3339)
+		if used.Valid {
+			p.LastUsedAt = used.Time.UTC().Format(time.RFC3339)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// registerPasskey finishes a registration ceremony and stores the passkey.
+func registerPasskey(userID, ceremonyID, name string, clientDataJSON, attestationObject []byte) (int, error) {
+	c, err := takePasskeyCeremony(ceremonyID, passkeyPurposeRegister, userID)
+	if err != nil {
+		return 0, err
+	}
+	cred, err := verifyRegistration(webauthnRP(), c.Challenge, clientDataJSON, attestationObject, true)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user_passkeys WHERE user_id = $1`, userID).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n >= maxPasskeysPerUser {
+		return 0, errPasskeyLimit
+	}
+	name = truncateText(strings.TrimSpace(name), 60)
+	if name == "" {
+		name = "Passkey"
+	}
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO user_passkeys (user_id, credential_id, public_key, alg, sign_count, aaguid, backup_eligible, name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		userID, b64url.EncodeToString(cred.ID), cred.PublicKey, cred.Alg, int64(cred.SignCount),
+		b64url.EncodeToString(cred.AAGUID), cred.BackupEligible, name).Scan(&id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return 0, errPasskeyExists
+	}
+	return id, err
+}
+
+// passkeyAssertion is the "passkey" object in a POST /login body: the
+// ceremony id from /passkeys/login/begin and the authenticator's response,
+// binary fields base64url.
+type passkeyAssertion struct {
+	CeremonyID        string `json:"ceremonyId"`
+	CredentialID      string `json:"credentialId"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// verifyPasskeyLogin checks a sign-in assertion and returns whose passkey
+// signed it.
+func verifyPasskeyLogin(p passkeyAssertion) (string, error) {
+	c, err := takePasskeyCeremony(p.CeremonyID, passkeyPurposeLogin, "")
+	if err != nil {
+		return "", err
+	}
+	clientData, err1 := decodeB64URL(p.ClientDataJSON)
+	authData, err2 := decodeB64URL(p.AuthenticatorData)
+	sig, err3 := decodeB64URL(p.Signature)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", errWebAuthnMalformed
+	}
+
+	var id int
+	var userID string
+	var stored int64
+	cred := webauthnCredential{}
+	err = db.QueryRow(`SELECT id, user_id::text, public_key, sign_count FROM user_passkeys WHERE credential_id = $1`,
+		strings.TrimRight(p.CredentialID, "=")).Scan(&id, &userID, &cred.PublicKey, &stored)
+	if err == sql.ErrNoRows {
+		return "", errPasskeyUnknown
+	}
+	if err != nil {
+		return "", err
+	}
+	// The user handle is optional for a credential we looked up by id, but
+	// when it is sent it must name the same account.
+	if p.UserHandle != "" {
+		if h, err := decodeB64URL(p.UserHandle); err != nil || string(h) != userID {
+			return "", errPasskeyUnknown
+		}
+	}
+	cred.SignCount = uint32(stored)
+	count, err := verifyAssertion(webauthnRP(), c.Challenge, cred, clientData, authData, sig, true)
+	if err != nil {
+		return "", err
+	}
+	res, err := db.Exec(`UPDATE user_passkeys SET sign_count = $2, last_used_at = NOW()
+		WHERE id = $1 AND sign_count = $3`, id, int64(count), stored)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", errWebAuthnCounter
+	}
+	return userID, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handlers
+// ─────────────────────────────────────────────────────────────────────────────
+
+type passkeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// BeginPasskeyRegistrationHandler — POST /api/v1/passkeys/register/begin
+// Returns { ceremonyId, publicKey } where publicKey is the
+// PublicKeyCredentialCreationOptions, binary fields base64url.
+func BeginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	userID := authUserID(r)
+	existing, err := userPasskeyIDs(userID)
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxPasskeysPerUser {
+		http.Error(w, errPasskeyLimit.Error(), http.StatusConflict)
+		return
+	}
+	id, c, err := beginPasskeyCeremony(passkeyPurposeRegister, userID)
+	if err != nil {
+		http.Error(w, "could not start registration", http.StatusInternalServerError)
+		return
+	}
+	exclude := make([]passkeyDescriptor, 0, len(existing))
+	for _, e := range existing {
+		exclude = append(exclude, passkeyDescriptor{Type: "public-key", ID: b64url.EncodeToString(e)})
+	}
+	params := make([]map[string]any, 0, len(webauthnAlgs))
+	for _, alg := range webauthnAlgs {
+		params = append(params, map[string]any{"type": "public-key", "alg": alg})
+	}
+	rp := webauthnRP()
+	username := authUsername(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ceremonyId": id,
+		"publicKey": map[string]any{
+			"challenge": b64url.EncodeToString(c.Challenge),
+			"rp":        map[string]string{"id": rp.ID, "name": rp.Name},
+			// The user handle is the user id: stable, and not personal data.
+			"user":               map[string]string{"id": b64url.EncodeToString([]byte(userID)), "name": username, "displayName": username},
+			"pubKeyCredParams":   params,
+			"excludeCredentials": exclude,
+			"authenticatorSelection": map[string]any{
+				"residentKey":        "required",
+				"requireResidentKey": true,
+				"userVerification":   "required",
+			},
+			"attestation": "none",
+			"timeout":     passkeyCeremonyTimeout.Milliseconds(),
+		},
+	})
+}
+
+// FinishPasskeyRegistrationHandler — POST /api/v1/passkeys/register/finish
+// Body: { ceremonyId, name?, clientDataJSON, attestationObject }
+func FinishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		CeremonyID        string `json:"ceremonyId"`
+		Name              string `json:"name"`
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	clientData, err1 := decodeB64URL(p.ClientDataJSON)
+	attObj, err2 := decodeB64URL(p.AttestationObject)
+	if err1 != nil || err2 != nil {
+		http.Error(w, errWebAuthnMalformed.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := registerPasskey(authUserID(r), p.CeremonyID, p.Name, clientData, attObj)
+	switch {
+	case errors.Is(err, errPasskeyLimit), errors.Is(err, errPasskeyExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errPasskeyCeremony):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case webauthnRejected(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("passkeys: register for %s: %v", authUserID(r), err)
+		http.Error(w, "registration failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+}
+
+// BeginPasskeyLoginHandler — POST /passkeys/login/begin
+// Returns { ceremonyId, publicKey } where publicKey is the
+// PublicKeyCredentialRequestOptions. No allowCredentials: the
+// authenticator offers whichever passkeys it holds for this site.
+func BeginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAction("ip:"+clientIP(r), "login") {
+		writeRateLimited(w, "login")
+		return
+	}
+	id, c, err := beginPasskeyCeremony(passkeyPurposeLogin, "")
+	if err != nil {
+		http.Error(w, "could not start sign-in", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ceremonyId": id,
+		"publicKey": map[string]any{
+			"challenge":        b64url.EncodeToString(c.Challenge),
+			"rpId":             webauthnRP().ID,
+			"userVerification": "required",
+			"timeout":          passkeyCeremonyTimeout.Milliseconds(),
+		},
+	})
+}
+
+// ListPasskeysHandler — GET /api/v1/passkeys
+func ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	keys, err := listPasskeys(authUserID(r))
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"passkeys": keys})
+}
+
+// DeletePasskeyHandler — DELETE /api/v1/passkeys/{id}
+// Removing a user's last passkey is allowed: every account has a password,
+// so it cannot lock anyone out.
+func DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, errPasskeyUnknown.Error(), http.StatusNotFound)
+		return
+	}
+	res, err := db.Exec(`DELETE FROM user_passkeys WHERE id = $1 AND user_id = $2`, id, authUserID(r))
+	if err != nil {
+		http.Error(w, "delete failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, errPasskeyUnknown.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// A software authenticator: what a phone's passkey provider does, with a key
+// held in memory, so the ceremonies can be tested end to end.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// cborPair is one map entry; a slice of them encodes as a map in order.
+type cborPair struct {
+	k, v any
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	default:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+}
+
+func cborEncode(v any) []byte {
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return cborHead(1, uint64(-1-x))
+		}
+		return cborHead(0, uint64(x))
+	case []byte:
+		return append(cborHead(2, uint64(len(x))), x...)
+	case string:
+		return append(cborHead(3, uint64(len(x))), x...)
+	case []cborPair:
+		out := cborHead(5, uint64(len(x)))
+		for _, p := range x {
+			out = append(out, cborEncode(p.k)...)
+			out = append(out, cborEncode(p.v)...)
+		}
+		return out
+	}
+	panic("cborEncode: unsupported type")
+}
+
+type softAuthenticator struct {
+	rpID, origin string
+	credID       []byte
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey // used instead of ecKey when set
+	counter      uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string) *softAuthenticator {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &softAuthenticator{rpID: rpID, origin: origin, credID: id, ecKey: k,
+		flags: authFlagUserPresent | authFlagUserVerified}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.edKey != nil {
+		return cborEncode([]cborPair{{1, 1}, {3, coseAlgEdDSA}, {-1, 6}, {-2, []byte(a.edKey.Public().(ed25519.PublicKey))}})
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.ecKey.X.FillBytes(x)
+	a.ecKey.Y.FillBytes(y)
+	return cborEncode([]cborPair{{1, 2}, {3, coseAlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	raw, _ := json.Marshal(webauthnClientData{Type: typ, Challenge: b64url.EncodeToString(challenge), Origin: a.origin})
+	return raw
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	h := sha256.Sum256([]byte(a.rpID))
+	out := append([]byte{}, h[:]...)
+	flags := a.flags
+	if attested {
+		flags |= authFlagAttestedData
+	}
+	out = append(out, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(out[33:], a.counter)
+	if attested {
+		out = append(out, make([]byte, 16)...) // AAGUID
+		out = append(out, byte(len(a.credID)>>8), byte(len(a.credID)))
+		out = append(out, a.credID...)
+		out = append(out, a.coseKey()...)
+	}
+	return out
+}
+
+// create answers navigator.credentials.create().
+func (a *softAuthenticator) create(challenge []byte) (clientDataJSON, attestationObject []byte) {
+	att := cborEncode([]cborPair{{"fmt", "none"}, {"attStmt", []cborPair{}}, {"authData", a.authData(true)}})
+	return a.clientData("webauthn.create", challenge), att
+}
+
+// get answers navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, challenge []byte) (clientDataJSON, authData, sig []byte) {
+	t.Helper()
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(false)
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), cdHash[:]...)
+	if a.edKey != nil {
+		return clientDataJSON, authData, ed25519.Sign(a.edKey, signed)
+	}
+	h := sha256.Sum256(signed)
+	sig, err := ecdsa.SignASN1(rand.Reader, a.ecKey, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientDataJSON, authData, sig
+}
+
+func testRP() relyingParty {
+	return relyingParty{ID: "devf.example", Name: "devf", Origins: map[string]bool{"https://devf.example": true}}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Verification
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestWebAuthnRegisterThenAssert(t *testing.T) {
+	for _, alg := range []string{"ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			rp := testRP()
+			a := newSoftAuthenticator(t, rp.ID, "https://devf.example")
+			if alg == "EdDSA" {
+				_, a.edKey, _ = ed25519.GenerateKey(rand.Reader)
+			}
+			challenge := []byte("registration-challenge-32-bytes!")
+			cd, att := a.create(challenge)
+			cred, err := verifyRegistration(rp, challenge, cd, att, true)
+			if err != nil {
+				t.Fatalf("verifyRegistration: %v", err)
+			}
+			if string(cred.ID) != string(a.credID) {
+				t.Errorf("credential id = %x, want %x", cred.ID, a.credID)
+			}
+
+			a.counter = 7
+			login := []byte("login-challenge-32-bytes-long!!!")
+			cd, ad, sig := a.get(t, login)
+			count, err := verifyAssertion(rp, login, *cred, cd, ad, sig, true)
+			if err != nil || count != 7 {
+				t.Fatalf("verifyAssertion = %d, %v", count, err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRejections(t *testing.T) {
+	rp := testRP()
+	a := newSoftAuthenticator(t, rp.ID, "https://devf.example")
+	challenge := []byte("registration-challenge-32-bytes!")
+	cd, att := a.create(challenge)
+	cred, err := verifyRegistration(rp, challenge, cd, att, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := []byte("login-challenge-32-bytes-long!!!")
+
+	// Answering a different challenge.
+	cd, ad, sig := a.get(t, []byte("some other challenge"))
+	if _, err := verifyAssertion(rp, login, *cred, cd, ad, sig, true); err != errWebAuthnClientData {
+		t.Errorf("wrong challenge: err = %v", err)
+	}
+
+	// From a phishing origin.
+	evil := *a
+	evil.origin = "https://devf-example.evil"
+	cd, ad, sig = evil.get(t, login)
+	if _, err := verifyAssertion(rp, login, *cred, cd, ad, sig, true); err != errWebAuthnOrigin {
+		t.Errorf("wrong origin: err = %v", err)
+	}
+
+	// Scoped to another site.
+	other := *a
+	other.rpID = "other.example"
+	cd, ad, sig = other.get(t, login)
+	if _, err := verifyAssertion(rp, login, *cred, cd, ad, sig, true); err != errWebAuthnRPID {
+		t.Errorf("wrong rp id: err = %v", err)
+	}
+
+	// No user verification.
+	noUV := *a
+	noUV.flags = authFlagUserPresent
+	cd, ad, sig = noUV.get(t, login)
+	if _, err := verifyAssertion(rp, login, *cred, cd, ad, sig, true); err != errWebAuthnFlags {
+		t.Errorf("no UV: err = %v", err)
+	}
+
+	// Tampered authenticator data.
+	cd, ad, sig = a.get(t, login)
+	ad[33] ^= 0xff
+	if _, err := verifyAssertion(rp, login, *cred, cd, ad, sig, true); err != errWebAuthnSignature {
+		t.Errorf("tampered: err = %v", err)
+	}
+
+	// A counter that does not move forward.
+	a.counter = 5
+	cd, ad, sig = a.get(t, login)
+	stored := *cred
+	stored.SignCount = 5
+	if _, err := verifyAssertion(rp, login, stored, cd, ad, sig, true); err != errWebAuthnCounter {
+		t.Errorf("counter replay: err = %v", err)
+	}
+	// …while a synced passkey that always says 0 is fine.
+	a.counter = 0
+	cd, ad, sig = a.get(t, login)
+	stored.SignCount = 0
+	if _, err := verifyAssertion(rp, login, stored, cd, ad, sig, true); err != nil {
+		t.Errorf("zero counter: err = %v", err)
+	}
+}
+
+func TestCBORDecodeRejectsTruncated(t *testing.T) {
+	full := cborEncode([]cborPair{{"authData", []byte("0123456789")}})
+	for i := range len(full) {
+		if _, _, err := cborDecode(full[:i]); err == nil {
+			t.Errorf("decoded %d of %d bytes without error", i, len(full))
+		}
+	}
+	if v, rest, err := cborDecode(append(full, 0x01)); err != nil || len(rest) != 1 {
+		t.Fatalf("cborDecode = %v, %x, %v", v, rest, err)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Ceremonies against storage
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	resetRedis(t)
+	t.Setenv("WEBAUTHN_RP_ID", "devf.example")
+	t.Setenv("WEBAUTHN_ORIGINS", "https://devf.example")
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	a := newSoftAuthenticator(t, "devf.example", "https://devf.example")
+
+	// Register.
+	ceremony, c, err := beginPasskeyCeremony(passkeyPurposeRegister, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, att := a.create(c.Challenge)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM user_passkeys")).WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO user_passkeys")).
+		WithArgs("5", b64url.EncodeToString(a.credID), a.coseKey(), int64(coseAlgES256), int64(0),
+			sqlmock.AnyArg(), false, "Pixel").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	if id, err := registerPasskey("5", ceremony, " Pixel ", cd, att); err != nil || id != 11 {
+		t.Fatalf("registerPasskey = %d, %v", id, err)
+	}
+	// The registration challenge is spent.
+	if _, err := registerPasskey("5", ceremony, "again", cd, att); err != errPasskeyCeremony {
+		t.Errorf("second finish: err = %v", err)
+	}
+
+	// Sign in.
+	ceremony, c, _ = beginPasskeyCeremony(passkeyPurposeLogin, "")
+	a.counter = 1
+	cd, ad, sig := a.get(t, c.Challenge)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_passkeys WHERE credential_id = $1")).
+		WithArgs(b64url.EncodeToString(a.credID)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "public_key", "sign_count"}).
+			AddRow(11, "5", a.coseKey(), 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_passkeys SET sign_count = $2")).
+		WithArgs(11, int64(1), int64(0)).WillReturnResult(sqlmock.NewResult(0, 1))
+	userID, err := verifyPasskeyLogin(passkeyAssertion{
+		CeremonyID:        ceremony,
+		CredentialID:      b64url.EncodeToString(a.credID),
+		ClientDataJSON:    b64url.EncodeToString(cd),
+		AuthenticatorData: b64url.EncodeToString(ad),
+		Signature:         b64url.EncodeToString(sig),
+		UserHandle:        b64url.EncodeToString([]byte("5")),
+	})
+	if err != nil || userID != "5" {
+		t.Fatalf("verifyPasskeyLogin = %q, %v", userID, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A registration ceremony can't be finished by a different account.
+func TestPasskeyCeremonyBoundToUser(t *testing.T) {
+	resetRedis(t)
+	ceremony, _, err := beginPasskeyCeremony(passkeyPurposeRegister, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := takePasskeyCeremony(ceremony, passkeyPurposeRegister, "6"); err != errPasskeyCeremony {
+		t.Errorf("other user: err = %v", err)
+	}
+	if _, err := takePasskeyCeremony(ceremony, passkeyPurposeRegister, "5"); err != errPasskeyCeremony {
+		t.Errorf("ceremony survived a failed take: err = %v", err)
+	}
+}
@@ -16,6 +16,9 @@ import "golang.org/x/crypto/bcrypt"
 // bcrypt silently truncates input at 72 bytes; that's far beyond any realistic
 // password and matches every other bcrypt deployment, so we don't pre-hash.
 
+// minPasswordLen is the shortest password signup or a reset accepts.
+const minPasswordLen = 6
+
 // hashPassword returns a bcrypt hash of the plaintext password at the default
 // cost (currently 10).
 func hashPassword(plain string) (string, error) {
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -76,11 +77,13 @@ func UpdateUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if payload.Visibility != nil {
-		v := *payload.Visibility
-		if v != "public" && v != "friends" {
-			http.Error(w, "visibility must be public or friends", http.StatusBadRequest)
+		// "friends" is the old name for private; it is stored as private.
+		v := normalizeVisibility(*payload.Visibility)
+		if v == "" {
+			http.Error(w, "visibility must be public or private", http.StatusBadRequest)
 			return
 		}
+		payload.Visibility = &v
 	}
 
 	// Build dynamic UPDATE. Anything not sent stays unchanged — we
@@ -144,6 +147,19 @@ func UpdateUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Going private takes effect on this replica now, not at the next
+	// snapshot reload. Going public lets in everyone still waiting — there
+	// is nothing left for them to be approved for. See follow_requests.go.
+	if payload.Visibility != nil {
+		private := *payload.Visibility == visibilityPrivate
+		privacyMarkPrivate(pathID, private)
+		if !private {
+			if _, err := approveAllFollowRequests(pathID); err != nil {
+				log.Printf("profile %s: approving pending follow requests: %v", pathID, err)
+			}
+		}
+	}
+
 	// Round-trip the fresh user so the client doesn't have to make a
 	// follow-up GET to reflect its own edit.
 	updated, ok := GetUserByID(pathID)
@@ -173,6 +189,9 @@ func GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing user id", http.StatusBadRequest)
 		return
 	}
+	if !requireAccountVisible(w, r, id) {
+		return
+	}
 	limit := parseIntOrDefault(r.URL.Query().Get("limit"), 30, 100)
 	page := parseIntOrDefault(r.URL.Query().Get("page"), 1, 1_000_000)
 	users := GetFollowers(id, limit, (page-1)*limit)
@@ -183,12 +202,18 @@ func GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetFollowingHandler — GET /api/v1/users/{id}/following?page=&limit=
+//
+// Both lists belong to the account's detail: a private account's are for
+// its approved followers (requireAccountVisible).
 func GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	if id == "" {
 		http.Error(w, "missing user id", http.StatusBadRequest)
 		return
 	}
+	if !requireAccountVisible(w, r, id) {
+		return
+	}
 	limit := parseIntOrDefault(r.URL.Query().Get("limit"), 30, 100)
 	page := parseIntOrDefault(r.URL.Query().Get("page"), 1, 1_000_000)
 	users := GetFollowing(id, limit, (page-1)*limit)
@@ -198,6 +223,22 @@ func GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, users)
 }
 
+// requireAccountVisible writes a 403 (returning false) when the signed-in
+// user may not see the account's detail: it is private and they are not an
+// approved follower. A missing or deleted account is a 404.
+func requireAccountVisible(w http.ResponseWriter, r *http.Request, id string) bool {
+	owner, ok := GetUserByID(id)
+	if !ok || accountDeactivated(owner.ID) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return false
+	}
+	if !canViewAccount(authUserID(r), owner) {
+		http.Error(w, "this account is private", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 // ════════════════════════════════════════════════════════════════════
 // Liked videos
 // ════════════════════════════════════════════════════════════════════
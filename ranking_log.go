@@ -0,0 +1,266 @@
+package main
+
+// ranking_log.go — what the ranker served, kept so a ranking change can be
+// judged before it ships.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Every change to the ranker — a weight in scoreForUser, a row of
+// cohortWeightTable, how much the LTR heads are trusted, the MMR lambdas —
+// has shipped blind. The tests exercise synthetic users
+// (algorithm_full_simulation_test.go); the experiments framework measures a
+// change only after real users are already getting it. Between the two there
+// was nothing that could say "this would have done better on last week's
+// traffic".
+//
+// This log is that something. For a sample of For You pages it keeps the
+// page exactly as served — every item's position, the propensity of that
+// position (positionPropensity, the same examination curve LTR trains on),
+// its final score and the full score breakdown — and then fills in what the
+// user did with each item. cmd/rankeval replays a candidate policy over these
+// pages and estimates, by inverse propensity scoring, how completions, likes
+// and skips would have moved had the candidate ranked them instead.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT IS LOGGED
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// One row per served item, grouped into pages by request_id. A page is sampled
+// whole (rankingLogSampleRate, RANKING_LOG_SAMPLE to override) — an estimator
+// needs complete slates, and half a page is useless to it. Impressions are far
+// too many to keep in full (impression_aggregator.go); a few percent of pages
+// is plenty to estimate a rate and cheap to store.
+//
+// Outcomes arrive later, as events. Logging a page leaves a marker in Redis
+// per item naming the page it was on; a like, completion or skip for that item
+// within rankingLogOutcomeWindow flips the matching flag on the row. Events
+// for items that were not logged cost one Redis miss and nothing else.
+//
+// The breakdown carries each base-score term as it was added (socialTerm,
+// freshnessTerm, …) as well as the additive bonuses, penalties and learned
+// corrections, so a candidate can rescale any of them. Each item's content
+// embedding and creator are kept too, which is what replaying the MMR
+// diversity pass needs. Rows go after rankingLogRetention.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// rankingLogSampleRate is the share of For You pages logged.
+	rankingLogSampleRate = 0.05
+	// rankingLogOutcomeWindow is how long after serving an event still
+	// counts as the outcome of that impression. Same horizon as the LTR
+	// breakdown stash (ltrBreakdownTTL).
+	rankingLogOutcomeWindow = 30 * time.Minute
+	rankingLogRetention     = 30 * 24 * time.Hour
+	rankingLogPruneInterval = 6 * time.Hour
+)
+
+var (
+	rankingLogSampleOnce sync.Once
+	rankingLogSampleVal  float64
+)
+
+// rankingLogSample returns the configured sample rate. RANKING_LOG_SAMPLE is
+// a fraction in [0,1]; 0 turns the log off. Read once.
+func rankingLogSample() float64 {
+	rankingLogSampleOnce.Do(func() {
+		rankingLogSampleVal = rankingLogSampleRate
+		if v := strings.TrimSpace(os.Getenv("RANKING_LOG_SAMPLE")); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+				rankingLogSampleVal = f
+			}
+		}
+	})
+	return rankingLogSampleVal
+}
+
+// rankingLogRow is one served item.
+type rankingLogRow struct {
+	ContentType string
+	ContentID   string
+	CreatorID   string
+	Source      string
+	Position    int
+	Propensity  float64
+	Score       float64
+	Breakdown   map[string]float64
+	Embedding   []float64
+}
+
+// rankingLogOutcomeColumn maps an event to the outcome flag it sets, or "".
+func rankingLogOutcomeColumn(eventType string) string {
+	switch eventType {
+	case "complete":
+		return "completed"
+	case "like":
+		return "liked"
+	case "skip":
+		return "skipped"
+	}
+	return ""
+}
+
+func rankingLogMarkKey(userID, contentType, contentID string) string {
+	return fmt.Sprintf("rlog:%s:%s:%s", userID, contentType, contentID)
+}
+
+// logRankedPage samples a served For You page into the log under its request
+// id, with the registry version that served it (model_registry.go; 0 for
+// none). The rows are assembled here, from the request's own data; the
+// embeddings, the write and the outcome markers happen in the background.
+func logRankedPage(requestID, userID, sessionID string, cohort Cohort, modelVersion int64, composed []ScoredItem, sourceMap map[string]string) {
+	if db == nil || requestID == "" || len(composed) == 0 || rand.Float64() >= rankingLogSample() {
+		return
+	}
+	if _, err := strconv.Atoi(userID); err != nil {
+		return
+	}
+	rows := make([]rankingLogRow, 0, len(composed))
+	for idx, it := range composed {
+		if it.ScoreBreakdown == nil {
+			// Injected rather than ranked (audition, bootstrap, surprise):
+			// there is no score to replay, so the page is logged without
+			// them and their positions are left as gaps.
+			continue
+		}
+		cid := getItemID(it.Item)
+		row := rankingLogRow{
+			ContentType: it.Item.Type,
+			ContentID:   cid,
+			CreatorID:   getItemCreatorID(it.Item),
+			Position:    idx + 1,
+			Propensity:  positionPropensity(idx + 1),
+			Score:       it.Score,
+			Breakdown:   it.ScoreBreakdown,
+		}
+		if sourceMap != nil {
+			row.Source = sourceMap[it.Item.Type+":"+cid]
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return
+	}
+	variants := map[string]string{}
+	for _, exp := range getActiveExperiments() {
+		if exp.Active {
+			variants[exp.ID] = assignVariant(userID, exp.ID)
+		}
+	}
+	go func() {
+		for i := range rows {
+			cs := getContentScore(rows[i].ContentID, rows[i].ContentType)
+			if cs != nil {
+				rows[i].Embedding = getOrBuildContentEmbedding(cs, getContentEmotions(rows[i].ContentID, rows[i].ContentType))
+			}
+		}
+		if err := insertRankingLog(requestID, modelVersion, userID, sessionID, string(cohort), variants, rows); err != nil {
+			log.Printf("ranking log: writing page for %s: %v", userID, err)
+			return
+		}
+		if rdb == nil {
+			return
+		}
+		pipe := rdb.Pipeline()
+		for _, row := range rows {
+			pipe.Set(rctx, rankingLogMarkKey(userID, row.ContentType, row.ContentID), requestID, rankingLogOutcomeWindow)
+		}
+		if _, err := pipe.Exec(rctx); err != nil {
+			log.Printf("ranking log: marking page for %s: %v", userID, err)
+		}
+	}()
+}
+
+// insertRankingLog writes one page in a single statement.
+func insertRankingLog(requestID string, modelVersion int64, userID, sessionID, cohort string, variants map[string]string, rows []rankingLogRow) error {
+	variantsJSON, err := json.Marshal(variants)
+	if err != nil {
+		return err
+	}
+	const cols = 13
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO ranking_impressions (request_id, model_version, user_id, session_id, cohort, variants,
+		position, propensity, content_type, content_id, creator_id, source, score, breakdown, embedding) VALUES `)
+	var versionArg any
+	if modelVersion != 0 {
+		versionArg = modelVersion
+	}
+	args := make([]any, 0, len(rows)*cols+2)
+	args = append(args, requestID, versionArg)
+	for i, row := range rows {
+		bd, err := json.Marshal(row.Breakdown)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&sb, "($1, $2, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11, n+12, n+13)
+		var emb any
+		if len(row.Embedding) > 0 {
+			emb = pq.Array(row.Embedding)
+		}
+		args = append(args, userID, sessionID, cohort, string(variantsJSON),
+			row.Position, row.Propensity, row.ContentType, row.ContentID, row.CreatorID, row.Source,
+			row.Score, string(bd), emb)
+	}
+	_, err = db.Exec(sb.String(), args...)
+	return err
+}
+
+// recordRankingOutcome sets the outcome flag an event implies on the logged
+// impression it belongs to, if the item was on a logged page.
+func recordRankingOutcome(event FeedEvent) {
+	col := rankingLogOutcomeColumn(event.EventType)
+	if col == "" || rdb == nil || db == nil || event.UserID == "" || event.ContentID == "" {
+		return
+	}
+	requestID, err := rdb.Get(rctx, rankingLogMarkKey(event.UserID, event.ContentType, event.ContentID)).Result()
+	if err != nil || requestID == "" {
+		return
+	}
+	// col is one of three literals from rankingLogOutcomeColumn.
+	if _, err := db.Exec(`UPDATE ranking_impressions SET `+col+` = TRUE
+		WHERE request_id = $1 AND content_type = $2 AND content_id = $3`,
+		requestID, event.ContentType, event.ContentID); err != nil {
+		log.Printf("ranking log: recording %s on %s:%s: %v", event.EventType, event.ContentType, event.ContentID, err)
+	}
+}
+
+// startRankingLogPruner drops logged pages past rankingLogRetention.
+func startRankingLogPruner() {
+	go func() {
+		t := time.NewTicker(rankingLogPruneInterval)
+		defer t.Stop()
+		for range t.C {
+			if db == nil {
+				continue
+			}
+			res, err := db.Exec(`DELETE FROM ranking_impressions WHERE served_at < NOW() - ($1)::interval`,
+				fmt.Sprintf("%d seconds", int(rankingLogRetention.Seconds())))
+			if err != nil {
+				log.Printf("ranking log: pruning: %v", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				log.Printf("ranking log: pruned %d impressions", n)
+			}
+		}
+	}()
+}
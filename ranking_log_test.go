@@ -0,0 +1,63 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// An outcome on a logged item flips the matching flag on its page's row.
+func TestRecordRankingOutcomeUpdatesLoggedImpression(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	rdb.Set(rctx, rankingLogMarkKey("5", "challenge", "42"), "req1", rankingLogOutcomeWindow)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE ranking_impressions SET completed = TRUE")).
+		WithArgs("req1", "challenge", "42").WillReturnResult(sqlmock.NewResult(0, 1))
+	recordRankingOutcome(FeedEvent{UserID: "5", ContentType: "challenge", ContentID: "42", EventType: "complete"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Items that were not on a logged page, and events that are not outcomes,
+// never reach the database.
+func TestRecordRankingOutcomeIgnoresUnloggedAndOtherEvents(t *testing.T) {
+	resetRedis(t)
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	rdb.Set(rctx, rankingLogMarkKey("5", "challenge", "42"), "req1", rankingLogOutcomeWindow)
+
+	recordRankingOutcome(FeedEvent{UserID: "5", ContentType: "challenge", ContentID: "43", EventType: "like"})
+	recordRankingOutcome(FeedEvent{UserID: "5", ContentType: "challenge", ContentID: "42", EventType: "view"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertRankingLogWritesOnePageInOneStatement(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+	rows := []rankingLogRow{
+		{ContentType: "challenge", ContentID: "1", Position: 1, Propensity: 1, Score: 2.1,
+			Breakdown: map[string]float64{"socialTerm": 0.3}},
+		{ContentType: "post", ContentID: "9", Position: 3, Propensity: positionPropensity(3), Score: 1.4,
+			Breakdown: map[string]float64{"socialTerm": 0.1}, Embedding: []float64{0.6, 0.8}},
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO ranking_impressions")).
+		WithArgs("req1", int64(12),
+			"5", "s1", "casual", `{"exp":"control"}`, 1, 1.0, "challenge", "1", "", "", 2.1, `{"socialTerm":0.3}`, nil,
+			"5", "s1", "casual", `{"exp":"control"}`, 3, positionPropensity(3), "post", "9", "", "", 1.4, `{"socialTerm":0.1}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := insertRankingLog("req1", 12, "5", "s1", "casual", map[string]string{"exp": "control"}, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
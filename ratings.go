@@ -0,0 +1,901 @@
+package main
+
+// ratings.go — Glicko-2 skill ratings for battlers, and the leagues and
+// matchmaking built on them.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY A RATING AND NOT JUST A LEAGUE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// A league is five names. It cannot say that one Gold player is nearly
+// Platinum and another barely scraped in, and it cannot say how sure we are —
+// somebody with two lucky wins and somebody with two hundred battles looked the
+// same. Matchmaking was "within two tiers", which let a fresh Bronze account
+// face a Gold veteran and stopped two players 10 points apart if a band edge
+// happened to sit between them.
+//
+// So every battler has a Glicko-2 rating: a number on the familiar
+// 1500-centred scale, a deviation saying how unsure that number is, and a
+// volatility saying how erratic their results are. One per category (dancing
+// well says nothing about comedy) and one overall.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT READS IT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	leagues      — users.league is a banding over the OVERALL rating, made
+//	               conservative (rating minus two deviations) so a newcomer is
+//	               not crowned Diamond on one win. Still written to users.league
+//	               because search, suggestions and the feed read that column.
+//	matchmaking  — checkLeagueEligibility compares ratings in the challenge's
+//	               category, falling back to overall, and allows up to
+//	               maxRatingGap points between the two.
+//	profiles     — GET /users/{username} carries the ratings and recent history.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHERE IT IS WRITTEN
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Live: inside the transaction that settles a battle (battle_resolution.go),
+// so a battle's result, its win/loss records and its rating changes commit
+// together or not at all.
+//
+// Rebuild: POST /admin/ratings/rebuild throws every rating away and replays
+// every settled battle from its votes, oldest first, through exactly the same
+// functions. Same votes in, same ratings out — which is what makes it safe to
+// change a constant here and rebuild, rather than living with ratings that were
+// computed under an older rule.
+//
+// Battles with no winner leave ratings alone, as they leave records alone.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ════════════════════════════════════════════════════════════════════════════════
+// GLICKO-2
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Straight from Glickman, "Example of the Glicko-2 system" — the variable names
+// below follow the paper so the two can be read side by side.
+
+const (
+	glickoScale         = 173.7178 // converts between the 1500 scale and Glicko-2's internal one
+	glickoDefaultRating = 1500.0
+	glickoDefaultRD     = 350.0
+	glickoDefaultVol    = 0.06
+	// glickoTau constrains how fast volatility can move. The paper suggests
+	// 0.3–1.2; the low end suits a game as noisy as a popularity vote.
+	glickoTau = 0.5
+	// glickoConvergence is the tolerance of the volatility search.
+	glickoConvergence = 1e-6
+	// glickoRatingPeriod is how much idle time counts as one empty rating
+	// period when a returning player's deviation is grown back.
+	glickoRatingPeriod = 7 * 24 * time.Hour
+
+	// overallRatingCategory is the user_ratings category that spans all of
+	// them.
+	overallRatingCategory = "overall"
+)
+
+// glickoRating is one rating on the public (1500-centred) scale.
+type glickoRating struct {
+	rating float64
+	rd     float64
+	vol    float64
+}
+
+func newGlickoRating() glickoRating {
+	return glickoRating{rating: glickoDefaultRating, rd: glickoDefaultRD, vol: glickoDefaultVol}
+}
+
+// glickoGame is one result against one opponent: score 1 for a win, 0 for a
+// loss, 0.5 for a draw.
+type glickoGame struct {
+	opp   glickoRating
+	score float64
+}
+
+// decayed grows the deviation for time spent away — the paper's step for a
+// player who sat out a rating period, applied once per glickoRatingPeriod of
+// idleness (fractionally, so the answer does not jump at week boundaries).
+// Never past the deviation of somebody who has never played.
+func (g glickoRating) decayed(idle time.Duration) glickoRating {
+	if idle <= 0 {
+		return g
+	}
+	periods := float64(idle) / float64(glickoRatingPeriod)
+	phi := g.rd / glickoScale
+	phi = math.Sqrt(phi*phi + periods*g.vol*g.vol)
+	g.rd = math.Min(phi*glickoScale, glickoDefaultRD)
+	return g
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu, muJ, phiJ float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(phiJ)*(mu-muJ)))
+}
+
+// glicko2Update is one rating period for one player: their rating before, and
+// every game they played in it. Pure — the same inputs always produce the same
+// rating, which is what the rebuild relies on.
+func glicko2Update(p glickoRating, games []glickoGame) glickoRating {
+	mu := (p.rating - glickoDefaultRating) / glickoScale
+	phi := p.rd / glickoScale
+	sigma := p.vol
+
+	if len(games) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return glickoRating{rating: p.rating, rd: math.Min(phi*glickoScale, glickoDefaultRD), vol: sigma}
+	}
+
+	// Step 3–4: estimated variance v and improvement delta.
+	var vInv, sum float64
+	for _, gm := range games {
+		muJ := (gm.opp.rating - glickoDefaultRating) / glickoScale
+		phiJ := gm.opp.rd / glickoScale
+		g := glickoG(phiJ)
+		e := glickoE(mu, muJ, phiJ)
+		vInv += g * g * e * (1 - e)
+		sum += g * (gm.score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	// Step 5: new volatility, by the paper's Illinois-method search.
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		num := ex * (delta*delta - phi*phi - v - ex)
+		den := 2 * (phi*phi + v + ex) * (phi*phi + v + ex)
+		return num/den - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoConvergence {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	sigmaNew := math.Exp(A / 2)
+
+	// Step 6–7: new deviation and rating.
+	phiStar := math.Sqrt(phi*phi + sigmaNew*sigmaNew)
+	phiNew := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	muNew := mu + phiNew*phiNew*sum
+
+	return glickoRating{
+		rating: muNew*glickoScale + glickoDefaultRating,
+		rd:     math.Min(phiNew*glickoScale, glickoDefaultRD),
+		vol:    sigmaNew,
+	}
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// A BATTLE AS GLICKO GAMES
+// ════════════════════════════════════════════════════════════════════════════════
+
+// ratingState is a stored rating plus what the live and rebuild paths need to
+// carry alongside it.
+type ratingState struct {
+	glickoRating
+	battles   int
+	updatedAt time.Time
+}
+
+// rateBattle turns one settled battle into new ratings for everybody in it.
+//
+// A battle is one rating period in which every participant played every other
+// one. The winner beat each of the others; between two non-winners, more
+// (weighted) votes beats fewer and equal is a draw. Somebody with two sides in the same
+// battle plays as their better one — they do not play themselves.
+//
+// prior is each participant's rating going in (missing means never rated);
+// the result holds only the participants. Pure, and used by both the live
+// resolver and the rebuild so the two cannot disagree.
+func rateBattle(sides []battleSide, winner int, prior map[string]ratingState, at time.Time) map[string]ratingState {
+	if winner < 0 || winner >= len(sides) {
+		return nil
+	}
+	winnerID := sides[winner].userID
+
+	// One entry per user: the winning side if they own it, otherwise the side
+	// with the most votes.
+	type entry struct {
+		userID string
+		weight float64
+	}
+	best := map[string]float64{}
+	var order []string
+	for _, s := range sides {
+		v, seen := best[s.userID]
+		if !seen {
+			order = append(order, s.userID)
+		}
+		if !seen || s.weight > v {
+			best[s.userID] = s.weight
+		}
+	}
+	if len(order) < 2 {
+		return nil // a battle against yourself rates nothing
+	}
+	players := make([]entry, 0, len(order))
+	for _, id := range order {
+		players = append(players, entry{userID: id, weight: best[id]})
+	}
+
+	// Everybody's rating going in, with idle time applied, read once so that
+	// every game in the period is scored against pre-battle ratings.
+	pre := make(map[string]ratingState, len(players))
+	for _, p := range players {
+		st, ok := prior[p.userID]
+		if !ok || st.battles == 0 {
+			st = ratingState{glickoRating: newGlickoRating()}
+		} else {
+			st.glickoRating = st.glickoRating.decayed(at.Sub(st.updatedAt))
+		}
+		pre[p.userID] = st
+	}
+
+	out := make(map[string]ratingState, len(players))
+	for _, p := range players {
+		games := make([]glickoGame, 0, len(players)-1)
+		for _, o := range players {
+			if o.userID == p.userID {
+				continue
+			}
+			score := 0.5
+			switch {
+			case p.userID == winnerID:
+				score = 1
+			case o.userID == winnerID:
+				score = 0
+			case p.weight > o.weight:
+				score = 1
+			case p.weight < o.weight:
+				score = 0
+			}
+			games = append(games, glickoGame{opp: pre[o.userID].glickoRating, score: score})
+		}
+		st := pre[p.userID]
+		out[p.userID] = ratingState{
+			glickoRating: glicko2Update(st.glickoRating, games),
+			battles:      st.battles + 1,
+			updatedAt:    at,
+		}
+	}
+	return out
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// LEAGUES AS BANDS OVER THE RATING
+// ════════════════════════════════════════════════════════════════════════════════
+
+// leagueRung is one league and the conservative rating it starts at.
+type leagueRung struct {
+	name  string
+	floor float64
+}
+
+// leagueLadder is leagueTier in order, with the conservative rating (rating
+// minus two deviations) each league starts at. Index+1 is the tier number
+// leagueTier uses.
+//
+// Conservative, so a league means "we are fairly sure they are at least this
+// good". A newcomer (1500 ± 350) reads as 800 and starts in Bronze however
+// they did in their first battle; the bands open up as the deviation closes.
+var leagueLadder = []leagueRung{
+	{name: "Bronze", floor: 0},
+	{name: "Silver", floor: 1150},
+	{name: "Gold", floor: 1350},
+	{name: "Platinum", floor: 1550},
+	{name: "Diamond", floor: 1750},
+}
+
+// leagueDemotionCushion is how far below a league's floor the conservative
+// rating has to fall before the user drops out of it. Without a cushion
+// somebody sitting exactly on a floor would be promoted and demoted on
+// alternate battles.
+const leagueDemotionCushion = 50.0
+
+// conservativeRating is the rating we are fairly (~95%) sure the player is at
+// least as good as.
+func conservativeRating(g glickoRating) float64 {
+	return g.rating - 2*g.rd
+}
+
+// leagueAfterResult returns the league a user belongs in after a battle,
+// given their league going in and their overall rating coming out.
+//
+// At most one step per battle in either direction. A promotion is something
+// the user is told about, and five in one notification reads like a bug.
+func leagueAfterResult(current string, overall glickoRating) string {
+	idx := ladderRung(current)
+	r := conservativeRating(overall)
+
+	if idx+1 < len(leagueLadder) && r >= leagueLadder[idx+1].floor {
+		return leagueLadder[idx+1].name
+	}
+	if idx > 0 && r < leagueLadder[idx].floor-leagueDemotionCushion {
+		return leagueLadder[idx-1].name
+	}
+	return leagueLadder[idx].name
+}
+
+// ladderRung is a league's position in leagueLadder. Unknown or empty names
+// are Bronze, the same default matchmaking has always applied.
+func ladderRung(name string) int {
+	for i, r := range leagueLadder {
+		if r.name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// MATCHMAKING
+// ════════════════════════════════════════════════════════════════════════════════
+
+// maxRatingGap is how far apart two battlers' ratings may be. 400 points is
+// where Glicko expects the stronger one to win about nine battles in ten —
+// past that a battle is not much of a contest.
+const maxRatingGap = 400.0
+
+// leagueSeedRating is the rating matchmaking assumes for somebody who has never
+// fought a rated battle: their league's tier, 200 points a tier around 1500.
+// Keeps the old "two tiers apart" rule for everybody the ratings do not know
+// yet — a seeded Diamond still cannot be challenged by a brand-new Bronze —
+// and stops mattering after their first battle.
+func leagueSeedRating(league string) float64 {
+	tier, ok := leagueTier[league]
+	if !ok {
+		tier = 1
+	}
+	return glickoDefaultRating + float64(tier-3)*200
+}
+
+// matchmakingRating is the rating a user is matched on in a category: their
+// rating there if they have battled in it, otherwise overall, otherwise the
+// seed for their league.
+func matchmakingRating(userID, league, category string) float64 {
+	if db == nil {
+		return leagueSeedRating(league)
+	}
+	rows, err := db.Query(`
+		SELECT category, rating
+		FROM user_ratings
+		WHERE user_id = CAST($1 AS INT)
+		  AND category IN ($2, $3)
+		  AND battles > 0`, userID, category, overallRatingCategory)
+	if err != nil {
+		return leagueSeedRating(league)
+	}
+	defer rows.Close()
+
+	found := map[string]float64{}
+	for rows.Next() {
+		var cat string
+		var r float64
+		if rows.Scan(&cat, &r) == nil {
+			found[cat] = r
+		}
+	}
+	if r, ok := found[category]; ok && category != "" {
+		return r
+	}
+	if r, ok := found[overallRatingCategory]; ok {
+		return r
+	}
+	return leagueSeedRating(league)
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE LIVE PATH — called from resolveBattle's transaction
+// ════════════════════════════════════════════════════════════════════════════════
+
+// applyBattleRatings rates a settled battle in its category and overall, and
+// re-bands the league of everyone whose overall rating moved. leagues holds
+// each participant's league going in; changes collects the ones that moved.
+func applyBattleRatings(ctx context.Context, tx *sql.Tx, cid int, category string, sides []battleSide, winner int, at time.Time, leagues map[string]string, changes map[string][2]string) error {
+	if category == "" {
+		category = "other"
+	}
+	for _, cat := range []string{category, overallRatingCategory} {
+		ids := make([]string, 0, len(sides))
+		for _, s := range sides {
+			ids = append(ids, s.userID)
+		}
+		prior, err := lockRatings(ctx, tx, cat, ids)
+		if err != nil {
+			return err
+		}
+		after := rateBattle(sides, winner, prior, at)
+		for _, uid := range ratedUsers(after) {
+			st := after[uid]
+			if err := saveRating(ctx, tx, uid, cat, cid, prior[uid], st); err != nil {
+				return err
+			}
+			if cat != overallRatingCategory {
+				continue
+			}
+			before, ok := leagues[uid]
+			if !ok {
+				continue // account deleted mid-battle
+			}
+			next := leagueAfterResult(before, st.glickoRating)
+			if next == before {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE users SET league = $2 WHERE id = CAST($1 AS INT)`, uid, next); err != nil {
+				return fmt.Errorf("moving user %s to %s: %w", uid, next, err)
+			}
+			changes[uid] = [2]string{before, next}
+		}
+	}
+	return nil
+}
+
+// lockRatings reads and locks the ratings of everyone in a battle for one
+// category. Rows are created first for anybody rated for the first time, so
+// every participant has a row to lock — two battles settling at once for the
+// same newcomer then queue up instead of both starting from 1500.
+//
+// Locked in user id order, so two battles that share players always take the
+// locks in the same order and cannot deadlock each other.
+func lockRatings(ctx context.Context, tx *sql.Tx, category string, userIDs []string) (map[string]ratingState, error) {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_ratings (user_id, category)
+		SELECT u, $1 FROM unnest(CAST($2 AS INT[])) AS u
+		ON CONFLICT (user_id, category) DO NOTHING`, category, pq.Array(userIDs)); err != nil {
+		return nil, fmt.Errorf("creating %s ratings: %w", category, err)
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT CAST(user_id AS TEXT), rating, deviation, volatility, battles, updated_at
+		FROM user_ratings
+		WHERE category = $1 AND user_id = ANY(CAST($2 AS INT[]))
+		ORDER BY user_id
+		FOR UPDATE`, category, pq.Array(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("locking %s ratings: %w", category, err)
+	}
+	defer rows.Close()
+
+	out := map[string]ratingState{}
+	for rows.Next() {
+		var uid string
+		var st ratingState
+		if err := rows.Scan(&uid, &st.rating, &st.rd, &st.vol, &st.battles, &st.updatedAt); err != nil {
+			return nil, fmt.Errorf("reading %s ratings: %w", category, err)
+		}
+		out[uid] = st
+	}
+	return out, rows.Err()
+}
+
+// saveRating writes a rating and the history row explaining it.
+func saveRating(ctx context.Context, tx *sql.Tx, userID, category string, cid int, before, after ratingState) error {
+	if before.battles == 0 {
+		before.glickoRating = newGlickoRating()
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE user_ratings
+		   SET rating = $3, deviation = $4, volatility = $5, battles = $6, updated_at = $7
+		 WHERE user_id = CAST($1 AS INT) AND category = $2`,
+		userID, category, after.rating, after.rd, after.vol, after.battles, after.updatedAt); err != nil {
+		return fmt.Errorf("saving %s rating for user %s: %w", category, userID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO rating_history (user_id, category, challenge_id, rating_before, rating_after, deviation, created_at)
+		VALUES (CAST($1 AS INT), $2, $3, $4, $5, $6, $7)`,
+		userID, category, cid, before.rating, after.rating, after.rd, after.updatedAt); err != nil {
+		return fmt.Errorf("recording %s rating history for user %s: %w", category, userID, err)
+	}
+	return nil
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// PROFILES
+// ════════════════════════════════════════════════════════════════════════════════
+
+// SkillRating is one of a user's ratings as the profile shows it.
+type SkillRating struct {
+	Category   string  `json:"category"`
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+	Battles    int     `json:"battles"`
+	UpdatedAt  string  `json:"updatedAt"`
+}
+
+// RatingHistoryEntry is one rating change as the profile shows it.
+type RatingHistoryEntry struct {
+	ChallengeID  string  `json:"challengeId,omitempty"`
+	Category     string  `json:"category"`
+	RatingBefore float64 `json:"ratingBefore"`
+	RatingAfter  float64 `json:"ratingAfter"`
+	Deviation    float64 `json:"deviation"`
+	CreatedAt    string  `json:"createdAt"`
+}
+
+// ratingHistoryLimit caps the history a profile carries. Enough to draw a
+// line; the table keeps everything.
+const ratingHistoryLimit = 50
+
+// GetUserRatings returns every rating a user has, overall first.
+func GetUserRatings(userID string) []SkillRating {
+	if db == nil {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT category, rating, deviation, volatility, battles, updated_at
+		FROM user_ratings
+		WHERE user_id = CAST($1 AS INT) AND battles > 0
+		ORDER BY (category = $2) DESC, battles DESC, category`, userID, overallRatingCategory)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var out []SkillRating
+	for rows.Next() {
+		var s SkillRating
+		var updated time.Time
+		if rows.Scan(&s.Category, &s.Rating, &s.Deviation, &s.Volatility, &s.Battles, &updated) == nil {
+			s.UpdatedAt = updated.UTC().Format(time.RFC3339)
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// GetRatingHistory returns a user's most recent rating changes, newest first.
+func GetRatingHistory(userID string, limit int) []RatingHistoryEntry {
+	if db == nil {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT COALESCE(CAST(challenge_id AS TEXT), ''), category,
+		       rating_before, rating_after, deviation, created_at
+		FROM rating_history
+		WHERE user_id = CAST($1 AS INT)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var out []RatingHistoryEntry
+	for rows.Next() {
+		var e RatingHistoryEntry
+		var created time.Time
+		if rows.Scan(&e.ChallengeID, &e.Category, &e.RatingBefore, &e.RatingAfter, &e.Deviation, &created) == nil {
+			e.CreatedAt = created.UTC().Format(time.RFC3339)
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE REBUILD
+// ════════════════════════════════════════════════════════════════════════════════
+
+// ratingRebuildSummary is what the admin rebuild reports back.
+type ratingRebuildSummary struct {
+	Battles      int     `json:"battles"`      // settled battles replayed
+	Rated        int     `json:"rated"`        // of those, the ones with a winner
+	Users        int     `json:"users"`        // users who ended up with a rating
+	LeagueMoves  int     `json:"leagueMoves"`  // users whose league changed
+	DurationSecs float64 `json:"durationSecs"` // wall time
+}
+
+// replayBattle is one settled battle as the rebuild sees it.
+type replayBattle struct {
+	id         int
+	creatorID  string
+	category   string
+	resolvedAt time.Time
+	sides      []battleSide
+}
+
+type replayHistory struct {
+	userID, category string
+	challengeID      int
+	before, after    ratingState
+}
+
+// rebuildRatings throws away every rating and replays every settled battle
+// from its votes, oldest first.
+//
+// Everything happens in one transaction that starts by locking the rating
+// tables. A battle the resolver is settling at the same moment either
+// committed before the lock (and is replayed here) or waits on the lock and
+// applies itself on top of the rebuilt ratings afterwards — it is never lost
+// and never counted twice.
+//
+// Leagues are re-banded from scratch for everybody the replay rates: they
+// start it in Bronze and move one step per battle, exactly as they would have
+// live. Users with no rated battles are left in whatever league they hold —
+// for most of them that came from the system before this one, and with
+// nothing to replay there is nothing to say it was wrong.
+func rebuildRatings(ctx context.Context) (ratingRebuildSummary, error) {
+	start := time.Now()
+	var sum ratingRebuildSummary
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return sum, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE user_ratings, rating_history IN EXCLUSIVE MODE`); err != nil {
+		return sum, fmt.Errorf("locking rating tables: %w", err)
+	}
+
+	battles, err := loadReplayBattles(ctx, tx)
+	if err != nil {
+		return sum, err
+	}
+	sum.Battles = len(battles)
+
+	// The replay itself: all in memory, through the same functions the live
+	// resolver uses.
+	ratings := map[string]map[string]ratingState{} // category → user → rating
+	leagues := map[string]string{}                 // user → league
+	var history []replayHistory
+	for _, b := range battles {
+		winner, _ := pickBattleWinner(b.sides)
+		if winner < 0 {
+			continue
+		}
+		sum.Rated++
+		for _, cat := range []string{b.category, overallRatingCategory} {
+			if ratings[cat] == nil {
+				ratings[cat] = map[string]ratingState{}
+			}
+			after := rateBattle(b.sides, winner, ratings[cat], b.resolvedAt)
+			for _, uid := range ratedUsers(after) {
+				st := after[uid]
+				before := ratings[cat][uid]
+				if before.battles == 0 {
+					before.glickoRating = newGlickoRating()
+				}
+				history = append(history, replayHistory{uid, cat, b.id, before, st})
+				ratings[cat][uid] = st
+				if cat == overallRatingCategory {
+					cur, ok := leagues[uid]
+					if !ok {
+						cur = leagueLadder[0].name
+					}
+					leagues[uid] = leagueAfterResult(cur, st.glickoRating)
+				}
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rating_history`); err != nil {
+		return sum, fmt.Errorf("clearing rating history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_ratings`); err != nil {
+		return sum, fmt.Errorf("clearing ratings: %w", err)
+	}
+	cats := make([]string, 0, len(ratings))
+	for cat := range ratings {
+		cats = append(cats, cat)
+	}
+	sort.Strings(cats)
+	for _, cat := range cats {
+		for _, uid := range ratedUsers(ratings[cat]) {
+			st := ratings[cat][uid]
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO user_ratings (user_id, category, rating, deviation, volatility, battles, updated_at)
+				VALUES (CAST($1 AS INT), $2, $3, $4, $5, $6, $7)`,
+				uid, cat, st.rating, st.rd, st.vol, st.battles, st.updatedAt); err != nil {
+				return sum, fmt.Errorf("writing %s rating for user %s: %w", cat, uid, err)
+			}
+		}
+	}
+	sum.Users = len(ratings[overallRatingCategory])
+	for _, h := range history {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO rating_history (user_id, category, challenge_id, rating_before, rating_after, deviation, created_at)
+			VALUES (CAST($1 AS INT), $2, $3, $4, $5, $6, $7)`,
+			h.userID, h.category, h.challengeID, h.before.rating, h.after.rating, h.after.rd, h.after.updatedAt); err != nil {
+			return sum, fmt.Errorf("writing rating history: %w", err)
+		}
+	}
+
+	// Leagues: everybody in the replay gets the league it walked them to;
+	// nobody else is touched. Counted as moves only where the stored value
+	// actually changes.
+	for _, uid := range mapKeys(leagues) {
+		league := leagues[uid]
+		res, err := tx.ExecContext(ctx, `
+			UPDATE users SET league = $2
+			WHERE id = CAST($1 AS INT) AND COALESCE(league, '') <> $2`, uid, league)
+		if err != nil {
+			return sum, fmt.Errorf("setting league for user %s: %w", uid, err)
+		}
+		n, _ := res.RowsAffected()
+		sum.LeagueMoves += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return sum, fmt.Errorf("commit: %w", err)
+	}
+	sum.DurationSecs = time.Since(start).Seconds()
+	return sum, nil
+}
+
+// loadReplayBattles reads every settled battle, its sides and its tallies, in
+// the order they were settled. Only votes cast before a battle closed count —
+// the same votes the resolver saw.
+func loadReplayBattles(ctx context.Context, tx *sql.Tx) ([]replayBattle, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.id, CAST(c.creator_id AS TEXT), COALESCE(c.category, 'other'), c.resolved_at
+		FROM challenges c
+		WHERE c.status = 'completed' AND c.resolved_at IS NOT NULL
+		ORDER BY c.resolved_at, c.id`)
+	if err != nil {
+		return nil, fmt.Errorf("reading settled battles: %w", err)
+	}
+	var battles []replayBattle
+	index := map[int]int{}
+	for rows.Next() {
+		var b replayBattle
+		if err := rows.Scan(&b.id, &b.creatorID, &b.category, &b.resolvedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading settled battles: %w", err)
+		}
+		b.sides = []battleSide{{userID: b.creatorID}}
+		index[b.id] = len(battles)
+		battles = append(battles, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading settled battles: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT cr.challenge_id, CAST(cr.id AS TEXT), CAST(cr.responder_id AS TEXT)
+		FROM challenge_responses cr
+		JOIN challenges c ON c.id = cr.challenge_id
+		WHERE c.status = 'completed' AND c.resolved_at IS NOT NULL
+		  AND COALESCE(cr.is_hidden, FALSE) = FALSE
+		ORDER BY cr.challenge_id, cr.created_at, cr.id`)
+	if err != nil {
+		return nil, fmt.Errorf("reading battle responses: %w", err)
+	}
+	sideOf := map[int]map[string]int{}
+	for rows.Next() {
+		var cid int
+		var s battleSide
+		if rows.Scan(&cid, &s.responseID, &s.userID) != nil {
+			continue
+		}
+		i, ok := index[cid]
+		if !ok {
+			continue
+		}
+		if sideOf[cid] == nil {
+			sideOf[cid] = map[string]int{"": 0}
+		}
+		sideOf[cid][s.responseID] = len(battles[i].sides)
+		battles[i].sides = append(battles[i].sides, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading battle responses: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT cv.challenge_id, COALESCE(CAST(cv.response_id AS TEXT), ''), COUNT(*), SUM(cv.weight), MAX(cv.created_at)
+		FROM challenge_votes cv
+		JOIN challenges c ON c.id = cv.challenge_id
+		WHERE c.status = 'completed' AND c.resolved_at IS NOT NULL
+		  AND cv.created_at <= c.resolved_at
+		GROUP BY cv.challenge_id, cv.response_id`)
+	if err != nil {
+		return nil, fmt.Errorf("reading battle votes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, n int
+		var rid string
+		var w float64
+		var last time.Time
+		if rows.Scan(&cid, &rid, &n, &w, &last) != nil {
+			continue
+		}
+		i, ok := index[cid]
+		if !ok {
+			continue
+		}
+		j := 0
+		if rid != "" {
+			if j, ok = sideOf[cid][rid]; !ok {
+				continue // a hidden response
+			}
+		}
+		battles[i].sides[j].votes = n
+		battles[i].sides[j].weight = w
+		battles[i].sides[j].reachedAt = last
+	}
+	return battles, rows.Err()
+}
+
+// ratedUsers is the users in a set of ratings, in a fixed order — so the same
+// ratings are always written in the same order, and history rows that share a
+// timestamp always come back the same way.
+func ratedUsers(m map[string]ratingState) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func mapKeys(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// AdminRebuildRatingsHandler replays every settled battle to rebuild ratings
+// and leagues from scratch.
+// POST /api/v1/admin/ratings/rebuild
+//
+// Deterministic: run it twice and the second run changes nothing. Run it
+// after changing a Glicko constant or the league bands, or to repair ratings
+// after a bug.
+func AdminRebuildRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	sum, err := rebuildRatings(r.Context())
+	if err != nil {
+		log.Printf("ratings rebuild: %v", err)
+		http.Error(w, "rebuild failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("ratings rebuild: replayed %d battles (%d rated), %d users, %d league moves in %.1fs.",
+		sum.Battles, sum.Rated, sum.Users, sum.LeagueMoves, sum.DurationSecs)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(sum)
+}
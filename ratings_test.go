@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Ratings decide leagues and who may battle whom, and an arithmetic slip in
+// Glicko-2 does not crash anything — it just quietly makes everybody's number
+// wrong. So the maths is pinned against the worked example in Glickman's
+// paper, and the rules layered on top are pinned as pure functions.
+
+// ── Glicko-2 itself ─────────────────────────────────────────────────────────
+
+func TestGlicko2Update_MatchesThePaper(t *testing.T) {
+	// "Example of the Glicko-2 system", Glickman: a 1500/200 player beats a
+	// 1400/30, loses to a 1550/100 and loses to a 1700/300.
+	p := glickoRating{rating: 1500, rd: 200, vol: 0.06}
+	got := glicko2Update(p, []glickoGame{
+		{opp: glickoRating{rating: 1400, rd: 30}, score: 1},
+		{opp: glickoRating{rating: 1550, rd: 100}, score: 0},
+		{opp: glickoRating{rating: 1700, rd: 300}, score: 0},
+	})
+	if math.Abs(got.rating-1464.06) > 0.05 {
+		t.Errorf("rating = %.2f, want 1464.06", got.rating)
+	}
+	if math.Abs(got.rd-151.52) > 0.05 {
+		t.Errorf("deviation = %.2f, want 151.52", got.rd)
+	}
+	if math.Abs(got.vol-0.05999) > 0.00001 {
+		t.Errorf("volatility = %.5f, want 0.05999", got.vol)
+	}
+}
+
+func TestGlickoDecay_GrowsButNeverPastANewcomer(t *testing.T) {
+	g := glickoRating{rating: 1600, rd: 60, vol: 0.06}
+	if d := g.decayed(0); d.rd != g.rd {
+		t.Errorf("no time away should change nothing, got rd %.2f", d.rd)
+	}
+	month := g.decayed(30 * 24 * time.Hour)
+	if month.rd <= g.rd {
+		t.Errorf("a month away should widen the deviation, got %.2f", month.rd)
+	}
+	if month.rating != g.rating {
+		t.Error("time away must not move the rating itself")
+	}
+	if forever := g.decayed(100 * 365 * 24 * time.Hour); forever.rd > glickoDefaultRD {
+		t.Errorf("deviation %.2f is wider than a player who has never battled", forever.rd)
+	}
+}
+
+// ── A battle as games ───────────────────────────────────────────────────────
+
+func TestRateBattle_WinnerUpLoserDown(t *testing.T) {
+	now := time.Now()
+	sides := []battleSide{
+		{userID: "1", votes: 3, weight: 3},
+		{responseID: "30", userID: "2", votes: 5, weight: 5},
+	}
+	after := rateBattle(sides, 1, nil, now)
+	if len(after) != 2 {
+		t.Fatalf("got %d rated users, want 2", len(after))
+	}
+	if after["2"].rating <= glickoDefaultRating || after["1"].rating >= glickoDefaultRating {
+		t.Errorf("winner %.1f / loser %.1f — the winner should gain and the loser drop",
+			after["2"].rating, after["1"].rating)
+	}
+	if after["1"].battles != 1 || !after["1"].updatedAt.Equal(now) {
+		t.Errorf("loser state = %+v, want one battle stamped now", after["1"])
+	}
+}
+
+func TestRateBattle_TieBreakWinnerStillBeatsTheRunnerUp(t *testing.T) {
+	// Level on votes, decided on who got there first. The rating has to agree
+	// with the result everybody was told, not score it as a draw.
+	sides := []battleSide{
+		{userID: "1", votes: 4, weight: 4},
+		{responseID: "30", userID: "2", votes: 4, weight: 4},
+	}
+	after := rateBattle(sides, 0, nil, time.Now())
+	if after["1"].rating <= after["2"].rating {
+		t.Errorf("tie-break winner %.1f should finish above runner-up %.1f",
+			after["1"].rating, after["2"].rating)
+	}
+}
+
+func TestRateBattle_NothingToRate(t *testing.T) {
+	self := []battleSide{{userID: "1", votes: 2, weight: 2}, {responseID: "30", userID: "1", votes: 1, weight: 1}}
+	if got := rateBattle(self, 0, nil, time.Now()); got != nil {
+		t.Errorf("a battle against yourself rated %v", got)
+	}
+	if got := rateBattle(self, -1, nil, time.Now()); got != nil {
+		t.Errorf("a battle with no winner rated %v", got)
+	}
+}
+
+func TestRateBattle_Deterministic(t *testing.T) {
+	// The rebuild promises the same votes give the same ratings.
+	now := time.Now()
+	prior := map[string]ratingState{
+		"2": {glickoRating: glickoRating{rating: 1620, rd: 80, vol: 0.06}, battles: 12, updatedAt: now.Add(-72 * time.Hour)},
+	}
+	sides := []battleSide{
+		{userID: "1", votes: 1, weight: 1},
+		{responseID: "30", userID: "2", votes: 6, weight: 6},
+		{responseID: "31", userID: "3", votes: 2, weight: 2},
+	}
+	a := rateBattle(sides, 1, prior, now)
+	b := rateBattle(sides, 1, prior, now)
+	for uid := range a {
+		if a[uid] != b[uid] {
+			t.Errorf("user %s: %+v then %+v", uid, a[uid], b[uid])
+		}
+	}
+}
+
+// ── Leagues ─────────────────────────────────────────────────────────────────
+
+func TestLeagueLadderMatchesLeagueTier(t *testing.T) {
+	// Search and the feed compare leagueTier numbers. If the ladder promotes
+	// people into a league the map has never heard of, they read as unranked
+	// everywhere else.
+	for i, r := range leagueLadder {
+		if leagueTier[r.name] != i+1 {
+			t.Errorf("%s is rung %d here but tier %d in leagueTier", r.name, i+1, leagueTier[r.name])
+		}
+		if i > 0 && r.floor <= leagueLadder[i-1].floor {
+			t.Errorf("%s's floor (%.0f) is not above %s's", r.name, r.floor, leagueLadder[i-1].name)
+		}
+	}
+}
+
+func TestLeagueAfterResult(t *testing.T) {
+	// conservative = rating - 2*rd; rd 50 keeps the arithmetic readable.
+	at := func(conservative float64) glickoRating {
+		return glickoRating{rating: conservative + 100, rd: 50, vol: glickoDefaultVol}
+	}
+	cases := []struct {
+		current string
+		rating  glickoRating
+		want    string
+	}{
+		{"Bronze", newGlickoRating(), "Bronze"},    // a newcomer reads as 800
+		{"Bronze", at(1150), "Silver"},             // exactly on the floor
+		{"", at(1150), "Silver"},                   // no league yet is Bronze
+		{"Bronze", at(2400), "Silver"},             // one rung per battle
+		{"Silver", at(1120), "Silver"},             // under the floor: the cushion holds
+		{"Silver", at(1100), "Silver"},             // exactly floor - cushion: still holds
+		{"Silver", at(1099), "Bronze"},             // past the cushion: down
+		{"Diamond", at(3000), "Diamond"},           // nowhere higher to go
+		{"Bronze", at(-500), "Bronze"},             // nowhere lower to go
+		{"Diamond", newGlickoRating(), "Platinum"}, // seeded Diamond, never battled: one step down
+	}
+	for _, c := range cases {
+		if got := leagueAfterResult(c.current, c.rating); got != c.want {
+			t.Errorf("leagueAfterResult(%q, %.0f±%.0f) = %q, want %q",
+				c.current, c.rating.rating, c.rating.rd, got, c.want)
+		}
+	}
+}
+
+// ── Matchmaking ─────────────────────────────────────────────────────────────
+
+func TestLeagueSeedRating_KeepsTheOldTwoTierRule(t *testing.T) {
+	// Before anybody has a rating, matchmaking falls back to league. The
+	// seeds must reproduce "within two tiers" or the first day after this
+	// ships would suddenly pair Bronze with Diamond.
+	for a := range leagueTier {
+		for b := range leagueTier {
+			tierGap := leagueTier[a] - leagueTier[b]
+			if tierGap < 0 {
+				tierGap = -tierGap
+			}
+			gap := math.Abs(leagueSeedRating(a) - leagueSeedRating(b))
+			if (tierGap <= 2) != (gap <= maxRatingGap) {
+				t.Errorf("%s vs %s: %d tiers apart but %.0f points apart", a, b, tierGap, gap)
+			}
+		}
+	}
+	if leagueSeedRating("") != leagueSeedRating("Bronze") {
+		t.Error("no league should seed as Bronze")
+	}
+}
+
+func TestMatchmakingRating_NoDatabaseFallsBackToLeague(t *testing.T) {
+	orig := db
+	db = nil
+	defer func() { db = orig }()
+	if got := matchmakingRating("1", "Gold", "dance"); got != leagueSeedRating("Gold") {
+		t.Errorf("got %.0f, want the Gold seed", got)
+	}
+}
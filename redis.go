@@ -82,9 +82,10 @@ func GetStoredNotifications(username string) ([]Notification, bool) {
 }
 
 // ClearStoredNotifications removes all pending notifications for a user
-// after they have been delivered via WebSocket.
+// after they have been delivered via WebSocket, and the mark saying one of
+// them is missing from the event log (ws_events.go).
 func ClearStoredNotifications(username string) {
 	key := "notifications:" + username
-	rdb.Del(rctx, key)
+	rdb.Del(rctx, key, wsUnloggedKey(username))
 	log.Printf("Cleared stored notifications for user %s.", username)
 }
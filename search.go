@@ -116,6 +116,20 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Who is actually asking, for private accounts (follow_requests.go).
+	// Not userId: that is a query parameter anyone can set, fine for
+	// personalising results and no good for deciding who may see what.
+	viewerID := optionalAuthUserID(r)
+	var viewerFollowing map[string]bool
+	if viewerID != "" && viewerID == userID {
+		viewerFollowing = followingSet
+	}
+	pv := newPrivacyViewer(viewerID, viewerFollowing)
+	// And what they muted (content_preferences.go). Only content: a muted
+	// creator's account still comes up by name, since muting is soft and
+	// their profile is where it is undone.
+	mutes := loadContentPrefs(viewerID)
+
 	resp := UnifiedSearchResponse{
 		Accounts: []User{},
 		Battles:  []Challenge{},
@@ -125,14 +139,24 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch + rerank ACCOUNTS.
 	if searchType == "all" || searchType == "accounts" || searchType == "users" {
 		resp.Accounts = rankSearchAccounts(query, userID, followingSet, fofSet, viewerLeague)
+		// A private account can be found — that is how people ask to
+		// follow it — but a stranger gets its limited profile.
+		for i, u := range resp.Accounts {
+			if !pv.canSee(u.ID) {
+				resp.Accounts[i] = limitedProfile(u)
+			}
+		}
 	}
 
 	// Fetch + rerank CHALLENGES, then split into battles + shorts. We fetch
 	// once and split, rather than running two queries, since Meilisearch's
 	// lexical rank is the same either way.
 	if searchType == "all" || searchType == "battles" || searchType == "shorts" || searchType == "challenges" {
-		all := rankSearchChallenges(query, userID, profile, followingSet)
+		all := dropMutedChallenges(rankSearchChallenges(query, userID, profile, followingSet), mutes)
 		for _, ch := range all {
+			if !pv.canSee(ch.CreatorID) {
+				continue
+			}
 			if ch.ResponseCount > 0 {
 				if len(resp.Battles) < searchBattleCap {
 					resp.Battles = append(resp.Battles, ch)
@@ -174,9 +198,12 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	// to realtime-trending content (category-filtered when the query
 	// smells like a topic), flagged so the client can label it honestly.
 	if len(resp.Accounts) == 0 && len(resp.Battles) == 0 && len(resp.Shorts) == 0 {
-		if rescued := searchZeroResultRescue(resp.Intent); len(rescued) > 0 {
+		if rescued := dropMutedChallenges(searchZeroResultRescue(resp.Intent), mutes); len(rescued) > 0 {
 			resp.Related = true
 			for _, ch := range rescued {
+				if !pv.canSee(ch.CreatorID) {
+					continue
+				}
 				if ch.ResponseCount > 0 && len(resp.Battles) < searchBattleCap {
 					resp.Battles = append(resp.Battles, ch)
 				} else if len(resp.Shorts) < searchShortCap {
@@ -232,6 +259,9 @@ func searchZeroResultRescue(intent string) []Challenge {
 			continue
 		}
 		ch := *item.Challenge
+		if moderationHides("challenge", ch.ID, ch.CreatorID) {
+			continue
+		}
 		if wantCat != "" && strings.EqualFold(ch.Category, wantCat) {
 			out = append(out, ch)
 		} else if len(backup) < 10 {
@@ -281,6 +311,11 @@ func rankSearchAccounts(query, userID string, following, fof map[string]bool, vi
 		if hit.User.ID == userID {
 			continue
 		}
+		// A suspended account is not findable while the suspension lasts,
+		// nor a deleted one during its grace period.
+		if accountHidden(hit.User.ID) {
+			continue
+		}
 
 		// Lexical: the position-in-shortlist proxy decays exponentially.
 		// Meilisearch's _rankingScore would be ideal but the Go SDK doesn't
@@ -429,6 +464,11 @@ func rankSearchChallenges(query, userID string, profile *UserProfile, following
 
 	for i, hit := range hits {
 		ch := hit.Ch
+		// Taken down by a moderator, or posted by a suspended account.
+		// Meilisearch still has the document; it just never comes back.
+		if moderationHides("challenge", ch.ID, ch.CreatorID) {
+			continue
+		}
 
 		// Lexical (rank-position decay).
 		lex := math.Exp(-float64(i) / 8.0)
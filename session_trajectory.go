@@ -200,7 +200,15 @@ func trajectoryBonus(cohort Cohort, fromKey, candidateKey string) float64 {
 		count = outs[candidateKey]
 	}
 	st.mu.RUnlock()
+	return trajectoryBonusFromCounts(count, total)
+}
 
+// trajectoryBonusFromCounts is the bonus for a candidate bucket seen count
+// times out of total transitions from the user's current bucket.
+func trajectoryBonusFromCounts(count, total int) float64 {
+	if total < trajectoryMinCounts {
+		return 0
+	}
 	// Probability this candidate's bucket is the natural next step.
 	prob := float64(count) / float64(total)
 	// Map prob to a bounded bonus. We center on the uniform expectation
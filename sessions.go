@@ -0,0 +1,573 @@
+package main
+
+// sessions.go — server-tracked sessions: revocation, refresh rotation, and
+// the list of devices a user is signed in on.
+//
+// auth.go's tokens were stateless: a signed JWT was good until it expired, and
+// nothing short of rotating JWT_SECRET for everyone could take one back.
+// Logging out only forgot the token on the phone; a stolen one kept working
+// for the rest of its 7 days.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SHAPE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Sign-in (login, signup) opens a session — a user_sessions row — and hands
+// back two things:
+//
+//	token         the access JWT, as before, now carrying the session id as
+//	              its jti. authed() refuses it once the session is revoked.
+//	refreshToken  "<session id>.<secret>", opaque, stored only as a hash.
+//	              POST /auth/refresh trades it for a new access token AND a
+//	              new refresh token; the old one is spent.
+//
+// Checking a session on every request is a Redis GET (sessionCacheTTL);
+// revoking writes the row and overwrites the cache entry, so every replica
+// refuses the token on its next request rather than after the cache expires.
+// If neither Redis nor the database can answer, the token is let through: a
+// revocation check must not turn a Redis blip into every user being logged
+// out, and with the database down there is little a token could do anyway.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// REUSE DETECTION
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Each session remembers the last sessionUsedRefreshKeep refresh secrets it
+// has spent. A spent secret coming back means two parties hold the same
+// session — the app and whoever copied its storage — and there is no telling
+// which is which, so the whole session is revoked. Both are signed out; the
+// real user signs in again, the copy cannot.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// TOKENS FROM BEFORE SESSIONS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Tokens issued before this release have no jti and no row to revoke. They
+// are honoured until they expire (tokenTTL, so within a week of deploy) with
+// one exception: "log out everywhere" records a not-before time per user in
+// session_epochs, and a session-less token issued before it is refused. A
+// session-less token used on /auth/refresh is upgraded to a real session.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// sessionTTL is how long a session survives without a refresh. Every
+	// refresh pushes it out again, so an app opened once a month stays
+	// signed in and a lost phone drops out on its own.
+	sessionTTL = 60 * 24 * time.Hour
+	// sessionCacheTTL bounds how long a replica trusts a cached "still
+	// valid". Revocation overwrites the entry, so this only matters when a
+	// revoke's Redis write was lost.
+	sessionCacheTTL = 5 * time.Minute
+	// sessionUsedRefreshKeep is how many spent refresh secrets a session
+	// remembers for reuse detection — at the client's ~3-day refresh cadence,
+	// about two months of them.
+	sessionUsedRefreshKeep = 20
+	// sessionPruneAfter is how long a dead session's row is kept, so the
+	// user can still see a revoked device in the list for a while.
+	sessionPruneAfter = 30 * 24 * time.Hour
+)
+
+var (
+	errSessionRevoked = errors.New("session has been signed out")
+	errRefreshInvalid = errors.New("invalid refresh token")
+	errRefreshReused  = errors.New("refresh token already used; session signed out")
+	errSessionUnknown = errors.New("session not found")
+)
+
+// sessionTokens is what sign-in and refresh hand the client.
+type sessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	SessionID    string `json:"sessionId"`
+}
+
+// Session is one entry in GET /sessions.
+type Session struct {
+	ID           string `json:"id"`
+	UserAgent    string `json:"userAgent"`
+	IP           string `json:"ip"`
+	CreatedAt    string `json:"createdAt"`
+	LastActiveAt string `json:"lastActiveAt"`
+	ExpiresAt    string `json:"expiresAt"`
+	Current      bool   `json:"current"`
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashRefreshSecret is what is stored in place of a refresh secret.
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitRefreshToken splits "<session id>.<secret>".
+func splitRefreshToken(tok string) (sessionID, secret string, ok bool) {
+	i := strings.IndexByte(tok, '.')
+	if i <= 0 || i == len(tok)-1 {
+		return "", "", false
+	}
+	return tok[:i], tok[i+1:], true
+}
+
+func sessionCacheKey(sessionID string) string { return "sess:" + sessionID }
+func sessionEpochKey(userID string) string    { return "sess:epoch:" + userID }
+
+// requestUserAgent is the device description kept on a session.
+func requestUserAgent(r *http.Request) string {
+	return truncateText(r.UserAgent(), 200)
+}
+
+// startSession opens a session for a user who has just proven who they are.
+func startSession(r *http.Request, userID, username string) (sessionTokens, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	// Sign first: with JWT_SECRET unset there is no point writing the row.
+	token, err := issueSessionToken(userID, username, sessionID)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO user_sessions (id, user_id, refresh_hash, user_agent, ip, expires_at)
+		VALUES ($1, CAST($2 AS INT), $3, $4, $5, $6)`,
+		sessionID, userID, hashRefreshSecret(secret), requestUserAgent(r), clientIP(r),
+		time.Now().Add(sessionTTL)); err != nil {
+		return sessionTokens{}, err
+	}
+	return sessionTokens{Token: token, RefreshToken: sessionID + "." + secret, SessionID: sessionID}, nil
+}
+
+// rotateRefreshToken spends a refresh token and returns its replacement,
+// with a fresh access token. A spent token revokes the session.
+func rotateRefreshToken(r *http.Request, refreshToken string) (sessionTokens, string, error) {
+	sessionID, secret, ok := splitRefreshToken(refreshToken)
+	if !ok {
+		return sessionTokens{}, "", errRefreshInvalid
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return sessionTokens{}, "", err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var username, current string
+	var used []string
+	var revoked bool
+	var expires time.Time
+	err = tx.QueryRow(`
+		SELECT s.user_id, u.username, s.refresh_hash, s.used_refresh_hashes,
+		       s.revoked_at IS NOT NULL, s.expires_at
+		FROM user_sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 FOR UPDATE OF s`, sessionID).
+		Scan(&userID, &username, &current, pq.Array(&used), &revoked, &expires)
+	if err == sql.ErrNoRows {
+		return sessionTokens{}, "", errRefreshInvalid
+	}
+	if err != nil {
+		return sessionTokens{}, "", err
+	}
+	uid := strconv.Itoa(userID)
+	if revoked || !time.Now().Before(expires) {
+		return sessionTokens{}, uid, errSessionRevoked
+	}
+
+	presented := hashRefreshSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(current)) != 1 {
+		for _, h := range used {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(h)) == 1 {
+				if _, err := tx.Exec(`UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = 'refresh_reuse'
+					WHERE id = $1`, sessionID); err != nil {
+					return sessionTokens{}, uid, err
+				}
+				if err := tx.Commit(); err != nil {
+					return sessionTokens{}, uid, err
+				}
+				cacheSessionState(sessionID, false)
+				return sessionTokens{}, uid, errRefreshReused
+			}
+		}
+		return sessionTokens{}, uid, errRefreshInvalid
+	}
+
+	next, err := randomHex(32)
+	if err != nil {
+		return sessionTokens{}, uid, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE user_sessions
+		SET refresh_hash = $2,
+		    used_refresh_hashes = (array_prepend($3::text, used_refresh_hashes))[1:$4],
+		    last_active_at = NOW(), expires_at = $5, ip = $6, user_agent = $7
+		WHERE id = $1`,
+		sessionID, hashRefreshSecret(next), current, sessionUsedRefreshKeep,
+		time.Now().Add(sessionTTL), clientIP(r), requestUserAgent(r)); err != nil {
+		return sessionTokens{}, uid, err
+	}
+	if err := tx.Commit(); err != nil {
+		return sessionTokens{}, uid, err
+	}
+	token, err := issueSessionToken(uid, username, sessionID)
+	if err != nil {
+		return sessionTokens{}, uid, err
+	}
+	return sessionTokens{Token: token, RefreshToken: sessionID + "." + next, SessionID: sessionID}, uid, nil
+}
+
+// cacheSessionState records a session's validity in Redis. Revoked entries
+// outlive any access token the session could have issued.
+func cacheSessionState(sessionID string, valid bool) {
+	if rdb == nil {
+		return
+	}
+	v, ttl := "1", sessionCacheTTL
+	if !valid {
+		v, ttl = "0", tokenTTL
+	}
+	if err := rdb.Set(rctx, sessionCacheKey(sessionID), v, ttl).Err(); err != nil {
+		log.Printf("sessions: caching %s: %v", sessionID, err)
+	}
+}
+
+// verifySession reports whether a verified token's session still stands.
+func verifySession(claims *authClaims) error {
+	if claims.ID == "" {
+		return verifySessionlessToken(claims)
+	}
+	if rdb != nil {
+		if v, err := rdb.Get(rctx, sessionCacheKey(claims.ID)).Result(); err == nil {
+			if v == "1" {
+				return nil
+			}
+			return errSessionRevoked
+		}
+	}
+	if db == nil {
+		return nil
+	}
+	var userID int
+	var valid bool
+	err := db.QueryRow(`SELECT user_id, revoked_at IS NULL AND expires_at > NOW()
+		FROM user_sessions WHERE id = $1`, claims.ID).Scan(&userID, &valid)
+	if err == sql.ErrNoRows {
+		valid = false
+	} else if err != nil {
+		log.Printf("sessions: checking %s (letting it through): %v", claims.ID, err)
+		return nil
+	} else if strconv.Itoa(userID) != claims.Subject {
+		valid = false
+	}
+	cacheSessionState(claims.ID, valid)
+	if !valid {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// verifySessionlessToken applies the user's "log out everywhere" time to a
+// token from before sessions.
+func verifySessionlessToken(claims *authClaims) error {
+	if claims.IssuedAt == nil {
+		return nil
+	}
+	var notBefore int64
+	cached := false
+	if rdb != nil {
+		if v, err := rdb.Get(rctx, sessionEpochKey(claims.Subject)).Int64(); err == nil {
+			notBefore, cached = v, true
+		}
+	}
+	if !cached {
+		if db == nil {
+			return nil
+		}
+		var t time.Time
+		err := db.QueryRow(`SELECT not_before FROM session_epochs WHERE user_id = CAST($1 AS INT)`,
+			claims.Subject).Scan(&t)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("sessions: checking epoch for %s (letting it through): %v", claims.Subject, err)
+			return nil
+		}
+		if err == nil {
+			notBefore = t.Unix()
+		}
+		if rdb != nil {
+			rdb.Set(rctx, sessionEpochKey(claims.Subject), notBefore, sessionCacheTTL)
+		}
+	}
+	if notBefore > 0 && claims.IssuedAt.Unix() < notBefore {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// revokeSession signs one of a user's sessions out.
+func revokeSession(userID, sessionID, reason string) error {
+	res, err := db.Exec(`UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = $3
+		WHERE id = $1 AND user_id = CAST($2 AS INT) AND revoked_at IS NULL`, sessionID, userID, reason)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSessionUnknown
+	}
+	cacheSessionState(sessionID, false)
+	return nil
+}
+
+// revokeAllSessions signs a user out everywhere except keepSessionID (which
+// may be empty), including tokens from before sessions. Returns how many
+// sessions it ended.
+func revokeAllSessions(userID, keepSessionID, reason string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = $3
+		WHERE user_id = CAST($1 AS INT) AND id <> $2 AND revoked_at IS NULL
+		RETURNING id`, userID, keepSessionID, reason)
+	if err != nil {
+		return 0, err
+	}
+	var ended []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ended = append(ended, id)
+		}
+	}
+	rows.Close()
+	var notBefore time.Time
+	if err := tx.QueryRow(`
+		INSERT INTO session_epochs (user_id, not_before) VALUES (CAST($1 AS INT), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET not_before = EXCLUDED.not_before
+		RETURNING not_before`, userID).Scan(&notBefore); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, id := range ended {
+		cacheSessionState(id, false)
+	}
+	if rdb != nil {
+		rdb.Set(rctx, sessionEpochKey(userID), notBefore.Unix(), sessionCacheTTL)
+	}
+	return len(ended), nil
+}
+
+// listSessions returns a user's live sessions, most recently used first.
+func listSessions(userID, currentID string) ([]Session, error) {
+	rows, err := db.Query(`
+		SELECT id, user_agent, ip, created_at, last_active_at, expires_at
+		FROM user_sessions
+		WHERE user_id = CAST($1 AS INT) AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_active_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Session{}
+	for rows.Next() {
+		var s Session
+		var created, active, expires time.Time
+		if rows.Scan(&s.ID, &s.UserAgent, &s.IP, &created, &active, &expires) != nil {
+			continue
+		}
+		s.CreatedAt = created.UTC().Format(time.RFC3339)
+		s.LastActiveAt = active.UTC().Format(time.RFC3339)
+		s.ExpiresAt = expires.UTC().Format(time.RFC3339)
+		s.Current = s.ID == currentID
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// startSessionPruner deletes sessions dead for longer than sessionPruneAfter.
+func startSessionPruner() {
+	go func() {
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for range t.C {
+			if db == nil {
+				continue
+			}
+			cutoff := time.Now().Add(-sessionPruneAfter)
+			res, err := db.Exec(`DELETE FROM user_sessions WHERE expires_at < $1 OR revoked_at < $1`, cutoff)
+			if err != nil {
+				log.Printf("sessions: pruning: %v", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				log.Printf("sessions: pruned %d dead sessions", n)
+			}
+		}
+	}()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HANDLERS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// RefreshTokenHandler — POST /api/v1/auth/refresh body:{ refreshToken }
+// Spends the refresh token and returns { token, refreshToken, sessionId }.
+// Not behind authed(): the access token may already have expired, and the
+// refresh token is the credential.
+//
+// App builds from before sessions send no body and authenticate with their
+// bearer token instead. That works once, for a token from before sessions:
+// it buys a session of its own, refresh token and all. A token that already
+// belongs to a session gets 401 without its refresh token — otherwise a
+// stolen access token could renew itself for ever, and rotation and reuse
+// detection would never see it.
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "refresh temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&p)
+	if p.RefreshToken == "" {
+		authed(legacyRefreshHandler)(w, r)
+		return
+	}
+
+	tokens, userID, err := rotateRefreshToken(r, p.RefreshToken)
+	switch {
+	case errors.Is(err, errRefreshReused):
+		log.Printf("sessions: refresh token reuse for user %s; session revoked", userID)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, errRefreshInvalid), errors.Is(err, errSessionRevoked):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Printf("sessions: refresh failed: %v", err)
+		http.Error(w, "refresh temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	if rs, suspended := moderationRestriction(userID, restrictSuspended); suspended {
+		writeRestricted(w, rs)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// legacyRefreshHandler is RefreshTokenHandler for a caller with only a
+// bearer token, which must be one from before sessions.
+func legacyRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	userID, username := authUserID(r), authUsername(r)
+	if userID == "" || username == "" || authSessionID(r) != "" {
+		http.Error(w, "refreshToken required", http.StatusUnauthorized)
+		return
+	}
+	tokens, err := startSession(r, userID, username)
+	if err != nil {
+		log.Printf("sessions: upgrading a session-less token for %s: %v", userID, err)
+		http.Error(w, "refresh temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// LogoutHandler — POST /api/v1/auth/logout
+// Ends the caller's current session.
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := authSessionID(r)
+	if sessionID == "" || db == nil {
+		// A token from before sessions has nothing to end; the app forgets it.
+		writeJSON(w, http.StatusOK, map[string]bool{"revoked": false})
+		return
+	}
+	if err := revokeSession(authUserID(r), sessionID, "logout"); err != nil && !errors.Is(err, errSessionUnknown) {
+		http.Error(w, "logout failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"revoked": true})
+}
+
+// ListSessionsHandler — GET /api/v1/sessions
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	sessions, err := listSessions(authUserID(r), authSessionID(r))
+	if err != nil {
+		http.Error(w, "lookup failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+// RevokeSessionHandler — DELETE /api/v1/sessions/{id}
+// Signs one of the caller's devices out. Ending the current session is
+// allowed; it is the same as logging out.
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	err := revokeSession(authUserID(r), mux.Vars(r)["id"], "revoked")
+	if errors.Is(err, errSessionUnknown) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "revoke failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutEverywhereHandler — POST /api/v1/sessions/logout-all?keepCurrent=true
+// Signs the caller out on every device; with keepCurrent, on every other one.
+func LogoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	keep := ""
+	if r.URL.Query().Get("keepCurrent") == "true" {
+		keep = authSessionID(r)
+	}
+	n, err := revokeAllSessions(authUserID(r), keep, "logout_all")
+	if err != nil {
+		log.Printf("sessions: logout-all for %s: %v", authUserID(r), err)
+		http.Error(w, "logout failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"revoked": n})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func authedStatus(t *testing.T, tok string) int {
+	t.Helper()
+	h := authed(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	req := httptest.NewRequest("GET", "/x", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w.Code
+}
+
+// A revoked session's token stops working on every replica at once: the
+// revoke overwrites the shared cache entry.
+func TestAuthedRejectsRevokedSession(t *testing.T) {
+	tok, err := issueSessionToken("u_sess", "sess", "sess-live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheSessionState("sess-live", true)
+	if got := authedStatus(t, tok); got != http.StatusOK {
+		t.Fatalf("live session: %d", got)
+	}
+	cacheSessionState("sess-live", false)
+	if got := authedStatus(t, tok); got != http.StatusUnauthorized {
+		t.Errorf("revoked session: %d, want 401", got)
+	}
+}
+
+// Log out everywhere also ends tokens from before sessions, by issue time.
+func TestSessionlessTokenHonoursEpoch(t *testing.T) {
+	tok, _ := issueToken("u_epoch", "epoch")
+	if got := authedStatus(t, tok); got != http.StatusOK {
+		t.Fatalf("before logout-all: %d", got)
+	}
+	rdb.Set(rctx, sessionEpochKey("u_epoch"), time.Now().Add(time.Minute).Unix(), time.Minute)
+	t.Cleanup(func() { rdb.Del(rctx, sessionEpochKey("u_epoch")) })
+	if got := authedStatus(t, tok); got != http.StatusUnauthorized {
+		t.Errorf("after logout-all: %d, want 401", got)
+	}
+}
+
+func refreshRequest() *http.Request {
+	return httptest.NewRequest("POST", "/api/v1/auth/refresh", strings.NewReader(""))
+}
+
+// A live refresh token is spent and replaced; the old secret joins the
+// spent list.
+func TestRotateRefreshToken(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_sessions s JOIN users u")).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "refresh_hash", "used", "revoked", "expires_at"}).
+			AddRow(7, "alice", hashRefreshSecret("old"), pq.StringArray{}, false, time.Now().Add(time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_sessions")).
+		WithArgs("s1", sqlmock.AnyArg(), hashRefreshSecret("old"), sessionUsedRefreshKeep,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	tokens, userID, err := rotateRefreshToken(refreshRequest(), "s1.old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "7" || tokens.SessionID != "s1" || !strings.HasPrefix(tokens.RefreshToken, "s1.") || tokens.RefreshToken == "s1.old" {
+		t.Errorf("tokens = %+v (user %s)", tokens, userID)
+	}
+	claims, err := parseToken(tokens.Token)
+	if err != nil || claims.ID != "s1" || claims.Subject != "7" {
+		t.Errorf("access token claims = %+v, %v", claims, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A spent refresh token coming back signs the whole session out.
+func TestRotateRefreshTokenReuseRevokes(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_sessions s JOIN users u")).WithArgs("s2").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "refresh_hash", "used", "revoked", "expires_at"}).
+			AddRow(7, "alice", hashRefreshSecret("newest"),
+				pq.StringArray{hashRefreshSecret("older"), hashRefreshSecret("stolen")}, false, time.Now().Add(time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("revoked_reason = 'refresh_reuse'")).WithArgs("s2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, _, err := rotateRefreshToken(refreshRequest(), "s2.stolen"); err != errRefreshReused {
+		t.Fatalf("err = %v, want errRefreshReused", err)
+	}
+	tok, _ := issueSessionToken("7", "alice", "s2")
+	if got := authedStatus(t, tok); got != http.StatusUnauthorized {
+		t.Errorf("session still usable after reuse: %d", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitRefreshToken(t *testing.T) {
+	if id, secret, ok := splitRefreshToken("abc.def"); !ok || id != "abc" || secret != "def" {
+		t.Errorf("split = %q %q %v", id, secret, ok)
+	}
+	for _, bad := range []string{"", "abc", ".def", "abc."} {
+		if _, _, ok := splitRefreshToken(bad); ok {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+// Only a token from before sessions may refresh without a refresh token;
+// a session's access token can't renew itself.
+func TestBearerOnlyRefreshRefusesSessionTokens(t *testing.T) {
+	_, cleanup := withMockDB(t)
+	defer cleanup()
+
+	tok, err := issueSessionToken("u_bearer", "bearer", "sess-bearer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheSessionState("sess-bearer", true)
+	req := refreshRequest()
+	req.Header.Set("Authorization", "Bearer "+tok)
+	w := httptest.NewRecorder()
+	RefreshTokenHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("session token without refresh token: %d, want 401", w.Code)
+	}
+}
@@ -20,6 +20,7 @@ import (
 // KEY SHAPES:
 //   blocked_creators:{user}  SET   creatorId values        TTL: none (persistent until unblocked)
 //   unfollowed:{user}        ZSET  creatorId → unixTs      TTL: 7d auto-prune at lookup time
+//                                  (also "show less from this creator" taps)
 //   recent_bounces:{user}    ZSET  contentId → unixTs      TTL: 24h per entry (sliding)
 //   recent_searches:{user}   LIST  normalized query        capped at 10, 24h key TTL
 //   last_session_end:{user}  STRING unixTs                  TTL: 30d
@@ -81,6 +82,25 @@ func MarkUnfollowed(userID, creatorID string) {
 	}
 }
 
+// MarkShowLessCreator is the "show less from this creator" tap on a feed
+// explanation (feed_explain.go). It shares the unfollow ZSET rather than
+// getting a key of its own: what the user asked for — fewer of this
+// creator's videos for a while, without unfollowing — is exactly the soft,
+// decaying penalty an unfollow already earns, and negativeCreatorPenalty
+// reads it with no further wiring. Counted under its own metric label so
+// the two taps can still be told apart.
+func MarkShowLessCreator(userID, creatorID string) {
+	if userID == "" || creatorID == "" {
+		return
+	}
+	now := float64(time.Now().Unix())
+	_ = rdb.ZAdd(rctx, "unfollowed:"+userID, redis.Z{Score: now, Member: creatorID}).Err()
+	_ = rdb.Expire(rctx, "unfollowed:"+userID, unfollowPenaltyWindow+24*time.Hour).Err()
+	if metricSignalCapture != nil {
+		metricSignalCapture.WithLabelValues("show_less_creator").Inc()
+	}
+}
+
 // MarkBounce records a <1s dismissal of a specific piece of content — a much
 // stronger "no" than a normal skip. Keyed by (user, content).
 func MarkBounce(userID, contentID string) {
@@ -1,6 +1,7 @@
 package main
 
-// signup.go — registration, token refresh, and onboarding interests.
+// signup.go — registration and onboarding interests. (Token refresh, which
+// started here, moved to sessions.go.)
 //
 // Until this file the backend had no way to CREATE an account (login
 // worked only for seeded users — a launch blocker), tokens hard-expired
@@ -9,15 +10,17 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 var usernameRe = regexp.MustCompile(`^[a-z0-9_.]{3,20}$`)
 
-// SignupHandler — POST /signup {username, password}.
+// SignupHandler — POST /signup {username, password, fullName?, email?}.
 // Mirrors LoginHandler's response shape ({user, token, allUsers:[]})
 // so the client's post-auth path is identical for both flows.
 func SignupHandler(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +32,7 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		Password string `json:"password"`
 		FullName string `json:"fullName"`
+		Email    string `json:"email"` // optional; see account_recovery.go
 	}
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -47,8 +51,13 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "username must be 3-20 chars: a-z, 0-9, _ or .", http.StatusBadRequest)
 		return
 	}
-	if len(creds.Password) < 6 {
-		http.Error(w, "password must be at least 6 characters", http.StatusBadRequest)
+	if len(creds.Password) < minPasswordLen {
+		http.Error(w, fmt.Sprintf("password must be at least %d characters", minPasswordLen), http.StatusBadRequest)
+		return
+	}
+	email, hasEmail := normalizeEmail(creds.Email)
+	if creds.Email != "" && !hasEmail {
+		http.Error(w, errEmailInvalid.Error(), http.StatusBadRequest)
 		return
 	}
 	if UserExists(creds.Username) {
@@ -80,7 +89,7 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := issueToken(id, creds.Username)
+	session, err := startSession(r, id, creds.Username)
 	if err != nil {
 		log.Printf("signup token issuance failed for %s: %v", creds.Username, err)
 		http.Error(w, "Signup succeeded but login is temporarily unavailable", http.StatusInternalServerError)
@@ -89,14 +98,24 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, _ := GetUserByUsername(creds.Username)
 	go IndexUser(user) // searchable immediately
+	if hasEmail {
+		go func(uid string) {
+			n, _ := strconv.Atoi(uid)
+			if _, err := setUserEmail(n, email); err != nil {
+				log.Printf("signup: recording email for %s: %v", uid, err)
+			}
+		}(id)
+	}
 
 	log.Printf("New user signed up: %s (id=%s)", creds.Username, id)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"user":     user,
-		"token":    token,
-		"allUsers": []User{},
+		"user":         user,
+		"token":        session.Token,
+		"refreshToken": session.RefreshToken,
+		"sessionId":    session.SessionID,
+		"allUsers":     []User{},
 	})
 }
 
@@ -113,25 +132,6 @@ func UsernameAvailableHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// RefreshTokenHandler — POST /api/v1/auth/refresh (authed).
-// Mints a fresh 7-day token for the already-verified caller so an
-// active user never hits the hard expiry (the client refreshes when
-// its stored token is older than ~3 days).
-func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
-	userID, username := authUserID(r), authUsername(r)
-	if userID == "" || username == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
-	token, err := issueToken(userID, username)
-	if err != nil {
-		http.Error(w, "refresh temporarily unavailable", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"token": token})
-}
-
 // SeedInterestsHandler — POST /api/v1/profile/interests
 // Body: {"categories": ["comedy", "dance", ...]}
 //
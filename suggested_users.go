@@ -36,6 +36,7 @@ import (
 //   - never suggest the user themselves
 //   - never suggest someone they already follow
 //   - never suggest someone they've blocked or who's blocked them
+//   - never suggest a private account (follow_requests.go)
 //   - require the candidate to have at least 1 challenge in the last 30 days
 //     so we don't surface dormant accounts
 // ─────────────────────────────────────────────────────────────────────────────
@@ -76,13 +77,19 @@ func BuildSuggestedAccountsCard(userID string, page int) *SuggestedAccountsCard
 
 	// Step 1: Build the exclusion set — self + already-followed + blocked.
 	excluded := buildSuggestedExclusions(userID)
+	// Nor anyone they muted, and no category they muted is a reason to
+	// suggest somebody. See content_preferences.go.
+	mutes := loadContentPrefs(userID)
+	for id := range mutes.creators {
+		excluded[id] = true
+	}
 
 	// Step 2: Pull the candidate pool. We're not yet scoring — just
 	// gathering a generous superset that includes likely-good rows from
 	// each retrieval lane (FoF, category, popular). This minimizes round
 	// trips: one query per lane.
 	fof := pullFoFCandidates(userID, excluded, candidatePoolSize/2)
-	cat := pullCategoryCandidates(userID, excluded, candidatePoolSize/2)
+	cat := pullCategoryCandidates(userID, excluded, mutes, candidatePoolSize/2)
 	pop := pullPopularCandidates(userID, excluded, candidatePoolSize/2)
 
 	// Step 3: Merge into a deduped map keyed by user ID. Each lane left a
@@ -126,6 +133,15 @@ func BuildSuggestedAccountsCard(userID string, page int) *SuggestedAccountsCard
 		a.recentActivity = a.recentActivity || c.RecentActivity
 	}
 
+	// Suspended and deleted accounts are never suggested, and neither are
+	// private ones: everyone here is someone the user doesn't follow, and
+	// the lanes rank on content a private account keeps to its followers.
+	for id := range pool {
+		if accountHidden(id) || accountPrivate(id) {
+			delete(pool, id)
+		}
+	}
+
 	if len(pool) == 0 {
 		return nil
 	}
@@ -359,7 +375,7 @@ func pullFoFCandidates(userID string, excluded map[string]bool, limit int) []can
 // pullCategoryCandidates returns users whose recent challenges fall into the
 // requesting user's top-affinity categories. CategoryFit is the user's
 // affinity weight for that category, so higher-affinity matches score more.
-func pullCategoryCandidates(userID string, excluded map[string]bool, limit int) []candidateRow {
+func pullCategoryCandidates(userID string, excluded map[string]bool, mutes *contentPrefs, limit int) []candidateRow {
 	profile, err := getOrComputeProfile(userID)
 	if err != nil || profile == nil || len(profile.CategoryAffinity) == 0 {
 		return nil
@@ -375,6 +391,9 @@ func pullCategoryCandidates(userID string, excluded map[string]bool, limit int)
 			continue // skip neutral/DISLIKED categories — mined negatives (down to
 			// -0.5) must never be picked as a "preferred" category for suggestions.
 		}
+		if mutes.mutesCategory(k) {
+			continue
+		}
 		weights = append(weights, cw{cat: k, w: v})
 	}
 	sort.Slice(weights, func(i, j int) bool { return weights[i].w > weights[j].w })
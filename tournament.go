@@ -0,0 +1,1086 @@
+package main
+
+// tournament.go — single-elimination brackets.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SHAPE OF IT
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	open      a creator opens a tournament on a subject with a bracket size
+//	          (4–32) and an entry window. People join with one entry video.
+//	draw      when the window closes (or the bracket fills) the entrants are
+//	          seeded by skill rating — strongest first — and placed so the top
+//	          two seeds can only meet in the final. Empty places are byes,
+//	          which go to the top seeds.
+//	rounds    every matchup is played as an ordinary battle: a challenges row
+//	          with the two entry videos as its sides. The battle resolver
+//	          closes it at the end of its 24 hours (or early, on a decisive
+//	          vote) exactly as it closes any other battle.
+//	advance   once every battle in a round is settled, the winners meet in the
+//	          next round. The last one standing wins the tournament.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY A MATCHUP IS A BATTLE
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Everything a head-to-head vote needs already exists for battles: the voting
+// endpoint, vote screening and weighting, the clock, the early close, records,
+// ratings, the feed's ranking and the client's battle screen. A matchup that
+// is a battle gets all of it for free and can never drift from it. This file
+// only decides who plays whom, and when.
+//
+// A tournament needs a winner from every matchup, and a battle can end with
+// none (nobody voted, or a dead heat). Then the higher seed goes through —
+// the draw already said they were the favourite.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// tournamentDefaultSize is the bracket size when the creator does not
+	// pick one.
+	tournamentDefaultSize = 8
+	// The entry window, in hours: default, shortest and longest allowed.
+	tournamentDefaultEntryHours = 24
+	tournamentMinEntryHours     = 1
+	tournamentMaxEntryHours     = 72
+	// tournamentSchedulerInterval is how often the scheduler looks for
+	// brackets to draw and rounds to advance.
+	tournamentSchedulerInterval = time.Minute
+	// tournamentSourceWeight is the feed lane's share of the candidate budget.
+	// Small: live matchups are a handful of videos at any one time, and the
+	// lane's job is to make sure they are seen while their vote is open.
+	tournamentSourceWeight = 0.05
+)
+
+// tournamentSizes are the bracket sizes a creator may choose.
+var tournamentSizes = map[int]bool{4: true, 8: true, 16: true, 32: true}
+
+var (
+	errTournamentNotFound = errors.New("tournament not found")
+	errTournamentClosed   = errors.New("entries for this tournament are closed")
+	errTournamentFull     = errors.New("this tournament is full")
+	errAlreadyEntered     = errors.New("you've already entered this tournament")
+)
+
+// ════════════════════════════════════════════════════════════════════════════════
+// WIRE TYPES
+// ════════════════════════════════════════════════════════════════════════════════
+
+// Tournament is one tournament's header.
+type Tournament struct {
+	ID              string `json:"id"`
+	CreatorID       string `json:"creatorId"`
+	CreatorUsername string `json:"creatorUsername"`
+	Prefix          string `json:"prefix"`
+	Subject         string `json:"subject"`
+	Category        string `json:"category"`
+	MaxEntrants     int    `json:"maxEntrants"`
+	EntrantCount    int    `json:"entrantCount"`
+	Status          string `json:"status"` // "open", "running", "completed", "cancelled"
+	Round           int    `json:"round"`  // round being played; 0 before the draw
+	Rounds          int    `json:"rounds"` // rounds in the full bracket
+	EntryDeadline   string `json:"entryDeadline"`
+	WinnerID        string `json:"winnerId,omitempty"`
+	WinnerUsername  string `json:"winnerUsername,omitempty"`
+	CreatedAt       string `json:"createdAt"`
+}
+
+// TournamentEntrant is one person in a tournament.
+type TournamentEntrant struct {
+	UserID       string `json:"userId"`
+	Username     string `json:"username"`
+	League       string `json:"league"`
+	VideoURL     string `json:"videoUrl"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	Seed         int    `json:"seed,omitempty"`
+	// EliminatedRound is the round they went out in; 0 while still in.
+	EliminatedRound int `json:"eliminatedRound,omitempty"`
+}
+
+// TournamentMatch is one matchup in the bracket.
+type TournamentMatch struct {
+	ID          string `json:"id"`
+	Round       int    `json:"round"`
+	Slot        int    `json:"slot"`
+	EntrantA    string `json:"entrantA,omitempty"` // user id
+	EntrantB    string `json:"entrantB,omitempty"` // user id; empty for a bye
+	ChallengeID string `json:"challengeId,omitempty"`
+	WinnerID    string `json:"winnerId,omitempty"`
+	Status      string `json:"status"` // "live", "done"
+	EndsAt      string `json:"endsAt,omitempty"`
+}
+
+// TournamentBracket is everything the bracket screen draws.
+type TournamentBracket struct {
+	Tournament
+	Entrants []TournamentEntrant `json:"entrants"`
+	// Matches grouped by round, round 1 first.
+	Matches [][]TournamentMatch `json:"matches"`
+}
+
+// CreateTournamentPayload is the body of POST /tournaments.
+type CreateTournamentPayload struct {
+	Prefix     string `json:"prefix"`
+	Subject    string `json:"subject"`
+	Category   string `json:"category"`
+	Size       int    `json:"size"`
+	EntryHours int    `json:"entryHours"`
+}
+
+// JoinTournamentPayload is the body of POST /tournaments/{id}/join.
+type JoinTournamentPayload struct {
+	VideoURL     string `json:"videoUrl"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	// Checked against the same bounds as a challenge response.
+	DurationMs int `json:"durationMs"`
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE BRACKET — pure
+// ════════════════════════════════════════════════════════════════════════════════
+
+// bracketSize is the smallest power of two that holds n entrants.
+func bracketSize(n int) int {
+	size := 2
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+// bracketRounds is how many rounds a bracket of this size plays.
+func bracketRounds(size int) int {
+	rounds := 0
+	for s := 1; s < size; s *= 2 {
+		rounds++
+	}
+	return rounds
+}
+
+// bracketSeedOrder lists seeds in first-round slot order for a bracket of
+// size entries (a power of two), so that adjacent pairs are the first-round
+// matchups and seed 1 and seed 2 are in opposite halves: 1v8, 4v5, 2v7, 3v6.
+//
+// Built by doubling: every seed s in a bracket of n gets the opponent n+1-s
+// when the bracket doubles. That keeps the sum of each pair constant, which is
+// exactly "best plays worst".
+func bracketSeedOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		m := len(order)*2 + 1
+		next := make([]int, 0, len(order)*2)
+		for _, s := range order {
+			next = append(next, s, m-s)
+		}
+		order = next
+	}
+	return order
+}
+
+// firstRoundPairs is the first round for n entrants: pairs of seeds, slot by
+// slot, with 0 standing in for a bye. Byes fall to the top seeds, because the
+// seeds past n are the lowest ones and they are who the top seeds would have
+// met.
+func firstRoundPairs(n int) [][2]int {
+	order := bracketSeedOrder(bracketSize(n))
+	pairs := make([][2]int, 0, len(order)/2)
+	for i := 0; i < len(order); i += 2 {
+		a, b := order[i], order[i+1]
+		if a > n {
+			a = 0
+		}
+		if b > n {
+			b = 0
+		}
+		if a == 0 { // keep the real entrant on side A
+			a, b = b, a
+		}
+		pairs = append(pairs, [2]int{a, b})
+	}
+	return pairs
+}
+
+// seedCandidate is an entrant as the draw sees them.
+type seedCandidate struct {
+	userID   string
+	rating   float64
+	joinedAt time.Time
+}
+
+// seedEntrants orders entrants for the draw: highest rating first, and on
+// equal ratings whoever joined first. Returns user ids; index+1 is the seed.
+func seedEntrants(cands []seedCandidate) []string {
+	sorted := append([]seedCandidate(nil), cands...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].rating != sorted[j].rating {
+			return sorted[i].rating > sorted[j].rating
+		}
+		if !sorted[i].joinedAt.Equal(sorted[j].joinedAt) {
+			return sorted[i].joinedAt.Before(sorted[j].joinedAt)
+		}
+		return lessNumericID(sorted[i].userID, sorted[j].userID)
+	})
+	out := make([]string, len(sorted))
+	for i, c := range sorted {
+		out[i] = c.userID
+	}
+	return out
+}
+
+// matchWinner decides a settled matchup. The battle's winner if it had one
+// and it is one of the two; otherwise the better seed. An empty side (a bye,
+// or an account deleted mid-tournament) always loses to a real one.
+func matchWinner(a, b, battleWinner string, seeds map[string]int) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	case battleWinner == a || battleWinner == b:
+		return battleWinner
+	}
+	sa, sb := seeds[a], seeds[b]
+	if sa == 0 {
+		return b
+	}
+	if sb == 0 || sa <= sb {
+		return a
+	}
+	return b
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// THE SCHEDULER
+// ════════════════════════════════════════════════════════════════════════════════
+
+// startTournamentScheduler draws brackets and advances rounds forever.
+//
+// Safe on several instances at once: each tournament is handled in a
+// transaction that locks its row and re-checks its state, so the second
+// instance to reach one finds nothing left to do.
+func startTournamentScheduler() {
+	go func() {
+		t := time.NewTicker(tournamentSchedulerInterval)
+		defer t.Stop()
+		for range t.C {
+			if err := runTournamentScheduler(context.Background()); err != nil {
+				log.Printf("tournament scheduler: %v", err)
+			}
+		}
+	}()
+}
+
+// runTournamentScheduler does one pass: draw every bracket whose entries have
+// closed, then advance every running tournament whose round is settled.
+func runTournamentScheduler(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	due, err := queryIDs(ctx, `
+		SELECT t.id FROM tournaments t
+		WHERE t.status = 'open'
+		  AND (t.entry_deadline <= NOW()
+		       OR (SELECT COUNT(*) FROM tournament_entrants e WHERE e.tournament_id = t.id) >= t.max_entrants)
+		ORDER BY t.entry_deadline
+		LIMIT 100`)
+	if err != nil {
+		return fmt.Errorf("finding brackets to draw: %w", err)
+	}
+	for _, id := range due {
+		notes, err := drawTournament(ctx, id)
+		if err != nil {
+			log.Printf("tournament scheduler: drawing %d: %v", id, err)
+			continue
+		}
+		go sendTournamentNotes(notes)
+	}
+
+	running, err := queryIDs(ctx, `
+		SELECT t.id FROM tournaments t
+		WHERE t.status = 'running'
+		  AND NOT EXISTS (
+			SELECT 1 FROM tournament_matches m
+			LEFT JOIN challenges c ON c.id = m.challenge_id
+			WHERE m.tournament_id = t.id AND m.round = t.round
+			  AND m.status = 'live' AND c.id IS NOT NULL AND c.resolved_at IS NULL)
+		LIMIT 100`)
+	if err != nil {
+		return fmt.Errorf("finding rounds to advance: %w", err)
+	}
+	for _, id := range running {
+		notes, err := advanceTournament(ctx, id)
+		if err != nil {
+			log.Printf("tournament scheduler: advancing %d: %v", id, err)
+			continue
+		}
+		go sendTournamentNotes(notes)
+	}
+	return nil
+}
+
+func queryIDs(ctx context.Context, query string, args ...any) ([]int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			out = append(out, id)
+		}
+	}
+	return out, rows.Err()
+}
+
+// tournamentNote is a notification to send once a transaction has committed.
+type tournamentNote struct {
+	username, message string
+}
+
+func sendTournamentNotes(notes []tournamentNote) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, n := range notes {
+		deliverNotification(n.username, Notification{
+			Type:      "tournament",
+			Message:   n.message,
+			Timestamp: now,
+		})
+	}
+}
+
+// tournamentRow is the tournament as the scheduler needs it, read under lock.
+type tournamentRow struct {
+	id                        int
+	prefix, subject, category string
+	status                    string
+	round                     int
+	entrants                  map[string]*TournamentEntrant
+	seeds                     map[string]int
+}
+
+// lockTournament reads and locks a tournament and its entrants.
+func lockTournament(ctx context.Context, tx *sql.Tx, id int) (*tournamentRow, error) {
+	t := &tournamentRow{id: id, entrants: map[string]*TournamentEntrant{}, seeds: map[string]int{}}
+	err := tx.QueryRowContext(ctx, `
+		SELECT prefix, subject, category, status, round
+		FROM tournaments WHERE id = $1 FOR UPDATE`, id).
+		Scan(&t.prefix, &t.subject, &t.category, &t.status, &t.round)
+	if err == sql.ErrNoRows {
+		return nil, errTournamentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT CAST(e.user_id AS TEXT), u.username, COALESCE(u.league, 'Bronze'),
+		       e.video_url, e.thumbnail_url, COALESCE(e.seed, 0), COALESCE(e.eliminated_round, 0)
+		FROM tournament_entrants e
+		JOIN users u ON u.id = e.user_id
+		WHERE e.tournament_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e TournamentEntrant
+		if err := rows.Scan(&e.UserID, &e.Username, &e.League, &e.VideoURL, &e.ThumbnailURL, &e.Seed, &e.EliminatedRound); err != nil {
+			return nil, err
+		}
+		t.entrants[e.UserID] = &e
+		if e.Seed > 0 {
+			t.seeds[e.UserID] = e.Seed
+		}
+	}
+	return t, rows.Err()
+}
+
+// drawTournament closes entries, seeds the bracket and starts round one — or
+// cancels the tournament if fewer than two people entered.
+func drawTournament(ctx context.Context, id int) ([]tournamentNote, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	t, err := lockTournament(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.status != "open" {
+		return nil, nil
+	}
+	title := t.prefix + " " + t.subject
+
+	var notes []tournamentNote
+	if len(t.entrants) < 2 {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE tournaments SET status = 'cancelled', completed_at = NOW() WHERE id = $1`, id); err != nil {
+			return nil, err
+		}
+		for _, e := range t.entrants {
+			notes = append(notes, tournamentNote{e.Username,
+				fmt.Sprintf("\"%s\" was cancelled — not enough people entered.", title)})
+		}
+		return notes, tx.Commit()
+	}
+
+	// Seed by rating in the tournament's category — the same number
+	// matchmaking uses, including its fallback for people not yet rated.
+	rows, err := tx.QueryContext(ctx,
+		`SELECT CAST(user_id AS TEXT), joined_at FROM tournament_entrants WHERE tournament_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	var cands []seedCandidate
+	for rows.Next() {
+		var c seedCandidate
+		if rows.Scan(&c.userID, &c.joinedAt) == nil {
+			if e := t.entrants[c.userID]; e != nil {
+				c.rating = matchmakingRating(c.userID, e.League, t.category)
+			}
+			cands = append(cands, c)
+		}
+	}
+	rows.Close()
+	seeded := seedEntrants(cands)
+	for i, uid := range seeded {
+		t.seeds[uid] = i + 1
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE tournament_entrants SET seed = $3 WHERE tournament_id = $1 AND user_id = CAST($2 AS INT)`,
+			id, uid, i+1); err != nil {
+			return nil, err
+		}
+	}
+
+	for slot, pair := range firstRoundPairs(len(seeded)) {
+		a, b := "", ""
+		if pair[0] > 0 {
+			a = seeded[pair[0]-1]
+		}
+		if pair[1] > 0 {
+			b = seeded[pair[1]-1]
+		}
+		n, err := createTournamentMatch(ctx, tx, t, 1, slot, a, b)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, n...)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE tournaments SET status = 'running', round = 1, started_at = NOW() WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	return notes, tx.Commit()
+}
+
+// createTournamentMatch creates one matchup. A matchup with both sides is
+// played as a battle: a challenge with A's entry as the challenger's video
+// and B's as the one response, live from the moment it is created. A matchup
+// with one side is a bye and is decided on the spot; with none, it is decided
+// for nobody.
+func createTournamentMatch(ctx context.Context, tx *sql.Tx, t *tournamentRow, round, slot int, a, b string) ([]tournamentNote, error) {
+	ea, eb := t.entrants[a], t.entrants[b]
+	if ea == nil {
+		a, ea, b, eb = b, eb, "", nil
+	}
+	if ea == nil || eb == nil {
+		var winner any
+		if ea != nil {
+			winner = a
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO tournament_matches (tournament_id, round, slot, entrant_a, winner_id, status, decided_at)
+			VALUES ($1, $2, $3, CAST($4 AS INT), CAST($4 AS INT), 'done', NOW())`,
+			t.id, round, slot, winner)
+		if err != nil {
+			return nil, fmt.Errorf("recording a bye: %w", err)
+		}
+		if ea == nil {
+			return nil, nil
+		}
+		return []tournamentNote{{ea.Username,
+			fmt.Sprintf("You have a bye in round %d of \"%s %s\" — straight through.", round, t.prefix, t.subject)}}, nil
+	}
+
+	var challengeID int
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO challenges (creator_id, video_url, thumbnail_url, prefix, subject, visibility, category, status)
+		VALUES (CAST($1 AS INT), $2, $3, $4, $5, 'arena', $6, 'active')
+		RETURNING id`,
+		a, ea.VideoURL, ea.ThumbnailURL, t.prefix, t.subject, t.category).Scan(&challengeID)
+	if err != nil {
+		return nil, fmt.Errorf("creating the battle: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO challenge_responses (challenge_id, responder_id, video_url, thumbnail_url)
+		VALUES ($1, CAST($2 AS INT), $3, $4)`,
+		challengeID, b, eb.VideoURL, eb.ThumbnailURL); err != nil {
+		return nil, fmt.Errorf("adding the second side: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO tournament_matches (tournament_id, round, slot, entrant_a, entrant_b, challenge_id, status)
+		VALUES ($1, $2, $3, CAST($4 AS INT), CAST($5 AS INT), $6, 'live')`,
+		t.id, round, slot, a, b, challengeID); err != nil {
+		return nil, fmt.Errorf("recording the matchup: %w", err)
+	}
+
+	msg := func(opp string) string {
+		return fmt.Sprintf("Round %d of \"%s %s\": you vs %s. Voting is open for %d hours.",
+			round, t.prefix, t.subject, opp, int(challengeLifetime.Hours()))
+	}
+	return []tournamentNote{{ea.Username, msg(eb.Username)}, {eb.Username, msg(ea.Username)}}, nil
+}
+
+// advanceTournament settles a round whose battles have all closed: records
+// each matchup's winner, knocks out the losers, and either draws the next
+// round or crowns the champion.
+func advanceTournament(ctx context.Context, id int) ([]tournamentNote, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	t, err := lockTournament(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.status != "running" {
+		return nil, nil
+	}
+
+	type matchState struct {
+		id, slot     int
+		a, b, winner string
+		status       string
+		hasBattle    bool
+		resolved     bool
+		battleWinner string
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT m.id, m.slot,
+		       COALESCE(CAST(m.entrant_a AS TEXT), ''), COALESCE(CAST(m.entrant_b AS TEXT), ''),
+		       COALESCE(CAST(m.winner_id AS TEXT), ''), m.status,
+		       c.id IS NOT NULL, COALESCE(c.resolved_at IS NOT NULL, FALSE),
+		       COALESCE(CAST(c.winner_id AS TEXT), '')
+		FROM tournament_matches m
+		LEFT JOIN challenges c ON c.id = m.challenge_id
+		WHERE m.tournament_id = $1 AND m.round = $2
+		ORDER BY m.slot
+		FOR UPDATE OF m`, id, t.round)
+	if err != nil {
+		return nil, err
+	}
+	var matches []matchState
+	for rows.Next() {
+		var m matchState
+		if err := rows.Scan(&m.id, &m.slot, &m.a, &m.b, &m.winner, &m.status,
+			&m.hasBattle, &m.resolved, &m.battleWinner); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Every battle in the round must be settled (or gone — a deleted battle
+	// is decided on seed rather than holding the tournament up forever).
+	for _, m := range matches {
+		if m.status == "live" && m.hasBattle && !m.resolved {
+			return nil, nil
+		}
+	}
+
+	title := t.prefix + " " + t.subject
+	var notes []tournamentNote
+	winners := make([]string, len(matches))
+	for i, m := range matches {
+		if m.status == "done" {
+			winners[i] = m.winner
+			continue
+		}
+		w := matchWinner(m.a, m.b, m.battleWinner, t.seeds)
+		winners[i] = w
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE tournament_matches
+			   SET winner_id = CAST(NULLIF($2, '') AS INT), status = 'done', decided_at = NOW()
+			 WHERE id = $1`, m.id, w); err != nil {
+			return nil, err
+		}
+		loser := m.a
+		if w == m.a {
+			loser = m.b
+		}
+		if loser != "" {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE tournament_entrants SET eliminated_round = $3
+				 WHERE tournament_id = $1 AND user_id = CAST($2 AS INT)`, id, loser, t.round); err != nil {
+				return nil, err
+			}
+			if e := t.entrants[loser]; e != nil {
+				notes = append(notes, tournamentNote{e.Username,
+					fmt.Sprintf("You're out of \"%s\" in round %d. Thanks for battling!", title, t.round)})
+			}
+		}
+	}
+
+	// The final is settled: crown the champion.
+	if len(winners) <= 1 {
+		champion := ""
+		if len(winners) == 1 {
+			champion = winners[0]
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE tournaments
+			   SET status = 'completed', winner_id = CAST(NULLIF($2, '') AS INT), completed_at = NOW()
+			 WHERE id = $1`, id, champion); err != nil {
+			return nil, err
+		}
+		for _, e := range t.entrants {
+			msg := fmt.Sprintf("\"%s\" is over.", title)
+			if ch := t.entrants[champion]; ch != nil {
+				msg = fmt.Sprintf("%s won \"%s\"!", ch.Username, title)
+				if e.UserID == champion {
+					msg = fmt.Sprintf("You won \"%s\"! 🏆", title)
+				}
+			}
+			notes = append(notes, tournamentNote{e.Username, msg})
+		}
+		return notes, tx.Commit()
+	}
+
+	// Winners of slots 2k and 2k+1 meet in slot k.
+	next := t.round + 1
+	for k := 0; k*2 < len(winners); k++ {
+		a, b := winners[2*k], ""
+		if 2*k+1 < len(winners) {
+			b = winners[2*k+1]
+		}
+		n, err := createTournamentMatch(ctx, tx, t, next, k, a, b)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, n...)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tournaments SET round = $2 WHERE id = $1`, id, next); err != nil {
+		return nil, err
+	}
+	return notes, tx.Commit()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// STORE
+// ════════════════════════════════════════════════════════════════════════════════
+
+// createTournament opens a tournament. Validation is the handler's job.
+func createTournament(creatorID string, p CreateTournamentPayload) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO tournaments (creator_id, prefix, subject, category, max_entrants, entry_deadline)
+		VALUES (CAST($1 AS INT), $2, $3, $4, $5, NOW() + ($6)::interval)
+		RETURNING id`,
+		creatorID, p.Prefix, p.Subject, p.Category, p.Size,
+		fmt.Sprintf("%d hours", p.EntryHours)).Scan(&id)
+	return id, err
+}
+
+// joinTournament enters a user with their entry video. The tournament row is
+// locked so the last place cannot be taken twice.
+func joinTournament(id int, userID string, p JoinTournamentPayload) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var status string
+	var maxEntrants int
+	var closed bool
+	err = tx.QueryRow(`
+		SELECT status, max_entrants, entry_deadline <= NOW()
+		FROM tournaments WHERE id = $1 FOR UPDATE`, id).Scan(&status, &maxEntrants, &closed)
+	if err == sql.ErrNoRows {
+		return errTournamentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != "open" || closed {
+		return errTournamentClosed
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM tournament_entrants WHERE tournament_id = $1`, id).Scan(&count); err != nil {
+		return err
+	}
+	if count >= maxEntrants {
+		return errTournamentFull
+	}
+	res, err := tx.Exec(`
+		INSERT INTO tournament_entrants (tournament_id, user_id, video_url, thumbnail_url)
+		VALUES ($1, CAST($2 AS INT), $3, $4)
+		ON CONFLICT (tournament_id, user_id) DO NOTHING`,
+		id, userID, p.VideoURL, p.ThumbnailURL)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAlreadyEntered
+	}
+	return tx.Commit()
+}
+
+const tournamentSelect = `
+	SELECT t.id, CAST(t.creator_id AS TEXT), u.username, t.prefix, t.subject, t.category,
+	       t.max_entrants,
+	       (SELECT COUNT(*) FROM tournament_entrants e WHERE e.tournament_id = t.id),
+	       t.status, t.round, t.entry_deadline,
+	       COALESCE(CAST(t.winner_id AS TEXT), ''), COALESCE(w.username, ''), t.created_at
+	FROM tournaments t
+	JOIN users u ON u.id = t.creator_id
+	LEFT JOIN users w ON w.id = t.winner_id`
+
+func scanTournament(row interface{ Scan(...any) error }) (Tournament, error) {
+	var t Tournament
+	var id int
+	var deadline, created time.Time
+	err := row.Scan(&id, &t.CreatorID, &t.CreatorUsername, &t.Prefix, &t.Subject, &t.Category,
+		&t.MaxEntrants, &t.EntrantCount, &t.Status, &t.Round, &deadline,
+		&t.WinnerID, &t.WinnerUsername, &created)
+	if err != nil {
+		return t, err
+	}
+	t.ID = strconv.Itoa(id)
+	t.EntryDeadline = deadline.UTC().Format(time.RFC3339)
+	t.CreatedAt = created.UTC().Format(time.RFC3339)
+	n := t.EntrantCount
+	if t.Status == "open" || n < 2 {
+		n = t.MaxEntrants
+	}
+	t.Rounds = bracketRounds(bracketSize(n))
+	return t, nil
+}
+
+// getTournamentBracket reads a tournament with its entrants and every
+// matchup so far.
+func getTournamentBracket(id int) (TournamentBracket, error) {
+	var b TournamentBracket
+	t, err := scanTournament(db.QueryRow(tournamentSelect+` WHERE t.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return b, errTournamentNotFound
+	}
+	if err != nil {
+		return b, err
+	}
+	b.Tournament = t
+	b.Entrants = []TournamentEntrant{}
+	b.Matches = [][]TournamentMatch{}
+
+	rows, err := db.Query(`
+		SELECT CAST(e.user_id AS TEXT), u.username, COALESCE(u.league, 'Bronze'),
+		       e.video_url, e.thumbnail_url, COALESCE(e.seed, 0), COALESCE(e.eliminated_round, 0)
+		FROM tournament_entrants e
+		JOIN users u ON u.id = e.user_id
+		WHERE e.tournament_id = $1
+		ORDER BY COALESCE(e.seed, 2147483647), e.joined_at`, id)
+	if err != nil {
+		return b, err
+	}
+	for rows.Next() {
+		var e TournamentEntrant
+		if rows.Scan(&e.UserID, &e.Username, &e.League, &e.VideoURL, &e.ThumbnailURL, &e.Seed, &e.EliminatedRound) == nil {
+			b.Entrants = append(b.Entrants, e)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT m.id, m.round, m.slot,
+		       COALESCE(CAST(m.entrant_a AS TEXT), ''), COALESCE(CAST(m.entrant_b AS TEXT), ''),
+		       COALESCE(CAST(m.challenge_id AS TEXT), ''), COALESCE(CAST(m.winner_id AS TEXT), ''),
+		       m.status, c.created_at
+		FROM tournament_matches m
+		LEFT JOIN challenges c ON c.id = m.challenge_id
+		WHERE m.tournament_id = $1
+		ORDER BY m.round, m.slot`, id)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m TournamentMatch
+		var mid int
+		var started sql.NullTime
+		if rows.Scan(&mid, &m.Round, &m.Slot, &m.EntrantA, &m.EntrantB, &m.ChallengeID, &m.WinnerID, &m.Status, &started) != nil {
+			continue
+		}
+		m.ID = strconv.Itoa(mid)
+		if started.Valid && m.Status == "live" {
+			m.EndsAt = started.Time.Add(challengeLifetime).UTC().Format(time.RFC3339)
+		}
+		for len(b.Matches) < m.Round {
+			b.Matches = append(b.Matches, []TournamentMatch{})
+		}
+		b.Matches[m.Round-1] = append(b.Matches[m.Round-1], m)
+	}
+	return b, rows.Err()
+}
+
+// listTournaments lists tournaments in one status, newest first.
+func listTournaments(status string, limit int) ([]Tournament, error) {
+	rows, err := db.Query(tournamentSelect+`
+		WHERE t.status = $1
+		ORDER BY t.created_at DESC
+		LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Tournament{}
+	for rows.Next() {
+		if t, err := scanTournament(rows); err == nil {
+			out = append(out, t)
+		}
+	}
+	return out, rows.Err()
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// FEED LANE
+// ════════════════════════════════════════════════════════════════════════════════
+
+// sourceTournament retrieves live tournament matchups, the ones closing
+// soonest first — a matchup only needs an audience while its vote is open.
+// The viewer's own matchups are left out; they cannot vote in them.
+func sourceTournament(userID string, limit int) []HomeFeedItem {
+	if db == nil || limit <= 0 {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT c.id, c.creator_id, u.username, u.league, c.video_url,
+			c.thumbnail_url, c.prefix, c.subject, c.visibility, c.status,
+			c.views, c.created_at, COALESCE(c.category, 'other'),
+			CAST(m.tournament_id AS TEXT), m.round
+		FROM tournament_matches m
+		JOIN challenges c ON c.id = m.challenge_id
+		JOIN users u ON c.creator_id = u.id
+		WHERE m.status = 'live'
+		  AND c.resolved_at IS NULL
+		  AND c.visibility = 'arena'
+		  AND m.entrant_a <> CAST($1 AS INT)
+		  AND m.entrant_b <> CAST($1 AS INT)
+		ORDER BY c.created_at ASC
+		LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil // fail quiet: one lane returning nothing never breaks a feed
+	}
+	defer rows.Close()
+
+	var items []HomeFeedItem
+	for rows.Next() {
+		var ch Challenge
+		var creatorID, views int
+		var createdAt time.Time
+		if err := rows.Scan(&ch.ID, &creatorID, &ch.CreatorUsername, &ch.CreatorLeague,
+			&ch.VideoURL, &ch.ThumbnailURL, &ch.Prefix, &ch.Subject,
+			&ch.Visibility, &ch.Status, &views, &createdAt, &ch.Category,
+			&ch.TournamentID, &ch.TournamentRound); err != nil {
+			continue
+		}
+		ch.CreatorID = strconv.Itoa(creatorID)
+		ch.Views = views
+		ch.ResponseCount = 1
+		ch.CreatedAt = createdAt.Format(time.RFC3339)
+		ch.ExpiresAt = createdAt.Add(challengeLifetime).Format(time.RFC3339)
+		items = append(items, HomeFeedItem{Type: "challenge", Challenge: &ch})
+	}
+	return items
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// HTTP
+// ════════════════════════════════════════════════════════════════════════════════
+
+// CreateTournamentHandler opens a tournament.
+// POST /api/v1/tournaments body:{ prefix, subject, category?, size?, entryHours? }
+func CreateTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	var p CreateTournamentPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	// A posting ban refuses new tournaments, as it does new challenges.
+	// See enforcement.go.
+	if !requireCanPost(w, userID) {
+		return
+	}
+	if !allowAction(userID, "tournament_create") {
+		writeRateLimited(w, "tournament_create")
+		return
+	}
+
+	p.Prefix = strings.TrimSpace(p.Prefix)
+	p.Subject = strings.TrimSpace(p.Subject)
+	if p.Prefix == "" || p.Subject == "" {
+		http.Error(w, "prefix and subject are required", http.StatusBadRequest)
+		return
+	}
+	if len(p.Prefix) > 100 || len(p.Subject) > 100 {
+		http.Error(w, "prefix and subject must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if p.Size == 0 {
+		p.Size = tournamentDefaultSize
+	}
+	if !tournamentSizes[p.Size] {
+		http.Error(w, "size must be 4, 8, 16 or 32", http.StatusBadRequest)
+		return
+	}
+	if p.EntryHours == 0 {
+		p.EntryHours = tournamentDefaultEntryHours
+	}
+	if p.EntryHours < tournamentMinEntryHours || p.EntryHours > tournamentMaxEntryHours {
+		http.Error(w, fmt.Sprintf("entryHours must be between %d and %d",
+			tournamentMinEntryHours, tournamentMaxEntryHours), http.StatusBadRequest)
+		return
+	}
+	if p.Category == "" {
+		p.Category = inferCategory(p.Subject, p.Prefix, "")
+	}
+
+	id, err := createTournament(userID, p)
+	if err != nil {
+		http.Error(w, "Failed to create tournament: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := getTournamentBracket(id)
+	if err != nil {
+		http.Error(w, "Failed to load tournament: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(b)
+}
+
+// JoinTournamentHandler enters the caller into an open tournament.
+// POST /api/v1/tournaments/{id}/join body:{ videoUrl, durationMs, thumbnailUrl? }
+func JoinTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid tournament id", http.StatusBadRequest)
+		return
+	}
+	var p JoinTournamentPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID := authUserID(r)
+	// An entry becomes a battle side, so a posting ban refuses it like any
+	// other response.
+	if !requireCanPost(w, userID) {
+		return
+	}
+	// Entering is the tournament equivalent of answering a challenge.
+	if !allowAction(userID, "challenge_accept") {
+		writeRateLimited(w, "challenge_accept")
+		return
+	}
+	// The entry is one side of a battle once the bracket is drawn, so it
+	// passes everything an answer to a challenge does: tier-1 validation
+	// (challenge_validation.go), then the size gate (video_probe.go).
+	if err := validateTournamentEntrySubmission(userID, p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if refusal, dims, _ := gateUpload(p.VideoURL); refusal != "" {
+		log.Printf("rejected oversized tournament entry from %s: %s", userID, dims)
+		http.Error(w, refusal, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	switch err := joinTournament(id, userID, p); {
+	case errors.Is(err, errTournamentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errTournamentClosed), errors.Is(err, errTournamentFull), errors.Is(err, errAlreadyEntered):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to join tournament: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := getTournamentBracket(id)
+	if err != nil {
+		http.Error(w, "Failed to load tournament: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// GetTournamentHandler returns a tournament's bracket.
+// GET /api/v1/tournaments/{id}
+func GetTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid tournament id", http.StatusBadRequest)
+		return
+	}
+	b, err := getTournamentBracket(id)
+	if errors.Is(err, errTournamentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load tournament: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// ListTournamentsHandler lists tournaments by status (default "open").
+// GET /api/v1/tournaments?status=open|running|completed
+func ListTournamentsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	switch status {
+	case "open", "running", "completed":
+	default:
+		http.Error(w, "status must be open, running or completed", http.StatusBadRequest)
+		return
+	}
+	ts, err := listTournaments(status, 50)
+	if err != nil {
+		http.Error(w, "Failed to list tournaments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ts)
+}
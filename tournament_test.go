@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// The bracket arithmetic decides who plays whom for a whole tournament, so it
+// is pinned exactly. The scheduler around it is mostly SQL and is left to the
+// battle tests it reuses.
+
+// ── The draw ────────────────────────────────────────────────────────────────
+
+func TestBracketSize(t *testing.T) {
+	cases := map[int]int{0: 2, 1: 2, 2: 2, 3: 4, 4: 4, 5: 8, 8: 8, 9: 16, 17: 32, 32: 32}
+	for n, want := range cases {
+		if got := bracketSize(n); got != want {
+			t.Errorf("bracketSize(%d) = %d, want %d", n, got, want)
+		}
+	}
+	for size, want := range map[int]int{2: 1, 4: 2, 8: 3, 16: 4, 32: 5} {
+		if got := bracketRounds(size); got != want {
+			t.Errorf("bracketRounds(%d) = %d, want %d", size, got, want)
+		}
+	}
+}
+
+func TestBracketSeedOrder(t *testing.T) {
+	cases := map[int][]int{
+		2: {1, 2},
+		4: {1, 4, 2, 3},
+		8: {1, 8, 4, 5, 2, 7, 3, 6},
+	}
+	for size, want := range cases {
+		if got := bracketSeedOrder(size); !reflect.DeepEqual(got, want) {
+			t.Errorf("bracketSeedOrder(%d) = %v, want %v", size, got, want)
+		}
+	}
+
+	// Whatever the size: every first-round pair sums to size+1 (best meets
+	// worst), and seeds 1 and 2 are in opposite halves.
+	for _, size := range []int{4, 8, 16, 32} {
+		order := bracketSeedOrder(size)
+		for i := 0; i < size; i += 2 {
+			if order[i]+order[i+1] != size+1 {
+				t.Errorf("size %d: pair %v+%v does not sum to %d", size, order[i], order[i+1], size+1)
+			}
+		}
+		half := map[int]int{}
+		for i, s := range order {
+			half[s] = i / (size / 2)
+		}
+		if half[1] == half[2] {
+			t.Errorf("size %d: seeds 1 and 2 can meet before the final", size)
+		}
+	}
+}
+
+func TestFirstRoundPairsGivesByesToTopSeeds(t *testing.T) {
+	got := firstRoundPairs(6)
+	want := [][2]int{{1, 0}, {4, 5}, {2, 0}, {3, 6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("firstRoundPairs(6) = %v, want %v", got, want)
+	}
+	// A full bracket has no byes.
+	for _, p := range firstRoundPairs(8) {
+		if p[0] == 0 || p[1] == 0 {
+			t.Errorf("full bracket has a bye: %v", p)
+		}
+	}
+}
+
+func TestSeedEntrants(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	got := seedEntrants([]seedCandidate{
+		{userID: "1", rating: 1400, joinedAt: t0},
+		{userID: "2", rating: 1600, joinedAt: t0.Add(time.Hour)},
+		{userID: "3", rating: 1400, joinedAt: t0.Add(-time.Hour)}, // same rating, joined first
+		{userID: "4", rating: 1200, joinedAt: t0},
+	})
+	want := []string{"2", "3", "1", "4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("seedEntrants = %v, want %v", got, want)
+	}
+}
+
+// ── Deciding a matchup ──────────────────────────────────────────────────────
+
+func TestMatchWinner(t *testing.T) {
+	seeds := map[string]int{"10": 1, "20": 4}
+	cases := []struct {
+		name         string
+		a, b, battle string
+		want         string
+	}{
+		{"battle winner goes through", "10", "20", "20", "20"},
+		{"no winner: better seed", "10", "20", "", "10"},
+		{"no winner, better seed on side B", "20", "10", "", "10"},
+		{"winner not in this matchup is ignored", "10", "20", "99", "10"},
+		{"bye", "10", "", "", "10"},
+		{"empty side A", "", "20", "", "20"},
+		{"nobody", "", "", "", ""},
+		{"unseeded side loses on a no-result", "10", "77", "", "10"},
+	}
+	for _, c := range cases {
+		if got := matchWinner(c.a, c.b, c.battle, seeds); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// ── Entering ────────────────────────────────────────────────────────────────
+
+func TestJoinTournamentRefusesWhenFull(t *testing.T) {
+	mock, done := withMockDB(t)
+	defer done()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM tournaments WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "max_entrants", "closed"}).
+			AddRow("open", 4, false))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM tournament_entrants`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectRollback()
+
+	err := joinTournament(7, "42", JoinTournamentPayload{VideoURL: "https://v/1.mp4"})
+	if !errors.Is(err, errTournamentFull) {
+		t.Fatalf("got %v, want errTournamentFull", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJoinTournamentRefusesAfterDeadline(t *testing.T) {
+	mock, done := withMockDB(t)
+	defer done()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM tournaments WHERE id = \$1 FOR UPDATE`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "max_entrants", "closed"}).
+			AddRow("open", 8, true))
+	mock.ExpectRollback()
+
+	err := joinTournament(7, "42", JoinTournamentPayload{VideoURL: "https://v/1.mp4"})
+	if !errors.Is(err, errTournamentClosed) {
+		t.Fatalf("got %v, want errTournamentClosed", err)
+	}
+}
+
+// An entry is checked like any answer to a challenge before the tournament is
+// touched.
+func TestJoinTournamentHandlerValidatesTheEntry(t *testing.T) {
+	resetRedis(t)
+	mock, done := withMockDB(t)
+	defer done()
+	mock.ExpectQuery(`FROM challenge_responses WHERE responder_id=\$1 AND video_url=\$2`).
+		WithArgs(42, "https://v/old.mp4").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	join := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/tournaments/7/join", strings.NewReader(body))
+		req = mux.SetURLVars(withAuth(req, "42", "kim"), map[string]string{"id": "7"})
+		rec := httptest.NewRecorder()
+		JoinTournamentHandler(rec, req)
+		return rec
+	}
+	if rec := join(`{"videoUrl":"https://v/1.mp4","durationMs":500}`); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), "too short") {
+		t.Errorf("short entry: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := join(`{"videoUrl":"https://v/old.mp4","durationMs":15000}`); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), "already used this video") {
+		t.Errorf("reused video: %d %q", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// ── The feed lane ───────────────────────────────────────────────────────────
+
+func TestTournamentLaneIsRegistered(t *testing.T) {
+	for _, build := range []struct {
+		name    string
+		sources []candidateSource
+	}{
+		{"default", buildDefaultSources()},
+		{"cohort", buildSourcesForCohort(CohortEngaged)},
+	} {
+		t.Run(build.name, func(t *testing.T) {
+			for _, s := range build.sources {
+				if s.name == "tournament" {
+					if s.fetch == nil || s.weight <= 0 {
+						t.Errorf("tournament lane registered with fetch=%v weight=%v", s.fetch != nil, s.weight)
+					}
+					return
+				}
+			}
+			t.Error("no tournament lane — live matchups only reach the feed by chance")
+		})
+	}
+}
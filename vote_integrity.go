@@ -0,0 +1,421 @@
+package main
+
+// vote_integrity.go — deciding which votes count, and how much.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// THE PROBLEM THIS FIXES
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Once votes started deciding battles, records and ratings, every weakness in
+// the vote became a way to win. Any authenticated account could vote, so:
+//
+//   - a battler voted for themselves, or their opponent's account did;
+//   - a vote could be cast from the feed without the voter ever having seen
+//     the other video;
+//   - a handful of fresh accounts could carry a battle;
+//   - a group of friends or sockpuppets could vote as a bloc, battle after
+//     battle;
+//   - a voter could switch sides as often as they liked, following whoever was
+//     behind.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHAT HAPPENS NOW
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Refused outright, when the vote is cast (CastVote):
+//
+//	participants  nobody in a battle votes in it — not for themselves and
+//	              not for anyone else.
+//	watching      the voter must have watched the side they vote for AND at
+//	              least one other side for voteMinDwellMs each, according to
+//	              watch_events. A vote is a comparison; it means nothing from
+//	              someone who saw one video.
+//	switching     one change of mind per battle (voteMaxChanges).
+//
+// Counted for less, with the weight stored on the vote row:
+//
+//	new accounts  ramp from voteNewAccountFloor to a full vote over
+//	              voteNewAccountDays.
+//	engagement    engagement_quality.go's multiplier, capped at 1 — a vote is
+//	              never worth MORE than one, however engaged the voter.
+//	rings         accounts the ring scan has flagged count voteRingPenalty.
+//
+// Raw counts are still what the public vote counter and the early-close quorum
+// read — everybody can see and understand those. The winner is decided on the
+// weighted tally.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// RINGS
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// An hourly scan pairs up voters who backed the same side in at least
+// voteRingMinCoVotes battles over the last voteRingWindow, nearly every time
+// they both voted (voteRingMinAgreement), AND are connected — one follows the
+// other, or user_similarities rates them near-identical. Friends who happen to
+// agree are not enough on their own; agreement without a connection is just
+// taste. Pairs are joined into groups, and a group of voteRingMinSize or more
+// is flagged.
+//
+// Flagging is forward-looking plus the battles still live: a flagged account's
+// votes on unresolved battles are re-weighted at once, and every vote it casts
+// afterwards is weighted at cast time. Settled battles are never re-opened.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// voteMinDwellMs is how long a voter must have watched a side (summed
+	// across views) for it to count as watched.
+	voteMinDwellMs = 3000
+	// voteMaxChanges is how many times a voter may switch sides in one battle.
+	voteMaxChanges = 1
+
+	// voteNewAccountDays is how long a new account takes to earn a full vote.
+	voteNewAccountDays = 14.0
+	// voteNewAccountFloor is what a vote from a day-old account is worth.
+	voteNewAccountFloor = 0.25
+	// voteMinWeight keeps every accepted vote worth something, so a voter is
+	// never told their vote was counted when it was in fact worth zero.
+	voteMinWeight = 0.05
+
+	// voteRingPenalty is what a vote from a flagged ring member is worth,
+	// relative to what it would otherwise be.
+	voteRingPenalty = 0.2
+	// The ring scan's thresholds — see RINGS above.
+	voteRingWindow       = 30 * 24 * time.Hour
+	voteRingMinCoVotes   = 5
+	voteRingMinAgreement = 0.8
+	voteRingMinSize      = 3
+	voteRingSimilarity   = 0.9
+	voteRingScanInterval = time.Hour
+)
+
+// Why a vote was refused. The handler maps each to a status and passes the
+// message through, so the client can tell the voter what to do.
+var (
+	errVoteByParticipant = errors.New("you're in this battle, so you can't vote in it")
+	errVoteNeedsWatch    = errors.New("watch both videos before you vote")
+	errVoteLocked        = errors.New("you've already changed your vote in this battle")
+	errVoteUnknownSide   = errors.New("that video isn't part of this battle")
+)
+
+// ════════════════════════════════════════════════════════════════════════════════
+// SCREENING ONE VOTE
+// ════════════════════════════════════════════════════════════════════════════════
+
+// challengeSideKey names a side for the dwell check: "challenge" for the
+// challenger's video, the response id for a response.
+const challengeSideKey = "challenge"
+
+// watchedEnough reports whether a voter has watched the side they chose and at
+// least one other. dwell maps side key → summed watch time in ms.
+func watchedEnough(dwell map[string]int, chosen string) bool {
+	if dwell[chosen] < voteMinDwellMs {
+		return false
+	}
+	for side, ms := range dwell {
+		if side != chosen && ms >= voteMinDwellMs {
+			return true
+		}
+	}
+	return false
+}
+
+// voteWeight is what one vote is worth, from the voter's account age (ageKnown
+// false for accounts that predate age tracking, which count as established),
+// their engagement-quality multiplier, and whether they are in a flagged ring.
+// Pure, so the numbers can be read and tested without a database.
+func voteWeight(ageDays float64, ageKnown bool, engagement float64, inRing bool) float64 {
+	age := 1.0
+	if ageKnown {
+		age = voteNewAccountFloor + (1-voteNewAccountFloor)*math.Max(0, ageDays)/voteNewAccountDays
+		age = math.Min(1, age)
+	}
+	w := age * math.Min(1, engagement)
+	if inRing {
+		w *= voteRingPenalty
+	}
+	return math.Max(voteMinWeight, w)
+}
+
+// voterWeight looks up what a voter's vote is worth right now.
+func voterWeight(voterID string) float64 {
+	var ageDays sql.NullFloat64
+	var inRing bool
+	err := db.QueryRow(`
+		SELECT EXTRACT(EPOCH FROM (NOW() - u.created_at)) / 86400,
+		       EXISTS (SELECT 1 FROM vote_ring_members m WHERE m.user_id = u.id)
+		FROM users u
+		WHERE u.id = CAST($1 AS INT)`, voterID).Scan(&ageDays, &inRing)
+	if err != nil {
+		// Unknown voter state is not a reason to refuse a vote; count it in
+		// full, as every vote was counted before this file existed.
+		return 1
+	}
+	return voteWeight(ageDays.Float64, ageDays.Valid, userEngagementQuality(voterID), inRing)
+}
+
+// voterDwell reads how long a voter has watched each side of a battle.
+func voterDwell(tx *sql.Tx, voterID, cid int) (map[string]int, error) {
+	rows, err := tx.Query(`
+		SELECT CASE WHEN we.content_type = 'challenge' THEN $3 ELSE CAST(we.content_id AS TEXT) END,
+		       SUM(we.watch_time)
+		FROM watch_events we
+		WHERE we.user_id = $1
+		  AND ((we.content_type = 'challenge' AND we.content_id = $2)
+		    OR (we.content_type = 'response' AND we.content_id IN
+		          (SELECT id FROM challenge_responses WHERE challenge_id = $2)))
+		GROUP BY 1`, voterID, cid, challengeSideKey)
+	if err != nil {
+		return nil, fmt.Errorf("reading watch history: %w", err)
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var side string
+		var ms int
+		if rows.Scan(&side, &ms) == nil {
+			out[side] = ms
+		}
+	}
+	return out, rows.Err()
+}
+
+// recordVoteOutcome counts a vote decision for the dashboard.
+func recordVoteOutcome(outcome string) {
+	if metricVoteIntegrity != nil {
+		metricVoteIntegrity.WithLabelValues(outcome).Inc()
+	}
+}
+
+// voteOutcomeLabel is the metric label for a CastVote error.
+func voteOutcomeLabel(err error) string {
+	switch {
+	case err == nil:
+		return "accepted"
+	case errors.Is(err, errVoteByParticipant):
+		return "participant"
+	case errors.Is(err, errVoteNeedsWatch):
+		return "not_watched"
+	case errors.Is(err, errVoteLocked):
+		return "locked"
+	case errors.Is(err, errBattleClosed):
+		return "closed"
+	default:
+		return "error"
+	}
+}
+
+// ════════════════════════════════════════════════════════════════════════════════
+// RINGS
+// ════════════════════════════════════════════════════════════════════════════════
+
+// votePair is two voters who keep backing the same side.
+type votePair struct {
+	a, b     string
+	together int
+}
+
+// findVoteRings joins pairs into groups (anybody linked by a chain of pairs is
+// in the same group) and returns the groups of at least minSize, each sorted,
+// largest first. Pure.
+func findVoteRings(pairs []votePair, minSize int) [][]string {
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(x string) string {
+		if p, ok := parent[x]; ok && p != x {
+			parent[x] = find(p)
+			return parent[x]
+		}
+		parent[x] = x
+		return x
+	}
+	for _, p := range pairs {
+		ra, rb := find(p.a), find(p.b)
+		if ra != rb {
+			parent[ra] = rb
+		}
+	}
+
+	groups := map[string][]string{}
+	for x := range parent {
+		r := find(x)
+		groups[r] = append(groups[r], x)
+	}
+	var out [][]string
+	for _, g := range groups {
+		if len(g) < minSize {
+			continue
+		}
+		sort.Slice(g, func(i, j int) bool { return lessNumericID(g[i], g[j]) })
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i]) != len(out[j]) {
+			return len(out[i]) > len(out[j])
+		}
+		return lessNumericID(out[i][0], out[j][0])
+	})
+	return out
+}
+
+// lessNumericID orders numeric ids as numbers ("9" before "10").
+func lessNumericID(a, b string) bool {
+	ai, errA := strconv.Atoi(a)
+	bi, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return ai < bi
+	}
+	return a < b
+}
+
+// startVoteRingScanner runs the ring scan forever.
+func startVoteRingScanner() {
+	go func() {
+		t := time.NewTicker(voteRingScanInterval)
+		defer t.Stop()
+		for range t.C {
+			if err := runVoteRingScan(context.Background()); err != nil {
+				log.Printf("vote ring scan: %v", err)
+			}
+		}
+	}()
+}
+
+// runVoteRingScan finds rings and flags their members.
+func runVoteRingScan(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	pairs, err := suspiciousVotePairs(ctx)
+	if err != nil {
+		return err
+	}
+	rings := findVoteRings(pairs, voteRingMinSize)
+	flagged := 0
+	for _, ring := range rings {
+		n, err := flagVoteRing(ctx, ring)
+		if err != nil {
+			log.Printf("vote ring scan: flagging ring-%s: %v", ring[0], err)
+			continue
+		}
+		flagged += n
+	}
+	if flagged > 0 {
+		log.Printf("vote ring scan: flagged %d account(s) across %d ring(s).", flagged, len(rings))
+	}
+	return nil
+}
+
+// suspiciousVotePairs lists connected pairs of voters who back the same side
+// far more often than chance, over the scan window.
+func suspiciousVotePairs(ctx context.Context) ([]votePair, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH recent AS (
+			SELECT challenge_id, response_id, voter_id
+			FROM challenge_votes
+			WHERE created_at > NOW() - ($1)::interval
+		),
+		per_voter AS (
+			SELECT voter_id, COUNT(*) AS n FROM recent GROUP BY voter_id
+		),
+		pairs AS (
+			SELECT a.voter_id AS a, b.voter_id AS b, COUNT(*) AS together
+			FROM recent a
+			JOIN recent b
+			  ON b.challenge_id = a.challenge_id
+			 AND b.response_id IS NOT DISTINCT FROM a.response_id
+			 AND b.voter_id > a.voter_id
+			GROUP BY a.voter_id, b.voter_id
+			HAVING COUNT(*) >= $2
+		)
+		SELECT CAST(p.a AS TEXT), CAST(p.b AS TEXT), p.together
+		FROM pairs p
+		JOIN per_voter va ON va.voter_id = p.a
+		JOIN per_voter vb ON vb.voter_id = p.b
+		WHERE p.together >= $3 * LEAST(va.n, vb.n)
+		  AND (EXISTS (SELECT 1 FROM follows f
+		                WHERE (f.follower_id = p.a AND f.following_id = p.b)
+		                   OR (f.follower_id = p.b AND f.following_id = p.a))
+		    OR EXISTS (SELECT 1 FROM user_similarities s
+		                WHERE s.user_id = CAST(p.a AS TEXT)
+		                  AND s.similar_user_id = CAST(p.b AS TEXT)
+		                  AND s.similarity_score >= $4))`,
+		fmt.Sprintf("%d seconds", int(voteRingWindow.Seconds())),
+		voteRingMinCoVotes, voteRingMinAgreement, voteRingSimilarity)
+	if err != nil {
+		return nil, fmt.Errorf("pairing voters: %w", err)
+	}
+	defer rows.Close()
+
+	var out []votePair
+	for rows.Next() {
+		var p votePair
+		if rows.Scan(&p.a, &p.b, &p.together) == nil {
+			out = append(out, p)
+		}
+	}
+	return out, rows.Err()
+}
+
+// flagVoteRing records a ring's members and re-weights the votes the newly
+// flagged ones have on battles that are still live. Returns how many members
+// were newly flagged. Members already flagged keep their original ring and are
+// not penalised twice.
+func flagVoteRing(ctx context.Context, members []string) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO vote_ring_members (user_id, ring_id, ring_size)
+		SELECT u, $2, $3 FROM unnest(CAST($1 AS INT[])) AS u
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING CAST(user_id AS TEXT)`,
+		pq.Array(members), "ring-"+members[0], len(members))
+	if err != nil {
+		return 0, fmt.Errorf("recording ring: %w", err)
+	}
+	var fresh []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			fresh = append(fresh, id)
+		}
+	}
+	rows.Close()
+	if len(fresh) == 0 {
+		return 0, tx.Commit()
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE challenge_votes cv
+		   SET weight = GREATEST($2, cv.weight * $3)
+		  FROM challenges c
+		 WHERE c.id = cv.challenge_id
+		   AND c.resolved_at IS NULL
+		   AND cv.voter_id = ANY(CAST($1 AS INT[]))`,
+		pq.Array(fresh), voteMinWeight, voteRingPenalty); err != nil {
+		return 0, fmt.Errorf("re-weighting live votes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if metricVoteIntegrity != nil {
+		metricVoteIntegrity.WithLabelValues("ring_flagged").Add(float64(len(fresh)))
+	}
+	return len(fresh), nil
+}
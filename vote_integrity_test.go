@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Every rule here either refuses somebody's vote or counts it for less, so
+// each one is pinned on its own: what passes, what does not, and where the
+// edges are.
+
+// ── Watching before voting ──────────────────────────────────────────────────
+
+func TestWatchedEnough(t *testing.T) {
+	cases := []struct {
+		name   string
+		dwell  map[string]int
+		chosen string
+		want   bool
+	}{
+		{"both watched", map[string]int{"challenge": 5000, "30": 4000}, "30", true},
+		{"only the pick", map[string]int{"30": 9000}, "30", false},
+		{"only the other side", map[string]int{"challenge": 9000}, "30", false},
+		{"pick too brief", map[string]int{"challenge": 9000, "30": voteMinDwellMs - 1}, "30", false},
+		{"exactly the minimum", map[string]int{"challenge": voteMinDwellMs, "30": voteMinDwellMs}, "challenge", true},
+		{"another response counts as the other side", map[string]int{"31": 4000, "30": 4000}, "30", true},
+		{"nothing at all", nil, "challenge", false},
+	}
+	for _, c := range cases {
+		if got := watchedEnough(c.dwell, c.chosen); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// ── What a vote is worth ────────────────────────────────────────────────────
+
+func TestVoteWeight(t *testing.T) {
+	if w := voteWeight(0, false, 1, false); w != 1 {
+		t.Errorf("an established account of neutral engagement should be one full vote, got %.3f", w)
+	}
+	if w := voteWeight(0, true, 1, false); w != voteNewAccountFloor {
+		t.Errorf("a brand-new account should count %.2f, got %.3f", voteNewAccountFloor, w)
+	}
+	if w := voteWeight(voteNewAccountDays, true, 1, false); w != 1 {
+		t.Errorf("an account voteNewAccountDays old should count in full, got %.3f", w)
+	}
+	half := voteWeight(voteNewAccountDays/2, true, 1, false)
+	if half <= voteNewAccountFloor || half >= 1 {
+		t.Errorf("halfway through the ramp should be between the floor and 1, got %.3f", half)
+	}
+	if w := voteWeight(0, false, engQualityMax, false); w != 1 {
+		t.Errorf("engagement must never make one vote worth more than one, got %.3f", w)
+	}
+	if w := voteWeight(0, false, 0.5, false); w != 0.5 {
+		t.Errorf("low engagement should scale the vote, got %.3f", w)
+	}
+	if w := voteWeight(0, false, 1, true); w != voteRingPenalty {
+		t.Errorf("a ring member should count %.2f, got %.3f", voteRingPenalty, w)
+	}
+	if w := voteWeight(0, true, engQualityMin, true); w != voteMinWeight {
+		t.Errorf("the worst case should bottom out at voteMinWeight, got %.3f", w)
+	}
+}
+
+// ── Rings ───────────────────────────────────────────────────────────────────
+
+func TestFindVoteRings(t *testing.T) {
+	pairs := []votePair{
+		// A chain 1–2–3–10: one ring of four, even though 1 and 10 never
+		// paired directly.
+		{a: "1", b: "2"}, {a: "2", b: "3"}, {a: "3", b: "10"},
+		// Two friends who agree: not a ring.
+		{a: "20", b: "21"},
+		// A triangle: a ring of three.
+		{a: "30", b: "31"}, {a: "31", b: "32"}, {a: "30", b: "32"},
+	}
+	got := findVoteRings(pairs, voteRingMinSize)
+	want := [][]string{{"1", "2", "3", "10"}, {"30", "31", "32"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := findVoteRings(nil, voteRingMinSize); len(got) != 0 {
+		t.Errorf("no pairs should find no rings, got %v", got)
+	}
+}
+
+// ── Screening in CastVote ───────────────────────────────────────────────────
+
+func TestCastVote_BattlersCannotVoteInTheirOwnBattle(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(`FROM users u`).WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"age", "ring"}).AddRow(nil, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR SHARE`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"creator_id", "status", "resolved"}).
+			AddRow(1, "active", false))
+	// Voter 2 answered this challenge.
+	mock.ExpectQuery(`responder_id = \$2`).WithArgs(7, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"participant"}).AddRow(true))
+	mock.ExpectRollback()
+
+	_, err := CastVote(ChallengeVotePayload{ChallengeID: "7", ResponseID: creatorVoteSide, VoterID: "2"})
+	if !errors.Is(err, errVoteByParticipant) {
+		t.Errorf("got %v, want errVoteByParticipant", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCastVote_OneChangeOfMind(t *testing.T) {
+	mock, cleanup := withMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(`FROM users u`).WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"age", "ring"}).AddRow(nil, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR SHARE`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"creator_id", "status", "resolved"}).
+			AddRow(1, "active", false))
+	mock.ExpectQuery(`responder_id = \$2`).WithArgs(7, 3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"participant"}).AddRow(false))
+	mock.ExpectQuery(`FROM watch_events`).WithArgs(3, 7, challengeSideKey).
+		WillReturnRows(sqlmock.NewRows([]string{"side", "ms"}).
+			AddRow(challengeSideKey, 8000).AddRow("30", 8000))
+	// Already voted for response 30, and already changed once.
+	mock.ExpectQuery(`FROM challenge_votes\s+WHERE challenge_id = \$1 AND voter_id = \$2 FOR UPDATE`).
+		WithArgs(7, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"response_id", "changes"}).AddRow(30, voteMaxChanges))
+	mock.ExpectRollback()
+
+	_, err := CastVote(ChallengeVotePayload{ChallengeID: "7", ResponseID: creatorVoteSide, VoterID: "3"})
+	if !errors.Is(err, errVoteLocked) {
+		t.Errorf("got %v, want errVoteLocked", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
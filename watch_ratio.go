@@ -104,28 +104,19 @@ func wrEnsureLoaded() {
 // gave every typical short-form item a persistent NEGATIVE bonus, because the
 // model's mean output equals the true population mean, which is well below 0.5.
 func wrPredictBonus(cohort Cohort, breakdown map[string]float64) float64 {
-	wrEnsureLoaded()
-	// Hold the RLock through ALL reads of m (Bias, Weights map, MeanRatio,
-	// Samples). The old code released the lock and then read those, racing
-	// wrObserve's writes under the write lock — a concurrent map read/write that
-	// can panic. The body here is a few map reads + arithmetic, so the read lock
-	// is held only briefly and still allows concurrent readers.
-	watchRatio.mu.RLock()
-	m, ok := watchRatio.byCoh[cohort]
-	if !ok || m == nil || m.Samples < wrMinSamples {
-		watchRatio.mu.RUnlock()
+	pred, center, samples, ok := wrOnlinePredict(cohort, breakdown)
+	// A live offline-trained artifact joins in or takes over here; see
+	// offline_models.go.
+	pred, center, samples, ok = offlineWatchRatioOverride(cohort, breakdown, pred, center, samples, ok)
+	if !ok {
 		return 0
 	}
-	z := m.Bias
-	for _, k := range ltrFeatureKeys {
-		if v, ok := breakdown[k]; ok {
-			z += m.Weights[k] * v
-		}
-	}
-	center := m.MeanRatio
-	samples := m.Samples
-	watchRatio.mu.RUnlock()
-	pred := 1.0 / (1.0 + math.Exp(-z))
+	return wrBonusFromPrediction(pred, center, samples)
+}
+
+// wrBonusFromPrediction maps a predicted watch ratio, read against the cohort
+// mean it is centred on, to the bounded bonus.
+func wrBonusFromPrediction(pred, center float64, samples int) float64 {
 	if center <= 0 || center >= 1 {
 		// Only the impossible/unset boundary values (e.g. a pre-migration model
 		// with MeanRatio==0) fall back to neutral. A GENUINE low-but-nonzero mean
@@ -162,6 +153,41 @@ func wrPredictBonus(cohort Cohort, breakdown map[string]float64) float64 {
 	return wrMaxBonus * learnedGainByVolume(samples, wrMinSamples) * delta
 }
 
+// wrOnlinePredict is the online model's predicted watch ratio for the
+// breakdown, with the cohort mean it is centred on and its sample count.
+// ok is false until the cohort is past wrMinSamples.
+func wrOnlinePredict(cohort Cohort, breakdown map[string]float64) (pred, center float64, samples int, ok bool) {
+	wrEnsureLoaded()
+	// Hold the RLock through ALL reads of m (Bias, Weights map, MeanRatio,
+	// Samples). The old code released the lock and then read those, racing
+	// wrObserve's writes under the write lock — a concurrent map read/write that
+	// can panic. The body here is a few map reads + arithmetic, so the read lock
+	// is held only briefly and still allows concurrent readers.
+	watchRatio.mu.RLock()
+	m, exists := watchRatio.byCoh[cohort]
+	if !exists || m == nil || m.Samples < wrMinSamples {
+		watchRatio.mu.RUnlock()
+		return 0, 0, 0, false
+	}
+	pred = m.predict(breakdown)
+	center = m.MeanRatio
+	samples = m.Samples
+	watchRatio.mu.RUnlock()
+	return pred, center, samples, true
+}
+
+// predict is the model's watch ratio for a breakdown. The caller holds
+// whatever lock guards m.
+func (m *wrModel) predict(breakdown map[string]float64) float64 {
+	z := m.Bias
+	for _, k := range ltrFeatureKeys {
+		if v, ok := breakdown[k]; ok {
+			z += m.Weights[k] * v
+		}
+	}
+	return 1.0 / (1.0 + math.Exp(-z))
+}
+
 // wrObserve records a (breakdown, watch_ratio) sample and SGD-updates the
 // per-cohort weights. Watch ratio must be in [0, 1]; out-of-range values
 // are clamped.
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// WebAuthn (passkey) verification backed by the stdlib, for the same
+// reasons totp.go is: the relying-party half of WebAuthn is a fixed,
+// well-specified set of checks over a small binary format, and
+// everything it needs — SHA-256, ECDSA P-256, Ed25519, RSA PKCS#1 —
+// already ships with Go.
+//
+// What this file does, per ceremony (W3C WebAuthn Level 2, §7):
+//
+//   - Registration ("webauthn.create"): check clientDataJSON's type,
+//     challenge and origin; decode the CBOR attestation object; check
+//     the RP ID hash and the user-present / user-verified flags; pull
+//     out the credential id and its COSE public key.
+//   - Authentication ("webauthn.get"): the same clientData checks, the
+//     same flag checks, the signature over authenticatorData ‖
+//     SHA-256(clientDataJSON), and the signature counter.
+//
+// What it deliberately does NOT do is verify attestation statements.
+// We ask for attestation "none" — we have no policy about which
+// authenticator makes a user's passkey, so there is nothing a verified
+// attestation chain would let us decide. Whatever statement comes back
+// is ignored, which the spec allows for "none" conveyance. The larger
+// libraries are mostly that machinery (metadata service, certificate
+// chains, TPM and Android formats), which is why we don't need them.
+//
+// The CBOR decoder below handles what CTAP2 authenticators emit —
+// definite lengths, integer/byte/text/array/map/simple values — and
+// rejects the rest rather than guessing.
+
+// Relying-party configuration. The RP ID is the registrable domain the
+// passkeys are bound to (changing it orphans every passkey already
+// registered); the origins are every origin a ceremony may come from —
+// the web app's, plus "android:apk-key-hash:…" for the Android app.
+//
+//	WEBAUTHN_RP_ID     default "localhost"
+//	WEBAUTHN_RP_NAME   default "devf"
+//	WEBAUTHN_ORIGINS   comma-separated, default "http://localhost:8080"
+type relyingParty struct {
+	ID      string
+	Name    string
+	Origins map[string]bool
+}
+
+func webauthnRP() relyingParty {
+	rp := relyingParty{
+		ID:      getEnv("WEBAUTHN_RP_ID", "localhost"),
+		Name:    getEnv("WEBAUTHN_RP_NAME", "devf"),
+		Origins: map[string]bool{},
+	}
+	origins := os.Getenv("WEBAUTHN_ORIGINS")
+	if origins == "" {
+		origins = "http://localhost:8080"
+	}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			rp.Origins[o] = true
+		}
+	}
+	return rp
+}
+
+// COSE algorithm identifiers we accept, in order of preference. This is
+// also the pubKeyCredParams list sent at registration.
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+var webauthnAlgs = []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+// authenticatorData flag bits.
+const (
+	authFlagUserPresent    = 0x01
+	authFlagUserVerified   = 0x04
+	authFlagBackupEligible = 0x08
+	authFlagAttestedData   = 0x40
+)
+
+var (
+	errWebAuthnClientData = errors.New("webauthn: client data does not match this ceremony")
+	errWebAuthnOrigin     = errors.New("webauthn: origin not allowed")
+	errWebAuthnRPID       = errors.New("webauthn: credential belongs to another site")
+	errWebAuthnFlags      = errors.New("webauthn: user presence or verification missing")
+	errWebAuthnMalformed  = errors.New("webauthn: malformed authenticator response")
+	errWebAuthnAlg        = errors.New("webauthn: unsupported public key algorithm")
+	errWebAuthnSignature  = errors.New("webauthn: signature does not verify")
+	errWebAuthnCounter    = errors.New("webauthn: signature counter went backwards (cloned authenticator?)")
+)
+
+// webauthnRejected reports whether err is one of the above: the client's
+// response was refused, as opposed to something failing on our side.
+func webauthnRejected(err error) bool {
+	for _, e := range []error{errWebAuthnClientData, errWebAuthnOrigin, errWebAuthnRPID, errWebAuthnFlags,
+		errWebAuthnMalformed, errWebAuthnAlg, errWebAuthnSignature, errWebAuthnCounter} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// b64url is the unpadded base64url every WebAuthn binary field travels in.
+var b64url = base64.RawURLEncoding
+
+// decodeB64URL accepts base64url with or without padding; browsers and
+// client libraries disagree about it.
+func decodeB64URL(s string) ([]byte, error) {
+	return b64url.DecodeString(strings.TrimRight(s, "="))
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Client data
+// ─────────────────────────────────────────────────────────────────────────────
+
+type webauthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func checkClientData(rp relyingParty, raw []byte, wantType string, challenge []byte) error {
+	var cd webauthnClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return errWebAuthnMalformed
+	}
+	got, err := decodeB64URL(cd.Challenge)
+	if err != nil || cd.Type != wantType || !bytes.Equal(got, challenge) {
+		return errWebAuthnClientData
+	}
+	if !rp.Origins[cd.Origin] || cd.CrossOrigin {
+		return errWebAuthnOrigin
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Authenticator data
+// ─────────────────────────────────────────────────────────────────────────────
+
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// Present only at registration (authFlagAttestedData).
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key, as sent
+}
+
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errWebAuthnMalformed
+	}
+	ad := &authenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if ad.Flags&authFlagAttestedData == 0 {
+		return ad, nil
+	}
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, errWebAuthnMalformed
+	}
+	ad.AAGUID = rest[:16]
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || n > 1023 || len(rest) < n {
+		return nil, errWebAuthnMalformed
+	}
+	ad.CredentialID = rest[:n]
+	rest = rest[n:]
+	// The COSE key is one CBOR item; extensions, if any, follow it.
+	_, after, err := cborDecode(rest)
+	if err != nil {
+		return nil, errWebAuthnMalformed
+	}
+	ad.PublicKey = rest[:len(rest)-len(after)]
+	return ad, nil
+}
+
+func (ad *authenticatorData) check(rp relyingParty, requireUV bool) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.RPIDHash, want[:]) {
+		return errWebAuthnRPID
+	}
+	if ad.Flags&authFlagUserPresent == 0 || (requireUV && ad.Flags&authFlagUserVerified == 0) {
+		return errWebAuthnFlags
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Ceremonies
+// ─────────────────────────────────────────────────────────────────────────────
+
+// webauthnCredential is what registration yields and what is stored.
+type webauthnCredential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	Alg            int64
+	SignCount      uint32
+	AAGUID         []byte
+	BackupEligible bool
+}
+
+// verifyRegistration checks a navigator.credentials.create() response
+// against the challenge we issued.
+func verifyRegistration(rp relyingParty, challenge, clientDataJSON, attestationObject []byte, requireUV bool) (*webauthnCredential, error) {
+	if err := checkClientData(rp, clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	obj, _, err := cborDecode(attestationObject)
+	if err != nil {
+		return nil, errWebAuthnMalformed
+	}
+	m, ok := obj.(map[any]any)
+	if !ok {
+		return nil, errWebAuthnMalformed
+	}
+	raw, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, errWebAuthnMalformed
+	}
+	ad, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := ad.check(rp, requireUV); err != nil {
+		return nil, err
+	}
+	if ad.CredentialID == nil {
+		return nil, errWebAuthnMalformed
+	}
+	key, err := parseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &webauthnCredential{
+		ID:             ad.CredentialID,
+		PublicKey:      ad.PublicKey,
+		Alg:            key.alg,
+		SignCount:      ad.SignCount,
+		AAGUID:         ad.AAGUID,
+		BackupEligible: ad.Flags&authFlagBackupEligible != 0,
+	}, nil
+}
+
+// verifyAssertion checks a navigator.credentials.get() response made
+// with a stored credential, and returns the authenticator's new signature
+// counter.
+//
+// Counter rule (§7.2 step 21): if either the stored or the new counter is
+// non-zero, the new one must be strictly greater. Synced passkeys (iCloud
+// Keychain, Google Password Manager) always report 0 and so never trip
+// this; a hardware key that goes backwards has been cloned or replayed.
+func verifyAssertion(rp relyingParty, challenge []byte, cred webauthnCredential, clientDataJSON, authData, signature []byte, requireUV bool) (uint32, error) {
+	if err := checkClientData(rp, clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+	if err := ad.check(rp, requireUV); err != nil {
+		return 0, err
+	}
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), cdHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return 0, err
+	}
+	if (ad.SignCount != 0 || cred.SignCount != 0) && ad.SignCount <= cred.SignCount {
+		return 0, errWebAuthnCounter
+	}
+	return ad.SignCount, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// COSE keys (RFC 9053)
+// ─────────────────────────────────────────────────────────────────────────────
+
+type cosePublicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parseCOSEKey(b []byte) (*cosePublicKey, error) {
+	v, rest, err := cborDecode(b)
+	if err != nil || len(rest) != 0 {
+		return nil, errWebAuthnMalformed
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errWebAuthnMalformed
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == coseAlgES256: // EC2, P-256
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errWebAuthnMalformed
+		}
+		pk := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, errWebAuthnMalformed
+		}
+		return &cosePublicKey{alg: alg, key: pk}, nil
+	case kty == 1 && alg == coseAlgEdDSA: // OKP, Ed25519
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errWebAuthnMalformed
+		}
+		return &cosePublicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errWebAuthnMalformed
+		}
+		exp := 0
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+		return &cosePublicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+	return nil, errWebAuthnAlg
+}
+
+func (k *cosePublicKey) verify(data, sig []byte) error {
+	ok := false
+	switch pk := k.key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(pk, h[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pk, data, sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(pk, crypto.SHA256, h[:], sig) == nil
+	}
+	if !ok {
+		return errWebAuthnSignature
+	}
+	return nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// CBOR (RFC 8949), decode only
+// ─────────────────────────────────────────────────────────────────────────────
+
+// cborMaxDepth bounds nesting; nothing a CTAP2 authenticator sends comes
+// close, and it keeps a hostile payload from recursing deeply.
+const cborMaxDepth = 16
+
+// cborDecode decodes one item from b and returns it with the bytes after
+// it. Unsigned and negative integers become int64, byte strings []byte,
+// text strings string, arrays []any and maps map[any]any.
+func cborDecode(b []byte) (any, []byte, error) {
+	return cborDecodeItem(b, 0)
+}
+
+func cborDecodeItem(b []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("cbor: nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end of input")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of input")
+		}
+		for _, c := range b[:n] {
+			arg = arg<<8 | uint64(c)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: indefinite or reserved length not supported")
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of input")
+		}
+		s := b[:arg]
+		if major == 3 {
+			return string(s), b[arg:], nil
+		}
+		return s, b[arg:], nil
+	case 4:
+		if arg > uint64(len(b)) { // every item is at least one byte
+			return nil, nil, fmt.Errorf("cbor: unexpected end of input")
+		}
+		out := make([]any, 0, arg)
+		for range arg {
+			var v any
+			var err error
+			if v, b, err = cborDecodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			out = append(out, v)
+		}
+		return out, b, nil
+	case 5:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of input")
+		}
+		out := make(map[any]any, arg)
+		for range arg {
+			var k, v any
+			var err error
+			if k, b, err = cborDecodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", k)
+			}
+			if v, b, err = cborDecodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			out[k] = v
+		}
+		return out, b, nil
+	case 6: // tag: keep the tagged item, drop the tag
+		return cborDecodeItem(b, depth+1)
+	case 7:
+		switch {
+		case info == 20:
+			return false, b, nil
+		case info == 21:
+			return true, b, nil
+		case info == 22 || info == 23:
+			return nil, b, nil
+		case info == 26:
+			return float64(math.Float32frombits(uint32(arg))), b, nil
+		case info == 27:
+			return math.Float64frombits(arg), b, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported item (major %d, info %d)", major, info)
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
@@ -17,9 +22,9 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// wsPresenceTTL bounds how long a crashed replica's ghost presence
-	// survives in Redis. Refreshed on every ping tick (54s), so a live
-	// connection never expires; a dead replica's entries clear within
-	// ~2 minutes.
+	// survives in Redis. Each connection refreshes its own entry on every
+	// ping tick (54s), so a live connection never lapses; a dead
+	// replica's entries lapse within ~2 minutes.
 	wsPresenceTTL = 2 * time.Minute
 
 	// wsRelayChannel carries cross-replica deliveries: a user connected
@@ -40,37 +45,173 @@ var upgrader = websocket.Upgrader{
 // from separate goroutines, which could previously interleave and
 // panic ("concurrent write to websocket connection"). Every write MUST
 // go through writeMessage.
+//
+// id names this one connection. A user is a SET of connections — phone
+// and tablet, or a reconnect that lands before the old socket has
+// noticed it is dead — and the id is how one of them is removed without
+// disturbing the others. Random, so it is unique across replicas too.
+//
+// The replay fields (see ws_events.go) are guarded by mu too. While a
+// reconnect is being replayed, live events are held in pending rather than
+// written, so they cannot overtake the replay; when it finishes they go out
+// in arrival order, minus any the replay already covered.
 type wsClient struct {
+	id   string
 	conn *websocket.Conn
 	mu   sync.Mutex
+
+	replaying  bool
+	replayedTo int64
+	pending    []wsPendingFrame
+}
+
+type wsPendingFrame struct {
+	seq   int64
+	frame []byte
 }
 
 func (c *wsClient) writeMessage(msgType int, data []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.write(msgType, data)
+}
+
+// write does the write; the caller holds mu.
+func (c *wsClient) write(msgType int, data []byte) error {
 	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 	return c.conn.WriteMessage(msgType, data)
 }
 
-var clients = make(map[string]*wsClient)
+// writeEvent writes a live event frame, numbered (seq > 0) or not. Held
+// back while a replay is running; skipped if the replay already sent it.
+func (c *wsClient) writeEvent(seq int64, frame []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.replaying {
+		c.pending = append(c.pending, wsPendingFrame{seq: seq, frame: frame})
+		return nil
+	}
+	if seq > 0 && seq <= c.replayedTo {
+		return nil
+	}
+	return c.write(websocket.TextMessage, frame)
+}
+
+// finishReplay ends a replay that got as far as seq last, and sends what
+// arrived live meanwhile.
+func (c *wsClient) finishReplay(last int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pending {
+		if p.seq > 0 && p.seq <= last {
+			continue
+		}
+		if err := c.write(websocket.TextMessage, p.frame); err != nil {
+			break
+		}
+	}
+	c.pending = nil
+	c.replaying = false
+	c.replayedTo = last
+}
+
+// newWSConnID returns a fresh connection id.
+func newWSConnID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// clients is username → connection id → connection, for this replica.
+//
+// It used to be username → connection. A second connection replaced the
+// first in the map, so every delivery reached one device only — whichever
+// connected last — and the one left out never heard it had been dropped.
+var clients = make(map[string]map[string]*wsClient)
 var clientsMu sync.Mutex
 
-// wsPresenceKey is the per-user Redis presence marker. A plain key with
-// a TTL (not a set member) so expiry is native and per-user.
+// wsRegister adds a connection to its user's set.
+func wsRegister(username string, c *wsClient) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	conns := clients[username]
+	if conns == nil {
+		conns = make(map[string]*wsClient)
+		clients[username] = conns
+	}
+	conns[c.id] = c
+}
+
+// wsUnregister removes one connection and reports whether it was the
+// user's last one on this replica.
+func wsUnregister(username, connID string) (last bool) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	conns := clients[username]
+	delete(conns, connID)
+	if len(conns) == 0 {
+		delete(clients, username)
+		return true
+	}
+	return false
+}
+
+// wsLocalConns snapshots a user's connections on this replica, so writes
+// happen outside the lock.
+func wsLocalConns(username string) []*wsClient {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	out := make([]*wsClient, 0, len(clients[username]))
+	for _, c := range clients[username] {
+		out = append(out, c)
+	}
+	return out
+}
+
+// wsPresenceKey is the per-user Redis presence marker: a sorted set of the
+// user's live connection ids across every replica, each scored with the
+// unix time it lapses. A member per connection (not one flag per user) so
+// one device disconnecting cannot mark the user offline while another is
+// still up, and per-member expiry so a crashed replica's connections age
+// out on their own.
+//
+// This key was once a plain string. A replica still running the old code
+// during a rolling deploy may have left one behind; wsMarkOnline replaces
+// it, and it would have expired within wsPresenceTTL anyway.
 func wsPresenceKey(username string) string { return "ws:online:" + username }
 
-func wsMarkOnline(username string) {
+func wsMarkOnline(username, connID string) {
 	if rdb == nil || !multiReplica() {
 		return
 	}
-	_ = rdb.Set(rctx, wsPresenceKey(username), "1", wsPresenceTTL).Err()
+	key := wsPresenceKey(username)
+	lapse := float64(time.Now().Add(wsPresenceTTL).Unix())
+	err := rdb.ZAdd(rctx, key, redis.Z{Score: lapse, Member: connID}).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		_ = rdb.Del(rctx, key).Err()
+		err = rdb.ZAdd(rctx, key, redis.Z{Score: lapse, Member: connID}).Err()
+	}
+	if err == nil {
+		_ = rdb.Expire(rctx, key, wsPresenceTTL).Err()
+	}
 }
 
-func wsMarkOffline(username string) {
+func wsMarkOffline(username, connID string) {
 	if rdb == nil || !multiReplica() {
 		return
 	}
-	_ = rdb.Del(rctx, wsPresenceKey(username)).Err()
+	_ = rdb.ZRem(rctx, wsPresenceKey(username), connID).Err()
+}
+
+// wsPresentConns lists a user's live connection ids on any replica.
+func wsPresentConns(username string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	return rdb.ZRangeByScore(rctx, wsPresenceKey(username), &redis.ZRangeBy{
+		Min: "(" + now,
+		Max: "+inf",
+	}).Result()
 }
 
 // WebsocketHandler upgrades the HTTP connection and manages the client lifecycle.
@@ -88,6 +229,9 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 	// param. The token's username must match the path — otherwise a caller could
 	// open a socket as someone else and receive their realtime chat/notifications.
 	claims, err := parseToken(r.URL.Query().Get("token"))
+	if err == nil {
+		err = verifySession(claims)
+	}
 	if err != nil || claims.Username != username {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
@@ -98,31 +242,45 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to upgrade connection for %s: %v", username, err)
 		return
 	}
-	client := &wsClient{conn: conn}
+	client := &wsClient{id: newWSConnID(), conn: conn}
 
-	defer func() {
-		clientsMu.Lock()
-		// Only clear the map entry if it's still OUR connection — a fast
-		// reconnect may have replaced it, and deleting the newcomer's
-		// entry would silently disconnect them from deliveries.
-		if clients[username] == client {
-			delete(clients, username)
-			wsMarkOffline(username)
+	// ?since=<seq> is an app build that keeps an event cursor: it gets the
+	// events it missed replayed (ws_events.go). Without it, the connection
+	// is served as it always was.
+	device := r.URL.Query().Get("device")
+	since, replay := int64(0), false
+	if v := r.URL.Query().Get("since"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			since, replay = n, true
 		}
-		clientsMu.Unlock()
+	}
+	// Hold live events from the moment the connection is registered, so
+	// none can slip in ahead of the replay.
+	client.replaying = replay
+
+	defer func() {
+		// Only this connection goes. The user's other devices stay
+		// connected, and stay online, until they close too.
+		last := wsUnregister(username, client.id)
+		wsMarkOffline(username, client.id)
 		conn.Close()
-		// Update last_seen on disconnect
-		go UpdateUserLastSeen(username)
-		log.Printf("WebSocket for %s disconnected and cleaned up", username)
+		// last_seen means "when they were last here at all", so it is
+		// only worth writing when the last connection here closes.
+		if last {
+			go UpdateUserLastSeen(username)
+		}
+		log.Printf("WebSocket %s for %s disconnected and cleaned up", client.id, username)
 	}()
 
-	clientsMu.Lock()
-	clients[username] = client
-	clientsMu.Unlock()
-	wsMarkOnline(username)
-	log.Printf("WebSocket for %s connected", username)
+	wsRegister(username, client)
+	wsMarkOnline(username, client.id)
+	log.Printf("WebSocket %s for %s connected", client.id, username)
 
-	go SendStoredNotifications(username)
+	if replay {
+		go wsReplay(client, username, device, since)
+	} else {
+		go SendStoredNotifications(username, client.id)
+	}
 
 	conn.SetReadDeadline(time.Now().Add(pongWait))
 	conn.SetPongHandler(func(string) error {
@@ -136,11 +294,11 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 		for {
 			<-ticker.C
 			if err := client.writeMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Ping failed for %s, connection will be closed: %v", username, err)
+				log.Printf("Ping failed for %s (%s), connection will be closed: %v", username, client.id, err)
 				return
 			}
-			// Keep the cross-replica presence marker alive.
-			wsMarkOnline(username)
+			// Keep this connection's cross-replica presence alive.
+			wsMarkOnline(username, client.id)
 		}
 	}()
 
@@ -152,57 +310,103 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			break
 		}
-		log.Printf("Received message from %s: %s", username, message)
+		wsHandleClientFrame(username, claims.Subject, device, message)
 	}
 }
 
-// wsSendLocal writes a text payload to a user connected to THIS replica.
-// Returns false when the user isn't connected here or the write failed.
-func wsSendLocal(username string, data []byte) bool {
-	clientsMu.Lock()
-	client, ok := clients[username]
-	clientsMu.Unlock()
-	if !ok {
-		return false
+// wsSendLocal writes a text payload to every connection a user has on THIS
+// replica — or, when only is non-empty, to just those connections. Returns
+// how many writes succeeded. For frames outside the event log; see
+// wsWriteLocal for the numbered kind.
+func wsSendLocal(username string, data []byte, only ...string) int {
+	return wsWriteLocal(username, 0, data, only...)
+}
+
+// wsWriteLocal writes an event frame (seq 0 for an unnumbered one) to a
+// user's connections on this replica, optionally only the named ones.
+func wsWriteLocal(username string, seq int64, data []byte, only ...string) int {
+	var want map[string]bool
+	if len(only) > 0 {
+		want = make(map[string]bool, len(only))
+		for _, id := range only {
+			want[id] = true
+		}
 	}
-	if err := client.writeMessage(websocket.TextMessage, data); err != nil {
-		log.Printf("ws write failed for %s: %v", username, err)
-		return false
+	sent := 0
+	for _, c := range wsLocalConns(username) {
+		if want != nil && !want[c.id] {
+			continue
+		}
+		if err := c.writeEvent(seq, data); err != nil {
+			log.Printf("ws write failed for %s (%s): %v", username, c.id, err)
+			continue
+		}
+		sent++
 	}
-	return true
+	return sent
 }
 
 // wsRelayEnvelope is the message shape published on wsRelayChannel.
+//
+// Conns names the connections the publish is for. Every replica receives
+// every publish; each one writes to those of the named connections it
+// holds. Without the list, the publishing replica — which has already
+// written to its own connections — would write to them a second time.
+// Seq is the event's number in the user's log (0 for frames outside it), so
+// a receiving connection in the middle of a replay can tell whether the
+// replay already sent it.
 type wsRelayEnvelope struct {
 	Username string          `json:"u"`
+	Conns    []string        `json:"c,omitempty"`
+	Seq      int64           `json:"s,omitempty"`
 	Payload  json.RawMessage `json:"p"`
 }
 
-// wsDeliver sends a text payload to a user wherever they're connected:
-// this replica directly, or — in multi-replica mode — any replica via
-// Redis pub/sub. Returns true when the payload was delivered locally or
-// handed to the relay while the user shows presence somewhere; false
-// means "treat as offline" (callers fall back to the stored-notification
-// queue or simply skip, exactly as before).
+// wsDeliver sends a text payload to every device a user has connected:
+// this replica's connections directly, and — in multi-replica mode — the
+// rest via Redis pub/sub. Returns true when the payload reached at least
+// one connection here or was handed to the relay for a connection that
+// shows presence elsewhere; false means "treat as offline" (callers fall
+// back to the stored-notification queue or simply skip, exactly as before).
+//
+// Nothing sent this way is logged or replayed; wsDeliverEvent is the
+// logged kind.
 func wsDeliver(username string, data []byte) bool {
-	if wsSendLocal(username, data) {
-		return true
-	}
+	return wsFanOut(username, 0, data)
+}
+
+// wsFanOut is wsDeliver for a frame that may carry a log number.
+func wsFanOut(username string, seq int64, data []byte) bool {
+	delivered := wsWriteLocal(username, seq, data) > 0
 	if !multiReplica() || rdb == nil {
-		return false
+		return delivered
 	}
-	// Cross-replica: only relay when the user shows presence somewhere —
-	// publishing to nobody is harmless but returning true for a truly
+	// Cross-replica: relay only to live connections that are not ours —
+	// publishing to nobody is harmless, but returning true for a truly
 	// offline user would skip the offline-queue fallback.
-	if n, err := rdb.Exists(rctx, wsPresenceKey(username)).Result(); err != nil || n == 0 {
-		return false
+	present, err := wsPresentConns(username)
+	if err != nil || len(present) == 0 {
+		return delivered
+	}
+	local := make(map[string]bool)
+	for _, c := range wsLocalConns(username) {
+		local[c.id] = true
 	}
-	env, err := json.Marshal(wsRelayEnvelope{Username: username, Payload: data})
+	var remote []string
+	for _, id := range present {
+		if !local[id] {
+			remote = append(remote, id)
+		}
+	}
+	if len(remote) == 0 {
+		return delivered
+	}
+	env, err := json.Marshal(wsRelayEnvelope{Username: username, Conns: remote, Seq: seq, Payload: data})
 	if err != nil {
-		return false
+		return delivered
 	}
 	if err := rdb.Publish(rctx, wsRelayChannel, env).Err(); err != nil {
-		return false
+		return delivered
 	}
 	return true
 }
@@ -217,28 +421,31 @@ func startWSRelay() {
 		sub := rdb.Subscribe(rctx, wsRelayChannel)
 		defer sub.Close()
 		for msg := range sub.Channel() {
-			var env wsRelayEnvelope
-			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
-				continue
-			}
-			// Deliver only if the user is on THIS replica; other
-			// replicas got the same publish and check their own maps.
-			wsSendLocal(env.Username, env.Payload)
+			wsHandleRelay([]byte(msg.Payload))
 		}
 	}()
 }
 
-// IsUserOnline checks if a user is currently connected — to this replica
+// wsHandleRelay delivers one relayed payload to whichever of its named
+// connections live on this replica; other replicas got the same publish
+// and check their own. An envelope with no list (from a replica still on
+// the old code) goes to all of the user's connections here.
+func wsHandleRelay(raw []byte) {
+	var env wsRelayEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return
+	}
+	wsWriteLocal(env.Username, env.Seq, env.Payload, env.Conns...)
+}
+
+// IsUserOnline checks if a user has any live connection — to this replica
 // always, and to any replica when multi-replica presence is on.
 func IsUserOnline(username string) bool {
-	clientsMu.Lock()
-	_, ok := clients[username]
-	clientsMu.Unlock()
-	if ok {
+	if len(wsLocalConns(username)) > 0 {
 		return true
 	}
 	if multiReplica() && rdb != nil {
-		if n, err := rdb.Exists(rctx, wsPresenceKey(username)).Result(); err == nil && n > 0 {
+		if conns, err := wsPresentConns(username); err == nil && len(conns) > 0 {
 			return true
 		}
 	}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// A user is a set of connections. These pin the three things that used to
+// go wrong when they were one: a second device replacing the first, one
+// device leaving taking the user offline, and cross-replica delivery
+// reaching only one device (or the same device twice).
+
+// withMultiReplica turns MULTI_REPLICA on for one test.
+func withMultiReplica(t *testing.T) {
+	t.Helper()
+	multiReplicaOnce.Do(func() {})
+	prev := multiReplicaVal
+	multiReplicaVal = true
+	t.Cleanup(func() { multiReplicaVal = prev })
+}
+
+// dialPair opens n real sockets for username through a minimal server that
+// registers each one the way WebsocketHandler does, and returns the client
+// ends.
+func dialPair(t *testing.T, username string, n int) []*websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := &wsClient{id: newWSConnID(), conn: conn}
+		wsRegister(username, c)
+		defer func() {
+			wsUnregister(username, c.id)
+			conn.Close()
+		}()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	var out []*websocket.Conn
+	for i := 0; i < n; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		out = append(out, conn)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(wsLocalConns(username)) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d connections registered", len(wsLocalConns(username)), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return out
+}
+
+func TestWSSecondDeviceDoesNotReplaceFirst(t *testing.T) {
+	phone := &wsClient{id: "phone"}
+	tablet := &wsClient{id: "tablet"}
+	wsRegister("ws-alice", phone)
+	wsRegister("ws-alice", tablet)
+
+	if got := len(wsLocalConns("ws-alice")); got != 2 {
+		t.Fatalf("%d connections registered, want 2", got)
+	}
+	if last := wsUnregister("ws-alice", "phone"); last {
+		t.Error("closing the phone reported the tablet gone too")
+	}
+	if !IsUserOnline("ws-alice") {
+		t.Error("user shown offline while the tablet is still connected")
+	}
+	if last := wsUnregister("ws-alice", "tablet"); !last {
+		t.Error("closing the last device was not reported as the last")
+	}
+	if IsUserOnline("ws-alice") {
+		t.Error("user still online with no connections")
+	}
+}
+
+func TestWSDeliverReachesEveryDevice(t *testing.T) {
+	conns := dialPair(t, "ws-bob", 2)
+
+	if !wsDeliver("ws-bob", []byte(`{"type":"ping"}`)) {
+		t.Fatal("wsDeliver reported the user offline")
+	}
+	for i, c := range conns {
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("device %d got nothing: %v", i, err)
+		}
+		if string(msg) != `{"type":"ping"}` {
+			t.Errorf("device %d got %s", i, msg)
+		}
+	}
+}
+
+func TestWSPresenceIsPerConnection(t *testing.T) {
+	resetRedis(t)
+	withMultiReplica(t)
+
+	// A leftover presence flag from the old single-key scheme is replaced,
+	// not an error.
+	mr.Set(wsPresenceKey("ws-carol"), "1")
+
+	wsMarkOnline("ws-carol", "a")
+	wsMarkOnline("ws-carol", "b")
+	wsMarkOffline("ws-carol", "a")
+	if !IsUserOnline("ws-carol") {
+		t.Error("one connection closing elsewhere marked the user offline")
+	}
+	wsMarkOffline("ws-carol", "b")
+	if IsUserOnline("ws-carol") {
+		t.Error("user still online after every connection closed")
+	}
+
+	// A connection whose replica died stops refreshing its entry, and once
+	// the entry's time has passed it no longer counts.
+	stale := float64(time.Now().Add(-time.Second).Unix())
+	if err := rdb.ZAdd(rctx, wsPresenceKey("ws-carol"), redis.Z{Score: stale, Member: "ghost"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if IsUserOnline("ws-carol") {
+		t.Error("a lapsed connection still counts as online")
+	}
+}
+
+func TestWSDeliverRelaysOnlyToOtherReplicasConnections(t *testing.T) {
+	resetRedis(t)
+	withMultiReplica(t)
+
+	conns := dialPair(t, "ws-dave", 1)
+	here := wsLocalConns("ws-dave")[0].id
+	wsMarkOnline("ws-dave", here)
+	wsMarkOnline("ws-dave", "elsewhere")
+
+	sub := rdb.Subscribe(rctx, wsRelayChannel)
+	defer sub.Close()
+	if _, err := sub.Receive(rctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !wsDeliver("ws-dave", []byte(`{"n":1}`)) {
+		t.Fatal("wsDeliver reported the user offline")
+	}
+	conns[0].SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conns[0].ReadMessage(); err != nil {
+		t.Fatalf("local device got nothing: %v", err)
+	}
+
+	select {
+	case msg := <-sub.Channel():
+		var env wsRelayEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(env.Conns, []string{"elsewhere"}) {
+			t.Errorf("relayed to %v, want only the other replica's connection", env.Conns)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing relayed for the connection on the other replica")
+	}
+
+	// The receiving side of that envelope, on this replica, writes to
+	// nothing: "elsewhere" is not here, and the local device already has it.
+	raw, _ := json.Marshal(wsRelayEnvelope{Username: "ws-dave", Conns: []string{"elsewhere"}, Payload: []byte(`{"n":1}`)})
+	wsHandleRelay(raw)
+	conns[0].SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, msg, err := conns[0].ReadMessage(); err == nil {
+		t.Errorf("local device got the relayed copy too: %s", msg)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+// ws_events.go — a per-user event log behind the WebSocket, so a reconnect
+// picks up exactly where it left off.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// WHY
+// ════════════════════════════════════════════════════════════════════════════════
+//
+// Realtime delivery was fire-and-forget: write to the socket if there is one.
+// A phone that drops signal in a lift for twenty seconds misses whatever was
+// written in those twenty seconds. Chat waited for the next REST fetch;
+// notifications only reached the offline list if the user had NO socket at
+// all, so anything written into a socket that was already dead (the server
+// finds out at the next ping, up to a minute later) was simply gone.
+//
+// ════════════════════════════════════════════════════════════════════════════════
+// HOW
+// ════════════════════════════════════════════════════════════════════════════════
+//
+//	log       every chat message and notification for a user is appended to
+//	          ws:events:<username> with the next number from ws:seq:<username>
+//	          before it is delivered. The number goes out in the frame as
+//	          "seq". Append is one Lua script, so numbers land in the log in
+//	          the order they were handed out, even with replicas appending at
+//	          once.
+//	ack       the client sends {"type":"ack","seq":N} for what it has shown.
+//	          Acks are kept per device (?device=<id> on the socket URL).
+//	replay    /ws/{username}?since=N replays everything after N (or after
+//	          that device's ack, whichever is later) before any live event,
+//	          then carries on live. An acked event is never sent to that
+//	          device again, whatever since says.
+//	retention the log keeps the last wsEventLogMax events and nothing older
+//	          than wsEventRetention. A client asking for more than that gets
+//	          "gap": true in the hello frame and refetches over REST.
+//
+// The sequence counter itself never expires: if it restarted at 1, a client
+// holding since=900 would skip the next 900 events.
+//
+// A socket opened without since is an app build from before the log, and is
+// served exactly as before: the stored-notification list on connect, frames
+// that merely carry an extra "seq" field it ignores.
+//
+// Ephemeral frames (next_reel_hint) are not logged — replaying a prefetch hint
+// for a video the user scrolled past an hour ago is worse than useless.
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// wsEventLogMax caps the log per user.
+	wsEventLogMax = 500
+	// wsEventRetention is the longest an event is kept for replay, and how
+	// long a device's ack cursor outlives its last ack.
+	wsEventRetention = 72 * time.Hour
+)
+
+func wsEventLogKey(username string) string { return "ws:events:" + username }
+func wsEventSeqKey(username string) string { return "ws:seq:" + username }
+func wsAckKey(username, device string) string {
+	return "ws:ack:" + username + ":" + device
+}
+
+// wsUnloggedKey marks a user with a stored notification that never made it
+// into the log, so a replay can't stand in for the stored list.
+func wsUnloggedKey(username string) string { return "ws:unlogged:" + username }
+
+// wsAppendScript numbers and stores one event, and trims the log, in one
+// step. Members are "<seq>|<unix ms>|<frame>" — the seq prefix keeps two
+// identical frames distinct, the time lets retention be enforced on a set
+// scored by seq. Trimming by age looks only at the oldest few members each
+// time: every append trims, so the log never falls far behind.
+var wsAppendScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+local now = tonumber(ARGV[2])
+redis.call('ZADD', KEYS[2], seq, seq .. '|' .. now .. '|' .. ARGV[1])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -(tonumber(ARGV[3]) + 1))
+local cutoff = now - tonumber(ARGV[4])
+for i = 1, 10 do
+	local oldest = redis.call('ZRANGE', KEYS[2], 0, 0)
+	if #oldest == 0 then break end
+	local at = tonumber(string.match(oldest[1], '^%d+|(%d+)|'))
+	if at == nil or at >= cutoff then break end
+	redis.call('ZREMRANGEBYRANK', KEYS[2], 0, 0)
+end
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+return seq
+`)
+
+// wsAckScript moves a device's ack cursor forward, never back — acks can
+// arrive out of order from a client that batches them.
+var wsAckScript = redis.NewScript(`
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local seq = tonumber(ARGV[1])
+if seq > cur then
+	redis.call('SET', KEYS[1], seq, 'PX', ARGV[2])
+else
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// wsEvent is one logged event, as replay reads it back.
+type wsEvent struct {
+	seq   int64
+	at    time.Time
+	frame []byte
+}
+
+// wsWithSeq adds "seq" to a JSON object frame. Frames that are not objects
+// are returned unchanged.
+func wsWithSeq(frame []byte, seq int64) []byte {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(frame, &obj) != nil || obj == nil {
+		return frame
+	}
+	obj["seq"] = json.RawMessage(strconv.FormatInt(seq, 10))
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return frame
+	}
+	return out
+}
+
+// wsAppendEvent logs a frame for a user and returns its sequence number.
+// 0 means it could not be logged (no Redis, or Redis failed); the caller
+// still delivers it live, just without a number.
+func wsAppendEvent(username string, frame []byte) int64 {
+	if rdb == nil {
+		return 0
+	}
+	seq, err := wsAppendScript.Run(rctx, rdb,
+		[]string{wsEventSeqKey(username), wsEventLogKey(username)},
+		string(frame), time.Now().UnixMilli(), wsEventLogMax, wsEventRetention.Milliseconds(),
+	).Int64()
+	if err != nil {
+		log.Printf("ws event log: appending for %s: %v", username, err)
+		return 0
+	}
+	return seq
+}
+
+// parseWSEvent splits a log member back into its parts.
+func parseWSEvent(member string) (wsEvent, bool) {
+	parts := strings.SplitN(member, "|", 3)
+	if len(parts) != 3 {
+		return wsEvent{}, false
+	}
+	seq, err1 := strconv.ParseInt(parts[0], 10, 64)
+	ms, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return wsEvent{}, false
+	}
+	return wsEvent{seq: seq, at: time.UnixMilli(ms), frame: []byte(parts[2])}, true
+}
+
+// wsEventsSince reads the events after seq, oldest first, that are still
+// inside the retention window. gap reports that events after seq have
+// already been dropped, so what is returned is not everything missed.
+func wsEventsSince(username string, since int64) (events []wsEvent, head int64, gap bool, err error) {
+	// Head first: an event appended between the two reads then shows up
+	// past head, where it does no harm, instead of as a false gap.
+	head, err = rdb.Get(rctx, wsEventSeqKey(username)).Int64()
+	if err == redis.Nil {
+		head, err = 0, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	members, err := rdb.ZRangeByScore(rctx, wsEventLogKey(username), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(since, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if since > head {
+		// The client is ahead of the counter, which only happens if the
+		// log was lost (a Redis flush). Nothing here is what it thinks
+		// it has; it has to refetch and start again from head.
+		return nil, head, true, nil
+	}
+
+	cutoff := time.Now().Add(-wsEventRetention)
+	next := since + 1
+	for _, m := range members {
+		ev, ok := parseWSEvent(m)
+		if !ok || ev.at.Before(cutoff) {
+			continue
+		}
+		if ev.seq != next {
+			gap = true
+		}
+		next = ev.seq + 1
+		events = append(events, ev)
+	}
+	if next <= head {
+		gap = true // the newest ones are gone too (or were never logged)
+	}
+	return events, head, gap, nil
+}
+
+// wsAckedSeq is the last seq a device has acked, 0 if none.
+func wsAckedSeq(username, device string) int64 {
+	if rdb == nil || device == "" {
+		return 0
+	}
+	n, err := rdb.Get(rctx, wsAckKey(username, device)).Int64()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// wsRecordAck stores a device's ack.
+func wsRecordAck(username, device string, seq int64) {
+	if rdb == nil || device == "" || seq <= 0 {
+		return
+	}
+	if err := wsAckScript.Run(rctx, rdb, []string{wsAckKey(username, device)},
+		seq, wsEventRetention.Milliseconds()).Err(); err != nil {
+		log.Printf("ws ack for %s/%s: %v", username, device, err)
+	}
+}
+
+// wsClientFrame is what a client may send up the socket.
+type wsClientFrame struct {
+	Type string `json:"type"`
+	Seq  int64  `json:"seq"`
+	// typing
+	To    string `json:"to"`
+	State string `json:"state"`
+	// delivered
+	MessageIDs []string `json:"messageIds"`
+}
+
+// wsHandleClientFrame acts on one frame from the client: an ack, a typing
+// indicator, or a DM delivery report (see chat_receipts.go). Anything else
+// is ignored, as every client frame always was.
+func wsHandleClientFrame(username, userID, device string, raw []byte) {
+	var f wsClientFrame
+	if json.Unmarshal(raw, &f) != nil {
+		return
+	}
+	switch f.Type {
+	case "ack":
+		wsRecordAck(username, device, f.Seq)
+	case "typing":
+		chatTyping(userID, username, f.To, f.State)
+	case "delivered":
+		// Only the messages named: a frame with none usable is dropped.
+		if uid, err := strconv.Atoi(userID); err == nil {
+			if ids := parseMessageIDs(f.MessageIDs); len(ids) > 0 {
+				deliverChatReceipts(uid, ids)
+			}
+		}
+	}
+}
+
+// wsDeliverEvent logs a frame for a user and delivers it to every device they
+// have connected, here or on another replica. Returns true when it reached at
+// least one connection (or the relay, for one elsewhere) — false means "treat
+// as offline", exactly as wsDeliver.
+func wsDeliverEvent(username string, frame []byte) bool {
+	_, ok := wsDeliverLoggedEvent(username, frame)
+	return ok
+}
+
+// wsDeliverLoggedEvent is wsDeliverEvent that also returns the seq the frame
+// was logged under, 0 if it could not be.
+func wsDeliverLoggedEvent(username string, frame []byte) (int64, bool) {
+	seq := wsAppendEvent(username, frame)
+	if seq > 0 {
+		frame = wsWithSeq(frame, seq)
+	}
+	return seq, wsFanOut(username, seq, frame)
+}
+
+// wsMarkUnlogged records that a notification was stored for username without
+// being logged. It lasts as long as the stored list does, and goes with it
+// (ClearStoredNotifications).
+func wsMarkUnlogged(username string) {
+	if rdb == nil {
+		return
+	}
+	if err := rdb.Set(rctx, wsUnloggedKey(username), 1, 30*24*time.Hour).Err(); err != nil {
+		log.Printf("ws event log: marking %s unlogged: %v", username, err)
+	}
+}
+
+// wsReplay brings a connection that has just opened up to date: everything
+// after since (or the device's ack, if later) in order, then whatever
+// arrived live meanwhile, then live. A hello frame first tells the client
+// where the log stands.
+func wsReplay(c *wsClient, username, device string, since int64) {
+	if acked := wsAckedSeq(username, device); acked > since {
+		since = acked
+	}
+	hello := map[string]interface{}{
+		"type":         "hello",
+		"connectionId": c.id,
+	}
+	var events []wsEvent
+	last := since
+	complete := false
+	if rdb != nil {
+		evs, head, gap, err := wsEventsSince(username, since)
+		if err != nil {
+			log.Printf("ws replay for %s: %v", username, err)
+			gap = true
+		}
+		events = evs
+		hello["seq"] = head
+		hello["gap"] = gap
+		// After a flush or reset since is past the head, and a cursor left
+		// there would have writeEvent drop every new event numbered up to
+		// it. The client refetches and starts again from head.
+		if last > head {
+			last = head
+		}
+		complete = !gap
+	}
+	hello["replayed"] = len(events)
+	data, _ := json.Marshal(hello)
+	if err := c.writeMessage(websocket.TextMessage, data); err != nil {
+		c.finishReplay(last)
+		return
+	}
+	for _, ev := range events {
+		if err := c.writeMessage(websocket.TextMessage, wsWithSeq(ev.frame, ev.seq)); err != nil {
+			c.finishReplay(last)
+			return
+		}
+		last = ev.seq
+	}
+	c.finishReplay(last)
+	// A notification stored for an offline user went into the log first
+	// (deliverNotification), so after a replay with nothing missing this
+	// client has all of them — unless one could not be logged. Left in
+	// place, the stored list would only grow for a user whose apps all
+	// replay, and a pre-log build connecting later would be handed the
+	// lot again.
+	if complete && !wsHasUnlogged(username) {
+		ClearStoredNotifications(username)
+	}
+}
+
+// wsHasUnlogged reports whether username has a stored notification the log
+// doesn't. A failed read counts as yes: keeping the list is the safe side.
+func wsHasUnlogged(username string) bool {
+	n, err := rdb.Exists(rctx, wsUnloggedKey(username)).Result()
+	return err != nil || n > 0
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestWSEventLogNumbersAndReplaysInOrder(t *testing.T) {
+	resetRedis(t)
+
+	for i := 1; i <= 3; i++ {
+		if seq := wsAppendEvent("ev-alice", []byte(fmt.Sprintf(`{"type":"chat","n":%d}`, i))); seq != int64(i) {
+			t.Fatalf("event %d got seq %d", i, seq)
+		}
+	}
+	events, head, gap, err := wsEventsSince("ev-alice", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != 3 || gap {
+		t.Errorf("head=%d gap=%v, want 3 and no gap", head, gap)
+	}
+	if len(events) != 2 || events[0].seq != 2 || events[1].seq != 3 {
+		t.Fatalf("replay after 1 = %+v", events)
+	}
+	var f struct {
+		N   int   `json:"n"`
+		Seq int64 `json:"seq"`
+	}
+	if err := json.Unmarshal(wsWithSeq(events[0].frame, events[0].seq), &f); err != nil || f.N != 2 || f.Seq != 2 {
+		t.Errorf("replayed frame = %+v (%v)", f, err)
+	}
+}
+
+func TestWSEventLogIsBounded(t *testing.T) {
+	resetRedis(t)
+
+	for i := 0; i < wsEventLogMax+5; i++ {
+		wsAppendEvent("ev-bob", []byte(`{"type":"chat"}`))
+	}
+	if n, _ := rdb.ZCard(rctx, wsEventLogKey("ev-bob")).Result(); n != wsEventLogMax {
+		t.Errorf("log holds %d events, want %d", n, wsEventLogMax)
+	}
+	// Asking from the start: the first five are gone, and the client is told.
+	events, _, gap, _ := wsEventsSince("ev-bob", 0)
+	if !gap {
+		t.Error("trimmed events replayed without reporting a gap")
+	}
+	if len(events) != wsEventLogMax || events[0].seq != 6 {
+		t.Fatalf("replayed %d events, want %d starting at 6", len(events), wsEventLogMax)
+	}
+	// Asking from inside what is kept: no gap.
+	if _, _, gap, _ := wsEventsSince("ev-bob", 100); gap {
+		t.Error("gap reported for a cursor inside the retained log")
+	}
+
+	// Events past the retention window are not replayed even if present.
+	old := time.Now().Add(-wsEventRetention - time.Minute).UnixMilli()
+	rdb.ZAdd(rctx, wsEventLogKey("ev-carol"), redis.Z{Score: 1, Member: fmt.Sprintf(`1|%d|{"type":"chat"}`, old)})
+	rdb.Set(rctx, wsEventSeqKey("ev-carol"), 1, 0)
+	if events, _, gap, _ := wsEventsSince("ev-carol", 0); len(events) != 0 || !gap {
+		t.Errorf("expired event replayed: %d events, gap=%v", len(events), gap)
+	}
+}
+
+func TestWSCursorAheadOfLogIsAGap(t *testing.T) {
+	resetRedis(t)
+	wsAppendEvent("ev-dan", []byte(`{"type":"chat"}`))
+	if _, head, gap, _ := wsEventsSince("ev-dan", 40); !gap || head != 1 {
+		t.Errorf("cursor past head: head=%d gap=%v", head, gap)
+	}
+}
+
+func TestWSAcksOnlyMoveForward(t *testing.T) {
+	resetRedis(t)
+
+	wsHandleClientFrame("ev-erin", "", "phone", []byte(`{"type":"ack","seq":7}`))
+	wsHandleClientFrame("ev-erin", "", "phone", []byte(`{"type":"ack","seq":4}`))
+	wsHandleClientFrame("ev-erin", "", "phone", []byte(`{"type":"typing"}`))
+	if got := wsAckedSeq("ev-erin", "phone"); got != 7 {
+		t.Errorf("phone ack = %d, want 7", got)
+	}
+	if got := wsAckedSeq("ev-erin", "tablet"); got != 0 {
+		t.Errorf("tablet ack = %d, want 0 — acks are per device", got)
+	}
+}
+
+// A reconnect gets what it missed, then live, each event exactly once — even
+// the ones that arrive live while the replay is still being written.
+func TestWSReplayThenLiveWithoutDuplicates(t *testing.T) {
+	resetRedis(t)
+
+	conns := dialPair(t, "ev-finn", 1)
+	c := wsLocalConns("ev-finn")[0]
+	c.mu.Lock()
+	c.replaying = true
+	c.mu.Unlock()
+
+	// Two events while the connection is still replaying: logged, and held.
+	wsDeliverEvent("ev-finn", []byte(`{"type":"chat","n":1}`))
+	wsDeliverEvent("ev-finn", []byte(`{"type":"chat","n":2}`))
+	wsReplay(c, "ev-finn", "", 0)
+	// And one after.
+	wsDeliverEvent("ev-finn", []byte(`{"type":"chat","n":3}`))
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		conns[0].SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conns[0].ReadMessage()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		var f struct {
+			Type string `json:"type"`
+			Seq  int64  `json:"seq"`
+		}
+		json.Unmarshal(msg, &f)
+		got = append(got, fmt.Sprintf("%s:%d", f.Type, f.Seq))
+	}
+	want := []string{"hello:2", "chat:1", "chat:2", "chat:3"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("frames = %v, want %v", got, want)
+	}
+	conns[0].SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, msg, err := conns[0].ReadMessage(); err == nil {
+		t.Errorf("extra frame: %s", msg)
+	}
+}
+
+func TestWSReplayDrainsStoredNotifications(t *testing.T) {
+	resetRedis(t)
+
+	// Offline: logged, and stored for builds without the log.
+	deliverNotification("ev-gia", Notification{Type: "vote", Message: "someone voted for you"})
+	if _, found := GetStoredNotifications("ev-gia"); !found {
+		t.Fatal("notification for an offline user wasn't stored")
+	}
+
+	conns := dialPair(t, "ev-gia", 1)
+	c := wsLocalConns("ev-gia")[0]
+	c.mu.Lock()
+	c.replaying = true
+	c.mu.Unlock()
+	wsReplay(c, "ev-gia", "", 0)
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		conns[0].SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conns[0].ReadMessage()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		var f struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(msg, &f)
+		got = append(got, f.Type)
+	}
+	if fmt.Sprint(got) != "[hello vote]" {
+		t.Errorf("frames = %v, want [hello vote]", got)
+	}
+	if n, found := GetStoredNotifications("ev-gia"); found {
+		t.Errorf("%d stored notifications left after the replay delivered them", len(n))
+	}
+}
+
+// A client whose since is past a reset log still gets what comes next.
+func TestWSReplayPastHeadStillTakesLiveEvents(t *testing.T) {
+	resetRedis(t)
+	wsAppendEvent("ev-hal", []byte(`{"type":"chat"}`))
+
+	conns := dialPair(t, "ev-hal", 1)
+	c := wsLocalConns("ev-hal")[0]
+	c.mu.Lock()
+	c.replaying = true
+	c.mu.Unlock()
+	wsReplay(c, "ev-hal", "", 40)
+	wsDeliverEvent("ev-hal", []byte(`{"type":"chat"}`))
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		conns[0].SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conns[0].ReadMessage()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		var f struct {
+			Type string `json:"type"`
+			Seq  int64  `json:"seq"`
+			Gap  bool   `json:"gap"`
+		}
+		json.Unmarshal(msg, &f)
+		got = append(got, fmt.Sprintf("%s:%d:%v", f.Type, f.Seq, f.Gap))
+	}
+	if want := "[hello:1:true chat:2:false]"; fmt.Sprint(got) != want {
+		t.Errorf("frames = %v, want %s", got, want)
+	}
+}
+
+// The stored list survives a replay that can't vouch for all of it.
+func TestWSReplayKeepsStoredNotificationsItMayNotCover(t *testing.T) {
+	replay := func(username string) {
+		dialPair(t, username, 1)
+		c := wsLocalConns(username)[0]
+		c.mu.Lock()
+		c.replaying = true
+		c.mu.Unlock()
+		wsReplay(c, username, "", 0)
+	}
+
+	// A gap: the oldest events are gone from the log.
+	resetRedis(t)
+	deliverNotification("ev-ivy", Notification{Type: "vote"})
+	deliverNotification("ev-ivy", Notification{Type: "vote"})
+	rdb.ZRemRangeByScore(rctx, wsEventLogKey("ev-ivy"), "1", "1")
+	replay("ev-ivy")
+	if n, _ := GetStoredNotifications("ev-ivy"); len(n) != 2 {
+		t.Errorf("after a gap: %d stored notifications, want 2", len(n))
+	}
+
+	// One that never made it into the log.
+	resetRedis(t)
+	deliverNotification("ev-jo", Notification{Type: "vote"})
+	StoreNotificationInRedis("ev-jo", Notification{Type: "comment"})
+	wsMarkUnlogged("ev-jo")
+	replay("ev-jo")
+	if n, _ := GetStoredNotifications("ev-jo"); len(n) != 2 {
+		t.Errorf("with an unlogged one: %d stored notifications, want 2", len(n))
+	}
+
+	// The pre-log path delivers the lot, and takes the mark with it.
+	SendStoredNotifications("ev-jo", "")
+	if n, _ := rdb.Exists(rctx, wsUnloggedKey("ev-jo")).Result(); n != 0 {
+		t.Error("unlogged mark outlived the stored list")
+	}
+}